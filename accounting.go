@@ -0,0 +1,121 @@
+package sfu
+
+import (
+	"sync/atomic"
+
+	"github.com/inlivedev/sfu/pkg/rtppool"
+)
+
+// ResourceLimits limits the resources that a client can hold in the SFU, zero is unlimited
+type ResourceLimits struct {
+	// MaxQueuedPackets is the number of the packets that can wait to be written to the client across all subscribed tracks.
+	// The packets above the limit are dropped, so a subscriber with a stalled connection doesn't hold the packets of the
+	// published tracks. Each subscribed track also has its own write queue, see ClientOptions.WriteQueueSize.
+	MaxQueuedPackets int `json:"max_queued_packets"`
+}
+
+// ClientResources is the resources that attributable to a client
+type ClientResources struct {
+	// Goroutines is the number of the long running goroutines of the client and its published tracks
+	Goroutines int64 `json:"goroutines"`
+	// BufferedPackets is the number of the packets of the published tracks that taken from the packet pools,
+	// including the packets in the jitter buffers and the packets that queued to the subscribers
+	BufferedPackets int64 `json:"buffered_packets"`
+	// MemoryBytes is the estimated memory of the buffered packets
+	MemoryBytes int64 `json:"memory_bytes"`
+	// QueuedPackets is the number of the packets of the subscribed tracks that waiting to be written to the client
+	QueuedPackets int64 `json:"queued_packets"`
+	// DroppedPackets is the number of the subscribed packets that dropped because a write queue is full or MaxQueuedPackets
+	// is reached
+	DroppedPackets uint64           `json:"dropped_packets"`
+	Tracks         []TrackResources `json:"tracks"`
+}
+
+// TrackResources is the resources of a published track
+type TrackResources struct {
+	ID string `json:"id"`
+	// Goroutines is the number of the read loops, a simulcast track has a read loop for each layer
+	Goroutines          int64 `json:"goroutines"`
+	BufferedPackets     int64 `json:"buffered_packets"`
+	JitterBufferPackets int   `json:"jitter_buffer_packets"`
+	MemoryBytes         int64 `json:"memory_bytes"`
+}
+
+// clientResources counts the resources of a client that can't be derived from the tracks
+type clientResources struct {
+	goroutines atomic.Int64
+	queued     atomic.Int64
+	dropped    atomic.Uint64
+}
+
+// goroutine runs f in a goroutine that counted as the client goroutine until f returns
+func (r *clientResources) goroutine(f func()) {
+	r.goroutines.Add(1)
+
+	go func() {
+		defer r.goroutines.Add(-1)
+		f()
+	}()
+}
+
+// enqueue counts the packet as queued, it returns false if the limit is reached
+func (r *clientResources) enqueue(limit int) bool {
+	if queued := r.queued.Add(1); limit > 0 && queued > int64(limit) {
+		r.queued.Add(-1)
+		return false
+	}
+
+	return true
+}
+
+func (r *clientResources) dequeue() {
+	r.queued.Add(-1)
+}
+
+// pushClientTrack queues the packet to the subscribed track, see clientTrackQueue
+func pushClientTrack(track iClientTrack, p *rtppool.RetainablePacket, quality QualityLevel) {
+	track.writeQueue().enqueue(track, p, quality)
+}
+
+// Resources returns the goroutines, the buffered packets, and the estimated memory that attributable to the client
+func (c *Client) Resources() ClientResources {
+	resources := ClientResources{
+		Goroutines:     c.resources.goroutines.Load(),
+		QueuedPackets:  c.resources.queued.Load(),
+		DroppedPackets: c.resources.dropped.Load(),
+		Tracks:         make([]TrackResources, 0),
+	}
+
+	for _, track := range c.Tracks() {
+		trackResources := publishedTrackResources(track)
+
+		resources.Goroutines += trackResources.Goroutines
+		resources.BufferedPackets += trackResources.BufferedPackets
+		resources.MemoryBytes += trackResources.MemoryBytes
+		resources.Tracks = append(resources.Tracks, trackResources)
+	}
+
+	return resources
+}
+
+func publishedTrackResources(track ITrack) TrackResources {
+	resources := TrackResources{ID: track.ID()}
+
+	remoteTracks := publishedRemoteTracks(track)
+	resources.Goroutines = int64(len(remoteTracks))
+
+	for _, remoteTrack := range remoteTracks {
+		if remoteTrack.jitterBuffer != nil {
+			resources.JitterBufferPackets += remoteTrack.jitterBuffer.Len()
+		}
+	}
+
+	// the layers of a simulcast track share the packet pool
+	if len(remoteTracks) > 0 {
+		stats := remoteTracks[0].rtppool.Stats()
+		resources.BufferedPackets = stats.PacketsInUse
+		resources.MemoryBytes = stats.MemoryBytes()
+	}
+
+	return resources
+}
@@ -0,0 +1,56 @@
+package sfu
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientResourcesQueueLimit(t *testing.T) {
+	resources := &clientResources{}
+
+	require.True(t, resources.enqueue(2))
+	require.True(t, resources.enqueue(2))
+	require.False(t, resources.enqueue(2))
+	require.Equal(t, int64(2), resources.queued.Load())
+
+	resources.dequeue()
+	require.True(t, resources.enqueue(2))
+
+	// zero is unlimited
+	unlimited := &clientResources{}
+	for i := 0; i < 100; i++ {
+		require.True(t, unlimited.enqueue(0))
+	}
+}
+
+func TestClientResourcesGoroutines(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom("room", "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	client, err := testRoom.AddClient("peer", "peer", DefaultClientOptions())
+	require.NoError(t, err)
+
+	// the stats monitor, the bitrate controller loop, and the idle timeout
+	require.Eventually(t, func() bool {
+		return client.Resources().Goroutines >= 2
+	}, time.Second, 10*time.Millisecond)
+
+	require.Empty(t, client.Resources().Tracks)
+
+	require.NoError(t, client.End())
+
+	require.Eventually(t, func() bool {
+		return client.Resources().Goroutines == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,300 @@
+package sfu
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	AnalyticsClientJoined    = "client_joined"
+	AnalyticsClientLeft      = "client_left"
+	AnalyticsConnectionState = "connection_state"
+	AnalyticsQualitySwitch   = "quality_switch"
+	AnalyticsBitrate         = "bitrate"
+	AnalyticsFreeze          = "freeze"
+
+	// the oldest events of a client are dropped when the timeline is full, so a long session doesn't grow the memory
+	analyticsMaxEvents = 2000
+	// the default interval of the bitrate samples
+	defaultAnalyticsInterval = 5 * time.Second
+)
+
+// AnalyticsEvent is an entry in the timeline of a client, only the fields of the event type are set
+type AnalyticsEvent struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// TrackID is the subscribed track of the quality switch and the freeze
+	TrackID string `json:"track_id,omitempty"`
+	// State is the new peer connection state
+	State string `json:"state,omitempty"`
+	// From and To are the quality levels of the quality switch
+	From *QualityLevel `json:"from,omitempty"`
+	To   *QualityLevel `json:"to,omitempty"`
+	// The bitrates in bits per second and the highest fraction lost of the sent tracks in the bitrate sample
+	BitrateSent        uint32  `json:"bitrate_sent,omitempty"`
+	BitrateReceived    uint32  `json:"bitrate_received,omitempty"`
+	EstimatedBandwidth uint32  `json:"estimated_bandwidth,omitempty"`
+	FractionLost       float64 `json:"fraction_lost,omitempty"`
+	// Duration of the freeze, the time of the freeze event is when the video stopped
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// ClientTimeline is the analytics of a client from joined until left
+type ClientTimeline struct {
+	ClientID string           `json:"client_id"`
+	Name     string           `json:"name"`
+	JoinedAt time.Time        `json:"joined_at"`
+	LeftAt   *time.Time       `json:"left_at,omitempty"`
+	Events   []AnalyticsEvent `json:"events"`
+	// DroppedEvents is the number of the oldest events that dropped because the timeline is full
+	DroppedEvents int `json:"dropped_events"`
+}
+
+// RoomAnalytics is the timeline of all clients that joined the room, including the clients that already left
+type RoomAnalytics struct {
+	RoomID  string           `json:"room_id"`
+	Clients []ClientTimeline `json:"clients"`
+}
+
+type trackSample struct {
+	quality     QualityLevel
+	packetsSent uint32
+	frozenSince time.Time
+}
+
+type clientAnalytics struct {
+	timeline    ClientTimeline
+	tracks      map[string]*trackSample
+	lastSample  time.Time
+	lastBitrate time.Time
+}
+
+// analytics records the timeline of the clients, the tracks are sampled from the room stats loop
+type analytics struct {
+	mu       sync.Mutex
+	interval time.Duration
+	clients  map[string]*clientAnalytics
+	order    []string
+}
+
+func newAnalytics(interval time.Duration) *analytics {
+	return &analytics{
+		interval: interval,
+		clients:  make(map[string]*clientAnalytics),
+		order:    make([]string, 0),
+	}
+}
+
+// Analytics returns the timeline of quality switches, connection state changes, bitrate samples and video freezes
+// of every client in the room. It's kept after the client left, so it can be queried after the session ended.
+func (r *Room) Analytics() RoomAnalytics {
+	analytics := RoomAnalytics{
+		RoomID:  r.id,
+		Clients: make([]ClientTimeline, 0),
+	}
+
+	if r.analytics == nil {
+		return analytics
+	}
+
+	r.analytics.mu.Lock()
+	defer r.analytics.mu.Unlock()
+
+	for _, id := range r.analytics.order {
+		timeline := r.analytics.clients[id].timeline
+		timeline.Events = append([]AnalyticsEvent(nil), timeline.Events...)
+		analytics.Clients = append(analytics.Clients, timeline)
+	}
+
+	return analytics
+}
+
+// ExportAnalytics writes the room analytics as JSON
+func (r *Room) ExportAnalytics(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r.Analytics())
+}
+
+func (a *analytics) addClient(client *Client) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+
+	now := time.Now()
+
+	if _, ok := a.clients[client.ID()]; !ok {
+		a.order = append(a.order, client.ID())
+	}
+
+	// a client that joins again with the same ID starts a new timeline
+	a.clients[client.ID()] = &clientAnalytics{
+		timeline: ClientTimeline{
+			ClientID: client.ID(),
+			Name:     client.Name(),
+			JoinedAt: now,
+			Events:   []AnalyticsEvent{{Time: now, Type: AnalyticsClientJoined}},
+		},
+		tracks: make(map[string]*trackSample),
+	}
+
+	a.mu.Unlock()
+
+	client.OnConnectionStateChanged(func(state webrtc.PeerConnectionState) {
+		a.add(client.ID(), AnalyticsEvent{Time: time.Now(), Type: AnalyticsConnectionState, State: state.String()})
+	})
+}
+
+func (a *analytics) removeClient(clientID string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ca, ok := a.clients[clientID]
+	if !ok || ca.timeline.LeftAt != nil {
+		return
+	}
+
+	now := time.Now()
+	ca.timeline.LeftAt = &now
+	ca.add(AnalyticsEvent{Time: now, Type: AnalyticsClientLeft})
+}
+
+func (a *analytics) add(clientID string, event AnalyticsEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if ca, ok := a.clients[clientID]; ok {
+		ca.add(event)
+	}
+}
+
+func (ca *clientAnalytics) add(event AnalyticsEvent) {
+	if len(ca.timeline.Events) >= analyticsMaxEvents {
+		ca.timeline.Events = ca.timeline.Events[1:]
+		ca.timeline.DroppedEvents++
+	}
+
+	ca.timeline.Events = append(ca.timeline.Events, event)
+}
+
+// sample records the quality switches and the freezes of the subscribed tracks, and the bitrate every interval
+func (a *analytics) sample(clients map[string]*Client) {
+	if a == nil {
+		return
+	}
+
+	now := time.Now()
+
+	for id, client := range clients {
+		a.mu.Lock()
+		ca, ok := a.clients[id]
+		a.mu.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		events := client.sampleAnalytics(ca, now, a.interval)
+
+		a.mu.Lock()
+		for _, event := range events {
+			ca.add(event)
+		}
+		a.mu.Unlock()
+	}
+}
+
+// sampleAnalytics compares the subscribed tracks with the last sample, the track samples are only accessed from the
+// room stats loop so they're not locked
+func (c *Client) sampleAnalytics(ca *clientAnalytics, now time.Time, interval time.Duration) []AnalyticsEvent {
+	events := make([]AnalyticsEvent, 0)
+	fractionLost := float64(0)
+	tracks := c.ClientTracks()
+
+	for id, track := range tracks {
+		stat, err := c.stats.GetSender(id)
+		if err != nil {
+			continue
+		}
+
+		if lost := stat.RemoteInboundRTPStreamStats.FractionLost; lost > fractionLost {
+			fractionLost = lost
+		}
+
+		if track.Kind() != webrtc.RTPCodecTypeVideo {
+			continue
+		}
+
+		quality := track.Quality()
+		packetsSent := uint32(stat.OutboundRTPStreamStats.PacketsSent)
+
+		last, ok := ca.tracks[id]
+		if !ok {
+			ca.tracks[id] = &trackSample{quality: quality, packetsSent: packetsSent}
+			continue
+		}
+
+		if quality != last.quality {
+			from, to := last.quality, quality
+			events = append(events, AnalyticsEvent{Time: now, Type: AnalyticsQualitySwitch, TrackID: id, From: &from, To: &to})
+		}
+
+		// the video is frozen if the track should be forwarded but no packet is sent since the last sample
+		expected := track.ReceiveBitrate() > 0 && track.MaxQuality() != QualityNone && !c.isTrackPaused(id)
+
+		switch {
+		case packetsSent != last.packetsSent && !last.frozenSince.IsZero():
+			events = append(events, AnalyticsEvent{Time: last.frozenSince, Type: AnalyticsFreeze, TrackID: id, Duration: now.Sub(last.frozenSince)})
+			last.frozenSince = time.Time{}
+		case packetsSent == last.packetsSent && expected && last.frozenSince.IsZero():
+			last.frozenSince = ca.lastSample
+		case !expected:
+			// the track is paused or the publisher stopped sending, it's not a freeze
+			last.frozenSince = time.Time{}
+		}
+
+		last.quality = quality
+		last.packetsSent = packetsSent
+	}
+
+	for id := range ca.tracks {
+		if _, ok := tracks[id]; !ok {
+			delete(ca.tracks, id)
+		}
+	}
+
+	if ca.lastBitrate.IsZero() {
+		ca.lastBitrate = now
+	} else if now.Sub(ca.lastBitrate) >= interval {
+		ca.lastBitrate = now
+
+		receivedBitrate := uint32(0)
+
+		c.stats.receiverMu.RLock()
+		for _, bitrate := range c.stats.receiverBitrates {
+			receivedBitrate += bitrate
+		}
+		c.stats.receiverMu.RUnlock()
+
+		events = append(events, AnalyticsEvent{
+			Time:               now,
+			Type:               AnalyticsBitrate,
+			BitrateSent:        c.bitrateController.totalSentBitrates(),
+			BitrateReceived:    receivedBitrate,
+			EstimatedBandwidth: c.GetEstimatedBandwidth(),
+			FractionLost:       fractionLost,
+		})
+	}
+
+	ca.lastSample = now
+
+	return events
+}
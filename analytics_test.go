@@ -0,0 +1,83 @@
+package sfu
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoomAnalytics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom("room", "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	client, err := testRoom.AddClient("peer", "peer", DefaultClientOptions())
+	require.NoError(t, err)
+
+	require.NoError(t, client.End())
+
+	// the timeline is kept after the client left
+	require.Eventually(t, func() bool {
+		analytics := testRoom.Analytics()
+		return len(analytics.Clients) == 1 && analytics.Clients[0].LeftAt != nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	timeline := testRoom.Analytics().Clients[0]
+	require.Equal(t, "peer", timeline.ClientID)
+	require.Equal(t, AnalyticsClientJoined, timeline.Events[0].Type)
+	require.Equal(t, AnalyticsClientLeft, timeline.Events[len(timeline.Events)-1].Type)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, testRoom.ExportAnalytics(buf))
+
+	exported := RoomAnalytics{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &exported))
+	require.Equal(t, "room", exported.RoomID)
+	require.Len(t, exported.Clients, 1)
+}
+
+func TestRoomAnalyticsDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	opts := DefaultRoomOptions()
+	disabled := time.Duration(0)
+	opts.AnalyticsInterval = &disabled
+
+	testRoom, err := roomManager.NewRoom("room", "test-room", RoomTypeLocal, opts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	client, err := testRoom.AddClient("peer", "peer", DefaultClientOptions())
+	require.NoError(t, err)
+
+	require.Empty(t, testRoom.Analytics().Clients)
+	require.NoError(t, client.End())
+}
+
+func TestAnalyticsMaxEvents(t *testing.T) {
+	ca := &clientAnalytics{}
+
+	for i := 0; i < analyticsMaxEvents+10; i++ {
+		ca.add(AnalyticsEvent{Time: time.Unix(int64(i), 0), Type: AnalyticsBitrate})
+	}
+
+	require.Len(t, ca.timeline.Events, analyticsMaxEvents)
+	require.Equal(t, 10, ca.timeline.DroppedEvents)
+	require.Equal(t, time.Unix(10, 0), ca.timeline.Events[0].Time)
+}
@@ -0,0 +1,335 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+var ErrAudioSinkClosed = errors.New("audiosink: audio sink is closed")
+
+// number of frames can be queued before the frames dropped when the sink is slow
+const audioSinkBufferSize = 256
+
+// the longest Opus packet is 120ms, 5760 samples per channel at 48kHz
+const audioSinkMaxSamples = 5760
+
+// AudioFrame is an Opus frame of a published audio track that passed to an AudioSink
+type AudioFrame struct {
+	// ClientID and ClientName are the publisher of the track, to label the speaker in the transcript
+	ClientID   string
+	ClientName string
+	TrackID    string
+	// Time is when the frame is received by the SFU
+	Time time.Time
+	// Timestamp is the RTP timestamp in the 48kHz clock, a bigger gap than the frame duration from the previous frame
+	// is the lost packets or the silence when the publisher enables DTX
+	Timestamp uint32
+	// Opus is the encoded frame, the primary encoding of a RED packet. It's owned by the sink
+	Opus []byte
+	// PCM is the decoded interleaved 16-bit samples, only set when AudioSinkOptions.NewDecoder is set
+	PCM        []int16
+	SampleRate int
+	Channels   int
+}
+
+// AudioSink receives the audio frames of a published track, like a speech-to-text stream. The frames are passed from
+// a goroutine of the track, the frames are dropped when WriteAudio is slower than the track.
+type AudioSink interface {
+	// WriteAudio is called for every frame of the track, return an error to stop passing the frames to the sink
+	WriteAudio(frame AudioFrame) error
+	// Close is called once the track is ended, WriteAudio returns an error, or the sink is removed
+	Close() error
+}
+
+// OpusDecoder decodes an Opus frame into the interleaved 16-bit samples and returns the number of samples per channel.
+// It matches the decoder of github.com/hraban/opus, the SFU never decodes the media so the decoder is provided by the application.
+type OpusDecoder interface {
+	Decode(data []byte, pcm []int16) (int, error)
+}
+
+// AudioSinkFactory creates the sink of a published audio track, return a nil sink to skip the track
+type AudioSinkFactory func(track ITrack, publisher *Client) (AudioSink, error)
+
+type AudioSinkOptions struct {
+	// NewDecoder creates the Opus decoder of each track to pass the PCM samples. Default is nil means only the Opus frames are passed
+	NewDecoder func(sampleRate, channels int) (OpusDecoder, error)
+	// SampleRate of the decoded samples, one of 8000, 12000, 16000, 24000, or 48000. Default is 48000
+	SampleRate int
+	// Channels of the decoded samples, 1 or 2. Default is 1
+	Channels int
+}
+
+func DefaultAudioSinkOptions() AudioSinkOptions {
+	return AudioSinkOptions{
+		SampleRate: 48000,
+		Channels:   1,
+	}
+}
+
+// AudioSinkHook passes the audio tracks of a room to the sinks that created by the factory, including the tracks that
+// published after it's added. Only the Opus tracks are passed.
+type AudioSinkHook struct {
+	mu      sync.Mutex
+	context context.Context
+	cancel  context.CancelFunc
+	room    *Room
+	factory AudioSinkFactory
+	options AudioSinkOptions
+	tracks  map[string]*audioSinkTrack
+	log     logging.LeveledLogger
+}
+
+type audioSinkTrack struct {
+	context   context.Context
+	cancel    context.CancelFunc
+	track     ITrack
+	publisher *Client
+	sink      AudioSink
+	decoder   OpusDecoder
+	frames    chan AudioFrame
+	done      chan bool
+	closeOnce sync.Once
+	log       logging.LeveledLogger
+}
+
+// AddAudioSink creates a sink with the factory for every published audio track in the room, and for the tracks that
+// published later until the hook is closed.
+func (r *Room) AddAudioSink(factory AudioSinkFactory, opts AudioSinkOptions) (*AudioSinkHook, error) {
+	if r.options.E2EE {
+		return nil, ErrE2EENotSupported
+	}
+
+	if opts.SampleRate == 0 {
+		opts.SampleRate = 48000
+	}
+
+	if opts.Channels == 0 {
+		opts.Channels = 1
+	}
+
+	ctx, cancel := context.WithCancel(r.context)
+
+	hook := &AudioSinkHook{
+		context: ctx,
+		cancel:  cancel,
+		room:    r,
+		factory: factory,
+		options: opts,
+		tracks:  make(map[string]*audioSinkTrack),
+		log:     r.sfu.log,
+	}
+
+	r.mu.Lock()
+	r.audioSinks = append(r.audioSinks, hook)
+	r.mu.Unlock()
+
+	for _, client := range r.sfu.GetClients() {
+		hook.addTracks(client.Tracks())
+	}
+
+	return hook, nil
+}
+
+// Close closes the sinks of all tracks and stops adding the new tracks
+func (h *AudioSinkHook) Close() error {
+	h.mu.Lock()
+
+	if h.context.Err() != nil {
+		h.mu.Unlock()
+		return ErrAudioSinkClosed
+	}
+
+	h.cancel()
+
+	tracks := make([]*audioSinkTrack, 0, len(h.tracks))
+	for key, st := range h.tracks {
+		tracks = append(tracks, st)
+		delete(h.tracks, key)
+	}
+	h.mu.Unlock()
+
+	h.room.mu.Lock()
+	for i, hook := range h.room.audioSinks {
+		if hook == h {
+			h.room.audioSinks = append(h.room.audioSinks[:i], h.room.audioSinks[i+1:]...)
+			break
+		}
+	}
+	h.room.mu.Unlock()
+
+	for _, st := range tracks {
+		st.stop()
+	}
+
+	return nil
+}
+
+func (h *AudioSinkHook) addTracks(tracks []ITrack) {
+	for _, track := range tracks {
+		if err := h.addTrack(track); err != nil {
+			h.log.Warnf("audiosink: failed to add track %s: %s", track.ID(), err.Error())
+		}
+	}
+}
+
+func (h *AudioSinkHook) addTrack(track ITrack) error {
+	mimeType := strings.ToLower(track.MimeType())
+	if track.Kind() != webrtc.RTPCodecTypeAudio || track.IsE2EE() ||
+		(mimeType != strings.ToLower(webrtc.MimeTypeOpus) && mimeType != "audio/red") {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.context.Err() != nil {
+		return ErrAudioSinkClosed
+	}
+
+	key := track.ClientID() + "/" + track.ID()
+	if _, ok := h.tracks[key]; ok {
+		return nil
+	}
+
+	publisher, err := h.room.sfu.GetClient(track.ClientID())
+	if err != nil {
+		return err
+	}
+
+	sink, err := h.factory(track, publisher)
+	if err != nil || sink == nil {
+		return err
+	}
+
+	var decoder OpusDecoder
+	if h.options.NewDecoder != nil {
+		if decoder, err = h.options.NewDecoder(h.options.SampleRate, h.options.Channels); err != nil {
+			_ = sink.Close()
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(h.context)
+
+	st := &audioSinkTrack{
+		context:   ctx,
+		cancel:    cancel,
+		track:     track,
+		publisher: publisher,
+		sink:      sink,
+		decoder:   decoder,
+		frames:    make(chan AudioFrame, audioSinkBufferSize),
+		done:      make(chan bool),
+		log:       h.log,
+	}
+
+	h.tracks[key] = st
+
+	track.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, _ QualityLevel) {
+		st.push(p, mimeType)
+	})
+
+	track.OnEnded(func() {
+		h.mu.Lock()
+		if h.tracks[key] == st {
+			delete(h.tracks, key)
+		}
+		h.mu.Unlock()
+
+		st.stop()
+	})
+
+	go st.run(h.options)
+
+	return nil
+}
+
+// push is called from the track read loop, the payload is copied because the packet will be returned to the pool
+func (st *audioSinkTrack) push(p *rtp.Packet, mimeType string) {
+	if st.context.Err() != nil {
+		return
+	}
+
+	payload := p.Payload
+	if p.PayloadType == 63 || mimeType == "audio/red" {
+		primary, err := extractPrimaryEncodingForRED(payload)
+		if err != nil {
+			return
+		}
+
+		payload = primary
+	}
+
+	if len(payload) == 0 {
+		return
+	}
+
+	frame := AudioFrame{
+		ClientID:   st.publisher.ID(),
+		ClientName: st.publisher.Name(),
+		TrackID:    st.track.ID(),
+		Time:       time.Now(),
+		Timestamp:  p.Timestamp,
+		Opus:       append([]byte(nil), payload...),
+	}
+
+	select {
+	case st.frames <- frame:
+	default:
+		st.log.Warnf("audiosink: frame buffer is full, dropping frame of track %s", st.track.ID())
+	}
+}
+
+func (st *audioSinkTrack) run(opts AudioSinkOptions) {
+	defer close(st.done)
+
+	for {
+		select {
+		case <-st.context.Done():
+			return
+		case frame := <-st.frames:
+			if st.decoder != nil {
+				pcm := make([]int16, audioSinkMaxSamples*opts.Channels)
+
+				n, err := st.decoder.Decode(frame.Opus, pcm)
+				if err != nil {
+					st.log.Warnf("audiosink: failed to decode frame of track %s: %s", st.track.ID(), err.Error())
+					continue
+				}
+
+				frame.PCM = pcm[:n*opts.Channels]
+				frame.SampleRate = opts.SampleRate
+				frame.Channels = opts.Channels
+			}
+
+			if err := st.sink.WriteAudio(frame); err != nil {
+				st.log.Errorf("audiosink: failed to write track %s: %s", st.track.ID(), err.Error())
+
+				// the sink is closed once the loop is returned
+				st.cancel()
+				go st.stop()
+
+				return
+			}
+		}
+	}
+}
+
+func (st *audioSinkTrack) stop() {
+	st.closeOnce.Do(func() {
+		st.cancel()
+		<-st.done
+
+		if err := st.sink.Close(); err != nil {
+			st.log.Warnf("audiosink: failed to close sink of track %s: %s", st.track.ID(), err.Error())
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package sfu
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testAudioSink struct {
+	mu     sync.Mutex
+	frames []AudioFrame
+	closed bool
+}
+
+func (s *testAudioSink) WriteAudio(frame AudioFrame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.frames = append(s.frames, frame)
+
+	return nil
+}
+
+func (s *testAudioSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	return nil
+}
+
+// testOpusDecoder returns the samples of a 20ms frame
+type testOpusDecoder struct {
+	samples int
+}
+
+func (d *testOpusDecoder) Decode(_ []byte, pcm []int16) (int, error) {
+	return d.samples, nil
+}
+
+func TestRoomAudioSink(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-audio-sink", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	sink := &testAudioSink{}
+
+	opts := DefaultAudioSinkOptions()
+	opts.SampleRate = 16000
+	opts.NewDecoder = func(sampleRate, channels int) (OpusDecoder, error) {
+		return &testOpusDecoder{samples: sampleRate / 50}, nil
+	}
+
+	hook, err := testRoom.AddAudioSink(func(track ITrack, publisher *Client) (AudioSink, error) {
+		return sink, nil
+	}, opts)
+	require.NoError(t, err)
+
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	require.Eventually(t, func() bool {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+
+		return len(sink.frames) > 10
+	}, 20*time.Second, 100*time.Millisecond)
+
+	require.NoError(t, hook.Close())
+	require.ErrorIs(t, hook.Close(), ErrAudioSinkClosed)
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	require.True(t, sink.closed)
+
+	frame := sink.frames[0]
+	require.Equal(t, publisher.ID(), frame.ClientID)
+	require.Equal(t, publisher.Name(), frame.ClientName)
+	require.NotEmpty(t, frame.Opus)
+	require.Len(t, frame.PCM, 320)
+	require.Equal(t, 16000, frame.SampleRate)
+	require.Equal(t, 1, frame.Channels)
+	require.NotEqual(t, sink.frames[1].Timestamp, frame.Timestamp)
+
+	_ = testRoom.StopClient(publisher.ID())
+}
@@ -0,0 +1,164 @@
+package sfu
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the video track is paused once it has no subscriber for this duration, so a track that published right before
+// its subscribers are added or a subscriber that resubscribes doesn't pause the publisher
+const autoPauseDelay = 2 * time.Second
+
+type publishPaused struct {
+	TrackID string `json:"track_id"`
+	Paused  bool   `json:"paused"`
+}
+
+type internalDataPublishPaused struct {
+	Type string        `json:"type"`
+	Data publishPaused `json:"data"`
+}
+
+// trackAutoPause stops forwarding a published video track while it has no subscriber, see RoomOptions.PauseUnsubscribedVideo.
+// The packets of a paused track are dropped right after they're read without copying them for the subscribers.
+type trackAutoPause struct {
+	ctx      context.Context
+	mu       sync.Mutex
+	paused   atomic.Bool
+	timer    *time.Timer
+	isNeeded func() bool
+	onPause  func(paused bool)
+}
+
+func newTrackAutoPause(ctx context.Context, isNeeded func() bool, onPause func(paused bool)) *trackAutoPause {
+	return &trackAutoPause{
+		ctx:      ctx,
+		isNeeded: isNeeded,
+		onPause:  onPause,
+	}
+}
+
+// isPaused returns true if the track is paused, it's always false when the auto pause is disabled
+func (a *trackAutoPause) isPaused() bool {
+	if a == nil {
+		return false
+	}
+
+	return a.paused.Load()
+}
+
+// update resumes the track right away once it's needed, or pauses it after the delay once it's not needed
+func (a *trackAutoPause) update() {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.isNeeded() {
+		if a.timer != nil {
+			a.timer.Stop()
+			a.timer = nil
+		}
+
+		if a.paused.CompareAndSwap(true, false) {
+			a.onPause(false)
+		}
+
+		return
+	}
+
+	if a.paused.Load() || a.timer != nil {
+		return
+	}
+
+	a.timer = time.AfterFunc(autoPauseDelay, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		a.timer = nil
+
+		if a.ctx.Err() != nil || a.isNeeded() {
+			return
+		}
+
+		if a.paused.CompareAndSwap(false, true) {
+			a.onPause(true)
+		}
+	})
+}
+
+// enableAutoPause pauses the video track while it has no subscriber and no OnRead callback, the publisher is notified
+// with the publish_paused internal message and a keyframe is requested when the track is resumed
+func (t *Track) enableAutoPause() {
+	t.base.autoPause = newTrackAutoPause(t.context, func() bool {
+		t.mu.Lock()
+		hasReaders := len(t.onReadCallbacks) > 0
+		t.mu.Unlock()
+
+		return hasReaders || t.base.clientTracks.Length() > 0
+	}, func(paused bool) {
+		t.base.onAutoPause(paused)
+
+		if !paused {
+			t.remoteTrack.SendPLI()
+		}
+	})
+
+	t.base.clientTracks.OnChanged(t.base.autoPause.update)
+	t.base.autoPause.update()
+}
+
+// enableAutoPause pauses all layers of the simulcast track while it has no subscriber and no OnRead callback,
+// see Track.enableAutoPause
+func (t *SimulcastTrack) enableAutoPause() {
+	t.base.autoPause = newTrackAutoPause(t.context, func() bool {
+		t.mu.RLock()
+		hasReaders := len(t.onReadCallbacks) > 0
+		t.mu.RUnlock()
+
+		return hasReaders || t.base.clientTracks.Length() > 0
+	}, func(paused bool) {
+		t.base.onAutoPause(paused)
+
+		if !paused {
+			t.sendPLI()
+		}
+	})
+
+	t.base.clientTracks.OnChanged(t.base.autoPause.update)
+	t.base.autoPause.update()
+}
+
+func (t *baseTrack) onAutoPause(paused bool) {
+	if paused {
+		t.client.log.Infof("track: pause track %s, it has no subscriber", t.id)
+	} else {
+		t.client.log.Infof("track: resume track %s, it's subscribed", t.id)
+	}
+
+	data, err := json.Marshal(internalDataPublishPaused{
+		Type: messageTypePublishPaused,
+		Data: publishPaused{TrackID: t.id, Paused: paused},
+	})
+	if err != nil {
+		t.client.log.Errorf("track: error marshal publish paused ", err)
+		return
+	}
+
+	t.publisher().sendInternalMessage(data)
+}
+
+// IsAutoPaused returns true if the track is not forwarded because it has no subscriber, see RoomOptions.PauseUnsubscribedVideo
+func (t *Track) IsAutoPaused() bool {
+	return t.base.autoPause.isPaused()
+}
+
+// IsAutoPaused returns true if the track is not forwarded because it has no subscriber, see RoomOptions.PauseUnsubscribedVideo
+func (t *SimulcastTrack) IsAutoPaused() bool {
+	return t.base.autoPause.isPaused()
+}
@@ -0,0 +1,56 @@
+package sfu
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackAutoPause(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	needed := &atomic.Bool{}
+	events := make(chan bool, 2)
+
+	pause := newTrackAutoPause(ctx, needed.Load, func(paused bool) {
+		events <- paused
+	})
+
+	// not paused before the delay
+	pause.update()
+	require.False(t, pause.isPaused())
+
+	select {
+	case paused := <-events:
+		require.True(t, paused)
+	case <-time.After(2 * autoPauseDelay):
+		t.Fatal("timeout waiting for the track to be paused")
+	}
+
+	require.True(t, pause.isPaused())
+
+	// resumed right away once it's subscribed
+	needed.Store(true)
+	pause.update()
+	require.False(t, pause.isPaused())
+	require.False(t, <-events)
+
+	// a subscriber that resubscribes before the delay doesn't pause the track
+	needed.Store(false)
+	pause.update()
+	needed.Store(true)
+	pause.update()
+
+	time.Sleep(autoPauseDelay + 100*time.Millisecond)
+	require.False(t, pause.isPaused())
+	require.Empty(t, events)
+
+	// disabled
+	var disabled *trackAutoPause
+	disabled.update()
+	require.False(t, disabled.isPaused())
+}
@@ -0,0 +1,201 @@
+package sfu
+
+import (
+	"errors"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/interceptors/avsync"
+	"github.com/pion/webrtc/v4"
+)
+
+var ErrAVSyncDisabled = errors.New("client: error A/V sync is disabled")
+
+// AVSyncStats is the audio/video sync of a stream that sent to the client. The delay is the smoothed time from the capture
+// of the packet on the publisher to the sending of the packet to the client, it includes the network delay of the publisher,
+// the jitter buffer and the forwarding. The client plays the audio and the video in sync as long as the drift is absorbed by
+// its own jitter buffer, a growing drift means one of the tracks is delayed in the SFU.
+type AVSyncStats struct {
+	StreamID string `json:"stream_id"`
+	// in milliseconds
+	AudioDelayMS float64 `json:"audio_delay_ms"`
+	// in milliseconds
+	VideoDelayMS float64 `json:"video_delay_ms"`
+	// the video delay minus the audio delay in milliseconds, positive means the video is sent later than the audio
+	DriftMS float64 `json:"drift_ms"`
+}
+
+// captureClock returns the capture clock of the published track, the clock of the track received by the peer connection
+// follows the RTCP sender reports of the publisher
+func (c *Client) captureClock(track IRemoteTrack) *avsync.CaptureClock {
+	if _, ok := track.(*RelayTrack); ok || c == nil || c.avSync == nil {
+		return avsync.NewCaptureClock(track.Codec().ClockRate)
+	}
+
+	return c.avSync.CaptureClock(uint32(track.SSRC()), track.Codec().ClockRate)
+}
+
+// setCaptureTime sets the capture time of the packet that about to be written to the local track. sourceTS is the RTP
+// timestamp of the packet as published, ts is the timestamp after it's rewritten for the client.
+func (c *Client) setCaptureTime(localTrack *webrtc.TrackLocalStaticRTP, clock *avsync.CaptureClock, sourceTS, ts uint32) {
+	if c.avSync == nil {
+		return
+	}
+
+	ssrc, ok := c.senderSSRCs.Load(localTrack.ID())
+	if !ok {
+		return
+	}
+
+	capture, ok := clock.Capture(sourceTS)
+	if !ok {
+		return
+	}
+
+	c.avSync.SetCaptureTime(ssrc.(uint32), ts, capture)
+}
+
+// AVSyncStats returns the audio/video sync of the streams that sent to the client, only the streams that have both the
+// audio and the video tracks are returned. It's empty if the client is created with EnableAVSync false.
+func (c *Client) AVSyncStats() []AVSyncStats {
+	if c.avSync == nil {
+		return nil
+	}
+
+	type delays struct {
+		audio, video       time.Duration
+		hasAudio, hasVideo bool
+	}
+
+	streams := make(map[string]*delays)
+
+	c.muTracks.Lock()
+	tracks := make([]iClientTrack, 0, len(c.clientTracks))
+	for _, track := range c.clientTracks {
+		tracks = append(tracks, track)
+	}
+	c.muTracks.Unlock()
+
+	for _, track := range tracks {
+		ssrc, ok := c.senderSSRCs.Load(track.LocalTrack().ID())
+		if !ok {
+			continue
+		}
+
+		delay, ok := c.avSync.Delay(ssrc.(uint32))
+		if !ok {
+			continue
+		}
+
+		stream, ok := streams[track.StreamID()]
+		if !ok {
+			stream = &delays{}
+			streams[track.StreamID()] = stream
+		}
+
+		if track.Kind() == webrtc.RTPCodecTypeAudio {
+			stream.audio, stream.hasAudio = delay, true
+		} else if !stream.hasVideo || !track.IsScreen() {
+			// a stream with the camera and the screen share is synced to the camera
+			stream.video, stream.hasVideo = delay, true
+		}
+	}
+
+	stats := make([]AVSyncStats, 0, len(streams))
+
+	for streamID, stream := range streams {
+		if !stream.hasAudio || !stream.hasVideo {
+			continue
+		}
+
+		stats = append(stats, AVSyncStats{
+			StreamID:     streamID,
+			AudioDelayMS: float64(stream.audio) / float64(time.Millisecond),
+			VideoDelayMS: float64(stream.video) / float64(time.Millisecond),
+			DriftMS:      float64(stream.video-stream.audio) / float64(time.Millisecond),
+		})
+	}
+
+	return stats
+}
+
+// SetSenderReportInterval sets the interval of the RTCP sender reports of a subscribed track, the shorter interval lets
+// the client sync the track faster after it's subscribed. The interval is rounded up to 100ms, 0 uses the default 1 second.
+func (c *Client) SetSenderReportInterval(trackID string, interval time.Duration) error {
+	if c.avSync == nil {
+		return ErrAVSyncDisabled
+	}
+
+	ssrc, ok := c.senderSSRC(trackID)
+	if !ok {
+		return ErrTrackIsNotExists
+	}
+
+	c.avSync.SetReportInterval(ssrc, interval)
+
+	return nil
+}
+
+// SyncInfo returns the mapping of the RTP timestamp of a subscribed track to the wall clock, it's the same mapping that
+// sent to the client in the RTCP sender reports. Use it to align the tracks that forwarded to an external recorder or relay.
+func (c *Client) SyncInfo(trackID string) (avsync.SyncInfo, error) {
+	if c.avSync == nil {
+		return avsync.SyncInfo{}, ErrAVSyncDisabled
+	}
+
+	ssrc, ok := c.senderSSRC(trackID)
+	if !ok {
+		return avsync.SyncInfo{}, ErrTrackIsNotExists
+	}
+
+	info, ok := c.avSync.SyncInfo(ssrc)
+	if !ok {
+		return avsync.SyncInfo{}, ErrNotFound
+	}
+
+	return info, nil
+}
+
+func (c *Client) senderSSRC(trackID string) (uint32, bool) {
+	c.muTracks.Lock()
+	track, ok := c.clientTracks[trackID]
+	c.muTracks.Unlock()
+
+	if !ok {
+		return 0, false
+	}
+
+	ssrc, ok := c.senderSSRCs.Load(track.LocalTrack().ID())
+	if !ok {
+		return 0, false
+	}
+
+	return ssrc.(uint32), true
+}
+
+// SyncInfo returns the mapping of the RTP timestamp of the published track to the capture time in the SFU clock, false if
+// no packet is received yet. The tracks of the same publisher are aligned when their timestamps map to the same time.
+func (t *Track) SyncInfo() (avsync.SyncInfo, bool) {
+	return t.remoteTrack.syncInfo()
+}
+
+// SyncInfo returns the mapping of the RTP timestamp of the simulcast layer to the capture time in the SFU clock, false
+// if the layer is not received yet
+func (t *SimulcastTrack) SyncInfo(quality QualityLevel) (avsync.SyncInfo, bool) {
+	remoteTrack := t.GetRemoteTrack(quality)
+	if remoteTrack == nil {
+		return avsync.SyncInfo{}, false
+	}
+
+	return remoteTrack.syncInfo()
+}
+
+func (t *remoteTrack) syncInfo() (avsync.SyncInfo, bool) {
+	info, ok := t.captureClock.SyncInfo()
+	if !ok {
+		return avsync.SyncInfo{}, false
+	}
+
+	info.SSRC = uint32(t.track.SSRC())
+
+	return info, true
+}
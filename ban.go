@@ -0,0 +1,141 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrClientBanned = errors.New("room: error the client is banned")
+
+// the maximum time to wait for the kicked message is sent before the peer connection is closed
+const kickMessageTimeout = time.Second
+
+// BanList keeps the banned client IDs of the rooms, it's checked when a client is added to a room.
+// Implement it to keep the bans in a database so they're not lost when the room or the server is restarted.
+type BanList interface {
+	Ban(roomID, clientID, reason string) error
+	Unban(roomID, clientID string) error
+	IsBanned(roomID, clientID string) (bool, error)
+}
+
+type memoryBanList struct {
+	mu   sync.RWMutex
+	bans map[string]map[string]string
+}
+
+// NewMemoryBanList returns a BanList that keeps the bans in the memory
+func NewMemoryBanList() BanList {
+	return &memoryBanList{
+		bans: make(map[string]map[string]string),
+	}
+}
+
+func (m *memoryBanList) Ban(roomID, clientID, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.bans[roomID]; !ok {
+		m.bans[roomID] = make(map[string]string)
+	}
+
+	m.bans[roomID][clientID] = reason
+
+	return nil
+}
+
+func (m *memoryBanList) Unban(roomID, clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.bans[roomID], clientID)
+
+	if len(m.bans[roomID]) == 0 {
+		delete(m.bans, roomID)
+	}
+
+	return nil
+}
+
+func (m *memoryBanList) IsBanned(roomID, clientID string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.bans[roomID][clientID]
+
+	return ok, nil
+}
+
+type kicked struct {
+	Reason string `json:"reason"`
+	Banned bool   `json:"banned"`
+}
+
+type internalDataKicked struct {
+	Type string `json:"type"`
+	Data kicked `json:"data"`
+}
+
+// KickClient removes a client from the room, the reason is sent to the client with the kicked internal message
+// before the peer connection is closed. The client can join again, use BanClient to prevent it.
+func (r *Room) KickClient(clientID, reason string) error {
+	return r.kickClient(clientID, reason, false)
+}
+
+// BanClient adds the client to the ban list and kicks it if it's in the room, a banned client can't be added
+// to the room with ErrClientBanned until it's unbanned.
+func (r *Room) BanClient(clientID, reason string) error {
+	if err := r.banList.Ban(r.id, clientID, reason); err != nil {
+		return err
+	}
+
+	if err := r.kickClient(clientID, reason, true); err != nil && !errors.Is(err, ErrClientNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// UnbanClient removes the client from the ban list
+func (r *Room) UnbanClient(clientID string) error {
+	return r.banList.Unban(r.id, clientID)
+}
+
+// BanList returns the ban list of the room
+func (r *Room) BanList() BanList {
+	return r.banList
+}
+
+func (r *Room) kickClient(clientID, reason string, banned bool) error {
+	client, err := r.sfu.GetClient(clientID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(internalDataKicked{
+		Type: messageTypeKicked,
+		Data: kicked{Reason: reason, Banned: banned},
+	})
+	if err != nil {
+		return err
+	}
+
+	client.sendInternalMessage(data)
+	client.waitInternalMessagesSent(kickMessageTimeout)
+
+	return r.StopClient(clientID)
+}
+
+// waitInternalMessagesSent waits until the queued internal messages are sent or the timeout is reached
+func (c *Client) waitInternalMessagesSent(timeout time.Duration) {
+	if c.internalDataChannel == nil {
+		return
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for c.internalDataChannel.BufferedAmount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
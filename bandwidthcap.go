@@ -0,0 +1,199 @@
+package sfu
+
+import (
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// the interval to repeat the REMB packet, the publisher will drop the cap if it's not refreshed
+	uplinkLimitInterval = time.Second
+	// the REMB that lifts the cap is higher than any publisher uplink, so only the estimate of the publisher applies
+	uplinkLimitCeiling = 100_000_000
+)
+
+// SetMaxDownlinkBitrate caps the bitrate that sent to the client in bits per second. The bitrate controller will
+// never use more than this value when selecting the quality of the subscribed tracks, even if the estimated
+// bandwidth is higher. Set it to 0 to remove the cap.
+func (c *Client) SetMaxDownlinkBitrate(bps uint32) {
+	c.maxDownlinkBitrate.Store(bps)
+	c.log.Infof("client: %s max downlink bitrate set to %s", c.id, ThousandSeparator(int(bps)))
+}
+
+// MaxDownlinkBitrate returns the downlink bitrate cap, 0 if there is no cap
+func (c *Client) MaxDownlinkBitrate() uint32 {
+	return c.maxDownlinkBitrate.Load()
+}
+
+// SetMaxUplinkBitrate caps the bitrate that the client publishes in bits per second. The cap is sent to the client
+// as REMB feedback every second, the client will lower its encoder bitrate based on the smallest value between its own
+// estimation and the cap. Set it to 0 to remove the cap, the client keeps the last REMB that it received so the
+// remaining cap or a high ceiling is sent right away.
+func (c *Client) SetMaxUplinkBitrate(bps uint32) {
+	previous := c.maxUplinkBitrate.Swap(bps)
+	c.log.Infof("client: %s max uplink bitrate set to %s", c.id, ThousandSeparator(int(bps)))
+
+	if bps == 0 {
+		if previous != 0 {
+			c.liftUplinkLimit()
+		}
+
+		return
+	}
+
+	c.sendUplinkLimit()
+	c.startUplinkLimiter()
+}
+
+// startUplinkLimiter starts sending the REMB every second, it's stopped once there is no uplink cap
+func (c *Client) startUplinkLimiter() {
+	if c.isUplinkLimiterRunning.CompareAndSwap(false, true) {
+		go c.loopUplinkLimit()
+	}
+}
+
+// setRelayUplinkLimit caps the uplink bitrate while the relay that forwards the tracks of the client is congested,
+// the lowest cap of the relays is used. Set it to 0 to remove the cap of the relay.
+func (c *Client) setRelayUplinkLimit(relayID string, bps uint32) {
+	if bps == 0 {
+		if _, ok := c.relayUplinkLimits.LoadAndDelete(relayID); ok {
+			c.liftUplinkLimit()
+		}
+
+		return
+	}
+
+	c.relayUplinkLimits.Store(relayID, bps)
+
+	c.sendUplinkLimit()
+	c.startUplinkLimiter()
+}
+
+// relayUplinkLimit returns the lowest uplink cap of the congested relays, 0 if there is no congested relay
+func (c *Client) relayUplinkLimit() uint32 {
+	bps := uint32(0)
+
+	c.relayUplinkLimits.Range(func(_, value any) bool {
+		if limit := value.(uint32); bps == 0 || limit < bps {
+			bps = limit
+		}
+
+		return true
+	})
+
+	return bps
+}
+
+// MaxUplinkBitrate returns the uplink bitrate cap, 0 if there is no cap
+func (c *Client) MaxUplinkBitrate() uint32 {
+	return c.maxUplinkBitrate.Load()
+}
+
+func (c *Client) loopUplinkLimit() {
+	defer c.isUplinkLimiterRunning.Store(false)
+
+	ticker := time.NewTicker(uplinkLimitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.context.Done():
+			return
+		case <-ticker.C:
+			if c.maxUplinkBitrate.Load() == 0 && !c.options.CapUnusedLayers && c.publishConstraints().MaxBitrate == 0 && c.relayUplinkLimit() == 0 {
+				return
+			}
+
+			c.sendUplinkLimit()
+		}
+	}
+}
+
+// uplinkLimitPacket returns the REMB packet for all published media SSRCs, nil if there is no cap or no published track.
+// The cap is the lowest of the max uplink bitrate, the max bitrate of the publish constraints, the bitrate of the simulcast
+// layers that needed, see unusedLayersLimit, and the caps of the congested relays.
+func (c *Client) uplinkLimitPacket() *rtcp.ReceiverEstimatedMaximumBitrate {
+	bps := c.maxUplinkBitrate.Load()
+	if limit := c.publishConstraints().MaxBitrate; limit > 0 && (bps == 0 || limit < bps) {
+		bps = limit
+	}
+
+	if limit := c.unusedLayersLimit(); limit > 0 && (bps == 0 || limit < bps) {
+		bps = limit
+	}
+
+	if limit := c.relayUplinkLimit(); limit > 0 && (bps == 0 || limit < bps) {
+		bps = limit
+	}
+
+	if bps == 0 {
+		return nil
+	}
+
+	return c.uplinkREMB(bps)
+}
+
+// uplinkLiftPacket returns the REMB packet that sent when a cap is removed, with the remaining cap or the ceiling if
+// there is no cap anymore. It's nil if there is no published track.
+func (c *Client) uplinkLiftPacket() *rtcp.ReceiverEstimatedMaximumBitrate {
+	if packet := c.uplinkLimitPacket(); packet != nil {
+		return packet
+	}
+
+	return c.uplinkREMB(uplinkLimitCeiling)
+}
+
+// uplinkREMB returns the REMB packet of the bitrate for all published media SSRCs, nil if there is no published track
+func (c *Client) uplinkREMB(bps uint32) *rtcp.ReceiverEstimatedMaximumBitrate {
+	ssrcs := make([]uint32, 0)
+
+	for _, track := range c.tracks.GetTracks() {
+		switch t := track.(type) {
+		case *Track:
+			ssrcs = append(ssrcs, uint32(t.SSRC()))
+		case *AudioTrack:
+			ssrcs = append(ssrcs, uint32(t.SSRC()))
+		case *SimulcastTrack:
+			for _, ssrc := range []webrtc.SSRC{t.SSRCHigh(), t.SSRCMid(), t.SSRCLow()} {
+				if ssrc != 0 {
+					ssrcs = append(ssrcs, uint32(ssrc))
+				}
+			}
+		}
+	}
+
+	if len(ssrcs) == 0 {
+		return nil
+	}
+
+	return &rtcp.ReceiverEstimatedMaximumBitrate{
+		Bitrate: float32(bps),
+		SSRCs:   ssrcs,
+	}
+}
+
+func (c *Client) sendUplinkLimit() {
+	c.writeUplinkREMB(c.uplinkLimitPacket())
+}
+
+// liftUplinkLimit sends the remaining cap or the ceiling after a cap is removed, the client would keep the removed cap
+// until it receives a new REMB
+func (c *Client) liftUplinkLimit() {
+	c.writeUplinkREMB(c.uplinkLiftPacket())
+}
+
+func (c *Client) writeUplinkREMB(packet *rtcp.ReceiverEstimatedMaximumBitrate) {
+	if packet == nil {
+		return
+	}
+
+	if c.peerConnection == nil || c.peerConnection.PC() == nil || c.peerConnection.PC().ConnectionState() != webrtc.PeerConnectionStateConnected {
+		return
+	}
+
+	if err := c.peerConnection.PC().WriteRTCP([]rtcp.Packet{packet}); err != nil {
+		c.log.Errorf("client: error write remb ", err)
+	}
+}
@@ -0,0 +1,67 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// the estimate is only decreased when the new target is lower than this ratio of the current estimate
+	bandwidthDecreaseRatio = 0.95
+	// the estimate is only increased when the new target is higher than this ratio of the current estimate
+	bandwidthIncreaseRatio = 1.1
+	// the new higher target must be stable for this duration before the estimate is increased
+	bandwidthIncreaseHold = 2 * time.Second
+	// the estimate won't increase for this duration after it is decreased
+	bandwidthDecreaseHold = 5 * time.Second
+)
+
+// bandwidthHysteresis stabilizes the TWCC based target bitrate from the congestion controller before it's used by
+// the bitrate controller to select the simulcast and SVC layers. The estimate follows the lower target immediately
+// so the congestion is handled fast, but the higher target is only followed after it's stable for a while,
+// so the layers are not switched up and down on every small change of the target bitrate. It's updated once a second
+// by the bitrate controller, so the estimate doesn't depend on how often it's read.
+type bandwidthHysteresis struct {
+	mu            sync.Mutex
+	estimate      uint32
+	lastDecrease  time.Time
+	increaseSince time.Time
+}
+
+func (h *bandwidthHysteresis) update(target uint32, now time.Time) uint32 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.estimate == 0 {
+		h.estimate = target
+		return h.estimate
+	}
+
+	switch {
+	case float64(target) < float64(h.estimate)*bandwidthDecreaseRatio:
+		h.estimate = target
+		h.lastDecrease = now
+		h.increaseSince = time.Time{}
+	case float64(target) > float64(h.estimate)*bandwidthIncreaseRatio:
+		if h.increaseSince.IsZero() {
+			h.increaseSince = now
+		}
+
+		if now.Sub(h.increaseSince) >= bandwidthIncreaseHold && now.Sub(h.lastDecrease) >= bandwidthDecreaseHold {
+			h.estimate = target
+			h.increaseSince = time.Time{}
+		}
+	default:
+		h.increaseSince = time.Time{}
+	}
+
+	return h.estimate
+}
+
+// get returns the current estimate, 0 if it's never updated
+func (h *bandwidthHysteresis) get() uint32 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.estimate
+}
@@ -0,0 +1,232 @@
+package sfu
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// the allocation weight of the subscribed video tracks, the track with higher weight gets the bigger share of the budget
+const (
+	allocationWeightDefault = 1
+	allocationWeightSpeaker = 2
+	allocationWeightScreen  = 3
+)
+
+// bitrateAllocator divides the downlink budget of each client in the room among the subscribed video tracks.
+// The audio and the non adjustable video tracks are reserved first, then the quality strategy selects the quality of
+// the rest, see DefaultQualityStrategy.
+type bitrateAllocator struct {
+	room    *Room
+	enabled *atomic.Bool
+	budget  *atomic.Uint32
+	// the strategy that selects the quality of the tracks, the weighted share if it's nil
+	strategy QualityStrategy
+}
+
+type trackAllocation struct {
+	id         string
+	weight     uint32
+	minBitrate uint32
+	maxQuality QualityLevel
+	bitrateAt  func(QualityLevel) uint32
+	quality    QualityLevel
+}
+
+func newBitrateAllocator(room *Room, budget *uint32, strategy QualityStrategy) *bitrateAllocator {
+	a := &bitrateAllocator{
+		room:     room,
+		enabled:  &atomic.Bool{},
+		budget:   &atomic.Uint32{},
+		strategy: strategy,
+	}
+
+	// the custom strategy replaces the stepwise bitrate controller even without the budget
+	if strategy != nil {
+		a.enabled.Store(true)
+	}
+
+	if budget != nil {
+		a.enabled.Store(true)
+		a.budget.Store(*budget)
+	}
+
+	return a
+}
+
+// SetDownlinkBitrateBudget sets the total downlink bitrate of each client in the room in bits per second that shared
+// by the subscribed video tracks. Calling it enables the room bitrate allocation if RoomOptions.DownlinkBitrateBudget
+// is not set. Set it to 0 to only use the estimated bandwidth of the client as the budget.
+func (r *Room) SetDownlinkBitrateBudget(bps uint32) {
+	r.bitrateAllocator.budget.Store(bps)
+	r.bitrateAllocator.enabled.Store(true)
+}
+
+// DownlinkBitrateBudget returns the downlink bitrate budget of each client in the room
+func (r *Room) DownlinkBitrateBudget() uint32 {
+	return r.bitrateAllocator.budget.Load()
+}
+
+func (a *bitrateAllocator) isEnabled() bool {
+	return a != nil && a.enabled.Load()
+}
+
+func (a *bitrateAllocator) weight(bc *bitrateController, track iClientTrack) uint32 {
+	if track.IsScreen() {
+		return allocationWeightScreen
+	}
+
+	publisherTrack, err := bc.client.publishedTracks.Get(track.ID())
+	if err == nil && publisherTrack.ClientID() == a.room.DominantSpeaker() {
+		return allocationWeightSpeaker
+	}
+
+	return allocationWeightDefault
+}
+
+// allocate sets the quality of the client video tracks that selected by the quality strategy
+func (a *bitrateAllocator) allocate(bc *bitrateController) {
+	input := a.qualityInput(bc)
+	if len(input.Tracks) == 0 || len(input.Levels) == 0 {
+		return
+	}
+
+	strategy := a.strategy
+	if strategy == nil {
+		strategy = DefaultQualityStrategy()
+	}
+
+	qualities := strategy.SelectQualities(input)
+
+	for _, track := range input.Tracks {
+		quality, ok := qualities[track.ID]
+		if !ok {
+			continue
+		}
+
+		quality = min(quality, track.MaxQuality)
+
+		claim := bc.GetClaim(track.ID)
+		if claim == nil || claim.Quality() == quality {
+			continue
+		}
+
+		bc.log.Tracef("bitrateallocator: track %s quality changed from %d to %d", track.ID, claim.Quality(), quality)
+		bc.setQuality(track.ID, quality)
+		claim.track.RequestPLI()
+	}
+}
+
+// qualityInput collects the bandwidth and the adjustable video tracks of the client for the quality strategy
+func (a *bitrateAllocator) qualityInput(bc *bitrateController) QualityInput {
+	bandwidth := bc.client.GetEstimatedBandwidth()
+	if budget := a.budget.Load(); budget != 0 && budget < bandwidth {
+		bandwidth = budget
+	}
+
+	input := QualityInput{
+		ClientID:  bc.client.ID(),
+		Bandwidth: bandwidth,
+		Levels:    slices.Clone(bc.enabledQualityLevels),
+		Tracks:    make([]QualityTrack, 0),
+	}
+
+	for id, claim := range bc.Claims() {
+		if claim.track.Kind() != webrtc.RTPCodecTypeVideo || !claim.IsAdjustable() {
+			input.Reserved += claim.SendBitrate()
+			continue
+		}
+
+		// the bitrate is unknown until the track receives the packets, and the paused or hidden track doesn't use the budget
+		if claim.track.ReceiveBitrate() == 0 || claim.track.MaxQuality() == QualityNone || bc.client.isTrackPaused(id) {
+			continue
+		}
+
+		track := QualityTrack{
+			ID:            id,
+			IsScreen:      claim.track.IsScreen(),
+			Quality:       claim.Quality(),
+			MaxQuality:    claim.track.MaxQuality(),
+			Priority:      a.weight(bc, claim.track),
+			Tier:          claim.tier(),
+			LayerBitrates: make(map[QualityLevel]uint32, len(input.Levels)),
+		}
+
+		if publisherTrack, err := bc.client.publishedTracks.Get(id); err == nil {
+			track.PublisherID = publisherTrack.ClientID()
+		}
+
+		if track.IsScreen {
+			track.MinBitrate = a.room.sfu.screenProfile.MinBitrate
+		}
+
+		track.ViewportWidth, track.ViewportHeight = claim.Viewport()
+
+		for _, level := range input.Levels {
+			track.LayerBitrates[level] = claim.QualityLevelToBitrate(level)
+		}
+
+		input.Tracks = append(input.Tracks, track)
+	}
+
+	return input
+}
+
+// allocateBitrates shares the bandwidth by the weight of the tracks and sets the highest quality that fits the track share
+func allocateBitrates(bandwidth uint32, tracks []*trackAllocation, levels []QualityLevel) {
+	levels = slices.Clone(levels)
+	sort.Slice(levels, func(i, j int) bool { return levels[i] > levels[j] })
+
+	// the tracks that need less than their share are saturated first, so the rest of the bandwidth can be shared again
+	pending := slices.Clone(tracks)
+
+	for len(pending) > 0 {
+		totalWeight := uint32(0)
+		for _, track := range pending {
+			totalWeight += track.weight
+		}
+
+		unsaturated := make([]*trackAllocation, 0, len(pending))
+		spent := uint32(0)
+
+		for _, track := range pending {
+			share := uint64(bandwidth) * uint64(track.weight) / uint64(totalWeight)
+			maxQuality := qualityForBitrate(levels, track, math.MaxUint64)
+
+			if maxBitrate := track.bitrateAt(maxQuality); uint64(maxBitrate) <= share {
+				track.quality = maxQuality
+				spent += maxBitrate
+			} else {
+				unsaturated = append(unsaturated, track)
+			}
+		}
+
+		if len(unsaturated) == len(pending) {
+			for _, track := range unsaturated {
+				// the screen track keeps its minimum bitrate even if it's more than its share
+				share := max(uint64(bandwidth)*uint64(track.weight)/uint64(totalWeight), uint64(track.minBitrate))
+				track.quality = qualityForBitrate(levels, track, share)
+			}
+
+			return
+		}
+
+		bandwidth -= min(spent, bandwidth)
+		pending = unsaturated
+	}
+}
+
+// qualityForBitrate returns the highest quality level that fits the bitrate, or the lowest level if nothing fits.
+// The level with unknown bitrate is skipped, the simulcast layer is not always sent by the publisher.
+func qualityForBitrate(levels []QualityLevel, track *trackAllocation, bitrate uint64) QualityLevel {
+	for _, level := range levels {
+		if levelBitrate := track.bitrateAt(level); level <= track.maxQuality && levelBitrate != 0 && uint64(levelBitrate) <= bitrate {
+			return level
+		}
+	}
+
+	return levels[len(levels)-1]
+}
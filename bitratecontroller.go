@@ -5,8 +5,10 @@ import (
 	"errors"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/inlivedev/sfu/pkg/logger"
 	"github.com/pion/logging"
 	"github.com/pion/webrtc/v4"
 )
@@ -25,6 +27,12 @@ type bitrateClaim struct {
 	track     iClientTrack
 	quality   QualityLevel
 	simulcast bool
+	// the quality is pinned by the subscriber and won't be adjusted by the bandwidth
+	pinned bool
+	// the rendered size of the video that reported by the subscriber
+	viewport videoSize
+	// the priority that set by the app server or the subscriber, see SubscriptionPriority
+	priority SubscriptionPriority
 }
 
 func (c *bitrateClaim) Quality() QualityLevel {
@@ -41,6 +49,36 @@ func (c *bitrateClaim) SetQuality(quality QualityLevel) {
 	c.quality = quality
 }
 
+// Viewport returns the rendered width and height of the video that reported by the subscriber, zero if it's not reported
+func (c *bitrateClaim) Viewport() (uint32, uint32) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.viewport.Width, c.viewport.Height
+}
+
+func (c *bitrateClaim) setViewport(size videoSize) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.viewport = size
+}
+
+// Priority returns the subscription priority of the track, auto if it's not set
+func (c *bitrateClaim) Priority() SubscriptionPriority {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.priority
+}
+
+func (c *bitrateClaim) setPriority(priority SubscriptionPriority) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.priority = priority
+}
+
 func (c *bitrateClaim) SendBitrate() uint32 {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -50,7 +88,21 @@ func (c *bitrateClaim) SendBitrate() uint32 {
 }
 
 func (c *bitrateClaim) IsAdjustable() bool {
-	return c.track.IsSimulcast() || c.track.IsScaleable()
+	return (c.track.IsSimulcast() || c.track.IsScaleable()) && !c.IsPinned()
+}
+
+func (c *bitrateClaim) IsPinned() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.pinned
+}
+
+func (c *bitrateClaim) setPinned(pinned bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pinned = pinned
 }
 
 func (c *bitrateClaim) QualityLevelToBitrate(quality QualityLevel) uint32 {
@@ -107,6 +159,10 @@ type bitrateController struct {
 	claims               sync.Map
 	enabledQualityLevels []QualityLevel
 	log                  logging.LeveledLogger
+	// set by the room when the room bitrate allocation is used
+	allocator atomic.Pointer[bitrateAllocator]
+	// only accessed by loopMonitor, to hold the increase after decreasing the bitrates
+	lastDecrease time.Time
 }
 
 func newbitrateController(client *Client, qualityLevels []QualityLevel) *bitrateController {
@@ -114,10 +170,10 @@ func newbitrateController(client *Client, qualityLevels []QualityLevel) *bitrate
 		client:               client,
 		claims:               sync.Map{},
 		enabledQualityLevels: qualityLevels,
-		log:                  logging.NewDefaultLoggerFactory().NewLogger("bitratecontroller"),
+		log:                  logger.Named(client.log, "bitratecontroller"),
 	}
 
-	go bc.loopMonitor()
+	client.resources.goroutine(bc.loopMonitor)
 
 	return bc
 }
@@ -293,6 +349,11 @@ func (bc *bitrateController) addClaims(clientTracks []iClientTrack) error {
 
 	var trackQuality QualityLevel = bc.qualityLevelPerTrack(leftTracks)
 
+	// don't start from the high layer before the downlink probe confirms the bandwidth
+	if bc.client.isProbingDownlink() {
+		trackQuality = min(trackQuality, QualityLow)
+	}
+
 	bc.log.Debugf("bitratecontroller: quality level per track is %d for  total %d tracks", trackQuality, len(leftTracks))
 
 	for _, clientTrack := range leftTracks {
@@ -309,7 +370,12 @@ func (bc *bitrateController) addClaims(clientTracks []iClientTrack) error {
 			if clientTrack.IsSimulcast() {
 				clientTrack.(*simulcastClientTrack).lastQuality.Store(uint32(trackQuality))
 			} else if clientTrack.IsScaleable() {
-				clientTrack.(*scaleableClientTrack).setLastQuality(trackQuality)
+				switch t := clientTrack.(type) {
+				case *scaleableClientTrack:
+					t.setLastQuality(trackQuality)
+				case *scaleableAV1ClientTrack:
+					t.setLastQuality(trackQuality)
+				}
 			}
 
 			_, err := bc.addClaim(clientTrack, trackQuality)
@@ -320,6 +386,10 @@ func (bc *bitrateController) addClaims(clientTracks []iClientTrack) error {
 		}
 	}
 
+	if allocator := bc.allocator.Load(); allocator.isEnabled() && !bc.client.isProbingDownlink() {
+		allocator.allocate(bc)
+	}
+
 	if len(errors) > 0 {
 		return FlattenErrors(errors)
 	}
@@ -414,7 +484,21 @@ func (bc *bitrateController) loopMonitor() {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case now := <-ticker.C:
+			bc.client.updateEstimatedBandwidth(now)
+
+			// the quality is kept until the downlink probe is done
+			if bc.client.isProbingDownlink() {
+				continue
+			}
+
+			bc.refreshViewports()
+
+			if allocator := bc.allocator.Load(); allocator.isEnabled() {
+				allocator.allocate(bc)
+				continue
+			}
+
 			var needAdjustment bool
 
 			totalSendBitrates := bc.totalSentBitrates()
@@ -432,6 +516,11 @@ func (bc *bitrateController) loopMonitor() {
 			}
 
 			if totalSendBitrates < uint32(bw) {
+				// don't increase right after decreased, the sent bitrates need time to follow the new layers
+				if time.Since(bc.lastDecrease) < bandwidthDecreaseHold {
+					continue
+				}
+
 				needAdjustment = bc.canIncreaseBitrate(availableBw)
 				if needAdjustment {
 					bc.log.Tracef("bitratecontroller: need to increase bitrate, available bandwidth %s", ThousandSeparator(int(availableBw)))
@@ -439,6 +528,7 @@ func (bc *bitrateController) loopMonitor() {
 			} else {
 				needAdjustment = bc.canDecreaseBitrate()
 				if needAdjustment {
+					bc.lastDecrease = time.Now()
 					bc.log.Tracef("bitratecontroller: need to decrease bitrate, available bandwidth ", ThousandSeparator(int(availableBw)))
 				}
 			}
@@ -457,9 +547,9 @@ func (bc *bitrateController) loopMonitor() {
 func (bc *bitrateController) fitBitratesToBandwidth(bw uint32) {
 	totalSentBitrates := bc.totalSentBitrates()
 
-	claims := bc.Claims()
 	if totalSentBitrates > bw {
-		// reduce bitrates
+		// reduce bitrates, the lower priority tracks first
+		claims := bc.claimsByTier(false)
 		for i := QualityHigh; i > QualityLowLow; i-- {
 			bc.log.Trace("bitratecontroller: trying to reduce bitrate")
 			for _, claim := range claims {
@@ -492,7 +582,8 @@ func (bc *bitrateController) fitBitratesToBandwidth(bw uint32) {
 		}
 	} else if totalSentBitrates < bw {
 		bc.log.Trace("bitratecontroller: trying to increase bitrate")
-		// increase bitrates
+		// increase bitrates, the higher priority tracks first
+		claims := bc.claimsByTier(true)
 		for i := QualityLowLow; i < QualityHigh; i++ {
 			for _, claim := range claims {
 				quality := claim.Quality()
@@ -560,6 +651,13 @@ func (bc *bitrateController) onRemoteViewedSizeChanged(videoSize videoSize) {
 		return
 	}
 
+	claim.setViewport(videoSize)
+
+	if claim.IsPinned() {
+		bc.log.Debugf("bitrate: track %s quality is pinned, ignore the video size", videoSize.TrackID)
+		return
+	}
+
 	bc.log.Debugf("bitrate: track %s video size changed  %dx%d=%d pixels", videoSize.TrackID, videoSize.Width, videoSize.Height, videoSize.Width*videoSize.Height)
 
 	// TODO: check if it is necessary to set max quality to none
@@ -569,16 +667,9 @@ func (bc *bitrateController) onRemoteViewedSizeChanged(videoSize videoSize) {
 		return
 	}
 
-	if videoSize.Width*videoSize.Height < bc.client.sfu.bitrateConfigs.VideoLowPixels {
-		bc.log.Debugf("bitrate: track %s video size is low, set max quality to low", videoSize.TrackID)
-		claim.track.SetMaxQuality(QualityLow)
-	} else if videoSize.Width*videoSize.Height < bc.client.sfu.bitrateConfigs.VideoMidPixels {
-		bc.log.Infof("bitrate: track %s video size is mid, set max quality to mid", videoSize.TrackID)
-		claim.track.SetMaxQuality(QualityMid)
-	} else {
-		bc.log.Infof("bitrate: track %s video size is high, set max quality to high", videoSize.TrackID)
-		claim.track.SetMaxQuality(QualityHigh)
-	}
+	maxQuality := bc.viewportMaxQuality(claim.track, videoSize)
+	bc.log.Debugf("bitrate: track %s video size set max quality to %d", videoSize.TrackID, maxQuality)
+	claim.track.SetMaxQuality(maxQuality)
 }
 
 func (bc *bitrateController) isEnoughBandwidthToIncrase(bandwidthLeft uint32, claim *bitrateClaim) bool {
@@ -0,0 +1,193 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+
+	"github.com/pion/webrtc/v4"
+)
+
+var (
+	ErrMoveToSameRoom      = errors.New("room: error the client is already in the target room")
+	ErrMoveClientNotActive = errors.New("room: error the client must be connected to move to another room")
+	ErrMoveE2EERequired    = errors.New("room: error the target room requires the end-to-end encrypted client")
+)
+
+// MoveClient moves a connected client to the target room through the same peer connection, for example to send the
+// participants to the breakout rooms and back. The subscriptions of the client to the tracks of this room and the
+// subscriptions of the other clients to the tracks of the client are removed, then the published tracks are announced
+// to the target room and the tracks of the target room are available to the client. The senders are removed and added
+// with the usual renegotiation, the ICE and DTLS connection is kept.
+//
+// The client is admitted with the same checks as Room.AddClient, like the ban list, the max clients, and the extensions.
+// The publish constraints of the target room replace the constraints of the client, the move is rejected with the
+// constraint error if the published tracks violate them, and with ErrMoveE2EERequired if the target room is end-to-end
+// encrypted but the client is not. The client waits in the lobby if the target room has one.
+//
+// This room gets the client left event and the target room gets the client joined or waiting event. The other client
+// options that copied from the room options when the client is added, like the playout delay, are kept.
+func (r *Room) MoveClient(clientID string, target *Room) error {
+	if target == r {
+		return ErrMoveToSameRoom
+	}
+
+	client, err := r.sfu.GetClient(clientID)
+	if err != nil {
+		return err
+	}
+
+	if client.state.Load() != ClientStateActive {
+		return ErrMoveClientNotActive
+	}
+
+	if target.options.E2EE && !client.options.E2EE {
+		return ErrMoveE2EERequired
+	}
+
+	var constraints *PublishConstraints
+	if c, ok := target.options.PublishConstraints[client.Role()]; ok {
+		constraints = &c
+
+		if err := checkPublishedTracks(client.tracks.GetTracks(), c); err != nil {
+			return err
+		}
+	}
+
+	release, err := target.admitClient(clientID, false)
+	if err != nil {
+		return err
+	}
+
+	// the place in the target room is released once the client is added, or if the move fails
+	defer release()
+
+	// the tracks of this room that the client must not receive anymore
+	staleTracks := make(map[string]bool)
+	for _, track := range r.sfu.relayTrackList() {
+		staleTracks[track.ID()] = true
+	}
+
+	for _, c := range r.sfu.GetClients() {
+		for _, track := range c.tracks.GetTracks() {
+			staleTracks[track.ID()] = true
+		}
+	}
+
+	publishedTracks := make(map[string]bool)
+	for _, track := range client.tracks.GetTracks() {
+		publishedTracks[track.ID()] = true
+		delete(staleTracks, track.ID())
+	}
+
+	if err := r.sfu.clients.Remove(client); err != nil {
+		return err
+	}
+
+	// the other clients in this room stop receiving the tracks of the client
+	for _, c := range r.sfu.GetClients() {
+		for id, track := range c.ClientTracks() {
+			if publishedTracks[id] {
+				endSubscription(track)
+			}
+		}
+	}
+
+	for id, track := range client.ClientTracks() {
+		if staleTracks[id] {
+			endSubscription(track)
+		}
+	}
+
+	client.mu.Lock()
+	client.stopSFUWatch()
+	client.sfu.Store(target.sfu)
+	client.stopSFUWatch = context.AfterFunc(target.sfu.context, client.cancel)
+	client.mu.Unlock()
+
+	client.bitrateController.allocator.Store(target.bitrateAllocator)
+
+	client.movePublishConstraints(constraints)
+
+	client.log.Infof("room: client %s moved from room %s to room %s", clientID, r.id, target.id)
+
+	// the client left this room without ending the client
+	r.speakers.removeClient(clientID)
+
+	if token := client.ReconnectToken(); token != "" {
+		r.sessions.remove(token)
+		client.setReconnectToken("")
+	}
+
+	r.onClientLeft(client)
+
+	r.sfu.onTrackCatalogChanged()
+
+	// and joined the target room
+	inLobby := target.options.Lobby && client.Role() != ClientRoleModerator
+	client.inLobby.Store(inLobby)
+
+	target.sfu.addClient(client)
+	release()
+
+	target.analytics.addClient(client)
+
+	if target.reconnectGracePeriod() > 0 {
+		token := target.sessions.add(client)
+		client.setReconnectToken(token)
+		target.sessions.setJoined(token)
+	}
+
+	// the client gets the tracks and its tracks are announced once it's admitted, see Room.Admit
+	if inLobby {
+		client.sendLobbyState(false)
+		target.onClientWaiting(client)
+
+		return nil
+	}
+
+	target.onClientJoined(client)
+
+	if tracks := client.tracks.GetTracks(); len(tracks) > 0 {
+		target.sfu.onTracksAvailable(clientID, tracks)
+	} else {
+		target.sfu.onTrackCatalogChanged()
+	}
+
+	if tracks := target.sfu.availableTracksFor(client); len(tracks) > 0 {
+		client.onTracksAvailable(tracks)
+	}
+
+	return nil
+}
+
+// movePublishConstraints replaces the publish constraints of the client with the constraints of the room that the client
+// is moved to, the resolution limit is added to the published tracks that published without one
+func (c *Client) movePublishConstraints(constraints *PublishConstraints) {
+	previous := c.publishConstraints()
+
+	c.constraints.Store(constraints)
+
+	if constraints == nil {
+		return
+	}
+
+	if (constraints.MaxWidth > 0 || constraints.MaxHeight > 0) && previous.MaxWidth == 0 && previous.MaxHeight == 0 {
+		for _, track := range c.tracks.GetTracks() {
+			if track.Kind() == webrtc.RTPCodecTypeVideo {
+				track.AddPacketInterceptor(PacketIngress, c.maxResolutionInterceptor())
+			}
+		}
+	}
+
+	if constraints.MaxBitrate > 0 {
+		c.sendUplinkLimit()
+		c.startUplinkLimiter()
+	}
+}
+
+// endSubscription removes the client track from the subscriber without ending the published track
+func endSubscription(track iClientTrack) {
+	if t, ok := track.(interface{ onEnded() }); ok {
+		t.onEnded()
+	}
+}
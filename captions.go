@@ -0,0 +1,190 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// CaptionsDataChannelLabel is the reserved data channel that the captions are sent on, it's created on a client
+// with the first caption that the client receives or when the client sets the caption languages
+const CaptionsDataChannelLabel = "captions"
+
+// the captions that sent once the captions data channel is open, the older captions are dropped
+const maxPendingCaptions = 20
+
+var (
+	ErrCaptionEmpty        = errors.New("captions: caption text is empty")
+	ErrDataChannelReserved = errors.New("error: data channel label is reserved")
+)
+
+// CaptionTiming is the time of the speech that the caption is transcribed from
+type CaptionTiming struct {
+	Start    time.Time
+	Duration time.Duration
+	// Final is false for the interim caption, the next caption of the same client replaces it
+	Final bool
+}
+
+// Caption is sent as JSON on the captions data channel, the start is the unix time and the duration in milliseconds
+type Caption struct {
+	ClientID string `json:"client_id"`
+	Name     string `json:"name"`
+	Text     string `json:"text"`
+	Lang     string `json:"lang"`
+	Final    bool   `json:"final"`
+	Start    int64  `json:"start"`
+	Duration int64  `json:"duration"`
+}
+
+type internalDataCaptionLanguages struct {
+	Type string   `json:"type"`
+	Data []string `json:"data"`
+}
+
+// clientCaptions is the captions data channel of a client and the languages that the client receives
+type clientCaptions struct {
+	mu          sync.Mutex
+	dataChannel *webrtc.DataChannel
+	languages   []string
+	pending     [][]byte
+}
+
+// PublishCaption sends the caption of what the client said to all clients in the room that receive the language, like
+// the transcript from a speech-to-text service. The lang is a BCP-47 tag like "en" or "en-US".
+func (r *Room) PublishCaption(clientID, text, lang string, timing CaptionTiming) error {
+	if strings.TrimSpace(text) == "" {
+		return ErrCaptionEmpty
+	}
+
+	speaker, err := r.sfu.GetClient(clientID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(Caption{
+		ClientID: clientID,
+		Name:     speaker.Name(),
+		Text:     text,
+		Lang:     lang,
+		Final:    timing.Final,
+		Start:    timing.Start.UnixMilli(),
+		Duration: timing.Duration.Milliseconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, client := range r.sfu.GetClients() {
+		if client.IsBridge() || client.IsInLobby() || !client.receivesCaptionLanguage(lang) {
+			continue
+		}
+
+		client.sendCaption(data)
+	}
+
+	return nil
+}
+
+// SetCaptionLanguages sets the languages of the captions that the client receives, empty means all languages.
+// A language matches the captions of its regional tags, "en" matches "en-US". The client sets it with the
+// caption_languages data channel message.
+func (c *Client) SetCaptionLanguages(languages []string) {
+	c.captions.mu.Lock()
+	c.captions.languages = languages
+	c.captions.mu.Unlock()
+
+	// the channel is ready before the first caption is published
+	if err := c.captionsDataChannel(); err != nil {
+		c.log.Errorf("client: error create captions data channel %s", err.Error())
+	}
+}
+
+// CaptionLanguages returns the languages of the captions that the client receives, empty means all languages
+func (c *Client) CaptionLanguages() []string {
+	c.captions.mu.Lock()
+	defer c.captions.mu.Unlock()
+
+	return append([]string(nil), c.captions.languages...)
+}
+
+func (c *Client) receivesCaptionLanguage(lang string) bool {
+	c.captions.mu.Lock()
+	defer c.captions.mu.Unlock()
+
+	if len(c.captions.languages) == 0 {
+		return true
+	}
+
+	for _, language := range c.captions.languages {
+		if strings.EqualFold(language, lang) || (len(lang) > len(language) &&
+			strings.EqualFold(lang[:len(language)], language) && lang[len(language)] == '-') {
+			return true
+		}
+	}
+
+	return false
+}
+
+// captionsDataChannel creates the captions data channel, the pending captions are sent once it's open
+func (c *Client) captionsDataChannel() error {
+	c.captions.mu.Lock()
+	defer c.captions.mu.Unlock()
+
+	if c.captions.dataChannel != nil {
+		return nil
+	}
+
+	// the captions are only sent by the SFU, the messages from the client are ignored
+	dc, err := c.createInternalDataChannel(CaptionsDataChannelLabel, func(msg webrtc.DataChannelMessage) {})
+	if err != nil {
+		return err
+	}
+
+	dc.OnOpen(func() {
+		c.captions.mu.Lock()
+		pending := c.captions.pending
+		c.captions.pending = nil
+		c.captions.mu.Unlock()
+
+		for _, data := range pending {
+			if err := dc.SendText(string(data)); err != nil {
+				c.log.Errorf("client: error send caption ", err)
+			}
+		}
+	})
+
+	c.captions.dataChannel = dc
+
+	return nil
+}
+
+func (c *Client) sendCaption(data []byte) {
+	if err := c.captionsDataChannel(); err != nil {
+		c.log.Errorf("client: error create captions data channel %s", err.Error())
+		return
+	}
+
+	c.captions.mu.Lock()
+	dc := c.captions.dataChannel
+
+	if dc.ReadyState() != webrtc.DataChannelStateOpen {
+		c.captions.pending = append(c.captions.pending, data)
+		if len(c.captions.pending) > maxPendingCaptions {
+			c.captions.pending = c.captions.pending[1:]
+		}
+
+		c.captions.mu.Unlock()
+
+		return
+	}
+	c.captions.mu.Unlock()
+
+	if err := dc.SendText(string(data)); err != nil {
+		c.log.Errorf("client: error send caption ", err)
+	}
+}
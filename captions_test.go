@@ -0,0 +1,130 @@
+package sfu
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoomPublishCaption(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-captions", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	require.ErrorIs(t, testRoom.CreateDataChannel(CaptionsDataChannelLabel, DefaultDataChannelOptions()), ErrDataChannelReserved)
+
+	onDataChannel := func(captions chan Caption) func(d *webrtc.DataChannel) {
+		return func(d *webrtc.DataChannel) {
+			if d.Label() != CaptionsDataChannelLabel {
+				return
+			}
+
+			d.OnMessage(func(msg webrtc.DataChannelMessage) {
+				caption := Caption{}
+				if err := json.Unmarshal(msg.Data, &caption); err == nil {
+					captions <- caption
+				}
+			})
+		}
+	}
+
+	captionsAll, captionsID := make(chan Caption, 10), make(chan Caption, 10)
+
+	_, speaker, _, speakerConn := CreateDataPair(ctx, TestLogger, testRoom, roomManager.options.IceServers, "speaker", func(*webrtc.DataChannel) {})
+	_, clientAll, _, allConn := CreateDataPair(ctx, TestLogger, testRoom, roomManager.options.IceServers, "all", onDataChannel(captionsAll))
+	_, clientID, _, idConn := CreateDataPair(ctx, TestLogger, testRoom, roomManager.options.IceServers, "id", onDataChannel(captionsID))
+
+	for _, connChan := range []chan webrtc.PeerConnectionState{speakerConn, allConn, idConn} {
+		waitConnected(t, connChan)
+	}
+
+	// the channel is created when the languages are set, so the first caption is not missed
+	clientID.SetCaptionLanguages([]string{"id"})
+	require.Equal(t, []string{"id"}, clientID.CaptionLanguages())
+
+	require.ErrorIs(t, testRoom.PublishCaption(speaker.ID(), " ", "en", CaptionTiming{}), ErrCaptionEmpty)
+	require.ErrorIs(t, testRoom.PublishCaption("unknown", "hello", "en", CaptionTiming{}), ErrClientNotFound)
+
+	start := time.Now()
+
+	require.NoError(t, testRoom.PublishCaption(speaker.ID(), "hello", "en-US", CaptionTiming{Start: start, Duration: 1500 * time.Millisecond, Final: true}))
+	require.NoError(t, testRoom.PublishCaption(speaker.ID(), "halo", "id-ID", CaptionTiming{Start: start, Final: false}))
+
+	receive := func(captions chan Caption) Caption {
+		select {
+		case caption := <-captions:
+			return caption
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for the caption")
+		}
+
+		return Caption{}
+	}
+
+	caption := receive(captionsAll)
+	require.Equal(t, "hello", caption.Text)
+	require.Equal(t, speaker.ID(), caption.ClientID)
+	require.Equal(t, speaker.Name(), caption.Name)
+	require.Equal(t, "en-US", caption.Lang)
+	require.True(t, caption.Final)
+	require.Equal(t, start.UnixMilli(), caption.Start)
+	require.Equal(t, int64(1500), caption.Duration)
+
+	require.Equal(t, "halo", receive(captionsAll).Text)
+
+	// the other languages are filtered out
+	caption = receive(captionsID)
+	require.Equal(t, "halo", caption.Text)
+	require.False(t, caption.Final)
+
+	select {
+	case caption := <-captionsID:
+		t.Fatalf("unexpected caption %s", caption.Text)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	for _, client := range []*Client{speaker, clientAll, clientID} {
+		_ = testRoom.StopClient(client.ID())
+	}
+}
+
+func TestClientCaptionLanguages(t *testing.T) {
+	client := &Client{}
+
+	require.True(t, client.receivesCaptionLanguage("en"))
+
+	client.captions.languages = []string{"en", "pt-BR"}
+
+	require.True(t, client.receivesCaptionLanguage("en"))
+	require.True(t, client.receivesCaptionLanguage("EN-gb"))
+	require.True(t, client.receivesCaptionLanguage("pt-BR"))
+	require.False(t, client.receivesCaptionLanguage("pt"))
+	require.False(t, client.receivesCaptionLanguage("eno"))
+	require.False(t, client.receivesCaptionLanguage("id"))
+}
+
+func waitConnected(t *testing.T, connChan chan webrtc.PeerConnectionState) {
+	t.Helper()
+
+	timeout := time.After(30 * time.Second)
+
+	for {
+		select {
+		case <-timeout:
+			t.Fatal("timeout waiting for the peer connection")
+		case state := <-connChan:
+			if state == webrtc.PeerConnectionStateConnected {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,599 @@
+package sfu
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// the cascade data channel is negotiated out of band on both SFUs with a fixed ID
+	cascadeDataChannelLabel = "cascade"
+	cascadeDataChannelID    = uint16(1000)
+
+	cascadeNodeHeader = "X-Cascade-Node"
+
+	cascadeMessageOffer  = "offer"
+	cascadeMessageAnswer = "answer"
+	cascadeMessageError  = "error"
+)
+
+var (
+	ErrCascadeNoNodeID        = errors.New("cascade: node ID is required")
+	ErrCascadeSameNode        = errors.New("cascade: can't cascade to the same node")
+	ErrCascadeTimeout         = errors.New("cascade: timeout waiting the renegotiation answer")
+	ErrCascadeRejected        = errors.New("cascade: origin rejected the connection")
+	ErrCascadeInvalidResponse = errors.New("cascade: invalid response from the origin")
+)
+
+type CascadeOptions struct {
+	// Path is the prefix of the origin endpoint, the edge posts the offer to {Path}/{roomID}
+	// and the link is deleted through {Path}/{roomID}/{clientID}
+	Path string `json:"path"`
+	// MaxHops is the maximum number of SFUs a track can pass through, zero means unlimited
+	MaxHops int `json:"max_hops"`
+	// NegotiationTimeout is the timeout to wait the answer of a renegotiation from the remote SFU
+	NegotiationTimeout time.Duration `json:"negotiation_timeout"`
+	// ClientOptions used to create the bridge client, the ICE trickle is always disabled
+	ClientOptions ClientOptions `json:"client_options"`
+	// Authorize is called on the origin before a new link is created, return an error to reject the request
+	Authorize func(r *http.Request, roomID, nodeID string) error `json:"-"`
+	// HTTPClient is used by the edge to connect to the origin, http.DefaultClient is used if not set
+	HTTPClient *http.Client `json:"-"`
+}
+
+func DefaultCascadeOptions() CascadeOptions {
+	return CascadeOptions{
+		Path:               "/cascade",
+		MaxHops:            4,
+		NegotiationTimeout: 10 * time.Second,
+		ClientOptions:      DefaultClientOptions(),
+	}
+}
+
+// cascadeTrack is the track information that sent to the edge with the renegotiation offer
+type cascadeTrack struct {
+	ID     string    `json:"id"`
+	Source TrackType `json:"source"`
+	Label  string    `json:"label,omitempty"`
+	// Path is the ID of the nodes the track already passed through, the first one is the node where the track is published
+	Path []string `json:"path"`
+}
+
+type cascadeMessage struct {
+	Type   string                     `json:"type"`
+	SDP    *webrtc.SessionDescription `json:"sdp,omitempty"`
+	Tracks []cascadeTrack             `json:"tracks,omitempty"`
+	Error  string                     `json:"error,omitempty"`
+}
+
+// Cascade is a link between two SFUs. The origin side forwards all tracks of the room to the edge through
+// a bridge client, and the edge side publishes the received tracks to the local room as relay tracks.
+// The keyframe requests from the edge subscribers are forwarded upstream to the publisher through the same link.
+type Cascade struct {
+	mu          sync.Mutex
+	room        *Room
+	client      *Client
+	localNode   string
+	remoteNode  string
+	isOrigin    bool
+	options     CascadeOptions
+	dataChannel *webrtc.DataChannel
+	opened      chan struct{}
+	answers     chan cascadeMessage
+	// tracks that announced by the origin, only used on the edge side
+	tracks map[string]cascadeTrack
+	log    logging.LeveledLogger
+}
+
+func newCascade(room *Room, client *Client, localNode, remoteNode string, isOrigin bool, opts CascadeOptions) (*Cascade, error) {
+	negotiated := true
+	id := cascadeDataChannelID
+
+	dc, err := client.peerConnection.PC().CreateDataChannel(cascadeDataChannelLabel, &webrtc.DataChannelInit{
+		Negotiated: &negotiated,
+		ID:         &id,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cascade{
+		room:        room,
+		client:      client,
+		localNode:   localNode,
+		remoteNode:  remoteNode,
+		isOrigin:    isOrigin,
+		options:     opts,
+		dataChannel: dc,
+		opened:      make(chan struct{}),
+		answers:     make(chan cascadeMessage, 1),
+		tracks:      make(map[string]cascadeTrack),
+		log:         client.log,
+	}
+
+	dc.OnOpen(func() {
+		close(c.opened)
+	})
+
+	dc.OnMessage(c.onMessage)
+
+	if isOrigin {
+		client.OnRenegotiation(c.renegotiate)
+		client.OnTracksAvailable(c.onTracksAvailable)
+	} else {
+		client.OnTracksAdded(c.onTracksAdded)
+	}
+
+	room.addCascade(c)
+
+	client.OnLeft(func() {
+		room.removeCascade(c)
+	})
+
+	return c, nil
+}
+
+// Client returns the bridge client of the link
+func (c *Cascade) Client() *Client {
+	return c.client
+}
+
+// RemoteNode returns the node ID of the SFU on the other side of the link
+func (c *Cascade) RemoteNode() string {
+	return c.remoteNode
+}
+
+// IsOrigin returns true if the local SFU is the origin of the link
+func (c *Cascade) IsOrigin() bool {
+	return c.isOrigin
+}
+
+// Close ends the bridge client, the remote SFU will end its bridge client once the connection is closed
+func (c *Cascade) Close() error {
+	return c.client.End()
+}
+
+// trackPath returns the nodes that the track received from this link passed through, including the remote node
+func (c *Cascade) trackPath(trackID string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append(slices.Clone(c.tracks[trackID].Path), c.remoteNode)
+}
+
+// onTracksAvailable subscribes the origin bridge client to all tracks that never passed through the remote node
+func (c *Cascade) onTracksAvailable(tracks []ITrack) {
+	req := make([]SubscribeTrackRequest, 0)
+
+	for _, track := range tracks {
+		if c.canRelay(track) {
+			req = append(req, SubscribeTrackRequest{ClientID: track.ClientID(), TrackID: track.ID()})
+		}
+	}
+
+	if len(req) == 0 {
+		return
+	}
+
+	if err := c.client.SubscribeTracks(req); err != nil {
+		c.log.Errorf("cascade: failed to subscribe tracks for node %s: %s", c.remoteNode, err.Error())
+	}
+}
+
+// canRelay prevents the loop by checking the path of the track doesn't contain the remote node
+func (c *Cascade) canRelay(track ITrack) bool {
+	path := c.room.trackPath(track)
+
+	if slices.Contains(path, c.remoteNode) {
+		return false
+	}
+
+	return c.options.MaxHops == 0 || len(path) < c.options.MaxHops
+}
+
+// renegotiate sends the offer of the origin bridge client through the data channel and waits the edge answer
+func (c *Cascade) renegotiate(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	timeout, cancel := context.WithTimeout(ctx, c.options.NegotiationTimeout)
+	defer cancel()
+
+	select {
+	case <-c.opened:
+	case <-timeout.Done():
+		return webrtc.SessionDescription{}, ErrCascadeTimeout
+	}
+
+	// drop the stale answer of the previous timed out renegotiation
+	select {
+	case <-c.answers:
+	default:
+	}
+
+	tracks := make([]cascadeTrack, 0)
+
+	for _, clientTrack := range c.client.ClientTracks() {
+		track, err := c.client.publishedTracks.Get(clientTrack.ID())
+		if err != nil {
+			continue
+		}
+
+		tracks = append(tracks, cascadeTrack{
+			ID:     track.ID(),
+			Source: track.SourceType(),
+			Label:  track.Source().Label,
+			Path:   c.room.trackPath(track),
+		})
+	}
+
+	if err := c.send(cascadeMessage{Type: cascadeMessageOffer, SDP: &offer, Tracks: tracks}); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	select {
+	case msg := <-c.answers:
+		if msg.Type == cascadeMessageError || msg.SDP == nil {
+			return webrtc.SessionDescription{}, errors.New(msg.Error)
+		}
+
+		return *msg.SDP, nil
+	case <-timeout.Done():
+		return webrtc.SessionDescription{}, ErrCascadeTimeout
+	}
+}
+
+func (c *Cascade) send(msg cascadeMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return c.dataChannel.SendText(string(data))
+}
+
+func (c *Cascade) onMessage(dcMsg webrtc.DataChannelMessage) {
+	var msg cascadeMessage
+	if err := json.Unmarshal(dcMsg.Data, &msg); err != nil {
+		c.log.Errorf("cascade: failed to decode message: %s", err.Error())
+		return
+	}
+
+	switch msg.Type {
+	case cascadeMessageAnswer, cascadeMessageError:
+		select {
+		case c.answers <- msg:
+		default:
+		}
+	case cascadeMessageOffer:
+		// answer in a goroutine to not block the data channel while the tracks are negotiated
+		go c.answer(msg)
+	}
+}
+
+// answer handles the renegotiation offer from the origin on the edge side
+func (c *Cascade) answer(msg cascadeMessage) {
+	if msg.SDP == nil {
+		return
+	}
+
+	c.mu.Lock()
+	for _, track := range msg.Tracks {
+		c.tracks[track.ID] = track
+	}
+	c.mu.Unlock()
+
+	answer, err := c.client.Negotiate(*msg.SDP)
+	if err != nil {
+		c.log.Errorf("cascade: failed to answer the renegotiation from node %s: %s", c.remoteNode, err.Error())
+		_ = c.send(cascadeMessage{Type: cascadeMessageError, Error: err.Error()})
+
+		return
+	}
+
+	if err := c.send(cascadeMessage{Type: cascadeMessageAnswer, SDP: answer}); err != nil {
+		c.log.Errorf("cascade: failed to send the answer to node %s: %s", c.remoteNode, err.Error())
+	}
+}
+
+// onTracksAdded publishes the tracks from the origin to the edge room. A track that's already in the room
+// is ignored, this happens when the same track is received from multiple links.
+func (c *Cascade) onTracksAdded(tracks []ITrack) {
+	setTracks := make(map[string]TrackSource)
+
+	c.mu.Lock()
+	for _, track := range tracks {
+		if c.room.hasTrack(track.ID(), c.client.ID()) {
+			c.log.Warnf("cascade: track %s from node %s is already in the room", track.ID(), c.remoteNode)
+			continue
+		}
+
+		setTracks[track.ID()] = TrackSource{Type: TrackTypeMedia}
+
+		if announced, ok := c.tracks[track.ID()]; ok && announced.Source != "" {
+			setTracks[track.ID()] = TrackSource{Type: announced.Source, Label: announced.Label}
+		}
+	}
+	c.mu.Unlock()
+
+	if len(setTracks) > 0 {
+		c.client.SetTracksSource(setTracks)
+	}
+}
+
+// Cascades returns the active cascade links of the room
+func (r *Room) Cascades() []*Cascade {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cascades := make([]*Cascade, 0, len(r.cascades))
+	for _, c := range r.cascades {
+		cascades = append(cascades, c)
+	}
+
+	return cascades
+}
+
+func (r *Room) addCascade(c *Cascade) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cascades[c.client.ID()] = c
+}
+
+func (r *Room) removeCascade(c *Cascade) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.cascades, c.client.ID())
+}
+
+// trackPath returns the nodes the track passed through before this node, empty if the track is published locally
+func (r *Room) trackPath(track ITrack) []string {
+	r.mu.RLock()
+	c, ok := r.cascades[track.ClientID()]
+	rtpCascade, rtpOK := r.rtpCascades[track.ClientID()]
+	relay, relayOK := r.relays[track.ClientID()]
+	r.mu.RUnlock()
+
+	switch {
+	case ok && !c.isOrigin:
+		return c.trackPath(track.ID())
+	case rtpOK:
+		return rtpCascade.trackPath(track.ID())
+	case relayOK:
+		return relay.trackPath(track.ID())
+	}
+
+	return []string{}
+}
+
+// hasTrack checks if the track is already published by other client than the given client ID
+func (r *Room) hasTrack(trackID, exceptClientID string) bool {
+	for _, client := range r.sfu.GetClients() {
+		if client.ID() == exceptClientID {
+			continue
+		}
+
+		if _, err := client.tracks.Get(trackID); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CascadeHandler is a http.Handler on the origin SFU that accepts the cascade links from the edge SFUs.
+// The edge posts its offer to {Path}/{roomID} with the node ID in the X-Cascade-Node header.
+type CascadeHandler struct {
+	manager *Manager
+	options CascadeOptions
+	log     logging.LeveledLogger
+}
+
+func NewCascadeHandler(manager *Manager, opts CascadeOptions) *CascadeHandler {
+	opts.Path = "/" + strings.Trim(opts.Path, "/")
+	opts.ClientOptions.IceTrickle = false
+
+	return &CascadeHandler{
+		manager: manager,
+		options: opts,
+		log:     manager.log,
+	}
+}
+
+func (h *CascadeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	roomID, clientID, err := parseSessionPath(h.options.Path, r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && clientID == "":
+		h.accept(w, r, roomID)
+	case r.Method == http.MethodDelete && clientID != "":
+		stopSession(w, h.manager, roomID, clientID)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *CascadeHandler) accept(w http.ResponseWriter, r *http.Request, roomID string) {
+	nodeID := r.Header.Get(cascadeNodeHeader)
+	if nodeID == "" {
+		http.Error(w, ErrCascadeNoNodeID.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if nodeID == h.manager.Name() {
+		http.Error(w, ErrCascadeSameNode.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if h.options.Authorize != nil {
+		if err := h.options.Authorize(r, roomID, nodeID); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	room, err := h.manager.GetRoom(roomID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	offer, err := readSDPOffer(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clientOpts := h.options.ClientOptions
+	clientOpts.Type = ClientTypeDownBridge
+
+	clientID := room.CreateClientID()
+
+	client, err := room.AddClient(clientID, nodeID, clientOpts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := newCascade(room, client, h.manager.Name(), nodeID, true, h.options); err != nil {
+		_ = room.StopClient(clientID)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	answer, err := client.NegotiateContext(r.Context(), *offer)
+	if err != nil {
+		h.log.Errorf("cascade: error negotiate node %s: %s", nodeID, err.Error())
+		_ = room.StopClient(clientID)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	h.log.Infof("cascade: node %s is connected to room %s", nodeID, roomID)
+
+	w.Header().Set(cascadeNodeHeader, h.manager.Name())
+	writeSDPAnswer(w, h.manager.iceServers, h.options.Path+"/"+roomID+"/"+clientID, answer)
+}
+
+// ConnectCascade connects the local room as an edge of the room on the origin SFU. The originURL is the
+// origin cascade endpoint including the room ID, for example https://origin.example.com/cascade/{roomID}.
+// The manager name is used as the node ID, so it must be unique across the SFUs in the cascade.
+func (m *Manager) ConnectCascade(ctx context.Context, roomID, originURL string, opts CascadeOptions) (*Cascade, error) {
+	room, err := m.GetRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.name == "" {
+		return nil, ErrCascadeNoNodeID
+	}
+
+	clientOpts := opts.ClientOptions
+	clientOpts.Type = ClientTypeUpBridge
+	clientOpts.IceTrickle = false
+
+	clientID := room.CreateClientID()
+
+	client, err := room.AddClient(clientID, originURL, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	cascade, err := m.connectCascade(ctx, room, client, originURL, opts)
+	if err != nil {
+		_ = room.StopClient(clientID)
+		return nil, err
+	}
+
+	return cascade, nil
+}
+
+func (m *Manager) connectCascade(ctx context.Context, room *Room, client *Client, originURL string, opts CascadeOptions) (*Cascade, error) {
+	// the origin node ID is not known yet, it's updated from the answer header
+	cascade, err := newCascade(room, client, m.name, "", false, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := client.peerConnection.PC()
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-gatherComplete:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, originURL, bytes.NewBufferString(pc.LocalDescription().SDP))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", whipContentType)
+	req.Header.Set(cascadeNodeHeader, m.name)
+
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSDPSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		m.log.Errorf("cascade: origin %s rejected the connection: %d %s", originURL, resp.StatusCode, strings.TrimSpace(string(body)))
+		return nil, ErrCascadeRejected
+	}
+
+	nodeID := resp.Header.Get(cascadeNodeHeader)
+	if nodeID == "" || len(body) == 0 {
+		return nil, ErrCascadeInvalidResponse
+	}
+
+	cascade.mu.Lock()
+	cascade.remoteNode = nodeID
+	cascade.mu.Unlock()
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(body)}); err != nil {
+		return nil, err
+	}
+
+	client.canAddCandidate.Store(true)
+
+	return cascade, nil
+}
@@ -0,0 +1,218 @@
+package sfu
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCascade(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	originManager := NewManager(ctx, "origin", sfuOpts)
+	defer originManager.Close()
+
+	edgeManager := NewManager(ctx, "edge", sfuOpts)
+	defer edgeManager.Close()
+
+	originRoom, err := originManager.NewRoom(originManager.CreateRoomID(), "origin-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	edgeRoom, err := edgeManager.NewRoom(edgeManager.CreateRoomID(), "edge-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	opts := DefaultCascadeOptions()
+	opts.ClientOptions.IdleTimeout = 10 * time.Second
+
+	server := httptest.NewServer(NewCascadeHandler(originManager, opts))
+	defer server.Close()
+
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, originRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	cascade, err := edgeManager.ConnectCascade(ctx, edgeRoom.ID(), server.URL+opts.Path+"/"+originRoom.ID(), opts)
+	require.NoError(t, err)
+	require.Equal(t, "origin", cascade.RemoteNode())
+	require.False(t, cascade.IsOrigin())
+
+	_, subscriber, _, _ := CreatePeerPair(ctx, TestLogger, edgeRoom, DefaultTestIceServers(), "subscriber", true, false, true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	// the subscriber on the edge receives the publisher tracks from the origin
+	received := func() bool {
+		count := 0
+
+		for _, track := range publisher.Tracks() {
+			if _, ok := subscriber.ClientTracks()[track.ID()]; ok {
+				count++
+			}
+		}
+
+		return count == 2
+	}
+
+	for !received() {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the cascaded tracks")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// the origin has a down bridge client for the edge
+	originCascades := originRoom.Cascades()
+	require.Len(t, originCascades, 1)
+	require.True(t, originCascades[0].IsOrigin())
+	require.Equal(t, "edge", originCascades[0].RemoteNode())
+	require.Equal(t, ClientTypeDownBridge, originCascades[0].Client().Type())
+
+	// the path of the cascaded tracks contains the origin node
+	for _, track := range cascade.Client().Tracks() {
+		require.Equal(t, []string{"origin"}, edgeRoom.trackPath(track))
+	}
+
+	// link the rooms in the other direction, the origin tracks must not be relayed back to the origin
+	edgeServer := httptest.NewServer(NewCascadeHandler(edgeManager, opts))
+	defer edgeServer.Close()
+
+	reverse, err := originManager.ConnectCascade(ctx, originRoom.ID(), edgeServer.URL+opts.Path+"/"+edgeRoom.ID(), opts)
+	require.NoError(t, err)
+
+	for len(reverse.Client().Tracks()) < 2 {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the reverse cascaded tracks")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	for _, track := range reverse.Client().Tracks() {
+		_, err := subscriber.tracks.Get(track.ID())
+		require.NoError(t, err, "only the edge tracks are relayed to the origin")
+	}
+
+	for _, c := range edgeRoom.Cascades() {
+		if c.IsOrigin() {
+			for id := range c.Client().ClientTracks() {
+				_, err := publisher.tracks.Get(id)
+				require.Error(t, err, "origin track %s is relayed back to the origin", id)
+			}
+		}
+	}
+
+	require.NoError(t, reverse.Close())
+	require.NoError(t, cascade.Close())
+
+	_ = originRoom.StopClient(publisher.ID())
+	_ = edgeRoom.StopClient(subscriber.ID())
+}
+
+func TestCascadeRTP(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	originManager := NewManager(ctx, "origin", sfuOpts)
+	defer originManager.Close()
+
+	edgeManager := NewManager(ctx, "edge", sfuOpts)
+	defer edgeManager.Close()
+
+	originRoom, err := originManager.NewRoom(originManager.CreateRoomID(), "origin-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	edgeRoom, err := edgeManager.NewRoom(edgeManager.CreateRoomID(), "edge-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	originConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	edgeConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, originRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	origin, err := originManager.ServeCascadeRTP(originRoom.ID(), originConn, DefaultCascadeOptions())
+	require.NoError(t, err)
+
+	edge, err := edgeManager.ConnectCascadeRTP(edgeRoom.ID(), edgeConn, originConn.LocalAddr(), DefaultCascadeOptions())
+	require.NoError(t, err)
+
+	_, subscriber, _, _ := CreatePeerPair(ctx, TestLogger, edgeRoom, DefaultTestIceServers(), "subscriber", true, false, true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	wait := func(msg string, cond func() bool) {
+		for !cond() {
+			select {
+			case <-timeout.Done():
+				t.Fatal(msg)
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+
+	wait("timeout waiting for the cascaded tracks", func() bool {
+		if len(publisher.Tracks()) < 2 {
+			return false
+		}
+
+		for _, track := range publisher.Tracks() {
+			if _, ok := subscriber.ClientTracks()[track.ID()]; !ok {
+				return false
+			}
+		}
+
+		return true
+	})
+
+	require.Equal(t, "edge", origin.RemoteNode())
+	require.Equal(t, "origin", edge.RemoteNode())
+
+	// the media is relayed to the edge
+	var packets atomic.Int32
+
+	for _, track := range publisher.Tracks() {
+		edgeRoom.sfu.mu.Lock()
+		relayTrack := edgeRoom.sfu.relayTracks[track.ID()]
+		edgeRoom.sfu.mu.Unlock()
+
+		require.NotNil(t, relayTrack)
+		require.Equal(t, []string{"origin"}, edgeRoom.trackPath(relayTrack))
+
+		relayTrack.OnRead(func(_ interceptor.Attributes, _ *rtp.Packet, _ QualityLevel) {
+			packets.Add(1)
+		})
+	}
+
+	wait("timeout waiting for the relayed packets", func() bool {
+		return packets.Load() > 10
+	})
+
+	require.NoError(t, edge.Close())
+	require.ErrorIs(t, edge.Close(), ErrRTPCascadeClosed)
+	require.NoError(t, origin.Close())
+
+	// the relay tracks are ended once the link is closed
+	wait("timeout waiting for the relay tracks ended", func() bool {
+		return !edgeRoom.sfu.hasRelayTrack(publisher.Tracks()[0].ID())
+	})
+
+	_ = originRoom.StopClient(publisher.ID())
+	_ = edgeRoom.StopClient(subscriber.ID())
+}
@@ -0,0 +1,594 @@
+package sfu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// name of the RTCP APP packets that carry the cascade signaling
+	rtpCascadeAppName = "CSCD"
+
+	rtpCascadeSubTypeHello = 0
+	rtpCascadeSubTypeTrack = 1
+
+	rtpCascadeAnnounceInterval = time.Second
+	// the link is closed when no packet is received from the remote node for this duration
+	rtpCascadeTimeout = 5 * time.Second
+
+	rtpCascadeChannelSize = 512
+)
+
+var (
+	ErrRTPCascadeTimeout = errors.New("cascade: no packet received from the remote node")
+	ErrRTPCascadeClosed  = errors.New("cascade: rtp link is closed")
+)
+
+type rtpCascadeHello struct {
+	Node string `json:"node"`
+}
+
+// rtpCascadeTrack is the track announcement that sent periodically by the origin
+type rtpCascadeTrack struct {
+	cascadeTrack
+	SSRC     uint32              `json:"ssrc"`
+	StreamID string              `json:"stream_id"`
+	Kind     webrtc.RTPCodecType `json:"kind"`
+	MimeType string              `json:"mime_type"`
+	Ended    bool                `json:"ended,omitempty"`
+}
+
+type rtpCascadeSource struct {
+	track ITrack
+	info  rtpCascadeTrack
+}
+
+type rtpCascadeRelay struct {
+	info    rtpCascadeTrack
+	rtpChan chan *rtp.Packet
+}
+
+// RTPCascade is a cascade link that forwards the tracks as plain RTP over UDP. The RTP and RTCP packets are
+// multiplexed on the same socket, and the track announcements are sent as RTCP APP packets.
+// Unlike the WebRTC cascade the media is not encrypted, so only use it in a private network between the SFUs.
+type RTPCascade struct {
+	mu         sync.Mutex
+	context    context.Context
+	cancel     context.CancelFunc
+	room       *Room
+	conn       net.PacketConn
+	remoteAddr net.Addr
+	localNode  string
+	remoteNode string
+	isOrigin   bool
+	options    CascadeOptions
+	// origin side, the tracks that forwarded to the edge by the track ID
+	sources map[string]*rtpCascadeSource
+	// edge side, the bridge client that owns the relay tracks and the relay tracks by the SSRC
+	client       *Client
+	relays       map[uint32]*rtpCascadeRelay
+	lastReceived atomic.Int64
+	onClosed     func(error)
+	log          logging.LeveledLogger
+}
+
+// ServeCascadeRTP waits an edge to connect to the conn and forwards all tracks of the room to the edge once connected.
+// Only one edge is served on a conn, create a new conn for every edge.
+func (m *Manager) ServeCascadeRTP(roomID string, conn net.PacketConn, opts CascadeOptions) (*RTPCascade, error) {
+	room, err := m.GetRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.name == "" {
+		return nil, ErrCascadeNoNodeID
+	}
+
+	c := newRTPCascade(room, conn, m.name, true, opts)
+
+	go c.readLoop()
+
+	return c, nil
+}
+
+// ConnectCascadeRTP connects the local room as an edge of the origin that served on the originAddr with ServeCascadeRTP.
+func (m *Manager) ConnectCascadeRTP(roomID string, conn net.PacketConn, originAddr net.Addr, opts CascadeOptions) (*RTPCascade, error) {
+	room, err := m.GetRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.name == "" {
+		return nil, ErrCascadeNoNodeID
+	}
+
+	c := newRTPCascade(room, conn, m.name, false, opts)
+	c.remoteAddr = originAddr
+
+	clientOpts := opts.ClientOptions
+	clientOpts.Type = ClientTypeUpBridge
+
+	// the bridge client never connects, it's only the owner of the relay tracks in the room
+	c.client = room.sfu.NewClient(room.CreateClientID(), originAddr.String(), clientOpts)
+
+	room.mu.Lock()
+	room.rtpCascades[c.client.ID()] = c
+	room.mu.Unlock()
+
+	go c.readLoop()
+	go c.helloLoop()
+
+	return c, nil
+}
+
+func newRTPCascade(room *Room, conn net.PacketConn, localNode string, isOrigin bool, opts CascadeOptions) *RTPCascade {
+	ctx, cancel := context.WithCancel(room.context)
+
+	c := &RTPCascade{
+		context:   ctx,
+		cancel:    cancel,
+		room:      room,
+		conn:      conn,
+		localNode: localNode,
+		isOrigin:  isOrigin,
+		options:   opts,
+		sources:   make(map[string]*rtpCascadeSource),
+		relays:    make(map[uint32]*rtpCascadeRelay),
+		log:       room.sfu.log,
+	}
+
+	c.lastReceived.Store(time.Now().UnixNano())
+
+	return c
+}
+
+// RemoteNode returns the node ID of the SFU on the other side of the link, empty until the edge is connected
+func (c *RTPCascade) RemoteNode() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.remoteNode
+}
+
+// IsOrigin returns true if the local SFU is the origin of the link
+func (c *RTPCascade) IsOrigin() bool {
+	return c.isOrigin
+}
+
+// OnClosed event is called once the link is closed, the error is ErrRTPCascadeTimeout if the remote node is not reachable
+func (c *RTPCascade) OnClosed(callback func(error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onClosed = callback
+}
+
+// Close stops the link and closes the conn, the relay tracks on the edge are ended
+func (c *RTPCascade) Close() error {
+	return c.close(nil)
+}
+
+func (c *RTPCascade) close(reason error) error {
+	c.mu.Lock()
+
+	if c.context.Err() != nil {
+		c.mu.Unlock()
+		return ErrRTPCascadeClosed
+	}
+
+	c.cancel()
+
+	for ssrc, relay := range c.relays {
+		close(relay.rtpChan)
+		delete(c.relays, ssrc)
+	}
+
+	onClosed := c.onClosed
+	c.mu.Unlock()
+
+	if c.client != nil {
+		c.room.mu.Lock()
+		delete(c.room.rtpCascades, c.client.ID())
+		c.room.mu.Unlock()
+
+		_ = c.client.stop()
+	}
+
+	err := c.conn.Close()
+
+	if onClosed != nil {
+		onClosed(reason)
+	}
+
+	return err
+}
+
+// trackPath returns the nodes that the relay track passed through, including the remote node
+func (c *RTPCascade) trackPath(trackID string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, relay := range c.relays {
+		if relay.info.ID == trackID {
+			return append(slices.Clone(relay.info.Path), c.remoteNode)
+		}
+	}
+
+	return []string{c.remoteNode}
+}
+
+func (c *RTPCascade) readLoop() {
+	buf := make([]byte, 1500)
+
+	go c.watchTimeout()
+
+	for {
+		n, addr, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			if c.context.Err() == nil {
+				c.log.Errorf("cascade: rtp link read error: %s", err.Error())
+				_ = c.close(err)
+			}
+
+			return
+		}
+
+		c.mu.Lock()
+		remoteAddr := c.remoteAddr
+		c.mu.Unlock()
+
+		// the origin accepts the first edge that says hello, the packets from other addresses are ignored
+		if remoteAddr != nil && addr.String() != remoteAddr.String() {
+			continue
+		}
+
+		c.lastReceived.Store(time.Now().UnixNano())
+
+		// RTP and RTCP multiplexing https://datatracker.ietf.org/doc/html/rfc5761#section-4
+		if n >= 2 && buf[1] >= 192 && buf[1] <= 223 {
+			c.handleRTCP(addr, buf[:n])
+		} else if !c.isOrigin {
+			c.handleRTP(buf[:n])
+		}
+	}
+}
+
+func (c *RTPCascade) watchTimeout() {
+	ticker := time.NewTicker(rtpCascadeAnnounceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.context.Done():
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, c.lastReceived.Load())) > rtpCascadeTimeout && c.RemoteNode() != "" {
+				c.log.Warnf("cascade: rtp link to node %s is timeout", c.RemoteNode())
+				_ = c.close(ErrRTPCascadeTimeout)
+
+				return
+			}
+
+			if c.isOrigin {
+				c.announceTracks()
+			}
+		}
+	}
+}
+
+// helloLoop sends the node ID to the origin until the link is closed, it's also the keep alive of the link
+func (c *RTPCascade) helloLoop() {
+	ticker := time.NewTicker(rtpCascadeAnnounceInterval)
+	defer ticker.Stop()
+
+	for {
+		c.sendApp(rtpCascadeSubTypeHello, rtpCascadeHello{Node: c.localNode})
+
+		select {
+		case <-c.context.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *RTPCascade) sendApp(subType uint8, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		c.log.Errorf("cascade: failed to encode rtp link message: %s", err.Error())
+		return
+	}
+
+	c.writeRTCP(&rtcp.ApplicationDefined{SubType: subType, Name: rtpCascadeAppName, Data: data})
+}
+
+func (c *RTPCascade) writeRTCP(p rtcp.Packet) {
+	c.mu.Lock()
+	remoteAddr := c.remoteAddr
+	c.mu.Unlock()
+
+	if remoteAddr == nil {
+		return
+	}
+
+	buf, err := p.Marshal()
+	if err != nil {
+		c.log.Errorf("cascade: failed to marshal rtcp: %s", err.Error())
+		return
+	}
+
+	if _, err := c.conn.WriteTo(buf, remoteAddr); err != nil && c.context.Err() == nil {
+		c.log.Errorf("cascade: failed to write rtcp: %s", err.Error())
+	}
+}
+
+func (c *RTPCascade) handleRTCP(addr net.Addr, buf []byte) {
+	packets, err := rtcp.Unmarshal(buf)
+	if err != nil {
+		c.log.Errorf("cascade: failed to unmarshal rtcp: %s", err.Error())
+		return
+	}
+
+	for _, packet := range packets {
+		switch p := packet.(type) {
+		case *rtcp.ApplicationDefined:
+			if p.Name != rtpCascadeAppName {
+				continue
+			}
+
+			switch {
+			case p.SubType == rtpCascadeSubTypeHello:
+				var hello rtpCascadeHello
+				if err := json.Unmarshal(p.Data, &hello); err == nil {
+					c.onHello(addr, hello)
+				}
+			case p.SubType == rtpCascadeSubTypeTrack && !c.isOrigin:
+				var info rtpCascadeTrack
+				if err := json.Unmarshal(p.Data, &info); err == nil {
+					c.onTrackAnnounced(info)
+				}
+			}
+		case *rtcp.PictureLossIndication:
+			c.onPLI(p.MediaSSRC)
+		case *rtcp.FullIntraRequest:
+			c.onPLI(p.MediaSSRC)
+		}
+	}
+}
+
+// onHello starts forwarding the room tracks to the first edge that connected.
+// Both sides keep sending the hello as the keep alive of the link.
+func (c *RTPCascade) onHello(addr net.Addr, hello rtpCascadeHello) {
+	c.mu.Lock()
+	if !c.isOrigin && c.remoteNode == "" {
+		c.remoteNode = hello.Node
+	}
+
+	if !c.isOrigin || c.remoteAddr != nil {
+		c.mu.Unlock()
+		return
+	}
+
+	if hello.Node == "" || hello.Node == c.localNode {
+		c.mu.Unlock()
+		c.log.Warnf("cascade: rtp link from %s rejected, invalid node ID %q", addr.String(), hello.Node)
+
+		return
+	}
+
+	c.remoteAddr = addr
+	c.remoteNode = hello.Node
+	c.mu.Unlock()
+
+	c.log.Infof("cascade: node %s is connected to room %s through rtp", hello.Node, c.room.ID())
+
+	go c.helloLoop()
+
+	c.room.sfu.OnTracksAvailable(func(tracks []ITrack) {
+		for _, track := range tracks {
+			c.addSource(track)
+		}
+	})
+
+	for _, client := range c.room.sfu.GetClients() {
+		for _, track := range client.Tracks() {
+			c.addSource(track)
+		}
+	}
+}
+
+// addSource forwards the track to the edge if the track never passed through the edge node
+func (c *RTPCascade) addSource(track ITrack) {
+	if c.context.Err() != nil {
+		return
+	}
+
+	path := c.room.trackPath(track)
+
+	c.mu.Lock()
+
+	if slices.Contains(path, c.remoteNode) || (c.options.MaxHops > 0 && len(path) >= c.options.MaxHops) {
+		c.mu.Unlock()
+		return
+	}
+
+	if _, ok := c.sources[track.ID()]; ok {
+		c.mu.Unlock()
+		return
+	}
+
+	source := &rtpCascadeSource{
+		track: track,
+		info: rtpCascadeTrack{
+			cascadeTrack: cascadeTrack{
+				ID:     track.ID(),
+				Source: track.SourceType(),
+				Label:  track.Source().Label,
+				Path:   path,
+			},
+			SSRC:     rand.Uint32(),
+			StreamID: track.StreamID(),
+			Kind:     track.Kind(),
+			MimeType: track.MimeType(),
+		},
+	}
+
+	c.sources[track.ID()] = source
+	c.mu.Unlock()
+
+	c.sendApp(rtpCascadeSubTypeTrack, source.info)
+
+	track.OnRead(func(attrs interceptor.Attributes, p *rtp.Packet, quality QualityLevel) {
+		// only the high layer of a simulcast track is forwarded
+		if track.IsSimulcast() && quality != QualityHigh {
+			return
+		}
+
+		c.writeRTP(source.info.SSRC, p)
+	})
+
+	track.OnEnded(func() {
+		c.mu.Lock()
+		delete(c.sources, track.ID())
+		c.mu.Unlock()
+
+		source.info.Ended = true
+		c.sendApp(rtpCascadeSubTypeTrack, source.info)
+	})
+
+	requestKeyframe(track)
+}
+
+func (c *RTPCascade) writeRTP(ssrc uint32, p *rtp.Packet) {
+	c.mu.Lock()
+	remoteAddr := c.remoteAddr
+	c.mu.Unlock()
+
+	if remoteAddr == nil || c.context.Err() != nil {
+		return
+	}
+
+	// the packet is shared with the other readers, copy the header before rewriting the SSRC
+	packet := rtp.Packet{Header: p.Header, Payload: p.Payload}
+	packet.Header.SSRC = ssrc
+
+	buf, err := packet.Marshal()
+	if err != nil {
+		c.log.Errorf("cascade: failed to marshal rtp: %s", err.Error())
+		return
+	}
+
+	if _, err := c.conn.WriteTo(buf, remoteAddr); err != nil && c.context.Err() == nil {
+		c.log.Errorf("cascade: failed to write rtp: %s", err.Error())
+	}
+}
+
+// announceTracks sends the announcement of all forwarded tracks, so the edge can recover from a lost announcement
+func (c *RTPCascade) announceTracks() {
+	c.mu.Lock()
+	infos := make([]rtpCascadeTrack, 0, len(c.sources))
+	for _, source := range c.sources {
+		infos = append(infos, source.info)
+	}
+	c.mu.Unlock()
+
+	for _, info := range infos {
+		c.sendApp(rtpCascadeSubTypeTrack, info)
+	}
+}
+
+func (c *RTPCascade) onPLI(ssrc uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, source := range c.sources {
+		if source.info.SSRC == ssrc {
+			requestKeyframe(source.track)
+			return
+		}
+	}
+}
+
+// onTrackAnnounced adds a relay track to the edge room for a new announced track, or ends the relay track
+func (c *RTPCascade) onTrackAnnounced(info rtpCascadeTrack) {
+	c.mu.Lock()
+
+	relay, ok := c.relays[info.SSRC]
+
+	if info.Ended {
+		if ok {
+			close(relay.rtpChan)
+			delete(c.relays, info.SSRC)
+		}
+
+		c.mu.Unlock()
+
+		return
+	}
+
+	if ok || c.context.Err() != nil {
+		c.mu.Unlock()
+		return
+	}
+
+	c.mu.Unlock()
+
+	if c.room.hasTrack(info.ID, c.client.ID()) || c.room.sfu.hasRelayTrack(info.ID) {
+		c.log.Warnf("cascade: track %s from node %s is already in the room", info.ID, c.remoteNode)
+		return
+	}
+
+	relay = &rtpCascadeRelay{
+		info:    info,
+		rtpChan: make(chan *rtp.Packet, rtpCascadeChannelSize),
+	}
+
+	c.mu.Lock()
+	c.relays[info.SSRC] = relay
+	c.mu.Unlock()
+
+	// the keyframe request from the edge subscribers is forwarded to the origin
+	onPLI := func() {
+		c.writeRTCP(&rtcp.PictureLossIndication{MediaSSRC: info.SSRC})
+	}
+
+	remoteTrack := NewTrackRelay(info.ID, info.StreamID, "", info.Kind, webrtc.SSRC(info.SSRC), info.MimeType, relay.rtpChan)
+
+	c.room.sfu.addRelayTrack(c.context, remoteTrack, c.client, TrackSource{Type: info.Source, Label: info.Label}, onPLI)
+}
+
+func (c *RTPCascade) handleRTP(buf []byte) {
+	// the buffer is reused for the next packet
+	p := &rtp.Packet{}
+	if err := p.Unmarshal(slices.Clone(buf)); err != nil {
+		c.log.Errorf("cascade: failed to unmarshal rtp: %s", err.Error())
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	relay, ok := c.relays[p.SSRC]
+	if !ok {
+		return
+	}
+
+	select {
+	case relay.rtpChan <- p:
+	default:
+		c.log.Warnf("cascade: relay track %s buffer is full, packet is dropped", relay.info.ID)
+	}
+}
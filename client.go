@@ -12,17 +12,23 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/inlivedev/sfu/pkg/interceptors/avsync"
+	"github.com/inlivedev/sfu/pkg/interceptors/fec"
+	"github.com/inlivedev/sfu/pkg/interceptors/nackresponder"
 	"github.com/inlivedev/sfu/pkg/interceptors/playoutdelay"
 	"github.com/inlivedev/sfu/pkg/interceptors/voiceactivedetector"
+	"github.com/inlivedev/sfu/pkg/logger"
 	"github.com/inlivedev/sfu/pkg/networkmonitor"
 	"github.com/pion/interceptor"
 	"github.com/pion/interceptor/pkg/cc"
 	"github.com/pion/interceptor/pkg/gcc"
 	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/interceptor/pkg/report"
 	"github.com/pion/interceptor/pkg/stats"
 	"github.com/pion/logging"
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v4"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ClientState int
@@ -38,6 +44,8 @@ const (
 	ClientTypeUpBridge   = "upbridge"
 	ClientTypeDownBridge = "downbridge"
 
+	// the video quality levels below the audio are the matrix of the spatial layers (High, Mid, Low) and the temporal layers,
+	// for example QualityHighLow is the high resolution with the lowest frame rate. See QualityPresets for the layers.
 	QualityAudioRed = 11
 	QualityAudio    = 10
 	QualityHigh     = 9
@@ -55,14 +63,64 @@ const (
 	messageTypeStats      = "stats"
 	messageTypeVADStarted = "vad_started"
 	messageTypeVADEnded   = "vad_ended"
+	// subscribe the tracks that match the filters, sent by the client
+	messageTypeSubscribeTracks = "subscribe_tracks"
+	// the audio levels of the speaking clients, sent to the client
+	messageTypeAudioLevels = "audio_levels"
+	// pin the quality of a subscribed track, sent by the client
+	messageTypeTrackQuality = "track_quality"
+	// the DTMF event from the SIP bridge, sent to the client
+	messageTypeDTMF = "dtmf"
+	// the room or a client metadata is changed, sent to the client
+	messageTypeMetadataChanged = "metadata_changed"
+	// the published tracks of a kind are muted or unmuted by a moderator, sent to the client
+	messageTypeForceMuted = "force_muted"
+	// a published track is muted or unmuted by the server, sent to the client
+	messageTypeTrackMuted = "track_muted"
+	// the client is kicked from the room, sent to the client before the peer connection is closed
+	messageTypeKicked = "kicked"
+	// the server is draining, the client should reconnect to the URL in the message, sent to the client
+	messageTypeMigrate = "migrate"
+	// the ICE servers of the client are updated before the ICE restart, sent to the client
+	messageTypeICEServers = "ice_servers"
+	// pause or resume a subscribed video track, sent by the client
+	messageTypeTrackPause = "track_pause"
+	// the encoding hints of a published screen track, sent to the client after the source type is set
+	messageTypeScreenProfile = "screen_profile"
+	// the source types and labels of the published tracks, sent by the client
+	messageTypeTrackSources = "track_sources"
+	// the simulcast layers that no subscriber needs, the client should stop sending them until they're needed again, sent to the client
+	messageTypeSimulcastLayersPaused = "simulcast_layers_paused"
+	// the published video track has no subscriber and it's not forwarded, the client can stop sending it until it's resumed, sent to the client
+	messageTypePublishPaused = "publish_paused"
+	// a published media section or track violates the publish constraints and it's not accepted, sent to the client
+	messageTypePublishRejected = "publish_rejected"
+	// the tracks that published in the room with their descriptors, sent to the client on connect and on every change
+	messageTypeTrackCatalog = "track_catalog"
+	// the client is waiting in the lobby or admitted to the room, sent to the client
+	messageTypeLobby = "lobby"
+	// the room is closing by a policy like the max duration, sent to the clients before the room is closed
+	messageTypeRoomClosing = "room_closing"
+	// the subscribed video track is frozen or recovered, sent to the client
+	messageTypeTrackFrozen = "track_frozen"
+	// the reason that a subscribed video is forwarded below the high quality is changed, sent to the client
+	messageTypeQualityLimitation = "quality_limitation"
+	// the rendered size of the subscribed videos, sent by the client when the layout is changed
+	messageTypeVideoSizes = "video_sizes"
+	// the priority of a subscribed video in the bitrate allocation, sent by the client
+	messageTypeSubscriptionPriority = "subscription_priority"
+	// the languages of the captions that the client receives, sent by the client
+	messageTypeCaptionLanguages = "caption_languages"
 )
 
 type QualityLevel uint32
 
 var (
-	ErrNegotiationIsNotRequested = errors.New("client: error negotiation is called before requested")
-	ErrRenegotiationCallback     = errors.New("client: error renegotiation callback is not set")
-	ErrClientStoped              = errors.New("client: error client already stopped")
+	ErrNegotiationIsNotRequested  = errors.New("client: error negotiation is called before requested")
+	ErrRenegotiationCallback      = errors.New("client: error renegotiation callback is not set")
+	ErrClientStoped               = errors.New("client: error client already stopped")
+	ErrClientNotConnected         = errors.New("client: error client is ended before connected")
+	ErrRenegotiationInvalidAnswer = errors.New("client: error renegotiation answer is not an answer type")
 )
 
 type ClientOptions struct {
@@ -88,10 +146,83 @@ type ClientOptions struct {
 	JitterBufferMinWait time.Duration `json:"jitter_buffer_min_wait"`
 	JitterBufferMaxWait time.Duration `json:"jitter_buffer_max_wait"`
 	// On unstable network, the packets can be arrived unordered which may affected the nack and packet loss counts, set this to true to allow the SFU to handle reordered packet
+	// with an adaptive jitter buffer on each published track. A missing packet is waited for the target delay that follows the jitter of the track, it's kept between
+	// JitterBufferMinWait and JitterBufferMaxWait. It adds the delay on the packet loss, see RoomOptions.DisableJitterBuffer for the low-latency room
 	ReorderPackets bool `json:"reorder_packets"`
-	Log            logging.LeveledLogger
-	settingEngine  webrtc.SettingEngine
-	qualityLevels  []QualityLevel
+	// Configure the number of the last sent packets of each subscribed track that kept to answer the NACKs from the client.
+	// Bigger cache recovers longer packet loss bursts but uses more memory per subscribed track, default is 1024 packets
+	NACKCacheSize int `json:"nack_cache_size"`
+	// Configure the maximum age of the cached packet to retransmit, the client most likely already gave up on the older packet.
+	// Default is 1 second, set to 0 to keep the packets until they're replaced by the newer packets
+	NACKCacheDuration time.Duration `json:"nack_cache_duration"`
+	// Enable the FlexFEC toward the client for the subscribed video tracks, it's only used when the client negotiates the
+	// video/flexfec-03 codec. The FEC packets are only sent when the packet loss reported by the client is above FECLossThreshold
+	EnableFEC bool `json:"enable_fec"`
+	// Configure the fraction of lost packets from 0 to 1 to start sending the FEC packets, the FEC is stopped when the loss
+	// drops below the half of the threshold. Default is 0.05, set to 0 to always send the FEC packets
+	FECLossThreshold float64 `json:"fec_loss_threshold"`
+	// Configure the number of the previous audio packets that sent as the redundancy in every RED packet when the publisher sends
+	// the plain Opus and the client supports RED. Default is 0 means the audio is sent as published, 2 is a good value for the unstable network
+	AudioREDDistance int `json:"audio_red_distance"`
+	// Configure the Opus parameters of the audio tracks per source type, for example the stereo and the higher bitrate for the music
+	// or the screen share audio. The audio of the source type that not in the map is negotiated with the browser defaults
+	OpusOptions map[TrackType]OpusOptions `json:"opus_options"`
+	// Enable the audio/video sync toward the client, the abs-capture-time of the published packets is forwarded or generated from
+	// the publisher sender reports when it's missing, and the RTCP sender reports to the client map the RTP timestamp to the capture
+	// time instead of the send time. It keeps the lip-sync after the simulcast layer switches and the relays. Default is true
+	EnableAVSync bool `json:"enable_av_sync"`
+	// Configure the client role, the subscriber role can't publish and the published media sections are answered without
+	// accepting them. Default is publisher
+	Role ClientRole `json:"role" enums:"publisher,subscriber,moderator" example:"publisher"`
+	// Configure the track sources that the client can publish, the track with another source is never published to the room.
+	// Default is empty means all sources
+	AllowedSources []TrackType `json:"allowed_sources" enums:"media,camera,screen"`
+	// Configure the client to restart the ICE instead of stopping the client when the connection is failed, for example when
+	// the client network is changed. Default is false means the client is stopped 5 seconds after the connection is failed
+	AutoICERestart bool `json:"auto_ice_restart"`
+	// Configure the maximum number of the automatic ICE restarts before the client is stopped. Default is 3
+	ICERestartMaxAttempts int `json:"ice_restart_max_attempts"`
+	// Configure the wait in nanoseconds before the first automatic ICE restart, it's doubled on every attempt. Default is 1 second
+	ICERestartBackoff time.Duration `json:"ice_restart_backoff"`
+	// Limits limits the packets that the client can hold in the SFU, the default is unlimited
+	Limits ResourceLimits `json:"limits"`
+	// WriteQueueSize is the number of the packets that can be queued for each subscribed track while the packets are
+	// written to the client. The oldest video packet or the newest audio packet is dropped when it's full. Default is 256
+	WriteQueueSize int `json:"write_queue_size"`
+	// CapUnusedLayers sends the REMB to the client to cap its uplink to the simulcast layers that needed by the subscribers,
+	// so the client stops sending the high layer while no subscriber receives it. See RoomOptions.CapUnusedLayers
+	CapUnusedLayers bool `json:"cap_unused_layers"`
+	// PauseUnusedLayers sends the simulcast_layers_paused message to the client with the simulcast layers that no subscriber
+	// needs, so the client can stop encoding them. See RoomOptions.PauseUnusedLayers
+	PauseUnusedLayers bool `json:"pause_unused_layers"`
+	// PauseUnsubscribedVideo stops forwarding the published video tracks that have no subscriber and sends the publish_paused
+	// message to the client. See RoomOptions.PauseUnsubscribedVideo
+	PauseUnsubscribedVideo bool `json:"pause_unsubscribed_video"`
+	// PublishConstraints restricts the tracks that the client can publish, the media sections that violate it are answered
+	// without accepting them. See RoomOptions.PublishConstraints
+	PublishConstraints *PublishConstraints `json:"publish_constraints"`
+	// EnableDownlinkProbe sends the RTP padding bursts after the client is connected, reconnected or its ICE is restarted, so the bandwidth estimate
+	// ramps up before the high simulcast layers are forwarded. The subscribed simulcast tracks start from the low layer
+	// while probing instead of the optimistic start from the initial bandwidth
+	EnableDownlinkProbe bool `json:"enable_downlink_probe"`
+	// DownlinkProbeDuration is the time in nanoseconds that the padding bursts are sent. Default is 2 seconds
+	DownlinkProbeDuration time.Duration `json:"downlink_probe_duration"`
+	// NetworkMonitor configures the thresholds and the hysteresis of the network state of the client, see Client.NetworkStats
+	NetworkMonitor networkmonitor.Options `json:"network_monitor"`
+	// SubscriberPriorityLimit is the highest priority that the client can set to its subscribed tracks with the
+	// subscription_priority message, the app server isn't limited with Client.SetSubscriptionPriority. Default is high
+	SubscriberPriorityLimit SubscriptionPriority `json:"subscriber_priority_limit" enums:"auto,low,normal,high,pinned"`
+	// MaxPrioritizedSubscriptions is the number of the subscribed tracks that the client can raise above the normal
+	// priority itself. Default is 2, set to 0 to only let the client lower the priority
+	MaxPrioritizedSubscriptions int `json:"max_prioritized_subscriptions"`
+	// E2EE marks the client media is end-to-end encrypted with insertable streams or SFrame, the SFU never parses the payload
+	// and only uses the RTP header extensions to detect the keyframes and layers. It's always enabled on an E2EE room.
+	E2EE          bool `json:"e2ee"`
+	Log           logging.LeveledLogger
+	settingEngine webrtc.SettingEngine
+	qualityLevels []QualityLevel
+	// reuse the transceivers offered by the remote peer to send the subscribed tracks
+	reuseTransceivers bool
 }
 
 type internalDataMessage struct {
@@ -114,10 +245,32 @@ type internalDataVideoSize struct {
 	Data videoSize `json:"data"`
 }
 
+type internalDataVideoSizes struct {
+	Type string      `json:"type"`
+	Data []videoSize `json:"data"`
+}
+
+type internalDataSubscribeTracks struct {
+	Type string        `json:"type"`
+	Data []TrackFilter `json:"data"`
+}
+
+type internalDataTrackQuality struct {
+	Type string       `json:"type"`
+	Data trackQuality `json:"data"`
+}
+
+type internalDataAudioLevels struct {
+	Type string       `json:"type"`
+	Data []AudioLevel `json:"data"`
+}
+
 type videoSize struct {
 	TrackID string `json:"track_id"`
 	Width   uint32 `json:"width"`
 	Height  uint32 `json:"height"`
+	// the device pixel ratio of the screen, the width and height are in CSS pixels when it's set
+	PixelRatio float64 `json:"pixel_ratio,omitempty"`
 }
 
 type remoteClientStats struct {
@@ -133,6 +286,8 @@ type Client struct {
 	bitrateController     *bitrateController
 	context               context.Context
 	cancel                context.CancelFunc
+	stopSFUWatch          func() bool
+	inLobby               atomic.Bool
 	canAddCandidate       *atomic.Bool
 	clientTracks          map[string]iClientTrack
 	muTracks              sync.Mutex
@@ -140,6 +295,7 @@ type Client struct {
 	dataChannels          *DataChannelList
 	dataChannelsInitiated bool
 	estimator             cc.BandwidthEstimator
+	estimatorHysteresis   bandwidthHysteresis
 	initialReceiverCount  atomic.Uint32
 	initialSenderCount    atomic.Uint32
 	isInRenegotiation     *atomic.Bool
@@ -158,13 +314,16 @@ type Client struct {
 	pendingRemoteRenegotiation        *atomic.Bool
 	receiveRED                        bool
 	state                             *atomic.Value
-	sfu                               *SFU
+	sfu                               atomic.Pointer[SFU]
 	muCallback                        sync.Mutex
 	onConnectionStateChangedCallbacks []func(webrtc.PeerConnectionState)
 	onJoinedCallbacks                 []func()
 	onLeftCallbacks                   []func()
 	onVoiceSentDetectedCallbacks      []func(voiceactivedetector.VoiceActivity)
 	onVoiceReceivedDetectedCallbacks  []func(voiceactivedetector.VoiceActivity)
+	onPublishRejectedCallbacks        []func(error)
+	onHeaderExtensionMissingCallbacks []func(error)
+	onTrackMutedRemotelyCallbacks     []func(trackID string, muted bool)
 	onTrackRemovedCallbacks           []func(sourceType string, track *webrtc.TrackLocalStaticRTP)
 	onIceCandidate                    func(context.Context, *webrtc.ICECandidate)
 	onRenegotiation                   func(context.Context, webrtc.SessionDescription) (webrtc.SessionDescription, error)
@@ -186,11 +345,56 @@ type Client struct {
 	receivingBandwidth             *atomic.Uint32
 	egressBandwidth                *atomic.Uint32
 	ingressBandwidth               *atomic.Uint32
+	maxDownlinkBitrate             *atomic.Uint32
+	maxUplinkBitrate               *atomic.Uint32
+	isUplinkLimiterRunning         *atomic.Bool
 	ingressQualityLimitationReason *atomic.Value
 	isDebug                        bool
 	vadInterceptor                 *voiceactivedetector.Interceptor
+	nackResponder                  *nackresponder.Interceptor
+	fecInterceptor                 *fec.Interceptor
+	playoutDelay                   *playoutdelay.InterceptorFactory
+	avSync                         *avsync.Interceptor
 	vads                           map[uint32]*voiceactivedetector.VoiceDetector
 	log                            logging.LeveledLogger
+	hotPathLog                     logging.LeveledLogger
+	meta                           *Metadata
+	metadata                       *jsonMetadata
+	pausedTracks                   sync.Map
+	subscriberPausedTracks         sync.Map
+	trackDimensions                sync.Map
+	forceMuted                     sync.Map
+	mutedTracks                    sync.Map
+	maxTemporalLayers              sync.Map
+	senderSSRCs                    sync.Map
+	reconnectToken                 atomic.Value
+	// the previous client that the republished tracks take the held tracks over from, nil if the client is not resumed
+	resumedFrom atomic.Pointer[Client]
+	// the published tracks that held after the transport is lost, see Room.ResumeClient
+	heldTracks heldTrackList
+	// leaving is true when the client is stopped by the server or the client, it's not resumable
+	leaving atomic.Bool
+	// iceRestarting is true while the automatic ICE restart is running
+	iceRestarting atomic.Bool
+	// iceRestartNeeded makes the next renegotiation offer with the new ICE credentials
+	iceRestartNeeded atomic.Bool
+	iceServers       []webrtc.ICEServer
+	// joinSpan is started when the client is created and ended when the client is connected
+	joinSpan trace.Span
+	// resources counts the goroutines and the queued packets of the client
+	resources clientResources
+	// uplinkMonitor and downlinkMonitor keep the network state of the published and the subscribed media
+	uplinkMonitor         *networkmonitor.NetworkMonitor
+	downlinkMonitor       *networkmonitor.NetworkMonitor
+	onNetworkStateChanged func(NetworkStats)
+	// probingDownlink is true while the padding bursts are sent to estimate the downlink bandwidth
+	probingDownlink atomic.Bool
+	// relayUplinkLimits are the uplink caps of the congested relays that forward the tracks of the client by the relay ID
+	relayUplinkLimits sync.Map
+	// captions is the reserved captions data channel, created with the first caption
+	captions clientCaptions
+	// constraints is ClientOptions.PublishConstraints, it's replaced when the client is moved to another room
+	constraints atomic.Pointer[PublishConstraints]
 }
 
 func DefaultClientOptions() ClientOptions {
@@ -202,33 +406,55 @@ func DefaultClientOptions() ClientOptions {
 		EnablePlayoutDelay:   true,
 		EnableOpusDTX:        true,
 		EnableOpusInbandFEC:  true,
+		EnableAVSync:         true,
 		MinPlayoutDelay:      100,
 		MaxPlayoutDelay:      200,
 		JitterBufferMinWait:  20 * time.Millisecond,
 		JitterBufferMaxWait:  150 * time.Millisecond,
 		ReorderPackets:       false,
+		NACKCacheSize:        nackresponder.DefaultConfig().Size,
+		NACKCacheDuration:    nackresponder.DefaultConfig().MaxAge,
+		FECLossThreshold:     0.05,
+		Role:                 ClientRolePublisher,
+		WriteQueueSize:       defaultWriteQueueSize,
+		NetworkMonitor:       networkmonitor.DefaultOptions(),
 		Log:                  logging.NewDefaultLoggerFactory().NewLogger("sfu"),
+
+		SubscriberPriorityLimit:     SubscriptionPriorityHigh,
+		MaxPrioritizedSubscriptions: defaultMaxPrioritizedSubscriptions,
 	}
 }
 
 func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Configuration, opts ClientOptions) *Client {
 	var client *Client
 	var vadInterceptor *voiceactivedetector.Interceptor
+	var nackResponder *nackresponder.Interceptor
+	var fecInterceptor *fec.Interceptor
+	var playoutDelayInterceptor *playoutdelay.InterceptorFactory
+	var avSyncInterceptor *avsync.Interceptor
+
+	opts.Log = logger.With(opts.Log, "client_id", id)
 
-	localCtx, cancel := context.WithCancel(s.context)
+	// the client context is ended with the SFU that the client currently belongs to, see Room.MoveClient
+	localCtx, cancel := context.WithCancel(context.WithoutCancel(s.context))
+	stopSFUWatch := context.AfterFunc(s.context, cancel)
 	m := &webrtc.MediaEngine{}
 
 	opts.settingEngine.EnableSCTPZeroChecksum(true)
 
-	if err := RegisterCodecs(m, s.codecs); err != nil {
-		panic(err)
+	registerCodecs := RegisterCodecs
+	if s.preferCodecsInOrder {
+		registerCodecs = RegisterCodecsInOrder
 	}
 
-	// let the client knows that we're receiving simulcast tracks
-	RegisterSimulcastHeaderExtensions(m, webrtc.RTPCodecTypeVideo)
+	if err := registerCodecs(m, s.codecs); err != nil {
+		panic(err)
+	}
 
-	if opts.EnableVoiceDetection {
-		voiceactivedetector.RegisterAudioLevelHeaderExtension(m)
+	// the simulcast, the dependency descriptor, and the frame marking extensions are needed to receive simulcast and
+	// to forward the AV1 SVC and H264 temporal layers, see HeaderExtensions
+	if err := registerHeaderExtensions(m, s.headerExtensions, opts.EnableVoiceDetection); err != nil {
+		panic(err)
 	}
 
 	// // Create a InterceptorRegistry. This is the user configurable RTP/RTCP Pipeline.
@@ -279,7 +505,8 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 		return gcc.NewSendSideBWE(
 			gcc.SendSideBWEInitialBitrate(int(s.bitrateConfigs.InitialBandwidth)),
 			// gcc.SendSideBWEPacer(pacer.NewLeakyBucketPacer(opts.Log, int(s.bitrateConfigs.InitialBandwidth), true)),
-			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+			// the pacer writes the retransmissions of the NACK responder on the RTX stream
+			gcc.SendSideBWEPacer(nackresponder.NewPacer()),
 		)
 	})
 	if err != nil {
@@ -292,19 +519,56 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 
 	i.Add(congestionController)
 
-	if err = webrtc.ConfigureTWCCHeaderExtensionSender(m, i); err != nil {
-		panic(err)
+	if s.headerExtensions.TransportCC {
+		if err = webrtc.ConfigureTWCCHeaderExtensionSender(m, i); err != nil {
+			panic(err)
+		}
 	}
 
 	if opts.EnablePlayoutDelay {
 		playoutdelay.RegisterPlayoutDelayHeaderExtension(m)
-		playoutDelayInterceptor := playoutdelay.NewInterceptor(opts.Log, opts.MinPlayoutDelay, opts.MaxPlayoutDelay)
+		playoutDelayInterceptor = playoutdelay.NewInterceptor(opts.Log, opts.MinPlayoutDelay, opts.MaxPlayoutDelay)
 
 		i.Add(playoutDelayInterceptor)
 	}
 
+	if opts.EnableAVSync {
+		avsync.RegisterAbsCaptureTimeHeaderExtension(m)
+
+		// replaces the sender reports of the default report interceptor, see registerInterceptors
+		avSyncInterceptorFactory := avsync.NewInterceptor(opts.Log, avsync.DefaultInterval)
+		avSyncInterceptorFactory.OnNew(func(i *avsync.Interceptor) {
+			avSyncInterceptor = i
+		})
+
+		i.Add(avSyncInterceptorFactory)
+	}
+
+	if opts.EnableFEC {
+		if err := fec.RegisterFlexFEC03(m, fec.DefaultPayloadType); err != nil {
+			panic(err)
+		}
+
+		// added before the NACK responder and the TWCC, only the media packets are cached and counted for the bandwidth estimation
+		fecInterceptorFactory := fec.NewInterceptor(opts.Log, fec.DefaultConfig())
+		fecInterceptorFactory.OnNew(func(i *fec.Interceptor) {
+			fecInterceptor = i
+		})
+
+		i.Add(fecInterceptorFactory)
+	}
+
+	nackResponderFactory := nackresponder.NewInterceptor(opts.Log, nackresponder.Config{
+		Size:   opts.NACKCacheSize,
+		MaxAge: opts.NACKCacheDuration,
+	})
+
+	nackResponderFactory.OnNew(func(i *nackresponder.Interceptor) {
+		nackResponder = i
+	})
+
 	// Use the default set of Interceptors
-	if err := registerInterceptors(m, i); err != nil {
+	if err := registerInterceptors(m, i, nackResponderFactory, !opts.EnableAVSync, s.headerExtensions.TransportCC); err != nil {
 		panic(err)
 	}
 
@@ -324,8 +588,11 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 	client = &Client{
 		id:                             id,
 		name:                           name,
+		meta:                           NewMetadata(),
+		metadata:                       &jsonMetadata{},
 		context:                        localCtx,
 		cancel:                         cancel,
+		stopSFUWatch:                   stopSFUWatch,
 		clientTracks:                   make(map[string]iClientTrack, 0),
 		canAddCandidate:                &atomic.Bool{},
 		isInRenegotiation:              &atomic.Bool{},
@@ -341,19 +608,35 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 		pendingPublishedTracks:         newTrackList(opts.Log),
 		pendingRemoteRenegotiation:     &atomic.Bool{},
 		publishedTracks:                newTrackList(opts.Log),
-		sfu:                            s,
 		statsGetter:                    statsGetter,
 		quality:                        &quality,
 		receivingBandwidth:             &atomic.Uint32{},
 		egressBandwidth:                &atomic.Uint32{},
 		ingressBandwidth:               &atomic.Uint32{},
+		maxDownlinkBitrate:             &atomic.Uint32{},
+		maxUplinkBitrate:               &atomic.Uint32{},
+		isUplinkLimiterRunning:         &atomic.Bool{},
 		ingressQualityLimitationReason: &atomic.Value{},
 		onTracksAvailableCallbacks:     make([]func([]ITrack), 0),
 		vadInterceptor:                 vadInterceptor,
+		nackResponder:                  nackResponder,
+		fecInterceptor:                 fecInterceptor,
+		playoutDelay:                   playoutDelayInterceptor,
+		avSync:                         avSyncInterceptor,
 		vads:                           vads,
 		log:                            opts.Log,
+		hotPathLog:                     newHotPathLogger(opts.Log),
 	}
 
+	client.sfu.Store(s)
+	client.constraints.Store(opts.PublishConstraints)
+
+	_, client.joinSpan = s.tracer.Start(localCtx, "sfu.client.join", trace.WithAttributes(
+		attrClientID.String(id),
+		attrClientName.String(name),
+		attrClientType.String(opts.Type),
+	))
+
 	client.onTrack = func(track ITrack) {
 		if err := client.pendingPublishedTracks.Add(track); err == ErrTrackExists {
 			s.log.Errorf("client: client %s track already added ", track.ID())
@@ -396,28 +679,13 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 		case webrtc.PeerConnectionStateConnected:
 			if client.state.Load() == ClientStateNew {
 				client.state.Store(ClientStateActive)
+				client.joinSpan.End()
 				client.onJoined()
 
-				// trigger available tracks from other clients
-
+				// trigger available tracks from other clients, the client in the lobby gets them when admitted
 				availableTracks := make([]ITrack, 0)
-
-				for _, c := range s.clients.GetClients() {
-					for _, track := range c.tracks.GetTracks() {
-						_, err := client.publishedTracks.Get(track.ID())
-						if track.ClientID() != client.ID() {
-							if err == ErrTrackIsNotExists {
-								availableTracks = append(availableTracks, track)
-							} else {
-								c.log.Errorf("client: track already exists")
-							}
-						}
-					}
-				}
-
-				// add relay tracks
-				for _, track := range s.relayTracks {
-					availableTracks = append(availableTracks, track)
+				if !client.IsInLobby() {
+					availableTracks = client.SFU().availableTracksFor(client)
 				}
 
 				if len(availableTracks) > 0 {
@@ -430,10 +698,14 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 				client.processPendingTracks()
 			}
 
+			client.startDownlinkProbe()
+
 		case webrtc.PeerConnectionStateClosed:
 			client.afterClosed()
 		case webrtc.PeerConnectionStateFailed:
-			client.startIdleTimeout(5 * time.Second)
+			if !client.autoRestartICE() {
+				client.startIdleTimeout(5 * time.Second)
+			}
 		case webrtc.PeerConnectionStateConnecting:
 			client.cancelIdleTimeout()
 		case webrtc.PeerConnectionStateDisconnected:
@@ -459,14 +731,20 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 
 	client.stats = newClientStats(client)
 
+	client.uplinkMonitor = networkmonitor.New(opts.NetworkMonitor)
+	client.downlinkMonitor = networkmonitor.New(opts.NetworkMonitor)
+	client.resources.goroutine(client.loopNetworkStats)
+	client.resources.goroutine(client.loopQualityLimitation)
+
 	client.bitrateController = newbitrateController(client, opts.qualityLevels)
 
 	go func() {
 		estimator := <-estimatorChan
 		client.mu.Lock()
-		defer client.mu.Unlock()
-
 		client.estimator = estimator
+		client.mu.Unlock()
+
+		client.updateEstimatedBandwidth(time.Now())
 	}()
 
 	// Set a handler for when a new remote track starts, this just distributes all our packets
@@ -475,10 +753,19 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 
 		var track ITrack
 
+		// the layers of a simulcast track are added concurrently, the error must not be shared with the other calls
+		var err error
+
 		remoteTrackID := strings.ReplaceAll(strings.ReplaceAll(remoteTrack.ID(), "{", ""), "}", "")
 
 		defer client.log.Infof("client: new track id %s rid %s ssrc %d kind %s", remoteTrack.ID(), remoteTrack.RID(), remoteTrack.SSRC(), remoteTrack.Kind())
 
+		// the media sections are answered without accepting them, this only happens if the SDP munging is failed
+		if !client.canPublish() {
+			client.log.Warnf("client: ignore the track %s from the client that not allowed to publish", remoteTrack.ID())
+			return
+		}
+
 		// make sure the remote track ID is not empty
 		if remoteTrackID == "" {
 			client.log.Errorf("client: error remote track id is empty")
@@ -486,15 +773,22 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 		}
 
 		onPLI := func() {
-			if client.peerConnection == nil || client.peerConnection.PC() == nil || client.peerConnection.PC().ConnectionState() != webrtc.PeerConnectionStateConnected {
-				return
-			}
+			client.SFU().requestPLI(uint32(remoteTrack.SSRC()), func() {
+				if client.peerConnection == nil || client.peerConnection.PC() == nil || client.peerConnection.PC().ConnectionState() != webrtc.PeerConnectionStateConnected {
+					return
+				}
 
-			if err := client.peerConnection.PC().WriteRTCP([]rtcp.Packet{
-				&rtcp.PictureLossIndication{MediaSSRC: uint32(remoteTrack.SSRC())},
-			}); err != nil {
-				client.log.Errorf("client: error write pli ", err)
-			}
+				if err := client.peerConnection.PC().WriteRTCP([]rtcp.Packet{
+					&rtcp.PictureLossIndication{MediaSSRC: uint32(remoteTrack.SSRC())},
+				}); err != nil {
+					client.log.Errorf("client: error write pli ", err)
+				}
+			})
+		}
+
+		// the track that published again by the resumed client continues the held track of the previous client
+		if client.resumeTrack(remoteTrack, receiver, onPLI) {
+			return
 		}
 
 		onStatsUpdated := func(stats *stats.Stats) {
@@ -507,31 +801,25 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 			minWait := opts.JitterBufferMinWait
 			maxWait := opts.JitterBufferMaxWait
 
-			track = newTrack(client.context, client, remoteTrack, minWait, maxWait, s.pliInterval, onPLI, client.statsGetter, onStatsUpdated)
+			client.checkHeaderExtensions(remoteTrack, receiver.GetParameters().HeaderExtensions)
+
+			track = newTrack(client.context, client, remoteTrack, minWait, maxWait, client.SFU().pliInterval, onPLI, client.statsGetter, onStatsUpdated)
+			switch t := track.(type) {
+			case *Track:
+				t.SetHeaderExtensions(receiver.GetParameters().HeaderExtensions)
+			case *AudioTrack:
+				t.SetHeaderExtensions(receiver.GetParameters().HeaderExtensions)
+			}
 			track.OnEnded(func() {
 				client.stats.removeReceiverStats(remoteTrack.ID() + remoteTrack.RID())
 				client.tracks.remove([]string{remoteTrack.ID()})
+				client.trackDimensions.Delete(remoteTrack.ID())
+
+				client.SFU().onTrackCatalogChanged()
 			})
 
 			if opts.EnableVoiceDetection && remoteTrack.Kind() == webrtc.RTPCodecTypeAudio {
-				vad, ok := vads[uint32(remoteTrack.SSRC())]
-				if ok {
-					audioTrack := track.(*AudioTrack)
-					audioTrack.SetVAD(vad)
-					audioTrack.OnVoiceDetected(func(pkts []voiceactivedetector.VoicePacketData) {
-						activity := voiceactivedetector.VoiceActivity{
-							TrackID:     track.ID(),
-							StreamID:    track.StreamID(),
-							SSRC:        uint32(audioTrack.SSRC()),
-							ClockRate:   audioTrack.base.codec.ClockRate,
-							AudioLevels: pkts,
-						}
-
-						client.onVoiceReceiveDetected(activity)
-					})
-				} else {
-					client.log.Errorf("client: error voice detector not found")
-				}
+				client.setTrackVAD(track.(*AudioTrack))
 			}
 
 			if err := client.tracks.Add(track); err != nil {
@@ -551,30 +839,33 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 
 			if err != nil {
 				// if track not found, add it
-				track = newSimulcastTrack(client, remoteTrack, opts.JitterBufferMinWait, opts.JitterBufferMaxWait, s.pliInterval, onPLI, client.statsGetter, onStatsUpdated)
+				client.checkHeaderExtensions(remoteTrack, receiver.GetParameters().HeaderExtensions)
+
+				track = newSimulcastTrack(client, remoteTrack, opts.JitterBufferMinWait, opts.JitterBufferMaxWait, client.SFU().pliInterval, onPLI, client.statsGetter, onStatsUpdated)
+				track.(*SimulcastTrack).SetHeaderExtensions(receiver.GetParameters().HeaderExtensions)
 				if err := client.tracks.Add(track); err != nil {
 					client.log.Errorf("client: error add track ", err)
 				}
 
 				track.OnEnded(func() {
 					simulcastTrack := track.(*SimulcastTrack)
-					// simulcastTrack.mu.Lock()
-					// defer simulcastTrack.mu.Unlock()
-					if simulcastTrack.remoteTrackHigh != nil {
-						client.stats.removeReceiverStats(simulcastTrack.remoteTrackHigh.track.ID() + simulcastTrack.remoteTrackHigh.track.RID())
-					}
-
-					if simulcastTrack.remoteTrackMid != nil {
-						client.stats.removeReceiverStats(simulcastTrack.remoteTrackMid.track.ID() + simulcastTrack.remoteTrackMid.track.RID())
-					}
 
-					if simulcastTrack.remoteTrackLow != nil {
-						client.stats.removeReceiverStats(simulcastTrack.remoteTrackLow.track.ID() + simulcastTrack.remoteTrackLow.track.RID())
+					// the layers are ended concurrently, the slots are read under the lock
+					for _, quality := range []QualityLevel{QualityHigh, QualityMid, QualityLow} {
+						if layer := simulcastTrack.GetRemoteTrack(quality); layer != nil {
+							client.stats.removeReceiverStats(layer.track.ID() + layer.track.RID())
+						}
 					}
 
 					client.tracks.remove([]string{remoteTrack.ID()})
+					client.trackDimensions.Delete(remoteTrack.ID())
+
+					client.SFU().onTrackCatalogChanged()
 				})
 
+				if opts.CapUnusedLayers {
+					client.startUplinkLimiter()
+				}
 			} else if simulcast, ok = track.(*SimulcastTrack); ok {
 				simulcast.AddRemoteTrack(remoteTrack, opts.JitterBufferMinWait, opts.JitterBufferMaxWait, client.statsGetter, onStatsUpdated, onPLI)
 			}
@@ -605,6 +896,10 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 		client.renegotiate(false)
 	})
 
+	if opts.PublishConstraints != nil && opts.PublishConstraints.MaxBitrate > 0 {
+		client.startUplinkLimiter()
+	}
+
 	return client
 }
 
@@ -620,6 +915,17 @@ func (c *Client) initDataChannel() {
 	}
 
 	c.internalDataChannel = internalDataChannel
+
+	if internalDataChannel != nil {
+		internalDataChannel.OnOpen(func() {
+			c.SFU().sendMetadataSnapshot(c)
+			c.SFU().sendTrackCatalog(c)
+
+			if c.IsInLobby() {
+				c.sendLobbyState(false)
+			}
+		})
+	}
 }
 
 func (c *Client) ID() string {
@@ -684,9 +990,36 @@ func (c *Client) IsAllowNegotiation() bool {
 }
 
 func (c *Client) Negotiate(offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	return c.negotiate(c.context, offer, nil)
+}
+
+// NegotiateContext is the same as Negotiate, the context is used as the parent of the negotiation span
+// to continue the trace from the signaling request.
+func (c *Client) NegotiateContext(ctx context.Context, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	return c.negotiate(ctx, offer, nil)
+}
+
+// negotiate handle the remote offer, the beforeAnswer callback is called after the remote description is set
+// and before the answer is created. It's used to add the local tracks to the answer without a renegotiation.
+func (c *Client) negotiate(ctx context.Context, offer webrtc.SessionDescription, beforeAnswer func() error) (answerSDP *webrtc.SessionDescription, err error) {
 	c.isInRemoteNegotiation.Store(true)
 
+	// the remote peer restarts ICE by sending an offer with the new ICE credentials
+	iceRestart := false
+	if current := c.peerConnection.PC().RemoteDescription(); current != nil {
+		iceRestart = iceUfrag(current.SDP) != iceUfrag(offer.SDP)
+	}
+
+	spanName := "sfu.client.negotiate"
+	if iceRestart {
+		spanName = "sfu.client.ice_restart"
+	}
+
+	_, span := c.startSpan(ctx, spanName, attrICERestart.Bool(iceRestart))
+
 	defer func() {
+		endSpan(span, err)
+
 		c.isInRemoteNegotiation.Store(false)
 		if c.negotiationNeeded.Load() {
 			c.renegotiate(false)
@@ -714,14 +1047,48 @@ func (c *Client) Negotiate(offer webrtc.SessionDescription) (*webrtc.SessionDesc
 		}
 	}
 
+	if !c.canPublish() {
+		var rejectedMids []string
+
+		if offer.SDP, rejectedMids = rejectPublishSDP(offer.SDP); len(rejectedMids) > 0 {
+			span.AddEvent("publish rejected")
+
+			c.onPublishRejected(&PublishRejectedError{ClientID: c.id, Role: c.Role(), Mids: rejectedMids})
+		}
+	}
+
+	if c.SFU().preferCodecsInOrder {
+		offer.SDP = preferCodecsSDP(offer.SDP, c.SFU().codecs)
+	}
+
+	if constraints := c.constraints.Load(); constraints != nil {
+		var rejected []*PublishConstraintError
+
+		if offer.SDP, rejected = applyPublishConstraints(offer.SDP, *constraints); len(rejected) > 0 {
+			span.AddEvent("publish constraints violated")
+
+			for _, err := range rejected {
+				c.onPublishConstraintViolated(err)
+			}
+		}
+	}
+
 	// Set the remote SessionDescription
-	err := c.peerConnection.PC().SetRemoteDescription(offer)
+	err = c.peerConnection.PC().SetRemoteDescription(offer)
 	if err != nil {
 		c.log.Errorf("client: error set remote description ", err)
 
 		return nil, err
 	}
 
+	span.AddEvent("remote description set")
+
+	if beforeAnswer != nil {
+		if err := beforeAnswer(); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create answer
 	answer, err := c.peerConnection.PC().CreateAnswer(nil)
 	if err != nil {
@@ -744,6 +1111,8 @@ func (c *Client) Negotiate(offer webrtc.SessionDescription) (*webrtc.SessionDesc
 
 	if !c.options.IceTrickle {
 		<-gatherComplete
+
+		span.AddEvent("ice gathering complete")
 	}
 
 	// allow add candidates once the local description is set
@@ -841,6 +1210,10 @@ func (c *Client) setOpusSDP(sdp webrtc.SessionDescription) webrtc.SessionDescrip
 		}
 	}
 
+	if len(c.options.OpusOptions) > 0 {
+		sdp.SDP = applyOpusOptions(sdp.SDP, c.opusOptionsByMid(), mergeOpusOptions(c.options.OpusOptions))
+	}
+
 	if !c.dataChannelsInitiated {
 		c.initDataChannel()
 		c.dataChannelsInitiated = true
@@ -901,64 +1274,97 @@ func (c *Client) renegotiate(offerFlexFec bool) {
 			// mark negotiation is not needed after this done, so it will out of the loop
 			c.negotiationNeeded.Store(false)
 
-			// only renegotiate when client is connected
+			// only renegotiate when client is connected, or the ICE restart is requested to recover the connection
 			if c.state.Load() != ClientStateEnded &&
 				c.peerConnection.PC().SignalingState() == webrtc.SignalingStateStable &&
-				c.peerConnection.PC().ConnectionState() == webrtc.PeerConnectionStateConnected {
+				(c.peerConnection.PC().ConnectionState() == webrtc.PeerConnectionStateConnected || c.iceRestartNeeded.Load()) {
 
 				if c.onRenegotiation == nil {
 					return
 				}
 
-				offer, err := c.peerConnection.PC().CreateOffer(nil)
-				if err != nil {
-					c.log.Errorf("sfu: error create offer on renegotiation ", err)
+				if err := c.sendRenegotiationOffer(offerFlexFec); err != nil {
 					return
 				}
+			}
+		}
+	}()
 
-				if offerFlexFec {
-					// munge the offer to include FlexFEC
-					// get the payload code of video track
+}
 
-				}
+// sendRenegotiationOffer creates the local offer and waits the remote answer through the renegotiation callback,
+// the client is stopped when the renegotiation is failed.
+func (c *Client) sendRenegotiationOffer(offerFlexFec bool) (err error) {
+	ctx, span := c.startSpan(c.context, "sfu.client.renegotiate")
+	defer func() {
+		endSpan(span, err)
+	}()
 
-				// Sets the LocalDescription, and starts our UDP listeners
-				err = c.peerConnection.PC().SetLocalDescription(offer)
-				if err != nil {
-					c.log.Errorf("sfu: error set local description on renegotiation ", err)
-					_ = c.stop()
+	var offerOptions *webrtc.OfferOptions
 
-					return
-				}
+	iceRestart := c.iceRestartNeeded.Swap(false)
+	if iceRestart {
+		offerOptions = &webrtc.OfferOptions{ICERestart: true}
+		span.SetAttributes(attrICERestart.Bool(true))
+	}
 
-				// this will be blocking until the renegotiation is done
-				sdp := c.setOpusSDP(*c.peerConnection.PC().LocalDescription())
-				answer, err := c.onRenegotiation(c.context, sdp)
-				if err != nil {
-					//TODO: when this happen, we need to close the client and ask the remote client to reconnect
-					c.log.Errorf("sfu: error on renegotiation ", err)
-					_ = c.stop()
+	offer, err := c.peerConnection.PC().CreateOffer(offerOptions)
+	if err != nil {
+		c.log.Errorf("sfu: error create offer on renegotiation ", err)
+		return err
+	}
 
-					return
-				}
+	if offerFlexFec {
+		// munge the offer to include FlexFEC
+		// get the payload code of video track
 
-				if answer.Type != webrtc.SDPTypeAnswer {
-					c.log.Errorf("sfu: error on renegotiation, the answer is not an answer type")
-					_ = c.stop()
+	}
 
-					return
-				}
+	// Sets the LocalDescription, and starts our UDP listeners
+	err = c.peerConnection.PC().SetLocalDescription(offer)
+	if err != nil {
+		c.log.Errorf("sfu: error set local description on renegotiation ", err)
+		_ = c.stop()
 
-				err = c.peerConnection.PC().SetRemoteDescription(answer)
-				if err != nil {
-					_ = c.stop()
+		return err
+	}
 
-					return
-				}
-			}
-		}
-	}()
+	span.AddEvent("local description set")
+
+	// this will be blocking until the renegotiation is done
+	// the context carries the span, so the signaling can propagate it to the remote client
+	sdp := c.setOpusSDP(*c.peerConnection.PC().LocalDescription())
+	answer, err := c.onRenegotiation(ctx, sdp)
+	if err != nil {
+		//TODO: when this happen, we need to close the client and ask the remote client to reconnect
+		c.log.Errorf("sfu: error on renegotiation ", err)
+		_ = c.stop()
+
+		return err
+	}
+
+	span.AddEvent("answer received")
+
+	if answer.Type != webrtc.SDPTypeAnswer {
+		c.log.Errorf("sfu: error on renegotiation, the answer is not an answer type")
+		_ = c.stop()
+
+		return ErrRenegotiationInvalidAnswer
+	}
 
+	err = c.peerConnection.PC().SetRemoteDescription(answer)
+	if err != nil {
+		_ = c.stop()
+
+		return err
+	}
+
+	// the estimate of the previous network path doesn't apply to the new one
+	if iceRestart {
+		c.startDownlinkProbe()
+	}
+
+	return nil
 }
 
 // OnAllowedRemoteRenegotiation event is called when the SFU is done with the renegotiation
@@ -1004,12 +1410,20 @@ func (c *Client) setClientTrack(t ITrack) iClientTrack {
 
 	localTrack := outputTrack.LocalTrack()
 
-	senderTcv, err := c.peerConnection.PC().AddTransceiverFromTrack(localTrack, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+	senderTcv, err := c.addSenderTransceiver(localTrack)
 	if err != nil {
 		c.log.Errorf("client: error on adding track ", err)
 		return nil
 	}
 
+	if delay := t.PlayoutDelay(); delay != nil {
+		c.setSenderPlayoutDelay(senderTcv.Sender(), delay)
+	}
+
+	if encodings := senderTcv.Sender().GetParameters().Encodings; len(encodings) > 0 {
+		c.senderSSRCs.Store(localTrack.ID(), uint32(encodings[0].SSRC))
+	}
+
 	// TODO: change to non goroutine
 
 	outputTrack.OnEnded(func() {
@@ -1020,6 +1434,10 @@ func (c *Client) setClientTrack(t ITrack) iClientTrack {
 		defer func() {
 			c.muTracks.Lock()
 			delete(c.clientTracks, outputTrack.ID())
+			c.pausedTracks.Delete(outputTrack.ID())
+			c.subscriberPausedTracks.Delete(outputTrack.ID())
+			c.maxTemporalLayers.Delete(outputTrack.ID())
+			c.senderSSRCs.Delete(localTrack.ID())
 			c.publishedTracks.remove([]string{outputTrack.ID()})
 			c.muTracks.Unlock()
 		}()
@@ -1043,6 +1461,38 @@ func (c *Client) setClientTrack(t ITrack) iClientTrack {
 	return outputTrack
 }
 
+// addSenderTransceiver adds a sendonly transceiver for the local track. If the client is set to reuse the transceivers,
+// the unused transceiver that offered by the remote peer will be used so the track can be sent without renegotiation.
+func (c *Client) addSenderTransceiver(localTrack *webrtc.TrackLocalStaticRTP) (*webrtc.RTPTransceiver, error) {
+	if c.options.reuseTransceivers && len(c.availableTransceivers(localTrack.Kind())) > 0 {
+		sender, err := c.peerConnection.PC().AddTrack(localTrack)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tcv := range c.peerConnection.PC().GetTransceivers() {
+			if tcv.Sender() == sender {
+				return tcv, nil
+			}
+		}
+	}
+
+	return c.peerConnection.PC().AddTransceiverFromTrack(localTrack, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+}
+
+// availableTransceivers returns the transceivers that offered by the remote peer to receive a track but not used yet
+func (c *Client) availableTransceivers(kind webrtc.RTPCodecType) []*webrtc.RTPTransceiver {
+	transceivers := make([]*webrtc.RTPTransceiver, 0)
+
+	for _, tcv := range c.peerConnection.PC().GetTransceivers() {
+		if tcv.Kind() == kind && tcv.Sender() == nil && tcv.Direction() == webrtc.RTPTransceiverDirectionSendonly {
+			transceivers = append(transceivers, tcv)
+		}
+	}
+
+	return transceivers
+}
+
 func (c *Client) ClientTracks() map[string]iClientTrack {
 	c.muTracks.Lock()
 	defer c.muTracks.Unlock()
@@ -1075,7 +1525,7 @@ func readRTCP(r *webrtc.RTPSender, b []byte) ([]rtcp.Packet, interceptor.Attribu
 // TODO: need to improve and reduce goroutine usage
 func (c *Client) enableReportAndStats(rtpSender *webrtc.RTPSender, track iClientTrack) {
 	ssrc := rtpSender.GetParameters().Encodings[0].SSRC
-	go func() {
+	c.resources.goroutine(func() {
 		localCtx, cancel := context.WithCancel(track.Context())
 		defer cancel()
 
@@ -1106,9 +1556,9 @@ func (c *Client) enableReportAndStats(rtpSender *webrtc.RTPSender, track iClient
 				}
 			}
 		}
-	}()
+	})
 
-	go func() {
+	c.resources.goroutine(func() {
 		localCtx, cancel := context.WithCancel(track.Context())
 		tick := time.NewTicker(1 * time.Second)
 		defer tick.Stop()
@@ -1128,7 +1578,7 @@ func (c *Client) enableReportAndStats(rtpSender *webrtc.RTPSender, track iClient
 				c.updateSenderStats(rtpSender, ssrc)
 			}
 		}
-	}()
+	})
 }
 
 func (c *Client) processPendingTracks() {
@@ -1152,6 +1602,10 @@ func (c *Client) afterClosed() {
 	}
 	c.mu.Unlock()
 
+	if state == ClientStateNew {
+		endSpan(c.joinSpan, ErrClientNotConnected)
+	}
+
 	c.state.Store(ClientStateEnded)
 
 	if c.internalDataChannel != nil {
@@ -1162,7 +1616,7 @@ func (c *Client) afterClosed() {
 
 	c.onLeft()
 
-	c.sfu.onAfterClientStopped(c)
+	c.SFU().onAfterClientStopped(c)
 
 	c.cancel()
 }
@@ -1185,6 +1639,8 @@ func (c *Client) stop() error {
 
 // End the client connection and clean up the resources.
 func (c *Client) End() error {
+	c.leaving.Store(true)
+
 	err := c.stop()
 	if err != nil {
 		c.log.Errorf("client: error stop client %s", err.Error())
@@ -1307,7 +1763,7 @@ func (c *Client) startIdleTimeout(timeout time.Duration) {
 		c.idleTimeoutCancel()
 	}
 
-	go func() {
+	c.resources.goroutine(func() {
 		c.idleTimeoutContext, c.idleTimeoutCancel = context.WithTimeout(c.context, timeout)
 		<-c.idleTimeoutContext.Done()
 		if c == nil || c.idleTimeoutContext == nil || c.idleTimeoutCancel == nil {
@@ -1325,7 +1781,7 @@ func (c *Client) startIdleTimeout(timeout time.Duration) {
 				c.log.Errorf("client: error stop client ", err)
 			}
 		}
-	}()
+	})
 }
 
 func (c *Client) cancelIdleTimeout() {
@@ -1347,6 +1803,15 @@ func (c *Client) PeerConnection() *PeerConnection {
 	return c.peerConnection
 }
 
+// NACKCacheStats returns the hit and miss counters of the packet cache that used to answer the NACKs from the client
+func (c *Client) NACKCacheStats() nackresponder.Stats {
+	if c.nackResponder == nil {
+		return nackresponder.Stats{}
+	}
+
+	return c.nackResponder.Stats()
+}
+
 func (c *Client) updateSenderStats(sender *webrtc.RTPSender, ssrc webrtc.SSRC) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -1360,22 +1825,73 @@ func (c *Client) updateSenderStats(sender *webrtc.RTPSender, ssrc webrtc.SSRC) {
 	stats := c.statsGetter.Get(uint32(ssrc))
 	if stats != nil && sender != nil && sender.Track() != nil {
 		c.stats.SetSender(sender.Track().ID(), *stats)
+
+		if c.fecInterceptor != nil {
+			c.updateFEC(uint32(ssrc), stats.RemoteInboundRTPStreamStats.FractionLost)
+		}
+	}
+}
+
+// updateFEC starts the FEC of the sent stream when the loss reported by the client is above the threshold, and stops it
+// when the loss drops below the half of the threshold, so it's not toggled on every receiver report
+func (c *Client) updateFEC(ssrc uint32, fractionLost float64) {
+	threshold := c.options.FECLossThreshold
+	enabled := c.fecInterceptor.IsEnabled(ssrc)
+
+	switch {
+	case !enabled && fractionLost >= threshold:
+		if c.fecInterceptor.SetEnabled(ssrc, true) {
+			c.log.Infof("client: %s start fec on ssrc %d, fraction lost %.2f", c.id, ssrc, fractionLost)
+		}
+	case enabled && fractionLost < threshold/2:
+		c.fecInterceptor.SetEnabled(ssrc, false)
+		c.log.Infof("client: %s stop fec on ssrc %d, fraction lost %.2f", c.id, ssrc, fractionLost)
 	}
 }
 
 // SetTracksSourceType set the source type of the pending published tracks.
 // This function must be called after receiving OnTracksAdded event.
-// The source type can be "media" or "screen"
+// The source type can be "media", "camera" or "screen"
 // Calling this method will trigger `client.OnTracksAvailable` event to other clients.
 // The other clients then can subscribe the tracks using `client.SubscribeTracks()` method.
 func (c *Client) SetTracksSourceType(trackTypes map[string]TrackType) {
+	sources := make(map[string]TrackSource, len(trackTypes))
+	for id, trackType := range trackTypes {
+		sources[id] = TrackSource{Type: trackType}
+	}
+
+	c.SetTracksSource(sources)
+}
+
+// SetTracksSource sets the source type and the label of the pending published tracks, it's the same as
+// SetTracksSourceType with a label for each track so the subscribers can tell the tracks of the same source type apart.
+// The client can also send it through the internal data channel with the track_sources message.
+func (c *Client) SetTracksSource(sources map[string]TrackSource) {
 	availableTracks := make([]ITrack, 0)
 	removeTrackIDs := make([]string, 0)
 	for _, track := range c.pendingPublishedTracks.GetTracks() {
-		if trackType, ok := trackTypes[track.ID()]; ok {
-			track.SetSourceType(trackType)
+		if source, ok := sources[track.ID()]; ok {
+			trackType := source.Type
+			if trackType == "" {
+				trackType = TrackTypeMedia
+			}
+
+			if !c.canPublishSource(trackType) {
+				c.log.Warnf("client: %s is not allowed to publish the %s track %s", c.ID(), trackType, track.ID())
+				c.onPublishRejected(fmt.Errorf("%w: %s track %s", ErrSourceNotAllowed, trackType, track.ID()))
+
+				removeTrackIDs = append(removeTrackIDs, track.ID())
+
+				continue
+			}
+
+			track.SetSource(TrackSource{Type: trackType, Label: source.Label})
 			availableTracks = append(availableTracks, track)
 
+			if trackType == TrackTypeScreen && track.Kind() == webrtc.RTPCodecTypeVideo {
+				c.sendScreenProfile(track.ID())
+			}
+
 			// remove it from pending published once it published available to other clients
 			removeTrackIDs = append(removeTrackIDs, track.ID())
 		}
@@ -1386,7 +1902,7 @@ func (c *Client) SetTracksSourceType(trackTypes map[string]TrackType) {
 	if len(availableTracks) > 0 {
 		// broadcast to other clients available tracks from this client
 		c.log.Debugf("client: %s set source tracks %d", c.ID(), len(availableTracks))
-		c.sfu.onTracksAvailable(c.ID(), availableTracks)
+		c.SFU().onTracksAvailable(c.ID(), availableTracks)
 		c.onTracksReady(availableTracks)
 	}
 }
@@ -1395,14 +1911,34 @@ func (c *Client) SetTracksSourceType(trackTypes map[string]TrackType) {
 // The client must listen for `client.OnTracksAvailable` to know if a new track is available to subscribe.
 // Calling subscribe tracks will trigger the SFU renegotiation with the client.
 func (c *Client) SubscribeTracks(req []SubscribeTrackRequest) error {
+	if c.IsInLobby() {
+		return ErrClientInLobby
+	}
+
 	if c.peerConnection.PC().ConnectionState() != webrtc.PeerConnectionStateConnected {
 		c.mu.Lock()
 		c.pendingReceivedTracks = append(c.pendingReceivedTracks, req...)
 		c.mu.Unlock()
 
+		c.joinSpan.AddEvent("tracks subscription is pending", trace.WithAttributes(attrTracksCount.Int(len(req))))
+
 		return nil
 	}
 
+	return c.subscribeTracks(req)
+}
+
+func (c *Client) subscribeTracks(req []SubscribeTrackRequest) error {
+	return c.subscribeSFUTracks(c.SFU(), req)
+}
+
+// subscribeSFUTracks subscribes the tracks of the clients in the SFU, it's the SFU of another room for the external subscriptions
+func (c *Client) subscribeSFUTracks(s *SFU, req []SubscribeTrackRequest) (err error) {
+	_, span := c.startSpan(c.context, "sfu.client.subscribe_tracks", attrTracksCount.Int(len(req)))
+	defer func() {
+		endSpan(span, err)
+	}()
+
 	clientTracks := make([]iClientTrack, 0)
 
 	for _, r := range req {
@@ -1413,17 +1949,24 @@ func (c *Client) SubscribeTracks(req []SubscribeTrackRequest) error {
 			continue
 		}
 
-		client, err := c.sfu.clients.GetClient(r.ClientID)
+		client, err := s.clients.GetClient(r.ClientID)
 		if err != nil {
 			return err
 		}
 
 		for _, track := range client.tracks.GetTracks() {
 			if track.ID() == r.TrackID {
+				track, err := c.transcodedTrack(track, r)
+				if err != nil {
+					return err
+				}
+
 				if clientTrack := c.setClientTrack(track); clientTrack != nil {
 					clientTracks = append(clientTracks, clientTrack)
 				}
 
+				span.AddEvent("track subscribed", trace.WithAttributes(attrTrackID.String(r.TrackID), attrTrackClientID.String(r.ClientID)))
+
 				c.log.Debugf("client: subscribe track %s from %s to %s", r.TrackID, r.ClientID, c.ID())
 
 				trackFound = true
@@ -1432,12 +1975,19 @@ func (c *Client) SubscribeTracks(req []SubscribeTrackRequest) error {
 		}
 
 		// look on relay tracks
-		for _, track := range c.SFU().relayTracks {
+		for _, track := range s.relayTracks {
 			if track.ID() == r.TrackID {
+				track, err := c.transcodedTrack(track, r)
+				if err != nil {
+					return err
+				}
+
 				if clientTrack := c.setClientTrack(track); clientTrack != nil {
 					clientTracks = append(clientTracks, clientTrack)
 				}
 
+				span.AddEvent("relay track subscribed", trace.WithAttributes(attrTrackID.String(r.TrackID), attrTrackClientID.String(r.ClientID)))
+
 				trackFound = true
 			}
 		}
@@ -1453,6 +2003,19 @@ func (c *Client) SubscribeTracks(req []SubscribeTrackRequest) error {
 			c.log.Errorf("client: failed to add claims ", err)
 		}
 
+		// limit the quality of the video tracks that requested with a max resolution
+		for _, r := range req {
+			if r.MaxWidth == 0 || r.MaxHeight == 0 {
+				continue
+			}
+
+			for _, track := range clientTracks {
+				if track.ID() == r.TrackID && track.Kind() == webrtc.RTPCodecTypeVideo {
+					c.bitrateController.onRemoteViewedSizeChanged(videoSize{TrackID: r.TrackID, Width: r.MaxWidth, Height: r.MaxHeight})
+				}
+			}
+		}
+
 		// request keyframe
 		for _, track := range clientTracks {
 			track.RequestPLI()
@@ -1462,6 +2025,15 @@ func (c *Client) SubscribeTracks(req []SubscribeTrackRequest) error {
 	return nil
 }
 
+// transcodedTrack returns the transcoded track if the track is requested with a different codec, otherwise the track itself
+func (c *Client) transcodedTrack(track ITrack, r SubscribeTrackRequest) (ITrack, error) {
+	if !needTranscode(track, r) {
+		return track, nil
+	}
+
+	return c.SFU().transcoding.track(track, r)
+}
+
 // SetQuality method is to set the maximum quality of the video that will be sent to the client.
 // This is for bandwidth efficiency purpose and use when the video is rendered in smaller size than the original size.
 func (c *Client) SetQuality(quality QualityLevel) {
@@ -1484,19 +2056,39 @@ func (c *Client) SetQuality(quality QualityLevel) {
 }
 
 // GetEstimatedBandwidth returns the estimated bandwidth in bits per second based on
-// Google Congestion Controller estimation. If the congestion controller is not enabled,
-// it will return the initial bandwidth. If the receiving bandwidth is not 0, it will return the smallest value between
-// the estimated bandwidth and the receiving bandwidth.
+// Google Congestion Controller estimation from the TWCC feedback. The estimation is decreased immediately
+// but only increased after it's stable for a while to avoid the layers oscillation. If the congestion controller is not enabled,
+// it will return the initial bandwidth. If the receiving bandwidth limit or the max downlink bitrate is not 0,
+// it will return the smallest value between the estimated bandwidth and the limits.
 func (c *Client) GetEstimatedBandwidth() uint32 {
+	bandwidth := c.SFU().bitrateConfigs.InitialBandwidth
+
+	if estimate := c.estimatorHysteresis.get(); estimate != 0 {
+		bandwidth = estimate
+	}
+
+	for _, limit := range []uint32{c.receivingBandwidth.Load(), c.maxDownlinkBitrate.Load()} {
+		if limit != 0 && limit < bandwidth {
+			bandwidth = limit
+		}
+	}
+
+	return bandwidth
+}
+
+// updateEstimatedBandwidth passes the target bitrate of the congestion controller to the hysteresis, it's called
+// by the bitrate controller loop and once the estimator is created
+func (c *Client) updateEstimatedBandwidth(now time.Time) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	estimator := c.estimator
+	c.mu.Unlock()
 
-	if c.estimator == nil {
-		return c.sfu.bitrateConfigs.InitialBandwidth
+	if estimator == nil {
+		return
 	}
 
 	// overshot the bandwidth by 40%
-	return uint32(c.estimator.GetTargetBitrate() * 1400 / 1000)
+	c.estimatorHysteresis.update(uint32(estimator.GetTargetBitrate()*1400/1000), now)
 }
 
 // This should get from the publisher client using RTCIceCandidatePairStats.availableOutgoingBitrate
@@ -1521,7 +2113,7 @@ func (c *Client) createDataChannel(label string, initOpts *webrtc.DataChannelIni
 	}
 
 	c.log.Infof("client: data channel created ", label, " ", c.ID())
-	c.sfu.setupMessageForwarder(c.ID(), newDc)
+	c.SFU().setupMessageForwarder(c.ID(), newDc)
 	c.dataChannels.Add(newDc)
 
 	return nil
@@ -1568,6 +2160,72 @@ func (c *Client) onInternalMessage(msg webrtc.DataChannelMessage) {
 		}
 
 		c.bitrateController.onRemoteViewedSizeChanged(internalData.Data)
+	case messageTypeVideoSizes:
+		internalData := internalDataVideoSizes{}
+		if err := json.Unmarshal(msg.Data, &internalData); err != nil {
+			c.log.Errorf("client: error unmarshal messageTypeVideoSizes ", err)
+			return
+		}
+
+		for _, size := range internalData.Data {
+			c.bitrateController.onRemoteViewedSizeChanged(size)
+		}
+	case messageTypeSubscribeTracks:
+		internalData := internalDataSubscribeTracks{}
+		if err := json.Unmarshal(msg.Data, &internalData); err != nil {
+			c.log.Errorf("client: error unmarshal messageTypeSubscribeTracks ", err)
+			return
+		}
+
+		if _, err := c.SubscribeTracksWithFilter(internalData.Data...); err != nil {
+			c.log.Errorf("client: error subscribe tracks with filter ", err)
+		}
+	case messageTypeTrackQuality:
+		internalData := internalDataTrackQuality{}
+		if err := json.Unmarshal(msg.Data, &internalData); err != nil {
+			c.log.Errorf("client: error unmarshal messageTypeTrackQuality ", err)
+			return
+		}
+
+		if err := c.onTrackQualityMessage(internalData.Data); err != nil {
+			c.log.Errorf("client: error set track quality ", err)
+		}
+	case messageTypeTrackSources:
+		internalData := internalDataTrackSources{}
+		if err := json.Unmarshal(msg.Data, &internalData); err != nil {
+			c.log.Errorf("client: error unmarshal messageTypeTrackSources ", err)
+			return
+		}
+
+		c.onTrackSourcesMessage(internalData.Data)
+	case messageTypeTrackPause:
+		internalData := internalDataTrackPause{}
+		if err := json.Unmarshal(msg.Data, &internalData); err != nil {
+			c.log.Errorf("client: error unmarshal messageTypeTrackPause ", err)
+			return
+		}
+
+		if err := c.onTrackPauseMessage(internalData.Data); err != nil {
+			c.log.Errorf("client: error pause track ", err)
+		}
+	case messageTypeSubscriptionPriority:
+		internalData := internalDataSubscriptionPriority{}
+		if err := json.Unmarshal(msg.Data, &internalData); err != nil {
+			c.log.Errorf("client: error unmarshal messageTypeSubscriptionPriority ", err)
+			return
+		}
+
+		if err := c.onSubscriptionPriorityMessage(internalData.Data); err != nil {
+			c.log.Errorf("client: error set subscription priority ", err)
+		}
+	case messageTypeCaptionLanguages:
+		internalData := internalDataCaptionLanguages{}
+		if err := json.Unmarshal(msg.Data, &internalData); err != nil {
+			c.log.Errorf("client: error unmarshal messageTypeCaptionLanguages ", err)
+			return
+		}
+
+		c.SetCaptionLanguages(internalData.Data)
 	}
 }
 
@@ -1617,7 +2275,7 @@ func (c *Client) Stats() ClientTrackStats {
 			if simulcastClientTrack.remoteTrackHigh != nil {
 				stats, err := c.stats.GetReceiver(simulcastClientTrack.remoteTrackHigh.Track().ID(), simulcastClientTrack.remoteTrackHigh.Track().RID())
 				if err == nil {
-					receivedStats, err := generateClientReceiverStats(c, simulcastClientTrack.remoteTrackHigh.Track(), stats)
+					receivedStats, err := generateClientReceiverStats(c, simulcastClientTrack.remoteTrackHigh, stats)
 					if err == nil {
 						clientStats.Receives = append(clientStats.Receives, receivedStats)
 					}
@@ -1628,7 +2286,7 @@ func (c *Client) Stats() ClientTrackStats {
 			if simulcastClientTrack.remoteTrackMid != nil {
 				stats, err := c.stats.GetReceiver(simulcastClientTrack.remoteTrackMid.Track().ID(), simulcastClientTrack.remoteTrackMid.Track().RID())
 				if err == nil {
-					receivedStats, err := generateClientReceiverStats(c, simulcastClientTrack.remoteTrackMid.Track(), stats)
+					receivedStats, err := generateClientReceiverStats(c, simulcastClientTrack.remoteTrackMid, stats)
 					if err == nil {
 						clientStats.Receives = append(clientStats.Receives, receivedStats)
 					}
@@ -1639,7 +2297,7 @@ func (c *Client) Stats() ClientTrackStats {
 			if simulcastClientTrack.remoteTrackLow != nil {
 				stats, err := c.stats.GetReceiver(simulcastClientTrack.remoteTrackLow.Track().ID(), simulcastClientTrack.remoteTrackLow.Track().RID())
 				if err == nil {
-					receivedStats, err := generateClientReceiverStats(c, simulcastClientTrack.remoteTrackLow.Track(), stats)
+					receivedStats, err := generateClientReceiverStats(c, simulcastClientTrack.remoteTrackLow, stats)
 					if err == nil {
 						clientStats.Receives = append(clientStats.Receives, receivedStats)
 					}
@@ -1657,10 +2315,12 @@ func (c *Client) Stats() ClientTrackStats {
 					continue
 				}
 
-				receivedStats, err = generateClientReceiverStats(c, t.RemoteTrack().Track(), stat)
+				receivedStats, err = generateClientReceiverStats(c, t.RemoteTrack(), stat)
 				if err != nil {
 					continue
 				}
+
+				receivedStats.DTXPackets = t.DTXPackets()
 			} else {
 				t := track.(*Track)
 				stat, err := c.stats.GetReceiver(t.RemoteTrack().track.ID(), t.RemoteTrack().track.RID())
@@ -1668,7 +2328,7 @@ func (c *Client) Stats() ClientTrackStats {
 					continue
 				}
 
-				receivedStats, err = generateClientReceiverStats(c, t.RemoteTrack().Track(), stat)
+				receivedStats, err = generateClientReceiverStats(c, t.RemoteTrack(), stat)
 				if err != nil {
 					continue
 				}
@@ -1705,11 +2365,17 @@ func (c *Client) Stats() ClientTrackStats {
 			Source:         source,
 			Quality:        track.Quality(),
 			MaxQuality:     track.MaxQuality(),
+			DroppedPackets: track.writeQueue().droppedPackets(),
+			// none for the audio and the video that forwarded on the high quality
+			QualityLimitationReason: c.qualityLimitationReason(track),
 		}
 
 		clientStats.Sents = append(clientStats.Sents, sentStats)
 	}
 
+	clientStats.AVSync = c.AVSyncStats()
+	clientStats.Resources = c.Resources()
+
 	return clientStats
 }
 
@@ -1724,8 +2390,66 @@ func (c *Client) IsDebugEnabled() bool {
 	return c.isDebug
 }
 
+// setTrackPaused pauses or resumes forwarding the subscribed video track, returns true if the state is changed.
+// A keyframe is requested when the track is resumed.
+func (c *Client) setTrackPaused(trackID string, paused bool) bool {
+	if paused {
+		_, loaded := c.pausedTracks.LoadOrStore(trackID, true)
+		return !loaded
+	}
+
+	if _, loaded := c.pausedTracks.LoadAndDelete(trackID); !loaded {
+		return false
+	}
+
+	if c.isTrackPausedBySubscriber(trackID) {
+		return true
+	}
+
+	if track, ok := c.ClientTracks()[trackID]; ok {
+		track.RequestPLI()
+	}
+
+	return true
+}
+
+// isTrackPaused returns true if the subscribed track is paused by the forwarding policy or by the client
+func (c *Client) isTrackPaused(trackID string) bool {
+	_, ok := c.pausedTracks.Load(trackID)
+	return ok || c.isTrackPausedBySubscriber(trackID)
+}
+
+// IsE2EE returns true if the client media is end-to-end encrypted
+func (c *Client) IsE2EE() bool {
+	return c.options.E2EE
+}
+
+// Meta returns the metadata of the client, the metadata can be used to filter the subscribed tracks with TrackFilter.ClientMeta
+func (c *Client) Meta() *Metadata {
+	return c.meta
+}
+
+// SetMetadata sets the client metadata, a JSON blob that sent to all clients in the room with the metadata_changed
+// internal message. Use it for the client states like the hand raise, the role or the display name.
+func (c *Client) SetMetadata(metadata []byte) (uint64, error) {
+	data, version, err := c.metadata.set(metadata, c.SFU().maxMetadataSize)
+	if err != nil {
+		return 0, err
+	}
+
+	c.SFU().onMetadataChanged(MetadataChanged{ClientID: c.id, Version: version, Metadata: data})
+
+	return version, nil
+}
+
+// Metadata returns the client metadata that set with SetMetadata and the version, the version is 0 if it's never set
+func (c *Client) Metadata() (json.RawMessage, uint64) {
+	return c.metadata.get()
+}
+
+// SFU returns the SFU of the room that the client is in, it's changed when the client is moved with Room.MoveClient
 func (c *Client) SFU() *SFU {
-	return c.sfu
+	return c.sfu.Load()
 }
 
 // OnTracksAvailable event is called when the SFU is trying to publish new tracks to the client.
@@ -1778,6 +2502,28 @@ func (c *Client) IsVADEnabled() bool {
 	return c.options.EnableVoiceDetection
 }
 
+// setTrackVAD sets the voice detector of the published audio track, the voice activity is sent to the client callbacks
+func (c *Client) setTrackVAD(audioTrack *AudioTrack) {
+	vad, ok := c.vads[uint32(audioTrack.SSRC())]
+	if !ok {
+		c.log.Errorf("client: error voice detector not found")
+		return
+	}
+
+	audioTrack.SetVAD(vad)
+	audioTrack.OnVoiceDetected(func(pkts []voiceactivedetector.VoicePacketData) {
+		activity := voiceactivedetector.VoiceActivity{
+			TrackID:     audioTrack.ID(),
+			StreamID:    audioTrack.StreamID(),
+			SSRC:        uint32(audioTrack.SSRC()),
+			ClockRate:   audioTrack.base.codec.ClockRate,
+			AudioLevels: pkts,
+		}
+
+		c.onVoiceReceiveDetected(activity)
+	})
+}
+
 func (c *Client) enableSendVADToInternalDataChannel() {
 	c.OnVoiceSentDetected(func(activity voiceactivedetector.VoiceActivity) {
 		if c.internalDataChannel == nil {
@@ -1837,6 +2583,17 @@ func (c *Client) sendVad(dataType string, activity voiceactivedetector.VoiceActi
 	}
 }
 
+// sendInternalMessage sends the message to the client internal data channel, the message is dropped if the data channel is not open
+func (c *Client) sendInternalMessage(data []byte) {
+	if c.internalDataChannel == nil || c.internalDataChannel.ReadyState() != webrtc.DataChannelStateOpen {
+		return
+	}
+
+	if err := c.internalDataChannel.SendText(string(data)); err != nil {
+		c.log.Errorf("client: error send internal message ", err)
+	}
+}
+
 func (c *Client) OnVoiceReceivedDetected(callback func(activity voiceactivedetector.VoiceActivity)) {
 	c.muCallback.Lock()
 	defer c.muCallback.Unlock()
@@ -1870,31 +2627,42 @@ func (c *Client) Tracks() []ITrack {
 	return c.tracks.GetTracks()
 }
 
-func registerInterceptors(m *webrtc.MediaEngine, interceptorRegistry *interceptor.Registry) error {
+// the responder answers the subscriber NACKs from the sent packets, on the RTX stream if the subscriber negotiated it
+func registerInterceptors(m *webrtc.MediaEngine, interceptorRegistry *interceptor.Registry, responder interceptor.Factory, senderReports, twcc bool) error {
 	// ConfigureNack will setup everything necessary for handling generating/responding to nack messages.
 	generator, err := nack.NewGeneratorInterceptor()
 	if err != nil {
 		return err
 	}
 
-	responder, err := nack.NewResponderInterceptor()
-	if err != nil {
-		return err
-	}
-
 	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack"}, webrtc.RTPCodecTypeVideo)
 	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "pli"}, webrtc.RTPCodecTypeVideo)
 	interceptorRegistry.Add(responder)
 	interceptorRegistry.Add(generator)
 
-	if err := webrtc.ConfigureRTCPReports(interceptorRegistry); err != nil {
-		return err
+	if senderReports {
+		if err := webrtc.ConfigureRTCPReports(interceptorRegistry); err != nil {
+			return err
+		}
+	} else {
+		// the sender reports are sent by the A/V sync interceptor
+		receiver, err := report.NewReceiverInterceptor()
+		if err != nil {
+			return err
+		}
+
+		interceptorRegistry.Add(receiver)
+	}
+
+	if !twcc {
+		return nil
 	}
 
 	return webrtc.ConfigureTWCCSender(m, interceptorRegistry)
 }
 
-func generateClientReceiverStats(c *Client, track IRemoteTrack, stat stats.Stats) (TrackReceivedStats, error) {
+func generateClientReceiverStats(c *Client, rt *remoteTrack, stat stats.Stats) (TrackReceivedStats, error) {
+	track := rt.Track()
 	bitrate, _ := c.stats.GetReceiverBitrate(track.ID(), track.RID())
 
 	receivedStats := TrackReceivedStats{
@@ -1909,6 +2677,10 @@ func generateClientReceiverStats(c *Client, track IRemoteTrack, stat stats.Stats
 		PacketsReceived: stat.InboundRTPStreamStats.PacketsReceived,
 	}
 
+	if jitterBufferStats, ok := rt.JitterBufferStats(); ok {
+		receivedStats.JitterBuffer = &jitterBufferStats
+	}
+
 	return receivedStats, nil
 }
 
@@ -2,10 +2,19 @@ package sfu
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/inlivedev/sfu/pkg/framemarking"
+	"github.com/pion/rtcp"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v4"
 	"github.com/stretchr/testify/require"
 )
@@ -271,3 +280,960 @@ func TestClientDataChannel(t *testing.T) {
 		require.Equal(t, "internal", dc.Label())
 	}
 }
+
+func TestClientBitrateLimit(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	pc, client, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer", true, false, true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	for len(client.Tracks()) < 2 || client.PeerConnection().PC().ConnectionState() != webrtc.PeerConnectionStateConnected {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the published tracks")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// downlink cap overrides the estimated bandwidth
+	client.SetMaxDownlinkBitrate(100_000)
+	require.Equal(t, uint32(100_000), client.MaxDownlinkBitrate())
+	require.Equal(t, uint32(100_000), client.GetEstimatedBandwidth())
+
+	client.SetMaxDownlinkBitrate(0)
+	require.Equal(t, uint32(0), client.MaxDownlinkBitrate())
+
+	// uplink cap is sent to the publisher as REMB for all published SSRCs
+	require.Nil(t, client.uplinkLimitPacket())
+
+	client.SetMaxUplinkBitrate(500_000)
+	require.Equal(t, uint32(500_000), client.MaxUplinkBitrate())
+	require.True(t, client.isUplinkLimiterRunning.Load())
+
+	expectedSSRCs := make([]uint32, 0)
+	for _, sender := range pc.PeerConnection.GetSenders() {
+		expectedSSRCs = append(expectedSSRCs, uint32(sender.GetParameters().Encodings[0].SSRC))
+	}
+
+	remb := client.uplinkLimitPacket()
+	require.NotNil(t, remb)
+	require.Equal(t, float32(500_000), remb.Bitrate)
+	require.ElementsMatch(t, expectedSSRCs, remb.SSRCs)
+
+	// the lowest cap of the congested relays is used when it's lower than the uplink cap
+	client.setRelayUplinkLimit("relay-1", 300_000)
+	client.setRelayUplinkLimit("relay-2", 400_000)
+	require.Equal(t, float32(300_000), client.uplinkLimitPacket().Bitrate)
+
+	client.setRelayUplinkLimit("relay-1", 0)
+	client.setRelayUplinkLimit("relay-2", 0)
+	require.Equal(t, float32(500_000), client.uplinkLimitPacket().Bitrate)
+
+	// the remaining cap is sent when the cap of a relay is removed
+	require.Equal(t, float32(500_000), client.uplinkLiftPacket().Bitrate)
+
+	// the limiter loop is stopped when the cap is removed
+	client.SetMaxUplinkBitrate(0)
+	require.Nil(t, client.uplinkLimitPacket())
+
+	// the client keeps the last REMB, the ceiling lifts the cap
+	lift := client.uplinkLiftPacket()
+	require.NotNil(t, lift)
+	require.Equal(t, float32(uplinkLimitCeiling), lift.Bitrate)
+	require.ElementsMatch(t, expectedSSRCs, lift.SSRCs)
+
+	for client.isUplinkLimiterRunning.Load() {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the uplink limiter to stop")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func TestBandwidthHysteresis(t *testing.T) {
+	h := bandwidthHysteresis{}
+	now := time.Now()
+
+	require.Equal(t, uint32(1_000_000), h.update(1_000_000, now))
+
+	// small changes are ignored
+	require.Equal(t, uint32(1_000_000), h.update(1_050_000, now.Add(time.Second)))
+	require.Equal(t, uint32(1_000_000), h.update(960_000, now.Add(2*time.Second)))
+
+	// decrease immediately
+	now = now.Add(3 * time.Second)
+	require.Equal(t, uint32(600_000), h.update(600_000, now))
+
+	// the higher target is held after the decrease even if it's stable
+	require.Equal(t, uint32(600_000), h.update(900_000, now.Add(time.Second)))
+	require.Equal(t, uint32(600_000), h.update(900_000, now.Add(4*time.Second)))
+	require.Equal(t, uint32(900_000), h.update(900_000, now.Add(5*time.Second)))
+
+	// the higher target must be stable for the increase hold
+	now = now.Add(10 * time.Second)
+	require.Equal(t, uint32(900_000), h.update(1_500_000, now))
+	require.Equal(t, uint32(900_000), h.update(900_000, now.Add(time.Second)))
+	require.Equal(t, uint32(900_000), h.update(1_500_000, now.Add(2*time.Second)))
+	require.Equal(t, uint32(900_000), h.update(1_500_000, now.Add(3*time.Second)))
+	require.Equal(t, uint32(1_500_000), h.update(1_500_000, now.Add(4*time.Second)))
+}
+
+func TestEstimatedBandwidthReads(t *testing.T) {
+	client := &Client{receivingBandwidth: &atomic.Uint32{}, maxDownlinkBitrate: &atomic.Uint32{}}
+	client.sfu.Store(&SFU{bitrateConfigs: DefaultBitrates()})
+
+	// the initial bandwidth until the estimator is updated
+	require.Equal(t, DefaultBitrates().InitialBandwidth, client.GetEstimatedBandwidth())
+
+	now := time.Now()
+	client.estimatorHysteresis.update(600_000, now)
+
+	var wg sync.WaitGroup
+
+	// reading the estimate from many goroutines doesn't advance the hysteresis
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				require.Equal(t, uint32(600_000), client.GetEstimatedBandwidth())
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// the higher target is only followed after it's updated for the increase hold
+	client.estimatorHysteresis.update(900_000, now.Add(6*time.Second))
+	require.Equal(t, uint32(600_000), client.GetEstimatedBandwidth())
+
+	client.estimatorHysteresis.update(900_000, now.Add(8*time.Second))
+	require.Equal(t, uint32(900_000), client.GetEstimatedBandwidth())
+}
+
+func TestPinTrackQuality(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	_, subscriber, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "subscriber", true, true, true)
+	_, _, _, _ = CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, true, true)
+	// the simulcast publisher only publishes video, the audio is from this one
+	_, _, _, _ = CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer", true, false, true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	var videoID, audioID string
+
+	for videoID == "" || audioID == "" {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the subscribed tracks")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		for id, track := range subscriber.ClientTracks() {
+			if !subscriber.bitrateController.Exist(id) {
+				continue
+			}
+
+			if track.IsSimulcast() {
+				videoID = id
+			} else if track.Kind() == webrtc.RTPCodecTypeAudio {
+				audioID = id
+			}
+		}
+	}
+
+	claim := subscriber.bitrateController.GetClaim(videoID)
+
+	require.NoError(t, subscriber.PinTrackQuality(videoID, QualityLow))
+	require.True(t, claim.IsPinned())
+	require.False(t, claim.IsAdjustable())
+	require.Equal(t, QualityLevel(QualityLow), claim.Quality())
+	require.Equal(t, QualityLevel(QualityLow), claim.track.MaxQuality())
+
+	// the video size doesn't change the pinned quality
+	subscriber.bitrateController.onRemoteViewedSizeChanged(videoSize{TrackID: videoID, Width: 1280, Height: 720})
+	require.Equal(t, QualityLevel(QualityLow), claim.track.MaxQuality())
+
+	require.NoError(t, subscriber.UnpinTrackQuality(videoID))
+	require.False(t, claim.IsPinned())
+	require.True(t, claim.IsAdjustable())
+	require.Equal(t, QualityLevel(QualityHigh), claim.track.MaxQuality())
+
+	// pin from the internal data channel message
+	subscriber.onInternalMessage(webrtc.DataChannelMessage{Data: []byte(fmt.Sprintf(`{"type":"track_quality","data":{"track_id":"%s","quality":"mid"}}`, videoID))})
+	require.True(t, claim.IsPinned())
+	require.Equal(t, QualityLevel(QualityMid), claim.Quality())
+
+	subscriber.onInternalMessage(webrtc.DataChannelMessage{Data: []byte(fmt.Sprintf(`{"type":"track_quality","data":{"track_id":"%s","quality":""}}`, videoID))})
+	require.False(t, claim.IsPinned())
+
+	require.ErrorIs(t, subscriber.onTrackQualityMessage(trackQuality{TrackID: videoID, Quality: "ultra"}), ErrInvalidTrackQuality)
+	require.ErrorIs(t, subscriber.PinTrackQuality(audioID, QualityLow), ErrTrackQualityNotAdjustable)
+	require.ErrorIs(t, subscriber.PinTrackQuality("unknown", QualityLow), ErrTrackIsNotExists)
+
+	// the temporal layer is capped independently from the spatial layer
+	require.Equal(t, uint8(maxTemporalID), subscriber.maxTemporalLayer(videoID))
+	require.NoError(t, subscriber.SetTrackMaxTemporalLayer(videoID, 0))
+	require.Equal(t, uint8(0), subscriber.maxTemporalLayer(videoID))
+	require.NoError(t, subscriber.SetTrackMaxTemporalLayer(videoID, maxTemporalID))
+	require.Equal(t, uint8(maxTemporalID), subscriber.maxTemporalLayer(videoID))
+	require.ErrorIs(t, subscriber.SetTrackMaxTemporalLayer(audioID, 0), ErrTrackQualityNotAdjustable)
+
+	// with all the SVC quality levels, the frame rate is reduced before the resolution
+	bc := &bitrateController{enabledQualityLevels: SVCQualityLevels()}
+	require.Equal(t, QualityLevel(QualityHighMid), bc.getPrevQuality(QualityHigh))
+	require.Equal(t, QualityLevel(QualityMid), bc.getPrevQuality(QualityHighLow))
+}
+
+func TestViewportMaxQuality(t *testing.T) {
+	bc := &bitrateController{client: &Client{}}
+	bc.client.sfu.Store(&SFU{bitrateConfigs: DefaultBitrates()})
+	track := &simulcastClientTrack{remoteTrack: &SimulcastTrack{}}
+
+	// the pixels thresholds are used until the layer dimensions are known
+	require.Equal(t, QualityLevel(QualityLow), bc.viewportMaxQuality(track, videoSize{Width: 160, Height: 90}))
+	require.Equal(t, QualityLevel(QualityMid), bc.viewportMaxQuality(track, videoSize{Width: 320, Height: 180}))
+	require.Equal(t, QualityLevel(QualityHigh), bc.viewportMaxQuality(track, videoSize{Width: 320, Height: 180, PixelRatio: 2}))
+
+	track.remoteTrack.layerDimensions.Store(QualityLevel(QualityLow), videoDimensions{Quality: QualityLow, Width: 320, Height: 180})
+	track.remoteTrack.layerDimensions.Store(QualityLevel(QualityMid), videoDimensions{Quality: QualityMid, Width: 640, Height: 360})
+	track.remoteTrack.layerDimensions.Store(QualityLevel(QualityHigh), videoDimensions{Quality: QualityHigh, Width: 1280, Height: 720})
+
+	// the lowest layer that covers the rendered size
+	require.Equal(t, QualityLevel(QualityLow), bc.viewportMaxQuality(track, videoSize{Width: 160, Height: 90}))
+	require.Equal(t, QualityLevel(QualityLow), bc.viewportMaxQuality(track, videoSize{Width: 320, Height: 180}))
+	require.Equal(t, QualityLevel(QualityMid), bc.viewportMaxQuality(track, videoSize{Width: 320, Height: 180, PixelRatio: 2}))
+	require.Equal(t, QualityLevel(QualityHigh), bc.viewportMaxQuality(track, videoSize{Width: 1920, Height: 1080}))
+}
+
+func TestSubscriptionPriority(t *testing.T) {
+	client := &Client{id: "subscriber", options: DefaultClientOptions(), log: TestLogger, bitrateController: &bitrateController{}}
+
+	for _, id := range []string{"a", "b", "c", "screen"} {
+		track := &simulcastClientTrack{id: id, kind: webrtc.RTPCodecTypeVideo, isScreen: &atomic.Bool{}}
+		track.isScreen.Store(id == "screen")
+		client.bitrateController.claims.Store(id, &bitrateClaim{track: track})
+	}
+
+	// the auto priority is resolved from the track source
+	require.Equal(t, SubscriptionPriorityNormal, client.bitrateController.GetClaim("a").tier())
+	require.Equal(t, SubscriptionPriorityHigh, client.bitrateController.GetClaim("screen").tier())
+
+	// the app server isn't limited
+	require.NoError(t, client.SetSubscriptionPriority("a", SubscriptionPriorityPinned))
+	require.ErrorIs(t, client.SetSubscriptionPriority("unknown", SubscriptionPriorityHigh), ErrTrackIsNotExists)
+	require.ErrorIs(t, client.SetSubscriptionPriority("a", SubscriptionPriority(10)), ErrInvalidSubscriptionPriority)
+
+	// the subscriber can't go above the limit or change the priority that set above the limit
+	message := func(trackID, priority string) error {
+		data := internalDataSubscriptionPriority{}
+		if err := json.Unmarshal([]byte(fmt.Sprintf(`{"type":"subscription_priority","data":{"track_id":"%s","priority":"%s"}}`, trackID, priority)), &data); err != nil {
+			return err
+		}
+
+		return client.onSubscriptionPriorityMessage(data.Data)
+	}
+
+	require.ErrorIs(t, message("b", "pinned"), ErrSubscriptionPriorityNotAllowed)
+	require.ErrorIs(t, message("a", "low"), ErrSubscriptionPriorityNotAllowed)
+	require.ErrorIs(t, message("b", "urgent"), ErrInvalidSubscriptionPriority)
+
+	// only two tracks can be raised above the normal priority, the pinned track counts
+	require.NoError(t, message("b", "high"))
+	require.ErrorIs(t, message("c", "high"), ErrSubscriptionPriorityNotAllowed)
+	require.NoError(t, message("c", "low"))
+
+	priority, err := client.SubscriptionPriority("c")
+	require.NoError(t, err)
+	require.Equal(t, SubscriptionPriorityLow, priority)
+
+	tiers := client.bitrateController.claimsByTier(true)
+	require.Equal(t, "a", tiers[0].track.ID())
+	require.Equal(t, "c", tiers[len(tiers)-1].track.ID())
+}
+
+func TestQualityLimitationReason(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	_, subscriber, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "subscriber", true, true, true)
+	_, _, _, _ = CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, true, true)
+
+	var videoID string
+
+	require.Eventually(t, func() bool {
+		for id, track := range subscriber.ClientTracks() {
+			if track.IsSimulcast() && subscriber.bitrateController.Exist(id) {
+				videoID = id
+				return true
+			}
+		}
+
+		return false
+	}, 30*time.Second, 100*time.Millisecond)
+
+	claim := subscriber.bitrateController.GetClaim(videoID)
+
+	requireReason := func(expected QualityLimitationReason) {
+		// the low layer may not be active yet, the high layer is forwarded until then
+		require.Eventually(t, func() bool {
+			reason, err := subscriber.QualityLimitationReason(videoID)
+			return err == nil && reason == expected
+		}, 10*time.Second, 50*time.Millisecond)
+	}
+
+	// the subscriber caps the quality
+	require.NoError(t, subscriber.PinTrackQuality(videoID, QualityLow))
+	requireReason(QualityLimitationPolicy)
+
+	// the bitrate controller lowers the quality
+	require.NoError(t, subscriber.UnpinTrackQuality(videoID))
+	claim.SetQuality(QualityLow)
+	requireReason(QualityLimitationBandwidth)
+
+	_, err = subscriber.QualityLimitationReason("unknown")
+	require.ErrorIs(t, err, ErrTrackIsNotExists)
+}
+
+func TestPauseTrack(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	_, subscriber, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "subscriber", true, false, true)
+	_, _, _, _ = CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	var videoID, audioID string
+
+	require.Eventually(t, func() bool {
+		for id, track := range subscriber.ClientTracks() {
+			if track.Kind() == webrtc.RTPCodecTypeVideo {
+				videoID = id
+			} else {
+				audioID = id
+			}
+		}
+
+		return videoID != "" && audioID != ""
+	}, 30*time.Second, 100*time.Millisecond)
+
+	video := subscriber.ClientTracks()[videoID]
+
+	require.NoError(t, subscriber.PauseTrack(videoID))
+	require.True(t, video.IsPaused())
+
+	// the forwarding policy doesn't resume the track that paused by the subscriber
+	require.True(t, subscriber.setTrackPaused(videoID, true))
+	require.True(t, subscriber.setTrackPaused(videoID, false))
+	require.True(t, video.IsPaused())
+
+	require.NoError(t, subscriber.ResumeTrack(videoID))
+	require.False(t, video.IsPaused())
+
+	// pause and resume from the internal data channel message
+	subscriber.onInternalMessage(webrtc.DataChannelMessage{Data: []byte(fmt.Sprintf(`{"type":"track_pause","data":{"track_id":"%s","paused":true}}`, videoID))})
+	require.True(t, video.IsPaused())
+
+	subscriber.onInternalMessage(webrtc.DataChannelMessage{Data: []byte(fmt.Sprintf(`{"type":"track_pause","data":{"track_id":"%s","paused":false}}`, videoID))})
+	require.False(t, video.IsPaused())
+
+	require.ErrorIs(t, subscriber.PauseTrack(audioID), ErrTrackNotPausable)
+	require.ErrorIs(t, subscriber.PauseTrack("unknown"), ErrTrackIsNotExists)
+}
+
+func TestSubscriberRetransmission(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	_, subscriber, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "subscriber", true, false, true)
+	_, _, _, _ = CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	var sender *webrtc.RTPSender
+
+	for sender == nil {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the subscribed video track")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		for _, s := range subscriber.PeerConnection().PC().GetSenders() {
+			if s.Track() != nil && s.Track().Kind() == webrtc.RTPCodecTypeVideo && len(s.GetParameters().Codecs) > 0 {
+				sender = s
+			}
+		}
+	}
+
+	// the NACK from the subscriber is answered by the SFU on the RTX stream of the sender,
+	// so the lost packets toward the subscriber don't need the retransmission from the publisher
+	params := sender.GetParameters()
+	require.NotZero(t, params.Encodings[0].RTX.SSRC)
+
+	hasRTX := false
+	for _, codec := range params.Codecs {
+		if codec.MimeType == webrtc.MimeTypeRTX {
+			hasRTX = true
+		}
+	}
+
+	require.True(t, hasRTX, "the rtx codec is not negotiated")
+}
+
+func TestSubscriberNACKRetransmission(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	subscriber, err := testRoom.AddClient("subscriber", "subscriber", DefaultClientOptions())
+	require.NoError(t, err)
+
+	subscriber.OnTracksAvailable(func(tracks []ITrack) {
+		requests := make([]SubscribeTrackRequest, 0)
+		for _, track := range tracks {
+			requests = append(requests, SubscribeTrackRequest{ClientID: track.ClientID(), TrackID: track.ID()})
+		}
+
+		_ = subscriber.SubscribeTracks(requests)
+	})
+
+	type receivedPacket struct {
+		ssrc uint32
+		seq  uint16
+		rtx  bool
+	}
+
+	received := make(chan receivedPacket, 1000)
+
+	// the peer has no NACK generator, the only NACK that the SFU receives is the one that sent below
+	pc := connectResumablePeer(t, subscriber)
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if track.Kind() != webrtc.RTPCodecTypeVideo {
+			return
+		}
+
+		for {
+			p, attrs, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+
+			select {
+			case received <- receivedPacket{ssrc: uint32(track.SSRC()), seq: p.SequenceNumber, rtx: attrs.Get(webrtc.AttributeRtxSsrc) != nil}:
+			default:
+			}
+		}
+	})
+
+	_, _, _, _ = CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	timeout := time.After(30 * time.Second)
+	count := 0
+	nacked := false
+
+	var lost uint16
+
+	for {
+		select {
+		case <-timeout:
+			t.Fatal("timeout waiting for the retransmitted packet")
+		case p := <-received:
+			if !nacked {
+				count++
+				if count < 50 {
+					continue
+				}
+
+				// the packet is dropped on the way to the subscriber, it's requested again from the SFU
+				lost = p.seq
+				nacked = true
+
+				require.NoError(t, pc.WriteRTCP([]rtcp.Packet{&rtcp.TransportLayerNack{
+					MediaSSRC: p.ssrc,
+					Nacks:     rtcp.NackPairsFromSequenceNumbers([]uint16{lost}),
+				}}))
+
+				continue
+			}
+
+			if !p.rtx || p.seq != lost {
+				continue
+			}
+
+			// the packet is answered from the send side cache, the publisher is not asked for it
+			require.NotZero(t, subscriber.NACKCacheStats().Hits)
+
+			return
+		}
+	}
+}
+
+func TestOpusOptionsSDP(t *testing.T) {
+	sdp := strings.Join([]string{
+		"v=0",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111 63",
+		"a=mid:0",
+		"a=rtpmap:111 opus/48000/2",
+		"a=fmtp:111 minptime=10;useinbandfec=1",
+		"a=rtpmap:63 red/48000/2",
+		"a=fmtp:63 111/111",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:1",
+		"a=rtpmap:96 VP8/90000",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 109",
+		"a=mid:2",
+		"a=rtpmap:109 opus/48000/2",
+		"a=fmtp:109 minptime=10;stereo=0",
+		"",
+	}, "\r\n")
+
+	options := map[TrackType]OpusOptions{
+		TrackTypeScreen: {Stereo: true, MaxAverageBitrate: 128000},
+	}
+
+	// the mid 0 is a subscribed media track, the mid 2 is not known so it uses the merged options
+	result := applyOpusOptions(sdp, map[string]OpusOptions{"0": options[TrackTypeMedia]}, mergeOpusOptions(options))
+	lines := strings.Split(result, "\r\n")
+
+	require.Equal(t, "a=fmtp:111 minptime=10;useinbandfec=1", lines[4])
+	require.Equal(t, "a=fmtp:63 111/111", lines[6])
+	// the existing parameter is not overridden
+	require.Equal(t, "a=fmtp:109 minptime=10;stereo=0;sprop-stereo=1;maxaveragebitrate=128000", lines[13])
+	require.True(t, strings.HasSuffix(result, "\r\n"))
+
+	require.Equal(t, "minptime=10;useinbandfec=1;stereo=1;sprop-stereo=1;maxaveragebitrate=128000", options[TrackTypeScreen].fmtp("minptime=10;useinbandfec=1"))
+}
+
+func TestClientRole(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	subscriberOpts := DefaultClientOptions()
+	subscriberOpts.Role = ClientRoleSubscriber
+	subscriber, err := testRoom.AddClient("viewer", "viewer", subscriberOpts)
+	require.NoError(t, err)
+
+	moderatorOpts := DefaultClientOptions()
+	moderatorOpts.Role = ClientRoleModerator
+	moderator, err := testRoom.AddClient("host", "host", moderatorOpts)
+	require.NoError(t, err)
+
+	publisher, err := testRoom.AddClient("speaker", "speaker", DefaultClientOptions())
+	require.NoError(t, err)
+	require.Equal(t, ClientRolePublisher, publisher.Role())
+
+	rejected := make(chan error, 1)
+	subscriber.OnPublishRejected(func(err error) {
+		rejected <- err
+	})
+
+	// the subscriber offers an audio track to send and a video to receive
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	defer pc.Close()
+
+	audioTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "viewer")
+	require.NoError(t, err)
+
+	_, err = pc.AddTrack(audioTrack)
+	require.NoError(t, err)
+
+	_, err = pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+	require.NoError(t, err)
+
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+	require.NoError(t, pc.SetLocalDescription(offer))
+
+	answer, err := subscriber.Negotiate(offer)
+	require.NoError(t, err)
+
+	err = <-rejected
+	require.ErrorIs(t, err, ErrPublishNotAllowed)
+
+	var rejectedErr *PublishRejectedError
+	require.ErrorAs(t, err, &rejectedErr)
+	require.Equal(t, []string{"0"}, rejectedErr.Mids)
+
+	// the audio is answered without receiving it
+	require.NoError(t, pc.SetRemoteDescription(*answer))
+
+	audioSection, _, _ := strings.Cut(answer.SDP[strings.Index(answer.SDP, "m=audio"):], "m=video")
+	require.NotContains(t, audioSection, "a=recvonly")
+	require.NotContains(t, audioSection, "a=sendrecv")
+
+	require.ErrorIs(t, testRoom.ForceMute(publisher.ID(), subscriber.ID(), webrtc.RTPCodecTypeAudio, true), ErrNotModerator)
+	require.ErrorIs(t, testRoom.ModeratorKickClient(subscriber.ID(), publisher.ID(), "spam"), ErrNotModerator)
+
+	require.NoError(t, testRoom.ForceMute(moderator.ID(), publisher.ID(), webrtc.RTPCodecTypeAudio, true))
+	require.True(t, publisher.IsForceMuted(webrtc.RTPCodecTypeAudio))
+	require.False(t, publisher.IsForceMuted(webrtc.RTPCodecTypeVideo))
+
+	require.NoError(t, testRoom.ForceMute(moderator.ID(), publisher.ID(), webrtc.RTPCodecTypeAudio, false))
+	require.False(t, publisher.IsForceMuted(webrtc.RTPCodecTypeAudio))
+
+	require.NoError(t, testRoom.ModeratorKickClient(moderator.ID(), publisher.ID(), "spam"))
+
+	// the client is removed once the peer connection is closed
+	require.Eventually(t, func() bool {
+		_, err := testRoom.SFU().GetClient(publisher.ID())
+		return errors.Is(err, ErrClientNotFound)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	_ = testRoom.StopClient(subscriber.ID())
+	_ = testRoom.StopClient(moderator.ID())
+}
+
+func TestPublishConstraints(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.PublishConstraints = map[ClientRole]PublishConstraints{
+		ClientRolePublisher: {
+			MaxVideoTracks: 1,
+			AllowedCodecs:  []string{webrtc.MimeTypeVP8, webrtc.MimeTypeOpus},
+			MaxBitrate:     500_000,
+		},
+	}
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	client, err := testRoom.AddClient("speaker", "speaker", DefaultClientOptions())
+	require.NoError(t, err)
+
+	defer func() {
+		_ = testRoom.StopClient(client.ID())
+	}()
+
+	rejected := make(chan error, 2)
+	client.OnPublishRejected(func(err error) {
+		rejected <- err
+	})
+
+	// the client offers two cameras and a microphone
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	defer pc.Close()
+
+	for _, track := range []struct{ mimeType, id string }{
+		{webrtc.MimeTypeOpus, "audio"},
+		{webrtc.MimeTypeVP8, "camera1"},
+		{webrtc.MimeTypeVP8, "camera2"},
+	} {
+		localTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: track.mimeType}, track.id, "speaker")
+		require.NoError(t, err)
+
+		_, err = pc.AddTrack(localTrack)
+		require.NoError(t, err)
+	}
+
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+	require.NoError(t, pc.SetLocalDescription(offer))
+
+	answer, err := client.Negotiate(offer)
+	require.NoError(t, err)
+	require.NoError(t, pc.SetRemoteDescription(*answer))
+
+	err = <-rejected
+	require.ErrorIs(t, err, ErrTooManyVideoTracks)
+
+	var constraintErr *PublishConstraintError
+	require.ErrorAs(t, err, &constraintErr)
+	require.Equal(t, "2", constraintErr.Mid)
+	require.Equal(t, "max_video_tracks", constraintErr.Reason())
+	require.Empty(t, rejected)
+
+	// only the allowed codec is answered for the first camera
+	sections := strings.Split(answer.SDP, "m=video")
+	require.Len(t, sections, 3)
+	require.Contains(t, sections[1], "VP8/90000")
+	require.NotContains(t, sections[1], "VP9/90000")
+	require.NotContains(t, sections[1], "H264/90000")
+	require.Contains(t, sections[1], "a=recvonly")
+	require.NotContains(t, sections[2], "a=recvonly")
+
+	require.Equal(t, uint32(500_000), client.publishConstraints().MaxBitrate)
+}
+
+func TestHeaderExtensions(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	extensions := DefaultHeaderExtensions()
+	extensions.AudioLevel = true
+	extensions.FrameMarking = false
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.HeaderExtensions = &extensions
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	require.Equal(t, extensions, testRoom.HeaderExtensions())
+
+	missing := make(chan error, 10)
+	testRoom.SFU().OnClientAdded(func(client *Client) {
+		client.OnHeaderExtensionMissing(func(err error) {
+			missing <- err
+		})
+	})
+
+	// the test peer doesn't register the audio level extension
+	_, client, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	select {
+	case <-time.After(30 * time.Second):
+		t.Fatal("timeout waiting for the missing header extension")
+	case err := <-missing:
+		require.ErrorIs(t, err, ErrHeaderExtensionMissing)
+
+		var missingErr *HeaderExtensionMissingError
+		require.ErrorAs(t, err, &missingErr)
+		require.Equal(t, sdp.AudioLevelURI, missingErr.URI)
+		require.Equal(t, webrtc.RTPCodecTypeAudio, missingErr.Kind)
+	}
+
+	for _, ext := range client.NegotiatedHeaderExtensions(webrtc.RTPCodecTypeVideo) {
+		require.NotEqual(t, framemarking.URI, ext.URI)
+	}
+}
+
+func TestApplyPublishConstraintsCodecs(t *testing.T) {
+	sdp := strings.Join([]string{
+		"v=0",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96 97 98 99",
+		"a=mid:0",
+		"a=sendonly",
+		"a=msid:stream track",
+		"a=rtpmap:96 VP8/90000",
+		"a=rtpmap:97 rtx/90000",
+		"a=fmtp:97 apt=96",
+		"a=rtpmap:98 H264/90000",
+		"a=rtpmap:99 rtx/90000",
+		"a=fmtp:99 apt=98",
+		"",
+	}, "\r\n")
+
+	result, rejected := applyPublishConstraints(sdp, PublishConstraints{AllowedCodecs: []string{"video/h264"}})
+	require.Empty(t, rejected)
+	require.Contains(t, result, "m=video 9 UDP/TLS/RTP/SAVPF 98 99\r\n")
+
+	result, rejected = applyPublishConstraints(sdp, PublishConstraints{AllowedCodecs: []string{webrtc.MimeTypeAV1}})
+	require.Len(t, rejected, 1)
+	require.ErrorIs(t, rejected[0], ErrCodecNotAllowed)
+	require.Contains(t, result, "a=inactive")
+	require.Contains(t, result, "m=video 9 UDP/TLS/RTP/SAVPF 96 97 98 99\r\n")
+}
+
+func TestClientRestartICE(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	client, err := testRoom.AddClient("peer", "peer", DefaultClientOptions())
+	require.NoError(t, err)
+
+	connected := func() bool {
+		return client.PeerConnection().PC().ConnectionState() == webrtc.PeerConnectionStateConnected &&
+			client.PeerConnection().PC().SignalingState() == webrtc.SignalingStateStable
+	}
+
+	require.ErrorIs(t, client.RestartICE(), ErrRenegotiationCallback)
+
+	pc := connectResumablePeer(t, client)
+
+	require.Eventually(t, connected, 30*time.Second, 50*time.Millisecond)
+
+	previous := iceUfrag(pc.RemoteDescription().SDP)
+	require.NotEmpty(t, previous)
+
+	require.NoError(t, client.RestartICE())
+
+	// the offer is sent with the next renegotiation
+	require.Eventually(t, func() bool {
+		return connected() && iceUfrag(pc.RemoteDescription().SDP) != previous
+	}, 30*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, client.End())
+
+	require.Eventually(t, func() bool {
+		return errors.Is(client.RestartICE(), ErrClientStoped)
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestICEServersProvider(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	secret := "turn-secret"
+	urls := []string{"turn:turn.example.com:3478"}
+	turnProvider := NewTURNCredentialsProvider(urls, secret, time.Hour)
+
+	calls := make(chan string, 10)
+
+	opts := sfuOpts
+	opts.ICEServersProvider = func(roomID, clientID string) ([]webrtc.ICEServer, error) {
+		calls <- roomID + "/" + clientID
+		return turnProvider(roomID, clientID)
+	}
+
+	roomManager := NewManager(ctx, "test", opts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom("room", "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	client, err := testRoom.AddClient("peer", "peer", DefaultClientOptions())
+	require.NoError(t, err)
+
+	require.Equal(t, "room/peer", <-calls)
+
+	servers := client.ICEServers()
+	require.Len(t, servers, 1)
+	require.Equal(t, urls, servers[0].URLs)
+	require.Equal(t, servers, client.PeerConnection().PC().GetConfiguration().ICEServers)
+
+	// the credential is the HMAC of the username with the expiry and the client ID
+	username := servers[0].Username
+	require.True(t, strings.HasSuffix(username, ":peer"))
+
+	expiry, err := strconv.ParseInt(strings.TrimSuffix(username, ":peer"), 10, 64)
+	require.NoError(t, err)
+	require.InDelta(t, time.Now().Add(time.Hour).Unix(), expiry, 5)
+
+	_, credential := TURNCredentials(secret, "peer", time.Unix(expiry, 0))
+	require.Equal(t, credential, servers[0].Credential)
+
+	// the credentials are refreshed on every ICE restart
+	client.OnRenegotiation(func(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+		return webrtc.SessionDescription{}, errors.New("not connected")
+	})
+
+	require.NoError(t, client.RestartICE())
+	require.Equal(t, "room/peer", <-calls)
+	require.Len(t, client.ICEServers(), 1)
+
+	require.NoError(t, client.End())
+}
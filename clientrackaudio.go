@@ -17,8 +17,13 @@ func newClientTrackAudio(c *Client, t ITrack) *clientTrackAudio {
 		return nil
 	}
 
-	if !c.receiveRED {
-		localTrack = audioTrack.createOpusLocalTrack()
+	if !audioTrack.isOpus() {
+		// the other codecs like G.711 from the SIP bridge are forwarded as published
+		localTrack = audioTrack.createLocalTrack()
+	} else if !c.receiveRED {
+		localTrack = audioTrack.createOpusLocalTrack(c.options.OpusOptions[audioTrack.SourceType()])
+	} else if audioTrack.PayloadType() != 63 && c.options.AudioREDDistance > 0 {
+		localTrack = audioTrack.createRedLocalTrack()
 	} else {
 		localTrack = audioTrack.createLocalTrack()
 	}
@@ -5,13 +5,13 @@ import (
 )
 
 type clientTrackList struct {
-	mu     sync.RWMutex
-	tracks []iClientTrack
+	mu        sync.RWMutex
+	tracks    []iClientTrack
+	onChanged func()
 }
 
 func (l *clientTrackList) Add(track iClientTrack) {
 	l.mu.Lock()
-	defer l.mu.Unlock()
 
 	// TODO: change to non go routine
 	track.OnEnded(func() {
@@ -19,18 +19,48 @@ func (l *clientTrackList) Add(track iClientTrack) {
 	})
 
 	l.tracks = append(l.tracks, track)
+	l.mu.Unlock()
+
+	l.changed()
 }
 
 func (l *clientTrackList) remove(id string) {
 	l.mu.Lock()
-	defer l.mu.Unlock()
+
+	removed := false
 
 	for i, track := range l.tracks {
 		if track.ID() == id {
 			l.tracks = append(l.tracks[:i], l.tracks[i+1:]...)
+			removed = true
+
 			break
 		}
 	}
+
+	l.mu.Unlock()
+
+	if removed {
+		l.changed()
+	}
+}
+
+// OnChanged is called after a client track is added or removed
+func (l *clientTrackList) OnChanged(f func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.onChanged = f
+}
+
+func (l *clientTrackList) changed() {
+	l.mu.RLock()
+	onChanged := l.onChanged
+	l.mu.RUnlock()
+
+	if onChanged != nil {
+		onChanged()
+	}
 }
 
 func (l *clientTrackList) Get(id string) iClientTrack {
@@ -57,7 +57,7 @@ func newClientStats(c *Client) *ClientStats {
 		},
 	}
 
-	go cstats.monitorBitrates(c.Context())
+	c.resources.goroutine(func() { cstats.monitorBitrates(c.Context()) })
 
 	return cstats
 }
@@ -30,6 +30,10 @@ type iClientTrack interface {
 	SendBitrate() uint32
 	Quality() QualityLevel
 	OnEnded(func())
+	Pause()
+	Resume()
+	IsPaused() bool
+	writeQueue() *clientTrackQueue
 }
 
 type clientTrack struct {
@@ -47,6 +51,7 @@ type clientTrack struct {
 	isScreen              bool
 	ssrc                  webrtc.SSRC
 	onTrackEndedCallbacks []func()
+	queue                 *clientTrackQueue
 }
 
 func newClientTrack(c *Client, t ITrack, isScreen bool, localTrack *webrtc.TrackLocalStaticRTP) *clientTrack {
@@ -72,6 +77,7 @@ func newClientTrack(c *Client, t ITrack, isScreen bool, localTrack *webrtc.Track
 		ssrc:                  track.remoteTrack.track.SSRC(),
 		onTrackEndedCallbacks: make([]func(), 0),
 		packetmap:             &packetmap.Map{},
+		queue:                 newClientTrackQueue(localTrack.Kind(), c.options.WriteQueueSize),
 	}
 
 	t.OnEnded(func() {
@@ -82,6 +88,10 @@ func newClientTrack(c *Client, t ITrack, isScreen bool, localTrack *webrtc.Track
 	return ct
 }
 
+func (t *clientTrack) writeQueue() *clientTrackQueue {
+	return t.queue
+}
+
 func (t *clientTrack) ID() string {
 	return t.id
 }
@@ -129,7 +139,7 @@ func (t *clientTrack) MimeType() string {
 	return t.mimeType
 }
 
-func (t *clientTrack) push(p *rtp.Packet, _ QualityLevel) {
+func (t *clientTrack) push(p *rtp.Packet, quality QualityLevel) {
 	if t.client.peerConnection.PC().ConnectionState() != webrtc.PeerConnectionStateConnected {
 		return
 	}
@@ -149,7 +159,7 @@ func (t *clientTrack) push(p *rtp.Packet, _ QualityLevel) {
 	// make sure the player is paused when the quality is none.
 	// quality none only possible when the video is not displayed
 	if t.Kind() == webrtc.RTPCodecTypeVideo {
-		quality := t.getQuality()
+		quality = t.getQuality()
 		if quality == QualityNone {
 			if ok := t.packetmap.Drop(p.SequenceNumber, 0); ok {
 				return
@@ -159,11 +169,27 @@ func (t *clientTrack) push(p *rtp.Packet, _ QualityLevel) {
 		}
 	}
 
+	if !t.baseTrack.intercept(PacketEgress, t.client, quality, p) {
+		return
+	}
+
+	t.setCaptureTime(p)
+
 	if err := t.localTrack.WriteRTP(p); err != nil {
-		t.client.log.Errorf("clienttrack: error on write rtp", err)
+		t.client.hotPathLog.Errorf("clienttrack: error on write rtp", err)
 	}
 }
 
+// writesPayload returns true if the packet can be modified by the egress interceptors
+func (t *clientTrack) writesPayload() bool {
+	return t.baseTrack.hasInterceptors(PacketEgress)
+}
+
+// setCaptureTime sets the capture time of the packet that about to be written, the timestamp is forwarded as published
+func (t *clientTrack) setCaptureTime(p *rtp.Packet) {
+	t.client.setCaptureTime(t.localTrack, t.remoteTrack.captureClock, p.Timestamp, p.Timestamp)
+}
+
 func (t *clientTrack) LocalTrack() *webrtc.TrackLocalStaticRTP {
 	return t.localTrack
 }
@@ -235,14 +261,13 @@ func (t *clientTrack) getQuality() QualityLevel {
 	claim := t.client.bitrateController.GetClaim(t.ID())
 
 	if claim == nil {
-		t.client.log.Warnf("clienttrack: claim is nil")
+		t.client.hotPathLog.Warnf("clienttrack: claim is nil")
 		return QualityNone
 	}
 
-	return min(t.MaxQuality(), claim.Quality(), Uint32ToQualityLevel(t.client.quality.Load()))
-}
-
-func qualityLevelToPreset(lvl QualityLevel) (qualityPreset QualityPreset) {
+	if t.client.isTrackPaused(t.ID()) {
+		return QualityNone
+	}
 
-	return DefaultQualityPresets[lvl]
+	return min(t.MaxQuality(), claim.Quality(), Uint32ToQualityLevel(t.client.quality.Load()))
 }
@@ -0,0 +1,286 @@
+package sfu
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/rtppool"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	defaultWriteQueueSize             = 256
+	writeQueueKeyframeRequestInterval = time.Second
+)
+
+type queuedPacket struct {
+	packet  *rtppool.RetainablePacket
+	quality QualityLevel
+}
+
+// payloadWriter is implemented by the subscribed tracks that may modify the payload of the packet before it's written.
+// The payload of the shared packet is copied for these tracks.
+type payloadWriter interface {
+	writesPayload() bool
+}
+
+// clientTrackQueue is the bounded write queue of a subscribed track. The packets are written to the subscriber from the
+// queue goroutine, so a slow subscriber doesn't block the read loop of the published track that fans out the packets
+// to all subscribers. When the queue is full, the oldest packet is dropped for the video because the newer packets
+// are more useful after the keyframe request, and the newest packet is dropped for the audio to keep the queued audio
+// continuous.
+//
+// The queued packets are shared with the other subscribers of the published track and must not be modified. Before a
+// packet is written, the header is copied to the working packet of the queue, including the extensions because they
+// can be set in place by the interceptors. The payload is only copied when the track writes to it, see payloadWriter.
+type clientTrackQueue struct {
+	mu         sync.Mutex
+	packets    []queuedPacket
+	head       int
+	size       int
+	dropOldest bool
+	notify     chan struct{}
+	start      sync.Once
+	closed     bool
+	dropped    atomic.Uint64
+	// the keyframe is requested at most once per interval while the packets are dropped
+	lastKeyframeRequest atomic.Int64
+	// the scheduler that writes the queue when Options.FanOutWorkers is set, see fanOutScheduler
+	fanOutSlot
+	scheduler *fanOutScheduler
+	track     iClientTrack
+	// the working packet and buffers that only used by the queue goroutine
+	packet     rtp.Packet
+	extensions []rtp.Extension
+	payload    []byte
+}
+
+func newClientTrackQueue(kind webrtc.RTPCodecType, size int) *clientTrackQueue {
+	if size <= 0 {
+		size = defaultWriteQueueSize
+	}
+
+	return &clientTrackQueue{
+		packets:    make([]queuedPacket, size),
+		dropOldest: kind == webrtc.RTPCodecTypeVideo,
+		notify:     make(chan struct{}, 1),
+	}
+}
+
+// enqueue retains the packet in the queue and starts the queue goroutine of the track on the first packet.
+// The caller can release its reference once it returns.
+func (q *clientTrackQueue) enqueue(track iClientTrack, p *rtppool.RetainablePacket, quality QualityLevel) {
+	client := track.Client()
+
+	q.start.Do(func() {
+		if scheduler := client.fanOutScheduler(); scheduler != nil {
+			q.scheduler = scheduler
+			q.track = track
+			// the sender is added after the track is subscribed, the first packets may come before its SSRC is known
+			ssrc, _ := client.senderSSRCs.Load(track.ID())
+			senderSSRC, _ := ssrc.(uint32)
+			q.shard = scheduler.shard(senderSSRC)
+
+			// the queue is cleared by the worker once the track or the client is ended
+			context.AfterFunc(track.Context(), func() { scheduler.schedule(q) })
+			context.AfterFunc(client.Context(), func() { scheduler.schedule(q) })
+
+			return
+		}
+
+		client.resources.goroutine(func() {
+			q.loop(track)
+		})
+	})
+
+	if !client.resources.enqueue(client.options.Limits.MaxQueuedPackets) {
+		q.drop(track)
+		return
+	}
+
+	q.mu.Lock()
+
+	if q.closed {
+		q.mu.Unlock()
+		client.resources.dequeue()
+
+		return
+	}
+
+	if err := p.Retain(); err != nil {
+		q.mu.Unlock()
+		client.resources.dequeue()
+
+		return
+	}
+
+	dropped := false
+
+	if q.size == len(q.packets) {
+		if !q.dropOldest {
+			q.mu.Unlock()
+			p.Release()
+			client.resources.dequeue()
+			q.drop(track)
+
+			return
+		}
+
+		q.pop().packet.Release()
+		client.resources.dequeue()
+
+		dropped = true
+	}
+
+	q.packets[(q.head+q.size)%len(q.packets)] = queuedPacket{packet: p, quality: quality}
+	q.size++
+
+	q.mu.Unlock()
+
+	if dropped {
+		q.drop(track)
+	}
+
+	if q.scheduler != nil {
+		q.scheduler.schedule(q)
+		return
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// drop counts the dropped packet, the subscriber needs a keyframe to decode the video after a packet is dropped
+func (q *clientTrackQueue) drop(track iClientTrack) {
+	q.dropped.Add(1)
+	track.Client().resources.dropped.Add(1)
+
+	if !q.dropOldest {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	last := q.lastKeyframeRequest.Load()
+
+	if now-last >= int64(writeQueueKeyframeRequestInterval) && q.lastKeyframeRequest.CompareAndSwap(last, now) {
+		track.RequestPLI()
+	}
+}
+
+// pop removes the oldest packet, the caller must hold the lock and the queue must not be empty
+func (q *clientTrackQueue) pop() queuedPacket {
+	packet := q.packets[q.head]
+	q.packets[q.head] = queuedPacket{}
+	q.head = (q.head + 1) % len(q.packets)
+	q.size--
+
+	return packet
+}
+
+// write writes the packet to the subscriber through the working packet of the queue
+func (q *clientTrackQueue) write(track iClientTrack, queued queuedPacket) {
+	header := queued.packet.Header()
+	payload := queued.packet.Payload()
+
+	q.packet.Header = *header
+	q.extensions = append(q.extensions[:0], header.Extensions...)
+	q.packet.Header.Extensions = q.extensions
+
+	if writer, ok := track.(payloadWriter); ok && writer.writesPayload() {
+		q.payload = append(q.payload[:0], payload...)
+		payload = q.payload
+	}
+
+	q.packet.Payload = payload
+
+	track.push(&q.packet, queued.quality)
+}
+
+// loop writes the queued packets to the subscriber until the track or the client is ended
+func (q *clientTrackQueue) loop(track iClientTrack) {
+	client := track.Client()
+
+	defer q.clear(client)
+
+	ctx, cancel := context.WithCancel(track.Context())
+	defer cancel()
+
+	stop := context.AfterFunc(client.Context(), cancel)
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.notify:
+		}
+
+		q.writeQueued(track, 0, time.Time{})
+	}
+}
+
+// run is called by the fan-out worker, it writes up to limit packets until the deadline and returns true if the queue
+// still has packets
+func (q *clientTrackQueue) run(limit int, deadline time.Time) bool {
+	client := q.track.Client()
+
+	if q.track.Context().Err() != nil || client.Context().Err() != nil {
+		q.clear(client)
+		return false
+	}
+
+	return q.writeQueued(q.track, limit, deadline)
+}
+
+// writeQueued writes the queued packets to the subscriber, up to limit packets if it's not zero and until the deadline
+// if it's not zero. It returns true if the queue still has packets.
+func (q *clientTrackQueue) writeQueued(track iClientTrack, limit int, deadline time.Time) bool {
+	client := track.Client()
+
+	for written := 0; limit == 0 || written < limit; written++ {
+		if written > 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+
+		q.mu.Lock()
+		if q.size == 0 {
+			q.mu.Unlock()
+			return false
+		}
+
+		queued := q.pop()
+		q.mu.Unlock()
+
+		q.write(track, queued)
+
+		queued.packet.Release()
+		client.resources.dequeue()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.size > 0
+}
+
+func (q *clientTrackQueue) clear(client *Client) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+
+	for q.size > 0 {
+		q.pop().packet.Release()
+		client.resources.dequeue()
+	}
+}
+
+// droppedPackets returns the number of the packets that dropped because the queue is full or the client limit is reached
+func (q *clientTrackQueue) droppedPackets() uint64 {
+	return q.dropped.Load()
+}
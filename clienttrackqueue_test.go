@@ -0,0 +1,337 @@
+package sfu
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/rtppool"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// queueTestTrack is a subscribed track that blocks the writes until it's unblocked
+type queueTestTrack struct {
+	iClientTrack
+	ctx     context.Context
+	client  *Client
+	queue   *clientTrackQueue
+	pool    *rtppool.RTPPool
+	unblock chan struct{}
+	mu      sync.Mutex
+	written []uint16
+	plis    atomic.Int32
+}
+
+func newQueueTestTrack(ctx context.Context, kind webrtc.RTPCodecType, size int) *queueTestTrack {
+	return &queueTestTrack{
+		ctx:     ctx,
+		client:  &Client{context: ctx},
+		queue:   newClientTrackQueue(kind, size),
+		pool:    rtppool.New(),
+		unblock: make(chan struct{}),
+	}
+}
+
+func (t *queueTestTrack) push(p *rtp.Packet, _ QualityLevel) {
+	<-t.unblock
+
+	t.mu.Lock()
+	t.written = append(t.written, p.SequenceNumber)
+	t.mu.Unlock()
+}
+
+func (t *queueTestTrack) ID() string                    { return fmt.Sprintf("%p", t) }
+func (t *queueTestTrack) Client() *Client               { return t.client }
+func (t *queueTestTrack) Context() context.Context      { return t.ctx }
+func (t *queueTestTrack) RequestPLI()                   { t.plis.Add(1) }
+func (t *queueTestTrack) writeQueue() *clientTrackQueue { return t.queue }
+
+func (t *queueTestTrack) writtenPackets() []uint16 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]uint16(nil), t.written...)
+}
+
+func (t *queueTestTrack) queueLen() int {
+	t.queue.mu.Lock()
+	defer t.queue.mu.Unlock()
+
+	return t.queue.size
+}
+
+func pushSequences(track *queueTestTrack, from, to uint16) {
+	for seq := from; seq <= to; seq++ {
+		packet := track.pool.NewPacket(&rtp.Header{SequenceNumber: seq}, []byte{byte(seq)}, nil)
+		pushClientTrack(track, packet, QualityHigh)
+		packet.Release()
+	}
+}
+
+func TestClientTrackQueueDropOldestVideo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	track := newQueueTestTrack(ctx, webrtc.RTPCodecTypeVideo, 3)
+
+	// the first packet is taken by the blocked write, the queue holds the next 3 packets
+	pushSequences(track, 1, 1)
+	require.Eventually(t, func() bool {
+		return track.queueLen() == 0
+	}, time.Second, time.Millisecond)
+
+	pushSequences(track, 2, 6)
+
+	require.Equal(t, uint64(2), track.queue.droppedPackets())
+	require.Equal(t, uint64(2), track.client.resources.dropped.Load())
+	// the keyframe is only requested once in the interval
+	require.Equal(t, int32(1), track.plis.Load())
+
+	close(track.unblock)
+
+	require.Eventually(t, func() bool {
+		return len(track.writtenPackets()) == 4
+	}, time.Second, time.Millisecond)
+
+	require.Equal(t, []uint16{1, 4, 5, 6}, track.writtenPackets())
+	require.Equal(t, int64(0), track.client.resources.queued.Load())
+	require.Equal(t, rtppool.Stats{}, track.pool.Stats())
+}
+
+func TestClientTrackQueueDropNewestAudio(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	track := newQueueTestTrack(ctx, webrtc.RTPCodecTypeAudio, 3)
+
+	pushSequences(track, 1, 1)
+	require.Eventually(t, func() bool {
+		return track.queueLen() == 0
+	}, time.Second, time.Millisecond)
+
+	pushSequences(track, 2, 6)
+
+	require.Equal(t, uint64(2), track.queue.droppedPackets())
+	require.Equal(t, int32(0), track.plis.Load())
+
+	close(track.unblock)
+
+	require.Eventually(t, func() bool {
+		return len(track.writtenPackets()) == 4
+	}, time.Second, time.Millisecond)
+
+	require.Equal(t, []uint16{1, 2, 3, 4}, track.writtenPackets())
+}
+
+func TestClientTrackQueueClosed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	track := newQueueTestTrack(ctx, webrtc.RTPCodecTypeVideo, 3)
+	close(track.unblock)
+
+	pushSequences(track, 1, 1)
+	require.Eventually(t, func() bool {
+		return len(track.writtenPackets()) == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+
+	require.Eventually(t, func() bool {
+		track.queue.mu.Lock()
+		defer track.queue.mu.Unlock()
+
+		return track.queue.closed
+	}, time.Second, time.Millisecond)
+
+	// the packets of the ended track are not queued
+	pushSequences(track, 2, 3)
+	require.Equal(t, int64(0), track.client.resources.queued.Load())
+	require.Equal(t, rtppool.Stats{}, track.pool.Stats())
+}
+
+// mutatingTestTrack modifies the written packets like a track with egress interceptors
+type mutatingTestTrack struct {
+	*queueTestTrack
+	payloads chan []byte
+}
+
+func (t *mutatingTestTrack) push(p *rtp.Packet, _ QualityLevel) {
+	p.SequenceNumber++
+	_ = p.SetExtension(1, []byte{0xff})
+	p.Payload[0] = 0xff
+
+	t.payloads <- append([]byte(nil), p.Payload...)
+}
+
+func (t *mutatingTestTrack) writesPayload() bool { return true }
+
+func TestClientTrackQueueSharedPacket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := rtppool.New()
+
+	mutating := &mutatingTestTrack{
+		queueTestTrack: newQueueTestTrack(ctx, webrtc.RTPCodecTypeVideo, 3),
+		payloads:       make(chan []byte, 1),
+	}
+
+	header := &rtp.Header{SequenceNumber: 1, Extension: true, ExtensionProfile: 0xBEDE}
+	require.NoError(t, header.SetExtension(1, []byte{0x01}))
+
+	packet := pool.NewPacket(header, []byte{0x01, 0x02}, nil)
+	pushClientTrack(mutating, packet, QualityHigh)
+
+	require.Equal(t, []byte{0xff, 0x02}, <-mutating.payloads)
+
+	// the shared packet is not modified by the subscriber
+	require.Equal(t, uint16(1), packet.Header().SequenceNumber)
+	require.Equal(t, []byte{0x01}, packet.Header().GetExtension(1))
+	require.Equal(t, []byte{0x01, 0x02}, packet.Payload())
+
+	packet.Release()
+
+	require.Equal(t, rtppool.Stats{}, pool.Stats())
+}
+
+func TestClientTrackQueueFanOutWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &Client{id: "subscriber", context: ctx}
+	client.sfu.Store(&SFU{fanOut: newFanOutScheduler(ctx, 2)})
+
+	tracks := make([]*queueTestTrack, 0)
+
+	for i := 0; i < 4; i++ {
+		track := newQueueTestTrack(ctx, webrtc.RTPCodecTypeAudio, 100)
+		track.client = client
+		close(track.unblock)
+
+		tracks = append(tracks, track)
+	}
+
+	for _, track := range tracks {
+		pushSequences(track, 1, 50)
+	}
+
+	for _, track := range tracks {
+		require.Eventually(t, func() bool {
+			return len(track.writtenPackets()) == 50
+		}, time.Second, time.Millisecond)
+
+		for i, seq := range track.writtenPackets() {
+			require.Equal(t, uint16(i+1), seq)
+		}
+
+		require.Equal(t, rtppool.Stats{}, track.pool.Stats())
+	}
+
+	require.Equal(t, int64(0), client.resources.queued.Load())
+	require.Equal(t, int64(0), client.resources.goroutines.Load())
+}
+
+func TestClientTrackQueueFanOutEnded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &Client{id: "subscriber", context: ctx}
+	client.sfu.Store(&SFU{fanOut: newFanOutScheduler(ctx, 1)})
+
+	blocking := newQueueTestTrack(ctx, webrtc.RTPCodecTypeVideo, 10)
+	blocking.client = client
+
+	trackCtx, endTrack := context.WithCancel(ctx)
+	ended := newQueueTestTrack(trackCtx, webrtc.RTPCodecTypeVideo, 10)
+	ended.client = client
+	close(ended.unblock)
+
+	// the only worker is blocked, so the packets of the other track stay in the queue until the track is ended
+	pushSequences(blocking, 1, 1)
+	require.Eventually(t, func() bool {
+		return blocking.queueLen() == 0
+	}, time.Second, time.Millisecond)
+
+	pushSequences(ended, 1, 4)
+	require.Equal(t, 4, ended.queueLen())
+
+	endTrack()
+	close(blocking.unblock)
+
+	require.Eventually(t, func() bool {
+		return ended.pool.Stats() == rtppool.Stats{}
+	}, time.Second, time.Millisecond)
+
+	require.Empty(t, ended.writtenPackets())
+	require.Equal(t, int64(0), client.resources.queued.Load())
+}
+
+// fanOutBenchTrack is a subscribed track that only counts the written packets
+type fanOutBenchTrack struct {
+	*queueTestTrack
+	wg *sync.WaitGroup
+}
+
+func (t *fanOutBenchTrack) push(_ *rtp.Packet, _ QualityLevel) {
+	t.wg.Done()
+}
+
+// BenchmarkFanOut writes every packet to 1000 subscribers, with a goroutine per subscribed track and with the workers
+func BenchmarkFanOut(b *testing.B) {
+	const subscribers = 1000
+
+	for _, workers := range []int{0, runtime.GOMAXPROCS(0)} {
+		name := "goroutines"
+		if workers > 0 {
+			name = fmt.Sprintf("workers-%d", workers)
+		}
+
+		b.Run(name, func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			client := &Client{id: "subscriber", context: ctx}
+			client.sfu.Store(&SFU{})
+			if workers > 0 {
+				client.SFU().fanOut = newFanOutScheduler(ctx, workers)
+			}
+
+			pool := rtppool.New()
+			wg := &sync.WaitGroup{}
+
+			tracks := make([]*fanOutBenchTrack, subscribers)
+			for i := range tracks {
+				track := newQueueTestTrack(ctx, webrtc.RTPCodecTypeVideo, 256)
+				track.client = client
+				tracks[i] = &fanOutBenchTrack{queueTestTrack: track, wg: wg}
+			}
+
+			payload := make([]byte, 1200)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				wg.Add(subscribers)
+
+				packet := pool.NewPacket(&rtp.Header{SequenceNumber: uint16(i)}, payload, nil)
+				for _, track := range tracks {
+					pushClientTrack(track, packet, QualityHigh)
+				}
+
+				packet.Release()
+
+				wg.Wait()
+			}
+
+			b.StopTimer()
+			b.ReportMetric(float64(client.resources.goroutines.Load()), "goroutines")
+		})
+	}
+}
@@ -3,6 +3,7 @@ package sfu
 import (
 	"encoding/binary"
 	"errors"
+	"sync"
 
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
@@ -13,6 +14,14 @@ var (
 	ErrIncompleteRedBlock  = errors.New("util: incomplete RED block")
 )
 
+const (
+	// the Opus payload type inside the RED payload, it's the same as the registered Opus codec
+	redPrimaryPayloadType = 111
+	// the RED header only has 14 bits of timestamp offset and 10 bits of block length
+	redMaxTimestampOffset = 1<<14 - 1
+	redMaxBlockLength     = 1<<10 - 1
+)
+
 type clientTrackRed struct {
 	*clientTrackAudio
 }
@@ -34,11 +43,23 @@ func (t *clientTrackRed) push(p *rtp.Packet, _ QualityLevel) {
 		primaryPacket := t.remoteTrack.rtppool.GetPacket()
 		primaryPacket.Payload = t.getPrimaryEncoding(p.Payload[:len(p.Payload)])
 		primaryPacket.Header = p.Header
+		// the primary encoding is a slice of the shared payload, it must not be cleared by the pool
+		defer func() {
+			primaryPacket.Payload = nil
+			t.remoteTrack.rtppool.PutPacket(primaryPacket)
+		}()
+		if !t.baseTrack.intercept(PacketEgress, t.client, QualityHigh, primaryPacket) {
+			return
+		}
+		t.setCaptureTime(primaryPacket)
 		if err := t.localTrack.WriteRTP(primaryPacket); err != nil {
 			t.client.log.Tracef("clienttrack: error on write primary rtp %s", err.Error())
 		}
-		t.remoteTrack.rtppool.PutPacket(primaryPacket)
 	} else {
+		if !t.baseTrack.intercept(PacketEgress, t.client, QualityHigh, p) {
+			return
+		}
+		t.setCaptureTime(p)
 		if err := t.localTrack.WriteRTP(p); err != nil {
 			t.client.log.Tracef("clienttrack: error on write rtp %s", err.Error())
 		}
@@ -107,3 +128,105 @@ func (t *clientTrackRed) MaxQuality() QualityLevel {
 
 	return QualityAudioRed
 }
+
+type redBlock struct {
+	timestamp uint32
+	payload   []byte
+}
+
+// clientTrackRedEncoder sends the plain Opus from the publisher as RED to the subscriber that supports it,
+// every packet carries the previous packets as the redundancy so a lost packet can be recovered from the next one
+type clientTrackRedEncoder struct {
+	*clientTrackAudio
+	mu       sync.Mutex
+	distance int
+	history  []redBlock
+}
+
+func newClientTrackRedEncoder(t *clientTrackAudio, distance int) *clientTrackRedEncoder {
+	return &clientTrackRedEncoder{
+		clientTrackAudio: t,
+		distance:         distance,
+		history:          make([]redBlock, 0, distance),
+	}
+}
+
+func (t *clientTrackRedEncoder) push(p *rtp.Packet, _ QualityLevel) {
+	if t.client.peerConnection.PC().ConnectionState() != webrtc.PeerConnectionStateConnected {
+		return
+	}
+
+	redPacket := t.remoteTrack.rtppool.GetPacket()
+	redPacket.Header = p.Header
+	redPacket.Payload = t.encode(p.Timestamp, p.Payload)
+
+	if !t.baseTrack.intercept(PacketEgress, t.client, QualityHigh, redPacket) {
+		t.remoteTrack.rtppool.PutPacket(redPacket)
+		return
+	}
+
+	t.setCaptureTime(redPacket)
+
+	if err := t.localTrack.WriteRTP(redPacket); err != nil {
+		t.client.log.Tracef("clienttrack: error on write red rtp %s", err.Error())
+	}
+
+	t.remoteTrack.rtppool.PutPacket(redPacket)
+}
+
+// encode returns the RED payload of the primary payload and the previous payloads as the redundant blocks
+func (t *clientTrackRedEncoder) encode(timestamp uint32, primary []byte) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	redundant := make([]redBlock, 0, len(t.history))
+
+	for _, block := range t.history {
+		offset := timestamp - block.timestamp
+		if offset == 0 || offset > redMaxTimestampOffset || len(block.payload) > redMaxBlockLength {
+			continue
+		}
+
+		redundant = append(redundant, block)
+	}
+
+	size := 1 + len(primary)
+	for _, block := range redundant {
+		size += 4 + len(block.payload)
+	}
+
+	payload := make([]byte, 0, size)
+
+	for _, block := range redundant {
+		header := uint32(1)<<31 | uint32(redPrimaryPayloadType)<<24 | (timestamp-block.timestamp)<<10 | uint32(len(block.payload))
+		payload = binary.BigEndian.AppendUint32(payload, header)
+	}
+
+	payload = append(payload, redPrimaryPayloadType)
+
+	for _, block := range redundant {
+		payload = append(payload, block.payload...)
+	}
+
+	payload = append(payload, primary...)
+
+	// the primary payload buffer is reused by the track after the push
+	if len(t.history) == t.distance {
+		t.history = append(t.history[:0], t.history[1:]...)
+	}
+
+	t.history = append(t.history, redBlock{
+		timestamp: timestamp,
+		payload:   append([]byte{}, primary...),
+	})
+
+	return payload
+}
+
+func (t *clientTrackRedEncoder) Quality() QualityLevel {
+	return QualityAudioRed
+}
+
+func (t *clientTrackRedEncoder) MaxQuality() QualityLevel {
+	return QualityAudioRed
+}
@@ -4,12 +4,24 @@ import (
 	"context"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/inlivedev/sfu/pkg/dependencydescriptor"
+	"github.com/inlivedev/sfu/pkg/framemarking"
 	"github.com/inlivedev/sfu/pkg/packetmap"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
 )
 
+const maxTemporalID = 2
+
+type temporalLayerInfo struct {
+	startOfFrame bool
+	tid          uint8
+	// switchable is true if the frame is a switch point to its temporal layer
+	switchable bool
+}
+
 type simulcastClientTrack struct {
 	id                      string
 	streamid                string
@@ -22,17 +34,25 @@ type simulcastClientTrack struct {
 	remoteTrack             *SimulcastTrack
 	baseTrack               *baseTrack
 	lastBlankSequenceNumber *atomic.Uint32
-	sequenceNumber          *atomic.Uint32
+	layerSwitcher           *LayerSwitcher
 	lastQuality             *atomic.Uint32
 	paddingTS               *atomic.Uint32
 	maxQuality              *atomic.Uint32
 	lastTimestamp           *atomic.Uint32
+	lastKeyframeRequest     *atomic.Int64
 	isScreen                *atomic.Bool
 	isEnded                 *atomic.Bool
 	packetmapHigh           *packetmap.Map
 	packetmapMid            *packetmap.Map
 	packetmapLow            *packetmap.Map
 	onTrackEndedCallbacks   []func()
+	queue                   *clientTrackQueue
+	// current forwarded temporal layer, only used when the temporal layer info is available
+	tid uint8
+	// latest dependency descriptor structure for each simulcast layer
+	structures map[QualityLevel]*dependencydescriptor.FrameDependencyStructure
+	// the padding packets that the downlink probe requested, see writePadding
+	pendingPadding atomic.Int32
 }
 
 func newSimulcastClientTrack(c *Client, t *SimulcastTrack) *simulcastClientTrack {
@@ -46,8 +66,6 @@ func newSimulcastClientTrack(c *Client, t *SimulcastTrack) *simulcastClientTrack
 
 	lastQuality := &atomic.Uint32{}
 
-	sequenceNumber := &atomic.Uint32{}
-
 	lastTimestamp := &atomic.Uint32{}
 
 	ctx, cancel := context.WithCancel(t.context)
@@ -63,18 +81,22 @@ func newSimulcastClientTrack(c *Client, t *SimulcastTrack) *simulcastClientTrack
 		localTrack:              track,
 		remoteTrack:             t,
 		baseTrack:               t.base,
-		sequenceNumber:          sequenceNumber,
+		layerSwitcher:           NewLayerSwitcher(t.base.codec.ClockRate),
 		lastQuality:             lastQuality,
 		paddingTS:               &atomic.Uint32{},
 		maxQuality:              &atomic.Uint32{},
 		lastBlankSequenceNumber: &atomic.Uint32{},
 		lastTimestamp:           lastTimestamp,
+		lastKeyframeRequest:     &atomic.Int64{},
 		isScreen:                isScreen,
 		isEnded:                 &atomic.Bool{},
 		onTrackEndedCallbacks:   make([]func(), 0),
 		packetmapHigh:           &packetmap.Map{},
 		packetmapMid:            &packetmap.Map{},
 		packetmapLow:            &packetmap.Map{},
+		tid:                     maxTemporalID,
+		structures:              make(map[QualityLevel]*dependencydescriptor.FrameDependencyStructure),
+		queue:                   newClientTrackQueue(track.Kind(), c.options.WriteQueueSize),
 	}
 
 	ct.SetMaxQuality(QualityHigh)
@@ -95,12 +117,16 @@ func (t *simulcastClientTrack) Client() *Client {
 	return t.client
 }
 
+func (t *simulcastClientTrack) writeQueue() *clientTrackQueue {
+	return t.queue
+}
+
 func (t *simulcastClientTrack) Context() context.Context {
 	return t.context
 }
 
 func (t *simulcastClientTrack) isFirstKeyframePacket(p *rtp.Packet) bool {
-	isKeyframe := IsKeyframe(t.mimeType, p.Payload)
+	isKeyframe := t.baseTrack.isKeyframe(p)
 
 	return isKeyframe && t.lastTimestamp.Load() != p.Timestamp
 }
@@ -108,25 +134,54 @@ func (t *simulcastClientTrack) isFirstKeyframePacket(p *rtp.Packet) bool {
 func (t *simulcastClientTrack) send(p *rtp.Packet, quality QualityLevel) {
 	t.lastTimestamp.Store(p.Timestamp)
 
-	t.rewritePacket(p, quality)
+	// the capture time is looked up with the timestamp of the layer before it's rewritten
+	sourceTS := p.Timestamp
+
+	if !t.rewritePacket(p, quality) {
+		return
+	}
 
 	// t.client.log.Infof("track: ", t.id, " send packet with quality ", quality, " and sequence number ", p.SequenceNumber)
 
+	if !t.baseTrack.intercept(PacketEgress, t.client, quality, p) {
+		return
+	}
+
+	if remoteTrack := t.remoteTrack.GetRemoteTrack(quality); remoteTrack != nil {
+		t.client.setCaptureTime(t.localTrack, remoteTrack.captureClock, sourceTS, p.Timestamp)
+	}
+
 	t.writeRTP(p)
+
+	// the probe padding is only inserted between the frames
+	if p.Marker {
+		t.writePendingPadding()
+	}
+}
+
+// writesPayload returns true if the packet can be modified by the egress interceptors
+func (t *simulcastClientTrack) writesPayload() bool {
+	return t.baseTrack.hasInterceptors(PacketEgress)
 }
 
 func (t *simulcastClientTrack) writeRTP(p *rtp.Packet) {
 	if err := t.localTrack.WriteRTP(p); err != nil {
-		t.client.log.Errorf("track: error on write rtp", err)
+		t.client.hotPathLog.Errorf("track: error on write rtp", err)
 	}
 }
 
 func (t *simulcastClientTrack) push(p *rtp.Packet, quality QualityLevel) {
-	isKeyframe := IsKeyframe(t.mimeType, p.Payload)
+	if t.client.isTrackPaused(t.ID()) {
+		t.dropPaused(p, quality)
+		return
+	}
+
+	isKeyframe := t.baseTrack.isKeyframe(p)
 
-	currentQuality := t.LastQuality()
+	currentQuality, _ := t.simulcastLayer(t.LastQuality())
 
-	targetQuality := t.getQuality()
+	targetQuality, targetTID := t.simulcastLayer(t.getQuality())
+	targetTID = min(targetTID, t.client.maxTemporalLayer(t.ID()))
 
 	if targetQuality == QualityNone {
 		// TODO: figure out what to do if the target quality is none
@@ -178,42 +233,168 @@ func (t *simulcastClientTrack) push(p *rtp.Packet, quality QualityLevel) {
 	}
 
 	// check if it's a first packet to send
-	if currentQuality == QualityNone && t.sequenceNumber.Load() == 0 {
-		// we try to send the low quality first	if the track is active and fallback to upper quality if not
-		if t.remoteTrack.GetRemoteTrack(QualityLow) != nil && quality == QualityLow {
-			t.lastQuality.Store(uint32(QualityLow))
-			// send PLI to make sure the client will receive the first frame
-			t.remoteTrack.sendPLI()
-		} else if t.remoteTrack.GetRemoteTrack(QualityMid) != nil && quality == QualityMid {
-			t.lastQuality.Store(uint32(QualityMid))
-			// send PLI to make sure the client will receive the first frame
-			t.remoteTrack.sendPLI()
-		} else if t.remoteTrack.GetRemoteTrack(QualityHigh) != nil && quality == QualityHigh {
-			t.lastQuality.Store(uint32(QualityHigh))
-			// send PLI to make sure the client will receive the first frame
-			t.remoteTrack.sendPLI()
+	if _, started := t.layerSwitcher.Layer(); currentQuality == QualityNone && !started {
+		// we try to start from the low quality if the track is available and fallback to upper quality if not.
+		// Only start from a keyframe, the subscriber can't decode the frames before it.
+		if first := t.firstLayer(); quality == first {
+			if isKeyframe {
+				currentQuality = first
+				t.lastQuality.Store(uint32(first))
+			} else {
+				t.requestSwitchKeyframe(first)
+			}
 		}
-
-		t.remoteTrack.onRemoteTrackAdded(func(remote *remoteTrack) {
-			t.remoteTrack.sendPLI()
-		})
-	} else if isKeyframe && canSwitch && quality == targetQuality && t.lastQuality.Load() != uint32(targetQuality) {
+	} else if isKeyframe && canSwitch && quality == targetQuality && currentQuality != targetQuality {
 		// change quality to target quality if it's a keyframe
 		t.client.log.Tracef("track: %s keyframe %v change quality from %d to %d ", t.id, isKeyframe, t.lastQuality.Load(), targetQuality)
 		currentQuality = targetQuality
 		t.lastQuality.Store(uint32(currentQuality))
+		t.resetTemporalLayer(targetTID)
 
-	} else if quality == targetQuality && !isKeyframe && t.lastQuality.Load() != uint32(targetQuality) {
-		// request PLI to allow us switch quality to target quality
+	} else if quality == targetQuality && currentQuality != targetQuality {
+		// keep forwarding the current layer until the keyframe of the target layer is received
 		t.client.log.Tracef("track: %s keyframe %v send keyframe and sequence number %d and can switch %v ", t.id, isKeyframe, p.SequenceNumber, canSwitch)
-		t.remoteTrack.sendPLI()
+		t.requestSwitchKeyframe(targetQuality)
 	}
 
 	if currentQuality == quality {
+		if t.isDroppedTemporalLayer(p, quality, targetTID) {
+			t.layerSwitcher.Drop(quality, p)
+			return
+		}
+
 		t.send(p, quality)
 	}
 }
 
+// firstLayer returns the layer to start forwarding, the lowest active layer so the first frame is received fast
+func (t *simulcastClientTrack) firstLayer() QualityLevel {
+	for _, quality := range []QualityLevel{QualityLow, QualityMid, QualityHigh} {
+		if t.remoteTrack.isTrackActive(quality) {
+			return quality
+		}
+	}
+
+	return QualityNone
+}
+
+// requestSwitchKeyframe requests a keyframe on the layer to switch to, the request is repeated every
+// simulcastKeyframeRequestInterval until the keyframe is received
+func (t *simulcastClientTrack) requestSwitchKeyframe(quality QualityLevel) {
+	now := time.Now().UnixNano()
+	last := t.lastKeyframeRequest.Load()
+
+	if now-last < int64(simulcastKeyframeRequestInterval) || !t.lastKeyframeRequest.CompareAndSwap(last, now) {
+		return
+	}
+
+	t.remoteTrack.sendPLIAt(quality)
+}
+
+// dropPaused drops the packet while the track is paused. The last quality is reset,
+// so the track is switched to the target quality on the next keyframe after resumed.
+func (t *simulcastClientTrack) dropPaused(p *rtp.Packet, quality QualityLevel) {
+	switch quality {
+	case QualityHigh:
+		t.packetmapHigh.Drop(p.SequenceNumber, 0)
+	case QualityMid:
+		t.packetmapMid.Drop(p.SequenceNumber, 0)
+	case QualityLow:
+		t.packetmapLow.Drop(p.SequenceNumber, 0)
+	}
+
+	t.lastQuality.Store(uint32(QualityNone))
+}
+
+// simulcastLayer returns the simulcast layer and the temporal layer ID of the quality level
+func (t *simulcastClientTrack) simulcastLayer(quality QualityLevel) (QualityLevel, uint8) {
+	tid := t.client.SFU().trackQualityPreset(quality, t.IsScreen()).TID
+
+	switch quality {
+	case QualityHigh, QualityHighMid, QualityHighLow:
+		return QualityHigh, tid
+	case QualityMid, QualityMidMid, QualityMidLow:
+		return QualityMid, tid
+	case QualityLow, QualityLowMid, QualityLowLow:
+		return QualityLow, tid
+	}
+
+	return quality, maxTemporalID
+}
+
+func (t *simulcastClientTrack) resetTemporalLayer(tid uint8) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tid = tid
+}
+
+// temporalLayer reads the temporal layer of the packet from the frame marking or dependency descriptor extension.
+// It returns false if the packet is not carrying any of them.
+func (t *simulcastClientTrack) temporalLayer(p *rtp.Packet, quality QualityLevel) (temporalLayerInfo, bool) {
+	if extID := t.baseTrack.frameMarkingExtID.Load(); extID != 0 {
+		if ext := p.Header.GetExtension(uint8(extID)); ext != nil {
+			fm, err := framemarking.Unmarshal(ext)
+			if err == nil {
+				return temporalLayerInfo{
+					startOfFrame: fm.StartOfFrame,
+					tid:          fm.TemporalID,
+					switchable:   fm.Independent || fm.BaseLayerSync,
+				}, true
+			}
+		}
+	}
+
+	if extID := t.baseTrack.dependencyDescriptorExtID.Load(); extID != 0 {
+		if ext := p.Header.GetExtension(uint8(extID)); ext != nil {
+			dd, err := dependencydescriptor.Unmarshal(ext, t.structures[quality])
+			if err != nil {
+				return temporalLayerInfo{}, false
+			}
+
+			if dd.AttachedStructure != nil {
+				t.structures[quality] = dd.AttachedStructure
+			}
+
+			info := temporalLayerInfo{
+				startOfFrame: dd.StartOfFrame,
+				tid:          dd.TemporalID,
+				switchable:   dd.AttachedStructure != nil,
+			}
+
+			if dt := t.structures[quality].DecodeTarget(dd.SpatialID, dd.TemporalID); dt >= 0 && dt < len(dd.DTIs) {
+				info.switchable = info.switchable || dd.DTIs[dt] == dependencydescriptor.DTISwitch
+			}
+
+			return info, true
+		}
+	}
+
+	return temporalLayerInfo{}, false
+}
+
+// isDroppedTemporalLayer checks if the packet belongs to a temporal layer higher than the target.
+// The forwarded temporal layer only changes on the frame boundary, scaling up also requires a switch frame.
+func (t *simulcastClientTrack) isDroppedTemporalLayer(p *rtp.Packet, quality QualityLevel, targetTID uint8) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info, ok := t.temporalLayer(p, quality)
+	if !ok {
+		return false
+	}
+
+	if info.startOfFrame {
+		if t.tid > targetTID {
+			t.tid = targetTID
+		} else if t.tid < targetTID && t.tid < info.tid && info.tid <= targetTID && info.switchable {
+			t.tid = info.tid
+		}
+	}
+
+	return info.tid > t.tid
+}
+
 func (t *simulcastClientTrack) GetRemoteTrack() *remoteTrack {
 	lastQuality := Uint32ToQualityLevel(t.lastQuality.Load())
 	// lastQuality := t.lastQuality
@@ -312,54 +493,40 @@ func (t *simulcastClientTrack) IsScaleable() bool {
 	return false
 }
 
-func (t *simulcastClientTrack) rewritePacket(p *rtp.Packet, quality QualityLevel) {
-	t.remoteTrack.mu.RLock()
-	defer t.remoteTrack.mu.RUnlock()
-	// make sure the timestamp and sequence number is consistent from the previous packet even it is not the same track
-	sequenceDelta := uint16(0)
-	// credit to https://github.com/k0nserv for helping me with this on Pion Slack channel
-	switch quality {
-	case QualityHigh:
-		p.Timestamp = t.remoteTrack.baseTS + ((p.Timestamp - t.remoteTrack.remoteTrackHighBaseTS) - t.remoteTrack.remoteTrackHighBaseTS)
-		sequenceDelta = t.remoteTrack.highSequence - t.remoteTrack.lastHighSequence
-	case QualityMid:
-		p.Timestamp = t.remoteTrack.baseTS + ((p.Timestamp - t.remoteTrack.remoteTrackMidBaseTS) - t.remoteTrack.remoteTrackMidBaseTS)
-		sequenceDelta = t.remoteTrack.midSequence - t.remoteTrack.lastMidSequence
-	case QualityLow:
-		p.Timestamp = t.remoteTrack.baseTS + ((p.Timestamp - t.remoteTrack.remoteTrackLowBaseTS) - t.remoteTrack.remoteTrackLowBaseTS)
-		sequenceDelta = t.remoteTrack.lowSequence - t.remoteTrack.lastLowSequence
-	}
-
-	t.sequenceNumber.Add(uint32(sequenceDelta))
-	p.SequenceNumber = uint16(t.sequenceNumber.Load())
+// rewritePacket keeps the sequence numbers and timestamps continuous across the layer switches,
+// it returns false if the packet can't be forwarded
+func (t *simulcastClientTrack) rewritePacket(p *rtp.Packet, quality QualityLevel) bool {
+	return t.layerSwitcher.Rewrite(quality, p)
 }
 
 func (t *simulcastClientTrack) RequestPLI() {
 	t.remoteTrack.sendPLI()
 }
 
-func (t *simulcastClientTrack) getQuality() QualityLevel {
-	track := t.remoteTrack
-
+// targetQuality returns the quality that selected for the subscriber, regardless of the layers that currently sent
+// by the publisher
+func (t *simulcastClientTrack) targetQuality() QualityLevel {
 	claim := t.Client().bitrateController.GetClaim(t.ID())
 
 	if claim == nil {
 		return QualityNone
 	}
 
-	quality := min(claim.Quality(), t.MaxQuality(), Uint32ToQualityLevel(t.client.quality.Load()))
+	return min(claim.Quality(), t.MaxQuality(), Uint32ToQualityLevel(t.client.quality.Load()))
+}
 
-	if quality != QualityNone && !track.isTrackActive(quality) {
-		if quality != QualityLow && track.isTrackActive(QualityLow) {
-			return QualityLow
-		}
+func (t *simulcastClientTrack) getQuality() QualityLevel {
+	track := t.remoteTrack
 
-		if quality != QualityMid && track.isTrackActive(QualityMid) {
-			return QualityMid
-		}
+	quality := t.targetQuality()
+	if quality == QualityNone {
+		return quality
+	}
 
-		if quality != QualityHigh && track.isTrackActive(QualityHigh) {
-			return QualityHigh
+	// switch to the nearest active layer while the selected layer is not sent by the publisher
+	if layer, _ := t.simulcastLayer(quality); !track.isTrackActive(layer) {
+		if fallback, ok := nearestActiveLayer(layer, track.isTrackActive); ok {
+			return fallback
 		}
 	}
 
@@ -0,0 +1,86 @@
+package sfu
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSimulcastTrack returns a simulcast track with the layers that count the keyframe requests
+func newTestSimulcastTrack(plis map[QualityLevel]*atomic.Int32) *SimulcastTrack {
+	log := logging.NewDefaultLoggerFactory().NewLogger("test")
+
+	layer := func(quality QualityLevel) *remoteTrack {
+		plis[quality] = &atomic.Int32{}
+
+		return &remoteTrack{onPLI: func() {
+			plis[quality].Add(1)
+		}}
+	}
+
+	return &SimulcastTrack{
+		base:            &baseTrack{client: &Client{log: log, hotPathLog: log}},
+		remoteTrackHigh: layer(QualityHigh),
+		remoteTrackMid:  layer(QualityMid),
+		remoteTrackLow:  layer(QualityLow),
+		lastReadHighTS:  &atomic.Int64{},
+		lastReadMidTS:   &atomic.Int64{},
+		lastReadLowTS:   &atomic.Int64{},
+	}
+}
+
+func TestSimulcastClientTrackFirstLayer(t *testing.T) {
+	track := newTestSimulcastTrack(make(map[QualityLevel]*atomic.Int32))
+	ct := &simulcastClientTrack{remoteTrack: track}
+
+	require.Equal(t, QualityLevel(QualityNone), ct.firstLayer())
+
+	now := time.Now().UnixNano()
+
+	// the lowest active layer is the first layer
+	track.lastReadHighTS.Store(now)
+	require.Equal(t, QualityLevel(QualityHigh), ct.firstLayer())
+
+	track.lastReadMidTS.Store(now)
+	require.Equal(t, QualityLevel(QualityMid), ct.firstLayer())
+
+	// the layer that stopped is skipped
+	track.lastReadLowTS.Store(time.Now().Add(-2 * simulcastLayerInactiveThreshold).UnixNano())
+	require.Equal(t, QualityLevel(QualityMid), ct.firstLayer())
+
+	track.lastReadLowTS.Store(now)
+	require.Equal(t, QualityLevel(QualityLow), ct.firstLayer())
+}
+
+func TestSimulcastClientTrackSwitchKeyframeThrottle(t *testing.T) {
+	plis := make(map[QualityLevel]*atomic.Int32)
+	ct := &simulcastClientTrack{remoteTrack: newTestSimulcastTrack(plis), lastKeyframeRequest: &atomic.Int64{}}
+
+	// the requests of every packet before the keyframe is received are sent once per interval
+	for i := 0; i < 10; i++ {
+		ct.requestSwitchKeyframe(QualityMid)
+	}
+
+	require.Eventually(t, func() bool {
+		return plis[QualityMid].Load() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// only the layer to switch to is requested
+	require.Zero(t, plis[QualityHigh].Load())
+	require.Zero(t, plis[QualityLow].Load())
+
+	// repeated after the interval
+	ct.lastKeyframeRequest.Store(time.Now().Add(-simulcastKeyframeRequestInterval).UnixNano())
+	ct.requestSwitchKeyframe(QualityMid)
+	ct.requestSwitchKeyframe(QualityMid)
+
+	require.Eventually(t, func() bool {
+		return plis[QualityMid].Load() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, int32(2), plis[QualityMid].Load())
+}
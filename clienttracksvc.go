@@ -78,6 +78,61 @@ type QualityPresets struct {
 	LowLow  QualityPreset `json:"lowlow"`
 }
 
+func newQualityPresets(presets map[QualityLevel]QualityPreset) QualityPresets {
+	return QualityPresets{
+		High:    presets[QualityHigh],
+		HighMid: presets[QualityHighMid],
+		HighLow: presets[QualityHighLow],
+		Mid:     presets[QualityMid],
+		MidMid:  presets[QualityMidMid],
+		MidLow:  presets[QualityMidLow],
+		Low:     presets[QualityLow],
+		LowMid:  presets[QualityLowMid],
+		LowLow:  presets[QualityLowLow],
+	}
+}
+
+func (q QualityPresets) get(lvl QualityLevel) QualityPreset {
+	switch lvl {
+	case QualityHigh:
+		return q.High
+	case QualityHighMid:
+		return q.HighMid
+	case QualityHighLow:
+		return q.HighLow
+	case QualityMid:
+		return q.Mid
+	case QualityMidMid:
+		return q.MidMid
+	case QualityMidLow:
+		return q.MidLow
+	case QualityLow:
+		return q.Low
+	case QualityLowMid:
+		return q.LowMid
+	case QualityLowLow:
+		return q.LowLow
+	}
+
+	return DefaultQualityPresets[lvl]
+}
+
+// SVCQualityLevels enables all the spatial and temporal layers combinations, so the bitrate controller can reduce
+// the frame rate before reducing the resolution. Use it as RoomOptions.QualityLevels when the publishers use L3T3 SVC.
+func SVCQualityLevels() []QualityLevel {
+	return []QualityLevel{
+		QualityHigh,
+		QualityHighMid,
+		QualityHighLow,
+		QualityMid,
+		QualityMidMid,
+		QualityMidLow,
+		QualityLow,
+		QualityLowMid,
+		QualityLowLow,
+	}
+}
+
 func DefaultQualityLevels() []QualityLevel {
 	return []QualityLevel{
 		QualityHigh,
@@ -138,7 +193,11 @@ func (t *scaleableClientTrack) getQuality() QualityLevel {
 	claim := t.client.bitrateController.GetClaim(t.ID())
 
 	if claim == nil {
-		t.client.log.Warnf("scalabletrack: claim is nil")
+		t.client.hotPathLog.Warnf("scalabletrack: claim is nil")
+		return QualityNone
+	}
+
+	if t.client.isTrackPaused(t.ID()) {
 		return QualityNone
 	}
 
@@ -156,10 +215,10 @@ func (t *scaleableClientTrack) push(p *rtp.Packet, _ QualityLevel) {
 
 	quality := t.getQuality()
 
-	qualityPreset := qualityLevelToPreset(quality)
+	qualityPreset := t.client.SFU().trackQualityPreset(quality, t.IsScreen())
 
 	targetSID := qualityPreset.GetSID()
-	targetTID := qualityPreset.GetTID()
+	targetTID := min(qualityPreset.GetTID(), t.client.maxTemporalLayer(t.ID()))
 
 	if !t.init {
 		t.init = true
@@ -250,13 +309,24 @@ func (t *scaleableClientTrack) push(p *rtp.Packet, _ QualityLevel) {
 	t.send(p)
 }
 
+// writesPayload returns true because the marker bit of the VP9 payload descriptor is rewritten
+func (t *scaleableClientTrack) writesPayload() bool {
+	return true
+}
+
 func (t *scaleableClientTrack) send(p *rtp.Packet) {
 	t.mu.Lock()
 	t.lastTimestamp = p.Timestamp
 	t.mu.Unlock()
 
+	if !t.baseTrack.intercept(PacketEgress, t.client, t.LastQuality(), p) {
+		return
+	}
+
+	t.setCaptureTime(p)
+
 	if err := t.localTrack.WriteRTP(p); err != nil {
-		t.client.log.Errorf("scaleabletrack: error on write rtp", err)
+		t.client.hotPathLog.Errorf("scaleabletrack: error on write rtp", err)
 	}
 
 }
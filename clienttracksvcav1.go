@@ -0,0 +1,131 @@
+package sfu
+
+import (
+	"github.com/inlivedev/sfu/pkg/dependencydescriptor"
+	"github.com/pion/rtp"
+)
+
+// scaleableAV1ClientTrack forward AV1 SVC (L1T3, L3T3, etc) layers based on the dependency descriptor
+// header extension. It reuse the quality and bitrate handling of VP9 scaleable client track.
+type scaleableAV1ClientTrack struct {
+	*scaleableClientTrack
+	structure *dependencydescriptor.FrameDependencyStructure
+}
+
+func newScaleableAV1ClientTrack(
+	c *Client,
+	t *Track,
+) *scaleableAV1ClientTrack {
+	return &scaleableAV1ClientTrack{
+		scaleableClientTrack: newScaleableClientTrack(c, t),
+	}
+}
+
+func (t *scaleableAV1ClientTrack) push(p *rtp.Packet, quality QualityLevel) {
+	extID := t.baseTrack.dependencyDescriptorExtID.Load()
+	if extID == 0 {
+		// no dependency descriptor negotiated, forward all layers
+		t.clientTrack.push(p, quality)
+		return
+	}
+
+	ext := p.Header.GetExtension(uint8(extID))
+	if ext == nil {
+		t.clientTrack.push(p, quality)
+		return
+	}
+
+	dd, err := dependencydescriptor.Unmarshal(ext, t.structure)
+	if err != nil {
+		_ = t.packetmap.Drop(p.SequenceNumber, 0)
+		return
+	}
+
+	if dd.AttachedStructure != nil {
+		t.structure = dd.AttachedStructure
+	}
+
+	quality = t.getQuality()
+
+	qualityPreset := t.client.SFU().trackQualityPreset(quality, t.IsScreen())
+
+	targetSID := qualityPreset.GetSID()
+	targetTID := min(qualityPreset.GetTID(), t.client.maxTemporalLayer(t.ID()))
+
+	// make sure the target is not higher than the layers sent by the publisher
+	if maxSID := uint8(t.structure.NumSpatialLayers() - 1); targetSID > maxSID {
+		targetSID = maxSID
+	}
+
+	if maxTID := uint8(t.structure.NumTemporalLayers() - 1); targetTID > maxTID {
+		targetTID = maxTID
+	}
+
+	if !t.init {
+		t.init = true
+		t.sid = targetSID
+		t.tid = targetTID
+	}
+
+	t.lastSequence = p.Header.SequenceNumber
+
+	if dd.StartOfFrame {
+		// scale temporal layer
+		if t.tid < targetTID {
+			if t.tid < dd.TemporalID && dd.TemporalID <= targetTID && t.isSwitchFrame(dd, t.sid, dd.TemporalID) {
+				t.tid = dd.TemporalID
+			}
+		} else if t.tid > targetTID {
+			// dropping higher temporal layers is always safe on frame boundary
+			t.tid = targetTID
+		}
+
+		// scale spatial layer
+		if t.sid < targetSID {
+			if t.sid < dd.SpatialID && dd.SpatialID <= targetSID && t.isSwitchFrame(dd, dd.SpatialID, t.tid) {
+				t.sid = dd.SpatialID
+			}
+		} else if t.sid > targetSID && dd.SpatialID == 0 {
+			// lower spatial layers never depend on the higher ones, scale down on the new temporal unit
+			t.sid = targetSID
+		}
+	}
+
+	if dd.EndOfFrame && t.tid == targetTID && t.sid == targetSID {
+		t.setLastQuality(quality)
+	}
+
+	if dd.TemporalID > t.tid || dd.SpatialID > t.sid || quality == QualityNone {
+		if ok := t.packetmap.Drop(p.SequenceNumber, 0); ok {
+			return
+		}
+	}
+
+	// mark packet as the end of temporal unit if it's the last frame of the forwarded spatial layer
+	if dd.EndOfFrame && dd.SpatialID == t.sid {
+		p.Marker = true
+	}
+
+	ok, newseqno, _ := t.packetmap.Map(p.SequenceNumber, 0)
+	if !ok {
+		return
+	}
+
+	p.SequenceNumber = newseqno
+
+	t.send(p)
+}
+
+// isSwitchFrame check if the frame is a switch point to decode target with the spatial and temporal layer
+func (t *scaleableAV1ClientTrack) isSwitchFrame(dd *dependencydescriptor.DependencyDescriptor, sid, tid uint8) bool {
+	if dd.AttachedStructure != nil {
+		return true
+	}
+
+	dt := t.structure.DecodeTarget(sid, tid)
+	if dt < 0 || dt >= len(dd.DTIs) {
+		return false
+	}
+
+	return dd.DTIs[dt] == dependencydescriptor.DTISwitch
+}
@@ -117,6 +117,15 @@ var (
 			RTPCodecCapability: webrtc.RTPCodecCapability{webrtc.MimeTypeOpus, 48000, 2, "minptime=10;useinbandfec=1", nil},
 			PayloadType:        111,
 		},
+		// G.711 is only registered when it's in the room codecs, it's used to forward the SIP audio without transcoding
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{webrtc.MimeTypePCMU, 8000, 0, "", nil},
+			PayloadType:        0,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{webrtc.MimeTypePCMA, 8000, 0, "", nil},
+			PayloadType:        8,
+		},
 	}
 
 	H264KeyFrame2x2SPS = []byte{
@@ -184,6 +193,93 @@ func RegisterCodecs(m *webrtc.MediaEngine, codecs []string) error {
 	return FlattenErrors(errors)
 }
 
+// RegisterCodecsInOrder registers the codecs in the order of the list instead of the SFU order, the first codec of
+// each kind is preferred when the SFU answers the publisher and offers the tracks to the subscriber. The RTX of each
+// video codec is registered right after it, and the codecs that not in the list are not registered.
+func RegisterCodecsInOrder(m *webrtc.MediaEngine, codecs []string) error {
+	errors := []error{}
+
+	for _, mimeType := range codecs {
+		for _, codec := range audioCodecs {
+			if strings.EqualFold(codec.MimeType, mimeType) {
+				if err := m.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+					errors = append(errors, err)
+				}
+			}
+		}
+
+		for _, codec := range videoCodecs {
+			if codec.MimeType == webrtc.MimeTypeRTX || !strings.EqualFold(codec.MimeType, mimeType) {
+				continue
+			}
+
+			if err := m.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+				errors = append(errors, err)
+			}
+
+			for _, rtx := range videoCodecs {
+				if rtx.MimeType == webrtc.MimeTypeRTX && rtx.SDPFmtpLine == fmt.Sprintf("apt=%d", codec.PayloadType) {
+					if err := m.RegisterCodec(rtx, webrtc.RTPCodecTypeVideo); err != nil {
+						errors = append(errors, err)
+					}
+				}
+			}
+		}
+	}
+
+	return FlattenErrors(errors)
+}
+
+// preferCodecsSDP reorders the payload types of the audio and video media sections of the remote offer by the codec
+// order, the answer follows the codec order of the offer so the publisher sends the preferred codec. The payload types
+// of the codecs that not in the list like RTX keep their order after the listed codecs.
+func preferCodecsSDP(sdp string, codecs []string) string {
+	lines := strings.Split(sdp, "\r\n")
+
+	rank := func(mimeType string) int {
+		for i, codec := range codecs {
+			if strings.EqualFold(codec, mimeType) {
+				return i
+			}
+		}
+
+		return len(codecs)
+	}
+
+	start := -1
+	for i := 0; i <= len(lines); i++ {
+		if i < len(lines) && !strings.HasPrefix(lines[i], "m=") {
+			continue
+		}
+
+		if start >= 0 && (strings.HasPrefix(lines[start], "m=audio") || strings.HasPrefix(lines[start], "m=video")) {
+			fields := strings.Fields(lines[start])
+			kind := strings.TrimPrefix(fields[0], "m=")
+
+			mimeTypes := make(map[string]string)
+			for _, line := range lines[start:i] {
+				if value, ok := strings.CutPrefix(line, "a=rtpmap:"); ok {
+					pt, codec, _ := strings.Cut(value, " ")
+					name, _, _ := strings.Cut(codec, "/")
+					mimeTypes[pt] = kind + "/" + name
+				}
+			}
+
+			if len(fields) > 4 {
+				slices.SortStableFunc(fields[3:], func(a, b string) int {
+					return rank(mimeTypes[a]) - rank(mimeTypes[b])
+				})
+
+				lines[start] = strings.Join(fields, " ")
+			}
+		}
+
+		start = i
+	}
+
+	return strings.Join(lines, "\r\n")
+}
+
 func RegisterDefaultCodecs(m *webrtc.MediaEngine) error {
 	// Default Pion Audio Codecs
 	for _, codec := range audioCodecs {
@@ -0,0 +1,447 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+var (
+	ErrCompositeRecordingActive   = errors.New("compositor: recording is still running")
+	ErrCompositeNoTracks          = errors.New("compositor: no recorded tracks to compose")
+	ErrCompositeUnsupportedFormat = errors.New("compositor: output format is not supported, use .mp4 or .webm")
+)
+
+// Rect is the position and the size of a video tile in the composite frame
+type Rect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// CompositeVideo is a video that visible in the composite frame
+type CompositeVideo struct {
+	ClientID string `json:"client_id"`
+	TrackID  string `json:"track_id"`
+	// Speaking is true when the client of the video is the active speaker.
+	// The voice activity detection must be enabled on the client to detect the active speaker.
+	Speaking bool `json:"speaking"`
+}
+
+// CompositeLayout arranges the video tiles in the composite frame. Arrange is called every time the visible videos
+// or the active speaker changed, the videos are ordered by the time they're published.
+// The returned rects must have the same length with the videos, use a zero size rect to hide a video.
+type CompositeLayout interface {
+	Arrange(width, height int, videos []CompositeVideo) []Rect
+}
+
+// GridLayout arranges the videos in equal size tiles, the incomplete last row is centered
+type GridLayout struct{}
+
+func (GridLayout) Arrange(width, height int, videos []CompositeVideo) []Rect {
+	if len(videos) == 0 {
+		return nil
+	}
+
+	cols := int(math.Ceil(math.Sqrt(float64(len(videos)))))
+	rows := (len(videos) + cols - 1) / cols
+	cellWidth := even(width / cols)
+	cellHeight := even(height / rows)
+
+	rects := make([]Rect, len(videos))
+
+	for i := range videos {
+		row, col := i/cols, i%cols
+
+		inRow := cols
+		if row == rows-1 {
+			inRow = len(videos) - row*cols
+		}
+
+		offsetX := (width - inRow*cellWidth) / 2
+
+		rects[i] = Rect{
+			X:      offsetX + col*cellWidth,
+			Y:      row * cellHeight,
+			Width:  cellWidth,
+			Height: cellHeight,
+		}
+	}
+
+	return rects
+}
+
+// ActiveSpeakerLayout shows the active speaker on the top and the other videos as thumbnails at the bottom.
+// The first video is shown as the speaker until someone is speaking, and the last speaker stays until another client speaks.
+type ActiveSpeakerLayout struct{}
+
+func (ActiveSpeakerLayout) Arrange(width, height int, videos []CompositeVideo) []Rect {
+	if len(videos) == 0 {
+		return nil
+	}
+
+	rects := make([]Rect, len(videos))
+
+	if len(videos) == 1 {
+		rects[0] = Rect{Width: even(width), Height: even(height)}
+		return rects
+	}
+
+	speaker := 0
+
+	for i, video := range videos {
+		if video.Speaking {
+			speaker = i
+			break
+		}
+	}
+
+	mainHeight := even(height * 3 / 4)
+	thumbHeight := even(height - mainHeight)
+	thumbs := len(videos) - 1
+	thumbWidth := even(min(width/thumbs, thumbHeight*16/9))
+	offsetX := (width - thumbs*thumbWidth) / 2
+
+	rects[speaker] = Rect{Width: even(width), Height: mainHeight}
+
+	n := 0
+
+	for i := range videos {
+		if i == speaker {
+			continue
+		}
+
+		rects[i] = Rect{
+			X:      offsetX + n*thumbWidth,
+			Y:      mainHeight,
+			Width:  thumbWidth,
+			Height: thumbHeight,
+		}
+		n++
+	}
+
+	return rects
+}
+
+type CompositeOptions struct {
+	Width     int `json:"width"`
+	Height    int `json:"height"`
+	FrameRate int `json:"frame_rate"`
+	// Layout arranges the video tiles, default is GridLayout
+	Layout CompositeLayout `json:"-"`
+	// FFmpegPath is the path of the ffmpeg binary that used to decode, mix and encode the media
+	FFmpegPath string `json:"ffmpeg_path"`
+}
+
+func DefaultCompositeOptions() CompositeOptions {
+	return CompositeOptions{
+		Width:      1280,
+		Height:     720,
+		FrameRate:  30,
+		Layout:     GridLayout{},
+		FFmpegPath: "ffmpeg",
+	}
+}
+
+// Compositor mixes the audio and lays out the video tiles of a finished recording into a single file.
+// The SFU never decode the media, so the decoding, mixing and encoding are done by ffmpeg from the recorded track files.
+// The layout is changed following the timeline of the recording, when a client publish or unpublish a video,
+// or when the active speaker changed.
+type Compositor struct {
+	recorder *Recorder
+	options  CompositeOptions
+}
+
+type compositeTile struct {
+	input int
+	rect  Rect
+}
+
+type compositeScene struct {
+	start time.Duration
+	end   time.Duration
+	tiles []compositeTile
+}
+
+func NewCompositor(recorder *Recorder, opts CompositeOptions) *Compositor {
+	if opts.Layout == nil {
+		opts.Layout = GridLayout{}
+	}
+
+	if opts.FFmpegPath == "" {
+		opts.FFmpegPath = "ffmpeg"
+	}
+
+	return &Compositor{
+		recorder: recorder,
+		options:  opts,
+	}
+}
+
+// Compose runs ffmpeg to write the composite recording to the output file, the format is decided from the file extension.
+func (c *Compositor) Compose(ctx context.Context, output string) error {
+	args, err := c.Args(output)
+	if err != nil {
+		return err
+	}
+
+	out, err := exec.CommandContext(ctx, c.options.FFmpegPath, args...).CombinedOutput()
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+
+		return fmt.Errorf("compositor: ffmpeg failed: %w: %s", err, lines[len(lines)-1])
+	}
+
+	return nil
+}
+
+// Args returns the ffmpeg arguments to compose the recording, use this to run ffmpeg somewhere else
+func (c *Compositor) Args(output string) ([]string, error) {
+	if !c.recorder.isStopped() {
+		return nil, ErrCompositeRecordingActive
+	}
+
+	var codecArgs []string
+
+	switch strings.ToLower(filepath.Ext(output)) {
+	case ".webm":
+		codecArgs = []string{"-c:v", "libvpx-vp9", "-row-mt", "1", "-c:a", "libopus"}
+	case ".mp4":
+		codecArgs = []string{"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p", "-c:a", "aac", "-movflags", "+faststart"}
+	default:
+		return nil, ErrCompositeUnsupportedFormat
+	}
+
+	tracks := c.recorder.RecordedTracks()
+	if len(tracks) == 0 {
+		return nil, ErrCompositeNoTracks
+	}
+
+	c.recorder.mu.Lock()
+	events := make([]voiceEvent, len(c.recorder.voiceEvents))
+	copy(events, c.recorder.voiceEvents)
+	duration := c.recorder.duration
+	c.recorder.mu.Unlock()
+
+	// keep the original timestamps, all track files are aligned to the recording start
+	args := []string{"-y", "-copyts"}
+	for _, track := range tracks {
+		args = append(args, "-i", track.Path)
+	}
+
+	scenes := c.scenes(tracks, events, duration)
+
+	filters := make([]string, 0)
+	filters = append(filters, fmt.Sprintf("color=c=black:s=%dx%d:r=%d:d=%.3f[base0]", c.options.Width, c.options.Height, c.options.FrameRate, duration.Seconds()))
+
+	// every appearance of a video in a scene needs its own scaled copy
+	appearances := make(map[int]int)
+	for _, scene := range scenes {
+		for _, tile := range scene.tiles {
+			appearances[tile.input]++
+		}
+	}
+
+	inputs := make([]int, 0, len(appearances))
+	for input := range appearances {
+		inputs = append(inputs, input)
+	}
+
+	sort.Ints(inputs)
+
+	for _, input := range inputs {
+		count := appearances[input]
+		if count == 1 {
+			filters = append(filters, fmt.Sprintf("[%d:v]null[v%d_0]", input, input))
+			continue
+		}
+
+		labels := ""
+		for i := 0; i < count; i++ {
+			labels += fmt.Sprintf("[v%d_%d]", input, i)
+		}
+
+		filters = append(filters, fmt.Sprintf("[%d:v]split=%d%s", input, count, labels))
+	}
+
+	used := make(map[int]int)
+	base := 0
+
+	for _, scene := range scenes {
+		for _, tile := range scene.tiles {
+			n := used[tile.input]
+			used[tile.input]++
+
+			r := tile.rect
+			filters = append(filters,
+				fmt.Sprintf("[v%d_%d]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2[t%d_%d]",
+					tile.input, n, r.Width, r.Height, r.Width, r.Height, tile.input, n),
+				fmt.Sprintf("[base%d][t%d_%d]overlay=x=%d:y=%d:eof_action=pass:enable='gte(t,%.3f)*lt(t,%.3f)'[base%d]",
+					base, tile.input, n, r.X, r.Y, scene.start.Seconds(), scene.end.Seconds(), base+1),
+			)
+			base++
+		}
+	}
+
+	// pad the silence before the first audio packet, so the audio is aligned with the video
+	audios := ""
+	audioCount := 0
+
+	for i, track := range tracks {
+		if track.Kind != webrtc.RTPCodecTypeAudio {
+			continue
+		}
+
+		filters = append(filters, fmt.Sprintf("[%d:a]aresample=async=1:first_pts=0[a%d]", i, i))
+		audios += fmt.Sprintf("[a%d]", i)
+		audioCount++
+	}
+
+	if audioCount > 0 {
+		filters = append(filters, fmt.Sprintf("%samix=inputs=%d:duration=longest:normalize=0[aout]", audios, audioCount))
+	}
+
+	args = append(args, "-filter_complex", strings.Join(filters, ";"), "-map", fmt.Sprintf("[base%d]", base))
+
+	if audioCount > 0 {
+		args = append(args, "-map", "[aout]")
+	}
+
+	args = append(args, codecArgs...)
+
+	return append(args, output), nil
+}
+
+// scenes splits the recording into time ranges with the same layout
+func (c *Compositor) scenes(tracks []RecordedTrack, events []voiceEvent, duration time.Duration) []compositeScene {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].at < events[j].at
+	})
+
+	points := []time.Duration{0}
+
+	for _, track := range tracks {
+		if track.Kind == webrtc.RTPCodecTypeVideo {
+			points = append(points, track.Start, track.End)
+		}
+	}
+
+	for _, event := range events {
+		points = append(points, event.at)
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i] < points[j]
+	})
+
+	scenes := make([]compositeScene, 0)
+	// the time when each client start speaking
+	speaking := make(map[string]time.Duration)
+	speaker := ""
+	nextEvent := 0
+
+	for i, start := range points {
+		if start >= duration || (i > 0 && start == points[i-1]) {
+			continue
+		}
+
+		end := duration
+		for _, point := range points[i+1:] {
+			if point > start {
+				end = min(point, duration)
+				break
+			}
+		}
+
+		for ; nextEvent < len(events) && events[nextEvent].at <= start; nextEvent++ {
+			event := events[nextEvent]
+
+			if event.speaking {
+				if _, ok := speaking[event.clientID]; !ok {
+					speaking[event.clientID] = event.at
+				}
+
+				speaker = event.clientID
+
+				continue
+			}
+
+			delete(speaking, event.clientID)
+
+			if event.clientID == speaker {
+				// switch to the latest client that still speaking, otherwise keep the last speaker
+				latest := time.Duration(-1)
+				for clientID, since := range speaking {
+					if since > latest || (since == latest && clientID < speaker) {
+						speaker, latest = clientID, since
+					}
+				}
+			}
+		}
+
+		videos := make([]CompositeVideo, 0)
+		inputs := make([]int, 0)
+
+		for input, track := range tracks {
+			if track.Kind != webrtc.RTPCodecTypeVideo || track.Start > start || track.End <= start {
+				continue
+			}
+
+			videos = append(videos, CompositeVideo{
+				ClientID: track.ClientID,
+				TrackID:  track.TrackID,
+				Speaking: track.ClientID == speaker,
+			})
+			inputs = append(inputs, input)
+		}
+
+		tiles := make([]compositeTile, 0, len(videos))
+
+		rects := c.options.Layout.Arrange(c.options.Width, c.options.Height, videos)
+		for i, rect := range rects {
+			if i >= len(inputs) || rect.Width <= 0 || rect.Height <= 0 {
+				continue
+			}
+
+			tiles = append(tiles, compositeTile{input: inputs[i], rect: rect})
+		}
+
+		// merge with the previous scene when the layout is not changed
+		if len(scenes) > 0 && sameTiles(scenes[len(scenes)-1].tiles, tiles) {
+			scenes[len(scenes)-1].end = end
+			continue
+		}
+
+		scenes = append(scenes, compositeScene{start: start, end: end, tiles: tiles})
+	}
+
+	return scenes
+}
+
+func sameTiles(a, b []compositeTile) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// even rounds down to an even number, most of the encoders require the even frame size
+func even(v int) int {
+	return v &^ 1
+}
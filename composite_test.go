@@ -0,0 +1,98 @@
+package sfu
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGridLayout(t *testing.T) {
+	videos := make([]CompositeVideo, 3)
+
+	rects := GridLayout{}.Arrange(1280, 720, videos)
+	require.Len(t, rects, 3)
+	require.Equal(t, Rect{X: 0, Y: 0, Width: 640, Height: 360}, rects[0])
+	require.Equal(t, Rect{X: 640, Y: 0, Width: 640, Height: 360}, rects[1])
+	// the last row is centered
+	require.Equal(t, Rect{X: 320, Y: 360, Width: 640, Height: 360}, rects[2])
+
+	require.Nil(t, GridLayout{}.Arrange(1280, 720, nil))
+}
+
+func TestActiveSpeakerLayout(t *testing.T) {
+	videos := []CompositeVideo{{ClientID: "a"}, {ClientID: "b", Speaking: true}, {ClientID: "c"}}
+
+	rects := ActiveSpeakerLayout{}.Arrange(1280, 720, videos)
+	require.Len(t, rects, 3)
+	require.Equal(t, Rect{X: 0, Y: 0, Width: 1280, Height: 540}, rects[1])
+	require.Equal(t, 540, rects[0].Y)
+	require.Equal(t, 540, rects[2].Y)
+	require.Equal(t, rects[0].X+rects[0].Width, rects[2].X)
+
+	rects = ActiveSpeakerLayout{}.Arrange(1280, 720, videos[:1])
+	require.Equal(t, Rect{Width: 1280, Height: 720}, rects[0])
+}
+
+func TestCompositorArgs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	recorder := &Recorder{
+		context:  ctx,
+		cancel:   cancel,
+		duration: 10 * time.Second,
+		recordedTracks: []*RecordedTrack{
+			{ClientID: "a", Kind: webrtc.RTPCodecTypeAudio, Path: "a-audio.webm", Start: 0, End: 10 * time.Second},
+			{ClientID: "a", Kind: webrtc.RTPCodecTypeVideo, Path: "a-video.webm", Start: 0, End: 10 * time.Second},
+			{ClientID: "b", Kind: webrtc.RTPCodecTypeVideo, Path: "b-video.mkv", Start: 4 * time.Second, End: 8 * time.Second},
+		},
+		voiceEvents: []voiceEvent{
+			{clientID: "a", at: 2 * time.Second, speaking: true},
+		},
+	}
+
+	compositor := NewCompositor(recorder, DefaultCompositeOptions())
+
+	_, err := compositor.Args("out.mp4")
+	require.ErrorIs(t, err, ErrCompositeRecordingActive)
+
+	cancel()
+
+	_, err = compositor.Args("out.avi")
+	require.ErrorIs(t, err, ErrCompositeUnsupportedFormat)
+
+	// single video, two videos in a grid, then single video again
+	scenes := compositor.scenes(recorder.RecordedTracks(), recorder.voiceEvents, recorder.duration)
+	require.Len(t, scenes, 3)
+	require.Equal(t, 4*time.Second, scenes[0].end)
+	require.Len(t, scenes[1].tiles, 2)
+	require.Equal(t, 8*time.Second, scenes[1].end)
+	require.Len(t, scenes[2].tiles, 1)
+
+	args, err := compositor.Args("out.mp4")
+	require.NoError(t, err)
+	require.Equal(t, "out.mp4", args[len(args)-1])
+	require.Contains(t, args, "-copyts")
+
+	graph := ""
+	for i, arg := range args {
+		if arg == "-filter_complex" {
+			graph = args[i+1]
+		}
+	}
+
+	require.Contains(t, graph, "[1:v]split=3[v1_0][v1_1][v1_2]")
+	require.Contains(t, graph, "[2:v]null[v2_0]")
+	require.Contains(t, graph, "[0:a]aresample=async=1:first_pts=0[a0]")
+	require.Equal(t, 4, strings.Count(graph, "overlay="))
+
+	// the active speaker layout changes when the client start speaking
+	opts := DefaultCompositeOptions()
+	opts.Layout = ActiveSpeakerLayout{}
+	scenes = NewCompositor(recorder, opts).scenes(recorder.RecordedTracks(), recorder.voiceEvents, recorder.duration)
+	require.Len(t, scenes, 3)
+	require.Equal(t, 1280, scenes[1].tiles[0].rect.Width)
+}
@@ -3,6 +3,7 @@ package sfu
 import (
 	"context"
 	"errors"
+	"slices"
 	"sync"
 	"time"
 
@@ -10,13 +11,20 @@ import (
 )
 
 var (
-	ErrDataChannelExists = errors.New("error: data channel already exists")
+	ErrDataChannelExists   = errors.New("error: data channel already exists")
+	ErrDataChannelNotFound = errors.New("error: data channel not found")
 )
 
 type SFUDataChannel struct {
-	label     string
-	clientIDs []string
-	isOrdered bool
+	label          string
+	clientIDs      []string
+	isOrdered      bool
+	maxRetransmits *uint16
+	// the permissions can be updated while the clients are sending messages
+	mu          sync.RWMutex
+	senderIDs   []string
+	receiverIDs []string
+	messageRate uint32
 }
 
 type SFUDataChannelList struct {
@@ -27,6 +35,25 @@ type SFUDataChannelList struct {
 type DataChannelOptions struct {
 	Ordered   bool
 	ClientIDs []string // empty means all clients
+	// MaxRetransmits limits the number of the retransmissions of a message, nil means the message is always retransmitted
+	// until it's delivered. Set it to 0 with Ordered false for the realtime messages that useless when they're late.
+	MaxRetransmits *uint16
+	// SenderIDs is the clients that allowed to send messages to the channel, empty means all clients in the channel.
+	// The messages from the other clients are dropped.
+	SenderIDs []string
+	// ReceiverIDs is the clients that receive the messages on the channel, empty means all clients in the channel
+	ReceiverIDs []string
+	// MaxMessagesPerSecond limits the messages that a client can send to the channel, with a burst of a second of messages.
+	// The messages over the limit are dropped. 0 means no limit.
+	MaxMessagesPerSecond uint32
+}
+
+// DataChannelMessage is a message that received from a client on a room data channel
+type DataChannelMessage struct {
+	Label    string
+	ClientID string
+	IsString bool
+	Data     []byte
 }
 
 type Data struct {
@@ -43,12 +70,20 @@ type DataChannelList struct {
 
 func NewSFUDataChannel(label string, opts DataChannelOptions) *SFUDataChannel {
 	return &SFUDataChannel{
-		label:     label,
-		clientIDs: opts.ClientIDs,
-		isOrdered: opts.Ordered,
+		label:          label,
+		clientIDs:      opts.ClientIDs,
+		isOrdered:      opts.Ordered,
+		maxRetransmits: opts.MaxRetransmits,
+		senderIDs:      opts.SenderIDs,
+		receiverIDs:    opts.ReceiverIDs,
+		messageRate:    opts.MaxMessagesPerSecond,
 	}
 }
 
+func (s *SFUDataChannel) Label() string {
+	return s.label
+}
+
 func (s *SFUDataChannel) ClientIDs() []string {
 	return s.clientIDs
 }
@@ -57,6 +92,110 @@ func (s *SFUDataChannel) IsOrdered() bool {
 	return s.isOrdered
 }
 
+// MaxRetransmits returns nil if the data channel is reliable
+func (s *SFUDataChannel) MaxRetransmits() *uint16 {
+	return s.maxRetransmits
+}
+
+func (s *SFUDataChannel) SenderIDs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.senderIDs
+}
+
+func (s *SFUDataChannel) ReceiverIDs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.receiverIDs
+}
+
+func (s *SFUDataChannel) setPermissions(senderIDs, receiverIDs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.senderIDs = senderIDs
+	s.receiverIDs = receiverIDs
+}
+
+func (s *SFUDataChannel) canSend(clientID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.senderIDs) == 0 || slices.Contains(s.senderIDs, clientID)
+}
+
+func (s *SFUDataChannel) canReceive(clientID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.receiverIDs) == 0 || slices.Contains(s.receiverIDs, clientID)
+}
+
+func (s *SFUDataChannel) initOptions() *webrtc.DataChannelInit {
+	ordered := s.isOrdered
+
+	return &webrtc.DataChannelInit{
+		Ordered:        &ordered,
+		MaxRetransmits: s.maxRetransmits,
+	}
+}
+
+// sendDataChannelMessage sends the message once the data channel is open
+func sendDataChannelMessage(dc *webrtc.DataChannel, data []byte) {
+	if dc.ReadyState() != webrtc.DataChannelStateOpen {
+		dc.OnOpen(func() {
+			dc.Send(data)
+		})
+	} else {
+		dc.Send(data)
+	}
+}
+
+// messageRateLimiter is a token bucket of the messages that a client sends to a data channel
+type messageRateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newMessageRateLimiter returns nil if the rate is not limited
+func newMessageRateLimiter(messagesPerSecond uint32) *messageRateLimiter {
+	if messagesPerSecond == 0 {
+		return nil
+	}
+
+	return &messageRateLimiter{
+		rate:   float64(messagesPerSecond),
+		tokens: float64(messagesPerSecond),
+	}
+}
+
+func (l *messageRateLimiter) allow(now time.Time) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() {
+		l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+
+	l.tokens--
+
+	return true
+}
+
 func NewSFUDataChannelList() *SFUDataChannelList {
 	return &SFUDataChannelList{
 		dataChannels: make(map[string]*SFUDataChannel),
@@ -288,6 +288,151 @@ Loop:
 	require.Equal(t, len(expectedMessages), len(messages))
 }
 
+func TestRoomBroadcastMessage(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err, "error creating room: %v", err)
+
+	// the realtime events that useless when they're late
+	maxRetransmits := uint16(0)
+	err = testRoom.CreateDataChannel("events", DataChannelOptions{Ordered: false, MaxRetransmits: &maxRetransmits})
+	require.NoError(t, err)
+
+	require.ErrorIs(t, testRoom.BroadcastMessage("unknown", []byte("hello"), nil), ErrDataChannelNotFound)
+
+	received := make(chan DataChannelMessage, 10)
+	testRoom.OnDataChannelMessage(func(msg DataChannelMessage) {
+		received <- msg
+	})
+
+	onDataChannel := func(opened chan *webrtc.DataChannel, messages chan string) func(d *webrtc.DataChannel) {
+		return func(d *webrtc.DataChannel) {
+			if d.Label() != "events" {
+				return
+			}
+
+			d.OnMessage(func(msg webrtc.DataChannelMessage) {
+				messages <- string(msg.Data)
+			})
+
+			if d.ReadyState() == webrtc.DataChannelStateOpen {
+				opened <- d
+			} else {
+				d.OnOpen(func() {
+					opened <- d
+				})
+			}
+		}
+	}
+
+	opened1, messages1 := make(chan *webrtc.DataChannel, 1), make(chan string, 10)
+	opened2, messages2 := make(chan *webrtc.DataChannel, 1), make(chan string, 10)
+
+	_, client1, _, _ := CreateDataPair(ctx, TestLogger, testRoom, roomManager.options.IceServers, "peer1", onDataChannel(opened1, messages1))
+	_, client2, _, _ := CreateDataPair(ctx, TestLogger, testRoom, roomManager.options.IceServers, "peer2", onDataChannel(opened2, messages2))
+
+	defer func() {
+		_ = testRoom.StopClient(client1.id)
+		_ = testRoom.StopClient(client2.id)
+	}()
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	var dc1 *webrtc.DataChannel
+
+	for _, opened := range []chan *webrtc.DataChannel{opened1, opened2} {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the data channel")
+		case dc := <-opened:
+			if dc1 == nil {
+				dc1 = dc
+			}
+		}
+	}
+
+	require.False(t, dc1.Ordered())
+	require.Equal(t, uint16(0), *dc1.MaxRetransmits())
+
+	require.NoError(t, dc1.SendText("ping"))
+
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the message hook")
+	case msg := <-received:
+		require.Equal(t, DataChannelMessage{Label: "events", ClientID: client1.ID(), IsString: true, Data: []byte("ping")}, msg)
+	}
+
+	require.NoError(t, testRoom.BroadcastMessage("events", []byte("hello"), []string{client1.ID()}))
+
+	// the message from peer1 is forwarded, and the broadcast skips peer1. The channel is unordered.
+	got := make([]string, 0, 2)
+	for len(got) < 2 {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the message")
+		case msg := <-messages2:
+			got = append(got, msg)
+		}
+	}
+
+	require.ElementsMatch(t, []string{"ping", "hello"}, got)
+
+	select {
+	case msg := <-messages1:
+		t.Fatalf("unexpected message to the excluded client: %s", msg)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestDataChannelPermissions(t *testing.T) {
+	dc := NewSFUDataChannel("chat", DataChannelOptions{
+		Ordered:              true,
+		SenderIDs:            []string{"host"},
+		MaxMessagesPerSecond: 2,
+	})
+
+	require.True(t, dc.canSend("host"))
+	require.False(t, dc.canSend("guest"))
+	require.True(t, dc.canReceive("guest"))
+
+	dc.setPermissions(nil, []string{"guest"})
+	require.True(t, dc.canSend("guest"))
+	require.False(t, dc.canReceive("host"))
+	require.True(t, dc.canReceive("guest"))
+
+	limiter := newMessageRateLimiter(dc.messageRate)
+	now := time.Now()
+
+	// the burst of a second of messages
+	require.True(t, limiter.allow(now))
+	require.True(t, limiter.allow(now))
+	require.False(t, limiter.allow(now))
+
+	require.True(t, limiter.allow(now.Add(500*time.Millisecond)))
+	require.False(t, limiter.allow(now.Add(600*time.Millisecond)))
+
+	// the tokens are not accumulated more than the burst
+	later := now.Add(time.Minute)
+	require.True(t, limiter.allow(later))
+	require.True(t, limiter.allow(later))
+	require.False(t, limiter.allow(later))
+
+	require.True(t, newMessageRateLimiter(0).allow(now), "no limit")
+}
+
 // TODO
 func TestStillUsableAfterReconnect(t *testing.T) {
 
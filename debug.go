@@ -0,0 +1,250 @@
+package sfu
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// DebugState is the snapshot of the internal state of the rooms that served by DebugHandler
+type DebugState struct {
+	Time  time.Time   `json:"time"`
+	Rooms []DebugRoom `json:"rooms"`
+}
+
+type DebugRoom struct {
+	ID      string        `json:"id"`
+	Name    string        `json:"name"`
+	Kind    string        `json:"kind"`
+	State   string        `json:"state"`
+	Clients []DebugClient `json:"clients"`
+}
+
+type DebugClient struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Type            string `json:"type"`
+	ConnectionState string `json:"connection_state"`
+	// EstimatedBandwidth is the downlink bandwidth estimation of the client in bits per second
+	EstimatedBandwidth uint32 `json:"estimated_bandwidth"`
+	// IngressBandwidth is the uplink bandwidth of the client in bits per second that reported by the client
+	IngressBandwidth uint32                 `json:"ingress_bandwidth"`
+	Published        []DebugPublishedTrack  `json:"published_tracks"`
+	Subscribed       []DebugSubscribedTrack `json:"subscribed_tracks"`
+}
+
+type DebugPublishedTrack struct {
+	ID        string             `json:"id"`
+	StreamID  string             `json:"stream_id"`
+	Kind      string             `json:"kind"`
+	MimeType  string             `json:"mime_type"`
+	Source    string             `json:"source"`
+	Label     string             `json:"label,omitempty"`
+	Simulcast bool               `json:"simulcast"`
+	Relay     bool               `json:"relay"`
+	Layers    []DebugRemoteLayer `json:"layers"`
+}
+
+// DebugRemoteLayer is a received RTP stream of a published track, a simulcast track has a layer for each RID
+type DebugRemoteLayer struct {
+	RID     string       `json:"rid,omitempty"`
+	Quality QualityLevel `json:"quality"`
+	SSRC    uint32       `json:"ssrc"`
+	Bitrate uint32       `json:"bitrate"`
+	// QueueDepth is the number of the packets that held in the jitter buffer, waiting for a missing packet
+	QueueDepth   int                `json:"queue_depth"`
+	JitterBuffer *JitterBufferStats `json:"jitter_buffer,omitempty"`
+}
+
+type DebugSubscribedTrack struct {
+	ID             string       `json:"id"`
+	Kind           string       `json:"kind"`
+	MimeType       string       `json:"mime_type"`
+	SSRC           uint32       `json:"ssrc"`
+	Quality        QualityLevel `json:"quality"`
+	MaxQuality     QualityLevel `json:"max_quality"`
+	Paused         bool         `json:"paused"`
+	SendBitrate    uint32       `json:"send_bitrate"`
+	ReceiveBitrate uint32       `json:"receive_bitrate"`
+}
+
+// DebugHandler is a http.Handler that dumps the live state of all rooms in the manager as JSON, like the rooms,
+// clients, published tracks with their SSRCs and jitter buffers, and the current layer of every subscribed track.
+// Use the room query parameter to only dump a room, for example /debug/sfu?room=room-id.
+//
+// The state includes the client IDs and names, don't expose it publicly. Mount it behind an authentication
+// middleware or on an internal port.
+type DebugHandler struct {
+	manager *Manager
+}
+
+func NewDebugHandler(manager *Manager) *DebugHandler {
+	return &DebugHandler{manager: manager}
+}
+
+func (h *DebugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := DebugState{
+		Time:  time.Now(),
+		Rooms: make([]DebugRoom, 0),
+	}
+
+	if roomID := r.URL.Query().Get("room"); roomID != "" {
+		h.manager.mutex.RLock()
+		room, err := h.manager.getRoom(roomID)
+		h.manager.mutex.RUnlock()
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		state.Rooms = append(state.Rooms, room.debugState())
+	} else {
+		for _, room := range h.manager.roomList() {
+			state.Rooms = append(state.Rooms, room.debugState())
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(state)
+}
+
+// roomList returns the rooms sorted by ID, so the dumps are comparable
+func (m *Manager) roomList() []*Room {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	rooms := make([]*Room, 0, len(m.rooms))
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].id < rooms[j].id
+	})
+
+	return rooms
+}
+
+func (r *Room) debugState() DebugRoom {
+	r.mu.RLock()
+	state := r.state
+	r.mu.RUnlock()
+
+	room := DebugRoom{
+		ID:      r.id,
+		Name:    r.name,
+		Kind:    r.kind,
+		State:   state,
+		Clients: make([]DebugClient, 0),
+	}
+
+	for _, client := range r.sfu.GetClients() {
+		room.Clients = append(room.Clients, client.debugState())
+	}
+
+	sort.Slice(room.Clients, func(i, j int) bool {
+		return room.Clients[i].ID < room.Clients[j].ID
+	})
+
+	return room
+}
+
+func (c *Client) debugState() DebugClient {
+	client := DebugClient{
+		ID:                 c.id,
+		Name:               c.name,
+		Type:               c.Type(),
+		ConnectionState:    c.peerConnection.PC().ConnectionState().String(),
+		EstimatedBandwidth: c.GetEstimatedBandwidth(),
+		IngressBandwidth:   c.ingressBandwidth.Load(),
+		Published:          make([]DebugPublishedTrack, 0),
+		Subscribed:         make([]DebugSubscribedTrack, 0),
+	}
+
+	for _, track := range c.Tracks() {
+		client.Published = append(client.Published, debugPublishedTrack(track))
+	}
+
+	for id, track := range c.ClientTracks() {
+		subscribed := DebugSubscribedTrack{
+			ID:             id,
+			Kind:           track.Kind().String(),
+			MimeType:       track.MimeType(),
+			Quality:        track.Quality(),
+			MaxQuality:     track.MaxQuality(),
+			Paused:         c.isTrackPaused(id),
+			SendBitrate:    track.SendBitrate(),
+			ReceiveBitrate: track.ReceiveBitrate(),
+		}
+
+		if ssrc, ok := c.senderSSRC(id); ok {
+			subscribed.SSRC = ssrc
+		}
+
+		client.Subscribed = append(client.Subscribed, subscribed)
+	}
+
+	sort.Slice(client.Subscribed, func(i, j int) bool {
+		return client.Subscribed[i].ID < client.Subscribed[j].ID
+	})
+
+	return client
+}
+
+func debugPublishedTrack(track ITrack) DebugPublishedTrack {
+	published := DebugPublishedTrack{
+		ID:        track.ID(),
+		StreamID:  track.StreamID(),
+		Kind:      track.Kind().String(),
+		MimeType:  track.MimeType(),
+		Source:    track.SourceType().String(),
+		Label:     track.Source().Label,
+		Simulcast: track.IsSimulcast(),
+		Relay:     track.IsRelay(),
+		Layers:    make([]DebugRemoteLayer, 0),
+	}
+
+	switch t := track.(type) {
+	case *Track:
+		published.Layers = append(published.Layers, debugRemoteLayer(t.RemoteTrack(), QualityHigh))
+	case *AudioTrack:
+		published.Layers = append(published.Layers, debugRemoteLayer(t.RemoteTrack(), QualityAudio))
+	case *SimulcastTrack:
+		for _, quality := range []QualityLevel{QualityHigh, QualityMid, QualityLow} {
+			if remoteTrack := t.GetRemoteTrack(quality); remoteTrack != nil {
+				published.Layers = append(published.Layers, debugRemoteLayer(remoteTrack, quality))
+			}
+		}
+	}
+
+	return published
+}
+
+func debugRemoteLayer(remoteTrack *remoteTrack, quality QualityLevel) DebugRemoteLayer {
+	layer := DebugRemoteLayer{
+		RID:     remoteTrack.Track().RID(),
+		Quality: quality,
+		SSRC:    uint32(remoteTrack.Track().SSRC()),
+		Bitrate: remoteTrack.bitrate.Load(),
+	}
+
+	if remoteTrack.jitterBuffer != nil {
+		stats := remoteTrack.jitterBuffer.Stats()
+		layer.QueueDepth = remoteTrack.jitterBuffer.Len()
+		layer.JitterBuffer = &stats
+	}
+
+	return layer
+}
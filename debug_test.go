@@ -0,0 +1,54 @@
+package sfu
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom("room", "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	client, err := testRoom.AddClient("peer", "peer-name", DefaultClientOptions())
+	require.NoError(t, err)
+
+	defer func() {
+		_ = client.End()
+	}()
+
+	handler := NewDebugHandler(roomManager)
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/debug/sfu", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "application/json", res.Header().Get("Content-Type"))
+
+	state := DebugState{}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &state))
+	require.Len(t, state.Rooms, 1)
+	require.Equal(t, "room", state.Rooms[0].ID)
+	require.Len(t, state.Rooms[0].Clients, 1)
+	require.Equal(t, "peer-name", state.Rooms[0].Clients[0].Name)
+	require.Empty(t, state.Rooms[0].Clients[0].Published)
+
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/debug/sfu?room=unknown", nil))
+	require.Equal(t, http.StatusNotFound, res.Code)
+
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/debug/sfu", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, res.Code)
+}
@@ -0,0 +1,141 @@
+package sfu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSFUDraining     = errors.New("sfu: error the sfu is draining, not accepting new clients")
+	ErrManagerDraining = errors.New("manager: error the manager is draining, not accepting new rooms")
+)
+
+// the interval to check if all clients are left the SFU while draining
+const drainCheckInterval = 100 * time.Millisecond
+
+type migrate struct {
+	URL string `json:"url"`
+}
+
+type internalDataMigrate struct {
+	Type string  `json:"type"`
+	Data migrate `json:"data"`
+}
+
+// IsDraining returns true if Drain is called, the SFU is not accepting new clients
+func (s *SFU) IsDraining() bool {
+	return s.draining.Load()
+}
+
+// Drain stops accepting new clients and sends the migrate internal message with the migrateURL to the connected clients,
+// so they can reconnect to another server. It waits until all clients are left or the context is done, then stops the SFU.
+// The context error is returned if the clients are still connected when the SFU is stopped.
+func (s *SFU) Drain(ctx context.Context, migrateURL string) error {
+	err := s.drain(ctx, migrateURL)
+	if errors.Is(err, ErrSFUDraining) {
+		return err
+	}
+
+	s.Stop()
+
+	return err
+}
+
+func (s *SFU) drain(ctx context.Context, migrateURL string) error {
+	if !s.draining.CompareAndSwap(false, true) {
+		return ErrSFUDraining
+	}
+
+	data, err := json.Marshal(internalDataMigrate{
+		Type: messageTypeMigrate,
+		Data: migrate{URL: migrateURL},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, client := range s.clients.GetClients() {
+		client.sendInternalMessage(data)
+	}
+
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+
+	for s.clients.Length() > 0 {
+		select {
+		case <-ctx.Done():
+			s.log.Warnf("sfu: drain deadline reached with %d clients left", s.clients.Length())
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// Drain is the same as SFU.Drain, the room is closed after the clients are left or the context is done
+func (r *Room) Drain(ctx context.Context, migrateURL string) error {
+	err := r.sfu.drain(ctx, migrateURL)
+	if errors.Is(err, ErrSFUDraining) {
+		return err
+	}
+
+	if closeErr := r.Close(); closeErr != nil && !errors.Is(closeErr, ErrRoomIsClosed) {
+		return closeErr
+	}
+
+	return err
+}
+
+// Drain stops creating new rooms and drains all rooms at the same time, see SFU.Drain. Use it before the server
+// is shut down for a zero downtime deploy, the manager is closed when all rooms are closed.
+func (m *Manager) Drain(ctx context.Context, migrateURL string) error {
+	if !m.draining.CompareAndSwap(false, true) {
+		return ErrManagerDraining
+	}
+
+	m.mutex.RLock()
+	rooms := make([]*Room, 0, len(m.rooms))
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+	m.mutex.RUnlock()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		drainErr error
+	)
+
+	for _, room := range rooms {
+		wg.Add(1)
+
+		go func(room *Room) {
+			defer wg.Done()
+
+			// the room that is already closed or drained before is skipped
+			err := room.Drain(ctx, migrateURL)
+			if err != nil && !errors.Is(err, ErrRoomIsClosed) && !errors.Is(err, ErrSFUDraining) {
+				mu.Lock()
+				if drainErr == nil {
+					drainErr = err
+				}
+				mu.Unlock()
+			}
+		}(room)
+	}
+
+	wg.Wait()
+
+	m.cancel()
+
+	return drainErr
+}
+
+// IsDraining returns true if Drain is called, the manager is not accepting new rooms
+func (m *Manager) IsDraining() bool {
+	return m.draining.Load()
+}
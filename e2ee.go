@@ -0,0 +1,47 @@
+package sfu
+
+import (
+	"errors"
+
+	"github.com/inlivedev/sfu/pkg/dependencydescriptor"
+	"github.com/inlivedev/sfu/pkg/framemarking"
+	"github.com/pion/rtp"
+)
+
+var ErrE2EENotSupported = errors.New("sfu: error the feature requires the media payload that is end-to-end encrypted")
+
+func (t *baseTrack) isE2EE() bool {
+	return t.client != nil && t.client.IsE2EE()
+}
+
+// isKeyframe checks if the packet is the start of a keyframe. The payload of the end-to-end encrypted tracks
+// can't be parsed, so only the dependency descriptor and frame marking header extensions are used.
+func (t *baseTrack) isKeyframe(p *rtp.Packet) bool {
+	if !t.isE2EE() {
+		return IsKeyframe(t.codec.MimeType, p.Payload)
+	}
+
+	return isKeyframeExtension(p, uint8(t.dependencyDescriptorExtID.Load()), uint8(t.frameMarkingExtID.Load()))
+}
+
+// isKeyframeExtension checks if the packet is the start of a keyframe from the header extensions, zero extension ID means not negotiated.
+// The dependency descriptor attaches the template structure on the keyframes.
+func isKeyframeExtension(p *rtp.Packet, ddExtID, fmExtID uint8) bool {
+	if ddExtID != 0 {
+		if ext := p.Header.GetExtension(ddExtID); ext != nil {
+			dd, err := dependencydescriptor.Unmarshal(ext, nil)
+
+			return err == nil && dd.StartOfFrame && dd.AttachedStructure != nil
+		}
+	}
+
+	if fmExtID != 0 {
+		if ext := p.Header.GetExtension(fmExtID); ext != nil {
+			fm, err := framemarking.Unmarshal(ext)
+
+			return err == nil && fm.StartOfFrame && fm.Independent
+		}
+	}
+
+	return false
+}
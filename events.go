@@ -0,0 +1,113 @@
+package sfu
+
+import (
+	"time"
+)
+
+const (
+	EventRoomCreated      = "room_created"
+	EventRoomClientJoined = "room_client_joined"
+	EventTrackPublished   = "track_published"
+	EventTrackUnpublished = "track_unpublished"
+	EventRecordingStarted = "recording_started"
+	EventRecordingStopped = "recording_stopped"
+
+	// EventRoomClientWaiting is sent when a client is connected and waiting in the lobby to be admitted
+	EventRoomClientWaiting = "room_client_waiting"
+)
+
+// EventSink receives the lifecycle events of all rooms in the manager, see Options.EventSink.
+// The Send method is called synchronously from the room, it must not block, queue the event if it needs to be sent over the network.
+// It's called without holding the room and the manager locks, so it can call them.
+type EventSink interface {
+	Send(event Event)
+}
+
+// EventSinkFunc is an EventSink from a function
+type EventSinkFunc func(event Event)
+
+func (f EventSinkFunc) Send(event Event) {
+	f(event)
+}
+
+// emit sends the event to Room.OnEvent and the event sink of the manager, the room ID is added to the data
+func (r *Room) emit(eventType string, data map[string]interface{}) {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+
+	data["room_id"] = r.id
+
+	event := Event{
+		Type: eventType,
+		Time: time.Now(),
+		Data: data,
+	}
+
+	if r.OnEvent != nil {
+		r.OnEvent(event)
+	}
+
+	if r.eventSink != nil {
+		r.eventSink.Send(event)
+	}
+}
+
+// emitTracksPublished sends the published event of the tracks and the unpublished event once they're ended
+func (r *Room) emitTracksPublished(tracks []ITrack) {
+	for _, track := range tracks {
+		data := func() map[string]interface{} {
+			return map[string]interface{}{
+				"client_id": track.ClientID(),
+				"track_id":  track.ID(),
+				"kind":      track.Kind().String(),
+				"source":    track.SourceType().String(),
+				"label":     track.Source().Label,
+				"mime_type": track.MimeType(),
+			}
+		}
+
+		r.emit(EventTrackPublished, data())
+
+		track.OnEnded(func() {
+			r.emit(EventTrackUnpublished, data())
+		})
+	}
+}
+
+// AddEventSink adds a sink that receives the events of all rooms in addition to Options.EventSink, including the rooms
+// that are already created. Call the returned function to remove the sink.
+func (m *Manager) AddEventSink(sink EventSink) (remove func()) {
+	entry := &sink
+
+	m.sinksMu.Lock()
+	m.eventSinks = append(m.eventSinks, entry)
+	m.sinksMu.Unlock()
+
+	return func() {
+		m.sinksMu.Lock()
+		defer m.sinksMu.Unlock()
+
+		for i, s := range m.eventSinks {
+			if s == entry {
+				m.eventSinks = append(m.eventSinks[:i:i], m.eventSinks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// sendEvent sends the event of a room to Options.EventSink and the added sinks
+func (m *Manager) sendEvent(event Event) {
+	if m.options.EventSink != nil {
+		m.options.EventSink.Send(event)
+	}
+
+	m.sinksMu.RLock()
+	sinks := m.eventSinks
+	m.sinksMu.RUnlock()
+
+	for _, sink := range sinks {
+		(*sink).Send(event)
+	}
+}
@@ -0,0 +1,55 @@
+package sfu
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventHandlersCallRoom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan string, 10)
+
+	var roomManager *Manager
+
+	// the handlers read the manager and the room state, they would deadlock if the events are sent under the locks
+	managerOpts := sfuOpts
+	managerOpts.EventSink = EventSinkFunc(func(event Event) {
+		for _, room := range roomManager.Rooms() {
+			_ = room.State()
+			_ = room.SFU().GetClients()
+		}
+
+		events <- event.Type
+	})
+
+	roomManager = NewManager(ctx, "test", managerOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	waitEvent := func(eventType string) {
+		select {
+		case event := <-events:
+			require.Equal(t, eventType, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for the %s event", eventType)
+		}
+	}
+
+	waitEvent(EventRoomCreated)
+
+	client, err := testRoom.AddClient("client", "client", DefaultClientOptions())
+	require.NoError(t, err)
+
+	require.NoError(t, testRoom.StopClient(client.ID()))
+	waitEvent(EventRoomClientLeft)
+
+	require.NoError(t, testRoom.Close())
+	waitEvent(EventRoomClosed)
+}
@@ -253,6 +253,7 @@ func clientHandler(isDebug bool, conn *websocket.Conn, messageChan chan Request,
 				"id":           track.ID(),
 				"client_id":    track.ClientID(),
 				"source_type":  track.SourceType().String(),
+				"label":        track.Source().Label,
 				"kind":         track.Kind().String(),
 				"is_simulcast": track.IsSimulcast(),
 			}
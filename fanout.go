@@ -0,0 +1,252 @@
+package sfu
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor"
+)
+
+const (
+	// fanOutBatchSize is the max number of the packets that a worker processes from a queue before it moves to the next
+	// queue, so a subscriber with a lot of queued packets doesn't delay the other subscribers on the same worker
+	fanOutBatchSize = 32
+	// fanOutTurnBudget is the max time that a worker spends on a queue before it moves to the next queue, so a subscriber
+	// with the slow writes doesn't stall the other subscribers on the same worker
+	fanOutTurnBudget = 2 * time.Millisecond
+	// defaultReadQueueSize is the number of the read packets of a published track that wait for its worker
+	defaultReadQueueSize = 256
+)
+
+// fanOutScheduler processes the packets of the published and the subscribed tracks with a fixed number of workers
+// instead of a goroutine per track. The read packets of a published track are forwarded by the worker of its SSRC, and
+// the queued packets of a subscribed track are written by the worker of its sender SSRC. A track is always processed
+// by the same worker, so its packets stay in order, and the subscribers of a track are spread across the workers.
+type fanOutScheduler struct {
+	workers []*fanOutWorker
+	// the tracks without a known SSRC are spread to the workers in turn
+	next atomic.Uint32
+}
+
+// fanOutTask is a queue that processed by the workers, see clientTrackQueue and remoteReadQueue
+type fanOutTask interface {
+	slot() *fanOutSlot
+	// run processes up to limit packets until the deadline, it returns true if the queue still has packets
+	run(limit int, deadline time.Time) bool
+}
+
+// fanOutSlot is the worker of a queue
+type fanOutSlot struct {
+	shard     int
+	scheduled atomic.Bool
+}
+
+func (s *fanOutSlot) slot() *fanOutSlot {
+	return s
+}
+
+type fanOutWorker struct {
+	mu     sync.Mutex
+	tasks  []fanOutTask
+	notify chan struct{}
+}
+
+func newFanOutScheduler(ctx context.Context, workers int) *fanOutScheduler {
+	s := &fanOutScheduler{
+		workers: make([]*fanOutWorker, workers),
+	}
+
+	for i := range s.workers {
+		s.workers[i] = &fanOutWorker{notify: make(chan struct{}, 1)}
+		go s.workers[i].loop(ctx, s)
+	}
+
+	return s
+}
+
+// shard returns the worker of the SSRC, the worker is picked in turn if the SSRC is zero
+func (s *fanOutScheduler) shard(ssrc uint32) int {
+	if ssrc == 0 {
+		ssrc = s.next.Add(1)
+	}
+
+	return int(ssrc % uint32(len(s.workers)))
+}
+
+// schedule adds the queue to its worker, a queue that already scheduled is not added again
+func (s *fanOutScheduler) schedule(task fanOutTask) {
+	slot := task.slot()
+
+	if !slot.scheduled.CompareAndSwap(false, true) {
+		return
+	}
+
+	w := s.workers[slot.shard]
+
+	w.mu.Lock()
+	w.tasks = append(w.tasks, task)
+	w.mu.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *fanOutWorker) loop(ctx context.Context, s *fanOutScheduler) {
+	tasks := make([]fanOutTask, 0)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.notify:
+		}
+
+		for {
+			w.mu.Lock()
+			tasks, w.tasks = w.tasks, tasks[:0]
+			w.mu.Unlock()
+
+			if len(tasks) == 0 {
+				break
+			}
+
+			for i, task := range tasks {
+				// the queue can be scheduled again while it's processed, the packets that enqueued after this are
+				// processed on the next turn
+				task.slot().scheduled.Store(false)
+
+				// the queue that has packets left after its turn is moved behind the other queues
+				if task.run(fanOutBatchSize, time.Now().Add(fanOutTurnBudget)) {
+					s.schedule(task)
+				}
+
+				tasks[i] = nil
+			}
+		}
+	}
+}
+
+// fanOutScheduler returns the scheduler of the client, it's nil if the tracks are processed by their own goroutines
+func (c *Client) fanOutScheduler() *fanOutScheduler {
+	if c.SFU() == nil {
+		return nil
+	}
+
+	return c.SFU().fanOut
+}
+
+type readItem struct {
+	// nil when the read loop wakes up without a packet, see remoteTrack.idle
+	buffer *[]byte
+	n      int
+	attrs  interceptor.Attributes
+}
+
+// remoteReadQueue is the bounded queue of the packets that read by the read loop of a published track. The read loop
+// still blocks on reading from the publisher in its own goroutine, but the packets are forwarded to the subscribers by
+// the worker, so the CPU work of the fan-out is limited to the workers. When the queue is full, the new packet is
+// dropped and the subscribers recover it with a NACK.
+type remoteReadQueue struct {
+	fanOutSlot
+	mu        sync.Mutex
+	items     []readItem
+	head      int
+	size      int
+	dropped   atomic.Uint64
+	track     *remoteTrack
+	scheduler *fanOutScheduler
+}
+
+func newRemoteReadQueue(scheduler *fanOutScheduler, track *remoteTrack) *remoteReadQueue {
+	q := &remoteReadQueue{
+		items:     make([]readItem, defaultReadQueueSize),
+		track:     track,
+		scheduler: scheduler,
+	}
+
+	q.shard = scheduler.shard(uint32(track.track.SSRC()))
+
+	// the queued packets are returned to the pool by the worker once the track is ended
+	context.AfterFunc(track.context, func() { scheduler.schedule(q) })
+
+	return q
+}
+
+// push queues the read packet for the worker, the buffer is owned by the queue after this
+func (q *remoteReadQueue) push(buffer *[]byte, n int, attrs interceptor.Attributes) {
+	q.mu.Lock()
+
+	if q.size == len(q.items) {
+		q.mu.Unlock()
+
+		if buffer != nil {
+			q.dropped.Add(1)
+			q.track.rtppool.PutPayload(buffer)
+		}
+
+		q.scheduler.schedule(q)
+
+		return
+	}
+
+	q.items[(q.head+q.size)%len(q.items)] = readItem{buffer: buffer, n: n, attrs: attrs}
+	q.size++
+
+	q.mu.Unlock()
+
+	q.scheduler.schedule(q)
+}
+
+func (q *remoteReadQueue) pop() (readItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.size == 0 {
+		return readItem{}, false
+	}
+
+	item := q.items[q.head]
+	q.items[q.head] = readItem{}
+	q.head = (q.head + 1) % len(q.items)
+	q.size--
+
+	return item, true
+}
+
+func (q *remoteReadQueue) run(limit int, deadline time.Time) bool {
+	if q.track.context.Err() != nil {
+		for item, ok := q.pop(); ok; item, ok = q.pop() {
+			if item.buffer != nil {
+				q.track.rtppool.PutPayload(item.buffer)
+			}
+		}
+
+		return false
+	}
+
+	for processed := 0; processed < limit && time.Now().Before(deadline); processed++ {
+		item, ok := q.pop()
+		if !ok {
+			return false
+		}
+
+		// the worker is alive as long as it processes the packets of the track, see remoteTrack.isStuck
+		q.track.heartbeat.Store(time.Now().UnixNano())
+
+		if item.buffer == nil {
+			q.track.expireBuffered()
+			continue
+		}
+
+		q.track.process(item.buffer, item.n, item.attrs)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.size > 0
+}
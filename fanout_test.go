@@ -0,0 +1,132 @@
+package sfu
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFanOutShardSSRC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	scheduler := newFanOutScheduler(ctx, 4)
+
+	require.Equal(t, 1, scheduler.shard(1))
+	require.Equal(t, 2, scheduler.shard(4000000002))
+	require.Equal(t, scheduler.shard(1234), scheduler.shard(1234))
+
+	// the tracks without the SSRC are spread in turn
+	shards := map[int]bool{}
+	for i := 0; i < 4; i++ {
+		shards[scheduler.shard(0)] = true
+	}
+
+	require.Len(t, shards, 4)
+}
+
+// slowQueueTestTrack is a subscribed track that takes a while to write a packet
+type slowQueueTestTrack struct {
+	*queueTestTrack
+}
+
+func (t *slowQueueTestTrack) push(p *rtp.Packet, _ QualityLevel) {
+	time.Sleep(5 * time.Millisecond)
+
+	t.mu.Lock()
+	t.written = append(t.written, p.SequenceNumber)
+	t.mu.Unlock()
+}
+
+func TestFanOutSlowSubscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &Client{id: "subscriber", context: ctx}
+	client.sfu.Store(&SFU{fanOut: newFanOutScheduler(ctx, 1)})
+
+	slow := &slowQueueTestTrack{queueTestTrack: newQueueTestTrack(ctx, webrtc.RTPCodecTypeVideo, 100)}
+	slow.client = client
+
+	fast := newQueueTestTrack(ctx, webrtc.RTPCodecTypeVideo, 100)
+	fast.client = client
+	close(fast.unblock)
+
+	for seq := uint16(1); seq <= 50; seq++ {
+		packet := slow.pool.NewPacket(&rtp.Header{SequenceNumber: seq}, []byte{byte(seq)}, nil)
+		pushClientTrack(slow, packet, QualityHigh)
+		packet.Release()
+	}
+
+	pushSequences(fast, 1, 50)
+
+	// the slow subscriber on the same worker only delays the other subscriber by its turn budget
+	require.Eventually(t, func() bool {
+		return len(fast.writtenPackets()) == 50
+	}, 100*time.Millisecond, time.Millisecond)
+
+	require.Less(t, len(slow.writtenPackets()), 20)
+
+	require.Eventually(t, func() bool {
+		return len(slow.writtenPackets()) == 50
+	}, 2*time.Second, 10*time.Millisecond)
+
+	for i, seq := range slow.writtenPackets() {
+		require.Equal(t, uint16(i+1), seq)
+	}
+}
+
+func TestFanOutWorkersRoom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := sfuOpts
+	opts.FanOutWorkers = 2
+
+	roomManager := NewManager(ctx, "test", opts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	pc1, client1, statsGetter1, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer1", true, false, true)
+	pc2, client2, statsGetter2, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer2", true, false, true)
+
+	// the packets that read from each publisher are forwarded by the workers to the other client
+	received := func(pc *PC, statsGetter stats.Getter) bool {
+		receiverStats := GetReceiverStats(pc.PeerConnection, statsGetter)
+		if len(receiverStats) != 2 {
+			return false
+		}
+
+		for _, stat := range receiverStats {
+			if stat.InboundRTPStreamStats.PacketsReceived < 50 {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	require.Eventually(t, func() bool {
+		return received(pc1, statsGetter1) && received(pc2, statsGetter2)
+	}, 30*time.Second, 100*time.Millisecond)
+
+	for _, client := range []*Client{client1, client2} {
+		for _, track := range client.Tracks() {
+			switch published := track.(type) {
+			case *AudioTrack:
+				require.NotNil(t, published.remoteTrack.readQueue)
+			case *Track:
+				require.NotNil(t, published.remoteTrack.readQueue)
+			}
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package sfu
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+)
+
+type ForwardingPolicyOptions struct {
+	// MaxVideoTracks is the maximum number of the video tracks that forwarded to each subscriber at the same time,
+	// the rest of the subscribed video tracks are paused
+	MaxVideoTracks int `json:"max_video_tracks"`
+	// Interval is how often the speaker ranking is evaluated
+	Interval time.Duration `json:"interval"`
+	// LimitScreenTracks counts the screen tracks in MaxVideoTracks, by default the screen tracks are always forwarded
+	// and the top N is only applied to the other video tracks
+	LimitScreenTracks bool `json:"limit_screen_tracks"`
+}
+
+func DefaultForwardingPolicyOptions() ForwardingPolicyOptions {
+	return ForwardingPolicyOptions{
+		MaxVideoTracks: 9,
+		Interval:       time.Second,
+	}
+}
+
+// ForwardingPolicy limits the forwarded video tracks of each subscriber in the room to the top N tracks.
+// The tracks are ranked by the pinned publishers of the subscriber first, then the screen tracks,
+// then the publishers that spoke most recently. Voice detection must be enabled on the publisher clients
+// to rank the active speakers.
+type ForwardingPolicy struct {
+	context context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	room    *Room
+	options ForwardingPolicyOptions
+	// pinned publisher IDs per subscriber
+	pinned map[string][]string
+	// forwarded video track IDs per subscriber
+	forwarded         map[string][]string
+	onChangedCallback func(subscriberID string, forwarded, paused []string)
+	log               logging.LeveledLogger
+}
+
+func newForwardingPolicy(room *Room, opts ForwardingPolicyOptions) *ForwardingPolicy {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultForwardingPolicyOptions().Interval
+	}
+
+	ctx, cancel := context.WithCancel(room.context)
+
+	p := &ForwardingPolicy{
+		context:   ctx,
+		cancel:    cancel,
+		room:      room,
+		options:   opts,
+		pinned:    make(map[string][]string),
+		forwarded: make(map[string][]string),
+		log:       room.sfu.log,
+	}
+
+	return p
+}
+
+// Pin makes the video tracks of the publishers always forwarded to the subscriber, in the given order.
+// The pinned tracks are still limited by MaxVideoTracks. Call it without publisher to unpin.
+func (p *ForwardingPolicy) Pin(subscriberID string, publisherIDs ...string) {
+	p.mu.Lock()
+	if len(publisherIDs) == 0 {
+		delete(p.pinned, subscriberID)
+	} else {
+		p.pinned[subscriberID] = slices.Clone(publisherIDs)
+	}
+	p.mu.Unlock()
+
+	p.evaluate()
+}
+
+// ForwardedTracks returns the video track IDs that currently forwarded to the subscriber
+func (p *ForwardingPolicy) ForwardedTracks(subscriberID string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return slices.Clone(p.forwarded[subscriberID])
+}
+
+// OnChanged is called when the forwarded video tracks of a subscriber are changed,
+// use it to tell the client which videos are paused
+func (p *ForwardingPolicy) OnChanged(callback func(subscriberID string, forwarded, paused []string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.onChangedCallback = callback
+}
+
+func (p *ForwardingPolicy) start() {
+	go func() {
+		ticker := time.NewTicker(p.options.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.context.Done():
+				return
+			case <-ticker.C:
+				p.evaluate()
+			}
+		}
+	}()
+
+	p.evaluate()
+}
+
+// stop resumes all the paused tracks
+func (p *ForwardingPolicy) stop() {
+	p.cancel()
+
+	for _, client := range p.room.sfu.GetClients() {
+		for id := range client.ClientTracks() {
+			client.setTrackPaused(id, false)
+		}
+	}
+}
+
+// rank returns the sort key of the publisher track for the subscriber, lower is forwarded first
+func (p *ForwardingPolicy) rank(subscriberID string, track ITrack) (int, time.Time) {
+	if i := slices.Index(p.pinned[subscriberID], track.ClientID()); i >= 0 {
+		return i, time.Time{}
+	}
+
+	pinned := len(p.pinned[subscriberID])
+
+	if track.SourceType() == TrackTypeScreen {
+		return pinned, time.Time{}
+	}
+
+	return pinned + 1, p.room.speakers.lastSpoke(track.ClientID())
+}
+
+func (p *ForwardingPolicy) evaluate() {
+	if p.context.Err() != nil {
+		return
+	}
+
+	type change struct {
+		subscriberID      string
+		forwarded, paused []string
+	}
+
+	changes := make([]change, 0)
+
+	p.mu.Lock()
+
+	clients := p.room.sfu.GetClients()
+
+	for id := range p.forwarded {
+		if _, ok := clients[id]; !ok {
+			delete(p.forwarded, id)
+		}
+	}
+
+	for _, subscriber := range clients {
+		clientTracks := subscriber.ClientTracks()
+
+		videos := make([]ITrack, 0)
+		for _, track := range subscriber.publishedTracks.GetTracks() {
+			if _, ok := clientTracks[track.ID()]; ok && track.Kind() == webrtc.RTPCodecTypeVideo {
+				videos = append(videos, track)
+			}
+		}
+
+		sort.SliceStable(videos, func(i, j int) bool {
+			rankI, spokeI := p.rank(subscriber.ID(), videos[i])
+			rankJ, spokeJ := p.rank(subscriber.ID(), videos[j])
+
+			if rankI != rankJ {
+				return rankI < rankJ
+			}
+
+			if !spokeI.Equal(spokeJ) {
+				return spokeI.After(spokeJ)
+			}
+
+			return videos[i].ID() < videos[j].ID()
+		})
+
+		forwarded := make([]string, 0)
+		paused := make([]string, 0)
+		changed := false
+
+		limited := 0
+
+		for _, track := range videos {
+			pause := false
+
+			if p.options.LimitScreenTracks || track.SourceType() != TrackTypeScreen {
+				pause = p.options.MaxVideoTracks > 0 && limited >= p.options.MaxVideoTracks
+				limited++
+			}
+
+			if pause {
+				paused = append(paused, track.ID())
+			} else {
+				forwarded = append(forwarded, track.ID())
+			}
+
+			if subscriber.setTrackPaused(track.ID(), pause) {
+				changed = true
+			}
+		}
+
+		p.forwarded[subscriber.ID()] = forwarded
+
+		if changed {
+			p.log.Debugf("forwarding: client %s forwarded videos %v, paused %v", subscriber.ID(), forwarded, paused)
+			changes = append(changes, change{subscriberID: subscriber.ID(), forwarded: forwarded, paused: paused})
+		}
+	}
+
+	callback := p.onChangedCallback
+
+	p.mu.Unlock()
+
+	if callback == nil {
+		return
+	}
+
+	for _, c := range changes {
+		callback(c.subscriberID, c.forwarded, c.paused)
+	}
+}
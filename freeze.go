@@ -0,0 +1,347 @@
+package sfu
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// FreezeEvent is passed to the Room.OnFreeze and Room.OnRecover callbacks. An ingress freeze is a video layer that the
+// publisher stopped sending, an egress freeze is a video track that stopped being forwarded to a subscriber.
+type FreezeEvent struct {
+	Direction   PacketDirection `json:"direction"`
+	TrackID     string          `json:"track_id"`
+	PublisherID string          `json:"publisher_id"`
+	// SubscriberID is the client that the egress track is forwarded to, empty on the ingress
+	SubscriberID string `json:"subscriber_id,omitempty"`
+	// Quality is the simulcast layer of the ingress freeze
+	Quality QualityLevel `json:"quality"`
+	// Since is the time of the last packet before the freeze
+	Since time.Time `json:"since"`
+	// Duration is the length of the freeze, it's zero on the OnFreeze callbacks
+	Duration time.Duration `json:"duration"`
+}
+
+// FreezeStat is the freeze counters of a published video layer or a subscribed video track, see Room.FreezeStats
+type FreezeStat struct {
+	Direction    PacketDirection `json:"direction"`
+	TrackID      string          `json:"track_id"`
+	PublisherID  string          `json:"publisher_id"`
+	SubscriberID string          `json:"subscriber_id,omitempty"`
+	Quality      QualityLevel    `json:"quality"`
+	Freezes      uint64          `json:"freezes"`
+	// FrozenDuration is the total length of the recovered freezes
+	FrozenDuration time.Duration `json:"frozen_duration"`
+	Frozen         bool          `json:"frozen"`
+}
+
+// trackFrozen is the data of the track_frozen message that sent to the subscriber
+type trackFrozen struct {
+	TrackID string `json:"track_id"`
+	Frozen  bool   `json:"frozen"`
+}
+
+type internalDataTrackFrozen struct {
+	Type string      `json:"type"`
+	Data trackFrozen `json:"data"`
+}
+
+type freezeKey struct {
+	direction    PacketDirection
+	trackID      string
+	subscriberID string
+	quality      QualityLevel
+}
+
+// freezeChange is a freeze or a recovery that found by the room freeze loop
+type freezeChange struct {
+	event      FreezeEvent
+	subscriber *Client
+}
+
+type freezeStream struct {
+	stat       FreezeStat
+	subscriber *Client
+	// the unix nano time of the last packet, it's updated from the packet path
+	lastPacket  atomic.Int64
+	frozenSince time.Time
+	expected    bool
+}
+
+// freezeDetector keeps the time of the last packet of every published video layer and subscribed video track,
+// the streams are checked by the room freeze loop
+type freezeDetector struct {
+	threshold time.Duration
+	streams   sync.Map
+	mu        sync.Mutex
+}
+
+func newFreezeDetector(threshold time.Duration) *freezeDetector {
+	return &freezeDetector{threshold: threshold}
+}
+
+// intercept is the room packet interceptor of both directions that records the time of the video packets
+func (d *freezeDetector) intercept(info PacketInfo, p *rtp.Packet) bool {
+	if info.Kind != webrtc.RTPCodecTypeVideo {
+		return true
+	}
+
+	key := freezeKey{direction: info.Direction, trackID: info.TrackID, quality: info.Quality}
+	if info.Direction == PacketEgress {
+		// the egress stream is the same while the forwarded layer is switched
+		key.subscriberID = info.Subscriber.ID()
+		key.quality = QualityNone
+	}
+
+	now := time.Now().UnixNano()
+
+	if value, ok := d.streams.Load(key); ok {
+		value.(*freezeStream).lastPacket.Store(now)
+		return true
+	}
+
+	stream := &freezeStream{
+		stat: FreezeStat{
+			Direction:    info.Direction,
+			TrackID:      info.TrackID,
+			PublisherID:  info.PublisherID,
+			SubscriberID: key.subscriberID,
+			Quality:      key.quality,
+		},
+		subscriber: info.Subscriber,
+		expected:   true,
+	}
+	stream.lastPacket.Store(now)
+
+	d.streams.LoadOrStore(key, stream)
+
+	return true
+}
+
+// isFreezeStreamExpected returns true if the packets of the stream should be received or forwarded, false if the stream
+// is muted or paused. The second value is false if the track or the subscription is ended.
+func (r *Room) isFreezeStreamExpected(stream *freezeStream) (bool, bool) {
+	publisher, err := r.sfu.GetClient(stream.stat.PublisherID)
+	if err != nil {
+		return false, false
+	}
+
+	track, err := publisher.tracks.Get(stream.stat.TrackID)
+	if err != nil {
+		return false, false
+	}
+
+	if stream.stat.Direction == PacketEgress {
+		clientTrack, ok := stream.subscriber.ClientTracks()[stream.stat.TrackID]
+		if !ok {
+			return false, false
+		}
+
+		if publisher.isPublishMuted(track.ID(), webrtc.RTPCodecTypeVideo) {
+			return false, true
+		}
+
+		return !stream.subscriber.isTrackPaused(stream.stat.TrackID) && clientTrack.MaxQuality() != QualityNone, true
+	}
+
+	if publisher.isPublishMuted(track.ID(), webrtc.RTPCodecTypeVideo) {
+		return false, true
+	}
+
+	switch t := track.(type) {
+	case *Track:
+		return !t.IsAutoPaused() && t.base.clientTracks.Length() > 0, true
+	case *SimulcastTrack:
+		return !t.IsAutoPaused() && t.base.clientTracks.Length() > 0 && !slices.Contains(t.PausedLayers(), stream.stat.Quality), true
+	default:
+		return true, true
+	}
+}
+
+// checkFreezes compares the last packet time of the streams with the threshold, it's called by the room freeze loop
+func (r *Room) checkFreezes(now time.Time) {
+	d := r.freezes
+
+	frozen := make([]freezeChange, 0)
+	recovered := make([]freezeChange, 0)
+
+	d.mu.Lock()
+
+	d.streams.Range(func(key, value any) bool {
+		stream := value.(*freezeStream)
+
+		expected, ok := r.isFreezeStreamExpected(stream)
+		if !ok {
+			d.streams.Delete(key)
+			return true
+		}
+
+		lastPacket := time.Unix(0, stream.lastPacket.Load())
+
+		if expected && !stream.expected {
+			// the paused stream has a grace period after it's resumed
+			stream.lastPacket.Store(now.UnixNano())
+			lastPacket = now
+		}
+
+		stream.expected = expected
+
+		switch {
+		case stream.frozenSince.IsZero() && expected && now.Sub(lastPacket) > d.threshold:
+			stream.frozenSince = lastPacket
+			stream.stat.Freezes++
+			stream.stat.Frozen = true
+
+			frozen = append(frozen, freezeChange{stream.event(lastPacket, 0), stream.subscriber})
+		case !stream.frozenSince.IsZero() && (lastPacket.After(stream.frozenSince) || !expected):
+			end := lastPacket
+			if !expected {
+				end = now
+			}
+
+			duration := end.Sub(stream.frozenSince)
+			stream.stat.FrozenDuration += duration
+			stream.stat.Frozen = false
+
+			recovered = append(recovered, freezeChange{stream.event(stream.frozenSince, duration), stream.subscriber})
+			stream.frozenSince = time.Time{}
+		}
+
+		return true
+	})
+
+	d.mu.Unlock()
+
+	for _, change := range frozen {
+		r.onFreeze(change.event)
+		sendTrackFrozen(change, true)
+	}
+
+	for _, change := range recovered {
+		r.onRecover(change.event)
+		sendTrackFrozen(change, false)
+	}
+}
+
+func (s *freezeStream) event(since time.Time, duration time.Duration) FreezeEvent {
+	return FreezeEvent{
+		Direction:    s.stat.Direction,
+		TrackID:      s.stat.TrackID,
+		PublisherID:  s.stat.PublisherID,
+		SubscriberID: s.stat.SubscriberID,
+		Quality:      s.stat.Quality,
+		Since:        since,
+		Duration:     duration,
+	}
+}
+
+func (r *Room) loopFreezeDetection() {
+	ticker := time.NewTicker(r.freezes.threshold / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.context.Done():
+			return
+		case now := <-ticker.C:
+			r.checkFreezes(now)
+		}
+	}
+}
+
+// FreezeStats returns the freeze counters of the published video layers and the subscribed video tracks that are
+// still in the room, it's empty if RoomOptions.FreezeThreshold is not set
+func (r *Room) FreezeStats() []FreezeStat {
+	stats := make([]FreezeStat, 0)
+
+	if r.freezes == nil {
+		return stats
+	}
+
+	r.freezes.mu.Lock()
+	r.freezes.streams.Range(func(_, value any) bool {
+		stats = append(stats, value.(*freezeStream).stat)
+		return true
+	})
+	r.freezes.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].TrackID != stats[j].TrackID {
+			return stats[i].TrackID < stats[j].TrackID
+		}
+
+		if stats[i].Direction != stats[j].Direction {
+			return stats[i].Direction < stats[j].Direction
+		}
+
+		if stats[i].SubscriberID != stats[j].SubscriberID {
+			return stats[i].SubscriberID < stats[j].SubscriberID
+		}
+
+		return stats[i].Quality > stats[j].Quality
+	})
+
+	return stats
+}
+
+// OnFreeze event is called when a published video layer or a subscribed video track doesn't have a packet for
+// RoomOptions.FreezeThreshold while it should, the subscriber of the egress freeze also gets the track_frozen message
+func (r *Room) OnFreeze(callback func(event FreezeEvent)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onFreezeCallbacks = append(r.onFreezeCallbacks, callback)
+}
+
+// OnRecover event is called when the frozen video has a packet again or it's paused, the event has the freeze duration
+func (r *Room) OnRecover(callback func(event FreezeEvent)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onRecoverCallbacks = append(r.onRecoverCallbacks, callback)
+}
+
+func (r *Room) onFreeze(event FreezeEvent) {
+	r.mu.RLock()
+	callbacks := r.onFreezeCallbacks
+	r.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		callback(event)
+	}
+}
+
+func (r *Room) onRecover(event FreezeEvent) {
+	r.mu.RLock()
+	callbacks := r.onRecoverCallbacks
+	r.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		callback(event)
+	}
+}
+
+// sendTrackFrozen tells the subscriber of the egress freeze to show or hide the frozen video indicator, the subscriber
+// can be a client of another room that subscribed with Client.SubscribeRoomTracks
+func sendTrackFrozen(change freezeChange, frozen bool) {
+	if change.event.Direction != PacketEgress || change.subscriber == nil {
+		return
+	}
+
+	data, err := json.Marshal(internalDataTrackFrozen{
+		Type: messageTypeTrackFrozen,
+		Data: trackFrozen{TrackID: change.event.TrackID, Frozen: frozen},
+	})
+	if err != nil {
+		change.subscriber.log.Errorf("room: error marshal track frozen ", err)
+		return
+	}
+
+	change.subscriber.sendInternalMessage(data)
+}
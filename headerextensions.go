@@ -0,0 +1,209 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/inlivedev/sfu/pkg/dependencydescriptor"
+	"github.com/inlivedev/sfu/pkg/framemarking"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+var ErrHeaderExtensionMissing = errors.New("client: error the expected header extension is not negotiated")
+
+// HeaderExtensions selects the RTP header extensions that registered to the media engine of the clients in the room,
+// see RoomOptions.HeaderExtensions. The extensions that needed by the enabled client options like the audio level for
+// the voice detection, the playout delay, and the abs-capture-time for the A/V sync are always registered.
+type HeaderExtensions struct {
+	// AudioLevel negotiates the ssrc-audio-level extension of the audio tracks
+	AudioLevel bool `json:"audio_level"`
+	// TransportCC negotiates the transport-wide-cc extension, it's needed by the bandwidth estimation of the subscribers
+	// and the congestion control of the publishers
+	TransportCC bool `json:"transport_cc"`
+	// AbsSendTime negotiates the abs-send-time extension for the publishers that estimate the bandwidth with REMB
+	AbsSendTime bool `json:"abs_send_time"`
+	// MidRid negotiates the mid, rid, and repaired-rid extensions of the video tracks, they're needed to receive simulcast
+	MidRid bool `json:"mid_rid"`
+	// DependencyDescriptor negotiates the dependency descriptor extension that needed to forward the AV1 SVC layers
+	DependencyDescriptor bool `json:"dependency_descriptor"`
+	// FrameMarking negotiates the frame marking extension that used to forward the H264 temporal layers
+	FrameMarking bool `json:"frame_marking"`
+}
+
+func DefaultHeaderExtensions() HeaderExtensions {
+	return HeaderExtensions{
+		TransportCC:          true,
+		MidRid:               true,
+		DependencyDescriptor: true,
+		FrameMarking:         true,
+	}
+}
+
+// HeaderExtensionMissingError is passed to the OnHeaderExtensionMissing callbacks when a published track is negotiated
+// without a header extension that the room expects, the feature that reads the extension doesn't work for the track
+type HeaderExtensionMissingError struct {
+	ClientID string
+	TrackID  string
+	Kind     webrtc.RTPCodecType
+	URI      string
+}
+
+func (e *HeaderExtensionMissingError) Error() string {
+	return fmt.Sprintf("%s: client %s, %s track %s, %s", ErrHeaderExtensionMissing.Error(), e.ClientID, e.Kind, e.TrackID, e.URI)
+}
+
+func (e *HeaderExtensionMissingError) Unwrap() error {
+	return ErrHeaderExtensionMissing
+}
+
+// registerHeaderExtensions registers the selected header extensions to the media engine, the transport-wide-cc is
+// registered with its interceptors in NewClient
+func registerHeaderExtensions(m *webrtc.MediaEngine, extensions HeaderExtensions, voiceDetection bool) error {
+	register := func(uri string, kinds ...webrtc.RTPCodecType) error {
+		for _, kind := range kinds {
+			if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: uri}, kind); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if extensions.MidRid {
+		// let the client knows that we're receiving simulcast tracks
+		for _, uri := range []string{sdp.SDESMidURI, sdp.SDESRTPStreamIDURI, SdesRepairRTPStreamIDURI} {
+			if err := register(uri, webrtc.RTPCodecTypeVideo); err != nil {
+				return err
+			}
+		}
+	}
+
+	if extensions.DependencyDescriptor {
+		if err := register(dependencydescriptor.URI, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
+	}
+
+	if extensions.FrameMarking {
+		if err := register(framemarking.URI, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
+	}
+
+	if extensions.AudioLevel || voiceDetection {
+		if err := register(sdp.AudioLevelURI, webrtc.RTPCodecTypeAudio); err != nil {
+			return err
+		}
+	}
+
+	if extensions.AbsSendTime {
+		if err := register(sdp.ABSSendTimeURI, webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expectedHeaderExtensions returns the URIs of the extensions that the published track needs to be negotiated with
+func (c *Client) expectedHeaderExtensions(kind webrtc.RTPCodecType, rid, mimeType string) []string {
+	extensions := c.SFU().headerExtensions
+	uris := make([]string, 0)
+
+	if extensions.TransportCC {
+		uris = append(uris, sdp.TransportCCURI)
+	}
+
+	if extensions.AbsSendTime {
+		uris = append(uris, sdp.ABSSendTimeURI)
+	}
+
+	if kind == webrtc.RTPCodecTypeAudio {
+		if extensions.AudioLevel || c.options.EnableVoiceDetection {
+			uris = append(uris, sdp.AudioLevelURI)
+		}
+
+		return uris
+	}
+
+	if extensions.MidRid && rid != "" {
+		uris = append(uris, sdp.SDESMidURI, sdp.SDESRTPStreamIDURI)
+	}
+
+	if extensions.DependencyDescriptor && mimeType == webrtc.MimeTypeAV1 {
+		uris = append(uris, dependencydescriptor.URI)
+	}
+
+	return uris
+}
+
+// checkHeaderExtensions reports the expected extensions that not negotiated for the published track
+func (c *Client) checkHeaderExtensions(remoteTrack *webrtc.TrackRemote, negotiated []webrtc.RTPHeaderExtensionParameter) {
+	for _, uri := range c.expectedHeaderExtensions(remoteTrack.Kind(), remoteTrack.RID(), remoteTrack.Codec().MimeType) {
+		if slices.ContainsFunc(negotiated, func(ext webrtc.RTPHeaderExtensionParameter) bool { return ext.URI == uri }) {
+			continue
+		}
+
+		err := &HeaderExtensionMissingError{ClientID: c.id, TrackID: remoteTrack.ID(), Kind: remoteTrack.Kind(), URI: uri}
+		c.log.Warn(err.Error())
+
+		c.onHeaderExtensionMissing(err)
+	}
+}
+
+// NegotiatedHeaderExtensions returns the RTP header extensions of the kind that negotiated with the client,
+// both on the published and the subscribed tracks
+func (c *Client) NegotiatedHeaderExtensions(kind webrtc.RTPCodecType) []webrtc.RTPHeaderExtensionParameter {
+	extensions := make([]webrtc.RTPHeaderExtensionParameter, 0)
+
+	add := func(params webrtc.RTPParameters) {
+		for _, ext := range params.HeaderExtensions {
+			if !slices.ContainsFunc(extensions, func(e webrtc.RTPHeaderExtensionParameter) bool { return e.URI == ext.URI }) {
+				extensions = append(extensions, ext)
+			}
+		}
+	}
+
+	for _, transceiver := range c.peerConnection.PC().GetTransceivers() {
+		if transceiver.Kind() != kind {
+			continue
+		}
+
+		if receiver := transceiver.Receiver(); receiver != nil {
+			add(receiver.GetParameters())
+		}
+
+		if sender := transceiver.Sender(); sender != nil {
+			add(sender.GetParameters().RTPParameters)
+		}
+	}
+
+	return extensions
+}
+
+// OnHeaderExtensionMissing event is called when a published track is negotiated without a header extension that the room
+// expects, for example the audio level without the ssrc-audio-level or a simulcast track without the rid.
+// The error is a *HeaderExtensionMissingError that wraps ErrHeaderExtensionMissing.
+func (c *Client) OnHeaderExtensionMissing(callback func(err error)) {
+	c.muCallback.Lock()
+	defer c.muCallback.Unlock()
+
+	c.onHeaderExtensionMissingCallbacks = append(c.onHeaderExtensionMissingCallbacks, callback)
+}
+
+func (c *Client) onHeaderExtensionMissing(err error) {
+	c.muCallback.Lock()
+	callbacks := append([]func(error){}, c.onHeaderExtensionMissingCallbacks...)
+	c.muCallback.Unlock()
+
+	for _, callback := range callbacks {
+		callback(err)
+	}
+}
+
+// HeaderExtensions returns the RTP header extensions that the clients in the room are negotiated with
+func (r *Room) HeaderExtensions() HeaderExtensions {
+	return r.sfu.headerExtensions
+}
@@ -0,0 +1,109 @@
+package sfu
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/rtppool"
+)
+
+// HealthStatus is the runtime health of the SFU, see Manager.Health
+type HealthStatus struct {
+	// Ready is false when the manager is draining or closed, the load balancer should stop sending new clients
+	Ready   bool `json:"ready"`
+	Rooms   int  `json:"rooms"`
+	Clients int  `json:"clients"`
+	// Tracks is the number of the published RTP streams, every stream has a read loop goroutine
+	Tracks     int `json:"tracks"`
+	Goroutines int `json:"goroutines"`
+	// GoroutinesPerClient is the average number of goroutines of a client, a value that keeps growing is a goroutine leak
+	GoroutinesPerClient float64 `json:"goroutines_per_client"`
+	// PacketPool is the number of the pooled packets and payloads that in use by all published tracks
+	PacketPool rtppool.Stats `json:"packet_pool"`
+	// StuckTracks are the read loops that blocked longer than the stuck timeout
+	StuckTracks []StuckTrack `json:"stuck_tracks"`
+	// HotPathLogs is the number of the rate limited logs of the packet path by the message format, see HotPathLogCounters
+	HotPathLogs map[string]uint64 `json:"hot_path_logs"`
+}
+
+// StuckTrack is a published track that its read loop is not progressing, usually because a packet callback is blocked.
+// The packets of the track are not forwarded until the loop continues.
+type StuckTrack struct {
+	RoomID   string    `json:"room_id"`
+	ClientID string    `json:"client_id"`
+	TrackID  string    `json:"track_id"`
+	RID      string    `json:"rid,omitempty"`
+	Since    time.Time `json:"since"`
+}
+
+// Health returns the runtime health of all rooms. A track read loop is stuck if it's blocked longer than stuckTimeout,
+// the loop wakes up at least every second even if the publisher doesn't send any packet.
+func (m *Manager) Health(stuckTimeout time.Duration) HealthStatus {
+	now := time.Now()
+
+	health := HealthStatus{
+		Ready:       !m.IsDraining() && m.context.Err() == nil,
+		Goroutines:  runtime.NumGoroutine(),
+		StuckTracks: make([]StuckTrack, 0),
+		HotPathLogs: hotPathLogCounters.Snapshot(),
+	}
+
+	for _, room := range m.roomList() {
+		health.Rooms++
+
+		for _, client := range room.sfu.GetClients() {
+			health.Clients++
+
+			for _, track := range client.Tracks() {
+				remoteTracks := publishedRemoteTracks(track)
+
+				// the layers of a simulcast track share the packet pool
+				if len(remoteTracks) > 0 {
+					stats := remoteTracks[0].rtppool.Stats()
+					health.PacketPool.PacketsInUse += stats.PacketsInUse
+					health.PacketPool.PayloadsInUse += stats.PayloadsInUse
+				}
+
+				for _, remoteTrack := range remoteTracks {
+					health.Tracks++
+
+					if since, stuck := remoteTrack.isStuck(now, stuckTimeout); stuck {
+						health.StuckTracks = append(health.StuckTracks, StuckTrack{
+							RoomID:   room.id,
+							ClientID: client.ID(),
+							TrackID:  track.ID(),
+							RID:      remoteTrack.Track().RID(),
+							Since:    since,
+						})
+					}
+				}
+			}
+		}
+	}
+
+	if health.Clients > 0 {
+		health.GoroutinesPerClient = float64(health.Goroutines) / float64(health.Clients)
+	}
+
+	return health
+}
+
+// publishedRemoteTracks returns the received RTP streams of a published track, a simulcast track has one for each layer
+func publishedRemoteTracks(track ITrack) []*remoteTrack {
+	remoteTracks := make([]*remoteTrack, 0, 1)
+
+	switch t := track.(type) {
+	case *Track:
+		remoteTracks = append(remoteTracks, t.RemoteTrack())
+	case *AudioTrack:
+		remoteTracks = append(remoteTracks, t.RemoteTrack())
+	case *SimulcastTrack:
+		for _, quality := range []QualityLevel{QualityHigh, QualityMid, QualityLow} {
+			if remoteTrack := t.GetRemoteTrack(quality); remoteTrack != nil {
+				remoteTracks = append(remoteTracks, remoteTrack)
+			}
+		}
+	}
+
+	return remoteTracks
+}
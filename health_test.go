@@ -0,0 +1,35 @@
+package sfu
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteTrackStuck(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	track := &remoteTrack{context: ctx, heartbeat: &atomic.Int64{}}
+	now := time.Now()
+
+	// the loop is not started yet
+	_, stuck := track.isStuck(now, time.Second)
+	require.False(t, stuck)
+
+	track.heartbeat.Store(now.Add(-500 * time.Millisecond).UnixNano())
+	_, stuck = track.isStuck(now, time.Second)
+	require.False(t, stuck)
+
+	track.heartbeat.Store(now.Add(-5 * time.Second).UnixNano())
+	since, stuck := track.isStuck(now, time.Second)
+	require.True(t, stuck)
+	require.Equal(t, now.Add(-5*time.Second).UnixNano(), since.UnixNano())
+
+	// the ended track is not stuck
+	cancel()
+	_, stuck = track.isStuck(now, time.Second)
+	require.False(t, stuck)
+}
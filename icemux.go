@@ -0,0 +1,185 @@
+package sfu
+
+import (
+	"errors"
+	"net"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/logging"
+	"github.com/pion/transport/v3/stdnet"
+	"github.com/pion/webrtc/v4"
+)
+
+var ErrICEMuxNoPort = errors.New("icemux: error at least one of the UDP or TCP port is required")
+
+type ICEMuxOptions struct {
+	// UDPPort is the single UDP port of all the ICE traffic on every interface. Default is 0 means the UDP mux is disabled
+	// and the ephemeral port range of the setting engine is used
+	UDPPort int
+	// TCPPort is the port of the ICE-TCP listener, the clients behind a firewall that blocks UDP can connect to it.
+	// Default is 0 means the ICE-TCP is disabled
+	TCPPort int
+	// UDPNetworks is the networks of the UDP mux, default is UDP4, and UDP6 if Network.EnableIPv6 is set
+	UDPNetworks []ice.NetworkType
+	// Network selects the interfaces and the IPs that the UDP mux listens on and the candidates are gathered from
+	Network NetworkOptions
+	// IncludeLoopback gathers the loopback candidates, only useful on the local tests
+	IncludeLoopback bool
+	// WriteBatch batches the writes of the UDP mux sockets to reduce the syscalls when there are many subscribers
+	WriteBatch WriteBatchOptions
+	Log        logging.LeveledLogger
+}
+
+// ICEMux runs the ICE of all clients on a single UDP port and a single ICE-TCP port, so the SFU only needs
+// two ports to be opened on the firewall or the load balancer.
+type ICEMux struct {
+	opts     ICEMuxOptions
+	udpMux   *ice.MultiUDPMuxDefault
+	tcpMux   *ice.TCPMuxDefault
+	batchNet *batchNet
+	ipFilter func(net.IP) bool
+}
+
+func NewICEMux(opts ICEMuxOptions) (*ICEMux, error) {
+	if opts.UDPPort <= 0 && opts.TCPPort <= 0 {
+		return nil, ErrICEMuxNoPort
+	}
+
+	if len(opts.UDPNetworks) == 0 {
+		for _, networkType := range opts.Network.udpNetworkTypes() {
+			if networkType == webrtc.NetworkTypeUDP6 {
+				opts.UDPNetworks = append(opts.UDPNetworks, ice.NetworkTypeUDP6)
+			} else {
+				opts.UDPNetworks = append(opts.UDPNetworks, ice.NetworkTypeUDP4)
+			}
+		}
+	}
+
+	ipFilter, err := opts.Network.ipFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Log == nil {
+		opts.Log = logging.NewDefaultLoggerFactory().NewLogger("sfu")
+	}
+
+	m := &ICEMux{opts: opts, ipFilter: ipFilter}
+
+	if opts.UDPPort > 0 {
+		udpOpts := []ice.UDPMuxFromPortOption{
+			ice.UDPMuxFromPortWithReadBufferSize(25_000_000),
+			ice.UDPMuxFromPortWithWriteBufferSize(25_000_000),
+			ice.UDPMuxFromPortWithNetworks(opts.UDPNetworks...),
+			ice.UDPMuxFromPortWithLogger(opts.Log),
+			ice.UDPMuxFromPortWithIPFilter(ipFilter),
+		}
+
+		if filter := opts.Network.interfaceFilter(); filter != nil {
+			udpOpts = append(udpOpts, ice.UDPMuxFromPortWithInterfaceFilter(filter))
+		}
+
+		if opts.IncludeLoopback {
+			udpOpts = append(udpOpts, ice.UDPMuxFromPortWithLoopback())
+		}
+
+		if opts.WriteBatch.Enabled {
+			n, err := stdnet.NewNet()
+			if err != nil {
+				return nil, err
+			}
+
+			m.batchNet = newBatchNet(n, opts.WriteBatch, opts.Log)
+			udpOpts = append(udpOpts, ice.UDPMuxFromPortWithNet(m.batchNet))
+		}
+
+		udpMux, err := ice.NewMultiUDPMuxFromPort(opts.UDPPort, udpOpts...)
+		if err != nil {
+			return nil, err
+		}
+
+		m.udpMux = udpMux
+	}
+
+	if opts.TCPPort > 0 {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: opts.TCPPort})
+		if err != nil {
+			_ = m.Close()
+			return nil, err
+		}
+
+		// the listener is closed with the mux
+		m.tcpMux = ice.NewTCPMuxDefault(ice.TCPMuxParams{
+			Listener:        listener,
+			Logger:          opts.Log,
+			ReadBufferSize:  8,
+			WriteBufferSize: 4 * 1024 * 1024,
+		})
+	}
+
+	return m, nil
+}
+
+// Configure sets the muxes and the network types of the setting engine, use it on Options.SettingEngine
+// before creating the manager.
+func (m *ICEMux) Configure(settingEngine *webrtc.SettingEngine) {
+	networkTypes := make([]webrtc.NetworkType, 0)
+
+	if m.udpMux != nil {
+		settingEngine.SetICEUDPMux(m.udpMux)
+
+		for _, network := range m.opts.UDPNetworks {
+			if network == ice.NetworkTypeUDP6 {
+				networkTypes = append(networkTypes, webrtc.NetworkTypeUDP6)
+			} else {
+				networkTypes = append(networkTypes, webrtc.NetworkTypeUDP4)
+			}
+		}
+	}
+
+	if m.tcpMux != nil {
+		settingEngine.SetICETCPMux(m.tcpMux)
+
+		if m.opts.Network.includeIPv4() {
+			networkTypes = append(networkTypes, webrtc.NetworkTypeTCP4)
+		}
+
+		if m.opts.Network.EnableIPv6 {
+			networkTypes = append(networkTypes, webrtc.NetworkTypeTCP6)
+		}
+	}
+
+	settingEngine.SetNetworkTypes(networkTypes)
+	settingEngine.SetIPFilter(m.ipFilter)
+
+	if filter := m.opts.Network.interfaceFilter(); filter != nil {
+		settingEngine.SetInterfaceFilter(filter)
+	}
+	settingEngine.SetIncludeLoopbackCandidate(m.opts.IncludeLoopback)
+}
+
+// WriteBatchStats returns the batch write stats of every UDP mux socket, it's empty if the batch writes are disabled
+func (m *ICEMux) WriteBatchStats() []WriteBatchStats {
+	if m.batchNet == nil {
+		return []WriteBatchStats{}
+	}
+
+	return m.batchNet.stats()
+}
+
+// Close closes the UDP and TCP listeners, the clients that use them are disconnected
+func (m *ICEMux) Close() error {
+	var err error
+
+	if m.udpMux != nil {
+		err = m.udpMux.Close()
+	}
+
+	if m.tcpMux != nil {
+		if tcpErr := m.tcpMux.Close(); tcpErr != nil && err == nil {
+			err = tcpErr
+		}
+	}
+
+	return err
+}
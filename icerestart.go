@@ -0,0 +1,94 @@
+package sfu
+
+import (
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	defaultICERestartMaxAttempts = 3
+	defaultICERestartBackoff     = time.Second
+)
+
+// RestartICE requests the next renegotiation offer to the client is sent with the new ICE credentials, the client gathers
+// the new candidates and the media continues on the new network path. Use it when the client reports its network is changed,
+// the connection is not required to be failed. The offer is sent through the renegotiation callback.
+//
+// The ICE servers are refreshed from Options.ICEServersProvider before the restart if it's set.
+func (c *Client) RestartICE() error {
+	if c.state.Load() == ClientStateEnded {
+		return ErrClientStoped
+	}
+
+	if c.onRenegotiation == nil {
+		return ErrRenegotiationCallback
+	}
+
+	c.refreshICEServers()
+
+	c.iceRestartNeeded.Store(true)
+	c.renegotiate(false)
+
+	return nil
+}
+
+// autoRestartICE starts restarting the ICE of the failed connection if ClientOptions.AutoICERestart is enabled,
+// the client is stopped when the connection is not recovered after the max attempts.
+func (c *Client) autoRestartICE() bool {
+	if !c.options.AutoICERestart {
+		return false
+	}
+
+	// the restart is already running, it checks the connection state after each attempt
+	if !c.iceRestarting.CompareAndSwap(false, true) {
+		return true
+	}
+
+	maxAttempts := c.options.ICERestartMaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultICERestartMaxAttempts
+	}
+
+	backoff := c.options.ICERestartBackoff
+	if backoff <= 0 {
+		backoff = defaultICERestartBackoff
+	}
+
+	go func() {
+		defer c.iceRestarting.Store(false)
+
+		for attempt := 1; ; attempt++ {
+			select {
+			case <-c.context.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			if c.peerConnection.PC().ConnectionState() == webrtc.PeerConnectionStateConnected {
+				c.log.Infof("client: %s connection is recovered after %d ICE restarts", c.ID(), attempt-1)
+				return
+			}
+
+			if attempt > maxAttempts {
+				break
+			}
+
+			c.log.Infof("client: %s restart ICE attempt %d", c.ID(), attempt)
+
+			if err := c.RestartICE(); err != nil {
+				c.log.Warnf("client: error restart ICE ", err)
+			}
+
+			backoff *= 2
+		}
+
+		c.log.Infof("client: %s connection is not recovered after %d ICE restarts, stopping client", c.ID(), maxAttempts)
+
+		if err := c.stop(); err != nil {
+			c.log.Errorf("client: error stop client ", err)
+		}
+	}()
+
+	return true
+}
@@ -0,0 +1,118 @@
+package sfu
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// ICEServersProvider returns the ICE servers of a client when the client is created and when the ICE is restarted,
+// use it to generate the time-limited TURN credentials per client. See NewTURNCredentialsProvider.
+type ICEServersProvider func(roomID, clientID string) ([]webrtc.ICEServer, error)
+
+type internalDataICEServers struct {
+	Type string             `json:"type"`
+	Data []webrtc.ICEServer `json:"data"`
+}
+
+// NewTURNCredentialsProvider returns an ICEServersProvider that generates the TURN credentials with the shared secret
+// of the TURN REST API, like the coturn use-auth-secret option. The username is the expiry Unix time and the client ID,
+// and the credential is the base64 HMAC-SHA1 of the username.
+func NewTURNCredentialsProvider(urls []string, secret string, ttl time.Duration) ICEServersProvider {
+	return func(roomID, clientID string) ([]webrtc.ICEServer, error) {
+		username, credential := TURNCredentials(secret, clientID, time.Now().Add(ttl))
+
+		return []webrtc.ICEServer{
+			{
+				URLs:           urls,
+				Username:       username,
+				Credential:     credential,
+				CredentialType: webrtc.ICECredentialTypePassword,
+			},
+		}, nil
+	}
+}
+
+// TURNCredentials returns the TURN REST API username and credential of the client that valid until the expiry
+func TURNCredentials(secret, clientID string, expiry time.Time) (string, string) {
+	username := fmt.Sprintf("%d:%s", expiry.Unix(), clientID)
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// iceServersFor returns the ICE servers of the client from the provider, or the SFU ICE servers if the provider is not set
+func (s *SFU) iceServersFor(clientID string) []webrtc.ICEServer {
+	if s.iceServersProvider == nil {
+		return s.iceServers
+	}
+
+	servers, err := s.iceServersProvider(clientID)
+	if err != nil {
+		s.log.Errorf("sfu: error get the ICE servers of client %s, use the default ICE servers: %s", clientID, err.Error())
+		return s.iceServers
+	}
+
+	return servers
+}
+
+// ICEServers returns the ICE servers of the client, pass them to the client with the join response so the client
+// uses the same TURN credentials.
+func (c *Client) ICEServers() []webrtc.ICEServer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.iceServers
+}
+
+// UpdateICEServers replaces the ICE servers of the client peer connection, they're used on the next ICE restart.
+// The client is notified with the ice_servers internal message to update its peer connection configuration.
+func (c *Client) UpdateICEServers(servers []webrtc.ICEServer) error {
+	config := c.peerConnection.PC().GetConfiguration()
+	config.ICEServers = servers
+
+	if err := c.peerConnection.PC().SetConfiguration(config); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.iceServers = servers
+	c.mu.Unlock()
+
+	data, err := json.Marshal(internalDataICEServers{
+		Type: messageTypeICEServers,
+		Data: servers,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.sendInternalMessage(data)
+
+	return nil
+}
+
+// refreshICEServers gets the new ICE servers from the provider before the ICE is restarted, so the expired TURN
+// credentials are rotated
+func (c *Client) refreshICEServers() {
+	if c.SFU().iceServersProvider == nil {
+		return
+	}
+
+	servers, err := c.SFU().iceServersProvider(c.ID())
+	if err != nil {
+		c.log.Errorf("client: error refresh the ICE servers ", err)
+		return
+	}
+
+	if err := c.UpdateICEServers(servers); err != nil {
+		c.log.Errorf("client: error update the ICE servers ", err)
+	}
+}
@@ -0,0 +1,264 @@
+package sfu
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+const (
+	// the target delay is the multiple of the interarrival jitter
+	jitterBufferDelayFactor = 3
+	// the maximum number of the held packets, the oldest gap is skipped once it's full
+	jitterBufferMaxPackets = 512
+)
+
+// JitterBufferStats is the stats of the jitter buffer of a published track
+type JitterBufferStats struct {
+	// Jitter is the interarrival jitter of the received packets, RFC 3550 section 6.4.1
+	Jitter time.Duration `json:"jitter_ns"`
+	// TargetDelay is how long the buffer waits for a missing packet before the next packets are forwarded without it
+	TargetDelay time.Duration `json:"target_delay_ns"`
+	// Late is the number of the packets that arrived after their sequence number is forwarded or skipped
+	Late uint64 `json:"late"`
+	// Dropped is the number of the dropped packets, the late and the duplicate packets
+	Dropped uint64 `json:"dropped"`
+	// Lost is the number of the missing sequence numbers that skipped after the target delay
+	Lost uint64 `json:"lost"`
+}
+
+type jitterBufferPacket struct {
+	attrs  interceptor.Attributes
+	packet *rtp.Packet
+	added  time.Time
+}
+
+// JitterBuffer reorders the packets of a published track before they're forwarded. The in order packets are
+// forwarded right away, the packets after a gap are held until the missing packet arrives or the oldest held packet
+// waited for the target delay, then the gap is skipped. The target delay follows the interarrival jitter of the
+// stream and it's kept between the min and max delay.
+//
+// The forward callback is called while the buffer is locked, it must not call the buffer.
+type JitterBuffer struct {
+	mu        sync.Mutex
+	clockRate uint32
+	minDelay  time.Duration
+	maxDelay  time.Duration
+	started   bool
+	nextSeq   uint16
+	packets   map[uint16]*jitterBufferPacket
+	// the arrival time and the timestamp of the previous packet to measure the jitter
+	lastArrival time.Time
+	lastTS      uint32
+	// the jitter in seconds
+	jitter float64
+	stats  JitterBufferStats
+}
+
+// NewJitterBuffer returns the jitter buffer for the codec clock rate, the target delay starts from the min delay
+func NewJitterBuffer(clockRate uint32, minDelay, maxDelay time.Duration) *JitterBuffer {
+	if maxDelay < minDelay {
+		maxDelay = minDelay
+	}
+
+	return &JitterBuffer{
+		clockRate: clockRate,
+		minDelay:  minDelay,
+		maxDelay:  maxDelay,
+		packets:   make(map[uint16]*jitterBufferPacket),
+		stats: JitterBufferStats{
+			TargetDelay: minDelay,
+		},
+	}
+}
+
+// Push adds the received packet and forwards the packets that are in order. The packet is copied if it's held, so
+// the caller can reuse it after Push returns.
+func (b *JitterBuffer) Push(attrs interceptor.Attributes, p *rtp.Packet, forward func(interceptor.Attributes, *rtp.Packet)) {
+	b.push(attrs, p, time.Now(), forward)
+}
+
+func (b *JitterBuffer) push(attrs interceptor.Attributes, p *rtp.Packet, now time.Time, forward func(interceptor.Attributes, *rtp.Packet)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.started {
+		b.started = true
+		b.nextSeq = p.SequenceNumber
+	}
+
+	diff := int16(p.SequenceNumber - b.nextSeq)
+
+	if diff < 0 {
+		// the sequence number is already forwarded or skipped
+		b.stats.Late++
+		b.stats.Dropped++
+
+		return
+	}
+
+	if _, ok := b.packets[p.SequenceNumber]; ok {
+		b.stats.Dropped++
+		return
+	}
+
+	b.updateJitter(p.Timestamp, now)
+
+	if int(diff) >= jitterBufferMaxPackets {
+		// the stream is restarted or the gap is too long to wait, forward everything and start from the packet
+		b.skip(len(b.packets), forward)
+		b.nextSeq = p.SequenceNumber
+	}
+
+	if p.SequenceNumber == b.nextSeq && len(b.packets) == 0 {
+		b.nextSeq++
+		forward(attrs, p)
+
+		return
+	}
+
+	b.packets[p.SequenceNumber] = &jitterBufferPacket{
+		attrs:  attrs,
+		packet: p.Clone(),
+		added:  now,
+	}
+
+	b.forwardInOrder(forward)
+
+	if len(b.packets) >= jitterBufferMaxPackets {
+		b.skip(1, forward)
+	}
+
+	b.expire(now, forward)
+}
+
+// Expire forwards the held packets that waited for the target delay, the missing packets before them are skipped
+func (b *JitterBuffer) Expire(forward func(interceptor.Attributes, *rtp.Packet)) {
+	b.expireAt(time.Now(), forward)
+}
+
+func (b *JitterBuffer) expireAt(now time.Time, forward func(interceptor.Attributes, *rtp.Packet)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.expire(now, forward)
+}
+
+// Deadline returns the time when the oldest held packet is waited for the target delay, false if no packet is held
+func (b *JitterBuffer) Deadline() (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	oldest, ok := b.oldest()
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return oldest.Add(b.stats.TargetDelay), true
+}
+
+// Len returns the number of the held packets
+func (b *JitterBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.packets)
+}
+
+func (b *JitterBuffer) Stats() JitterBufferStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.stats
+}
+
+// updateJitter updates the interarrival jitter with the transit time difference of the packet and the previous
+// packet, RFC 3550 section 6.4.1
+func (b *JitterBuffer) updateJitter(ts uint32, now time.Time) {
+	if b.clockRate == 0 {
+		return
+	}
+
+	if !b.lastArrival.IsZero() {
+		arrival := now.Sub(b.lastArrival).Seconds()
+		sent := float64(int32(ts-b.lastTS)) / float64(b.clockRate)
+
+		b.jitter += (math.Abs(arrival-sent) - b.jitter) / 16
+	}
+
+	b.lastArrival = now
+	b.lastTS = ts
+
+	jitter := time.Duration(b.jitter * float64(time.Second))
+	target := min(max(jitter*jitterBufferDelayFactor, b.minDelay), b.maxDelay)
+
+	b.stats.Jitter = jitter
+	b.stats.TargetDelay = target
+}
+
+func (b *JitterBuffer) forwardInOrder(forward func(interceptor.Attributes, *rtp.Packet)) {
+	for {
+		pkt, ok := b.packets[b.nextSeq]
+		if !ok {
+			return
+		}
+
+		delete(b.packets, b.nextSeq)
+		b.nextSeq++
+		forward(pkt.attrs, pkt.packet)
+	}
+}
+
+// skip skips the gaps before the next n held packets and forwards them
+func (b *JitterBuffer) skip(n int, forward func(interceptor.Attributes, *rtp.Packet)) {
+	for i := 0; i < n && len(b.packets) > 0; i++ {
+		next, _ := b.first()
+		b.stats.Lost += uint64(next - b.nextSeq)
+		b.nextSeq = next
+		b.forwardInOrder(forward)
+	}
+}
+
+func (b *JitterBuffer) expire(now time.Time, forward func(interceptor.Attributes, *rtp.Packet)) {
+	for {
+		oldest, ok := b.oldest()
+		if !ok || now.Sub(oldest) < b.stats.TargetDelay {
+			return
+		}
+
+		b.skip(1, forward)
+	}
+}
+
+// first returns the lowest held sequence number
+func (b *JitterBuffer) first() (uint16, bool) {
+	found := false
+	first := uint16(0)
+
+	for seq := range b.packets {
+		if !found || int16(seq-first) < 0 {
+			first = seq
+			found = true
+		}
+	}
+
+	return first, found
+}
+
+// oldest returns the added time of the packet that held for the longest time
+func (b *JitterBuffer) oldest() (time.Time, bool) {
+	found := false
+	oldest := time.Time{}
+
+	for _, pkt := range b.packets {
+		if !found || pkt.added.Before(oldest) {
+			oldest = pkt.added
+			found = true
+		}
+	}
+
+	return oldest, found
+}
@@ -0,0 +1,49 @@
+package sfu
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/inlivedev/sfu/pkg/token"
+)
+
+var (
+	ErrTokenRoomMismatch = errors.New("room: error the token is for another room")
+	ErrTokenInvalidRole  = errors.New("room: error the token role is invalid")
+)
+
+// AddClientWithToken validates the join token and adds the client with the ID, name, role and allowed sources
+// from the token claims. The other options are from opts, see pkg/token to sign the token on the API server.
+func (r *Room) AddClientWithToken(verifier *token.Verifier, joinToken string, opts ClientOptions) (*Client, error) {
+	claims, err := verifier.Verify(joinToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.RoomID != r.id {
+		return nil, ErrTokenRoomMismatch
+	}
+
+	if claims.Role != "" {
+		role := ClientRole(claims.Role)
+		if !slices.Contains([]ClientRole{ClientRolePublisher, ClientRoleSubscriber, ClientRoleModerator}, role) {
+			return nil, ErrTokenInvalidRole
+		}
+
+		opts.Role = role
+	}
+
+	if len(claims.Sources) > 0 {
+		opts.AllowedSources = make([]TrackType, 0, len(claims.Sources))
+		for _, source := range claims.Sources {
+			opts.AllowedSources = append(opts.AllowedSources, TrackType(source))
+		}
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.ClientID
+	}
+
+	return r.AddClient(claims.ClientID, name, opts)
+}
@@ -0,0 +1,134 @@
+package sfu
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// the window of the received bitrate of a remote track
+	bitrateWindow = time.Second
+	// the headroom in percent over the measured bitrate of a needed layer, so the publisher encoder can still ramp up
+	uplinkBudgetHeadroom = 20
+)
+
+// LayerBitrates returns the received bitrate of each simulcast layer in bits per second, measured over the last second.
+// The layers that not published are not included.
+func (t *SimulcastTrack) LayerBitrates() map[QualityLevel]uint32 {
+	bitrates := make(map[QualityLevel]uint32)
+
+	for _, quality := range []QualityLevel{QualityHigh, QualityMid, QualityLow} {
+		if remoteTrack := t.GetRemoteTrack(quality); remoteTrack != nil {
+			bitrates[quality] = remoteTrack.Bitrate()
+		}
+	}
+
+	return bitrates
+}
+
+// requiredLayer returns the highest simulcast layer that selected by the subscribers, the low layer is always required
+// so a new subscriber can start right away. All layers are required when the track is read by a recorder or a relay.
+func (t *SimulcastTrack) requiredLayer() QualityLevel {
+	t.mu.RLock()
+	hasReaders := len(t.onReadCallbacks) > 0
+	t.mu.RUnlock()
+
+	if hasReaders {
+		return QualityHigh
+	}
+
+	required := QualityLevel(QualityLow)
+
+	for _, track := range t.base.clientTracks.GetTracks() {
+		clientTrack, ok := track.(*simulcastClientTrack)
+		if !ok {
+			return QualityHigh
+		}
+
+		if clientTrack.client.isTrackPaused(clientTrack.ID()) {
+			continue
+		}
+
+		layer, _ := clientTrack.simulcastLayer(clientTrack.targetQuality())
+		required = max(required, layer)
+	}
+
+	return required
+}
+
+// uplinkBudget returns the bitrate that the publisher needs to send the layers up to the layer, each layer uses the
+// measured bitrate with the headroom or the configured bitrate, whichever is higher
+func (t *SimulcastTrack) uplinkBudget(layer QualityLevel, bitrates BitrateConfigs) uint32 {
+	layers := []struct {
+		quality    QualityLevel
+		configured uint32
+	}{
+		{QualityLow, bitrates.VideoLow},
+		{QualityMid, bitrates.VideoMid},
+		{QualityHigh, bitrates.VideoHigh},
+	}
+
+	budget := uint32(0)
+
+	for _, l := range layers {
+		if l.quality > layer {
+			break
+		}
+
+		if remoteTrack := t.GetRemoteTrack(l.quality); remoteTrack != nil {
+			budget += max(withHeadroom(remoteTrack.Bitrate()), l.configured)
+		}
+	}
+
+	return budget
+}
+
+func withHeadroom(bitrate uint32) uint32 {
+	return bitrate + bitrate*uplinkBudgetHeadroom/100
+}
+
+// unusedLayersLimit returns the uplink bitrate that covers the tracks of the client without the simulcast layers that not
+// needed by the subscribers, 0 if all layers are needed. The other tracks are covered by their measured bitrate with
+// the headroom or the configured bitrate, because a REMB caps the whole uplink of the client.
+func (c *Client) unusedLayersLimit() uint32 {
+	if !c.options.CapUnusedLayers || c.SFU() == nil {
+		return 0
+	}
+
+	bitrates := c.SFU().bitrateConfigs
+	capped := false
+	total := uint32(0)
+
+	for _, track := range c.tracks.GetTracks() {
+		if simulcast, ok := track.(*SimulcastTrack); ok {
+			layer := simulcast.requiredLayer()
+			if layer < QualityHigh {
+				capped = true
+			}
+
+			total += simulcast.uplinkBudget(layer, bitrates)
+
+			continue
+		}
+
+		configured := bitrates.Video
+		if track.Kind() == webrtc.RTPCodecTypeAudio {
+			configured = bitrates.Audio
+			if strings.EqualFold(track.MimeType(), "audio/red") {
+				configured = bitrates.AudioRed
+			}
+		}
+
+		for _, remoteTrack := range publishedRemoteTracks(track) {
+			total += max(withHeadroom(remoteTrack.Bitrate()), configured)
+		}
+	}
+
+	if !capped {
+		return 0
+	}
+
+	return total
+}
@@ -0,0 +1,149 @@
+package sfu
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteTrackBitrate(t *testing.T) {
+	track := &remoteTrack{bitrate: &atomic.Uint32{}, currentBytesReceived: &atomic.Uint64{}}
+
+	now := time.Now()
+	track.measureBitrate(0, now)
+	track.measureBitrate(1000, now.Add(500*time.Millisecond))
+	require.Equal(t, uint32(0), track.Bitrate())
+
+	track.measureBitrate(250, now.Add(time.Second))
+	require.Equal(t, uint32(10_000), track.Bitrate())
+
+	// the read deadline is reached without any packet
+	track.measureBitrate(0, now.Add(2*time.Second))
+	require.Equal(t, uint32(0), track.Bitrate())
+}
+
+func TestCapUnusedLayers(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	roomOpts.CapUnusedLayers = true
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	_, client, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer", true, true, true)
+
+	defer func() {
+		_ = testRoom.StopClient(client.ID())
+	}()
+
+	var simulcast *SimulcastTrack
+
+	// wait until all layers are received for a second
+	require.Eventually(t, func() bool {
+		for _, track := range client.Tracks() {
+			if s, ok := track.(*SimulcastTrack); ok {
+				simulcast = s
+			}
+		}
+
+		if simulcast == nil || simulcast.TotalTracks() != 3 {
+			return false
+		}
+
+		for _, bitrate := range simulcast.LayerBitrates() {
+			if bitrate == 0 {
+				return false
+			}
+		}
+
+		return true
+	}, 30*time.Second, 100*time.Millisecond)
+
+	require.True(t, client.isUplinkLimiterRunning.Load())
+
+	// no subscriber, only the low layer is needed
+	require.Equal(t, QualityLevel(QualityLow), simulcast.requiredLayer())
+
+	remb := client.uplinkLimitPacket()
+	require.NotNil(t, remb)
+	require.Less(t, remb.Bitrate, float32(simulcast.uplinkBudget(QualityHigh, testRoom.sfu.bitrateConfigs)))
+	require.Subset(t, remb.SSRCs, []uint32{uint32(simulcast.SSRCHigh()), uint32(simulcast.SSRCMid()), uint32(simulcast.SSRCLow())})
+
+	// the track is read by a recorder, all layers are needed
+	unregister := simulcast.OnRead(func(_ interceptor.Attributes, _ *rtp.Packet, _ QualityLevel) {})
+	require.Equal(t, QualityLevel(QualityHigh), simulcast.requiredLayer())
+	require.Zero(t, client.unusedLayersLimit())
+
+	// the recorder is stopped, only the low layer is needed again
+	unregister()
+	require.Equal(t, QualityLevel(QualityLow), simulcast.requiredLayer())
+}
+
+func TestPauseUnusedLayers(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	roomOpts.PauseUnusedLayers = true
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	_, client, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer", true, true, true)
+
+	defer func() {
+		_ = testRoom.StopClient(client.ID())
+	}()
+
+	var simulcast *SimulcastTrack
+
+	// no subscriber, the mid and high layers are paused after the pause delay
+	require.Eventually(t, func() bool {
+		for _, track := range client.Tracks() {
+			if s, ok := track.(*SimulcastTrack); ok {
+				simulcast = s
+			}
+		}
+
+		if simulcast == nil || simulcast.TotalTracks() != 3 {
+			return false
+		}
+
+		return len(simulcast.PausedLayers()) == 2
+	}, 30*time.Second, 100*time.Millisecond)
+
+	require.Equal(t, []QualityLevel{QualityMid, QualityHigh}, simulcast.PausedLayers())
+
+	// the track is read by a recorder, all layers are resumed without waiting for the pause delay
+	simulcast.OnRead(func(_ interceptor.Attributes, _ *rtp.Packet, _ QualityLevel) {})
+
+	require.Eventually(t, func() bool {
+		return len(simulcast.PausedLayers()) == 0
+	}, simulcastLayerPauseDelay, 50*time.Millisecond)
+}
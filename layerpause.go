@@ -0,0 +1,118 @@
+package sfu
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// the layer is paused once it's not needed by the subscribers for this duration, so a subscriber that switches the
+// layers back and forth doesn't pause and resume the publisher encoder every time. The paused state is also resent
+// to the publisher on this interval in case a message is lost.
+const simulcastLayerPauseDelay = 3 * time.Second
+
+type simulcastLayersPaused struct {
+	TrackID string `json:"track_id"`
+	// PausedRIDs is the RIDs of the layers that the publisher should stop sending, empty when all layers are needed
+	PausedRIDs []string `json:"paused_rids"`
+}
+
+type internalDataSimulcastLayersPaused struct {
+	Type string                `json:"type"`
+	Data simulcastLayersPaused `json:"data"`
+}
+
+// simulcastLayerPauser decides which layers of a simulcast track are paused, it's only used by the layer monitor
+type simulcastLayerPauser struct {
+	unusedSince time.Time
+	lastSent    time.Time
+}
+
+// update pauses the layers above the required layer once they're not needed for the pause delay, and resumes them
+// right away once a subscriber needs them
+func (p *simulcastLayerPauser) update(t *SimulcastTrack, now time.Time) {
+	required := t.requiredLayer()
+	current := t.maxSentLayer()
+
+	switch {
+	case required > current:
+		p.unusedSince = time.Time{}
+		t.setMaxSentLayer(required)
+		p.lastSent = now
+	case required < current:
+		if p.unusedSince.IsZero() {
+			p.unusedSince = now
+			return
+		}
+
+		if now.Sub(p.unusedSince) >= simulcastLayerPauseDelay {
+			p.unusedSince = time.Time{}
+			t.setMaxSentLayer(required)
+			p.lastSent = now
+		}
+	default:
+		p.unusedSince = time.Time{}
+
+		if current < QualityHigh && now.Sub(p.lastSent) >= simulcastLayerPauseDelay {
+			t.sendPausedLayers()
+			p.lastSent = now
+		}
+	}
+}
+
+// maxSentLayer returns the highest layer that the publisher is asked to send, the layers above it are paused
+func (t *SimulcastTrack) maxSentLayer() QualityLevel {
+	if layer := Uint32ToQualityLevel(t.maxLayer.Load()); layer != QualityNone {
+		return layer
+	}
+
+	return QualityHigh
+}
+
+func (t *SimulcastTrack) setMaxSentLayer(layer QualityLevel) {
+	t.maxLayer.Store(uint32(layer))
+
+	if layer < QualityHigh {
+		t.base.client.log.Infof("track: pause the layers of track %s above layer %d, no subscriber needs them", t.base.id, layer)
+	} else {
+		t.base.client.log.Infof("track: resume all layers of track %s", t.base.id)
+	}
+
+	t.sendPausedLayers()
+}
+
+// PausedLayers returns the simulcast layers that the publisher is asked to stop sending because no subscriber needs them,
+// see RoomOptions.PauseUnusedLayers
+func (t *SimulcastTrack) PausedLayers() []QualityLevel {
+	paused := make([]QualityLevel, 0)
+	maxLayer := t.maxSentLayer()
+
+	for _, quality := range []QualityLevel{QualityMid, QualityHigh} {
+		if quality > maxLayer && t.GetRemoteTrack(quality) != nil {
+			paused = append(paused, quality)
+		}
+	}
+
+	return paused
+}
+
+// sendPausedLayers sends the RIDs of the paused layers to the publisher with the simulcast_layers_paused internal message
+func (t *SimulcastTrack) sendPausedLayers() {
+	rids := make([]string, 0)
+
+	for _, quality := range t.PausedLayers() {
+		if remoteTrack := t.GetRemoteTrack(quality); remoteTrack != nil {
+			rids = append(rids, remoteTrack.Track().RID())
+		}
+	}
+
+	data, err := json.Marshal(internalDataSimulcastLayersPaused{
+		Type: messageTypeSimulcastLayersPaused,
+		Data: simulcastLayersPaused{TrackID: t.base.id, PausedRIDs: rids},
+	})
+	if err != nil {
+		t.base.client.log.Errorf("track: error marshal simulcast layers paused ", err)
+		return
+	}
+
+	t.base.publisher().sendInternalMessage(data)
+}
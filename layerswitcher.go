@@ -0,0 +1,75 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// LayerSwitcher rewrites the packets of multiple RTP streams, like the simulcast layers of a track, into one
+// continuous RTP stream for a subscriber that only receives one encoding. Each layer has its own sequence numbers
+// and timestamps, so without the rewrite the decoder sees a jump on every layer switch. See RTPMunger for the rewrite.
+//
+// The switcher only rewrites the headers, the caller decides when to switch the layer and it must be done on a
+// keyframe of the new layer. It's safe to use from multiple goroutines.
+type LayerSwitcher struct {
+	mu      sync.Mutex
+	munger  *RTPMunger
+	started bool
+	layer   QualityLevel
+}
+
+// NewLayerSwitcher returns a layer switcher for the codec clock rate, the clock rate is used to advance the
+// timestamp by the elapsed time when the layer is switched
+func NewLayerSwitcher(clockRate uint32) *LayerSwitcher {
+	return &LayerSwitcher{
+		munger: NewRTPMunger(clockRate),
+	}
+}
+
+// Layer returns the layer of the last rewritten packet, false if no packet is rewritten yet
+func (s *LayerSwitcher) Layer() (QualityLevel, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.layer, s.started
+}
+
+// Rewrite rewrites the sequence number and the timestamp of the packet in place. When the layer is different from
+// the previous packet, the new layer continues right after the last rewritten packet: the sequence number is the
+// next one and the timestamp is advanced by the time since the last packet. It returns false if the packet can't
+// be forwarded, see RTPMunger.Rewrite.
+func (s *LayerSwitcher) Rewrite(layer QualityLevel, p *rtp.Packet) bool {
+	return s.rewrite(layer, p, time.Now())
+}
+
+func (s *LayerSwitcher) rewrite(layer QualityLevel, p *rtp.Packet, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started && layer != s.layer {
+		s.munger.Switch()
+	}
+
+	s.started = true
+	s.layer = layer
+
+	return s.munger.rewrite(p, now)
+}
+
+// Drop removes the packet of the current layer from the stream, like a dropped temporal layer packet,
+// so the receiver doesn't see a gap. The packets of the other layers are ignored.
+func (s *LayerSwitcher) Drop(layer QualityLevel, p *rtp.Packet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started && layer == s.layer {
+		s.munger.Drop(p)
+	}
+}
+
+// Munger returns the munger of the output stream, use it to insert the padding packets
+func (s *LayerSwitcher) Munger() *RTPMunger {
+	return s.munger
+}
@@ -0,0 +1,134 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+var (
+	ErrClientInLobby    = errors.New("client: error the client is waiting in the lobby to be admitted")
+	ErrClientNotInLobby = errors.New("room: error the client is not waiting in the lobby")
+)
+
+// lobbyState is the data of the lobby message that sent to the client
+type lobbyState struct {
+	Admitted bool `json:"admitted"`
+}
+
+type internalDataLobby struct {
+	Type string     `json:"type"`
+	Data lobbyState `json:"data"`
+}
+
+// IsInLobby returns true if the client is waiting in the lobby to be admitted, see RoomOptions.Lobby
+func (c *Client) IsInLobby() bool {
+	return c.inLobby.Load()
+}
+
+// sendLobbyState tells the client whether it's waiting in the lobby or admitted to the room
+func (c *Client) sendLobbyState(admitted bool) {
+	data, err := json.Marshal(internalDataLobby{
+		Type: messageTypeLobby,
+		Data: lobbyState{Admitted: admitted},
+	})
+	if err != nil {
+		c.log.Errorf("client: error marshal lobby state ", err)
+		return
+	}
+
+	c.sendInternalMessage(data)
+}
+
+// Admit lets a client that waiting in the lobby join the room, the tracks of the other clients are available to the client,
+// the published tracks of the client are announced to the other clients, and the OnClientJoined callbacks are called.
+func (r *Room) Admit(clientID string) error {
+	client, err := r.sfu.GetClient(clientID)
+	if err != nil {
+		return err
+	}
+
+	if !client.inLobby.CompareAndSwap(true, false) {
+		return ErrClientNotInLobby
+	}
+
+	client.log.Infof("room: client %s is admitted from the lobby", clientID)
+
+	client.sendLobbyState(true)
+
+	// the client that admitted before connected gets the tracks when connected
+	if client.state.Load() != ClientStateActive {
+		return nil
+	}
+
+	r.onClientJoined(client)
+
+	client.SFU().sendTrackCatalog(client)
+
+	if tracks := client.SFU().availableTracksFor(client); len(tracks) > 0 {
+		client.onTracksAvailable(tracks)
+	}
+
+	if tracks := client.publishedTracksInLobby(); len(tracks) > 0 {
+		client.SFU().onTracksAvailable(clientID, tracks)
+	}
+
+	return nil
+}
+
+// publishedTracksInLobby returns the tracks that the client published while waiting in the lobby,
+// the tracks that still wait for the source type are announced once the source is set
+func (c *Client) publishedTracksInLobby() []ITrack {
+	pending := make(map[string]bool)
+	for _, track := range c.pendingPublishedTracks.GetTracks() {
+		pending[track.ID()] = true
+	}
+
+	tracks := make([]ITrack, 0)
+
+	for _, track := range c.tracks.GetTracks() {
+		if !pending[track.ID()] {
+			tracks = append(tracks, track)
+		}
+	}
+
+	return tracks
+}
+
+// WaitingClients returns the clients that waiting in the lobby to be admitted, sorted by ID
+func (r *Room) WaitingClients() []*Client {
+	clients := make([]*Client, 0)
+
+	for _, client := range r.sfu.GetClients() {
+		if client.IsInLobby() {
+			clients = append(clients, client)
+		}
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID() < clients[j].ID()
+	})
+
+	return clients
+}
+
+// OnClientWaiting event is called when a client is connected and waiting in the lobby to be admitted with Room.Admit,
+// use Room.KickClient to deny it. See RoomOptions.Lobby
+func (r *Room) OnClientWaiting(callback func(client *Client)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onWaitingCallbacks = append(r.onWaitingCallbacks, callback)
+}
+
+func (r *Room) onClientWaiting(client *Client) {
+	r.mu.RLock()
+	callbacks := r.onWaitingCallbacks
+	r.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		callback(client)
+	}
+
+	r.emit(EventRoomClientWaiting, map[string]interface{}{"client_id": client.ID(), "name": client.Name()})
+}
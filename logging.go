@@ -0,0 +1,24 @@
+package sfu
+
+import (
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/logger"
+	"github.com/pion/logging"
+)
+
+// the hot path logs, like the failed packet writes, are logged at most once per interval for each message
+const hotPathLogInterval = 10 * time.Second
+
+var hotPathLogCounters = logger.NewCounters()
+
+// HotPathLogCounters returns the number of the hot path logs by the message format, including the logs that suppressed
+// by the rate limit. It implements expvar.Var, publish it with expvar.Publish to export the counters as metrics.
+func HotPathLogCounters() *logger.Counters {
+	return hotPathLogCounters
+}
+
+// newHotPathLogger returns the logger for the packet path, where a failure can be repeated for every packet
+func newHotPathLogger(log logging.LeveledLogger) logging.LeveledLogger {
+	return logger.NewRateLimited(log, hotPathLogInterval, hotPathLogCounters)
+}
@@ -0,0 +1,94 @@
+package sfu
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/inlivedev/sfu/pkg/logger"
+	"github.com/stretchr/testify/require"
+)
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) find(msg string) map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, line := range strings.Split(b.buf.String(), "\n") {
+		record := map[string]interface{}{}
+		if json.Unmarshal([]byte(line), &record) == nil && record["msg"] == msg {
+			return record
+		}
+	}
+
+	return nil
+}
+
+func TestClientLoggerFields(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	buf := &lockedBuffer{}
+
+	opts := sfuOpts
+	opts.LoggerFactory = logger.NewFactory(slog.NewJSONHandler(buf, nil))
+
+	roomManager := NewManager(ctx, "test", opts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom("room", "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	client, err := testRoom.AddClient("peer", "peer", DefaultClientOptions())
+	require.NoError(t, err)
+
+	defer func() {
+		_ = client.End()
+	}()
+
+	client.log.Infof("test: client log")
+	client.bitrateController.log.Infof("test: bitrate controller log")
+
+	record := buf.find("test: client log")
+	require.NotNil(t, record)
+	require.Equal(t, "sfu", record[logger.ScopeKey])
+	require.Equal(t, "room", record["room_id"])
+	require.Equal(t, "peer", record["client_id"])
+
+	record = buf.find("test: bitrate controller log")
+	require.NotNil(t, record)
+	require.Equal(t, "bitratecontroller", record[logger.ScopeKey])
+	require.Equal(t, "peer", record["client_id"])
+}
+
+func TestHotPathLogger(t *testing.T) {
+	buf := &lockedBuffer{}
+	log := newHotPathLogger(logger.NewFactory(slog.NewJSONHandler(buf, nil)).NewLogger("sfu"))
+
+	before := HotPathLogCounters().Snapshot()["test: hot path %d"]
+
+	for i := 0; i < 10; i++ {
+		log.Warnf("test: hot path %d", i)
+	}
+
+	require.NotNil(t, buf.find("test: hot path 0"))
+	require.Nil(t, buf.find("test: hot path 1"))
+	require.Equal(t, before+10, HotPathLogCounters().Snapshot()["test: hot path %d"])
+}
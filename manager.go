@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 
+	"github.com/inlivedev/sfu/pkg/logger"
 	"github.com/pion/logging"
 	"github.com/pion/webrtc/v4"
 )
@@ -29,12 +31,19 @@ type Manager struct {
 	options    Options
 	extension  []IManagerExtension
 	log        logging.LeveledLogger
+	draining   atomic.Bool
+	fanOut     *fanOutScheduler
+	sinksMu    sync.RWMutex
+	eventSinks []*EventSink
 }
 
 func NewManager(ctx context.Context, name string, options Options) *Manager {
 	localCtx, cancel := context.WithCancel(ctx)
 
-	logger := logging.NewDefaultLoggerFactory().NewLogger("sfu")
+	loggerFactory := options.LoggerFactory
+	if loggerFactory == nil {
+		loggerFactory = logging.NewDefaultLoggerFactory()
+	}
 
 	m := &Manager{
 		rooms:      make(map[string]*Room),
@@ -45,7 +54,11 @@ func NewManager(ctx context.Context, name string, options Options) *Manager {
 		mutex:      sync.RWMutex{},
 		options:    options,
 		extension:  make([]IManagerExtension, 0),
-		log:        logger,
+		log:        loggerFactory.NewLogger("sfu"),
+	}
+
+	if options.FanOutWorkers > 0 {
+		m.fanOut = newFanOutScheduler(localCtx, options.FanOutWorkers)
 	}
 
 	return m
@@ -68,9 +81,25 @@ func (m *Manager) Name() string {
 }
 
 func (m *Manager) NewRoom(id, name, roomType string, opts RoomOptions) (*Room, error) {
+	room, err := m.newRoom(id, name, roomType, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// the event handlers can call the manager, so it's sent without holding the lock
+	room.emit(EventRoomCreated, map[string]interface{}{"name": name, "type": roomType})
+
+	return room, nil
+}
+
+func (m *Manager) newRoom(id, name, roomType string, opts RoomOptions) (*Room, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	if m.draining.Load() {
+		return nil, ErrManagerDraining
+	}
+
 	if _, ok := m.rooms[id]; ok {
 		return nil, ErrRoomAlreadyExists
 	}
@@ -80,18 +109,38 @@ func (m *Manager) NewRoom(id, name, roomType string, opts RoomOptions) (*Room, e
 		return nil, err
 	}
 
+	pliWindow := defaultPLIWindow
+	if opts.PLIWindow != nil {
+		pliWindow = *opts.PLIWindow
+	}
+
 	sfuOpts := sfuOptions{
-		Bitrates:      opts.Bitrates,
-		IceServers:    m.iceServers,
-		Codecs:        *opts.Codecs,
-		PLIInterval:   *opts.PLIInterval,
-		Log:           m.log,
-		SettingEngine: m.options.SettingEngine,
+		Bitrates:       opts.Bitrates,
+		IceServers:     m.iceServers,
+		Codecs:         *opts.Codecs,
+		PLIInterval:    *opts.PLIInterval,
+		PLIWindow:      pliWindow,
+		Log:            logger.With(m.log, "room_id", id),
+		SettingEngine:  m.options.SettingEngine,
+		TracerProvider: m.options.TracerProvider,
+		FanOut:         m.fanOut,
+		ScreenProfile:  opts.ScreenProfile,
+
+		PreferCodecsInOrder: opts.PreferCodecsInOrder,
+		HeaderExtensions:    opts.HeaderExtensions,
+		TrackCatalog:        opts.TrackCatalog,
+	}
+
+	if m.options.ICEServersProvider != nil {
+		sfuOpts.ICEServersProvider = func(clientID string) ([]webrtc.ICEServer, error) {
+			return m.options.ICEServersProvider(id, clientID)
+		}
 	}
 
 	newSFU := New(m.context, sfuOpts)
 
 	room := newRoom(id, name, newSFU, roomType, opts)
+	room.eventSink = EventSinkFunc(m.sendEvent)
 
 	for _, ext := range m.extension {
 		ext.OnNewRoom(m, room)
@@ -111,6 +160,10 @@ func (m *Manager) NewRoom(id, name, roomType string, opts RoomOptions) (*Room, e
 
 	_, emptyRoomCancel = startRoomTimeout(m, room)
 
+	if opts.MaxDuration != nil && *opts.MaxDuration > 0 {
+		go startRoomMaxDuration(m, room, *opts.MaxDuration)
+	}
+
 	idleMutex := sync.Mutex{}
 	room.OnClientLeft(func(client *Client) {
 		idleMutex.Lock()
@@ -154,6 +207,11 @@ func (m *Manager) RoomsCount() int {
 	return len(m.rooms)
 }
 
+// Rooms returns the rooms of the manager sorted by ID
+func (m *Manager) Rooms() []*Room {
+	return m.roomList()
+}
+
 func (m *Manager) GetRoom(id string) (*Room, error) {
 	var (
 		room *Room
@@ -228,6 +286,8 @@ func startRoomTimeout(m *Manager, room *Room) (context.Context, context.CancelFu
 	go func() {
 		<-ctx.Done()
 		if ctx.Err() == context.DeadlineExceeded {
+			room.onClosing(CloseReasonEmpty, 0)
+
 			m.mutex.Lock()
 			defer m.mutex.Unlock()
 			room.Close()
@@ -0,0 +1,523 @@
+package sfu
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/ivfreader"
+)
+
+// the frame duration to continue the timestamp on loop when it can't be estimated from the last frames
+const mediaPlayerDefaultFrameDuration = 20 * time.Millisecond
+
+var (
+	ErrMediaPlayerClosed      = errors.New("mediaplayer: player is closed")
+	ErrMediaFileUnsupported   = errors.New("mediaplayer: file type is not supported, only ivf, ogg, and webm")
+	ErrMediaSourceNotSeekable = errors.New("mediaplayer: source can't be rewound, the reader is not seekable")
+	ErrMediaSourceNoTrack     = errors.New("mediaplayer: no supported track found in the media")
+)
+
+// MediaFrame is an encoded frame of a MediaSource
+type MediaFrame struct {
+	Data []byte
+	// Timestamp is the presentation time of the frame from the start of the media
+	Timestamp time.Duration
+}
+
+// MediaSource is the encoded frames of a track that played by the MediaPlayer, implement it to publish the frames
+// that generated by the application, like a text-to-speech bot.
+type MediaSource interface {
+	// MimeType of the frames, like video/VP8 or audio/opus
+	MimeType() string
+	// ReadFrame returns the next frame, or io.EOF at the end of the media
+	ReadFrame() (MediaFrame, error)
+	// Rewind is called to play the media again from the start when MediaPlayerOptions.Loop is enabled,
+	// return an error if the media can't be played again
+	Rewind() error
+}
+
+type MediaPlayerOptions struct {
+	// StreamID of the published tracks, default is the player client ID
+	StreamID string `json:"stream_id"`
+	// Loop plays the sources again from the start after they're ended. Default is false
+	Loop          bool          `json:"loop"`
+	ClientOptions ClientOptions `json:"client_options"`
+}
+
+func DefaultMediaPlayerOptions() MediaPlayerOptions {
+	return MediaPlayerOptions{
+		ClientOptions: DefaultClientOptions(),
+	}
+}
+
+// MediaPlayer publishes the server generated tracks to a room, like a media player bot that plays a file. The frames
+// are paced by their timestamps, so the sources that started together are played in sync.
+type MediaPlayer struct {
+	mu      sync.Mutex
+	ingest  *RTPIngest
+	options MediaPlayerOptions
+	sources []*mediaPlayerSource
+	playing bool
+	// the number of the sources that still playing
+	active  int
+	done    chan bool
+	closers []io.Closer
+}
+
+type mediaPlayerSource struct {
+	source     MediaSource
+	stream     *rtpIngestStream
+	packetizer *rtpIngestPacketizer
+}
+
+// NewMediaPlayer creates the player client in the room, add the sources and call Play to start publishing the frames.
+// The player is closed when all sources are ended, or when it's closed or the room is closed.
+func (r *Room) NewMediaPlayer(name string, opts MediaPlayerOptions) (*MediaPlayer, error) {
+	ingest, err := r.IngestRTP(name, RTPIngestOptions{StreamID: opts.StreamID, ClientOptions: opts.ClientOptions})
+	if err != nil {
+		return nil, err
+	}
+
+	p := &MediaPlayer{
+		ingest:  ingest,
+		options: opts,
+		sources: make([]*mediaPlayerSource, 0),
+		done:    make(chan bool),
+		closers: make([]io.Closer, 0),
+	}
+
+	go func() {
+		<-ingest.done
+
+		p.mu.Lock()
+		for _, closer := range p.closers {
+			_ = closer.Close()
+		}
+		p.mu.Unlock()
+
+		close(p.done)
+	}()
+
+	return p, nil
+}
+
+// ClientID returns the ID of the player client that publishes the tracks
+func (p *MediaPlayer) ClientID() string {
+	return p.ingest.ClientID()
+}
+
+// Tracks returns the relay tracks of the added sources
+func (p *MediaPlayer) Tracks() []ITrack {
+	return p.ingest.Tracks()
+}
+
+// AddSource publishes the source as a track, one audio and one video source can be added to a player. The source is
+// started immediately if the player is already playing.
+func (p *MediaPlayer) AddSource(source MediaSource) (ITrack, error) {
+	packetizer, err := newRTPIngestPacketizer(source.MimeType())
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := p.ingest.addStream(nil, source.MimeType())
+	if err != nil {
+		return nil, err
+	}
+
+	s := &mediaPlayerSource{
+		source:     source,
+		stream:     stream,
+		packetizer: packetizer,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sources = append(p.sources, s)
+
+	if p.playing {
+		p.playSource(s, time.Now())
+	}
+
+	return stream.track, nil
+}
+
+// AddFile adds the tracks of the ivf, ogg, or webm file by the file extension. The WebM file can have one audio
+// and one video track, the file is closed when the player is closed.
+func (p *MediaPlayer) AddFile(path string) ([]ITrack, error) {
+	kinds := []webrtc.RTPCodecType{0}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ivf", ".ogg", ".opus":
+	case ".webm", ".mkv":
+		kinds = []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio}
+	default:
+		return nil, ErrMediaFileUnsupported
+	}
+
+	tracks := make([]ITrack, 0, len(kinds))
+
+	for _, kind := range kinds {
+		// each track of the WebM file is read separately
+		f, err := os.Open(path)
+		if err != nil {
+			return tracks, err
+		}
+
+		var source MediaSource
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ivf":
+			source, err = NewIVFSource(f)
+		case ".ogg", ".opus":
+			source, err = NewOggSource(f)
+		default:
+			source, err = NewWebMSource(f, kind)
+			// the audio only or video only file
+			if errors.Is(err, ErrMediaSourceNoTrack) {
+				_ = f.Close()
+				continue
+			}
+		}
+
+		if err != nil {
+			_ = f.Close()
+			return tracks, err
+		}
+
+		track, err := p.AddSource(source)
+		if err != nil {
+			_ = f.Close()
+			return tracks, err
+		}
+
+		p.mu.Lock()
+		p.closers = append(p.closers, f)
+		p.mu.Unlock()
+
+		tracks = append(tracks, track)
+	}
+
+	if len(tracks) == 0 {
+		return nil, ErrMediaSourceNoTrack
+	}
+
+	return tracks, nil
+}
+
+// Play starts publishing the frames of all sources at the same time
+func (p *MediaPlayer) Play() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ingest.context.Err() != nil {
+		return ErrMediaPlayerClosed
+	}
+
+	if p.playing {
+		return nil
+	}
+
+	p.playing = true
+
+	start := time.Now()
+	for _, s := range p.sources {
+		p.playSource(s, start)
+	}
+
+	return nil
+}
+
+// Done is closed when the player is closed
+func (p *MediaPlayer) Done() <-chan bool {
+	return p.done
+}
+
+// Close stops playing, ends the relay tracks, and closes the added files
+func (p *MediaPlayer) Close() error {
+	err := p.ingest.Close()
+	<-p.done
+
+	if errors.Is(err, ErrRTPIngestClosed) {
+		return ErrMediaPlayerClosed
+	}
+
+	return err
+}
+
+// playSource must be called with the lock, the player is closed when all sources are ended
+func (p *MediaPlayer) playSource(s *mediaPlayerSource, start time.Time) {
+	p.active++
+
+	go func() {
+		// the files are closed when the player is closed
+		if err := p.play(s, start); err != nil && !errors.Is(err, io.EOF) && p.ingest.context.Err() == nil {
+			p.ingest.log.Errorf("mediaplayer: error play %s: %s", s.source.MimeType(), err.Error())
+		}
+
+		p.mu.Lock()
+		p.active--
+		ended := p.active == 0
+		p.mu.Unlock()
+
+		if ended {
+			p.ingest.cancel()
+		}
+	}()
+}
+
+func (p *MediaPlayer) play(s *mediaPlayerSource, start time.Time) error {
+	var (
+		// the timestamp offset of the looped media
+		offset   time.Duration
+		last     time.Duration
+		duration = mediaPlayerDefaultFrameDuration
+	)
+
+	for {
+		frame, err := s.source.ReadFrame()
+		if errors.Is(err, io.EOF) && p.options.Loop {
+			if err := s.source.Rewind(); err != nil {
+				return err
+			}
+
+			offset = last + duration
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		timestamp := offset + frame.Timestamp
+		if timestamp > last {
+			duration = timestamp - last
+		}
+
+		last = timestamp
+
+		timer := time.NewTimer(time.Until(start.Add(timestamp)))
+
+		select {
+		case <-p.ingest.context.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		rtpTimestamp := uint32(uint64(timestamp) * uint64(s.packetizer.clockRate) / uint64(time.Second))
+
+		for _, packet := range s.packetizer.packetize(rtpTimestamp, frame.Data) {
+			p.ingest.writeRTP(s.stream, packet)
+		}
+	}
+}
+
+// rewindReader seeks the reader to the start, it returns ErrMediaSourceNotSeekable if the reader is not an io.Seeker
+func rewindReader(r io.Reader) error {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return ErrMediaSourceNotSeekable
+	}
+
+	_, err := seeker.Seek(0, io.SeekStart)
+
+	return err
+}
+
+type ivfSource struct {
+	reader   io.Reader
+	ivf      *ivfreader.IVFReader
+	header   *ivfreader.IVFFileHeader
+	mimeType string
+}
+
+// NewIVFSource returns the source of the VP8, VP9, or AV1 frames in the IVF container, the reader must be an io.Seeker
+// to loop the media.
+func NewIVFSource(r io.Reader) (MediaSource, error) {
+	s := &ivfSource{reader: r}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	switch s.header.FourCC {
+	case "VP80":
+		s.mimeType = webrtc.MimeTypeVP8
+	case "VP90":
+		s.mimeType = webrtc.MimeTypeVP9
+	case "AV01":
+		s.mimeType = webrtc.MimeTypeAV1
+	default:
+		return nil, ErrMediaSourceNoTrack
+	}
+
+	return s, nil
+}
+
+func (s *ivfSource) open() error {
+	var err error
+
+	s.ivf, s.header, err = ivfreader.NewWith(s.reader)
+
+	return err
+}
+
+func (s *ivfSource) MimeType() string {
+	return s.mimeType
+}
+
+func (s *ivfSource) ReadFrame() (MediaFrame, error) {
+	data, header, err := s.ivf.ParseNextFrame()
+	if err != nil {
+		return MediaFrame{}, err
+	}
+
+	// the timestamp is in the time base of the file
+	timestamp := time.Duration(header.Timestamp) * time.Second * time.Duration(s.header.TimebaseNumerator) / time.Duration(s.header.TimebaseDenominator)
+
+	return MediaFrame{Data: data, Timestamp: timestamp}, nil
+}
+
+func (s *ivfSource) Rewind() error {
+	if err := rewindReader(s.reader); err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+// oggSource reads the Opus packets from the Ogg pages, unlike the pion oggreader the packets in the same page
+// are returned separately
+type oggSource struct {
+	reader io.Reader
+	buf    *bufio.Reader
+	// the packets of the current page and the packet that continued to the next page
+	packets  [][]byte
+	partial  []byte
+	packetNo int
+	samples  uint64
+}
+
+// NewOggSource returns the source of the Opus packets in the Ogg container, the reader must be an io.Seeker to loop
+// the media.
+func NewOggSource(r io.Reader) (MediaSource, error) {
+	s := &oggSource{reader: r}
+	s.open()
+
+	// the first packet is the OpusHead
+	frame, err := s.readPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(frame) < 8 || string(frame[:8]) != "OpusHead" {
+		return nil, ErrMediaSourceNoTrack
+	}
+
+	return s, nil
+}
+
+func (s *oggSource) open() {
+	s.buf = bufio.NewReader(s.reader)
+	s.packets = nil
+	s.partial = nil
+	s.packetNo = 0
+	s.samples = 0
+}
+
+func (s *oggSource) MimeType() string {
+	return webrtc.MimeTypeOpus
+}
+
+func (s *oggSource) readPacket() ([]byte, error) {
+	for len(s.packets) == 0 {
+		if err := s.readPage(); err != nil {
+			return nil, err
+		}
+	}
+
+	packet := s.packets[0]
+	s.packets = s.packets[1:]
+	s.packetNo++
+
+	return packet, nil
+}
+
+// readPage reads the packets of the next page, RFC 3533 section 6
+func (s *oggSource) readPage() error {
+	header := make([]byte, 27)
+	if _, err := io.ReadFull(s.buf, header); err != nil {
+		return err
+	}
+
+	if string(header[:4]) != "OggS" {
+		return ErrMediaSourceNoTrack
+	}
+
+	segments := make([]byte, header[26])
+	if _, err := io.ReadFull(s.buf, segments); err != nil {
+		return err
+	}
+
+	for _, size := range segments {
+		segment := make([]byte, size)
+		if _, err := io.ReadFull(s.buf, segment); err != nil {
+			return err
+		}
+
+		s.partial = append(s.partial, segment...)
+
+		// the packet is continued to the next segment if the segment size is 255
+		if size < 255 {
+			s.packets = append(s.packets, s.partial)
+			s.partial = nil
+		}
+	}
+
+	return nil
+}
+
+func (s *oggSource) ReadFrame() (MediaFrame, error) {
+	for {
+		packet, err := s.readPacket()
+		if err != nil {
+			return MediaFrame{}, err
+		}
+
+		// the second packet is the OpusTags
+		if s.packetNo == 2 {
+			continue
+		}
+
+		frame := MediaFrame{
+			Data:      packet,
+			Timestamp: time.Duration(s.samples) * time.Second / 48000,
+		}
+
+		s.samples += uint64(opusSamples(packet))
+
+		return frame, nil
+	}
+}
+
+func (s *oggSource) Rewind() error {
+	if err := rewindReader(s.reader); err != nil {
+		return err
+	}
+
+	s.open()
+
+	// skip the OpusHead
+	_, err := s.readPacket()
+
+	return err
+}
@@ -0,0 +1,256 @@
+package sfu
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// testIVF returns a VP8 IVF file with 30fps frames
+func testIVF(frames int) []byte {
+	buf := []byte("DKIF")
+	buf = binary.LittleEndian.AppendUint16(buf, 0)
+	buf = binary.LittleEndian.AppendUint16(buf, 32)
+	buf = append(buf, "VP80"...)
+	buf = binary.LittleEndian.AppendUint16(buf, 640)
+	buf = binary.LittleEndian.AppendUint16(buf, 480)
+	buf = binary.LittleEndian.AppendUint32(buf, 30)
+	buf = binary.LittleEndian.AppendUint32(buf, 1)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(frames))
+	buf = binary.LittleEndian.AppendUint32(buf, 0)
+
+	for i := 0; i < frames; i++ {
+		frame := []byte{0x10, 0x02, 0x00, byte(i)}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(frame)))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(i))
+		buf = append(buf, frame...)
+	}
+
+	return buf
+}
+
+func testOggPage(packets ...[]byte) []byte {
+	page := append([]byte("OggS"), make([]byte, 22)...)
+
+	segments := make([]byte, 0)
+	for _, packet := range packets {
+		segments = append(segments, byte(len(packet)))
+	}
+
+	page = append(page, byte(len(segments)))
+	page = append(page, segments...)
+
+	for _, packet := range packets {
+		page = append(page, packet...)
+	}
+
+	return page
+}
+
+// testOgg returns an Opus Ogg file with 20ms packets, the packets are in the same page
+func testOgg(packets int) []byte {
+	head := append([]byte("OpusHead"), 1, 1, 0, 0, 0x80, 0xbb, 0, 0, 0, 0, 0)
+
+	opus := make([][]byte, 0, packets)
+	for i := 0; i < packets; i++ {
+		opus = append(opus, []byte{0xfc, byte(i)})
+	}
+
+	buf := testOggPage(head)
+	buf = append(buf, testOggPage([]byte("OpusTags"))...)
+
+	return append(buf, testOggPage(opus...)...)
+}
+
+func testEBML(id []byte, data ...[]byte) []byte {
+	payload := bytes.Join(data, nil)
+
+	element := append([]byte{}, id...)
+	element = append(element, 0x08, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(element[len(id):], uint64(len(payload))|uint64(1)<<56)
+
+	return append(element, payload...)
+}
+
+// testWebM returns a WebM file with VP8 and Opus tracks and the unknown size segment and cluster
+func testWebM() []byte {
+	block := func(track byte, timecode int16, payload byte) []byte {
+		return testEBML([]byte{0xa3}, []byte{0x80 | track, byte(timecode >> 8), byte(timecode), 0x80, payload})
+	}
+
+	buf := testEBML([]byte{0x1a, 0x45, 0xdf, 0xa3}, testEBML([]byte{0x42, 0x82}, []byte("webm")))
+	buf = append(buf, 0x18, 0x53, 0x80, 0x67, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	buf = append(buf, testEBML([]byte{0x15, 0x49, 0xa9, 0x66}, testEBML([]byte{0x2a, 0xd7, 0xb1}, []byte{0x0f, 0x42, 0x40}))...)
+	buf = append(buf, testEBML([]byte{0x16, 0x54, 0xae, 0x6b},
+		testEBML([]byte{0xae}, testEBML([]byte{0xd7}, []byte{1}), testEBML([]byte{0x83}, []byte{1}), testEBML([]byte{0x86}, []byte("V_VP8")), testEBML([]byte{0xe0}, testEBML([]byte{0xb0}, []byte{0x02, 0x80}))),
+		testEBML([]byte{0xae}, testEBML([]byte{0xd7}, []byte{2}), testEBML([]byte{0x83}, []byte{2}), testEBML([]byte{0x86}, []byte("A_OPUS"))),
+	)...)
+	buf = append(buf, 0x1f, 0x43, 0xb6, 0x75, 0xff)
+	buf = append(buf, testEBML([]byte{0xe7}, []byte{0x03, 0xe8})...)
+	buf = append(buf, bytes.Join([][]byte{block(1, 0, 0xa0), block(2, 0, 0xb0), block(2, 20, 0xb1), block(1, 33, 0xa1)}, nil)...)
+
+	return buf
+}
+
+func TestWebMSource(t *testing.T) {
+	video, err := NewWebMSource(bytes.NewReader(testWebM()), webrtc.RTPCodecTypeVideo)
+	require.NoError(t, err)
+	require.Equal(t, webrtc.MimeTypeVP8, video.MimeType())
+
+	audio, err := NewWebMSource(bytes.NewReader(testWebM()), webrtc.RTPCodecTypeAudio)
+	require.NoError(t, err)
+	require.Equal(t, webrtc.MimeTypeOpus, audio.MimeType())
+
+	readAll := func(source MediaSource) []MediaFrame {
+		frames := make([]MediaFrame, 0)
+
+		for {
+			frame, err := source.ReadFrame()
+			if err == io.EOF {
+				return frames
+			}
+
+			require.NoError(t, err)
+
+			frames = append(frames, frame)
+		}
+	}
+
+	// the cluster timecode is 1000ms
+	require.Equal(t, []MediaFrame{{Data: []byte{0xa0}, Timestamp: time.Second}, {Data: []byte{0xa1}, Timestamp: 1033 * time.Millisecond}}, readAll(video))
+	require.Equal(t, []MediaFrame{{Data: []byte{0xb0}, Timestamp: time.Second}, {Data: []byte{0xb1}, Timestamp: 1020 * time.Millisecond}}, readAll(audio))
+
+	require.NoError(t, video.Rewind())
+	require.Len(t, readAll(video), 2)
+
+	_, err = NewWebMSource(bytes.NewReader(testIVF(1)), webrtc.RTPCodecTypeVideo)
+	require.Error(t, err)
+
+	ogg, err := NewOggSource(bytes.NewReader(testOgg(3)))
+	require.NoError(t, err)
+
+	// the OpusHead and OpusTags are skipped, and the packets in the same page are read separately
+	require.Equal(t, []MediaFrame{{Data: []byte{0xfc, 0}}, {Data: []byte{0xfc, 1}, Timestamp: 20 * time.Millisecond}, {Data: []byte{0xfc, 2}, Timestamp: 40 * time.Millisecond}}, readAll(ogg))
+
+	// the reader that can't be rewound
+	ogg, err = NewOggSource(io.MultiReader(bytes.NewReader(testOgg(1))))
+	require.NoError(t, err)
+	require.ErrorIs(t, ogg.Rewind(), ErrMediaSourceNotSeekable)
+}
+
+func TestMediaPlayer(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-media-player-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	player, err := testRoom.NewMediaPlayer("bot", DefaultMediaPlayerOptions())
+	require.NoError(t, err)
+
+	_, err = player.AddFile("video.mp4")
+	require.ErrorIs(t, err, ErrMediaFileUnsupported)
+
+	path := filepath.Join(t.TempDir(), "video.ivf")
+	require.NoError(t, os.WriteFile(path, testIVF(4), 0o600))
+
+	tracks, err := player.AddFile(path)
+	require.NoError(t, err)
+	require.Len(t, tracks, 1)
+
+	source, err := NewOggSource(bytes.NewReader(testOgg(3)))
+	require.NoError(t, err)
+
+	audioTrack, err := player.AddSource(source)
+	require.NoError(t, err)
+
+	type readPacket struct {
+		packet rtp.Packet
+		at     time.Time
+	}
+
+	received := make(map[webrtc.RTPCodecType]chan readPacket)
+
+	for _, track := range []ITrack{tracks[0], audioTrack} {
+		packets := make(chan readPacket, 10)
+		received[track.Kind()] = packets
+
+		track.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, _ QualityLevel) {
+			packets <- readPacket{packet: *p.Clone(), at: time.Now()}
+		})
+	}
+
+	start := time.Now()
+	require.NoError(t, player.Play())
+
+	select {
+	case <-player.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the player is ended")
+	}
+
+	video := make([]readPacket, 0, 4)
+	for len(received[webrtc.RTPCodecTypeVideo]) > 0 {
+		video = append(video, <-received[webrtc.RTPCodecTypeVideo])
+	}
+
+	require.Len(t, video, 4)
+	require.Len(t, received[webrtc.RTPCodecTypeAudio], 3)
+
+	// the frames are paced by the timestamps, the 4th frame is at 100ms
+	require.GreaterOrEqual(t, video[3].at.Sub(start), 90*time.Millisecond)
+	require.Equal(t, uint32(9000), video[3].packet.Timestamp-video[0].packet.Timestamp)
+
+	require.ErrorIs(t, player.Close(), ErrMediaPlayerClosed)
+
+	// the looped media continues the timestamp
+	loopOpts := DefaultMediaPlayerOptions()
+	loopOpts.Loop = true
+
+	player, err = testRoom.NewMediaPlayer("looped-bot", loopOpts)
+	require.NoError(t, err)
+
+	source, err = NewOggSource(bytes.NewReader(testOgg(2)))
+	require.NoError(t, err)
+
+	audioTrack, err = player.AddSource(source)
+	require.NoError(t, err)
+
+	timestamps := make(chan uint32, 10)
+	audioTrack.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, _ QualityLevel) {
+		select {
+		case timestamps <- p.Timestamp:
+		default:
+		}
+	})
+
+	require.NoError(t, player.Play())
+
+	first := <-timestamps
+	for i := uint32(1); i < 5; i++ {
+		select {
+		case timestamp := <-timestamps:
+			require.Equal(t, i*960, timestamp-first)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the looped packets")
+		}
+	}
+
+	require.NoError(t, player.Close())
+}
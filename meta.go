@@ -1,14 +1,21 @@
 package sfu
 
 import (
+	"encoding/json"
 	"errors"
 	"sync"
 )
 
 var (
 	ErrMetaNotFound = errors.New("meta: metadata not found")
+
+	ErrMetadataTooLarge    = errors.New("meta: metadata is too large")
+	ErrMetadataInvalidJSON = errors.New("meta: metadata is not a valid JSON")
 )
 
+// the default maximum size of the room and client metadata, it's sent to all clients on every change
+const defaultMaxMetadataSize = 64 * 1024
+
 type Metadata struct {
 	mu                 sync.RWMutex
 	m                  map[string]interface{}
@@ -103,3 +110,56 @@ func (m *Metadata) OnChanged(f func(key string, value interface{})) *OnMetaChang
 
 	return sub
 }
+
+// MetadataChanged is the event when the room or a client metadata is set with SetMetadata
+type MetadataChanged struct {
+	// ClientID is empty for the room metadata
+	ClientID string `json:"client_id,omitempty"`
+	// Version is increased on every change, use it to ignore the older events
+	Version  uint64          `json:"version"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+type internalDataMetadata struct {
+	Type string          `json:"type"`
+	Data MetadataChanged `json:"data"`
+}
+
+// jsonMetadata is a JSON blob of the room or client metadata with a version, different from the Metadata that only used
+// on the server side, it's broadcasted to the clients for the states like the hand raise, the roles or the display names.
+type jsonMetadata struct {
+	mu      sync.RWMutex
+	data    json.RawMessage
+	version uint64
+}
+
+// set replaces the metadata and returns the stored copy with the new version
+func (m *jsonMetadata) set(data []byte, maxSize int) (json.RawMessage, uint64, error) {
+	if len(data) == 0 {
+		data = []byte("null")
+	}
+
+	if len(data) > maxSize {
+		return nil, 0, ErrMetadataTooLarge
+	}
+
+	if !json.Valid(data) {
+		return nil, 0, ErrMetadataInvalidJSON
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data = append(json.RawMessage{}, data...)
+	m.version++
+
+	return m.data, m.version, nil
+}
+
+// get returns the metadata and the version, the version is 0 if the metadata is never set
+func (m *jsonMetadata) get() (json.RawMessage, uint64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.data, m.version
+}
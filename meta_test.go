@@ -1,9 +1,12 @@
 package sfu
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
 	"time"
 
+	"github.com/pion/webrtc/v4"
 	"github.com/stretchr/testify/require"
 )
 
@@ -95,3 +98,100 @@ func TestMetadata(t *testing.T) {
 
 	require.Equal(t, true, state)
 }
+
+func TestRoomMetadata(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	maxSize := 64
+	roomOpts.MaxMetadataSize = &maxSize
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	changed := make(chan MetadataChanged, 10)
+	testRoom.OnMetadataChanged(func(event MetadataChanged) {
+		changed <- event
+	})
+
+	_, err = testRoom.SetMetadata([]byte(`{"topic":`))
+	require.ErrorIs(t, err, ErrMetadataInvalidJSON)
+
+	_, err = testRoom.SetMetadata(make([]byte, maxSize+1))
+	require.ErrorIs(t, err, ErrMetadataTooLarge)
+
+	// set before the client joined, it's sent when the internal data channel is open
+	version, err := testRoom.SetMetadata([]byte(`{"topic":"weekly sync"}`))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), version)
+	require.Equal(t, MetadataChanged{Version: 1, Metadata: json.RawMessage(`{"topic":"weekly sync"}`)}, <-changed)
+
+	messages := make(chan MetadataChanged, 10)
+
+	pc, client, _, connChan := CreateDataPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer1", func(dc *webrtc.DataChannel) {
+		if dc.Label() != "internal" {
+			return
+		}
+
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			data := internalDataMetadata{}
+			if err := json.Unmarshal(msg.Data, &data); err == nil && data.Type == messageTypeMetadataChanged {
+				messages <- data.Data
+			}
+		})
+	})
+
+	defer pc.Close()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-connChan:
+			}
+		}
+	}()
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the room metadata")
+	case msg := <-messages:
+		require.Empty(t, msg.ClientID)
+		require.Equal(t, uint64(1), msg.Version)
+		require.JSONEq(t, `{"topic":"weekly sync"}`, string(msg.Metadata))
+	}
+
+	version, err = client.SetMetadata([]byte(`{"hand_raised":true}`))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), version)
+
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the client metadata")
+	case msg := <-messages:
+		require.Equal(t, client.ID(), msg.ClientID)
+		require.Equal(t, uint64(1), msg.Version)
+		require.JSONEq(t, `{"hand_raised":true}`, string(msg.Metadata))
+	}
+
+	require.Equal(t, client.ID(), (<-changed).ClientID)
+
+	data, version := client.Metadata()
+	require.Equal(t, uint64(1), version)
+	require.JSONEq(t, `{"hand_raised":true}`, string(data))
+
+	_ = testRoom.StopClient(client.ID())
+}
@@ -0,0 +1,348 @@
+package sfu
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	tsPIDPAT     = 0
+
+	tsStreamTypeH264    = 0x1b
+	tsStreamTypePrivate = 0x06
+
+	// the registration descriptor that identifies the Opus stream in a private stream type
+	tsDescriptorRegistration = 0x05
+)
+
+var errTSInvalidPacket = errors.New("mpegts: invalid packet")
+
+// tsElementaryStream is an audio or video stream in the program map table, the PES packet is collected in buf
+// until the next PES packet is started or the PES packet length is reached
+type tsElementaryStream struct {
+	pid        uint16
+	streamType uint8
+	// mimeType is empty if the codec is not supported by WebRTC, like AAC
+	mimeType string
+	buf      []byte
+	size     int
+}
+
+// tsDemuxer reads the MPEG-TS packets from the reader and calls onPES for every complete PES packet of the
+// elementary streams that found in the first program, the demuxer is stopped if onStream returns an error
+type tsDemuxer struct {
+	reader   *bufio.Reader
+	pmtPID   uint16
+	hasPMT   bool
+	streams  map[uint16]*tsElementaryStream
+	onStream func(stream *tsElementaryStream) error
+	onPES    func(stream *tsElementaryStream, pts uint64, data []byte)
+}
+
+func newTSDemuxer(r io.Reader, onStream func(*tsElementaryStream) error, onPES func(*tsElementaryStream, uint64, []byte)) *tsDemuxer {
+	return &tsDemuxer{
+		// the SRT payload is 7 TS packets
+		reader:   bufio.NewReaderSize(r, tsPacketSize*7*16),
+		streams:  make(map[uint16]*tsElementaryStream),
+		onStream: onStream,
+		onPES:    onPES,
+	}
+}
+
+// run reads until the reader or onStream returns an error
+func (d *tsDemuxer) run() error {
+	packet := make([]byte, tsPacketSize)
+
+	for {
+		// resync to the next sync byte if the stream is corrupted
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if b != tsSyncByte {
+			continue
+		}
+
+		packet[0] = b
+		if _, err := io.ReadFull(d.reader, packet[1:]); err != nil {
+			return err
+		}
+
+		// the invalid packets are skipped, the other errors are returned by onStream
+		if err := d.handlePacket(packet); err != nil && !errors.Is(err, errTSInvalidPacket) {
+			return err
+		}
+	}
+}
+
+func (d *tsDemuxer) handlePacket(packet []byte) error {
+	unitStart := packet[1]&0x40 != 0
+	pid := binary.BigEndian.Uint16(packet[1:3]) & 0x1fff
+	adaptation := (packet[3] >> 4) & 0x03
+
+	offset := 4
+	if adaptation&0x02 != 0 {
+		offset += 1 + int(packet[4])
+	}
+
+	if adaptation&0x01 == 0 || offset >= tsPacketSize {
+		return nil
+	}
+
+	payload := packet[offset:]
+
+	switch {
+	case pid == tsPIDPAT:
+		if unitStart {
+			return d.handlePAT(payload)
+		}
+	case d.hasPMT && pid == d.pmtPID:
+		if unitStart {
+			return d.handlePMT(payload)
+		}
+	default:
+		if stream, ok := d.streams[pid]; ok {
+			d.handlePES(stream, unitStart, payload)
+		}
+	}
+
+	return nil
+}
+
+// tsSection returns the section data after the table header without the CRC, the section is expected in a single packet
+func tsSection(payload []byte, tableID byte) ([]byte, error) {
+	if len(payload) < 1 || int(payload[0])+1 >= len(payload) {
+		return nil, errTSInvalidPacket
+	}
+
+	section := payload[1+int(payload[0]):]
+	if len(section) < 8 || section[0] != tableID {
+		return nil, errTSInvalidPacket
+	}
+
+	length := int(binary.BigEndian.Uint16(section[1:3]) & 0x0fff)
+	if length < 9 || 3+length > len(section) {
+		return nil, errTSInvalidPacket
+	}
+
+	return section[8 : 3+length-4], nil
+}
+
+func (d *tsDemuxer) handlePAT(payload []byte) error {
+	data, err := tsSection(payload, 0x00)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i+4 <= len(data); i += 4 {
+		program := binary.BigEndian.Uint16(data[i : i+2])
+		// the program 0 is the network information table
+		if program == 0 {
+			continue
+		}
+
+		d.pmtPID = binary.BigEndian.Uint16(data[i+2:i+4]) & 0x1fff
+		d.hasPMT = true
+
+		return nil
+	}
+
+	return nil
+}
+
+func (d *tsDemuxer) handlePMT(payload []byte) error {
+	data, err := tsSection(payload, 0x02)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < 4 {
+		return errTSInvalidPacket
+	}
+
+	i := 4 + int(binary.BigEndian.Uint16(data[2:4])&0x0fff)
+
+	for i+5 <= len(data) {
+		streamType := data[i]
+		pid := binary.BigEndian.Uint16(data[i+1:i+3]) & 0x1fff
+		infoLength := int(binary.BigEndian.Uint16(data[i+3:i+5]) & 0x0fff)
+
+		if i+5+infoLength > len(data) {
+			return errTSInvalidPacket
+		}
+
+		descriptors := data[i+5 : i+5+infoLength]
+		i += 5 + infoLength
+
+		// the PMT is repeated periodically
+		if _, ok := d.streams[pid]; ok {
+			continue
+		}
+
+		stream := &tsElementaryStream{
+			pid:        pid,
+			streamType: streamType,
+			mimeType:   tsStreamMimeType(streamType, descriptors),
+		}
+
+		d.streams[pid] = stream
+
+		if err := d.onStream(stream); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func tsStreamMimeType(streamType uint8, descriptors []byte) string {
+	switch streamType {
+	case tsStreamTypeH264:
+		return webrtc.MimeTypeH264
+	case tsStreamTypePrivate:
+		for i := 0; i+2 <= len(descriptors); i += 2 + int(descriptors[i+1]) {
+			end := i + 2 + int(descriptors[i+1])
+			if end > len(descriptors) {
+				break
+			}
+
+			if descriptors[i] == tsDescriptorRegistration && bytes.Equal(descriptors[i+2:end], []byte("Opus")) {
+				return webrtc.MimeTypeOpus
+			}
+		}
+	}
+
+	return ""
+}
+
+func (d *tsDemuxer) handlePES(stream *tsElementaryStream, unitStart bool, payload []byte) {
+	if unitStart {
+		d.flushPES(stream)
+
+		stream.buf = append(stream.buf[:0], payload...)
+		stream.size = 0
+
+		if len(payload) >= 6 {
+			if length := int(binary.BigEndian.Uint16(payload[4:6])); length > 0 {
+				stream.size = 6 + length
+			}
+		}
+	} else if len(stream.buf) > 0 {
+		stream.buf = append(stream.buf, payload...)
+	}
+
+	// the audio PES packet usually has the length, so it's not delayed until the next PES packet
+	if stream.size > 0 && len(stream.buf) >= stream.size {
+		stream.buf = stream.buf[:stream.size]
+		d.flushPES(stream)
+	}
+}
+
+func (d *tsDemuxer) flushPES(stream *tsElementaryStream) {
+	data := stream.buf
+	stream.buf = stream.buf[:0]
+
+	if len(data) < 9 || data[0] != 0 || data[1] != 0 || data[2] != 1 {
+		return
+	}
+
+	headerLength := int(data[8])
+	if 9+headerLength > len(data) || data[7]&0x80 == 0 || headerLength < 5 {
+		return
+	}
+
+	d.onPES(stream, tsPTS(data[9:14]), bytes.Clone(data[9+headerLength:]))
+}
+
+// tsPTS returns the 33 bits presentation timestamp in 90kHz
+func tsPTS(b []byte) uint64 {
+	return uint64(b[0]>>1&0x07)<<30 | uint64(b[1])<<22 | uint64(b[2]>>1)<<15 | uint64(b[3])<<7 | uint64(b[4]>>1)
+}
+
+// tsOpusAccessUnits splits the Opus PES payload into the Opus packets, each packet is prefixed with
+// the opus_control_header of ETSI TS 102 366 appendix
+func tsOpusAccessUnits(data []byte) [][]byte {
+	units := make([][]byte, 0, 1)
+
+	for len(data) >= 2 && data[0] == 0x7f && data[1]&0xe0 == 0xe0 {
+		flags := data[1]
+		i := 2
+
+		size := 0
+		for i < len(data) {
+			size += int(data[i])
+			i++
+
+			if data[i-1] != 0xff {
+				break
+			}
+		}
+
+		// start trim and end trim
+		if flags&0x10 != 0 {
+			i += 2
+		}
+
+		if flags&0x08 != 0 {
+			i += 2
+		}
+
+		// control extension
+		if flags&0x04 != 0 && i < len(data) {
+			i += 1 + int(data[i])
+		}
+
+		if i+size > len(data) {
+			break
+		}
+
+		units = append(units, data[i:i+size])
+		data = data[i+size:]
+	}
+
+	return units
+}
+
+// opusSamples returns the number of 48kHz samples in the Opus packet from the TOC byte, RFC 6716 section 3.1
+func opusSamples(packet []byte) uint32 {
+	if len(packet) == 0 {
+		return 0
+	}
+
+	config := packet[0] >> 3
+
+	// the frame duration in 1/400 second
+	var duration uint32
+
+	switch {
+	case config < 12:
+		duration = []uint32{4, 8, 16, 24}[config%4]
+	case config < 16:
+		duration = []uint32{4, 8}[config%2]
+	default:
+		duration = []uint32{1, 2, 4, 8}[config%4]
+	}
+
+	frames := uint32(1)
+
+	switch packet[0] & 0x03 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0
+		}
+
+		frames = uint32(packet[1] & 0x3f)
+	}
+
+	return duration * frames * 120
+}
@@ -0,0 +1,50 @@
+package sfu
+
+import (
+	"errors"
+
+	"github.com/pion/webrtc/v4"
+)
+
+var (
+	ErrExternalSubscribeNotAllowed = errors.New("room: error the client is not allowed to subscribe to the tracks of the room")
+	ErrExternalSubscribeNotReady   = errors.New("client: error the client must be connected to subscribe to the tracks of another room")
+)
+
+// SubscribeRoomTracks subscribes the client to the tracks of another room through the same peer connection, for example
+// a breakout room client that keeps listening to the main stage. The room must allow it with
+// RoomOptions.AuthorizeExternalSubscriber, and the client must be connected.
+// The subscriptions end when the published track ends, the client leaves its room, or the room is closed.
+func (c *Client) SubscribeRoomTracks(room *Room, req []SubscribeTrackRequest) error {
+	if room.sfu == c.SFU() {
+		return c.SubscribeTracks(req)
+	}
+
+	if err := room.authorizeExternalSubscriber(c, req); err != nil {
+		return err
+	}
+
+	if c.peerConnection.PC().ConnectionState() != webrtc.PeerConnectionStateConnected {
+		return ErrExternalSubscribeNotReady
+	}
+
+	if err := c.subscribeSFUTracks(room.sfu, req); err != nil {
+		return err
+	}
+
+	c.log.Infof("client: %s subscribed %d tracks of room %s", c.id, len(req), room.id)
+
+	return nil
+}
+
+func (r *Room) authorizeExternalSubscriber(client *Client, req []SubscribeTrackRequest) error {
+	if r.options.AuthorizeExternalSubscriber == nil {
+		return ErrExternalSubscribeNotAllowed
+	}
+
+	if err := r.options.AuthorizeExternalSubscriber(client, req); err != nil {
+		return errors.Join(ErrExternalSubscribeNotAllowed, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package sfu
+
+import (
+	"encoding/json"
+
+	"github.com/pion/webrtc/v4"
+)
+
+type trackMuted struct {
+	TrackID string `json:"track_id"`
+	Muted   bool   `json:"muted"`
+}
+
+type internalDataTrackMuted struct {
+	Type string     `json:"type"`
+	Data trackMuted `json:"data"`
+}
+
+// MuteClientTrack stops forwarding the packets of a published track to the subscribers without removing the track,
+// the publisher is notified with the track_muted internal message and the OnTrackMutedRemotely callbacks.
+func (r *Room) MuteClientTrack(clientID, trackID string) error {
+	return r.setClientTrackMuted(clientID, trackID, true)
+}
+
+// UnmuteClientTrack resumes forwarding the published track that muted with MuteClientTrack
+func (r *Room) UnmuteClientTrack(clientID, trackID string) error {
+	return r.setClientTrackMuted(clientID, trackID, false)
+}
+
+func (r *Room) setClientTrackMuted(clientID, trackID string, muted bool) error {
+	client, err := r.sfu.GetClient(clientID)
+	if err != nil {
+		return err
+	}
+
+	return client.setTrackMutedRemotely(trackID, muted)
+}
+
+// setTrackMutedRemotely mutes or unmutes a published track, the state is kept if the track is republished with the same ID
+func (c *Client) setTrackMutedRemotely(trackID string, muted bool) error {
+	track, err := c.tracks.Get(trackID)
+	if err != nil {
+		return err
+	}
+
+	if muted {
+		if _, loaded := c.mutedTracks.LoadOrStore(trackID, true); loaded {
+			return nil
+		}
+	} else {
+		if _, loaded := c.mutedTracks.LoadAndDelete(trackID); !loaded {
+			return nil
+		}
+
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			requestKeyframe(track)
+		}
+	}
+
+	c.muCallback.Lock()
+	callbacks := append([]func(string, bool){}, c.onTrackMutedRemotelyCallbacks...)
+	c.muCallback.Unlock()
+
+	for _, callback := range callbacks {
+		callback(trackID, muted)
+	}
+
+	data, err := json.Marshal(internalDataTrackMuted{
+		Type: messageTypeTrackMuted,
+		Data: trackMuted{TrackID: trackID, Muted: muted},
+	})
+	if err != nil {
+		c.log.Errorf("client: error marshal track muted ", err)
+		return nil
+	}
+
+	c.sendInternalMessage(data)
+
+	return nil
+}
+
+// IsTrackMutedRemotely returns true if the published track is muted with Room.MuteClientTrack
+func (c *Client) IsTrackMutedRemotely(trackID string) bool {
+	_, ok := c.mutedTracks.Load(trackID)
+	return ok
+}
+
+// OnTrackMutedRemotely event is called when a published track is muted or unmuted with Room.MuteClientTrack
+// and Room.UnmuteClientTrack
+func (c *Client) OnTrackMutedRemotely(callback func(trackID string, muted bool)) {
+	c.muCallback.Lock()
+	defer c.muCallback.Unlock()
+
+	c.onTrackMutedRemotelyCallbacks = append(c.onTrackMutedRemotelyCallbacks, callback)
+}
+
+// isPublishMuted returns true if the packets of the published track must not be forwarded to the subscribers
+func (c *Client) isPublishMuted(trackID string, kind webrtc.RTPCodecType) bool {
+	return c.IsForceMuted(kind) || c.IsTrackMutedRemotely(trackID)
+}
@@ -0,0 +1,165 @@
+package sfu
+
+import (
+	"errors"
+	"net"
+	"slices"
+
+	"github.com/pion/webrtc/v4"
+)
+
+var ErrInvalidIP = errors.New("network: error invalid IP address")
+
+// NetworkOptions selects the network interfaces and the IP versions that the ICE candidates are gathered from,
+// use it on a dual-stack or a multi-homed host so the clients only get the reachable candidates.
+type NetworkOptions struct {
+	// EnableIPv6 gathers the IPv6 candidates in addition to the IPv4 candidates. Default is false
+	EnableIPv6 bool
+	// DisableIPv4 only gathers the IPv6 candidates, it's ignored if EnableIPv6 is false. Default is false
+	DisableIPv4 bool
+	// Interfaces is the names of the network interfaces to gather the candidates from, like eth0.
+	// Default is empty means all interfaces
+	Interfaces []string
+	// IPs is the local IP addresses to gather the candidates from. Default is empty means all addresses
+	IPs []string
+}
+
+func (o NetworkOptions) includeIPv4() bool {
+	return !o.EnableIPv6 || !o.DisableIPv4
+}
+
+// udpNetworkTypes returns the UDP network types of the enabled IP versions
+func (o NetworkOptions) udpNetworkTypes() []webrtc.NetworkType {
+	networkTypes := make([]webrtc.NetworkType, 0, 2)
+
+	if o.includeIPv4() {
+		networkTypes = append(networkTypes, webrtc.NetworkTypeUDP4)
+	}
+
+	if o.EnableIPv6 {
+		networkTypes = append(networkTypes, webrtc.NetworkTypeUDP6)
+	}
+
+	return networkTypes
+}
+
+func (o NetworkOptions) interfaceFilter() func(string) bool {
+	if len(o.Interfaces) == 0 {
+		return nil
+	}
+
+	return func(name string) bool {
+		return slices.Contains(o.Interfaces, name)
+	}
+}
+
+func (o NetworkOptions) ipFilter() (func(net.IP) bool, error) {
+	ips := make([]net.IP, 0, len(o.IPs))
+
+	for _, addr := range o.IPs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, ErrInvalidIP
+		}
+
+		ips = append(ips, ip)
+	}
+
+	return func(ip net.IP) bool {
+		if (ip.To4() == nil && !o.EnableIPv6) || (ip.To4() != nil && !o.includeIPv4()) {
+			return false
+		}
+
+		if len(ips) == 0 {
+			return true
+		}
+
+		return slices.ContainsFunc(ips, ip.Equal)
+	}, nil
+}
+
+// Configure sets the network types and the interface and IP filters of the setting engine, use it on
+// Options.SettingEngine before creating the manager. Use ICEMuxOptions.Network instead if the ICE mux is used.
+func (o NetworkOptions) Configure(settingEngine *webrtc.SettingEngine) error {
+	ipFilter, err := o.ipFilter()
+	if err != nil {
+		return err
+	}
+
+	settingEngine.SetNetworkTypes(o.udpNetworkTypes())
+	settingEngine.SetIPFilter(ipFilter)
+
+	if filter := o.interfaceFilter(); filter != nil {
+		settingEngine.SetInterfaceFilter(filter)
+	}
+
+	return nil
+}
+
+// CandidatePairStats is the state and the addresses of an ICE candidate pair of the client peer connection
+type CandidatePairStats struct {
+	LocalIP   string `json:"local_ip"`
+	LocalPort int32  `json:"local_port"`
+	// LocalNetwork is the network type of the local candidate, like udp4 or tcp6
+	LocalNetwork        string `json:"local_network"`
+	LocalCandidateType  string `json:"local_candidate_type"`
+	RemoteIP            string `json:"remote_ip"`
+	RemotePort          int32  `json:"remote_port"`
+	RemoteCandidateType string `json:"remote_candidate_type"`
+	Protocol            string `json:"protocol"`
+	State               string `json:"state"`
+	Nominated           bool   `json:"nominated"`
+	// Selected is true if the pair is used to send and receive the media
+	Selected             bool    `json:"selected"`
+	CurrentRoundTripTime float64 `json:"current_round_trip_time"`
+	BytesSent            uint64  `json:"bytes_sent"`
+	BytesReceived        uint64  `json:"bytes_received"`
+}
+
+// CandidatePairStats returns the stats of all candidate pairs of the client, use it to check which network the client
+// is connected through on a dual-stack or a multi-homed host.
+func (c *Client) CandidatePairStats() []CandidatePairStats {
+	report := c.peerConnection.PC().GetStats()
+
+	var selected *webrtc.ICECandidatePair
+	if sctp := c.peerConnection.PC().SCTP(); sctp != nil {
+		selected, _ = sctp.Transport().ICETransport().GetSelectedCandidatePair()
+	}
+
+	pairs := make([]CandidatePairStats, 0)
+
+	for _, s := range report {
+		pair, ok := s.(webrtc.ICECandidatePairStats)
+		if !ok {
+			continue
+		}
+
+		local, _ := report[pair.LocalCandidateID].(webrtc.ICECandidateStats)
+		remote, _ := report[pair.RemoteCandidateID].(webrtc.ICECandidateStats)
+
+		stats := CandidatePairStats{
+			LocalIP:              local.IP,
+			LocalPort:            local.Port,
+			LocalNetwork:         local.NetworkType,
+			LocalCandidateType:   local.CandidateType.String(),
+			RemoteIP:             remote.IP,
+			RemotePort:           remote.Port,
+			RemoteCandidateType:  remote.CandidateType.String(),
+			Protocol:             local.Protocol,
+			State:                string(pair.State),
+			Nominated:            pair.Nominated,
+			CurrentRoundTripTime: pair.CurrentRoundTripTime,
+			BytesSent:            pair.BytesSent,
+			BytesReceived:        pair.BytesReceived,
+		}
+
+		if selected != nil && selected.Local != nil && selected.Remote != nil {
+			stats.Selected = selected.Local.Address == local.IP && int32(selected.Local.Port) == local.Port &&
+				selected.Remote.Address == remote.IP && int32(selected.Remote.Port) == remote.Port
+		}
+
+		pairs = append(pairs, stats)
+	}
+
+	return pairs
+}
@@ -0,0 +1,178 @@
+package sfu
+
+import (
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/networkmonitor"
+)
+
+const networkStatsInterval = time.Second
+
+// NetworkStats is the network condition of the client, the state of each direction changes only after the consecutive
+// samples configured in ClientOptions.NetworkMonitor to avoid flapping
+type NetworkStats struct {
+	// Uplink is the network from the client to the SFU, measured on the published tracks
+	Uplink networkmonitor.Stats `json:"uplink"`
+	// Downlink is the network from the SFU to the client, measured from the receiver reports of the subscribed tracks
+	Downlink networkmonitor.Stats `json:"downlink"`
+	// State is the worse state of the uplink and the downlink
+	State networkmonitor.State `json:"state"`
+}
+
+// networkCounters is the cumulative packet counters of the previous sample, keyed by the track ID and the RID
+type networkCounters struct {
+	received   map[string]uint64
+	lost       map[string]int64
+	sent       map[string]uint64
+	remoteLost map[string]int64
+}
+
+func newNetworkCounters() networkCounters {
+	return networkCounters{
+		received:   make(map[string]uint64),
+		lost:       make(map[string]int64),
+		sent:       make(map[string]uint64),
+		remoteLost: make(map[string]int64),
+	}
+}
+
+// NetworkStats returns the packet loss, jitter, round trip time, and the state of the client network, it's updated every second
+func (c *Client) NetworkStats() NetworkStats {
+	uplink := c.uplinkMonitor.Stats()
+	downlink := c.downlinkMonitor.Stats()
+
+	return NetworkStats{
+		Uplink:   uplink,
+		Downlink: downlink,
+		State:    max(uplink.State, downlink.State),
+	}
+}
+
+// OnNetworkStateChanged event is called when the uplink or the downlink state is changed, see Client.NetworkStats
+func (c *Client) OnNetworkStateChanged(callback func(NetworkStats)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onNetworkStateChanged = callback
+}
+
+func (c *Client) loopNetworkStats() {
+	ticker := time.NewTicker(networkStatsInterval)
+	defer ticker.Stop()
+
+	counters := newNetworkCounters()
+
+	for {
+		select {
+		case <-c.Context().Done():
+			return
+		case <-ticker.C:
+			counters = c.updateNetworkStats(counters)
+		}
+	}
+}
+
+// updateNetworkStats samples the packet counters since the previous sample and updates the network monitors
+func (c *Client) updateNetworkStats(previous networkCounters) networkCounters {
+	counters := newNetworkCounters()
+
+	var rtt time.Duration
+
+	downlink := networkmonitor.Sample{}
+
+	for id, s := range c.stats.Senders() {
+		sent := s.OutboundRTPStreamStats.PacketsSent
+		lost := s.RemoteInboundRTPStreamStats.PacketsLost
+
+		counters.sent[id] = sent
+		counters.remoteLost[id] = lost
+
+		if prevSent, ok := previous.sent[id]; ok && sent >= prevSent {
+			deltaSent := sent - prevSent
+			deltaLost := uint64(max(lost-previous.remoteLost[id], 0))
+
+			downlink.PacketsLost += min(deltaLost, deltaSent)
+			downlink.PacketsReceived += deltaSent - min(deltaLost, deltaSent)
+		}
+
+		downlink.Jitter = max(downlink.Jitter, time.Duration(s.RemoteInboundRTPStreamStats.Jitter*float64(time.Second)))
+		rtt = max(rtt, s.RemoteInboundRTPStreamStats.RoundTripTime)
+	}
+
+	uplink := networkmonitor.Sample{}
+
+	for _, track := range c.tracks.GetTracks() {
+		for _, remoteTrack := range publishedRemoteTracks(track) {
+			s, err := c.stats.GetReceiver(remoteTrack.track.ID(), remoteTrack.track.RID())
+			if err != nil {
+				continue
+			}
+
+			key := remoteTrack.track.ID() + remoteTrack.track.RID()
+			received := s.InboundRTPStreamStats.PacketsReceived
+			lost := s.InboundRTPStreamStats.PacketsLost
+
+			counters.received[key] = received
+			counters.lost[key] = lost
+
+			if prevReceived, ok := previous.received[key]; ok && received >= prevReceived {
+				uplink.PacketsReceived += received - prevReceived
+				uplink.PacketsLost += uint64(max(lost-previous.lost[key], 0))
+			}
+
+			// the inbound jitter is in the RTP timestamp units
+			if clockRate := remoteTrack.track.Codec().ClockRate; clockRate > 0 {
+				jitter := time.Duration(s.InboundRTPStreamStats.Jitter / float64(clockRate) * float64(time.Second))
+				uplink.Jitter = max(uplink.Jitter, jitter)
+			}
+
+			if rtt == 0 {
+				rtt = max(rtt, s.RemoteOutboundRTPStreamStats.RoundTripTime)
+			}
+		}
+	}
+
+	// the round trip time is the same for both directions
+	uplink.RTT = rtt
+	downlink.RTT = rtt
+
+	_, uplinkChanged := c.uplinkMonitor.Update(uplink)
+	_, downlinkChanged := c.downlinkMonitor.Update(downlink)
+
+	if uplinkChanged || downlinkChanged {
+		c.onNetworkStatsChanged(uplinkChanged, downlinkChanged)
+	}
+
+	return counters
+}
+
+func (c *Client) onNetworkStatsChanged(uplinkChanged, downlinkChanged bool) {
+	stats := c.NetworkStats()
+
+	c.log.Infof("client: network state changed uplink %s downlink %s", stats.Uplink.State, stats.Downlink.State)
+
+	// the coarse condition of OnNetworkConditionChanged is normal only on the stable state
+	if uplinkChanged {
+		if stats.Uplink.State == networkmonitor.StateStable {
+			c.onNetworkConditionChanged(networkmonitor.RECEIVENORMAL)
+		} else {
+			c.onNetworkConditionChanged(networkmonitor.RECEIVELOSS)
+		}
+	}
+
+	if downlinkChanged {
+		if stats.Downlink.State == networkmonitor.StateStable {
+			c.onNetworkConditionChanged(networkmonitor.SENDERNORMAL)
+		} else {
+			c.onNetworkConditionChanged(networkmonitor.SENDERLOSS)
+		}
+	}
+
+	c.mu.Lock()
+	callback := c.onNetworkStateChanged
+	c.mu.Unlock()
+
+	if callback != nil {
+		callback(stats)
+	}
+}
@@ -0,0 +1,143 @@
+package sfu
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// OpusOptions is the Opus parameters that negotiated for the audio tracks of a source type, RFC 7587 section 6.1
+type OpusOptions struct {
+	// Stereo negotiates the stereo audio with stereo=1 and sprop-stereo=1
+	Stereo bool `json:"stereo"`
+	// MaxAverageBitrate is the maxaveragebitrate in bits per second, from 6000 to 510000. 0 to use the encoder default,
+	// usually 32kbps for mono and 64kbps for stereo in the browsers.
+	MaxAverageBitrate uint32 `json:"max_average_bitrate"`
+}
+
+var opusRtpmapRegex = regexp.MustCompile(`^a=rtpmap:(\d+) opus/`)
+
+// fmtp appends the options that not exist yet to the fmtp line
+func (o OpusOptions) fmtp(line string) string {
+	if o.Stereo {
+		line = addFmtpParameter(line, "stereo", "1")
+		line = addFmtpParameter(line, "sprop-stereo", "1")
+	}
+
+	if o.MaxAverageBitrate > 0 {
+		line = addFmtpParameter(line, "maxaveragebitrate", strconv.FormatUint(uint64(o.MaxAverageBitrate), 10))
+	}
+
+	return line
+}
+
+func addFmtpParameter(line, key, value string) string {
+	for _, param := range strings.Split(line, ";") {
+		if k, _, _ := strings.Cut(strings.TrimSpace(param), "="); k == key {
+			return line
+		}
+	}
+
+	if line == "" {
+		return key + "=" + value
+	}
+
+	return line + ";" + key + "=" + value
+}
+
+// mergeOpusOptions returns the options for the audio that the source type is not known yet when it's negotiated,
+// which is the published audio because the source type is only set after the negotiation.
+func mergeOpusOptions(options map[TrackType]OpusOptions) OpusOptions {
+	merged := OpusOptions{}
+
+	for _, opts := range options {
+		merged.Stereo = merged.Stereo || opts.Stereo
+		merged.MaxAverageBitrate = max(merged.MaxAverageBitrate, opts.MaxAverageBitrate)
+	}
+
+	return merged
+}
+
+// opusOptionsByMid returns the options of the transceivers that send the subscribed audio tracks by the mid
+func (c *Client) opusOptionsByMid() map[string]OpusOptions {
+	clientTracks := c.ClientTracks()
+	options := make(map[string]OpusOptions)
+
+	for _, transceiver := range c.peerConnection.PC().GetTransceivers() {
+		if transceiver.Kind() != webrtc.RTPCodecTypeAudio || transceiver.Mid() == "" || transceiver.Sender() == nil {
+			continue
+		}
+
+		localTrack := transceiver.Sender().Track()
+		if localTrack == nil {
+			continue
+		}
+
+		clientTrack, ok := clientTracks[localTrack.ID()]
+		if !ok {
+			continue
+		}
+
+		sourceType := TrackType(TrackTypeMedia)
+		if clientTrack.IsScreen() {
+			sourceType = TrackTypeScreen
+		}
+
+		options[transceiver.Mid()] = c.options.OpusOptions[sourceType]
+	}
+
+	return options
+}
+
+// applyOpusOptions updates the Opus fmtp line of every audio media section in the SDP, the section that the mid is not in
+// midOptions uses the defaultOptions.
+func applyOpusOptions(sdp string, midOptions map[string]OpusOptions, defaultOptions OpusOptions) string {
+	lines := strings.Split(sdp, "\r\n")
+
+	// the media section is from the m= line until the next one
+	start := -1
+	for i := 0; i <= len(lines); i++ {
+		if i < len(lines) && !strings.HasPrefix(lines[i], "m=") {
+			continue
+		}
+
+		if start >= 0 && strings.HasPrefix(lines[start], "m=audio") {
+			applyOpusOptionsToSection(lines[start:i], midOptions, defaultOptions)
+		}
+
+		start = i
+	}
+
+	return strings.Join(lines, "\r\n")
+}
+
+func applyOpusOptionsToSection(lines []string, midOptions map[string]OpusOptions, defaultOptions OpusOptions) {
+	opts := defaultOptions
+	payloadType := ""
+
+	for _, line := range lines {
+		if mid, ok := strings.CutPrefix(line, "a=mid:"); ok {
+			if midOpts, ok := midOptions[mid]; ok {
+				opts = midOpts
+			}
+		}
+
+		if match := opusRtpmapRegex.FindStringSubmatch(line); match != nil {
+			payloadType = match[1]
+		}
+	}
+
+	if payloadType == "" {
+		return
+	}
+
+	prefix := "a=fmtp:" + payloadType + " "
+
+	for i, line := range lines {
+		if fmtpLine, ok := strings.CutPrefix(line, prefix); ok {
+			lines[i] = prefix + opts.fmtp(fmtpLine)
+		}
+	}
+}
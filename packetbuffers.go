@@ -23,6 +23,8 @@ type Packet struct {
 }
 
 // buffer ring for cached packets
+//
+// Deprecated: the published tracks are reordered with JitterBuffer, the wait time adapts to the jitter of the track.
 type PacketBuffers struct {
 	context            context.Context
 	init               bool
@@ -64,7 +66,7 @@ func NewPacketBuffers(ctx context.Context, minLatency, maxLatency time.Duration,
 		lastSequenceWaitTime: 0,
 		packetAvailableWait:  sync.NewCond(&sync.Mutex{}),
 		enableDynamicLatency: dynamicLatency,
-		log:                  log,
+		log:                  newHotPathLogger(log),
 	}
 
 	go func() {
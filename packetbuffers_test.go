@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/inlivedev/sfu/pkg/rtppool"
+	"github.com/pion/interceptor"
 	"github.com/pion/logging"
 	"github.com/pion/rtp"
 	"github.com/stretchr/testify/require"
@@ -377,3 +378,69 @@ func BenchmarkPopPool(b *testing.B) {
 	}
 
 }
+
+func TestJitterBuffer(t *testing.T) {
+	t.Parallel()
+
+	minDelay := 20 * time.Millisecond
+	maxDelay := 100 * time.Millisecond
+
+	buffer := NewJitterBuffer(90000, minDelay, maxDelay)
+
+	forwarded := make([]uint16, 0)
+	forward := func(_ interceptor.Attributes, p *rtp.Packet) {
+		forwarded = append(forwarded, p.SequenceNumber)
+	}
+
+	now := time.Now()
+	push := func(seq uint16, at time.Duration) {
+		p := &rtp.Packet{Header: rtp.Header{SequenceNumber: seq, Timestamp: uint32(seq) * 90}}
+		buffer.push(nil, p, now.Add(at), forward)
+	}
+
+	// the in order packets are forwarded right away, the sequence numbers wrap around
+	push(65534, 0)
+	push(65535, time.Millisecond)
+	push(0, 2*time.Millisecond)
+	require.Equal(t, []uint16{65534, 65535, 0}, forwarded)
+
+	// the reordered packet is waited
+	push(2, 3*time.Millisecond)
+	require.Equal(t, 1, buffer.Len())
+	push(1, 4*time.Millisecond)
+	require.Equal(t, []uint16{65534, 65535, 0, 1, 2}, forwarded)
+	require.Equal(t, 0, buffer.Len())
+
+	// the missing packet is skipped after the target delay
+	push(4, 5*time.Millisecond)
+	push(5, 6*time.Millisecond)
+	push(5, 7*time.Millisecond)
+
+	deadline, ok := buffer.Deadline()
+	require.True(t, ok)
+	require.Equal(t, now.Add(5*time.Millisecond).Add(buffer.Stats().TargetDelay), deadline)
+
+	buffer.expireAt(deadline.Add(-time.Millisecond), forward)
+	require.Len(t, forwarded, 5)
+
+	buffer.expireAt(deadline, forward)
+	require.Equal(t, []uint16{65534, 65535, 0, 1, 2, 4, 5}, forwarded)
+
+	// the skipped packet arrives too late
+	push(3, 40*time.Millisecond)
+	require.Len(t, forwarded, 7)
+
+	stats := buffer.Stats()
+	require.Equal(t, uint64(1), stats.Late)
+	require.Equal(t, uint64(2), stats.Dropped)
+	require.Equal(t, uint64(1), stats.Lost)
+
+	// the target delay follows the jitter but it's never above the max delay
+	for i := uint16(0); i < 100; i++ {
+		push(6+i, 40*time.Millisecond+time.Duration(i)*time.Millisecond+time.Duration(i%2)*30*time.Millisecond)
+	}
+
+	stats = buffer.Stats()
+	require.Greater(t, stats.Jitter, time.Duration(0))
+	require.Equal(t, maxDelay, stats.TargetDelay)
+}
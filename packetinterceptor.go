@@ -0,0 +1,151 @@
+package sfu
+
+import (
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+type PacketDirection int
+
+const (
+	// PacketIngress is the packet that received from the publisher, before it's forwarded to the subscribers
+	PacketIngress PacketDirection = iota
+	// PacketEgress is the packet that will be sent to a subscriber, after the layer selection and the header rewrite
+	PacketEgress
+)
+
+func (d PacketDirection) String() string {
+	switch d {
+	case PacketIngress:
+		return "ingress"
+	case PacketEgress:
+		return "egress"
+	default:
+		return "unknown"
+	}
+}
+
+// PacketInfo describes the packet that passed to the packet interceptor
+type PacketInfo struct {
+	Direction   PacketDirection
+	TrackID     string
+	StreamID    string
+	PublisherID string
+	Kind        webrtc.RTPCodecType
+	MimeType    string
+	// Quality is the simulcast layer of the ingress packet, or the forwarded quality of the egress packet
+	Quality QualityLevel
+	// Subscriber is the client that will receive the egress packet, nil on the ingress
+	Subscriber *Client
+}
+
+// PacketInterceptor is called for every RTP packet of the track, the packet can be modified in place and it's
+// dropped if the interceptor returns false. The packet is from the pool and must not be used after the interceptor
+// returns, clone it if it's needed later. The interceptor is called from the track read loop, it must not block.
+type PacketInterceptor func(info PacketInfo, p *rtp.Packet) bool
+
+// packetInterceptors is the ordered chain of the interceptors for each direction
+type packetInterceptors struct {
+	mu      sync.RWMutex
+	ingress []PacketInterceptor
+	egress  []PacketInterceptor
+}
+
+func newPacketInterceptors() *packetInterceptors {
+	return &packetInterceptors{
+		ingress: make([]PacketInterceptor, 0),
+		egress:  make([]PacketInterceptor, 0),
+	}
+}
+
+func (i *packetInterceptors) add(direction PacketDirection, interceptor PacketInterceptor) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if direction == PacketEgress {
+		i.egress = append(i.egress, interceptor)
+	} else {
+		i.ingress = append(i.ingress, interceptor)
+	}
+}
+
+// run calls the interceptors in the order they're added, it stops and returns false once the packet is dropped
+func (i *packetInterceptors) run(info PacketInfo, p *rtp.Packet) bool {
+	i.mu.RLock()
+	interceptors := i.ingress
+	if info.Direction == PacketEgress {
+		interceptors = i.egress
+	}
+	i.mu.RUnlock()
+
+	for _, interceptor := range interceptors {
+		if !interceptor(info, p) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (i *packetInterceptors) isEmpty(direction PacketDirection) bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if direction == PacketEgress {
+		return len(i.egress) == 0
+	}
+
+	return len(i.ingress) == 0
+}
+
+func (t *baseTrack) roomInterceptors() *packetInterceptors {
+	if t.client.SFU() == nil {
+		return nil
+	}
+
+	return t.client.SFU().packetInterceptors
+}
+
+// hasInterceptors returns true if the room or the track has an interceptor for the direction
+func (t *baseTrack) hasInterceptors(direction PacketDirection) bool {
+	room := t.roomInterceptors()
+
+	return (room != nil && !room.isEmpty(direction)) || (t.interceptors != nil && !t.interceptors.isEmpty(direction))
+}
+
+// intercept runs the room interceptors and then the track interceptors, it returns false if the packet is dropped
+func (t *baseTrack) intercept(direction PacketDirection, subscriber *Client, quality QualityLevel, p *rtp.Packet) bool {
+	room := t.roomInterceptors()
+
+	hasRoom := room != nil && !room.isEmpty(direction)
+	hasTrack := t.interceptors != nil && !t.interceptors.isEmpty(direction)
+
+	if !hasRoom && !hasTrack {
+		return true
+	}
+
+	info := PacketInfo{
+		Direction:   direction,
+		TrackID:     t.id,
+		StreamID:    t.streamid,
+		PublisherID: t.client.id,
+		Kind:        t.kind,
+		MimeType:    t.codec.MimeType,
+		Quality:     quality,
+		Subscriber:  subscriber,
+	}
+
+	if hasRoom && !room.run(info, p) {
+		return false
+	}
+
+	return !hasTrack || t.interceptors.run(info, p)
+}
+
+// AddPacketInterceptor adds the interceptor to the end of the chain of the direction for all tracks in the room.
+// The room interceptors are called before the interceptors that added to the track.
+func (r *Room) AddPacketInterceptor(direction PacketDirection, interceptor PacketInterceptor) {
+	r.sfu.packetInterceptors.add(direction, interceptor)
+}
@@ -0,0 +1,79 @@
+// Package admin serves the health checks, the pprof profiles, and the debug dump of the SFU on a separate HTTP mux.
+// It's opt-in, import it only if the admin endpoints are needed, and serve the mux on an internal port.
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/inlivedev/sfu"
+)
+
+type Options struct {
+	// StuckTrackTimeout is how long a track read loop can be blocked before the liveness check fails. Default is 10 seconds
+	StuckTrackTimeout time.Duration
+	// EnablePprof serves the net/http/pprof profiles under /debug/pprof/
+	EnablePprof bool
+	// EnableDebug serves the sfu.DebugHandler dump under /debug/sfu
+	EnableDebug bool
+}
+
+func DefaultOptions() Options {
+	return Options{
+		StuckTrackTimeout: 10 * time.Second,
+		EnablePprof:       true,
+		EnableDebug:       true,
+	}
+}
+
+// NewMux returns the admin mux with these endpoints:
+//   - /healthz/live responds 503 if a track read loop is stuck, restart the process because the stuck track is not recovered
+//   - /healthz/ready responds 503 if the manager is draining or closed
+//   - /debug/pprof/ the pprof profiles if EnablePprof
+//   - /debug/sfu the dump of the rooms if EnableDebug
+//
+// Both health endpoints respond with the sfu.HealthStatus as JSON.
+func NewMux(manager *sfu.Manager, opts Options) *http.ServeMux {
+	if opts.StuckTrackTimeout <= 0 {
+		opts.StuckTrackTimeout = DefaultOptions().StuckTrackTimeout
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz/live", func(w http.ResponseWriter, r *http.Request) {
+		health := manager.Health(opts.StuckTrackTimeout)
+		writeHealth(w, health, len(health.StuckTracks) == 0)
+	})
+
+	mux.HandleFunc("/healthz/ready", func(w http.ResponseWriter, r *http.Request) {
+		health := manager.Health(opts.StuckTrackTimeout)
+		writeHealth(w, health, health.Ready)
+	})
+
+	if opts.EnablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	if opts.EnableDebug {
+		mux.Handle("/debug/sfu", sfu.NewDebugHandler(manager))
+	}
+
+	return mux
+}
+
+func writeHealth(w http.ResponseWriter, health sfu.HealthStatus, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	_ = json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,83 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/inlivedev/sfu"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMux(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := sfu.NewManager(ctx, "test", sfu.DefaultOptions())
+	defer roomManager.Close()
+
+	_, err := roomManager.NewRoom("room", "test-room", sfu.RoomTypeLocal, sfu.DefaultRoomOptions())
+	require.NoError(t, err)
+
+	server := httptest.NewServer(NewMux(roomManager, DefaultOptions()))
+	defer server.Close()
+
+	get := func(path string) (int, sfu.HealthStatus) {
+		res, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+
+		defer res.Body.Close()
+
+		health := sfu.HealthStatus{}
+		if strings.HasPrefix(path, "/healthz/") {
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&health))
+		}
+
+		return res.StatusCode, health
+	}
+
+	status, health := get("/healthz/live")
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, health.StuckTracks)
+
+	status, health = get("/healthz/ready")
+	require.Equal(t, http.StatusOK, status)
+	require.True(t, health.Ready)
+	require.Equal(t, 1, health.Rooms)
+	require.Positive(t, health.Goroutines)
+
+	status, _ = get("/debug/pprof/")
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = get("/debug/sfu")
+	require.Equal(t, http.StatusOK, status)
+
+	// the manager is closed once all rooms are drained
+	require.NoError(t, roomManager.Drain(ctx, ""))
+
+	status, health = get("/healthz/ready")
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.False(t, health.Ready)
+}
+
+func TestMuxDisabledEndpoints(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := sfu.NewManager(ctx, "test", sfu.DefaultOptions())
+	defer roomManager.Close()
+
+	server := httptest.NewServer(NewMux(roomManager, Options{}))
+	defer server.Close()
+
+	for _, path := range []string{"/debug/pprof/", "/debug/sfu"} {
+		res, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		res.Body.Close()
+
+		require.Equal(t, http.StatusNotFound, res.StatusCode, path)
+	}
+}
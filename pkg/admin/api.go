@@ -0,0 +1,403 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/inlivedev/sfu"
+)
+
+// limit the size of the request body
+const maxRequestSize = 1 << 20
+
+var (
+	ErrInvalidAPIKey    = errors.New("admin: error invalid API key")
+	ErrInvalidRoomType  = errors.New("admin: error invalid room type")
+	ErrNotFound         = errors.New("admin: error not found")
+	ErrMethodNotAllowed = errors.New("admin: error method not allowed")
+)
+
+type APIOptions struct {
+	// APIKeys are the keys that accepted in the Authorization: Bearer header or the X-API-Key header, all requests
+	// are rejected if it's empty
+	APIKeys []string
+	// RoomOptions returns the options of the room that created with POST /rooms, return an error to reject the
+	// request. Default is nil means sfu.DefaultRoomOptions with the max clients and the empty room timeout of the request
+	RoomOptions func(req CreateRoomRequest) (sfu.RoomOptions, error)
+	// RecordingOptions returns the options of the recording that started with POST /rooms/{roomID}/recording.
+	// Default is nil means sfu.DefaultRecordingOptions
+	RecordingOptions func(room *sfu.Room) sfu.RecordingOptions
+}
+
+func DefaultAPIOptions() APIOptions {
+	return APIOptions{}
+}
+
+// CreateRoomRequest is the body of POST /rooms, the ID is generated if it's empty
+type CreateRoomRequest struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Type is local or remote, default is local
+	Type       string `json:"type,omitempty" enums:"local,remote"`
+	MaxClients int    `json:"max_clients,omitempty"`
+	// EmptyRoomTimeout closes the room when it's empty for the duration in nanoseconds, default is the room default
+	EmptyRoomTimeout time.Duration `json:"empty_room_timeout_ns,omitempty"`
+	// Metadata is the initial room metadata, see sfu.Room.SetMetadata
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// UpdateRoomRequest is the body of PATCH /rooms/{roomID}
+type UpdateRoomRequest struct {
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+// Room is the room in the responses of the API
+type Room struct {
+	ID           string          `json:"id"`
+	Name         string          `json:"name"`
+	Type         string          `json:"type" enums:"local,remote"`
+	State        string          `json:"state" enums:"open,closed"`
+	ClientsCount int             `json:"clients_count"`
+	Recording    bool            `json:"recording"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+}
+
+// Recording is the response of the recording endpoints
+type Recording struct {
+	RoomID    string              `json:"room_id"`
+	StartTime time.Time           `json:"start_time"`
+	Duration  time.Duration       `json:"duration_ns"`
+	Tracks    []sfu.RecordedTrack `json:"tracks"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// RequireAPIKey rejects the requests without one of the API keys in the Authorization: Bearer header or the
+// X-API-Key header with 401, the keys are compared in constant time
+func RequireAPIKey(keys []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			key = bearer
+		}
+
+		valid := false
+
+		for _, k := range keys {
+			if key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+				valid = true
+			}
+		}
+
+		if !valid {
+			writeError(w, http.StatusUnauthorized, ErrInvalidAPIKey)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewAPIHandler returns the REST admin API that protected with RequireAPIKey, mount it with http.StripPrefix:
+//
+//	mux.Handle("/api/", http.StripPrefix("/api", admin.NewAPIHandler(manager, opts)))
+//
+// The endpoints are:
+//   - GET /rooms lists the rooms, POST /rooms creates a room with CreateRoomRequest
+//   - GET /rooms/{roomID} gets a room, PATCH /rooms/{roomID} sets the metadata, DELETE /rooms/{roomID} closes the room
+//   - GET /rooms/{roomID}/clients lists the clients with their tracks and subscriptions as sfu.RoomClientState
+//   - DELETE /rooms/{roomID}/clients/{clientID}?reason=&ban=true disconnects the client, banned if ban is true
+//   - GET, POST, and DELETE /rooms/{roomID}/recording gets, starts, and stops the recording
+//
+// The errors are responded as JSON with the error message.
+func NewAPIHandler(manager *sfu.Manager, opts APIOptions) http.Handler {
+	api := &apiHandler{
+		manager: manager,
+		opts:    opts,
+	}
+
+	return RequireAPIKey(opts.APIKeys, api)
+}
+
+type apiHandler struct {
+	manager *sfu.Manager
+	opts    APIOptions
+}
+
+func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "rooms" {
+		writeError(w, http.StatusNotFound, ErrNotFound)
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			h.listRooms(w)
+		case http.MethodPost:
+			h.createRoom(w, r)
+		default:
+			writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+
+		return
+	}
+
+	room, err := h.manager.GetRoom(parts[1])
+	if err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+
+	switch {
+	case len(parts) == 2:
+		h.serveRoom(w, r, room)
+	case len(parts) == 3 && parts[2] == "clients":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, room.State().Clients)
+	case len(parts) == 4 && parts[2] == "clients":
+		if r.Method != http.MethodDelete {
+			writeMethodNotAllowed(w, http.MethodDelete)
+			return
+		}
+
+		h.disconnectClient(w, r, room, parts[3])
+	case len(parts) == 3 && parts[2] == "recording":
+		h.serveRecording(w, r, room)
+	default:
+		writeError(w, http.StatusNotFound, ErrNotFound)
+	}
+}
+
+func (h *apiHandler) listRooms(w http.ResponseWriter) {
+	rooms := make([]Room, 0)
+	for _, room := range h.manager.Rooms() {
+		rooms = append(rooms, newRoom(room))
+	}
+
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+func (h *apiHandler) createRoom(w http.ResponseWriter, r *http.Request) {
+	req := CreateRoomRequest{}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if req.Type == "" {
+		req.Type = sfu.RoomTypeLocal
+	}
+
+	if req.Type != sfu.RoomTypeLocal && req.Type != sfu.RoomTypeRemote {
+		writeError(w, http.StatusBadRequest, ErrInvalidRoomType)
+		return
+	}
+
+	opts := sfu.DefaultRoomOptions()
+	opts.MaxClients = req.MaxClients
+
+	if req.EmptyRoomTimeout > 0 {
+		opts.EmptyRoomTimeout = &req.EmptyRoomTimeout
+	}
+
+	if h.opts.RoomOptions != nil {
+		var err error
+		if opts, err = h.opts.RoomOptions(req); err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+	}
+
+	if req.ID == "" {
+		req.ID = h.manager.CreateRoomID()
+	}
+
+	if req.Name == "" {
+		req.Name = req.ID
+	}
+
+	room, err := h.manager.NewRoom(req.ID, req.Name, req.Type, opts)
+	if err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+
+	if len(req.Metadata) > 0 {
+		if _, err := room.SetMetadata(req.Metadata); err != nil {
+			_ = room.Close()
+			writeError(w, statusCode(err), err)
+
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusCreated, newRoom(room))
+}
+
+func (h *apiHandler) serveRoom(w http.ResponseWriter, r *http.Request, room *sfu.Room) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, newRoom(room))
+	case http.MethodPatch:
+		req := UpdateRoomRequest{}
+		if err := readJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if _, err := room.SetMetadata(req.Metadata); err != nil {
+			writeError(w, statusCode(err), err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newRoom(room))
+	case http.MethodDelete:
+		if err := h.manager.CloseRoom(room.ID()); err != nil {
+			writeError(w, statusCode(err), err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPatch, http.MethodDelete)
+	}
+}
+
+func (h *apiHandler) disconnectClient(w http.ResponseWriter, r *http.Request, room *sfu.Room, clientID string) {
+	reason := r.URL.Query().Get("reason")
+
+	var err error
+
+	if ban, _ := strconv.ParseBool(r.URL.Query().Get("ban")); ban {
+		err = room.BanClient(clientID, reason)
+	} else {
+		err = room.KickClient(clientID, reason)
+	}
+
+	if err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *apiHandler) serveRecording(w http.ResponseWriter, r *http.Request, room *sfu.Room) {
+	switch r.Method {
+	case http.MethodGet:
+		recorder := room.Recorder()
+		if recorder == nil {
+			writeError(w, http.StatusNotFound, sfu.ErrRecordingNotStarted)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newRecording(room, recorder))
+	case http.MethodPost:
+		opts := sfu.DefaultRecordingOptions()
+		if h.opts.RecordingOptions != nil {
+			opts = h.opts.RecordingOptions(room)
+		}
+
+		recorder, err := room.StartRecording(opts)
+		if err != nil {
+			writeError(w, statusCode(err), err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, newRecording(room, recorder))
+	case http.MethodDelete:
+		recorder := room.Recorder()
+		if recorder == nil {
+			writeError(w, http.StatusConflict, sfu.ErrRecordingNotStarted)
+			return
+		}
+
+		if err := room.StopRecording(); err != nil {
+			writeError(w, statusCode(err), err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newRecording(room, recorder))
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+}
+
+func newRoom(room *sfu.Room) Room {
+	state := room.State()
+	metadata, _ := room.Metadata()
+
+	return Room{
+		ID:           room.ID(),
+		Name:         room.Name(),
+		Type:         room.Kind(),
+		State:        state.State,
+		ClientsCount: len(state.Clients),
+		Recording:    room.Recorder() != nil,
+		Metadata:     metadata,
+	}
+}
+
+func newRecording(room *sfu.Room, recorder *sfu.Recorder) Recording {
+	return Recording{
+		RoomID:    room.ID(),
+		StartTime: recorder.StartTime(),
+		Duration:  recorder.Duration(),
+		Tracks:    recorder.RecordedTracks(),
+	}
+}
+
+// statusCode returns the HTTP status of the SFU error
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, sfu.ErrRoomNotFound), errors.Is(err, sfu.ErrClientNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, sfu.ErrRoomAlreadyExists), errors.Is(err, sfu.ErrRecordingAlreadyStarted),
+		errors.Is(err, sfu.ErrRecordingNotStarted), errors.Is(err, sfu.ErrE2EENotSupported), errors.Is(err, sfu.ErrRoomIsClosed):
+		return http.StatusConflict
+	case errors.Is(err, sfu.ErrMetadataTooLarge), errors.Is(err, sfu.ErrMetadataInvalidJSON):
+		return http.StatusBadRequest
+	case errors.Is(err, sfu.ErrManagerDraining):
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+}
@@ -0,0 +1,127 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inlivedev/sfu"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := sfu.NewManager(ctx, "test", sfu.DefaultOptions())
+	defer roomManager.Close()
+
+	opts := DefaultAPIOptions()
+	opts.APIKeys = []string{"secret"}
+	opts.RecordingOptions = func(room *sfu.Room) sfu.RecordingOptions {
+		return sfu.RecordingOptions{Directory: t.TempDir()}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", http.StripPrefix("/api", NewAPIHandler(roomManager, opts)))
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	request := func(method, path, key, body string, v interface{}) int {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		defer res.Body.Close()
+
+		data, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+
+		if v != nil {
+			require.NoError(t, json.Unmarshal(data, v), string(data))
+		}
+
+		return res.StatusCode
+	}
+
+	require.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/api/rooms", "", "", nil))
+	require.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/api/rooms", "wrong", "", nil))
+
+	// rooms
+	room := Room{}
+	status := request(http.MethodPost, "/api/rooms", "secret", `{"id":"room-1","name":"Room 1","max_clients":2,"metadata":{"topic":"test"}}`, &room)
+	require.Equal(t, http.StatusCreated, status)
+	require.Equal(t, "room-1", room.ID)
+	require.Equal(t, "Room 1", room.Name)
+	require.Equal(t, sfu.RoomTypeLocal, room.Type)
+	require.JSONEq(t, `{"topic":"test"}`, string(room.Metadata))
+
+	require.Equal(t, http.StatusConflict, request(http.MethodPost, "/api/rooms", "secret", `{"id":"room-1"}`, nil))
+	require.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/rooms", "secret", `{"type":"unknown"}`, nil))
+	require.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/rooms", "secret", `{"unknown":true}`, nil))
+
+	rooms := []Room{}
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/api/rooms", "secret", "", &rooms))
+	require.Len(t, rooms, 1)
+
+	require.Equal(t, http.StatusOK, request(http.MethodPatch, "/api/rooms/room-1", "secret", `{"metadata":{"topic":"updated"}}`, &room))
+	require.JSONEq(t, `{"topic":"updated"}`, string(room.Metadata))
+
+	require.Equal(t, http.StatusNotFound, request(http.MethodGet, "/api/rooms/unknown", "secret", "", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, request(http.MethodPut, "/api/rooms/room-1", "secret", "", nil))
+
+	// clients
+	sfuRoom, err := roomManager.GetRoom("room-1")
+	require.NoError(t, err)
+
+	_, err = sfuRoom.AddClient("alice", "Alice", sfu.DefaultClientOptions())
+	require.NoError(t, err)
+
+	clients := []sfu.RoomClientState{}
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/api/rooms/room-1/clients", "secret", "", &clients))
+	require.Len(t, clients, 1)
+	require.Equal(t, "alice", clients[0].ID)
+
+	require.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/api/rooms/room-1/clients/bob", "secret", "", nil))
+	require.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/api/rooms/room-1/clients/alice?reason=test&ban=true", "secret", "", nil))
+
+	banned, err := sfuRoom.BanList().IsBanned("room-1", "alice")
+	require.NoError(t, err)
+	require.True(t, banned)
+
+	require.Eventually(t, func() bool {
+		return request(http.MethodGet, "/api/rooms/room-1/clients", "secret", "", &clients) == http.StatusOK && len(clients) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// recording
+	recording := Recording{}
+	require.Equal(t, http.StatusNotFound, request(http.MethodGet, "/api/rooms/room-1/recording", "secret", "", nil))
+	require.Equal(t, http.StatusCreated, request(http.MethodPost, "/api/rooms/room-1/recording", "secret", "", &recording))
+	require.Equal(t, "room-1", recording.RoomID)
+	require.Equal(t, http.StatusConflict, request(http.MethodPost, "/api/rooms/room-1/recording", "secret", "", nil))
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/api/rooms/room-1/recording", "secret", "", &recording))
+
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/api/rooms/room-1", "secret", "", &room))
+	require.True(t, room.Recording)
+
+	require.Equal(t, http.StatusOK, request(http.MethodDelete, "/api/rooms/room-1/recording", "secret", "", &recording))
+	require.Equal(t, http.StatusConflict, request(http.MethodDelete, "/api/rooms/room-1/recording", "secret", "", nil))
+
+	require.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/api/rooms/room-1", "secret", "", nil))
+	require.Equal(t, http.StatusConflict, request(http.MethodDelete, "/api/rooms/room-1", "secret", "", nil))
+
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/api/rooms/room-1", "secret", "", &room))
+	require.Equal(t, sfu.StateRoomClosed, room.State)
+}
@@ -0,0 +1,482 @@
+// Package controlplane serves a gRPC API to manage the rooms, the clients, and the tracks of the SFU for the backend
+// services that orchestrate it. The service is defined in controlpb/control.proto, register it on a gRPC server with
+// Server.Register and protect it with the transport credentials or an auth interceptor of the gRPC server.
+package controlplane
+
+//go:generate buf generate
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/inlivedev/sfu"
+	"github.com/inlivedev/sfu/pkg/controlplane/controlpb"
+	"github.com/pion/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var ErrInvalidRoomType = errors.New("controlplane: error invalid room type")
+
+type Options struct {
+	// RoomOptions returns the options of the room that created with CreateRoom, return an error to reject the request.
+	// Default is nil means sfu.DefaultRoomOptions with the max clients and the empty room timeout of the request
+	RoomOptions func(req *controlpb.CreateRoomRequest) (sfu.RoomOptions, error)
+	// RecordingOptions returns the options of the recording that started with StartRecording. Default is nil means
+	// sfu.DefaultRecordingOptions
+	RecordingOptions func(room *sfu.Room) sfu.RecordingOptions
+	// StatsInterval is the interval of StreamStats when the request has no interval. Default is 1 second
+	StatsInterval time.Duration
+	// MinStatsInterval is the shortest interval that StreamStats accepts. Default is 100 milliseconds
+	MinStatsInterval time.Duration
+	// EventBufferSize is the number of events queued for each StreamEvents stream, the events are dropped when the
+	// stream is slower than the events. Default is 256
+	EventBufferSize int
+	Log             logging.LeveledLogger
+}
+
+func DefaultOptions() Options {
+	return Options{
+		StatsInterval:    time.Second,
+		MinStatsInterval: 100 * time.Millisecond,
+		EventBufferSize:  256,
+		Log:              logging.NewDefaultLoggerFactory().NewLogger("controlplane"),
+	}
+}
+
+// Server implements controlpb.ControlServiceServer on a manager
+type Server struct {
+	controlpb.UnimplementedControlServiceServer
+	manager *sfu.Manager
+	opts    Options
+}
+
+func NewServer(manager *sfu.Manager, opts Options) *Server {
+	defaults := DefaultOptions()
+
+	if opts.StatsInterval <= 0 {
+		opts.StatsInterval = defaults.StatsInterval
+	}
+
+	if opts.MinStatsInterval <= 0 {
+		opts.MinStatsInterval = defaults.MinStatsInterval
+	}
+
+	if opts.EventBufferSize <= 0 {
+		opts.EventBufferSize = defaults.EventBufferSize
+	}
+
+	if opts.Log == nil {
+		opts.Log = defaults.Log
+	}
+
+	return &Server{
+		manager: manager,
+		opts:    opts,
+	}
+}
+
+// Register registers the service on the gRPC server
+func (s *Server) Register(registrar grpc.ServiceRegistrar) {
+	controlpb.RegisterControlServiceServer(registrar, s)
+}
+
+func (s *Server) CreateRoom(ctx context.Context, req *controlpb.CreateRoomRequest) (*controlpb.Room, error) {
+	roomType := req.GetType()
+	if roomType == "" {
+		roomType = sfu.RoomTypeLocal
+	}
+
+	if roomType != sfu.RoomTypeLocal && roomType != sfu.RoomTypeRemote {
+		return nil, toStatus(ErrInvalidRoomType)
+	}
+
+	opts := sfu.DefaultRoomOptions()
+	opts.MaxClients = int(req.GetMaxClients())
+
+	if req.GetEmptyRoomTimeout() != nil {
+		timeout := req.GetEmptyRoomTimeout().AsDuration()
+		opts.EmptyRoomTimeout = &timeout
+	}
+
+	if s.opts.RoomOptions != nil {
+		var err error
+		if opts, err = s.opts.RoomOptions(req); err != nil {
+			return nil, toStatus(err)
+		}
+	}
+
+	id := req.GetId()
+	if id == "" {
+		id = s.manager.CreateRoomID()
+	}
+
+	name := req.GetName()
+	if name == "" {
+		name = id
+	}
+
+	room, err := s.manager.NewRoom(id, name, roomType, opts)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return newRoom(room), nil
+}
+
+func (s *Server) GetRoom(ctx context.Context, req *controlpb.GetRoomRequest) (*controlpb.Room, error) {
+	room, err := s.manager.GetRoom(req.GetRoomId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return newRoom(room), nil
+}
+
+func (s *Server) ListRooms(ctx context.Context, req *controlpb.ListRoomsRequest) (*controlpb.ListRoomsResponse, error) {
+	res := &controlpb.ListRoomsResponse{}
+
+	for _, room := range s.manager.Rooms() {
+		res.Rooms = append(res.Rooms, newRoom(room))
+	}
+
+	return res, nil
+}
+
+func (s *Server) CloseRoom(ctx context.Context, req *controlpb.CloseRoomRequest) (*controlpb.CloseRoomResponse, error) {
+	if err := s.manager.CloseRoom(req.GetRoomId()); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &controlpb.CloseRoomResponse{}, nil
+}
+
+func (s *Server) ListClients(ctx context.Context, req *controlpb.ListClientsRequest) (*controlpb.ListClientsResponse, error) {
+	room, err := s.manager.GetRoom(req.GetRoomId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	res := &controlpb.ListClientsResponse{}
+
+	for _, state := range room.State().Clients {
+		res.Clients = append(res.Clients, newClient(room, state))
+	}
+
+	return res, nil
+}
+
+func (s *Server) KickClient(ctx context.Context, req *controlpb.KickClientRequest) (*controlpb.KickClientResponse, error) {
+	room, err := s.manager.GetRoom(req.GetRoomId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	if req.GetBan() {
+		err = room.BanClient(req.GetClientId(), req.GetReason())
+	} else {
+		err = room.KickClient(req.GetClientId(), req.GetReason())
+	}
+
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &controlpb.KickClientResponse{}, nil
+}
+
+func (s *Server) MuteTrack(ctx context.Context, req *controlpb.MuteTrackRequest) (*controlpb.MuteTrackResponse, error) {
+	room, err := s.manager.GetRoom(req.GetRoomId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	if req.GetMuted() {
+		err = room.MuteClientTrack(req.GetClientId(), req.GetTrackId())
+	} else {
+		err = room.UnmuteClientTrack(req.GetClientId(), req.GetTrackId())
+	}
+
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &controlpb.MuteTrackResponse{}, nil
+}
+
+func (s *Server) StartRecording(ctx context.Context, req *controlpb.StartRecordingRequest) (*controlpb.Recording, error) {
+	room, err := s.manager.GetRoom(req.GetRoomId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	opts := sfu.DefaultRecordingOptions()
+	if s.opts.RecordingOptions != nil {
+		opts = s.opts.RecordingOptions(room)
+	}
+
+	recorder, err := room.StartRecording(opts)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return newRecording(room, recorder), nil
+}
+
+func (s *Server) StopRecording(ctx context.Context, req *controlpb.StopRecordingRequest) (*controlpb.Recording, error) {
+	room, err := s.manager.GetRoom(req.GetRoomId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	recorder := room.Recorder()
+	if recorder == nil {
+		return nil, toStatus(sfu.ErrRecordingNotStarted)
+	}
+
+	if err := room.StopRecording(); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return newRecording(room, recorder), nil
+}
+
+func (s *Server) StreamStats(req *controlpb.StreamStatsRequest, stream controlpb.ControlService_StreamStatsServer) error {
+	room, err := s.manager.GetRoom(req.GetRoomId())
+	if err != nil {
+		return toStatus(err)
+	}
+
+	interval := s.opts.StatsInterval
+	if req.GetInterval() != nil {
+		interval = max(req.GetInterval().AsDuration(), s.opts.MinStatsInterval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := stream.Send(newRoomStats(room.ID(), room.Stats())); err != nil {
+			return err
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-room.Context().Done():
+			// the stream ends when the room is closed
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) StreamEvents(req *controlpb.StreamEventsRequest, stream controlpb.ControlService_StreamEventsServer) error {
+	events := make(chan sfu.Event, s.opts.EventBufferSize)
+
+	remove := s.manager.AddEventSink(sfu.EventSinkFunc(func(event sfu.Event) {
+		if req.GetRoomId() != "" && event.Data["room_id"] != req.GetRoomId() {
+			return
+		}
+
+		if len(req.GetTypes()) > 0 && !slices.Contains(req.GetTypes(), event.Type) {
+			return
+		}
+
+		select {
+		case events <- event:
+		default:
+			s.opts.Log.Warnf("controlplane: drop event %s, the stream is too slow", event.Type)
+		}
+	}))
+
+	defer remove()
+
+	// the header tells the client that the events are subscribed, the events before it are not sent
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.manager.Context().Done():
+			return nil
+		case event := <-events:
+			msg, err := newEvent(event)
+			if err != nil {
+				s.opts.Log.Errorf("controlplane: error encode event %s: %s", event.Type, err)
+				continue
+			}
+
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// toStatus returns the gRPC status of the SFU error
+func toStatus(err error) error {
+	code := codes.Internal
+
+	switch {
+	case errors.Is(err, sfu.ErrRoomNotFound), errors.Is(err, sfu.ErrClientNotFound), errors.Is(err, sfu.ErrTrackIsNotExists):
+		code = codes.NotFound
+	case errors.Is(err, sfu.ErrRoomAlreadyExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, ErrInvalidRoomType):
+		code = codes.InvalidArgument
+	case errors.Is(err, sfu.ErrRecordingAlreadyStarted), errors.Is(err, sfu.ErrRecordingNotStarted),
+		errors.Is(err, sfu.ErrE2EENotSupported), errors.Is(err, sfu.ErrRoomIsClosed):
+		code = codes.FailedPrecondition
+	case errors.Is(err, sfu.ErrManagerDraining):
+		code = codes.Unavailable
+	}
+
+	return status.Error(code, err.Error())
+}
+
+func newRoom(room *sfu.Room) *controlpb.Room {
+	state := room.State()
+
+	return &controlpb.Room{
+		Id:           room.ID(),
+		Name:         room.Name(),
+		Type:         room.Kind(),
+		State:        state.State,
+		ClientsCount: int32(len(state.Clients)),
+		Recording:    room.Recorder() != nil,
+	}
+}
+
+func newClient(room *sfu.Room, state sfu.RoomClientState) *controlpb.Client {
+	client := &controlpb.Client{
+		Id:              state.ID,
+		Name:            state.Name,
+		Role:            string(state.Role),
+		ConnectionState: state.ConnectionState,
+		InLobby:         state.InLobby,
+	}
+
+	// the client may leave after the state is taken
+	sfuClient, _ := room.SFU().GetClient(state.ID)
+
+	for _, track := range state.Published {
+		client.PublishedTracks = append(client.PublishedTracks, &controlpb.Track{
+			Id:        track.ID,
+			StreamId:  track.StreamID,
+			Kind:      track.Kind,
+			MimeType:  track.MimeType,
+			Source:    track.Source.String(),
+			Label:     track.Label,
+			Simulcast: track.Simulcast,
+			Muted:     sfuClient != nil && sfuClient.IsTrackMutedRemotely(track.ID),
+		})
+	}
+
+	return client
+}
+
+func newRecording(room *sfu.Room, recorder *sfu.Recorder) *controlpb.Recording {
+	recording := &controlpb.Recording{
+		RoomId:    room.ID(),
+		StartTime: timestamppb.New(recorder.StartTime()),
+		Duration:  durationpb.New(recorder.Duration()),
+	}
+
+	for _, track := range recorder.RecordedTracks() {
+		recording.Tracks = append(recording.Tracks, &controlpb.RecordedTrack{
+			ClientId: track.ClientID,
+			TrackId:  track.TrackID,
+			Kind:     track.Kind.String(),
+			Path:     track.Path,
+			Start:    durationpb.New(track.Start),
+			End:      durationpb.New(track.End),
+		})
+	}
+
+	return recording
+}
+
+func newRoomStats(roomID string, stats sfu.RoomStats) *controlpb.RoomStats {
+	msg := &controlpb.RoomStats{
+		RoomId:          roomID,
+		Timestamp:       timestamppb.New(stats.Timestamp),
+		ActiveSessions:  int32(stats.ActiveSessions),
+		ClientsCount:    int32(stats.ClientsCount),
+		BitrateSent:     stats.BitrateSent,
+		BitrateReceived: stats.BitrateReceived,
+		BytesIngress:    stats.BytesIngress,
+		BytesEgress:     stats.BytesEgress,
+	}
+
+	for _, client := range stats.ClientStats {
+		clientStats := &controlpb.ClientStats{
+			Id:                 client.ID,
+			Name:               client.Name,
+			PublisherBandwidth: client.PublisherBandwidth,
+			ConsumerBandwidth:  client.ConsumerBandwidth,
+			CurrentBitrate:     client.CurrentConsumerBitrate,
+		}
+
+		for _, track := range client.Sents {
+			clientStats.SentTracks = append(clientStats.SentTracks, &controlpb.TrackStats{
+				Id:             track.ID,
+				Kind:           track.Kind.String(),
+				Codec:          track.Codec,
+				CurrentBitrate: track.CurrentBitrate,
+				PacketsLost:    track.PacketsLost,
+			})
+		}
+
+		for _, track := range client.Receives {
+			clientStats.ReceivedTracks = append(clientStats.ReceivedTracks, &controlpb.TrackStats{
+				Id:             track.ID,
+				Kind:           track.Kind.String(),
+				Codec:          track.Codec,
+				CurrentBitrate: track.CurrentBitrate,
+				PacketsLost:    track.PacketsLost,
+			})
+		}
+
+		msg.Clients = append(msg.Clients, clientStats)
+	}
+
+	slices.SortFunc(msg.Clients, func(a, b *controlpb.ClientStats) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+
+	return msg
+}
+
+// newEvent encodes the event data through JSON, so any JSON value like the time is accepted by the struct
+func newEvent(event sfu.Event) (*controlpb.Event, error) {
+	raw, err := json.Marshal(event.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &structpb.Struct{}
+	if err := data.UnmarshalJSON(raw); err != nil {
+		return nil, err
+	}
+
+	roomID, _ := event.Data["room_id"].(string)
+
+	return &controlpb.Event{
+		Type:   event.Type,
+		RoomId: roomID,
+		Time:   timestamppb.New(event.Time),
+		Data:   data,
+	}, nil
+}
@@ -0,0 +1,169 @@
+package controlplane
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/inlivedev/sfu"
+	"github.com/inlivedev/sfu/pkg/controlplane/controlpb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := sfu.NewManager(ctx, "test", sfu.DefaultOptions())
+	defer roomManager.Close()
+
+	opts := DefaultOptions()
+	opts.RecordingOptions = func(room *sfu.Room) sfu.RecordingOptions {
+		return sfu.RecordingOptions{Directory: t.TempDir()}
+	}
+
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	NewServer(roomManager, opts).Register(grpcServer)
+
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	api := controlpb.NewControlServiceClient(conn)
+
+	requireCode := func(err error, code codes.Code) {
+		require.Error(t, err)
+		require.Equal(t, code, status.Code(err), err.Error())
+	}
+
+	events, err := api.StreamEvents(ctx, &controlpb.StreamEventsRequest{RoomId: "room-1"})
+	require.NoError(t, err)
+
+	_, err = events.Header()
+	require.NoError(t, err)
+
+	room, err := api.CreateRoom(ctx, &controlpb.CreateRoomRequest{Id: "room-1", Name: "Room 1", MaxClients: 2})
+	require.NoError(t, err)
+	require.Equal(t, "room-1", room.GetId())
+	require.Equal(t, "Room 1", room.GetName())
+	require.Equal(t, sfu.RoomTypeLocal, room.GetType())
+
+	_, err = api.CreateRoom(ctx, &controlpb.CreateRoomRequest{Id: "room-1"})
+	requireCode(err, codes.AlreadyExists)
+
+	_, err = api.CreateRoom(ctx, &controlpb.CreateRoomRequest{Type: "unknown"})
+	requireCode(err, codes.InvalidArgument)
+
+	// the events of the other rooms are filtered
+	_, err = api.CreateRoom(ctx, &controlpb.CreateRoomRequest{Id: "room-2"})
+	require.NoError(t, err)
+
+	event, err := events.Recv()
+	require.NoError(t, err)
+	require.Equal(t, sfu.EventRoomCreated, event.GetType())
+	require.Equal(t, "room-1", event.GetRoomId())
+	require.Equal(t, "Room 1", event.GetData().AsMap()["name"])
+
+	rooms, err := api.ListRooms(ctx, &controlpb.ListRoomsRequest{})
+	require.NoError(t, err)
+	require.Len(t, rooms.GetRooms(), 2)
+	require.Equal(t, "room-1", rooms.GetRooms()[0].GetId())
+
+	sfuRoom, err := roomManager.GetRoom("room-1")
+	require.NoError(t, err)
+	require.Equal(t, 2, sfuRoom.Options().MaxClients)
+
+	_, err = sfuRoom.AddClient("alice", "Alice", sfu.DefaultClientOptions())
+	require.NoError(t, err)
+
+	clients, err := api.ListClients(ctx, &controlpb.ListClientsRequest{RoomId: "room-1"})
+	require.NoError(t, err)
+	require.Len(t, clients.GetClients(), 1)
+	require.Equal(t, "alice", clients.GetClients()[0].GetId())
+	require.Equal(t, "Alice", clients.GetClients()[0].GetName())
+
+	_, err = api.MuteTrack(ctx, &controlpb.MuteTrackRequest{RoomId: "room-1", ClientId: "bob", TrackId: "track", Muted: true})
+	requireCode(err, codes.NotFound)
+
+	// recording
+	recording, err := api.StartRecording(ctx, &controlpb.StartRecordingRequest{RoomId: "room-1"})
+	require.NoError(t, err)
+	require.Equal(t, "room-1", recording.GetRoomId())
+
+	room, err = api.GetRoom(ctx, &controlpb.GetRoomRequest{RoomId: "room-1"})
+	require.NoError(t, err)
+	require.True(t, room.GetRecording())
+	require.Equal(t, int32(1), room.GetClientsCount())
+
+	_, err = api.StartRecording(ctx, &controlpb.StartRecordingRequest{RoomId: "room-1"})
+	requireCode(err, codes.FailedPrecondition)
+
+	_, err = api.StopRecording(ctx, &controlpb.StopRecordingRequest{RoomId: "room-1"})
+	require.NoError(t, err)
+
+	_, err = api.StopRecording(ctx, &controlpb.StopRecordingRequest{RoomId: "room-1"})
+	requireCode(err, codes.FailedPrecondition)
+
+	// stats
+	statsCtx, statsCancel := context.WithCancel(ctx)
+
+	stats, err := api.StreamStats(statsCtx, &controlpb.StreamStatsRequest{RoomId: "room-1", Interval: durationpb.New(time.Millisecond)})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		roomStats, err := stats.Recv()
+		require.NoError(t, err)
+		require.Equal(t, "room-1", roomStats.GetRoomId())
+	}
+
+	statsCancel()
+
+	_, err = api.KickClient(ctx, &controlpb.KickClientRequest{RoomId: "room-1", ClientId: "alice", Reason: "test", Ban: true})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		clients, err := api.ListClients(ctx, &controlpb.ListClientsRequest{RoomId: "room-1"})
+		return err == nil && len(clients.GetClients()) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	banned, err := sfuRoom.BanList().IsBanned("room-1", "alice")
+	require.NoError(t, err)
+	require.True(t, banned)
+
+	_, err = api.CloseRoom(ctx, &controlpb.CloseRoomRequest{RoomId: "room-1"})
+	require.NoError(t, err)
+
+	eventTypes := make([]string, 0)
+	for len(eventTypes) == 0 || eventTypes[len(eventTypes)-1] != sfu.EventRoomClosed {
+		event, err := events.Recv()
+		require.NoError(t, err)
+		require.Equal(t, "room-1", event.GetRoomId())
+
+		eventTypes = append(eventTypes, event.GetType())
+	}
+
+	require.Contains(t, eventTypes, sfu.EventRecordingStarted)
+	require.Contains(t, eventTypes, sfu.EventRecordingStopped)
+
+	_, err = api.GetRoom(ctx, &controlpb.GetRoomRequest{RoomId: "unknown"})
+	requireCode(err, codes.NotFound)
+}
@@ -0,0 +1,354 @@
+// Package dependencydescriptor implements parsing of the AV1 RTP dependency descriptor header extension.
+// https://aomediacodec.github.io/av1-rtp-spec/#dependency-descriptor-rtp-header-extension
+package dependencydescriptor
+
+import (
+	"errors"
+)
+
+const (
+	URI = "https://aomediacodec.github.io/av1-rtp-spec/#dependency-descriptor-rtp-header-extension"
+
+	maxTemplates = 64
+)
+
+// DecodeTargetIndication tells how a frame is related to a decode target
+type DecodeTargetIndication uint8
+
+const (
+	DTINotPresent  DecodeTargetIndication = 0
+	DTIDiscardable DecodeTargetIndication = 1
+	DTISwitch      DecodeTargetIndication = 2
+	DTIRequired    DecodeTargetIndication = 3
+)
+
+var (
+	ErrTooShort         = errors.New("dependencydescriptor: buffer too short")
+	ErrMissingStructure = errors.New("dependencydescriptor: template dependency structure is not received yet")
+	ErrInvalidTemplate  = errors.New("dependencydescriptor: invalid template index")
+	ErrTooManyTemplates = errors.New("dependencydescriptor: too many templates")
+)
+
+type FrameTemplate struct {
+	SpatialID  uint8
+	TemporalID uint8
+	DTIs       []DecodeTargetIndication
+	FrameDiffs []uint8
+	ChainDiffs []uint8
+}
+
+type Resolution struct {
+	Width  uint16
+	Height uint16
+}
+
+// FrameDependencyStructure is sent by the encoder on every keyframe and apply to all following frames
+// until the next structure is received.
+type FrameDependencyStructure struct {
+	TemplateIDOffset       uint8
+	DecodeTargetCount      uint8
+	ChainCount             uint8
+	DecodeTargetProtectdBy []uint8
+	Templates              []FrameTemplate
+	Resolutions            []Resolution
+}
+
+// NumSpatialLayers returns the number of spatial layers declared by the structure
+func (s *FrameDependencyStructure) NumSpatialLayers() int {
+	max := uint8(0)
+	for _, t := range s.Templates {
+		if t.SpatialID > max {
+			max = t.SpatialID
+		}
+	}
+
+	return int(max) + 1
+}
+
+// NumTemporalLayers returns the number of temporal layers declared by the structure
+func (s *FrameDependencyStructure) NumTemporalLayers() int {
+	max := uint8(0)
+	for _, t := range s.Templates {
+		if t.TemporalID > max {
+			max = t.TemporalID
+		}
+	}
+
+	return int(max) + 1
+}
+
+// DecodeTarget returns the index of decode target that decoding the spatial and temporal layer.
+// It returns -1 if there is no decode target for the layer
+func (s *FrameDependencyStructure) DecodeTarget(sid, tid uint8) int {
+	for dt := 0; dt < int(s.DecodeTargetCount); dt++ {
+		maxSID, maxTID := uint8(0), uint8(0)
+		for _, t := range s.Templates {
+			if t.DTIs[dt] == DTINotPresent {
+				continue
+			}
+
+			if t.SpatialID > maxSID {
+				maxSID = t.SpatialID
+			}
+
+			if t.TemporalID > maxTID {
+				maxTID = t.TemporalID
+			}
+		}
+
+		if maxSID == sid && maxTID == tid {
+			return dt
+		}
+	}
+
+	return -1
+}
+
+type DependencyDescriptor struct {
+	StartOfFrame bool
+	EndOfFrame   bool
+	TemplateID   uint8
+	FrameNumber  uint16
+	// AttachedStructure is not nil when the descriptor is carrying a new structure, usually on keyframe
+	AttachedStructure *FrameDependencyStructure
+	// ActiveDecodeTargetsBitmask is nil when the descriptor is not carrying the active decode targets
+	ActiveDecodeTargetsBitmask *uint32
+	SpatialID                  uint8
+	TemporalID                 uint8
+	DTIs                       []DecodeTargetIndication
+	// FrameDiffs are the differences to the frame numbers of the referenced frames, a custom frame diff is up to 4096
+	FrameDiffs []uint16
+}
+
+// Unmarshal parses the dependency descriptor. The structure is the latest received structure
+// and can be nil if the descriptor is expected to carry a new structure.
+func Unmarshal(buf []byte, structure *FrameDependencyStructure) (*DependencyDescriptor, error) {
+	if len(buf) < 3 {
+		return nil, ErrTooShort
+	}
+
+	r := &bitReader{buf: buf}
+	d := &DependencyDescriptor{}
+
+	d.StartOfFrame = r.readBool()
+	d.EndOfFrame = r.readBool()
+	d.TemplateID = uint8(r.readBits(6))
+	d.FrameNumber = uint16(r.readBits(16))
+
+	var customDTIs, customFdiffs, customChains bool
+
+	if len(buf) > 3 {
+		structurePresent := r.readBool()
+		activeDecodeTargetsPresent := r.readBool()
+		customDTIs = r.readBool()
+		customFdiffs = r.readBool()
+		customChains = r.readBool()
+
+		if structurePresent {
+			s, err := readStructure(r)
+			if err != nil {
+				return nil, err
+			}
+
+			d.AttachedStructure = s
+			structure = s
+
+			bitmask := uint32(1<<s.DecodeTargetCount) - 1
+			d.ActiveDecodeTargetsBitmask = &bitmask
+		}
+
+		if activeDecodeTargetsPresent {
+			if structure == nil {
+				return nil, ErrMissingStructure
+			}
+
+			bitmask := uint32(r.readBits(int(structure.DecodeTargetCount)))
+			d.ActiveDecodeTargetsBitmask = &bitmask
+		}
+	}
+
+	if structure == nil {
+		return nil, ErrMissingStructure
+	}
+
+	templateIndex := (int(d.TemplateID) + maxTemplates - int(structure.TemplateIDOffset)) % maxTemplates
+	if templateIndex >= len(structure.Templates) {
+		return nil, ErrInvalidTemplate
+	}
+
+	template := structure.Templates[templateIndex]
+	d.SpatialID = template.SpatialID
+	d.TemporalID = template.TemporalID
+	d.DTIs = template.DTIs
+
+	d.FrameDiffs = make([]uint16, len(template.FrameDiffs))
+	for i, fdiff := range template.FrameDiffs {
+		d.FrameDiffs[i] = uint16(fdiff)
+	}
+
+	if customDTIs {
+		d.DTIs = make([]DecodeTargetIndication, structure.DecodeTargetCount)
+		for i := range d.DTIs {
+			d.DTIs[i] = DecodeTargetIndication(r.readBits(2))
+		}
+	}
+
+	if customFdiffs {
+		d.FrameDiffs = readFrameFdiffs(r)
+	}
+
+	if customChains {
+		for i := 0; i < int(structure.ChainCount); i++ {
+			_ = r.readBits(8)
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return d, nil
+}
+
+func readStructure(r *bitReader) (*FrameDependencyStructure, error) {
+	s := &FrameDependencyStructure{}
+	s.TemplateIDOffset = uint8(r.readBits(6))
+	s.DecodeTargetCount = uint8(r.readBits(5)) + 1
+
+	// template layers
+	spatialID, temporalID := uint8(0), uint8(0)
+	for {
+		if len(s.Templates) >= maxTemplates {
+			return nil, ErrTooManyTemplates
+		}
+
+		s.Templates = append(s.Templates, FrameTemplate{SpatialID: spatialID, TemporalID: temporalID})
+
+		nextLayerIdc := r.readBits(2)
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		if nextLayerIdc == 1 {
+			temporalID++
+		} else if nextLayerIdc == 2 {
+			temporalID = 0
+			spatialID++
+		} else if nextLayerIdc == 3 {
+			break
+		}
+	}
+
+	// template dtis
+	for i := range s.Templates {
+		s.Templates[i].DTIs = make([]DecodeTargetIndication, s.DecodeTargetCount)
+		for dt := range s.Templates[i].DTIs {
+			s.Templates[i].DTIs[dt] = DecodeTargetIndication(r.readBits(2))
+		}
+	}
+
+	// template fdiffs
+	for i := range s.Templates {
+		s.Templates[i].FrameDiffs = readFdiffs(r)
+	}
+
+	// template chains
+	s.ChainCount = uint8(r.readNonSymmetric(uint32(s.DecodeTargetCount) + 1))
+	if s.ChainCount > 0 {
+		s.DecodeTargetProtectdBy = make([]uint8, s.DecodeTargetCount)
+		for dt := range s.DecodeTargetProtectdBy {
+			s.DecodeTargetProtectdBy[dt] = uint8(r.readNonSymmetric(uint32(s.ChainCount)))
+		}
+
+		for i := range s.Templates {
+			s.Templates[i].ChainDiffs = make([]uint8, s.ChainCount)
+			for c := range s.Templates[i].ChainDiffs {
+				s.Templates[i].ChainDiffs[c] = uint8(r.readBits(4))
+			}
+		}
+	}
+
+	// render resolutions
+	if r.readBool() {
+		for sid := 0; sid < s.NumSpatialLayers(); sid++ {
+			s.Resolutions = append(s.Resolutions, Resolution{
+				Width:  uint16(r.readBits(16)) + 1,
+				Height: uint16(r.readBits(16)) + 1,
+			})
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return s, nil
+}
+
+// readFdiffs reads the fdiffs of a template, each fdiff is 4 bits after a continuation bit
+func readFdiffs(r *bitReader) []uint8 {
+	fdiffs := make([]uint8, 0)
+	for r.readBool() {
+		fdiffs = append(fdiffs, uint8(r.readBits(4))+1)
+		if r.err != nil {
+			break
+		}
+	}
+
+	return fdiffs
+}
+
+// readFrameFdiffs reads the custom fdiffs of a frame, each fdiff is 4, 8, or 12 bits after its 2 bits size, and the
+// zero size ends the list
+func readFrameFdiffs(r *bitReader) []uint16 {
+	fdiffs := make([]uint16, 0)
+	for size := r.readBits(2); size != 0 && r.err == nil; size = r.readBits(2) {
+		fdiffs = append(fdiffs, uint16(r.readBits(4*int(size)))+1)
+	}
+
+	return fdiffs
+}
+
+type bitReader struct {
+	buf    []byte
+	offset int
+	err    error
+}
+
+func (r *bitReader) readBits(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		if r.offset >= len(r.buf)*8 {
+			r.err = ErrTooShort
+			return 0
+		}
+
+		bit := (r.buf[r.offset/8] >> (7 - uint(r.offset%8))) & 0x01
+		v = (v << 1) | uint64(bit)
+		r.offset++
+	}
+
+	return v
+}
+
+func (r *bitReader) readBool() bool {
+	return r.readBits(1) == 1
+}
+
+// non-symmetric unsigned encoded integer with maximum number of values n
+func (r *bitReader) readNonSymmetric(n uint32) uint32 {
+	w := 0
+	for x := n; x != 0; x >>= 1 {
+		w++
+	}
+
+	m := uint32(1<<w) - n
+	v := uint32(r.readBits(w - 1))
+	if v < m {
+		return v
+	}
+
+	extraBit := uint32(r.readBits(1))
+
+	return (v << 1) - m + extraBit
+}
@@ -0,0 +1,180 @@
+package dependencydescriptor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type bitWriter struct {
+	buf    []byte
+	offset int
+}
+
+func (w *bitWriter) write(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.offset%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+
+		bit := byte((v >> uint(i)) & 0x01)
+		w.buf[w.offset/8] |= bit << (7 - uint(w.offset%8))
+		w.offset++
+	}
+}
+
+func mandatoryFields(w *bitWriter, templateID uint8, frameNumber uint16) {
+	w.write(1, 1) // start of frame
+	w.write(1, 1) // end of frame
+	w.write(uint64(templateID), 6)
+	w.write(uint64(frameNumber), 16)
+}
+
+// L1T3 structure with 3 templates and 3 decode targets
+func l1t3Descriptor(templateIDOffset uint8) []byte {
+	w := &bitWriter{}
+	mandatoryFields(w, templateIDOffset, 1)
+
+	w.write(1, 1) // template dependency structure present
+	w.write(0, 1) // active decode targets present
+	w.write(0, 1) // custom dtis
+	w.write(0, 1) // custom fdiffs
+	w.write(0, 1) // custom chains
+
+	w.write(uint64(templateIDOffset), 6)
+	w.write(3-1, 5) // decode target count
+
+	// template layers
+	w.write(1, 2) // next temporal layer
+	w.write(1, 2) // next temporal layer
+	w.write(3, 2) // no more templates
+
+	// template dtis
+	for _, dtis := range [][]DecodeTargetIndication{
+		{DTISwitch, DTISwitch, DTISwitch},
+		{DTINotPresent, DTISwitch, DTISwitch},
+		{DTINotPresent, DTINotPresent, DTIDiscardable},
+	} {
+		for _, dti := range dtis {
+			w.write(uint64(dti), 2)
+		}
+	}
+
+	// template fdiffs
+	for _, fdiff := range []uint64{4, 2, 1} {
+		w.write(1, 1)
+		w.write(fdiff-1, 4)
+		w.write(0, 1)
+	}
+
+	// no chains
+	w.write(0, 2)
+
+	// no render resolutions
+	w.write(0, 1)
+
+	return w.buf
+}
+
+func TestUnmarshalStructure(t *testing.T) {
+	dd, err := Unmarshal(l1t3Descriptor(10), nil)
+	require.NoError(t, err)
+	require.True(t, dd.StartOfFrame)
+	require.True(t, dd.EndOfFrame)
+	require.Equal(t, uint16(1), dd.FrameNumber)
+	require.NotNil(t, dd.AttachedStructure)
+	require.NotNil(t, dd.ActiveDecodeTargetsBitmask)
+	require.Equal(t, uint32(0x7), *dd.ActiveDecodeTargetsBitmask)
+
+	s := dd.AttachedStructure
+	require.Equal(t, uint8(3), s.DecodeTargetCount)
+	require.Len(t, s.Templates, 3)
+	require.Equal(t, 1, s.NumSpatialLayers())
+	require.Equal(t, 3, s.NumTemporalLayers())
+	require.Equal(t, []uint8{2}, s.Templates[1].FrameDiffs)
+	require.Equal(t, 1, s.DecodeTarget(0, 1))
+	require.Equal(t, -1, s.DecodeTarget(1, 0))
+
+	require.Equal(t, uint8(0), dd.SpatialID)
+	require.Equal(t, uint8(0), dd.TemporalID)
+	require.Equal(t, DTISwitch, dd.DTIs[0])
+}
+
+func TestUnmarshalWithStructure(t *testing.T) {
+	keyframe, err := Unmarshal(l1t3Descriptor(62), nil)
+	require.NoError(t, err)
+
+	// template id is wrapped around from the offset
+	w := &bitWriter{}
+	mandatoryFields(w, 0, 2)
+
+	dd, err := Unmarshal(w.buf, keyframe.AttachedStructure)
+	require.NoError(t, err)
+	require.Nil(t, dd.AttachedStructure)
+	require.Equal(t, uint16(2), dd.FrameNumber)
+	require.Equal(t, uint8(2), dd.TemporalID)
+	require.Equal(t, DTIDiscardable, dd.DTIs[2])
+
+	w = &bitWriter{}
+	mandatoryFields(w, 5, 3)
+
+	_, err = Unmarshal(w.buf, keyframe.AttachedStructure)
+	require.ErrorIs(t, err, ErrInvalidTemplate)
+}
+
+func TestUnmarshalCustomFdiffs(t *testing.T) {
+	keyframe, err := Unmarshal(l1t3Descriptor(0), nil)
+	require.NoError(t, err)
+
+	w := &bitWriter{}
+	mandatoryFields(w, 1, 2)
+
+	w.write(0, 1) // template dependency structure present
+	w.write(0, 1) // active decode targets present
+	w.write(1, 1) // custom dtis
+	w.write(1, 1) // custom fdiffs
+	w.write(0, 1) // custom chains
+
+	for _, dti := range []DecodeTargetIndication{DTINotPresent, DTIRequired, DTIDiscardable} {
+		w.write(uint64(dti), 2)
+	}
+
+	// the fdiffs of 4, 8, and 12 bits
+	for _, fdiff := range []struct {
+		size  uint64
+		value uint64
+	}{{1, 3}, {2, 200}, {3, 4000}} {
+		w.write(fdiff.size, 2)
+		w.write(fdiff.value-1, 4*int(fdiff.size))
+	}
+
+	w.write(0, 2) // no more fdiffs
+
+	dd, err := Unmarshal(w.buf, keyframe.AttachedStructure)
+	require.NoError(t, err)
+	require.Equal(t, uint8(1), dd.TemporalID)
+	require.Equal(t, []DecodeTargetIndication{DTINotPresent, DTIRequired, DTIDiscardable}, dd.DTIs)
+	require.Equal(t, []uint16{3, 200, 4000}, dd.FrameDiffs)
+
+	// the template fdiffs are used without the custom fdiffs
+	w = &bitWriter{}
+	mandatoryFields(w, 1, 3)
+
+	dd, err = Unmarshal(w.buf, keyframe.AttachedStructure)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{2}, dd.FrameDiffs)
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	_, err := Unmarshal([]byte{0x80}, nil)
+	require.ErrorIs(t, err, ErrTooShort)
+
+	w := &bitWriter{}
+	mandatoryFields(w, 0, 1)
+
+	_, err = Unmarshal(w.buf, nil)
+	require.ErrorIs(t, err, ErrMissingStructure)
+
+	_, err = Unmarshal(l1t3Descriptor(0)[:5], nil)
+	require.ErrorIs(t, err, ErrTooShort)
+}
@@ -0,0 +1,54 @@
+// Package framemarking implements parsing of the frame marking RTP header extension.
+// https://datatracker.ietf.org/doc/html/draft-ietf-avtext-framemarking
+package framemarking
+
+import "errors"
+
+const URI = "urn:ietf:params:rtp-hdrext:framemarking"
+
+var ErrTooShort = errors.New("framemarking: buffer too short")
+
+//	 0                   1                   2                   3
+//	 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|  ID=? |  L=2  |S|E|I|D|B| TID |   LID         |    TL0PICIDX  |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// The LID and TL0PICIDX are omitted for non-scalable streams.
+type FrameMarking struct {
+	StartOfFrame bool
+	EndOfFrame   bool
+	Independent  bool
+	Discardable  bool
+	// BaseLayerSync is set when the frame only depends on the base temporal layer,
+	// it's safe to switch up to the frame temporal layer
+	BaseLayerSync bool
+	TemporalID    uint8
+	LayerID       uint8
+	TL0PicIdx     uint8
+}
+
+func Unmarshal(buf []byte) (*FrameMarking, error) {
+	if len(buf) < 1 {
+		return nil, ErrTooShort
+	}
+
+	f := &FrameMarking{
+		StartOfFrame:  buf[0]&0x80 != 0,
+		EndOfFrame:    buf[0]&0x40 != 0,
+		Independent:   buf[0]&0x20 != 0,
+		Discardable:   buf[0]&0x10 != 0,
+		BaseLayerSync: buf[0]&0x08 != 0,
+		TemporalID:    buf[0] & 0x07,
+	}
+
+	if len(buf) >= 2 {
+		f.LayerID = buf[1]
+	}
+
+	if len(buf) >= 3 {
+		f.TL0PicIdx = buf[2]
+	}
+
+	return f, nil
+}
@@ -0,0 +1,31 @@
+package framemarking
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshal(t *testing.T) {
+	// start of an independent frame on base layer
+	f, err := Unmarshal([]byte{0xa0})
+	require.NoError(t, err)
+	require.True(t, f.StartOfFrame)
+	require.False(t, f.EndOfFrame)
+	require.True(t, f.Independent)
+	require.Equal(t, uint8(0), f.TemporalID)
+
+	// end of a discardable frame on temporal layer 2 with base layer sync
+	f, err = Unmarshal([]byte{0x5a, 0x01, 0x7f})
+	require.NoError(t, err)
+	require.False(t, f.StartOfFrame)
+	require.True(t, f.EndOfFrame)
+	require.True(t, f.Discardable)
+	require.True(t, f.BaseLayerSync)
+	require.Equal(t, uint8(2), f.TemporalID)
+	require.Equal(t, uint8(1), f.LayerID)
+	require.Equal(t, uint8(0x7f), f.TL0PicIdx)
+
+	_, err = Unmarshal(nil)
+	require.ErrorIs(t, err, ErrTooShort)
+}
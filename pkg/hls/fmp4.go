@@ -0,0 +1,231 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	videoTimescale = 90000
+	audioTimescale = 48000
+
+	// sample flags https://www.iso.org/standard/83102.html section 8.8.3.1
+	sampleFlagSync    = 0x02000000
+	sampleFlagNonSync = 0x01010000
+)
+
+// fmp4Track is the track description that written in the init segment
+type fmp4Track struct {
+	id        uint32
+	video     bool
+	timescale uint32
+	// video settings
+	width  uint16
+	height uint16
+	avcc   []byte
+	// audio settings
+	channels uint16
+}
+
+type fmp4Sample struct {
+	data     []byte
+	duration uint32
+	keyframe bool
+}
+
+// fmp4Run is the samples of a track in a fragment
+type fmp4Run struct {
+	track    *fmp4Track
+	baseTime uint64
+	samples  []fmp4Sample
+}
+
+var unityMatrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+func box(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+
+	buf := make([]byte, 0, size)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(size))
+	buf = append(buf, typ...)
+
+	for _, payload := range payloads {
+		buf = append(buf, payload...)
+	}
+
+	return buf
+}
+
+func fullBox(typ string, version byte, flags uint32, payloads ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+
+	return box(typ, append([][]byte{header}, payloads...)...)
+}
+
+// fields writes the big endian values, every value type decides the written size
+func fields(values ...interface{}) []byte {
+	buf := &bytes.Buffer{}
+
+	for _, v := range values {
+		switch value := v.(type) {
+		case []byte:
+			buf.Write(value)
+		case string:
+			buf.WriteString(value)
+		case []uint32:
+			for _, u := range value {
+				_ = binary.Write(buf, binary.BigEndian, u)
+			}
+		default:
+			_ = binary.Write(buf, binary.BigEndian, value)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// initSegment returns the ftyp and moov boxes that describe all tracks in the stream
+func initSegment(tracks []*fmp4Track) []byte {
+	ftyp := box("ftyp", fields("iso5", uint32(512), "iso5", "iso6", "mp41", "cmfc"))
+
+	mvhd := fullBox("mvhd", 0, 0, fields(
+		uint32(0), uint32(0), // creation and modification time
+		uint32(1000), uint32(0), // timescale and duration
+		uint32(0x00010000), uint16(0x0100), make([]byte, 10), // rate, volume and reserved
+		unityMatrix, make([]byte, 24), // matrix and pre defined
+		uint32(len(tracks)+1), // next track ID
+	))
+
+	traks := make([][]byte, 0, len(tracks))
+	trexs := make([][]byte, 0, len(tracks))
+
+	for _, track := range tracks {
+		traks = append(traks, trak(track))
+		trexs = append(trexs, fullBox("trex", 0, 0, fields(track.id, uint32(1), uint32(0), uint32(0), uint32(0))))
+	}
+
+	moov := box("moov", append(append([][]byte{mvhd}, traks...), box("mvex", trexs...))...)
+
+	return append(ftyp, moov...)
+}
+
+func trak(track *fmp4Track) []byte {
+	volume := uint16(0)
+	handler, name := "vide", "VideoHandler"
+	mediaHeader := fullBox("vmhd", 0, 1, make([]byte, 8))
+
+	if !track.video {
+		volume = 0x0100
+		handler, name = "soun", "SoundHandler"
+		mediaHeader = fullBox("smhd", 0, 0, make([]byte, 4))
+	}
+
+	tkhd := fullBox("tkhd", 0, 3, fields(
+		uint32(0), uint32(0), // creation and modification time
+		track.id, uint32(0), uint32(0), // track ID, reserved and duration
+		make([]byte, 8), uint16(0), uint16(0), volume, uint16(0), // reserved, layer, alternate group, volume and reserved
+		unityMatrix,
+		uint32(track.width)<<16, uint32(track.height)<<16,
+	))
+
+	mdhd := fullBox("mdhd", 0, 0, fields(
+		uint32(0), uint32(0), // creation and modification time
+		track.timescale, uint32(0),
+		uint16(0x55c4), uint16(0), // language "und"
+	))
+
+	hdlr := fullBox("hdlr", 0, 0, fields(uint32(0), handler, make([]byte, 12), name, byte(0)))
+
+	dinf := box("dinf", fullBox("dref", 0, 0, fields(uint32(1)), fullBox("url ", 0, 1)))
+
+	stbl := box("stbl",
+		fullBox("stsd", 0, 0, fields(uint32(1)), sampleEntry(track)),
+		fullBox("stts", 0, 0, fields(uint32(0))),
+		fullBox("stsc", 0, 0, fields(uint32(0))),
+		fullBox("stsz", 0, 0, fields(uint32(0), uint32(0))),
+		fullBox("stco", 0, 0, fields(uint32(0))),
+	)
+
+	return box("trak", tkhd, box("mdia", mdhd, hdlr, box("minf", mediaHeader, dinf, stbl)))
+}
+
+func sampleEntry(track *fmp4Track) []byte {
+	if track.video {
+		return box("avc1", fields(
+			make([]byte, 6), uint16(1), // reserved and data reference index
+			make([]byte, 16), // pre defined and reserved
+			track.width, track.height,
+			uint32(0x00480000), uint32(0x00480000), // 72 dpi
+			uint32(0), uint16(1), // reserved and frame count
+			make([]byte, 32), // compressor name
+			uint16(0x0018), int16(-1),
+		), box("avcC", track.avcc))
+	}
+
+	// https://opus-codec.org/docs/opus_in_isobmff.html
+	dops := box("dOps", fields(
+		byte(0), byte(track.channels), uint16(0), // version, output channel count and pre-skip
+		uint32(audioTimescale), int16(0), byte(0), // input sample rate, output gain and channel mapping family
+	))
+
+	return box("Opus", fields(
+		make([]byte, 6), uint16(1), // reserved and data reference index
+		make([]byte, 8),            // reserved
+		track.channels, uint16(16), // channel count and sample size
+		uint32(0), // pre defined and reserved
+		uint32(audioTimescale)<<16,
+	), dops)
+}
+
+// fragment returns the moof and mdat boxes of the samples
+func fragment(sequence uint32, runs []fmp4Run) []byte {
+	build := func(dataOffset uint32) []byte {
+		trafs := make([][]byte, 0, len(runs))
+		offset := dataOffset
+
+		for _, run := range runs {
+			// default-base-is-moof
+			tfhd := fullBox("tfhd", 0, 0x020000, fields(run.track.id))
+			tfdt := fullBox("tfdt", 1, 0, fields(run.baseTime))
+
+			entries := make([]byte, 0, len(run.samples)*12)
+			for _, sample := range run.samples {
+				flags := uint32(sampleFlagSync)
+				if run.track.video && !sample.keyframe {
+					flags = sampleFlagNonSync
+				}
+
+				entries = binary.BigEndian.AppendUint32(entries, sample.duration)
+				entries = binary.BigEndian.AppendUint32(entries, uint32(len(sample.data)))
+				entries = binary.BigEndian.AppendUint32(entries, flags)
+			}
+
+			// data offset, sample duration, sample size and sample flags are present
+			trun := fullBox("trun", 0, 0x000701, fields(uint32(len(run.samples)), offset), entries)
+
+			trafs = append(trafs, box("traf", tfhd, tfdt, trun))
+
+			for _, sample := range run.samples {
+				offset += uint32(len(sample.data))
+			}
+		}
+
+		return box("moof", append([][]byte{fullBox("mfhd", 0, 0, fields(sequence))}, trafs...)...)
+	}
+
+	// the size of moof is not changed by the offset value, build it twice to get the data offset
+	moof := build(0)
+	moof = build(uint32(len(moof)) + 8)
+
+	data := make([][]byte, 0)
+	for _, run := range runs {
+		for _, sample := range run.samples {
+			data = append(data, sample.data)
+		}
+	}
+
+	return append(moof, box("mdat", data...)...)
+}
@@ -0,0 +1,376 @@
+package hls
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	naluTypeIDR = 5
+	naluTypeSPS = 7
+	naluTypePPS = 8
+)
+
+var errInvalidSPS = errors.New("hls: invalid h264 sps")
+
+// splitNALUs returns the NAL units of the length prefixed sample
+func splitNALUs(sample []byte) [][]byte {
+	nalus := make([][]byte, 0)
+
+	for len(sample) > 4 {
+		size := int(binary.BigEndian.Uint32(sample))
+		sample = sample[4:]
+
+		if size == 0 || size > len(sample) {
+			break
+		}
+
+		nalus = append(nalus, sample[:size])
+		sample = sample[size:]
+	}
+
+	return nalus
+}
+
+func isH264Keyframe(sample []byte) bool {
+	for _, nalu := range splitNALUs(sample) {
+		if nalu[0]&0x1f == naluTypeIDR {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parameterSets returns the SPS and PPS of the sample if exists
+func parameterSets(sample []byte) (sps, pps []byte) {
+	for _, nalu := range splitNALUs(sample) {
+		switch nalu[0] & 0x1f {
+		case naluTypeSPS:
+			sps = nalu
+		case naluTypePPS:
+			pps = nalu
+		}
+	}
+
+	return sps, pps
+}
+
+// avcDecoderConfig builds the AVCDecoderConfigurationRecord that used in the avcC box
+func avcDecoderConfig(sps, pps []byte) []byte {
+	config := []byte{1, sps[1], sps[2], sps[3], 0xff, 0xe1}
+	config = binary.BigEndian.AppendUint16(config, uint16(len(sps)))
+	config = append(config, sps...)
+	config = append(config, 1)
+	config = binary.BigEndian.AppendUint16(config, uint16(len(pps)))
+
+	return append(config, pps...)
+}
+
+// h264CodecString returns the codec string for the CODECS attribute https://datatracker.ietf.org/doc/html/rfc6381#section-3.3
+func h264CodecString(sps []byte) string {
+	return fmt.Sprintf("avc1.%02x%02x%02x", sps[1], sps[2], sps[3])
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) bit() (uint32, error) {
+	if r.pos >= len(r.data)*8 {
+		return 0, errInvalidSPS
+	}
+
+	b := (r.data[r.pos/8] >> (7 - r.pos%8)) & 1
+	r.pos++
+
+	return uint32(b), nil
+}
+
+func (r *bitReader) bits(n int) (uint32, error) {
+	v := uint32(0)
+
+	for i := 0; i < n; i++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+
+		v = v<<1 | b
+	}
+
+	return v, nil
+}
+
+// ue reads an unsigned exp-golomb code
+func (r *bitReader) ue() (uint32, error) {
+	zeros := 0
+
+	for {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+
+		if b == 1 {
+			break
+		}
+
+		zeros++
+		if zeros > 31 {
+			return 0, errInvalidSPS
+		}
+	}
+
+	v, err := r.bits(zeros)
+	if err != nil {
+		return 0, err
+	}
+
+	return (1<<zeros - 1) + v, nil
+}
+
+func (r *bitReader) se() (int32, error) {
+	v, err := r.ue()
+	if err != nil {
+		return 0, err
+	}
+
+	if v%2 == 0 {
+		return -int32(v / 2), nil
+	}
+
+	return int32(v/2) + 1, nil
+}
+
+// removeEmulationPrevention removes the 0x03 byte from the 0x000003 sequence
+func removeEmulationPrevention(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+
+	for i := 0; i < len(data); i++ {
+		if i >= 2 && data[i] == 3 && data[i-1] == 0 && data[i-2] == 0 {
+			continue
+		}
+
+		out = append(out, data[i])
+	}
+
+	return out
+}
+
+// spsDimensions returns the frame size from the SPS https://www.itu.int/rec/T-REC-H.264 section 7.3.2.1.1
+func spsDimensions(sps []byte) (width, height uint16, err error) {
+	if len(sps) < 4 {
+		return 0, 0, errInvalidSPS
+	}
+
+	r := &bitReader{data: removeEmulationPrevention(sps[1:])}
+
+	profile, err := r.bits(8)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// constraint flags and level
+	if _, err = r.bits(16); err != nil {
+		return 0, 0, err
+	}
+
+	// seq_parameter_set_id
+	if _, err = r.ue(); err != nil {
+		return 0, 0, err
+	}
+
+	chromaFormat := uint32(1)
+
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		if chromaFormat, err = r.ue(); err != nil {
+			return 0, 0, err
+		}
+
+		if chromaFormat == 3 {
+			// separate_colour_plane_flag
+			if _, err = r.bit(); err != nil {
+				return 0, 0, err
+			}
+		}
+
+		// bit depth luma and chroma
+		if _, err = r.ue(); err != nil {
+			return 0, 0, err
+		}
+
+		if _, err = r.ue(); err != nil {
+			return 0, 0, err
+		}
+
+		// qpprime_y_zero_transform_bypass_flag
+		if _, err = r.bit(); err != nil {
+			return 0, 0, err
+		}
+
+		scalingMatrix, err := r.bit()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if scalingMatrix == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+
+			for i := 0; i < lists; i++ {
+				present, err := r.bit()
+				if err != nil {
+					return 0, 0, err
+				}
+
+				if present == 0 {
+					continue
+				}
+
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+
+				if err := skipScalingList(r, size); err != nil {
+					return 0, 0, err
+				}
+			}
+		}
+	}
+
+	// log2_max_frame_num_minus4
+	if _, err = r.ue(); err != nil {
+		return 0, 0, err
+	}
+
+	pocType, err := r.ue()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	switch pocType {
+	case 0:
+		if _, err = r.ue(); err != nil {
+			return 0, 0, err
+		}
+	case 1:
+		// delta_pic_order_always_zero_flag, offset_for_non_ref_pic, offset_for_top_to_bottom_field
+		if _, err = r.bit(); err != nil {
+			return 0, 0, err
+		}
+
+		if _, err = r.se(); err != nil {
+			return 0, 0, err
+		}
+
+		if _, err = r.se(); err != nil {
+			return 0, 0, err
+		}
+
+		cycle, err := r.ue()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		for i := uint32(0); i < cycle; i++ {
+			if _, err = r.se(); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	// max_num_ref_frames and gaps_in_frame_num_value_allowed_flag
+	if _, err = r.ue(); err != nil {
+		return 0, 0, err
+	}
+
+	if _, err = r.bit(); err != nil {
+		return 0, 0, err
+	}
+
+	widthInMbs, err := r.ue()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	heightInMapUnits, err := r.ue()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	frameMbsOnly, err := r.bit()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if frameMbsOnly == 0 {
+		// mb_adaptive_frame_field_flag
+		if _, err = r.bit(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// direct_8x8_inference_flag
+	if _, err = r.bit(); err != nil {
+		return 0, 0, err
+	}
+
+	w := (widthInMbs + 1) * 16
+	h := (2 - frameMbsOnly) * (heightInMapUnits + 1) * 16
+
+	cropping, err := r.bit()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if cropping == 1 {
+		crop := make([]uint32, 4)
+		for i := range crop {
+			if crop[i], err = r.ue(); err != nil {
+				return 0, 0, err
+			}
+		}
+
+		cropX, cropY := uint32(1), 2-frameMbsOnly
+		if chromaFormat == 1 || chromaFormat == 2 {
+			cropX = 2
+		}
+
+		if chromaFormat == 1 {
+			cropY *= 2
+		}
+
+		w -= (crop[0] + crop[1]) * cropX
+		h -= (crop[2] + crop[3]) * cropY
+	}
+
+	return uint16(w), uint16(h), nil
+}
+
+func skipScalingList(r *bitReader, size int) error {
+	last, next := int32(8), int32(8)
+
+	for i := 0; i < size; i++ {
+		if next != 0 {
+			delta, err := r.se()
+			if err != nil {
+				return err
+			}
+
+			next = (last + delta + 256) % 256
+		}
+
+		if next != 0 {
+			last = next
+		}
+	}
+
+	return nil
+}
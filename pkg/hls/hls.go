@@ -0,0 +1,320 @@
+// Package hls packages the tracks of a room into HLS playlists with fMP4 segments, so the viewers without WebRTC
+// can watch the room with a few seconds latency. Low latency HLS partial segments are enabled with Options.PartDuration.
+// The SFU never transcode the media, so only H264 video and Opus audio tracks are packaged.
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inlivedev/sfu"
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+)
+
+var (
+	ErrInvalidOptions = errors.New("hls: segment duration and window size must be greater than zero")
+	ErrClosed         = errors.New("hls: packager is closed")
+	ErrStreamNotFound = errors.New("hls: stream not found")
+)
+
+type Options struct {
+	// SegmentDuration is the target duration of a segment, the segment is cut on the first keyframe after the duration exceeded
+	SegmentDuration time.Duration `json:"segment_duration"`
+	// PartDuration is the target duration of the LL-HLS partial segment, zero to disable the low latency mode
+	PartDuration time.Duration `json:"part_duration"`
+	// WindowSize is the number of segments in the playlist, the older segments are deleted from the output directory
+	WindowSize int `json:"window_size"`
+	// OutputDir is the directory where the segments are written, each client stream is written to {OutputDir}/{roomID}/{clientID}
+	OutputDir string `json:"output_dir"`
+}
+
+func DefaultOptions() Options {
+	return Options{
+		SegmentDuration: 2 * time.Second,
+		WindowSize:      6,
+		OutputDir:       "hls",
+	}
+}
+
+// Packager creates a HLS stream for every client that publish tracks to the room
+// The packager is a http.Handler that serves the streams on /{clientID}/index.m3u8, mount it with http.StripPrefix:
+//
+//	http.Handle("/hls/"+room.ID()+"/", http.StripPrefix("/hls/"+room.ID(), packager))
+type Packager struct {
+	mu      sync.Mutex
+	context context.Context
+	cancel  context.CancelFunc
+	room    *sfu.Room
+	options Options
+	dir     string
+	streams map[string]*Stream
+	log     logging.LeveledLogger
+}
+
+func New(room *sfu.Room, opts Options) (*Packager, error) {
+	if opts.SegmentDuration <= 0 || opts.WindowSize <= 0 {
+		return nil, ErrInvalidOptions
+	}
+
+	if room.IsE2EE() {
+		return nil, sfu.ErrE2EENotSupported
+	}
+
+	dir := filepath.Join(opts.OutputDir, room.ID())
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(room.Context())
+
+	p := &Packager{
+		context: ctx,
+		cancel:  cancel,
+		room:    room,
+		options: opts,
+		dir:     dir,
+		streams: make(map[string]*Stream),
+		log:     logging.NewDefaultLoggerFactory().NewLogger("hls"),
+	}
+
+	room.SFU().OnTracksAvailable(p.addTracks)
+
+	room.OnRoomClosed(func(id string) {
+		_ = p.Close()
+	})
+
+	for _, client := range room.SFU().GetClients() {
+		p.addTracks(client.Tracks())
+	}
+
+	return p, nil
+}
+
+// Close stops all streams, the playlists are ended but the files are kept in the output directory
+func (p *Packager) Close() error {
+	p.mu.Lock()
+
+	if p.context.Err() != nil {
+		p.mu.Unlock()
+		return ErrClosed
+	}
+
+	p.cancel()
+
+	streams := make([]*Stream, 0, len(p.streams))
+	for _, stream := range p.streams {
+		streams = append(streams, stream)
+	}
+	p.mu.Unlock()
+
+	for _, stream := range streams {
+		stream.stop()
+	}
+
+	return nil
+}
+
+// Streams returns the client ID of the streams
+func (p *Packager) Streams() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ids := make([]string, 0, len(p.streams))
+	for id := range p.streams {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func (p *Packager) addTracks(tracks []sfu.ITrack) {
+	byClient := make(map[string][]sfu.ITrack)
+	for _, track := range tracks {
+		byClient[track.ClientID()] = append(byClient[track.ClientID()], track)
+	}
+
+	for clientID, clientTracks := range byClient {
+		if err := p.addStream(clientID, clientTracks); err != nil {
+			p.log.Errorf("hls: failed to create stream for client %s: %s", clientID, err.Error())
+		}
+	}
+}
+
+func (p *Packager) addStream(clientID string, tracks []sfu.ITrack) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.context.Err() != nil {
+		return nil
+	}
+
+	if stream, ok := p.streams[clientID]; ok {
+		stream.mu.Lock()
+		ended := stream.ended
+		stream.mu.Unlock()
+
+		if !ended {
+			p.log.Warnf("hls: client %s already has a stream, the new tracks are not packaged", clientID)
+			return nil
+		}
+	}
+
+	var video, audio sfu.ITrack
+
+	for _, track := range tracks {
+		if track.IsE2EE() {
+			p.log.Warnf("hls: track %s is not packaged: %s", track.ID(), sfu.ErrE2EENotSupported.Error())
+			continue
+		}
+
+		if !isSupportedTrack(track) {
+			p.log.Warnf("hls: track %s with codec %s is not supported", track.ID(), track.MimeType())
+			continue
+		}
+
+		if track.Kind() == webrtc.RTPCodecTypeVideo && video == nil {
+			video = track
+		} else if track.Kind() == webrtc.RTPCodecTypeAudio && audio == nil {
+			audio = track
+		}
+	}
+
+	if video == nil && audio == nil {
+		return nil
+	}
+
+	stream, err := newStream(p.context, clientID, filepath.Join(p.dir, clientID), p.options, p.log, video, audio)
+	if err != nil {
+		return err
+	}
+
+	p.streams[clientID] = stream
+
+	stream.start()
+
+	return nil
+}
+
+func (p *Packager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 {
+		http.Error(w, ErrStreamNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	p.mu.Lock()
+	stream, ok := p.streams[parts[0]]
+	p.mu.Unlock()
+
+	if !ok {
+		http.Error(w, ErrStreamNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	// wait up to 3 target durations for the blocking requests
+	timeout := 3 * p.options.SegmentDuration
+
+	name := path.Base(parts[1])
+
+	switch {
+	case name == "index.m3u8":
+		if !stream.wait(r.Context(), timeout, func() bool { return stream.initialized }) {
+			http.Error(w, "hls: stream is not started", http.StatusServiceUnavailable)
+			return
+		}
+
+		writePlaylist(w, stream.multivariantPlaylist())
+	case name == "media.m3u8":
+		p.serveMediaPlaylist(w, r, stream, timeout)
+	case name == initFileName:
+		if !stream.wait(r.Context(), timeout, func() bool { return stream.initialized }) {
+			http.Error(w, "hls: stream is not started", http.StatusServiceUnavailable)
+			return
+		}
+
+		serveFile(w, r, filepath.Join(stream.dir, name))
+	case strings.HasPrefix(name, "seg") && strings.HasSuffix(name, ".m4s"):
+		var sequence uint64
+
+		var index int
+
+		// the preload hint part is requested before it's available
+		if n, _ := fmt.Sscanf(name, "seg%d.%d.m4s", &sequence, &index); n == 2 {
+			stream.wait(r.Context(), timeout, func() bool { return stream.hasPart(sequence, index) })
+		}
+
+		serveFile(w, r, filepath.Join(stream.dir, name))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *Packager) serveMediaPlaylist(w http.ResponseWriter, r *http.Request, stream *Stream, timeout time.Duration) {
+	query := r.URL.Query()
+
+	if msn := query.Get("_HLS_msn"); msn != "" {
+		sequence, err := strconv.ParseUint(msn, 10, 64)
+		if err != nil {
+			http.Error(w, "hls: invalid _HLS_msn", http.StatusBadRequest)
+			return
+		}
+
+		index := -1
+
+		if partQuery := query.Get("_HLS_part"); partQuery != "" {
+			if index, err = strconv.Atoi(partQuery); err != nil {
+				http.Error(w, "hls: invalid _HLS_part", http.StatusBadRequest)
+				return
+			}
+		}
+
+		stream.mu.Lock()
+		current := stream.current.sequence
+		stream.mu.Unlock()
+
+		// the request is too far in the future
+		if sequence > current+2 {
+			http.Error(w, "hls: _HLS_msn is too far in the future", http.StatusBadRequest)
+			return
+		}
+
+		if !stream.wait(r.Context(), timeout, func() bool { return stream.hasPart(sequence, index) }) && r.Context().Err() == nil {
+			stream.mu.Lock()
+			ended := stream.ended
+			stream.mu.Unlock()
+
+			if !ended {
+				http.Error(w, "hls: timeout waiting the playlist update", http.StatusServiceUnavailable)
+				return
+			}
+		}
+	}
+
+	writePlaylist(w, stream.mediaPlaylist())
+}
+
+func writePlaylist(w http.ResponseWriter, playlist string) {
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write([]byte(playlist))
+}
+
+func serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	w.Header().Set("Content-Type", "video/mp4")
+	http.ServeFile(w, r, name)
+}
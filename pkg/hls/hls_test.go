@@ -0,0 +1,138 @@
+package hls
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/require"
+)
+
+// sps of the 320x180 test video in the media directory
+var testSPS, _ = hex.DecodeString("6764000dacb202833f3e0220000003002000000601e28549")
+
+func avcSample(nalus ...[]byte) []byte {
+	sample := make([]byte, 0)
+	for _, nalu := range nalus {
+		sample = binary.BigEndian.AppendUint32(sample, uint32(len(nalu)))
+		sample = append(sample, nalu...)
+	}
+
+	return sample
+}
+
+// readBoxes returns the type and payload of the top level boxes
+func readBoxes(t *testing.T, buf []byte) ([]string, [][]byte) {
+	t.Helper()
+
+	types := make([]string, 0)
+	payloads := make([][]byte, 0)
+
+	for len(buf) > 0 {
+		require.GreaterOrEqual(t, len(buf), 8)
+
+		size := int(binary.BigEndian.Uint32(buf))
+		require.GreaterOrEqual(t, len(buf), size)
+
+		types = append(types, string(buf[4:8]))
+		payloads = append(payloads, buf[8:size])
+		buf = buf[size:]
+	}
+
+	return types, payloads
+}
+
+func TestSPSDimensions(t *testing.T) {
+	width, height, err := spsDimensions(testSPS)
+	require.NoError(t, err)
+	require.Equal(t, uint16(320), width)
+	require.Equal(t, uint16(180), height)
+
+	require.Equal(t, "avc1.64000d", h264CodecString(testSPS))
+
+	_, _, err = spsDimensions([]byte{0x67})
+	require.ErrorIs(t, err, errInvalidSPS)
+}
+
+func TestFragment(t *testing.T) {
+	video := &fmp4Track{id: 1, video: true, timescale: videoTimescale}
+	audio := &fmp4Track{id: 2, timescale: audioTimescale, channels: 2}
+
+	types, _ := readBoxes(t, initSegment([]*fmp4Track{video, audio}))
+	require.Equal(t, []string{"ftyp", "moov"}, types)
+
+	data := fragment(1, []fmp4Run{
+		{track: video, baseTime: 0, samples: []fmp4Sample{{data: []byte{1, 2}, duration: 3000, keyframe: true}}},
+		{track: audio, baseTime: 0, samples: []fmp4Sample{{data: []byte{3}, duration: 960}, {data: []byte{4}, duration: 960}}},
+	})
+
+	types, payloads := readBoxes(t, data)
+	require.Equal(t, []string{"moof", "mdat"}, types)
+	require.Equal(t, []byte{1, 2, 3, 4}, payloads[1])
+
+	// the data offset of the audio run points to the first audio sample in mdat
+	_, trafs := readBoxes(t, payloads[0])
+	_, audioBoxes := readBoxes(t, trafs[2])
+	trun := audioBoxes[2]
+	require.Equal(t, uint32(2), binary.BigEndian.Uint32(trun[4:]))
+
+	offset := binary.BigEndian.Uint32(trun[8:])
+	require.Equal(t, byte(3), data[offset])
+}
+
+func TestStreamPlaylist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := DefaultOptions()
+	opts.SegmentDuration = time.Second
+	opts.PartDuration = 500 * time.Millisecond
+	opts.WindowSize = 2
+
+	dir := filepath.Join(t.TempDir(), "client")
+
+	stream, err := newStream(ctx, "client", dir, opts, logging.NewDefaultLoggerFactory().NewLogger("hls"), nil, nil)
+	require.NoError(t, err)
+
+	stream.video = &streamTrack{fmp4: &fmp4Track{id: 1, video: true, timescale: videoTimescale}}
+
+	keyframe := avcSample(testSPS, []byte{0x68, 0xeb}, []byte{0x65, 0x88})
+	delta := avcSample([]byte{0x41, 0x9a})
+
+	// 3 seconds of 30 fps video with a keyframe every second
+	for i := 0; i < 90; i++ {
+		sample := delta
+		if i%30 == 0 {
+			sample = keyframe
+		}
+
+		require.NoError(t, stream.writeSample(stream.video, sample, uint32(i*3000)))
+	}
+
+	stream.end()
+
+	playlist := stream.mediaPlaylist()
+	require.Contains(t, playlist, "#EXT-X-MAP:URI=\"init.mp4\"")
+	require.Contains(t, playlist, "#EXT-X-PART-INF:PART-TARGET=0.500")
+	require.Contains(t, playlist, "#EXT-X-ENDLIST")
+	// the window only keeps the last 2 segments
+	require.Contains(t, playlist, "#EXT-X-MEDIA-SEQUENCE:1")
+	require.Equal(t, 2, strings.Count(playlist, "#EXTINF:1.000,"))
+	require.Contains(t, playlist, "#EXT-X-PART:DURATION=0.500,URI=\"seg1.0.m4s\",INDEPENDENT=YES")
+
+	require.Contains(t, stream.multivariantPlaylist(), "CODECS=\"avc1.64000d\",RESOLUTION=320x180")
+
+	for _, name := range []string{"init.mp4", "seg1.m4s", "seg2.m4s", "seg1.1.m4s"} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		require.NoError(t, err, name)
+	}
+
+	require.True(t, stream.hasPart(2, -1))
+	require.False(t, stream.hasPart(3, 0))
+}
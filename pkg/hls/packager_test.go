@@ -0,0 +1,98 @@
+package hls
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/inlivedev/sfu"
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackager(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the peers are connected through the loopback host candidates
+	sfuOpts := sfu.DefaultOptions()
+	sfuOpts.IceServers = []webrtc.ICEServer{}
+	sfuOpts.SettingEngine.SetIncludeLoopbackCandidate(true)
+
+	roomManager := sfu.NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := sfu.DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	room, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-hls-room", sfu.RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	opts := DefaultOptions()
+	opts.OutputDir = t.TempDir()
+	opts.SegmentDuration = time.Second
+	opts.PartDuration = 500 * time.Millisecond
+
+	packager, err := New(room, opts)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(packager)
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.StatusCode, string(body)
+	}
+
+	code, _ := get("/unknown/index.m3u8")
+	require.Equal(t, http.StatusNotFound, code)
+
+	_, publisher, _, _ := sfu.CreatePeerPair(ctx, logging.NewDefaultLoggerFactory().NewLogger("test"), room, sfuOpts.IceServers, "publisher", true, false, true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 20*time.Second)
+	defer cancelTimeout()
+
+	for len(packager.Streams()) == 0 {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the stream")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	streamID := packager.Streams()[0]
+	require.Equal(t, publisher.ID(), streamID)
+
+	code, body := get("/" + streamID + "/index.m3u8")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, "CODECS=\"avc1.64000d,opus\"")
+
+	// blocking playlist reload until the first part of the second segment is available
+	code, body = get("/" + streamID + "/media.m3u8?_HLS_msn=1&_HLS_part=0")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, "seg0.m4s")
+	require.Contains(t, body, "seg1.0.m4s")
+
+	for _, name := range []string{"init.mp4", "seg0.m4s", "seg1.0.m4s"} {
+		code, _ = get("/" + streamID + "/" + name)
+		require.Equal(t, http.StatusOK, code, name)
+	}
+
+	code, _ = get("/" + streamID + "/media.m3u8?_HLS_msn=10")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	require.NoError(t, packager.Close())
+
+	_, body = get("/" + streamID + "/media.m3u8")
+	require.Contains(t, body, "#EXT-X-ENDLIST")
+}
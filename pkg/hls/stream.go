@@ -0,0 +1,700 @@
+package hls
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inlivedev/sfu"
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/samplebuilder"
+)
+
+const (
+	initFileName = "init.mp4"
+
+	// number of packets can be buffered before the packets dropped
+	packetBufferSize = 512
+
+	// the latest segments that the partial segments are listed in the playlist
+	partSegments = 3
+)
+
+type trackPacket struct {
+	track  *streamTrack
+	packet *rtp.Packet
+}
+
+type pendingSample struct {
+	data     []byte
+	time     uint64
+	keyframe bool
+}
+
+type streamTrack struct {
+	track   sfu.ITrack
+	fmp4    *fmp4Track
+	builder *samplebuilder.SampleBuilder
+	started bool
+	lastTS  uint32
+	elapsed int64
+	offset  int64
+	pending *pendingSample
+	// completed samples that not written to a fragment yet
+	samples      []fmp4Sample
+	baseTime     uint64
+	lastDuration uint32
+}
+
+type part struct {
+	index       int
+	duration    time.Duration
+	independent bool
+	data        []byte
+}
+
+type segment struct {
+	sequence uint64
+	duration time.Duration
+	parts    []*part
+}
+
+// Stream segments the video and audio tracks of a client into a single fMP4 rendition.
+// The segment is cut on the video keyframe, a keyframe is requested from the publisher when the segment duration is exceeded.
+type Stream struct {
+	mu          sync.Mutex
+	context     context.Context
+	cancel      context.CancelFunc
+	done        chan bool
+	stopOnce    sync.Once
+	clientID    string
+	dir         string
+	options     Options
+	log         logging.LeveledLogger
+	video       *streamTrack
+	audio       *streamTrack
+	packets     chan trackPacket
+	initialized bool
+	ended       bool
+	startTime   time.Time
+	lastPLI     time.Time
+	codecs      []string
+	fragmentSeq uint32
+	// completed segments in the playlist window
+	segments     []*segment
+	expired      []*segment
+	current      *segment
+	segmentStart uint64
+	partStart    uint64
+	totalBytes   int
+	totalTime    time.Duration
+	updated      chan bool
+}
+
+func newStream(ctx context.Context, clientID, dir string, opts Options, log logging.LeveledLogger, video, audio sfu.ITrack) (*Stream, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	localCtx, cancel := context.WithCancel(ctx)
+
+	s := &Stream{
+		context:  localCtx,
+		cancel:   cancel,
+		done:     make(chan bool),
+		clientID: clientID,
+		dir:      dir,
+		options:  opts,
+		log:      log,
+		packets:  make(chan trackPacket, packetBufferSize),
+		current:  &segment{},
+		updated:  make(chan bool),
+	}
+
+	if video != nil {
+		s.video = &streamTrack{
+			track:   video,
+			fmp4:    &fmp4Track{id: 1, video: true, timescale: videoTimescale},
+			builder: samplebuilder.New(256, &codecs.H264Packet{IsAVC: true}, videoTimescale),
+		}
+	}
+
+	if audio != nil {
+		s.audio = &streamTrack{
+			track:   audio,
+			fmp4:    &fmp4Track{id: 2, timescale: audioTimescale, channels: 2},
+			builder: samplebuilder.New(64, &codecs.OpusPacket{}, audioTimescale),
+		}
+
+		if s.video == nil {
+			s.audio.fmp4.id = 1
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Stream) ClientID() string {
+	return s.clientID
+}
+
+func (s *Stream) tracks() []*streamTrack {
+	tracks := make([]*streamTrack, 0, 2)
+
+	if s.video != nil {
+		tracks = append(tracks, s.video)
+	}
+
+	if s.audio != nil {
+		tracks = append(tracks, s.audio)
+	}
+
+	return tracks
+}
+
+// clock returns the track that decides when the parts and segments are cut
+func (s *Stream) clock() *streamTrack {
+	if s.video != nil {
+		return s.video
+	}
+
+	return s.audio
+}
+
+func (s *Stream) start() {
+	for _, st := range s.tracks() {
+		st := st
+
+		st.track.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, quality sfu.QualityLevel) {
+			if st.track.IsSimulcast() && quality != sfu.QualityHigh {
+				return
+			}
+
+			s.push(st, p)
+		})
+
+		st.track.OnEnded(func() {
+			s.stop()
+		})
+	}
+
+	go s.run()
+}
+
+// push is called from the track read loop, the packet is copied because it will be returned to the pool
+func (s *Stream) push(st *streamTrack, p *rtp.Packet) {
+	if s.context.Err() != nil {
+		return
+	}
+
+	select {
+	case s.packets <- trackPacket{track: st, packet: p.Clone()}:
+	default:
+		s.log.Warnf("hls: packet buffer is full, dropping packet of client %s", s.clientID)
+	}
+}
+
+func (s *Stream) run() {
+	defer close(s.done)
+
+	if s.video != nil {
+		s.requestKeyframe()
+	}
+
+	for {
+		select {
+		case <-s.context.Done():
+			s.end()
+			return
+		case p := <-s.packets:
+			p.track.builder.Push(p.packet)
+
+			for sample := p.track.builder.Pop(); sample != nil; sample = p.track.builder.Pop() {
+				if err := s.writeSample(p.track, sample.Data, sample.PacketTimestamp); err != nil {
+					s.log.Errorf("hls: failed to write sample of client %s: %s", s.clientID, err.Error())
+				}
+			}
+		}
+	}
+}
+
+func (s *Stream) stop() {
+	s.stopOnce.Do(func() {
+		s.cancel()
+		<-s.done
+	})
+}
+
+func (s *Stream) requestKeyframe() {
+	s.lastPLI = time.Now()
+
+	switch t := s.video.track.(type) {
+	case *sfu.Track:
+		t.RemoteTrack().SendPLI()
+	case *sfu.SimulcastTrack:
+		if remoteTrack := t.GetRemoteTrack(sfu.QualityHigh); remoteTrack != nil {
+			remoteTrack.SendPLI()
+		}
+	}
+}
+
+func (s *Stream) writeSample(st *streamTrack, data []byte, rtpTimestamp uint32) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	keyframe := !st.fmp4.video || isH264Keyframe(data)
+
+	if !s.initialized {
+		ready, err := s.initialize(st, data, keyframe)
+		if err != nil || !ready {
+			return err
+		}
+	}
+
+	t := s.decodeTime(st, rtpTimestamp)
+
+	if st.pending != nil {
+		duration := st.lastDuration
+		if t > st.pending.time {
+			duration = uint32(t - st.pending.time)
+		}
+
+		s.completeSample(st, duration)
+	}
+
+	if st == s.clock() {
+		if err := s.cut(st, t, keyframe); err != nil {
+			return err
+		}
+	}
+
+	st.pending = &pendingSample{data: data, time: t, keyframe: keyframe}
+
+	return nil
+}
+
+// initialize writes the init segment, the stream is started from the first video keyframe
+func (s *Stream) initialize(st *streamTrack, data []byte, keyframe bool) (bool, error) {
+	if s.video != nil {
+		if st != s.video {
+			return false, nil
+		}
+
+		if !keyframe {
+			if time.Since(s.lastPLI) > time.Second {
+				s.requestKeyframe()
+			}
+
+			return false, nil
+		}
+
+		sps, pps := parameterSets(data)
+		if sps == nil || pps == nil {
+			return false, nil
+		}
+
+		width, height, err := spsDimensions(sps)
+		if err != nil {
+			s.log.Warnf("hls: failed to read the video size of client %s: %s", s.clientID, err.Error())
+		}
+
+		s.video.fmp4.avcc = avcDecoderConfig(sps, pps)
+		s.video.fmp4.width, s.video.fmp4.height = width, height
+		s.codecs = append(s.codecs, h264CodecString(sps))
+	}
+
+	if s.audio != nil {
+		s.codecs = append(s.codecs, "opus")
+	}
+
+	fmp4Tracks := make([]*fmp4Track, 0, 2)
+	for _, track := range s.tracks() {
+		fmp4Tracks = append(fmp4Tracks, track.fmp4)
+	}
+
+	if err := os.WriteFile(filepath.Join(s.dir, initFileName), initSegment(fmp4Tracks), 0o644); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.initialized = true
+	s.startTime = time.Now()
+	s.notify()
+	s.mu.Unlock()
+
+	return true, nil
+}
+
+// decodeTime converts the RTP timestamp to the decode time from the stream start in the track timescale
+func (s *Stream) decodeTime(st *streamTrack, rtpTimestamp uint32) uint64 {
+	if !st.started {
+		st.started = true
+		st.lastTS = rtpTimestamp
+		st.offset = int64(time.Since(s.startTime)) * int64(st.fmp4.timescale) / int64(time.Second)
+	}
+
+	// the signed difference handles the timestamp wraparound
+	st.elapsed += int64(int32(rtpTimestamp - st.lastTS))
+	st.lastTS = rtpTimestamp
+
+	return uint64(max(st.offset+st.elapsed, 0))
+}
+
+func (s *Stream) completeSample(st *streamTrack, duration uint32) {
+	if len(st.samples) == 0 {
+		st.baseTime = st.pending.time
+	}
+
+	st.samples = append(st.samples, fmp4Sample{
+		data:     st.pending.data,
+		duration: duration,
+		keyframe: st.pending.keyframe,
+	})
+
+	st.lastDuration = duration
+	st.pending = nil
+}
+
+// cut flushes the completed samples into a new part or segment before the sample at time t is added
+func (s *Stream) cut(st *streamTrack, t uint64, keyframe bool) error {
+	timescale := st.fmp4.timescale
+	segmentDuration := toDuration(t-min(t, s.segmentStart), timescale)
+
+	if keyframe && segmentDuration >= s.options.SegmentDuration {
+		if err := s.flushPart(t); err != nil {
+			return err
+		}
+
+		s.segmentStart = t
+
+		return s.closeSegment()
+	}
+
+	if st.fmp4.video && segmentDuration >= s.options.SegmentDuration && time.Since(s.lastPLI) >= s.options.SegmentDuration {
+		s.requestKeyframe()
+	}
+
+	// cut the part before it's exceeding the part target duration when the next sample is added
+	if s.options.PartDuration > 0 && t > s.partStart &&
+		toDuration(t+uint64(st.lastDuration)-s.partStart, timescale) > s.options.PartDuration {
+		return s.flushPart(t)
+	}
+
+	return nil
+}
+
+func (s *Stream) flushPart(t uint64) error {
+	runs := make([]fmp4Run, 0, 2)
+	independent := false
+
+	for _, st := range s.tracks() {
+		if len(st.samples) == 0 {
+			continue
+		}
+
+		if st == s.clock() {
+			independent = st.samples[0].keyframe
+		}
+
+		runs = append(runs, fmp4Run{track: st.fmp4, baseTime: st.baseTime, samples: st.samples})
+
+		for _, sample := range st.samples {
+			st.baseTime += uint64(sample.duration)
+		}
+
+		st.samples = nil
+	}
+
+	if len(runs) == 0 {
+		return nil
+	}
+
+	s.fragmentSeq++
+	data := fragment(s.fragmentSeq, runs)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := &part{
+		index:       len(s.current.parts),
+		duration:    toDuration(t-min(t, s.partStart), s.clock().fmp4.timescale),
+		independent: independent,
+		data:        data,
+	}
+
+	s.partStart = t
+
+	if s.options.PartDuration > 0 {
+		if err := os.WriteFile(filepath.Join(s.dir, partFileName(s.current.sequence, p.index)), data, 0o644); err != nil {
+			return err
+		}
+	}
+
+	s.current.parts = append(s.current.parts, p)
+	s.current.duration += p.duration
+	s.totalBytes += len(data)
+	s.totalTime += p.duration
+	s.notify()
+
+	return nil
+}
+
+func (s *Stream) closeSegment() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.current.parts) == 0 {
+		return nil
+	}
+
+	data := make([]byte, 0)
+	for _, p := range s.current.parts {
+		data = append(data, p.data...)
+		p.data = nil
+	}
+
+	if err := os.WriteFile(filepath.Join(s.dir, segmentFileName(s.current.sequence)), data, 0o644); err != nil {
+		return err
+	}
+
+	s.segments = append(s.segments, s.current)
+	s.current = &segment{sequence: s.current.sequence + 1}
+
+	for len(s.segments) > s.options.WindowSize {
+		s.expired = append(s.expired, s.segments[0])
+		s.segments = s.segments[1:]
+	}
+
+	// keep the expired segments for a while, the player might still downloading them
+	for len(s.expired) > 2 {
+		s.removeFiles(s.expired[0])
+		s.expired = s.expired[1:]
+	}
+
+	s.notify()
+
+	return nil
+}
+
+func (s *Stream) removeFiles(seg *segment) {
+	_ = os.Remove(filepath.Join(s.dir, segmentFileName(seg.sequence)))
+
+	if s.options.PartDuration > 0 {
+		for _, p := range seg.parts {
+			_ = os.Remove(filepath.Join(s.dir, partFileName(seg.sequence, p.index)))
+		}
+	}
+}
+
+// end flushes the remaining samples and ends the playlist
+func (s *Stream) end() {
+	if s.initialized {
+		end := uint64(0)
+
+		for _, st := range s.tracks() {
+			if st.pending == nil {
+				continue
+			}
+
+			pendingTime := st.pending.time
+
+			s.completeSample(st, st.lastDuration)
+
+			if st == s.clock() {
+				end = pendingTime + uint64(st.lastDuration)
+			}
+		}
+
+		if err := s.flushPart(max(end, s.partStart)); err != nil {
+			s.log.Errorf("hls: failed to write the last part of client %s: %s", s.clientID, err.Error())
+		}
+
+		if err := s.closeSegment(); err != nil {
+			s.log.Errorf("hls: failed to write the last segment of client %s: %s", s.clientID, err.Error())
+		}
+	}
+
+	s.mu.Lock()
+	s.ended = true
+	s.notify()
+	s.mu.Unlock()
+}
+
+// notify wakes up the blocking playlist requests, must be called with the lock held
+func (s *Stream) notify() {
+	close(s.updated)
+	s.updated = make(chan bool)
+}
+
+// wait blocks until the condition is true or the stream ended, the condition is called with the lock held
+func (s *Stream) wait(ctx context.Context, timeout time.Duration, condition func() bool) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		if condition() {
+			s.mu.Unlock()
+			return true
+		}
+
+		if s.ended {
+			s.mu.Unlock()
+			return false
+		}
+
+		updated := s.updated
+		s.mu.Unlock()
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// hasPart returns true if the playlist already contains the part of the segment, a negative part means the whole segment
+func (s *Stream) hasPart(sequence uint64, partIndex int) bool {
+	if sequence < s.current.sequence {
+		return true
+	}
+
+	return sequence == s.current.sequence && partIndex >= 0 && partIndex < len(s.current.parts)
+}
+
+func (s *Stream) targetDuration() int {
+	target := s.options.SegmentDuration
+
+	for _, seg := range s.segments {
+		target = max(target, seg.duration)
+	}
+
+	return int(math.Ceil(target.Seconds()))
+}
+
+// multivariantPlaylist returns the playlist that describes the rendition of the stream
+func (s *Stream) multivariantPlaylist() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// use 2 Mbps until the bitrate is known
+	bandwidth := 2000000
+	if s.totalTime > 0 {
+		bandwidth = int(float64(s.totalBytes*8) / s.totalTime.Seconds())
+	}
+
+	b := &strings.Builder{}
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	fmt.Fprintf(b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=\"%s\"", bandwidth, strings.Join(s.codecs, ","))
+
+	if s.video != nil && s.video.fmp4.width > 0 {
+		fmt.Fprintf(b, ",RESOLUTION=%dx%d", s.video.fmp4.width, s.video.fmp4.height)
+	}
+
+	b.WriteString("\nmedia.m3u8\n")
+
+	return b.String()
+}
+
+// mediaPlaylist returns the playlist of the segments in the window https://datatracker.ietf.org/doc/html/draft-pantos-hls-rfc8216bis
+func (s *Stream) mediaPlaylist() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lowLatency := s.options.PartDuration > 0
+
+	b := &strings.Builder{}
+	b.WriteString("#EXTM3U\n")
+
+	if lowLatency {
+		b.WriteString("#EXT-X-VERSION:9\n")
+	} else {
+		b.WriteString("#EXT-X-VERSION:7\n")
+	}
+
+	fmt.Fprintf(b, "#EXT-X-TARGETDURATION:%d\n", s.targetDuration())
+
+	sequence := s.current.sequence
+	if len(s.segments) > 0 {
+		sequence = s.segments[0].sequence
+	}
+
+	fmt.Fprintf(b, "#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+
+	if lowLatency {
+		partTarget := s.options.PartDuration.Seconds()
+		fmt.Fprintf(b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*partTarget)
+		fmt.Fprintf(b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget)
+	}
+
+	fmt.Fprintf(b, "#EXT-X-MAP:URI=\"%s\"\n", initFileName)
+
+	for i, seg := range s.segments {
+		if lowLatency && i >= len(s.segments)-partSegments {
+			writeParts(b, seg)
+		}
+
+		fmt.Fprintf(b, "#EXTINF:%.3f,\n%s\n", seg.duration.Seconds(), segmentFileName(seg.sequence))
+	}
+
+	if s.ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	} else if lowLatency {
+		writeParts(b, s.current)
+		fmt.Fprintf(b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", partFileName(s.current.sequence, len(s.current.parts)))
+	}
+
+	return b.String()
+}
+
+func writeParts(b *strings.Builder, seg *segment) {
+	for _, p := range seg.parts {
+		fmt.Fprintf(b, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", p.duration.Seconds(), partFileName(seg.sequence, p.index))
+
+		if p.independent {
+			b.WriteString(",INDEPENDENT=YES")
+		}
+
+		b.WriteString("\n")
+	}
+}
+
+func segmentFileName(sequence uint64) string {
+	return fmt.Sprintf("seg%d.m4s", sequence)
+}
+
+func partFileName(sequence uint64, index int) string {
+	return fmt.Sprintf("seg%d.%d.m4s", sequence, index)
+}
+
+func toDuration(v uint64, timescale uint32) time.Duration {
+	return time.Duration(v) * time.Second / time.Duration(timescale)
+}
+
+func isSupportedTrack(track sfu.ITrack) bool {
+	switch strings.ToLower(track.MimeType()) {
+	case strings.ToLower(webrtc.MimeTypeH264), strings.ToLower(webrtc.MimeTypeOpus):
+		return true
+	default:
+		return false
+	}
+}
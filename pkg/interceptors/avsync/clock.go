@@ -0,0 +1,170 @@
+package avsync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// CaptureClock estimates the capture time of the packets of a received stream. The capture time is read from the
+// abs-capture-time extension when the packet has it, otherwise it's extrapolated from the last known capture time or
+// from the RTCP sender reports of the publisher. The capture time is the same for all layers of the same frame, so it
+// survives the simulcast layer switches and the relays that rewrite the RTP timestamp.
+type CaptureClock struct {
+	mu        sync.Mutex
+	clockRate float64
+	// the last sender report, it maps the RTP timestamp to the sender clock
+	hasReport bool
+	reportTS  uint32
+	reportNTP time.Time
+	// the smoothed offset from the sender clock to the local clock, it includes the network delay
+	senderOffset time.Duration
+	// the reference that the capture time of the next packets is extrapolated from
+	hasCapture bool
+	fromExt    bool
+	captureTS  uint32
+	capture    Capture
+}
+
+func NewCaptureClock(clockRate uint32) *CaptureClock {
+	return &CaptureClock{
+		clockRate: float64(clockRate),
+	}
+}
+
+// OnSenderReport updates the offset of the sender clock with the sender report that received at the time
+func (c *CaptureClock) OnSenderReport(report *rtcp.SenderReport, received time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ntp := FromNTP(report.NTPTime)
+	offset := received.Sub(ntp)
+
+	if !c.hasReport {
+		c.senderOffset = offset
+	} else {
+		c.senderOffset += (offset - c.senderOffset) / 16
+	}
+
+	c.hasReport = true
+	c.reportTS = report.RTPTime
+	c.reportNTP = ntp
+}
+
+// Update updates the capture time reference with the received packet. extID is the ID of the negotiated
+// abs-capture-time extension, 0 if it's not negotiated.
+func (c *CaptureClock) Update(header *rtp.Header, extID uint8, received time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if extID != 0 {
+		if payload := header.GetExtension(extID); payload != nil {
+			ext := rtp.AbsCaptureTimeExtension{}
+			if err := ext.Unmarshal(payload); err == nil {
+				capture := Capture{
+					Time:   ext.CaptureTime(),
+					Offset: c.senderOffset,
+				}
+
+				if offset := ext.EstimatedCaptureClockOffsetDuration(); offset != nil {
+					capture.Offset += *offset
+				}
+
+				c.hasCapture = true
+				c.fromExt = true
+				c.captureTS = header.Timestamp
+				c.capture = capture
+
+				return
+			}
+		}
+	}
+
+	if c.hasCapture {
+		return
+	}
+
+	// the publisher doesn't send the extension, generate it from the sender report or the first received packet
+	c.hasCapture = true
+	c.captureTS = header.Timestamp
+
+	if c.hasReport {
+		c.capture = c.fromReport(header.Timestamp)
+	} else {
+		c.capture = Capture{Time: received}
+	}
+}
+
+// Capture returns the capture time of the packet with the RTP timestamp, false if no packet is received yet
+func (c *CaptureClock) Capture(ts uint32) (Capture, bool) {
+	if c == nil {
+		return Capture{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.hasCapture {
+		return Capture{}, false
+	}
+
+	// the generated capture time follows the sender reports once they're received
+	if !c.fromExt && c.hasReport {
+		return c.fromReport(ts), true
+	}
+
+	capture := c.capture
+	capture.Time = capture.Time.Add(c.elapsed(c.captureTS, ts))
+
+	return capture, true
+}
+
+func (c *CaptureClock) fromReport(ts uint32) Capture {
+	return Capture{
+		Time:   c.reportNTP.Add(c.elapsed(c.reportTS, ts)),
+		Offset: c.senderOffset,
+	}
+}
+
+// elapsed returns the duration from the RTP timestamp from to the RTP timestamp to, negative if to is older
+func (c *CaptureClock) elapsed(from, to uint32) time.Duration {
+	if c.clockRate == 0 {
+		return 0
+	}
+
+	return time.Duration(float64(int32(to-from)) / c.clockRate * float64(time.Second))
+}
+
+// SyncInfo returns the mapping of the RTP timestamp of the received stream to the capture time in the local clock, false
+// if no packet is received yet. The tracks of the same publisher can be aligned with it, like the sender reports.
+func (c *CaptureClock) SyncInfo() (SyncInfo, bool) {
+	if c == nil {
+		return SyncInfo{}, false
+	}
+
+	c.mu.Lock()
+	ts := c.captureTS
+	c.mu.Unlock()
+
+	capture, ok := c.Capture(ts)
+	if !ok {
+		return SyncInfo{}, false
+	}
+
+	return SyncInfo{
+		ClockRate:   uint32(c.clockRate),
+		NTPTime:     capture.Time.Add(capture.Offset),
+		RTPTime:     ts,
+		FromCapture: true,
+	}, true
+}
@@ -0,0 +1,478 @@
+package avsync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	AbsCaptureTimeURI = "http://www.webrtc.org/experiments/rtp-hdrext/abs-capture-time"
+	// the default interval of the RTCP sender reports, the same with the pion report interceptor
+	DefaultInterval = 1 * time.Second
+	// the resolution of the report intervals, the interval of a stream is rounded up to it
+	reportResolution = 100 * time.Millisecond
+)
+
+// Capture is the capture time of a packet
+type Capture struct {
+	// Time is the capture time in the clock of the capture system, like the publisher
+	Time time.Time
+	// Offset is the estimated offset from the capture system clock to the sender clock, Time+Offset is the capture
+	// time in the sender clock
+	Offset time.Duration
+}
+
+// SyncInfo maps the RTP timestamp of a stream to the wall clock, it's the same mapping that sent in the RTCP sender
+// reports of the stream. The RTP timestamp ts is at NTPTime + (ts - RTPTime) / ClockRate.
+type SyncInfo struct {
+	SSRC      uint32    `json:"ssrc"`
+	ClockRate uint32    `json:"clock_rate"`
+	NTPTime   time.Time `json:"ntp_time"`
+	RTPTime   uint32    `json:"rtp_time"`
+	// the number of the sent packets and bytes, always 0 for the received stream
+	PacketCount uint32 `json:"packet_count"`
+	OctetCount  uint32 `json:"octet_count"`
+	// the number of the sent sender reports and the time of the last one, always 0 for the received stream
+	ReportsSent uint64    `json:"reports_sent"`
+	LastReport  time.Time `json:"last_report"`
+	// true if the RTP timestamp is mapped to the capture time, false if it's mapped to the time the packet is sent
+	FromCapture bool `json:"from_capture"`
+}
+
+type InterceptorFactory struct {
+	onNew    func(i *Interceptor)
+	interval time.Duration
+	log      logging.LeveledLogger
+}
+
+func NewInterceptor(log logging.LeveledLogger, interval time.Duration) *InterceptorFactory {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	return &InterceptorFactory{
+		interval: interval,
+		log:      log,
+	}
+}
+
+// NewInterceptor constructs a new A/V sync Interceptor
+func (g *InterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	i := new(g.log, g.interval)
+
+	if g.onNew != nil {
+		g.onNew(i)
+	}
+
+	return i, nil
+}
+
+func (g *InterceptorFactory) OnNew(callback func(i *Interceptor)) {
+	g.onNew = callback
+}
+
+// Interceptor sends the RTCP sender reports of the outgoing streams and sets the abs-capture-time extension on the
+// outgoing packets. It replaces the sender reports of the pion report interceptor, those reports map the RTP timestamp
+// to the time the packet is sent, so the audio and the video that have the different forwarding delay are out of sync
+// on the receiver. When the capture time of the packets is set with SetCaptureTime, the reports map the RTP timestamp
+// to the capture time instead, so the receiver can sync the streams that captured by the same system.
+type Interceptor struct {
+	interceptor.NoOp
+	mu       sync.RWMutex
+	log      logging.LeveledLogger
+	interval time.Duration
+	streams  map[uint32]*localStream
+	clocks   map[uint32]*CaptureClock
+	close    chan struct{}
+	closeMu  sync.Mutex
+	wg       sync.WaitGroup
+}
+
+type localStream struct {
+	mu        sync.Mutex
+	clockRate float64
+	extID     uint8
+	// the last sent packet
+	started     bool
+	lastTS      uint32
+	lastSent    time.Time
+	packetCount uint32
+	octetCount  uint32
+	// the capture time of the next packet that written with the timestamp
+	pendingTS      uint32
+	pending        *Capture
+	hasCapture     bool
+	captureTS      uint32
+	captureInLocal time.Time
+	// the smoothed time between the capture and the sending
+	delay time.Duration
+	// the interval of the sender reports, 0 uses the interceptor interval
+	interval    time.Duration
+	lastReport  time.Time
+	reportsSent uint64
+}
+
+func new(log logging.LeveledLogger, interval time.Duration) *Interceptor {
+	return &Interceptor{
+		log:      log,
+		interval: interval,
+		streams:  make(map[uint32]*localStream),
+		clocks:   make(map[uint32]*CaptureClock),
+		close:    make(chan struct{}),
+	}
+}
+
+func (i *Interceptor) stream(ssrc uint32) *localStream {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	stream, ok := i.streams[ssrc]
+	if !ok {
+		stream = &localStream{}
+		i.streams[ssrc] = stream
+	}
+
+	return stream
+}
+
+// CaptureClock returns the capture clock of the received stream, it's updated with the sender reports of the stream
+func (i *Interceptor) CaptureClock(ssrc uint32, clockRate uint32) *CaptureClock {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	clock, ok := i.clocks[ssrc]
+	if !ok {
+		clock = NewCaptureClock(clockRate)
+		i.clocks[ssrc] = clock
+	}
+
+	return clock
+}
+
+// SetCaptureTime sets the capture time of the next packet of the stream, it's used if the next written packet has
+// the same timestamp. Call it right before the packet is written.
+func (i *Interceptor) SetCaptureTime(ssrc uint32, ts uint32, capture Capture) {
+	stream := i.stream(ssrc)
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	stream.pendingTS = ts
+	stream.pending = &capture
+}
+
+// SetReportInterval sets the interval of the sender reports of the stream, the shorter interval lets the receiver sync the
+// stream faster after it's started. 0 uses the interval of the interceptor. It can be set before the stream is bound.
+func (i *Interceptor) SetReportInterval(ssrc uint32, interval time.Duration) {
+	stream := i.stream(ssrc)
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	stream.interval = max(interval, 0)
+}
+
+// SyncInfo returns the mapping of the RTP timestamp to the wall clock of the sent stream, false if no packet is sent yet
+func (i *Interceptor) SyncInfo(ssrc uint32) (SyncInfo, bool) {
+	i.mu.RLock()
+	stream, ok := i.streams[ssrc]
+	i.mu.RUnlock()
+
+	if !ok {
+		return SyncInfo{}, false
+	}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	if !stream.started || stream.clockRate == 0 {
+		return SyncInfo{}, false
+	}
+
+	now := time.Now()
+
+	return SyncInfo{
+		SSRC:        ssrc,
+		ClockRate:   uint32(stream.clockRate),
+		NTPTime:     now,
+		RTPTime:     stream.rtpTime(now),
+		PacketCount: stream.packetCount,
+		OctetCount:  stream.octetCount,
+		ReportsSent: stream.reportsSent,
+		LastReport:  stream.lastReport,
+		FromCapture: stream.hasCapture,
+	}, true
+}
+
+// Delay returns the smoothed time between the capture and the sending of the stream packets, false if there is no
+// packet sent with the capture time yet
+func (i *Interceptor) Delay(ssrc uint32) (time.Duration, bool) {
+	i.mu.RLock()
+	stream, ok := i.streams[ssrc]
+	i.mu.RUnlock()
+
+	if !ok {
+		return 0, false
+	}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	return stream.delay, stream.hasCapture
+}
+
+// BindLocalStream lets you modify any outgoing RTP packets. It is called once for per LocalStream. The returned method
+// will be called once per rtp packet.
+func (i *Interceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	stream := i.stream(info.SSRC)
+
+	stream.mu.Lock()
+	stream.clockRate = float64(info.ClockRate)
+	for _, extension := range info.RTPHeaderExtensions {
+		if extension.URI == AbsCaptureTimeURI {
+			stream.extID = uint8(extension.ID)
+		}
+	}
+	stream.mu.Unlock()
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		stream.processRTP(time.Now(), header, payload, i.log)
+
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// UnbindLocalStream is called when the Stream is removed. It can be used to clean up any data related to that track.
+func (i *Interceptor) UnbindLocalStream(info *interceptor.StreamInfo) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.streams, info.SSRC)
+}
+
+// BindRTCPReader lets you modify any incoming RTCP packets. It is called once per sender/receiver, however this might
+// change in the future. The returned method will be called once per packet batch.
+func (i *Interceptor) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if attr == nil {
+			attr = make(interceptor.Attributes)
+		}
+
+		pkts, err := attr.GetRTCPPackets(b[:n])
+		if err != nil {
+			return 0, nil, err
+		}
+
+		now := time.Now()
+
+		for _, pkt := range pkts {
+			if report, ok := pkt.(*rtcp.SenderReport); ok {
+				i.mu.RLock()
+				clock := i.clocks[report.SSRC]
+				i.mu.RUnlock()
+
+				clock.OnSenderReport(report, now)
+			}
+		}
+
+		return n, attr, nil
+	})
+}
+
+// BindRemoteStream lets you modify any incoming RTP packets. It is called once for per RemoteStream. The returned method
+// will be called once per rtp packet.
+func (i *Interceptor) BindRemoteStream(info *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	i.CaptureClock(info.SSRC, info.ClockRate)
+
+	return reader
+}
+
+// UnbindRemoteStream is called when the Stream is removed. It can be used to clean up any data related to that track.
+func (i *Interceptor) UnbindRemoteStream(info *interceptor.StreamInfo) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.clocks, info.SSRC)
+}
+
+// BindRTCPWriter lets you modify any outgoing RTCP packets. It is called once per PeerConnection. The returned method
+// will be called once per packet batch.
+func (i *Interceptor) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
+	i.wg.Add(1)
+
+	go i.loop(writer)
+
+	return writer
+}
+
+func (i *Interceptor) Close() error {
+	i.closeMu.Lock()
+	select {
+	case <-i.close:
+	default:
+		close(i.close)
+	}
+	i.closeMu.Unlock()
+
+	i.wg.Wait()
+
+	return nil
+}
+
+func (i *Interceptor) loop(writer interceptor.RTCPWriter) {
+	defer i.wg.Done()
+
+	ticker := time.NewTicker(min(i.interval, reportResolution))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-i.close:
+			return
+		case now := <-ticker.C:
+			i.mu.RLock()
+			reports := make([]rtcp.Packet, 0, len(i.streams))
+			for ssrc, stream := range i.streams {
+				if report := stream.generateReport(ssrc, now, i.interval); report != nil {
+					reports = append(reports, report)
+				}
+			}
+			i.mu.RUnlock()
+
+			for _, report := range reports {
+				if _, err := writer.Write([]rtcp.Packet{report}, interceptor.Attributes{}); err != nil {
+					i.log.Tracef("avsync: error on write sender report %s", err.Error())
+				}
+			}
+		}
+	}
+}
+
+func (s *localStream) processRTP(now time.Time, header *rtp.Header, payload []byte, log logging.LeveledLogger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.started = true
+	s.lastTS = header.Timestamp
+	s.lastSent = now
+	s.packetCount++
+	s.octetCount += uint32(len(payload))
+
+	if s.pending == nil || s.pendingTS != header.Timestamp {
+		return
+	}
+
+	capture := *s.pending
+	s.pending = nil
+
+	s.hasCapture = true
+	s.captureTS = header.Timestamp
+	s.captureInLocal = capture.Time.Add(capture.Offset)
+
+	delay := now.Sub(s.captureInLocal)
+	if s.delay == 0 {
+		s.delay = delay
+	} else {
+		s.delay += (delay - s.delay) / 16
+	}
+
+	if s.extID == 0 {
+		return
+	}
+
+	ext := rtp.NewAbsCaptureTimeExtensionWithCaptureClockOffset(capture.Time, capture.Offset)
+
+	extPayload, err := ext.Marshal()
+	if err != nil {
+		return
+	}
+
+	// the extensions are shared with the other subscribers of the packet, set the extension on a copy
+	extensions := make([]rtp.Extension, len(header.Extensions), len(header.Extensions)+1)
+	copy(extensions, header.Extensions)
+	header.Extensions = extensions
+
+	if err := header.SetExtension(s.extID, extPayload); err != nil {
+		log.Tracef("avsync: error on set abs capture time extension %s", err.Error())
+	}
+}
+
+// generateReport returns the sender report of the stream, nil if the stream is not started or the report is not due yet
+func (s *localStream) generateReport(ssrc uint32, now time.Time, interval time.Duration) *rtcp.SenderReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started || s.clockRate == 0 {
+		return nil
+	}
+
+	if s.interval > 0 {
+		interval = s.interval
+	}
+
+	// the ticker may fire slightly earlier than the interval
+	if !s.lastReport.IsZero() && now.Sub(s.lastReport) < interval-reportResolution/2 {
+		return nil
+	}
+
+	s.lastReport = now
+	s.reportsSent++
+
+	return &rtcp.SenderReport{
+		SSRC:        ssrc,
+		NTPTime:     ToNTP(now),
+		RTPTime:     s.rtpTime(now),
+		PacketCount: s.packetCount,
+		OctetCount:  s.octetCount,
+	}
+}
+
+// rtpTime returns the RTP timestamp that corresponds to the time, from the capture time if it's known
+func (s *localStream) rtpTime(now time.Time) uint32 {
+	if s.hasCapture {
+		return s.captureTS + uint32(int64(now.Sub(s.captureInLocal).Seconds()*s.clockRate))
+	}
+
+	return s.lastTS + uint32(now.Sub(s.lastSent).Seconds()*s.clockRate)
+}
+
+// ToNTP converts the time to the 64 bits NTP timestamp, RFC 5905 section 6
+func ToNTP(t time.Time) uint64 {
+	nsec := uint64(t.Sub(ntpEpoch))
+
+	sec := nsec / uint64(time.Second)
+	frac := ((nsec % uint64(time.Second)) << 32) / uint64(time.Second)
+
+	return sec<<32 | frac
+}
+
+// FromNTP converts the 64 bits NTP timestamp to the time
+func FromNTP(ntp uint64) time.Time {
+	sec := ntp >> 32
+	frac := ntp & 0xffffffff
+
+	return ntpEpoch.Add(time.Duration(sec)*time.Second + time.Duration((frac*uint64(time.Second))>>32))
+}
+
+var ntpEpoch = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func RegisterAbsCaptureTimeHeaderExtension(m *webrtc.MediaEngine) {
+	if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: AbsCaptureTimeURI}, webrtc.RTPCodecTypeAudio); err != nil {
+		panic(err)
+	}
+
+	if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: AbsCaptureTimeURI}, webrtc.RTPCodecTypeVideo); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,179 @@
+package avsync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNTP(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 600000000, time.UTC)
+
+	require.WithinDuration(t, now, FromNTP(ToNTP(now)), time.Microsecond)
+}
+
+func TestCaptureClockExtension(t *testing.T) {
+	clock := NewCaptureClock(90000)
+
+	_, ok := clock.Capture(1000)
+	require.False(t, ok)
+
+	captured := time.Now().Add(-50 * time.Millisecond)
+	ext, err := rtp.NewAbsCaptureTimeExtensionWithCaptureClockOffset(captured, 10*time.Millisecond).Marshal()
+	require.NoError(t, err)
+
+	header := &rtp.Header{Timestamp: 1000}
+	require.NoError(t, header.SetExtension(3, ext))
+
+	clock.Update(header, 3, time.Now())
+
+	capture, ok := clock.Capture(1000)
+	require.True(t, ok)
+	require.WithinDuration(t, captured, capture.Time, time.Millisecond)
+	require.InDelta(t, 10*time.Millisecond, capture.Offset, float64(time.Millisecond))
+
+	// the packets without the extension are extrapolated from the last extension, 9000 is 100ms in 90kHz
+	clock.Update(&rtp.Header{Timestamp: 10000}, 3, time.Now())
+
+	capture, ok = clock.Capture(10000)
+	require.True(t, ok)
+	require.WithinDuration(t, captured.Add(100*time.Millisecond), capture.Time, time.Millisecond)
+
+	// the older timestamp, including the one before the wrap around
+	before := uint32(1000)
+	capture, ok = clock.Capture(before - 9000)
+	require.True(t, ok)
+	require.WithinDuration(t, captured.Add(-100*time.Millisecond), capture.Time, time.Millisecond)
+}
+
+func TestCaptureClockSenderReport(t *testing.T) {
+	clock := NewCaptureClock(48000)
+	now := time.Now()
+
+	// generated from the first packet until the sender report is received
+	clock.Update(&rtp.Header{Timestamp: 4800}, 0, now)
+
+	capture, ok := clock.Capture(4800)
+	require.True(t, ok)
+	require.Equal(t, now, capture.Time)
+	require.Zero(t, capture.Offset)
+
+	// the sender clock is 2 seconds behind the local clock
+	senderNow := now.Add(-2 * time.Second)
+	clock.OnSenderReport(&rtcp.SenderReport{NTPTime: ToNTP(senderNow), RTPTime: 48000}, now)
+
+	capture, ok = clock.Capture(48000 + 4800)
+	require.True(t, ok)
+	require.WithinDuration(t, senderNow.Add(100*time.Millisecond), capture.Time, time.Millisecond)
+	require.InDelta(t, 2*time.Second, capture.Offset, float64(time.Millisecond))
+	require.WithinDuration(t, now.Add(100*time.Millisecond), capture.Time.Add(capture.Offset), time.Millisecond)
+
+	info, ok := clock.SyncInfo()
+	require.True(t, ok)
+	require.Equal(t, uint32(4800), info.RTPTime)
+	require.WithinDuration(t, now.Add(-900*time.Millisecond), info.NTPTime, time.Millisecond)
+}
+
+func TestInterceptorSenderReport(t *testing.T) {
+	factory := NewInterceptor(logging.NewDefaultLoggerFactory().NewLogger("test"), time.Hour)
+
+	i, err := factory.NewInterceptor("")
+	require.NoError(t, err)
+
+	defer i.Close()
+
+	avsync := i.(*Interceptor)
+
+	var written rtp.Header
+	writer := avsync.BindLocalStream(&interceptor.StreamInfo{
+		SSRC:                1111,
+		ClockRate:           90000,
+		RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: AbsCaptureTimeURI, ID: 4}},
+	}, interceptor.RTPWriterFunc(func(header *rtp.Header, _ []byte, _ interceptor.Attributes) (int, error) {
+		written = *header
+		return 0, nil
+	}))
+
+	captured := time.Now().Add(-200 * time.Millisecond)
+	avsync.SetCaptureTime(1111, 90000, Capture{Time: captured})
+
+	// the extensions are shared with the other subscribers
+	shared := make([]rtp.Extension, 0, 4)
+	header := &rtp.Header{SSRC: 1111, Timestamp: 90000, Extensions: shared}
+	_, err = writer.Write(header, []byte{0x01}, nil)
+	require.NoError(t, err)
+	require.Zero(t, shared[:1][0])
+
+	ext := rtp.AbsCaptureTimeExtension{}
+	require.NoError(t, ext.Unmarshal(written.GetExtension(4)))
+	require.WithinDuration(t, captured, ext.CaptureTime(), time.Millisecond)
+
+	delay, ok := avsync.Delay(1111)
+	require.True(t, ok)
+	require.InDelta(t, 200*time.Millisecond, delay, float64(10*time.Millisecond))
+
+	// the report maps the RTP timestamp to the capture time, not the send time
+	now := time.Now()
+	report := avsync.streams[1111].generateReport(1111, now, DefaultInterval)
+	require.NotNil(t, report)
+	require.Equal(t, uint32(1), report.PacketCount)
+
+	elapsed := time.Duration(float64(report.RTPTime-90000) / 90000 * float64(time.Second))
+	require.InDelta(t, now.Sub(captured), elapsed, float64(time.Millisecond))
+
+	// the sync info is the same mapping with the report
+	info, ok := avsync.SyncInfo(1111)
+	require.True(t, ok)
+	require.True(t, info.FromCapture)
+	require.Equal(t, uint64(1), info.ReportsSent)
+
+	elapsed = time.Duration(float64(int32(info.RTPTime-report.RTPTime)) / 90000 * float64(time.Second))
+	require.InDelta(t, info.NTPTime.Sub(now), elapsed, float64(time.Millisecond))
+}
+
+func TestInterceptorReportInterval(t *testing.T) {
+	factory := NewInterceptor(logging.NewDefaultLoggerFactory().NewLogger("test"), time.Hour)
+
+	i, err := factory.NewInterceptor("")
+	require.NoError(t, err)
+
+	defer i.Close()
+
+	avsync := i.(*Interceptor)
+
+	_, ok := avsync.SyncInfo(2222)
+	require.False(t, ok)
+
+	// the interval can be set before the stream is bound
+	avsync.SetReportInterval(2222, 200*time.Millisecond)
+
+	writer := avsync.BindLocalStream(&interceptor.StreamInfo{SSRC: 2222, ClockRate: 48000}, interceptor.RTPWriterFunc(func(_ *rtp.Header, _ []byte, _ interceptor.Attributes) (int, error) {
+		return 0, nil
+	}))
+
+	_, err = writer.Write(&rtp.Header{SSRC: 2222, Timestamp: 960}, []byte{0x01}, nil)
+	require.NoError(t, err)
+
+	stream := avsync.streams[2222]
+	now := time.Now()
+
+	require.NotNil(t, stream.generateReport(2222, now, DefaultInterval))
+	require.Nil(t, stream.generateReport(2222, now.Add(100*time.Millisecond), DefaultInterval))
+	require.NotNil(t, stream.generateReport(2222, now.Add(200*time.Millisecond), DefaultInterval))
+
+	// back to the interceptor interval
+	avsync.SetReportInterval(2222, 0)
+	require.Nil(t, stream.generateReport(2222, now.Add(400*time.Millisecond), DefaultInterval))
+	require.NotNil(t, stream.generateReport(2222, now.Add(1200*time.Millisecond), DefaultInterval))
+
+	info, ok := avsync.SyncInfo(2222)
+	require.True(t, ok)
+	require.False(t, info.FromCapture)
+	require.Equal(t, uint64(3), info.ReportsSent)
+	require.Equal(t, uint32(48000), info.ClockRate)
+}
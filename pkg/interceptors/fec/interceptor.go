@@ -0,0 +1,212 @@
+package fec
+
+import (
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/flexfec"
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	MimeTypeFlexFEC03 = "video/flexfec-03"
+	// the default payload type of the FlexFEC codec, it's not used by any codec in the SFU
+	DefaultPayloadType = 118
+)
+
+type Config struct {
+	// MediaPackets is the number of the media packets that protected together by the FEC packets
+	MediaPackets int
+	// FECPackets is the number of the FEC packets generated for every MediaPackets
+	FECPackets int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MediaPackets: 5,
+		FECPackets:   1,
+	}
+}
+
+type InterceptorFactory struct {
+	onNew  func(i *Interceptor)
+	config Config
+	log    logging.LeveledLogger
+}
+
+func NewInterceptor(log logging.LeveledLogger, config Config) *InterceptorFactory {
+	if config.MediaPackets <= 0 || config.FECPackets <= 0 {
+		config = DefaultConfig()
+	}
+
+	return &InterceptorFactory{
+		config: config,
+		log:    log,
+	}
+}
+
+// NewInterceptor constructs a new FEC Interceptor
+func (g *InterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	i := new(g.log, g.config)
+
+	if g.onNew != nil {
+		g.onNew(i)
+	}
+
+	return i, nil
+}
+
+func (g *InterceptorFactory) OnNew(callback func(i *Interceptor)) {
+	g.onNew = callback
+}
+
+// Interceptor generates the FlexFEC packets on the FEC stream of the outgoing video streams that negotiated it.
+// The FEC is disabled by default, it's enabled per stream with SetEnabled because it costs the extra bandwidth
+// that only worth it when the receiver is losing the packets.
+type Interceptor struct {
+	interceptor.NoOp
+	mu      sync.RWMutex
+	config  Config
+	log     logging.LeveledLogger
+	streams map[uint32]*localStream
+}
+
+type localStream struct {
+	mu      sync.Mutex
+	enabled bool
+	encoder flexfec.FlexEncoder
+	packets []rtp.Packet
+}
+
+func new(log logging.LeveledLogger, config Config) *Interceptor {
+	return &Interceptor{
+		config:  config,
+		log:     log,
+		streams: make(map[uint32]*localStream),
+	}
+}
+
+// SetEnabled enables or disables the FEC of the stream, returns false if the stream doesn't negotiate the FEC
+func (v *Interceptor) SetEnabled(ssrc uint32, enabled bool) bool {
+	v.mu.RLock()
+	stream, ok := v.streams[ssrc]
+	v.mu.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	stream.enabled = enabled
+	stream.packets = stream.packets[:0]
+
+	return true
+}
+
+func (v *Interceptor) IsEnabled(ssrc uint32) bool {
+	v.mu.RLock()
+	stream, ok := v.streams[ssrc]
+	v.mu.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	return stream.enabled
+}
+
+// BindLocalStream lets you modify any outgoing RTP packets. It is called once for per LocalStream. The returned method
+// will be called once per rtp packet.
+func (v *Interceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	if info.SSRCForwardErrorCorrection == 0 || info.PayloadTypeForwardErrorCorrection == 0 {
+		return writer
+	}
+
+	stream := &localStream{
+		encoder: flexfec.NewFlexEncoder03(info.PayloadTypeForwardErrorCorrection, info.SSRCForwardErrorCorrection),
+		packets: make([]rtp.Packet, 0, v.config.MediaPackets),
+	}
+
+	v.mu.Lock()
+	v.streams[info.SSRC] = stream
+	v.mu.Unlock()
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		n, err := writer.Write(header, payload, attributes)
+		if err != nil {
+			return n, err
+		}
+
+		for _, fecPacket := range stream.protect(header, payload, v.config) {
+			if _, err := writer.Write(&fecPacket.Header, fecPacket.Payload, attributes); err != nil {
+				v.log.Warnf("fec: failed to write fec packet: %s", err.Error())
+				break
+			}
+		}
+
+		return n, err
+	})
+}
+
+// UnbindLocalStream is called when the Stream is removed. It can be used to clean up any data related to that track.
+func (v *Interceptor) UnbindLocalStream(info *interceptor.StreamInfo) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	delete(v.streams, info.SSRC)
+}
+
+// protect adds the media packet to the group and returns the FEC packets once the group is complete
+func (s *localStream) protect(header *rtp.Header, payload []byte, config Config) []rtp.Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.enabled {
+		return nil
+	}
+
+	// the FEC only protects the consecutive packets, start a new group on a sequence gap
+	if len(s.packets) > 0 && s.packets[len(s.packets)-1].SequenceNumber+1 != header.SequenceNumber {
+		s.packets = s.packets[:0]
+	}
+
+	// the payload buffer is reused by the caller after the write
+	s.packets = append(s.packets, rtp.Packet{
+		Header:  header.Clone(),
+		Payload: append([]byte{}, payload...),
+	})
+
+	if len(s.packets) < config.MediaPackets {
+		return nil
+	}
+
+	fecPackets := s.encoder.EncodeFec(s.packets, uint32(config.FECPackets))
+
+	// use the media timestamp, the receiver uses it to drop the FEC packets that are too old
+	for i := range fecPackets {
+		fecPackets[i].Timestamp = header.Timestamp
+	}
+
+	s.packets = s.packets[:0]
+
+	return fecPackets
+}
+
+// RegisterFlexFEC03 registers the FlexFEC codec, the FEC is only generated for the clients that negotiate it
+func RegisterFlexFEC03(m *webrtc.MediaEngine, payloadType webrtc.PayloadType) error {
+	return m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    MimeTypeFlexFEC03,
+			ClockRate:   90000,
+			SDPFmtpLine: "repair-window=10000000",
+		},
+		PayloadType: payloadType,
+	}, webrtc.RTPCodecTypeVideo)
+}
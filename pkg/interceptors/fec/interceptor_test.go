@@ -0,0 +1,70 @@
+package fec
+
+import (
+	"testing"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFECGeneration(t *testing.T) {
+	const (
+		ssrc    = 1234
+		fecSSRC = 5678
+	)
+
+	i := new(logging.NewDefaultLoggerFactory().NewLogger("test"), Config{MediaPackets: 4, FECPackets: 1})
+
+	written := make([]rtp.Header, 0)
+
+	writer := i.BindLocalStream(&interceptor.StreamInfo{
+		SSRC:                              ssrc,
+		SSRCForwardErrorCorrection:        fecSSRC,
+		PayloadTypeForwardErrorCorrection: DefaultPayloadType,
+	}, interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+		written = append(written, header.Clone())
+		return len(payload), nil
+	}))
+
+	write := func(from, to uint16) {
+		for seq := from; seq < to; seq++ {
+			_, err := writer.Write(&rtp.Header{Version: 2, SSRC: ssrc, PayloadType: 96, SequenceNumber: seq, Timestamp: 3000}, []byte{0x01, 0x02, byte(seq)}, nil)
+			require.NoError(t, err)
+		}
+	}
+
+	// disabled by default
+	write(0, 8)
+	require.Len(t, written, 8)
+	require.False(t, i.IsEnabled(ssrc))
+
+	require.True(t, i.SetEnabled(ssrc, true))
+	require.False(t, i.SetEnabled(9999, true), "the stream without FEC can't be enabled")
+
+	written = written[:0]
+	write(8, 16)
+
+	// one FEC packet after every 4 media packets
+	require.Len(t, written, 10)
+	require.Equal(t, uint32(fecSSRC), written[4].SSRC)
+	require.Equal(t, uint8(DefaultPayloadType), written[4].PayloadType)
+	require.Equal(t, uint32(3000), written[4].Timestamp)
+	require.Equal(t, uint32(fecSSRC), written[9].SSRC)
+
+	// the sequence gap starts a new group
+	written = written[:0]
+	write(20, 22)
+	write(30, 34)
+	require.Len(t, written, 7)
+	require.Equal(t, uint32(fecSSRC), written[6].SSRC)
+
+	require.True(t, i.SetEnabled(ssrc, false))
+	written = written[:0]
+	write(34, 42)
+	require.Len(t, written, 8)
+
+	i.UnbindLocalStream(&interceptor.StreamInfo{SSRC: ssrc})
+	require.False(t, i.IsEnabled(ssrc))
+}
@@ -0,0 +1,282 @@
+package nackresponder
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/rtppool"
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+type Config struct {
+	// Size is the number of the last sent packets of each stream that kept to answer the NACKs
+	Size int
+	// MaxAge is the maximum age of the cached packet to retransmit, the receiver most likely already gave up on the older packet.
+	// Set to 0 to keep the packets until they're replaced by the newer packets.
+	MaxAge time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Size:   1024,
+		MaxAge: time.Second,
+	}
+}
+
+// Stats is the cache counters of the NACKed packets
+type Stats struct {
+	// Hits is the number of the NACKed packets that retransmitted from the cache
+	Hits uint64 `json:"hits"`
+	// Misses is the number of the NACKed packets that not found in the cache
+	Misses uint64 `json:"misses"`
+	// Expired is the number of the NACKed packets that found in the cache but older than the max age
+	Expired uint64 `json:"expired"`
+}
+
+type InterceptorFactory struct {
+	onNew  func(i *Interceptor)
+	config Config
+	log    logging.LeveledLogger
+}
+
+func NewInterceptor(log logging.LeveledLogger, config Config) *InterceptorFactory {
+	if config.Size <= 0 {
+		config.Size = DefaultConfig().Size
+	}
+
+	return &InterceptorFactory{
+		config: config,
+		log:    log,
+	}
+}
+
+// NewInterceptor constructs a new responder Interceptor
+func (g *InterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	i := new(g.log, g.config)
+
+	if g.onNew != nil {
+		g.onNew(i)
+	}
+
+	return i, nil
+}
+
+func (g *InterceptorFactory) OnNew(callback func(i *Interceptor)) {
+	g.onNew = callback
+}
+
+// Interceptor answers the NACKs from the receiver with the packets from the send side cache.
+// The packets are retransmitted on the RTX stream if it's negotiated, or on the original stream otherwise.
+type Interceptor struct {
+	interceptor.NoOp
+	mu            sync.RWMutex
+	config        Config
+	log           logging.LeveledLogger
+	packetManager *rtppool.PacketManager
+	streams       map[uint32]*localStream
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	expired       atomic.Uint64
+}
+
+type localStream struct {
+	mu             sync.Mutex
+	cache          *packetCache
+	writer         interceptor.RTPWriter
+	rtxSSRC        uint32
+	rtxPayloadType uint8
+	rtxSequence    uint16
+}
+
+func new(log logging.LeveledLogger, config Config) *Interceptor {
+	return &Interceptor{
+		config:        config,
+		log:           log,
+		packetManager: rtppool.NewPacketManager(),
+		streams:       make(map[uint32]*localStream),
+	}
+}
+
+// Stats returns the cache counters of all streams in the peer connection
+func (v *Interceptor) Stats() Stats {
+	return Stats{
+		Hits:    v.hits.Load(),
+		Misses:  v.misses.Load(),
+		Expired: v.expired.Load(),
+	}
+}
+
+// BindRTCPReader lets you modify any incoming RTCP packets. It is called once per sender/receiver, however this might
+// change in the future. The returned method will be called once per packet batch.
+func (v *Interceptor) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		i, attr, err := reader.Read(b, a)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if attr == nil {
+			attr = make(interceptor.Attributes)
+		}
+
+		pkts, err := attr.GetRTCPPackets(b[:i])
+		if err != nil {
+			return 0, nil, err
+		}
+
+		for _, pkt := range pkts {
+			if nack, ok := pkt.(*rtcp.TransportLayerNack); ok {
+				go v.resendPackets(nack)
+			}
+		}
+
+		return i, attr, err
+	})
+}
+
+// BindLocalStream lets you modify any outgoing RTP packets. It is called once for per LocalStream. The returned method
+// will be called once per rtp packet.
+func (v *Interceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	if !supportNack(info) {
+		return writer
+	}
+
+	stream := &localStream{
+		cache:          newPacketCache(v.config.Size, v.config.MaxAge),
+		writer:         writer,
+		rtxSSRC:        info.SSRCRetransmission,
+		rtxPayloadType: info.PayloadTypeRetransmission,
+		rtxSequence:    uint16(rand.Uint32()),
+	}
+
+	v.mu.Lock()
+	v.streams[info.SSRC] = stream
+	v.mu.Unlock()
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		packet, err := v.packetManager.NewPacket(header, payload, nil)
+		if err != nil {
+			v.log.Warnf("nackresponder: failed to cache packet %d: %s", header.SequenceNumber, err.Error())
+		} else {
+			stream.cache.add(header.SequenceNumber, packet, time.Now())
+		}
+
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// UnbindLocalStream is called when the Stream is removed. It can be used to clean up any data related to that track.
+func (v *Interceptor) UnbindLocalStream(info *interceptor.StreamInfo) {
+	v.mu.Lock()
+	stream, ok := v.streams[info.SSRC]
+	delete(v.streams, info.SSRC)
+	v.mu.Unlock()
+
+	if ok {
+		stream.cache.close()
+	}
+}
+
+func (v *Interceptor) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for ssrc, stream := range v.streams {
+		stream.cache.close()
+		delete(v.streams, ssrc)
+	}
+
+	return nil
+}
+
+func (v *Interceptor) resendPackets(nack *rtcp.TransportLayerNack) {
+	v.mu.RLock()
+	stream, ok := v.streams[nack.MediaSSRC]
+	v.mu.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+
+	for _, pair := range nack.Nacks {
+		pair.Range(func(seq uint16) bool {
+			packet, result := stream.cache.get(seq, now)
+
+			switch result {
+			case cacheMiss:
+				v.misses.Add(1)
+				return true
+			case cacheExpired:
+				v.expired.Add(1)
+				return true
+			}
+
+			v.hits.Add(1)
+
+			if err := stream.resend(packet); err != nil {
+				v.log.Warnf("nackresponder: failed to resend packet %d: %s", seq, err.Error())
+			}
+
+			packet.Release()
+
+			return true
+		})
+	}
+}
+
+func (s *localStream) resend(packet *rtppool.RetainablePacket) error {
+	if s.rtxSSRC == 0 || s.rtxPayloadType == 0 {
+		_, err := s.writer.Write(packet.Header(), packet.Payload(), interceptor.Attributes{})
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	header := packet.Header().Clone()
+	original := packet.Payload()
+
+	// the padding is not retransmitted
+	if header.Padding && len(original) > 0 {
+		if paddingLength := int(original[len(original)-1]); paddingLength <= len(original) {
+			original = original[:len(original)-paddingLength]
+		}
+
+		header.Padding = false
+	}
+
+	// RFC 4588, the RTX payload starts with the original sequence number
+	payload := make([]byte, 2+len(original))
+	binary.BigEndian.PutUint16(payload, header.SequenceNumber)
+	copy(payload[2:], original)
+
+	// the writers before the responder only know the media stream, see Pacer
+	attributes := interceptor.Attributes{AttributeMediaSSRC: header.SSRC}
+
+	header.SSRC = s.rtxSSRC
+	header.PayloadType = s.rtxPayloadType
+	header.SequenceNumber = s.rtxSequence
+	s.rtxSequence++
+
+	_, err := s.writer.Write(&header, payload, attributes)
+
+	return err
+}
+
+func supportNack(info *interceptor.StreamInfo) bool {
+	for _, fb := range info.RTCPFeedback {
+		if fb.Type == "nack" && fb.Parameter == "" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,132 @@
+package nackresponder
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/logging"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+type writtenPacket struct {
+	header  rtp.Header
+	payload []byte
+}
+
+func TestPacketCache(t *testing.T) {
+	now := time.Now()
+	i := new(logging.NewDefaultLoggerFactory().NewLogger("test"), Config{Size: 4, MaxAge: time.Second})
+	cache := newPacketCache(4, time.Second)
+
+	for seq := uint16(0); seq < 6; seq++ {
+		packet, err := i.packetManager.NewPacket(&rtp.Header{SequenceNumber: seq}, []byte{byte(seq)}, nil)
+		require.NoError(t, err)
+		cache.add(seq, packet, now)
+	}
+
+	// the oldest packets are replaced by the newer ones
+	_, result := cache.get(1, now)
+	require.Equal(t, cacheMiss, result)
+
+	packet, result := cache.get(5, now)
+	require.Equal(t, cacheHit, result)
+	require.Equal(t, []byte{5}, packet.Payload())
+	packet.Release()
+
+	_, result = cache.get(5, now.Add(2*time.Second))
+	require.Equal(t, cacheExpired, result)
+
+	cache.close()
+
+	_, result = cache.get(5, now)
+	require.Equal(t, cacheMiss, result)
+}
+
+func TestResendRTX(t *testing.T) {
+	const (
+		ssrc    = 1234
+		rtxSSRC = 5678
+	)
+
+	i := new(logging.NewDefaultLoggerFactory().NewLogger("test"), Config{Size: 16, MaxAge: time.Second})
+
+	mu := sync.Mutex{}
+	written := make([]writtenPacket, 0)
+
+	writer := i.BindLocalStream(&interceptor.StreamInfo{
+		SSRC:                      ssrc,
+		SSRCRetransmission:        rtxSSRC,
+		PayloadTypeRetransmission: 97,
+		RTCPFeedback:              []interceptor.RTCPFeedback{{Type: "nack"}},
+	}, interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		written = append(written, writtenPacket{header: header.Clone(), payload: append([]byte{}, payload...)})
+
+		return len(payload), nil
+	}))
+
+	for seq := uint16(10); seq < 15; seq++ {
+		_, err := writer.Write(&rtp.Header{SSRC: ssrc, PayloadType: 96, SequenceNumber: seq}, []byte{0xaa, byte(seq)}, nil)
+		require.NoError(t, err)
+	}
+
+	// 12 and 13 are in the cache, 100 is never sent
+	i.resendPackets(&rtcp.TransportLayerNack{
+		MediaSSRC: ssrc,
+		Nacks:     []rtcp.NackPair{{PacketID: 12, LostPackets: 0b1}, {PacketID: 100}},
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, written, 7)
+
+	for idx, seq := range []uint16{12, 13} {
+		rtx := written[5+idx]
+		require.Equal(t, uint32(rtxSSRC), rtx.header.SSRC)
+		require.Equal(t, uint8(97), rtx.header.PayloadType)
+		require.Equal(t, seq, binary.BigEndian.Uint16(rtx.payload))
+		require.Equal(t, []byte{0xaa, byte(seq)}, rtx.payload[2:])
+	}
+
+	require.Equal(t, written[5].header.SequenceNumber+1, written[6].header.SequenceNumber)
+	require.Equal(t, Stats{Hits: 2, Misses: 1}, i.Stats())
+
+	i.UnbindLocalStream(&interceptor.StreamInfo{SSRC: ssrc})
+	require.NoError(t, i.Close())
+}
+
+func TestPacerRTX(t *testing.T) {
+	const (
+		ssrc    = 1234
+		rtxSSRC = 5678
+	)
+
+	written := make([]uint32, 0)
+
+	pacer := NewPacer()
+	pacer.AddStream(ssrc, interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+		written = append(written, header.SSRC)
+		return len(payload), nil
+	}))
+
+	_, err := pacer.Write(&rtp.Header{SSRC: ssrc}, []byte{0xaa}, nil)
+	require.NoError(t, err)
+
+	// the retransmission is written by the writer of its media stream
+	_, err = pacer.Write(&rtp.Header{SSRC: rtxSSRC}, []byte{0xaa}, interceptor.Attributes{AttributeMediaSSRC: uint32(ssrc)})
+	require.NoError(t, err)
+
+	_, err = pacer.Write(&rtp.Header{SSRC: rtxSSRC}, []byte{0xaa}, nil)
+	require.ErrorIs(t, err, gcc.ErrUnknownStream)
+
+	require.Equal(t, []uint32{ssrc, rtxSSRC}, written)
+}
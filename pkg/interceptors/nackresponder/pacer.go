@@ -0,0 +1,60 @@
+package nackresponder
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/rtp"
+)
+
+// AttributeMediaSSRC is the SSRC of the media stream of a retransmitted packet that written on the RTX stream
+const AttributeMediaSSRC = "nackresponder_media_ssrc"
+
+// Pacer is the gcc.Pacer that writes the packets right away like gcc.NoOpPacer, but it also writes the retransmissions
+// on the RTX stream. Only the media stream is bound to the interceptors, so the pacer doesn't know the writer of the RTX
+// SSRC, the retransmission is written by the writer of its media stream, see AttributeMediaSSRC.
+type Pacer struct {
+	mu      sync.RWMutex
+	writers map[uint32]interceptor.RTPWriter
+}
+
+func NewPacer() *Pacer {
+	return &Pacer{
+		writers: make(map[uint32]interceptor.RTPWriter),
+	}
+}
+
+// AddStream adds the writer of the media stream
+func (p *Pacer) AddStream(ssrc uint32, writer interceptor.RTPWriter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.writers[ssrc] = writer
+}
+
+// SetTargetBitrate is a no-op, the packets are not paced
+func (p *Pacer) SetTargetBitrate(int) {}
+
+// Write writes the packet with the writer of its stream
+func (p *Pacer) Write(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+	ssrc := header.SSRC
+	if mediaSSRC, ok := attributes.Get(AttributeMediaSSRC).(uint32); ok {
+		ssrc = mediaSSRC
+	}
+
+	p.mu.RLock()
+	writer, ok := p.writers[ssrc]
+	p.mu.RUnlock()
+
+	if !ok {
+		return 0, fmt.Errorf("%w: %d", gcc.ErrUnknownStream, header.SSRC)
+	}
+
+	return writer.Write(header, payload, attributes)
+}
+
+func (p *Pacer) Close() error {
+	return nil
+}
@@ -0,0 +1,89 @@
+package nackresponder
+
+import (
+	"sync"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/rtppool"
+)
+
+type cacheResult int
+
+const (
+	cacheHit cacheResult = iota
+	// the packet is not in the cache, it's never sent or already replaced by the newer packet
+	cacheMiss
+	// the packet is in the cache but it's older than the max age
+	cacheExpired
+)
+
+type cachedPacket struct {
+	packet  *rtppool.RetainablePacket
+	seq     uint16
+	addedAt time.Time
+}
+
+// packetCache keeps the last sent packets of a stream by the sequence number
+type packetCache struct {
+	mu      sync.Mutex
+	packets []cachedPacket
+	maxAge  time.Duration
+}
+
+func newPacketCache(size int, maxAge time.Duration) *packetCache {
+	return &packetCache{
+		packets: make([]cachedPacket, size),
+		maxAge:  maxAge,
+	}
+}
+
+func (c *packetCache) add(seq uint16, packet *rtppool.RetainablePacket, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	idx := int(seq) % len(c.packets)
+
+	if prev := c.packets[idx].packet; prev != nil {
+		prev.Release()
+	}
+
+	c.packets[idx] = cachedPacket{
+		packet:  packet,
+		seq:     seq,
+		addedAt: now,
+	}
+}
+
+// get returns the retained packet on cache hit, the caller must release it after use
+func (c *packetCache) get(seq uint16, now time.Time) (*rtppool.RetainablePacket, cacheResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached := c.packets[int(seq)%len(c.packets)]
+	if cached.packet == nil || cached.seq != seq {
+		return nil, cacheMiss
+	}
+
+	if c.maxAge > 0 && now.Sub(cached.addedAt) > c.maxAge {
+		return nil, cacheExpired
+	}
+
+	if err := cached.packet.Retain(); err != nil {
+		return nil, cacheMiss
+	}
+
+	return cached.packet, cacheHit
+}
+
+func (c *packetCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, cached := range c.packets {
+		if cached.packet != nil {
+			cached.packet.Release()
+		}
+
+		c.packets[i] = cachedPacket{}
+	}
+}
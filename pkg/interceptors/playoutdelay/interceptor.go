@@ -1,6 +1,9 @@
 package playoutdelay
 
 import (
+	"sync"
+	"sync/atomic"
+
 	"github.com/pion/interceptor"
 	"github.com/pion/logging"
 	"github.com/pion/rtp"
@@ -10,6 +13,7 @@ import (
 type InterceptorFactory struct {
 	minDelay, maxDelay uint16
 	log                logging.LeveledLogger
+	streams            *streamDelays
 }
 
 func NewInterceptor(log logging.LeveledLogger, minDelay, maxDelay uint16) *InterceptorFactory {
@@ -17,27 +21,81 @@ func NewInterceptor(log logging.LeveledLogger, minDelay, maxDelay uint16) *Inter
 		minDelay: minDelay,
 		maxDelay: maxDelay,
 		log:      log,
+		streams: &streamDelays{
+			delays: make(map[uint32]*streamDelay),
+		},
 	}
 }
 
 // NewInterceptor constructs a new ReceiverInterceptor
 func (g *InterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
-	i := new(g.log, g.minDelay, g.maxDelay)
+	i := new(g.log, g.minDelay, g.maxDelay, g.streams)
 
 	return i, nil
 }
 
+// SetDelay overrides the default playout delay of the outgoing stream with the SSRC, it can be called before
+// the stream is bound and it's used by the next packets if the stream is already sending.
+func (g *InterceptorFactory) SetDelay(ssrc uint32, minDelay, maxDelay uint16) error {
+	payload, err := PlayoutDelayFromValue(minDelay, maxDelay).Marshal()
+	if err != nil {
+		return err
+	}
+
+	g.streams.get(ssrc).payload.Store(&payload)
+
+	return nil
+}
+
+// ResetDelay sets the outgoing stream with the SSRC back to the default playout delay
+func (g *InterceptorFactory) ResetDelay(ssrc uint32) {
+	g.streams.get(ssrc).payload.Store(nil)
+}
+
+// streamDelays keeps the playout delays of the streams that set with SetDelay, it's shared by the interceptors
+type streamDelays struct {
+	mu     sync.Mutex
+	delays map[uint32]*streamDelay
+}
+
+type streamDelay struct {
+	// the marshaled extension payload, nil means the default delay
+	payload atomic.Pointer[[]byte]
+}
+
+func (s *streamDelays) get(ssrc uint32) *streamDelay {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delay, ok := s.delays[ssrc]
+	if !ok {
+		delay = &streamDelay{}
+		s.delays[ssrc] = delay
+	}
+
+	return delay
+}
+
+func (s *streamDelays) remove(ssrc uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.delays, ssrc)
+}
+
 type Interceptor struct {
 	minDelay uint16
 	maxDelay uint16
 	log      logging.LeveledLogger
+	streams  *streamDelays
 }
 
-func new(log logging.LeveledLogger, min, max uint16) *Interceptor {
+func new(log logging.LeveledLogger, min, max uint16, streams *streamDelays) *Interceptor {
 	return &Interceptor{
 		minDelay: min,
 		maxDelay: max,
 		log:      log,
+		streams:  streams,
 	}
 }
 
@@ -54,15 +112,22 @@ func (v *Interceptor) BindLocalStream(info *interceptor.StreamInfo, writer inter
 		return writer
 	}
 
+	delay := v.streams.get(info.SSRC)
+
 	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
-		newHeader := v.addPlayoutDelay(info, header, extID, payloadDelay)
+		streamPayload := payloadDelay
+		if p := delay.payload.Load(); p != nil {
+			streamPayload = *p
+		}
+
+		newHeader := v.addPlayoutDelay(info, header, extID, streamPayload)
 		return writer.Write(newHeader, payload, attributes)
 	})
 }
 
 // UnbindLocalStream is called when the Stream is removed. It can be used to clean up any data related to that track.
 func (v *Interceptor) UnbindLocalStream(info *interceptor.StreamInfo) {
-
+	v.streams.remove(info.SSRC)
 }
 
 // BindRemoteStream lets you modify any incoming RTP packets. It is called once for per RemoteStream. The returned method
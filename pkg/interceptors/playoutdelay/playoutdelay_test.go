@@ -5,6 +5,9 @@ package playoutdelay
 import (
 	"testing"
 
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
 	"github.com/stretchr/testify/require"
 )
 
@@ -35,3 +38,55 @@ func TestPlayoutDelay(t *testing.T) {
 	require.Equal(t, uint16((1<<12)-1)*10, p5.Min)
 	require.Equal(t, uint16((1<<12)-1)*10, p5.Max)
 }
+
+func TestInterceptorStreamDelay(t *testing.T) {
+	factory := NewInterceptor(logging.NewDefaultLoggerFactory().NewLogger("test"), 100, 200)
+
+	i, err := factory.NewInterceptor("")
+	require.NoError(t, err)
+
+	// the delay can be set before the stream is bound
+	require.NoError(t, factory.SetDelay(1111, 0, 0))
+
+	var written rtp.Header
+	writer := interceptor.RTPWriterFunc(func(header *rtp.Header, _ []byte, _ interceptor.Attributes) (int, error) {
+		written = *header
+		return 0, nil
+	})
+
+	bind := func(ssrc uint32) interceptor.RTPWriter {
+		return i.BindLocalStream(&interceptor.StreamInfo{
+			SSRC:                ssrc,
+			RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: PlayoutDelayURI, ID: 5}},
+		}, writer)
+	}
+
+	delayOf := func(w interceptor.RTPWriter) PlayOutDelay {
+		_, err := w.Write(&rtp.Header{Version: 2}, nil, nil)
+		require.NoError(t, err)
+
+		var delay PlayOutDelay
+		require.NoError(t, delay.Unmarshal(written.GetExtension(5)))
+
+		return delay
+	}
+
+	first := bind(1111)
+	second := bind(2222)
+
+	require.Equal(t, PlayOutDelay{Min: 0, Max: 0}, delayOf(first))
+	require.Equal(t, PlayOutDelay{Min: 100, Max: 200}, delayOf(second))
+
+	// the bound stream uses the new delay on the next packet
+	require.NoError(t, factory.SetDelay(2222, 500, 1000))
+	require.Equal(t, PlayOutDelay{Min: 500, Max: 1000}, delayOf(second))
+
+	factory.ResetDelay(1111)
+	require.Equal(t, PlayOutDelay{Min: 100, Max: 200}, delayOf(first))
+
+	// the stream is not sent with the extension if it's not negotiated
+	notNegotiated := i.BindLocalStream(&interceptor.StreamInfo{SSRC: 3333}, writer)
+	_, err = notNegotiated.Write(&rtp.Header{Version: 2}, nil, nil)
+	require.NoError(t, err)
+	require.Nil(t, written.GetExtension(5))
+}
@@ -0,0 +1,10 @@
+package voiceactivedetector
+
+// the Opus encoder sends a packet with only the TOC byte, or a TOC byte with the frame count, in a DTX silent period.
+// The packets are sent every 400ms instead of every 20ms until the voice is back.
+const opusDTXMaxSize = 2
+
+// IsOpusDTX returns true if the Opus payload is a DTX/comfort noise packet that carries no audio
+func IsOpusDTX(payload []byte) bool {
+	return len(payload) > 0 && len(payload) <= opusDTXMaxSize
+}
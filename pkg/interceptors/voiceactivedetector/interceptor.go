@@ -142,7 +142,15 @@ func (v *Interceptor) BindRemoteStream(info *interceptor.StreamInfo, reader inte
 			return 0, nil, err
 		}
 
-		audioAttribute := v.processPacket(info.SSRC, header)
+		var audioAttribute rtp.AudioLevelExtension
+
+		// the audio level extension of the DTX packet may still carry the voice flag of the last frame,
+		// it's silence so it shouldn't keep the voice active
+		if info.MimeType == webrtc.MimeTypeOpus && IsOpusDTX(rtpPayload(b[:i], header)) {
+			audioAttribute = rtp.AudioLevelExtension{Level: 127}
+		} else {
+			audioAttribute = v.processPacket(info.SSRC, header)
+		}
 
 		attr.Set(ATTRIBUTE_KEY, audioAttribute.Level)
 		attr.Set("isVoice", audioAttribute.Voice)
@@ -212,6 +220,26 @@ func (v *Interceptor) processPacket(ssrc uint32, header *rtp.Header) rtp.AudioLe
 
 }
 
+// rtpPayload returns the payload of the raw RTP packet without the padding
+func rtpPayload(b []byte, header *rtp.Header) []byte {
+	offset := header.MarshalSize()
+	if offset > len(b) {
+		return nil
+	}
+
+	payload := b[offset:]
+	if header.Padding && len(payload) > 0 {
+		paddingLength := int(payload[len(payload)-1])
+		if paddingLength > len(payload) {
+			return nil
+		}
+
+		payload = payload[:len(payload)-paddingLength]
+	}
+
+	return payload
+}
+
 func (v *Interceptor) getConfig() Config {
 	v.mu.RLock()
 	defer v.mu.RUnlock()
@@ -0,0 +1,321 @@
+// Package logger is a structured logger for the SFU that implements the pion logging interfaces, so it can be used
+// everywhere a logging.LeveledLogger or a logging.LoggerFactory is expected, including the pion WebRTC stack.
+// The records are written to a slog.Handler, use slog.NewJSONHandler or slog.NewTextHandler from the standard library,
+// or an adapter to another logging library like go.uber.org/zap/exp/zapslog for zap.
+//
+// Every logger has a scope, like sfu or bitratecontroller, and the level of each scope can be changed at runtime with
+// Factory.SetLevel. The fields like room_id and client_id are attached with With, and written with every record.
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+)
+
+// LevelTrace is the slog level of the trace records, slog doesn't define a level below debug
+const LevelTrace = slog.Level(-8)
+
+// ScopeKey is the attribute key of the logger scope
+const ScopeKey = "scope"
+
+// Factory creates the loggers that write to the same handler, it implements logging.LoggerFactory
+type Factory struct {
+	handler      slog.Handler
+	mu           sync.RWMutex
+	defaultLevel logging.LogLevel
+	levels       map[string]*scopeLevel
+}
+
+// scopeLevel is read on every log call, so it's atomic instead of locked
+type scopeLevel struct {
+	level atomic.Int32
+	// set if the level is set for the scope, otherwise it follows the default level
+	isSet atomic.Bool
+}
+
+// NewFactory creates a logger factory that writes to the handler, slog.Default().Handler() is used if it's nil.
+// The default level is info.
+func NewFactory(handler slog.Handler) *Factory {
+	if handler == nil {
+		handler = slog.Default().Handler()
+	}
+
+	return &Factory{
+		handler:      handler,
+		defaultLevel: logging.LogLevelInfo,
+		levels:       make(map[string]*scopeLevel),
+	}
+}
+
+// NewLogger returns the logger of the scope, the loggers of the same scope share the level
+func (f *Factory) NewLogger(scope string) logging.LeveledLogger {
+	return f.newLogger(scope)
+}
+
+func (f *Factory) newLogger(scope string) *Logger {
+	return &Logger{
+		factory: f,
+		scope:   scope,
+		level:   f.scopeLevel(scope),
+		handler: f.handler.WithAttrs([]slog.Attr{slog.String(ScopeKey, scope)}),
+	}
+}
+
+func (f *Factory) scopeLevel(scope string) *scopeLevel {
+	f.mu.RLock()
+	level, ok := f.levels[scope]
+	f.mu.RUnlock()
+
+	if ok {
+		return level
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if level, ok = f.levels[scope]; ok {
+		return level
+	}
+
+	level = &scopeLevel{}
+	level.level.Store(int32(f.defaultLevel))
+	f.levels[scope] = level
+
+	return level
+}
+
+// SetLevel changes the level of the scope at runtime, including the loggers that already created
+func (f *Factory) SetLevel(scope string, level logging.LogLevel) {
+	l := f.scopeLevel(scope)
+
+	// serialized with SetDefaultLevel, so the default level doesn't overwrite it
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	l.isSet.Store(true)
+	l.level.Store(int32(level))
+}
+
+// ResetLevel makes the scope follow the default level again
+func (f *Factory) ResetLevel(scope string) {
+	l := f.scopeLevel(scope)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	l.isSet.Store(false)
+	l.level.Store(int32(f.defaultLevel))
+}
+
+// SetDefaultLevel changes the level of all scopes that don't have their own level
+func (f *Factory) SetDefaultLevel(level logging.LogLevel) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.defaultLevel = level
+
+	for _, l := range f.levels {
+		if !l.isSet.Load() {
+			l.level.Store(int32(level))
+		}
+	}
+}
+
+// Levels returns the current level of every scope that has a logger
+func (f *Factory) Levels() map[string]logging.LogLevel {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	levels := make(map[string]logging.LogLevel, len(f.levels))
+	for scope, l := range f.levels {
+		levels[scope] = l.get()
+	}
+
+	return levels
+}
+
+func (l *scopeLevel) get() logging.LogLevel {
+	return logging.LogLevel(l.level.Load())
+}
+
+// ParseLevel parses the level name like pion does for the PION_LOG_* environment variables, it's case insensitive
+func ParseLevel(name string) (logging.LogLevel, error) {
+	switch strings.ToLower(name) {
+	case "disabled", "disable", "off":
+		return logging.LogLevelDisabled, nil
+	case "error":
+		return logging.LogLevelError, nil
+	case "warn", "warning":
+		return logging.LogLevelWarn, nil
+	case "info":
+		return logging.LogLevelInfo, nil
+	case "debug":
+		return logging.LogLevelDebug, nil
+	case "trace":
+		return logging.LogLevelTrace, nil
+	}
+
+	return logging.LogLevelDisabled, fmt.Errorf("logger: unknown level %q", name)
+}
+
+// Logger is a structured logger that implements logging.LeveledLogger
+type Logger struct {
+	factory *Factory
+	scope   string
+	level   *scopeLevel
+	handler slog.Handler
+	// the fields of With, kept to create the named loggers
+	attrs []slog.Attr
+}
+
+// With returns a logger that writes the fields with every record, the arguments are the key value pairs like slog.Logger.With
+func (l *Logger) With(args ...any) *Logger {
+	if len(args) == 0 {
+		return l
+	}
+
+	attrs := argsToAttrs(args)
+
+	return &Logger{
+		factory: l.factory,
+		scope:   l.scope,
+		level:   l.level,
+		handler: l.handler.WithAttrs(attrs),
+		attrs:   append(append(make([]slog.Attr, 0, len(l.attrs)+len(attrs)), l.attrs...), attrs...),
+	}
+}
+
+// Named returns a logger of another scope that keeps the fields of this logger
+func (l *Logger) Named(scope string) *Logger {
+	named := l.factory.newLogger(scope)
+	if len(l.attrs) > 0 {
+		named.handler = named.handler.WithAttrs(l.attrs)
+		named.attrs = l.attrs
+	}
+
+	return named
+}
+
+// Scope returns the scope of the logger
+func (l *Logger) Scope() string {
+	return l.scope
+}
+
+func (l *Logger) Trace(msg string) { l.log(logging.LogLevelTrace, LevelTrace, msg) }
+func (l *Logger) Tracef(format string, args ...interface{}) {
+	l.logf(logging.LogLevelTrace, LevelTrace, format, args...)
+}
+func (l *Logger) Debug(msg string) { l.log(logging.LogLevelDebug, slog.LevelDebug, msg) }
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.logf(logging.LogLevelDebug, slog.LevelDebug, format, args...)
+}
+func (l *Logger) Info(msg string) { l.log(logging.LogLevelInfo, slog.LevelInfo, msg) }
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.logf(logging.LogLevelInfo, slog.LevelInfo, format, args...)
+}
+func (l *Logger) Warn(msg string) { l.log(logging.LogLevelWarn, slog.LevelWarn, msg) }
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.logf(logging.LogLevelWarn, slog.LevelWarn, format, args...)
+}
+func (l *Logger) Error(msg string) { l.log(logging.LogLevelError, slog.LevelError, msg) }
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.logf(logging.LogLevelError, slog.LevelError, format, args...)
+}
+
+func (l *Logger) enabled(level logging.LogLevel) bool {
+	return l.level.get() >= level
+}
+
+func (l *Logger) log(level logging.LogLevel, slogLevel slog.Level, msg string) {
+	if !l.enabled(level) {
+		return
+	}
+
+	l.write(slogLevel, msg)
+}
+
+func (l *Logger) logf(level logging.LogLevel, slogLevel slog.Level, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	l.write(slogLevel, formatMessage(format, args...))
+}
+
+func (l *Logger) write(level slog.Level, msg string) {
+	ctx := context.Background()
+	if !l.handler.Enabled(ctx, level) {
+		return
+	}
+
+	// skip runtime.Callers, write, log or logf, and the level method, so the source is the caller of the logger
+	var pcs [1]uintptr
+	runtime.Callers(4, pcs[:])
+
+	record := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	_ = l.handler.Handle(ctx, record)
+}
+
+// formatMessage formats the message like the pion default logger. Some callers pass the arguments without the
+// format verbs, like Errorf("client: error ", err), they're appended to the message instead of the %!(EXTRA) noise.
+func formatMessage(format string, args ...interface{}) string {
+	if len(args) > 0 && !strings.Contains(format, "%") {
+		return fmt.Sprint(append([]interface{}{format}, args...)...)
+	}
+
+	return fmt.Sprintf(format, args...)
+}
+
+func argsToAttrs(args []any) []slog.Attr {
+	attrs := make([]slog.Attr, 0, len(args)/2)
+
+	for len(args) > 0 {
+		switch key := args[0].(type) {
+		case slog.Attr:
+			attrs = append(attrs, key)
+			args = args[1:]
+		case string:
+			if len(args) == 1 {
+				attrs = append(attrs, slog.String("!BADKEY", key))
+				args = nil
+				continue
+			}
+
+			attrs = append(attrs, slog.Any(key, args[1]))
+			args = args[2:]
+		default:
+			attrs = append(attrs, slog.Any("!BADKEY", key))
+			args = args[1:]
+		}
+	}
+
+	return attrs
+}
+
+// With attaches the fields to the logger if it's a structured logger, other loggers like the pion default logger
+// are returned as is because they can't write the fields.
+func With(log logging.LeveledLogger, args ...any) logging.LeveledLogger {
+	if l, ok := log.(*Logger); ok {
+		return l.With(args...)
+	}
+
+	return log
+}
+
+// Named returns a logger of the scope with the fields of log if it's a structured logger, otherwise a pion default
+// logger of the scope.
+func Named(log logging.LeveledLogger, scope string) logging.LeveledLogger {
+	if l, ok := log.(*Logger); ok {
+		return l.Named(scope)
+	}
+
+	return logging.NewDefaultLoggerFactory().NewLogger(scope)
+}
@@ -0,0 +1,110 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFactory(buf *bytes.Buffer) *Factory {
+	return NewFactory(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: LevelTrace}))
+}
+
+func records(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	records := make([]map[string]interface{}, 0)
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		record := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+
+	buf.Reset()
+
+	return records
+}
+
+func TestLoggerFields(t *testing.T) {
+	buf := &bytes.Buffer{}
+	factory := newTestFactory(buf)
+
+	log := With(factory.NewLogger("sfu"), "room_id", "room", "client_id", "client")
+	log.Infof("client: new track %s", "track")
+
+	r := records(t, buf)
+	require.Len(t, r, 1)
+	require.Equal(t, "client: new track track", r[0]["msg"])
+	require.Equal(t, "INFO", r[0]["level"])
+	require.Equal(t, "sfu", r[0][ScopeKey])
+	require.Equal(t, "room", r[0]["room_id"])
+	require.Equal(t, "client", r[0]["client_id"])
+
+	// the named logger keeps the fields
+	Named(log, "bitratecontroller").Warn("claim failed")
+
+	r = records(t, buf)
+	require.Len(t, r, 1)
+	require.Equal(t, "bitratecontroller", r[0][ScopeKey])
+	require.Equal(t, "client", r[0]["client_id"])
+
+	// the arguments without the format verbs are appended like the pion default logger
+	log.Errorf("client: error ", "failed")
+	require.Equal(t, "client: error failed", records(t, buf)[0]["msg"])
+}
+
+func TestLoggerLevels(t *testing.T) {
+	buf := &bytes.Buffer{}
+	factory := newTestFactory(buf)
+
+	sfuLog := factory.NewLogger("sfu")
+	bcLog := Named(sfuLog, "bitratecontroller")
+
+	sfuLog.Debug("hidden")
+	require.Empty(t, records(t, buf))
+
+	// the level is changed for the loggers that already created
+	factory.SetLevel("sfu", logging.LogLevelDebug)
+	sfuLog.Debug("visible")
+	bcLog.Debug("hidden")
+	require.Len(t, records(t, buf), 1)
+
+	factory.SetDefaultLevel(logging.LogLevelError)
+	sfuLog.Info("visible")
+	bcLog.Warn("hidden")
+	require.Len(t, records(t, buf), 1)
+
+	factory.ResetLevel("sfu")
+	sfuLog.Info("hidden")
+	require.Empty(t, records(t, buf))
+
+	require.Equal(t, map[string]logging.LogLevel{
+		"sfu":               logging.LogLevelError,
+		"bitratecontroller": logging.LogLevelError,
+	}, factory.Levels())
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	require.NoError(t, err)
+	require.Equal(t, logging.LogLevelWarn, level)
+
+	_, err = ParseLevel("verbose")
+	require.Error(t, err)
+}
+
+func TestWithDefaultLogger(t *testing.T) {
+	log := logging.NewDefaultLoggerFactory().NewLogger("sfu")
+
+	// the pion default logger can't write the fields, it's returned as is
+	require.Equal(t, log, With(log, "room_id", "room"))
+	require.NotNil(t, Named(log, "bitratecontroller"))
+}
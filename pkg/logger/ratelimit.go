@@ -0,0 +1,157 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+)
+
+// Counters counts the log calls by the message format, it's safe for concurrent use.
+// It implements expvar.Var, so it can be published with expvar.Publish.
+type Counters struct {
+	counters sync.Map
+}
+
+func NewCounters() *Counters {
+	return &Counters{}
+}
+
+func (c *Counters) inc(key string) {
+	counter, ok := c.counters.Load(key)
+	if !ok {
+		counter, _ = c.counters.LoadOrStore(key, &atomic.Uint64{})
+	}
+
+	counter.(*atomic.Uint64).Add(1)
+}
+
+// Snapshot returns the current counts by the message format
+func (c *Counters) Snapshot() map[string]uint64 {
+	snapshot := make(map[string]uint64)
+
+	c.counters.Range(func(key, value any) bool {
+		snapshot[key.(string)] = value.(*atomic.Uint64).Load()
+		return true
+	})
+
+	return snapshot
+}
+
+// String returns the counts as JSON
+func (c *Counters) String() string {
+	b, _ := json.Marshal(c.Snapshot())
+	return string(b)
+}
+
+// RateLimited is a logger for the hot paths like the packet path, where a failure is repeated at the packet rate.
+// A message is logged at most once per interval for each message format, with the number of the suppressed messages
+// since it was logged. Every call is counted in the counters, including the suppressed calls.
+type RateLimited struct {
+	log      logging.LeveledLogger
+	interval time.Duration
+	counters *Counters
+	mu       sync.Mutex
+	entries  map[string]*rateLimitedEntry
+}
+
+type rateLimitedEntry struct {
+	lastLogged time.Time
+	suppressed uint64
+}
+
+// NewRateLimited wraps the logger, counters can be shared by multiple loggers or nil if the calls are not counted
+func NewRateLimited(log logging.LeveledLogger, interval time.Duration, counters *Counters) *RateLimited {
+	return &RateLimited{
+		log:      log,
+		interval: interval,
+		counters: counters,
+		entries:  make(map[string]*rateLimitedEntry),
+	}
+}
+
+// allow returns true and the number of the suppressed messages if the message can be logged
+func (l *RateLimited) allow(key string) (bool, uint64) {
+	if l.counters != nil {
+		l.counters.inc(key)
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[key]
+	if !ok {
+		l.entries[key] = &rateLimitedEntry{lastLogged: now}
+		return true, 0
+	}
+
+	if now.Sub(entry.lastLogged) < l.interval {
+		entry.suppressed++
+		return false, 0
+	}
+
+	suppressed := entry.suppressed
+	entry.lastLogged = now
+	entry.suppressed = 0
+
+	return true, suppressed
+}
+
+func (l *RateLimited) print(key string, write func(string), msg func() string) {
+	ok, suppressed := l.allow(key)
+	if !ok {
+		return
+	}
+
+	if suppressed > 0 {
+		write(fmt.Sprintf("%s (%d similar messages suppressed)", msg(), suppressed))
+		return
+	}
+
+	write(msg())
+}
+
+func (l *RateLimited) Trace(msg string) {
+	l.print(msg, l.log.Trace, func() string { return msg })
+}
+
+func (l *RateLimited) Tracef(format string, args ...interface{}) {
+	l.print(format, l.log.Trace, func() string { return formatMessage(format, args...) })
+}
+
+func (l *RateLimited) Debug(msg string) {
+	l.print(msg, l.log.Debug, func() string { return msg })
+}
+
+func (l *RateLimited) Debugf(format string, args ...interface{}) {
+	l.print(format, l.log.Debug, func() string { return formatMessage(format, args...) })
+}
+
+func (l *RateLimited) Info(msg string) {
+	l.print(msg, l.log.Info, func() string { return msg })
+}
+
+func (l *RateLimited) Infof(format string, args ...interface{}) {
+	l.print(format, l.log.Info, func() string { return formatMessage(format, args...) })
+}
+
+func (l *RateLimited) Warn(msg string) {
+	l.print(msg, l.log.Warn, func() string { return msg })
+}
+
+func (l *RateLimited) Warnf(format string, args ...interface{}) {
+	l.print(format, l.log.Warn, func() string { return formatMessage(format, args...) })
+}
+
+func (l *RateLimited) Error(msg string) {
+	l.print(msg, l.log.Error, func() string { return msg })
+}
+
+func (l *RateLimited) Errorf(format string, args ...interface{}) {
+	l.print(format, l.log.Error, func() string { return formatMessage(format, args...) })
+}
@@ -0,0 +1,40 @@
+package logger
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimited(t *testing.T) {
+	buf := &bytes.Buffer{}
+	counters := NewCounters()
+	log := NewRateLimited(newTestFactory(buf).NewLogger("sfu"), 50*time.Millisecond, counters)
+
+	for i := 0; i < 100; i++ {
+		log.Warnf("track: error on write rtp %d", i)
+	}
+
+	log.Errorf("track: claim is nil")
+
+	r := records(t, buf)
+	require.Len(t, r, 2)
+	require.Equal(t, "track: error on write rtp 0", r[0]["msg"])
+	require.Equal(t, "track: claim is nil", r[1]["msg"])
+
+	time.Sleep(60 * time.Millisecond)
+
+	log.Warnf("track: error on write rtp %d", 100)
+
+	r = records(t, buf)
+	require.Len(t, r, 1)
+	require.Equal(t, "track: error on write rtp 100 (99 similar messages suppressed)", r[0]["msg"])
+
+	require.Equal(t, map[string]uint64{
+		"track: error on write rtp %d": 101,
+		"track: claim is nil":          1,
+	}, counters.Snapshot())
+	require.JSONEq(t, `{"track: error on write rtp %d":101,"track: claim is nil":1}`, counters.String())
+}
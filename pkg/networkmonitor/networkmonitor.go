@@ -1,7 +1,7 @@
 package networkmonitor
 
 import (
-	"context"
+	"sync"
 	"time"
 )
 
@@ -15,17 +15,258 @@ const (
 	SENDERLOSS     = NetworkConditionType(4)
 )
 
+// State is the network state that changed with the hysteresis, see Options
+type State uint8
+
+const (
+	StateStable State = iota
+	StateUnstable
+	StateCritical
+)
+
+func (s State) String() string {
+	switch s {
+	case StateStable:
+		return "stable"
+	case StateUnstable:
+		return "unstable"
+	case StateCritical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Trend is the direction of the round trip time over the last samples
+type Trend int8
+
+const (
+	TrendSteady Trend = iota
+	TrendRising
+	TrendFalling
+)
+
+func (t Trend) String() string {
+	switch t {
+	case TrendRising:
+		return "rising"
+	case TrendFalling:
+		return "falling"
+	default:
+		return "steady"
+	}
+}
+
+func (t Trend) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Options configures the thresholds of the states and the hysteresis, a zero threshold is not checked
+type Options struct {
+	// UnstableLoss is the packet loss percentage that the network is unstable. Default is 2
+	UnstableLoss float64 `json:"unstable_loss"`
+	// CriticalLoss is the packet loss percentage that the network is critical. Default is 10
+	CriticalLoss float64 `json:"critical_loss"`
+	// UnstableJitter is the jitter in nanoseconds that the network is unstable. Default is 30ms
+	UnstableJitter time.Duration `json:"unstable_jitter"`
+	// CriticalJitter is the jitter in nanoseconds that the network is critical. Default is 100ms
+	CriticalJitter time.Duration `json:"critical_jitter"`
+	// UnstableRTT is the round trip time in nanoseconds that the network is unstable. Default is 300ms
+	UnstableRTT time.Duration `json:"unstable_rtt"`
+	// CriticalRTT is the round trip time in nanoseconds that the network is critical. Default is 1s
+	CriticalRTT time.Duration `json:"critical_rtt"`
+	// DegradeSamples is the number of the consecutive worse samples before the state is changed to worse. Default is 2
+	DegradeSamples uint8 `json:"degrade_samples"`
+	// RecoverSamples is the number of the consecutive better samples before the state is changed to better, it's higher
+	// than DegradeSamples so the state doesn't flap on a short recovery. Default is 5
+	RecoverSamples uint8 `json:"recover_samples"`
+	// TrendSamples is the number of the last round trip time samples that compared for the trend. Default is 5
+	TrendSamples int `json:"trend_samples"`
+}
+
+func DefaultOptions() Options {
+	return Options{
+		UnstableLoss:   2,
+		CriticalLoss:   10,
+		UnstableJitter: 30 * time.Millisecond,
+		CriticalJitter: 100 * time.Millisecond,
+		UnstableRTT:    300 * time.Millisecond,
+		CriticalRTT:    time.Second,
+		DegradeSamples: 2,
+		RecoverSamples: 5,
+		TrendSamples:   5,
+	}
+}
+
+// Sample is the network measurement since the previous sample
+type Sample struct {
+	PacketsReceived uint64
+	PacketsLost     uint64
+	Jitter          time.Duration
+	RTT             time.Duration
+}
+
+// Stats is the last measurement and the state after the hysteresis
+type Stats struct {
+	State       State         `json:"state"`
+	LossPercent float64       `json:"loss_percent"`
+	Jitter      time.Duration `json:"jitter"`
+	RTT         time.Duration `json:"rtt"`
+	RTTTrend    Trend         `json:"rtt_trend"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
 type NetworkMonitor struct {
-	context                           context.Context
-	receiverCondition                 NetworkConditionType
-	senderCondition                   NetworkConditionType
-	consecutiveConditionToChangeState uint8
-	consecutiveConditionCount         uint8
+	mu      sync.Mutex
+	options Options
+	stats   Stats
+	// the consecutive samples that worse or better than the current state, and the state to change to
+	degradeCount uint8
+	degradeTo    State
+	recoverCount uint8
+	recoverTo    State
+	rtts         []time.Duration
 }
 
-func New(ctx context.Context, interval time.Duration, consecutiveConditionToChangeState uint8) *NetworkMonitor {
+func New(opts Options) *NetworkMonitor {
+	if opts.DegradeSamples == 0 {
+		opts.DegradeSamples = 1
+	}
+
+	if opts.RecoverSamples == 0 {
+		opts.RecoverSamples = 1
+	}
+
 	return &NetworkMonitor{
-		context:                           ctx,
-		consecutiveConditionToChangeState: consecutiveConditionToChangeState,
+		options: opts,
+		rtts:    make([]time.Duration, 0, opts.TrendSamples),
+	}
+}
+
+// Stats returns the last measurement and the current state
+func (m *NetworkMonitor) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.stats
+}
+
+// Update adds the sample and returns the stats, the second value is true if the state is changed
+func (m *NetworkMonitor) Update(sample Sample) (Stats, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stats.LossPercent = 0
+	if total := sample.PacketsReceived + sample.PacketsLost; total > 0 {
+		m.stats.LossPercent = float64(sample.PacketsLost) * 100 / float64(total)
+	}
+
+	m.stats.Jitter = sample.Jitter
+	m.stats.RTT = sample.RTT
+	m.stats.RTTTrend = m.rttTrend(sample.RTT)
+	m.stats.UpdatedAt = time.Now()
+
+	measured := m.measuredState()
+	current := m.stats.State
+
+	switch {
+	case measured > current:
+		m.recoverCount = 0
+
+		// the state is changed to the least severe state of the consecutive worse samples
+		if m.degradeCount == 0 || measured < m.degradeTo {
+			m.degradeTo = measured
+		}
+
+		m.degradeCount++
+		if m.degradeCount < m.options.DegradeSamples {
+			return m.stats, false
+		}
+
+		m.stats.State = m.degradeTo
+	case measured < current:
+		m.degradeCount = 0
+
+		// the state is changed to the most severe state of the consecutive better samples
+		if m.recoverCount == 0 || measured > m.recoverTo {
+			m.recoverTo = measured
+		}
+
+		m.recoverCount++
+		if m.recoverCount < m.options.RecoverSamples {
+			return m.stats, false
+		}
+
+		m.stats.State = m.recoverTo
+	default:
+		m.degradeCount = 0
+		m.recoverCount = 0
+
+		return m.stats, false
+	}
+
+	m.degradeCount = 0
+	m.recoverCount = 0
+
+	return m.stats, true
+}
+
+// measuredState returns the state of the last sample without the hysteresis, the worst of the loss, jitter, and RTT
+func (m *NetworkMonitor) measuredState() State {
+	exceeds := func(value, threshold float64) bool {
+		return threshold > 0 && value >= threshold
+	}
+
+	opts := m.options
+	loss, jitter, rtt := m.stats.LossPercent, float64(m.stats.Jitter), float64(m.stats.RTT)
+
+	switch {
+	case exceeds(loss, opts.CriticalLoss) || exceeds(jitter, float64(opts.CriticalJitter)) || exceeds(rtt, float64(opts.CriticalRTT)):
+		return StateCritical
+	case exceeds(loss, opts.UnstableLoss) || exceeds(jitter, float64(opts.UnstableJitter)) || exceeds(rtt, float64(opts.UnstableRTT)):
+		return StateUnstable
+	default:
+		return StateStable
+	}
+}
+
+// rttTrend compares the average of the newer half of the last RTT samples with the older half,
+// a change less than 10 percent is steady
+func (m *NetworkMonitor) rttTrend(rtt time.Duration) Trend {
+	if m.options.TrendSamples < 2 || rtt == 0 {
+		return TrendSteady
+	}
+
+	m.rtts = append(m.rtts, rtt)
+	if len(m.rtts) > m.options.TrendSamples {
+		m.rtts = m.rtts[len(m.rtts)-m.options.TrendSamples:]
+	}
+
+	if len(m.rtts) < m.options.TrendSamples {
+		return TrendSteady
+	}
+
+	half := len(m.rtts) / 2
+
+	var older, newer time.Duration
+	for _, value := range m.rtts[:half] {
+		older += value
+	}
+
+	for _, value := range m.rtts[len(m.rtts)-half:] {
+		newer += value
+	}
+
+	switch {
+	case newer*10 > older*11:
+		return TrendRising
+	case newer*10 < older*9:
+		return TrendFalling
+	default:
+		return TrendSteady
 	}
 }
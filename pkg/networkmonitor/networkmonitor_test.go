@@ -0,0 +1,77 @@
+package networkmonitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateHysteresis(t *testing.T) {
+	m := New(DefaultOptions())
+
+	good := Sample{PacketsReceived: 1000, RTT: 50 * time.Millisecond}
+	lossy := Sample{PacketsReceived: 950, PacketsLost: 50, RTT: 50 * time.Millisecond}
+	critical := Sample{PacketsReceived: 800, PacketsLost: 200, RTT: 50 * time.Millisecond}
+
+	stats, changed := m.Update(lossy)
+	require.False(t, changed)
+	require.Equal(t, StateStable, stats.State)
+	require.InDelta(t, 5, stats.LossPercent, 0.001)
+
+	// the state is changed to the least severe of the consecutive worse samples
+	stats, changed = m.Update(critical)
+	require.True(t, changed)
+	require.Equal(t, StateUnstable, stats.State)
+
+	stats, changed = m.Update(critical)
+	require.False(t, changed)
+	require.Equal(t, StateUnstable, stats.State)
+
+	stats, changed = m.Update(critical)
+	require.True(t, changed)
+	require.Equal(t, StateCritical, stats.State)
+
+	// a short recovery doesn't change the state
+	for i := 0; i < 4; i++ {
+		_, changed = m.Update(good)
+		require.False(t, changed)
+	}
+
+	_, changed = m.Update(critical)
+	require.False(t, changed)
+
+	for i := 0; i < 4; i++ {
+		_, changed = m.Update(good)
+		require.False(t, changed)
+	}
+
+	stats, changed = m.Update(good)
+	require.True(t, changed)
+	require.Equal(t, StateStable, stats.State)
+	require.Equal(t, StateStable, m.Stats().State)
+}
+
+func TestRTTTrend(t *testing.T) {
+	m := New(DefaultOptions())
+
+	var stats Stats
+
+	for _, rtt := range []time.Duration{100, 100, 110, 150, 200} {
+		stats, _ = m.Update(Sample{RTT: rtt * time.Millisecond})
+	}
+
+	require.Equal(t, TrendRising, stats.RTTTrend)
+
+	for _, rtt := range []time.Duration{100, 100, 100, 100, 100} {
+		stats, _ = m.Update(Sample{RTT: rtt * time.Millisecond})
+	}
+
+	require.Equal(t, TrendSteady, stats.RTTTrend)
+
+	for _, rtt := range []time.Duration{100, 80, 60, 50, 40} {
+		stats, _ = m.Update(Sample{RTT: rtt * time.Millisecond})
+	}
+
+	require.Equal(t, TrendFalling, stats.RTTTrend)
+}
@@ -0,0 +1,149 @@
+// Package relaygrpc carries the relay between two SFUs over a gRPC bidirectional stream, for the SFUs in the same
+// datacenter where the ICE and DTLS handshake of a WebRTC link is not needed. The server side accepts the relays
+// with Server.Register, and the other side connects with sfu.Manager.ConnectRelay and Dial. The congestion feedback
+// of the relay works the same as on the other transports, the flow control of the stream drops the packets in the
+// relay send queue and the publishers are capped by the estimated bitrate.
+package relaygrpc
+
+//go:generate buf generate
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/inlivedev/sfu"
+	"github.com/inlivedev/sfu/pkg/relaygrpc/relaypb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements relaypb.RelayServiceServer on a manager
+type Server struct {
+	relaypb.UnimplementedRelayServiceServer
+	manager *sfu.Manager
+	opts    sfu.RelayOptions
+}
+
+func NewServer(manager *sfu.Manager, opts sfu.RelayOptions) *Server {
+	return &Server{
+		manager: manager,
+		opts:    opts,
+	}
+}
+
+// Register registers the relay service on the gRPC server
+func (s *Server) Register(registrar grpc.ServiceRegistrar) {
+	relaypb.RegisterRelayServiceServer(registrar, s)
+}
+
+func (s *Server) Relay(stream relaypb.RelayService_RelayServer) error {
+	conn := &serverConn{
+		stream: stream,
+		closed: make(chan struct{}),
+	}
+
+	if _, err := s.manager.AcceptRelay(conn, s.opts); err != nil {
+		return toStatus(err)
+	}
+
+	// the stream is ended when the handler returns, keep it until the relay closes or replaces the connection
+	select {
+	case <-conn.closed:
+	case <-stream.Context().Done():
+	}
+
+	return nil
+}
+
+// Dial returns a sfu.RelayDialFunc that opens the relay stream on the connection to the server,
+// the gRPC connection is not closed when the relay is closed
+func Dial(cc grpc.ClientConnInterface) sfu.RelayDialFunc {
+	client := relaypb.NewRelayServiceClient(cc)
+
+	return func(ctx context.Context) (sfu.RelayConn, error) {
+		// the stream outlives the dial, it's canceled once the relay closes the connection
+		streamCtx, cancel := context.WithCancel(context.Background())
+
+		stream, err := client.Relay(streamCtx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		return &clientConn{
+			stream: stream,
+			cancel: cancel,
+		}, nil
+	}
+}
+
+func toStatus(err error) error {
+	// the error of RelayOptions.Authorize
+	code := codes.PermissionDenied
+
+	switch {
+	case errors.Is(err, sfu.ErrRoomNotFound):
+		code = codes.NotFound
+	case errors.Is(err, sfu.ErrRelayInvalidHello), errors.Is(err, sfu.ErrCascadeSameNode):
+		code = codes.InvalidArgument
+	case errors.Is(err, sfu.ErrRelayTimeout):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, sfu.ErrCascadeNoNodeID):
+		code = codes.FailedPrecondition
+	}
+
+	return status.Error(code, err.Error())
+}
+
+type serverConn struct {
+	stream relaypb.RelayService_RelayServer
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *serverConn) ReadMessage() ([]byte, error) {
+	msg, err := c.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	return msg.GetData(), nil
+}
+
+func (c *serverConn) WriteMessage(data []byte) error {
+	return c.stream.Send(&relaypb.RelayMessage{Data: data})
+}
+
+// Close ends the stream by returning from the handler
+func (c *serverConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+
+	return nil
+}
+
+type clientConn struct {
+	stream relaypb.RelayService_RelayClient
+	cancel context.CancelFunc
+}
+
+func (c *clientConn) ReadMessage() ([]byte, error) {
+	msg, err := c.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	return msg.GetData(), nil
+}
+
+func (c *clientConn) WriteMessage(data []byte) error {
+	return c.stream.Send(&relaypb.RelayMessage{Data: data})
+}
+
+func (c *clientConn) Close() error {
+	c.cancel()
+	return nil
+}
@@ -0,0 +1,87 @@
+package relaygrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/inlivedev/sfu"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestRelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	managerA := sfu.NewManager(ctx, "node-a", sfu.DefaultOptions())
+	defer managerA.Close()
+
+	managerB := sfu.NewManager(ctx, "node-b", sfu.DefaultOptions())
+	defer managerB.Close()
+
+	roomA, err := managerA.NewRoom("room-1", "room", sfu.RoomTypeLocal, sfu.DefaultRoomOptions())
+	require.NoError(t, err)
+
+	_, err = managerB.NewRoom("room-1", "room", sfu.RoomTypeLocal, sfu.DefaultRoomOptions())
+	require.NoError(t, err)
+
+	_, err = managerB.NewRoom("room-2", "room", sfu.RoomTypeLocal, sfu.DefaultRoomOptions())
+	require.NoError(t, err)
+
+	serverOpts := sfu.DefaultRelayOptions()
+	serverOpts.ReconnectTimeout = 500 * time.Millisecond
+	serverOpts.Authorize = func(roomID, nodeID string) error {
+		if nodeID != "node-b" {
+			return errors.New("unknown node")
+		}
+
+		return nil
+	}
+
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	NewServer(managerA, serverOpts).Register(grpcServer)
+
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	// the room doesn't exist on the server
+	_, err = managerB.ConnectRelay(ctx, "room-2", Dial(conn), sfu.DefaultRelayOptions())
+	require.Equal(t, codes.NotFound, status.Code(err), err)
+
+	relay, err := managerB.ConnectRelay(ctx, "room-1", Dial(conn), sfu.DefaultRelayOptions())
+	require.NoError(t, err)
+	require.Equal(t, "node-a", relay.RemoteNode())
+	require.True(t, relay.IsConnected())
+
+	require.Eventually(t, func() bool {
+		relays := roomA.Relays()
+		return len(relays) == 1 && relays[0].RemoteNode() == "node-b" && relays[0].IsConnected()
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// the server closes the relay once the client is gone for the reconnect timeout
+	require.NoError(t, relay.Close())
+	require.Eventually(t, func() bool {
+		return len(roomA.Relays()) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,153 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: relaypb/relay.proto
+
+package relaypb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type RelayMessage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (x *RelayMessage) Reset() {
+	*x = RelayMessage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_relaypb_relay_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RelayMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RelayMessage) ProtoMessage() {}
+
+func (x *RelayMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_relaypb_relay_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RelayMessage.ProtoReflect.Descriptor instead.
+func (*RelayMessage) Descriptor() ([]byte, []int) {
+	return file_relaypb_relay_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RelayMessage) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+var File_relaypb_relay_proto protoreflect.FileDescriptor
+
+var file_relaypb_relay_proto_rawDesc = []byte{
+	0x0a, 0x13, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x70, 0x62, 0x2f, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x13, 0x69, 0x6e, 0x6c, 0x69, 0x76, 0x65, 0x2e, 0x73, 0x66,
+	0x75, 0x2e, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x2e, 0x76, 0x31, 0x22, 0x22, 0x0a, 0x0c, 0x52, 0x65,
+	0x6c, 0x61, 0x79, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61,
+	0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x32, 0x61,
+	0x0a, 0x0c, 0x52, 0x65, 0x6c, 0x61, 0x79, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x51,
+	0x0a, 0x05, 0x52, 0x65, 0x6c, 0x61, 0x79, 0x12, 0x21, 0x2e, 0x69, 0x6e, 0x6c, 0x69, 0x76, 0x65,
+	0x2e, 0x73, 0x66, 0x75, 0x2e, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x65,
+	0x6c, 0x61, 0x79, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x1a, 0x21, 0x2e, 0x69, 0x6e, 0x6c,
+	0x69, 0x76, 0x65, 0x2e, 0x73, 0x66, 0x75, 0x2e, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x2e, 0x76, 0x31,
+	0x2e, 0x52, 0x65, 0x6c, 0x61, 0x79, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x28, 0x01, 0x30,
+	0x01, 0x42, 0x30, 0x5a, 0x2e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f,
+	0x69, 0x6e, 0x6c, 0x69, 0x76, 0x65, 0x64, 0x65, 0x76, 0x2f, 0x73, 0x66, 0x75, 0x2f, 0x70, 0x6b,
+	0x67, 0x2f, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x67, 0x72, 0x70, 0x63, 0x2f, 0x72, 0x65, 0x6c, 0x61,
+	0x79, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_relaypb_relay_proto_rawDescOnce sync.Once
+	file_relaypb_relay_proto_rawDescData = file_relaypb_relay_proto_rawDesc
+)
+
+func file_relaypb_relay_proto_rawDescGZIP() []byte {
+	file_relaypb_relay_proto_rawDescOnce.Do(func() {
+		file_relaypb_relay_proto_rawDescData = protoimpl.X.CompressGZIP(file_relaypb_relay_proto_rawDescData)
+	})
+	return file_relaypb_relay_proto_rawDescData
+}
+
+var file_relaypb_relay_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_relaypb_relay_proto_goTypes = []any{
+	(*RelayMessage)(nil), // 0: inlive.sfu.relay.v1.RelayMessage
+}
+var file_relaypb_relay_proto_depIdxs = []int32{
+	0, // 0: inlive.sfu.relay.v1.RelayService.Relay:input_type -> inlive.sfu.relay.v1.RelayMessage
+	0, // 1: inlive.sfu.relay.v1.RelayService.Relay:output_type -> inlive.sfu.relay.v1.RelayMessage
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_relaypb_relay_proto_init() }
+func file_relaypb_relay_proto_init() {
+	if File_relaypb_relay_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_relaypb_relay_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*RelayMessage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_relaypb_relay_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_relaypb_relay_proto_goTypes,
+		DependencyIndexes: file_relaypb_relay_proto_depIdxs,
+		MessageInfos:      file_relaypb_relay_proto_msgTypes,
+	}.Build()
+	File_relaypb_relay_proto = out.File
+	file_relaypb_relay_proto_rawDesc = nil
+	file_relaypb_relay_proto_goTypes = nil
+	file_relaypb_relay_proto_depIdxs = nil
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"sync"
+	"sync/atomic"
 
 	"github.com/pion/interceptor"
 	"github.com/pion/rtp"
@@ -25,6 +26,9 @@ type PacketManager struct {
 	HeaderPool  *sync.Pool
 	PayloadPool *sync.Pool
 	AttrPool    *sync.Pool
+	// the number of the retainable packets and their payloads that not released yet
+	packetsInUse  atomic.Int64
+	payloadsInUse atomic.Int64
 }
 
 func NewPacketManager() *PacketManager {
@@ -102,14 +106,22 @@ func (m *PacketManager) NewPacket(header *rtp.Header, payload []byte, attr inter
 		}
 	}
 
+	m.packetsInUse.Add(1)
+	if p.buffer != nil {
+		m.payloadsInUse.Add(1)
+	}
+
 	return p, nil
 }
 
 func (m *PacketManager) releasePacket(header *rtp.Header, payload *[]byte, p *RetainablePacket) {
 	m.HeaderPool.Put(header)
+	m.packetsInUse.Add(-1)
+
 	if payload != nil {
 		copy(*payload, blankPayload)
 		m.PayloadPool.Put(payload)
+		m.payloadsInUse.Add(-1)
 	}
 
 	if p.attr != nil {
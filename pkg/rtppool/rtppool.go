@@ -2,6 +2,8 @@ package rtppool
 
 import (
 	"sync"
+	"sync/atomic"
+	"unsafe"
 
 	"github.com/pion/interceptor"
 	"github.com/pion/rtp"
@@ -10,10 +12,24 @@ import (
 type RTPPool struct {
 	pool          sync.Pool
 	PacketManager *PacketManager
+	// the number of the packets and payloads that taken and not returned yet
+	packetsInUse  atomic.Int64
+	payloadsInUse atomic.Int64
+}
+
+// Stats is the utilization of the pool
+type Stats struct {
+	// PacketsInUse is the number of the packets that taken from the pool and not returned yet
+	PacketsInUse int64 `json:"packets_in_use"`
+	// PayloadsInUse is the number of the payload buffers that taken from the pool and not returned yet
+	PayloadsInUse int64 `json:"payloads_in_use"`
 }
 
 var blankPayload = make([]byte, maxPayloadLen)
 
+// the size of a pooled packet struct without the payload
+var packetSize = int64(unsafe.Sizeof(rtp.Packet{}))
+
 func New() *RTPPool {
 	return &RTPPool{
 		pool: sync.Pool{
@@ -31,14 +47,17 @@ func (r *RTPPool) PutPacket(localPacket *rtp.Packet) {
 	copy(localPacket.Payload, blankPayload)
 
 	r.pool.Put(localPacket)
+	r.packetsInUse.Add(-1)
 }
 
 func (r *RTPPool) GetPacket() *rtp.Packet {
+	r.packetsInUse.Add(1)
 	ipacket := r.pool.Get()
 	return ipacket.(*rtp.Packet) //nolint:forcetypeassert
 }
 
 func (r *RTPPool) GetPayload() *[]byte {
+	r.payloadsInUse.Add(1)
 	ipayload := r.PacketManager.PayloadPool.Get()
 	return ipayload.(*[]byte) //nolint:forcetypeassert
 }
@@ -46,6 +65,22 @@ func (r *RTPPool) GetPayload() *[]byte {
 func (r *RTPPool) PutPayload(localPayload *[]byte) {
 	copy(*localPayload, blankPayload)
 	r.PacketManager.PayloadPool.Put(localPayload)
+	r.payloadsInUse.Add(-1)
+}
+
+// MemoryBytes estimates the memory of the packets and payloads that in use, a payload buffer is always allocated
+// with the maximum payload size
+func (s Stats) MemoryBytes() int64 {
+	return s.PacketsInUse*packetSize + s.PayloadsInUse*maxPayloadLen
+}
+
+// Stats returns the number of the packets and payloads that in use, a number that keeps growing is a leak
+func (r *RTPPool) Stats() Stats {
+	// the retainable packets that shared with the subscribers are counted until the last reference is released
+	return Stats{
+		PacketsInUse:  r.packetsInUse.Load() + r.PacketManager.packetsInUse.Load(),
+		PayloadsInUse: r.payloadsInUse.Load() + r.PacketManager.payloadsInUse.Load(),
+	}
 }
 
 func (r *RTPPool) NewPacket(header *rtp.Header, payload []byte, attr interceptor.Attributes) *RetainablePacket {
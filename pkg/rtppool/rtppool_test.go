@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
 )
 
 var testPacket = &rtp.Packet{
@@ -75,3 +76,33 @@ func BenchmarkPayloadPool(b *testing.B) {
 		pool.Put(p)
 	}
 }
+
+func TestPoolStats(t *testing.T) {
+	pool := New()
+
+	p := pool.GetPacket()
+	payload := pool.GetPayload()
+
+	require.Equal(t, Stats{PacketsInUse: 1, PayloadsInUse: 1}, pool.Stats())
+	require.Equal(t, packetSize+maxPayloadLen, pool.Stats().MemoryBytes())
+
+	pool.PutPacket(p)
+	pool.PutPayload(payload)
+
+	require.Equal(t, Stats{}, pool.Stats())
+}
+
+func TestPoolStatsRetainablePacket(t *testing.T) {
+	pool := New()
+
+	p := pool.NewPacket(header, payload, nil)
+	require.NoError(t, p.Retain())
+
+	require.Equal(t, Stats{PacketsInUse: 1, PayloadsInUse: 1}, pool.Stats())
+
+	p.Release()
+	require.Equal(t, Stats{PacketsInUse: 1, PayloadsInUse: 1}, pool.Stats())
+
+	p.Release()
+	require.Equal(t, Stats{}, pool.Stats())
+}
@@ -0,0 +1,422 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/inlivedev/sfu"
+	"github.com/inlivedev/sfu/pkg/token"
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+)
+
+var ErrRenegotiationTimeout = errors.New("signaling: error timeout waiting for the answer")
+
+type Options struct {
+	// Verifier validates the token of the join request, the client ID, name, role, and allowed sources are from the
+	// token claims. Default is nil means the client ID and name are from the join request
+	Verifier *token.Verifier
+	// ClientOptions returns the options of the joining client, return an error to reject the join. Default is nil
+	// means sfu.DefaultClientOptions
+	ClientOptions func(join Join) (sfu.ClientOptions, error)
+	// RenegotiationTimeout is how long the SFU waits for the answer of its offer. Default is 30 seconds
+	RenegotiationTimeout time.Duration
+	Log                  logging.LeveledLogger
+}
+
+func DefaultOptions() Options {
+	return Options{
+		RenegotiationTimeout: 30 * time.Second,
+		Log:                  logging.NewDefaultLoggerFactory().NewLogger("signaling"),
+	}
+}
+
+// Session is the signaling state of a connection, it joins one client to a room and translates the protocol messages
+// to the client methods and the client events to the protocol messages. The send function is called from multiple
+// goroutines, but never concurrently.
+type Session struct {
+	manager *sfu.Manager
+	opts    Options
+	sendMu  sync.Mutex
+	send    func(Message) error
+	context context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	room    *sfu.Room
+	client  *sfu.Client
+	// the answers of the SFU offers by the offer ID
+	answers  map[string]chan webrtc.SessionDescription
+	offerSeq atomic.Uint64
+}
+
+// NewSession returns a session that sends the messages to the client with send
+func NewSession(manager *sfu.Manager, opts Options, send func(Message) error) *Session {
+	if opts.RenegotiationTimeout <= 0 {
+		opts.RenegotiationTimeout = DefaultOptions().RenegotiationTimeout
+	}
+
+	if opts.Log == nil {
+		opts.Log = DefaultOptions().Log
+	}
+
+	ctx, cancel := context.WithCancel(manager.Context())
+
+	return &Session{
+		manager: manager,
+		opts:    opts,
+		send:    send,
+		context: ctx,
+		cancel:  cancel,
+		answers: make(map[string]chan webrtc.SessionDescription),
+	}
+}
+
+// Done is closed when the session is closed or the client left the room, the transport should be closed then
+func (s *Session) Done() <-chan struct{} {
+	return s.context.Done()
+}
+
+// Client returns the joined client, nil before the join
+func (s *Session) Client() *sfu.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.client
+}
+
+// Close stops the joined client and closes the session
+func (s *Session) Close() {
+	s.mu.Lock()
+	room, client := s.room, s.client
+	s.mu.Unlock()
+
+	if client != nil && client.Context().Err() == nil {
+		if err := room.StopClient(client.ID()); err != nil {
+			s.opts.Log.Warnf("signaling: error stop client %s: %s", client.ID(), err)
+		}
+	}
+
+	s.cancel()
+}
+
+// Handle handles a message from the client, the failed requests are answered with the error message
+func (s *Session) Handle(msg Message) {
+	if msg.Version != Version {
+		s.sendError(msg.ID, ErrCodeUnsupportedVersion, fmt.Sprintf("signaling: unsupported protocol version %d, the server supports %d", msg.Version, Version))
+		return
+	}
+
+	var err error
+
+	switch msg.Type {
+	case TypeJoin:
+		err = s.handleJoin(msg)
+	case TypePing:
+		s.reply(msg.ID, TypePong, nil)
+	case TypeLeave:
+		s.reply(msg.ID, TypeAck, nil)
+		s.Close()
+	case TypeOffer, TypeAnswer, TypeCandidate, TypeSetTrackSources, TypeSubscribe, TypeSetQuality, TypeTrackQuality, TypeTrackPause:
+		client := s.Client()
+		if client == nil {
+			s.sendError(msg.ID, ErrCodeNotJoined, ErrNotJoined.Error())
+			return
+		}
+
+		err = s.handleClientMessage(client, msg)
+	default:
+		s.sendError(msg.ID, ErrCodeUnknownType, fmt.Sprintf("signaling: unknown message type %q", msg.Type))
+		return
+	}
+
+	if err != nil {
+		code := ErrCodeRequestFailed
+
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+
+		switch {
+		case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+			code = ErrCodeInvalidMessage
+		case errors.Is(err, ErrAlreadyJoined):
+			code = ErrCodeAlreadyJoined
+		case msg.Type == TypeJoin:
+			code = ErrCodeJoinFailed
+		case msg.Type == TypeOffer:
+			code = ErrCodeNegotiationFailed
+		}
+
+		s.sendError(msg.ID, code, err.Error())
+	}
+}
+
+func (s *Session) handleJoin(msg Message) error {
+	join := Join{}
+	if err := json.Unmarshal(msg.Data, &join); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil {
+		return ErrAlreadyJoined
+	}
+
+	room, err := s.manager.GetRoom(join.RoomID)
+	if err != nil {
+		return err
+	}
+
+	opts := sfu.DefaultClientOptions()
+	if s.opts.ClientOptions != nil {
+		if opts, err = s.opts.ClientOptions(join); err != nil {
+			return err
+		}
+	}
+
+	var client *sfu.Client
+
+	if s.opts.Verifier != nil {
+		client, err = room.AddClientWithToken(s.opts.Verifier, join.Token, opts)
+	} else {
+		id := join.ClientID
+		if id == "" {
+			id = room.CreateClientID()
+		}
+
+		name := join.Name
+		if name == "" {
+			name = id
+		}
+
+		client, err = room.AddClient(id, name, opts)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.room = room
+	s.client = client
+	s.registerCallbacks(client)
+
+	// the session is done when the client is stopped by the room, like kicked or the room is closed
+	context.AfterFunc(client.Context(), s.cancel)
+
+	s.reply(msg.ID, TypeJoined, Joined{RoomID: room.ID(), ClientID: client.ID()})
+
+	return nil
+}
+
+func (s *Session) handleClientMessage(client *sfu.Client, msg Message) error {
+	switch msg.Type {
+	case TypeOffer:
+		offer := SessionDescription{}
+		if err := json.Unmarshal(msg.Data, &offer); err != nil {
+			return err
+		}
+
+		answer, err := client.NegotiateContext(s.context, offer)
+		if err != nil {
+			return err
+		}
+
+		s.reply(msg.ID, TypeAnswer, answer)
+
+		return nil
+	case TypeAnswer:
+		answer := SessionDescription{}
+		if err := json.Unmarshal(msg.Data, &answer); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		answerChan, ok := s.answers[msg.ID]
+		delete(s.answers, msg.ID)
+		s.mu.Unlock()
+
+		if !ok {
+			return fmt.Errorf("signaling: no pending offer with ID %q", msg.ID)
+		}
+
+		answerChan <- answer
+
+		return nil
+	case TypeCandidate:
+		candidate := Candidate{}
+		if err := json.Unmarshal(msg.Data, &candidate); err != nil {
+			return err
+		}
+
+		if err := client.AddICECandidate(candidate); err != nil {
+			return err
+		}
+	case TypeSetTrackSources:
+		sources := TrackSources{}
+		if err := json.Unmarshal(msg.Data, &sources); err != nil {
+			return err
+		}
+
+		client.SetTracksSource(sources)
+	case TypeSubscribe:
+		req := Subscribe{}
+		if err := json.Unmarshal(msg.Data, &req); err != nil {
+			return err
+		}
+
+		if err := client.SubscribeTracks(req); err != nil {
+			return err
+		}
+	case TypeSetQuality:
+		quality := Quality{}
+		if err := json.Unmarshal(msg.Data, &quality); err != nil {
+			return err
+		}
+
+		level, ok := qualityLevels[quality.Quality]
+		if !ok {
+			return ErrInvalidQuality
+		}
+
+		client.SetQuality(level)
+	case TypeTrackQuality:
+		quality := TrackQuality{}
+		if err := json.Unmarshal(msg.Data, &quality); err != nil {
+			return err
+		}
+
+		if err := setTrackQuality(client, quality); err != nil {
+			return err
+		}
+	case TypeTrackPause:
+		pause := TrackPause{}
+		if err := json.Unmarshal(msg.Data, &pause); err != nil {
+			return err
+		}
+
+		var err error
+		if pause.Paused {
+			err = client.PauseTrack(pause.TrackID)
+		} else {
+			err = client.ResumeTrack(pause.TrackID)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// the commands without an ID are not acknowledged
+	if msg.ID != "" {
+		s.reply(msg.ID, TypeAck, nil)
+	}
+
+	return nil
+}
+
+func setTrackQuality(client *sfu.Client, quality TrackQuality) error {
+	switch quality.Quality {
+	case "":
+		return client.UnpinTrackQuality(quality.TrackID)
+	case "high", "mid", "low":
+		return client.PinTrackQuality(quality.TrackID, sfu.RIDToQuality(quality.Quality))
+	}
+
+	return ErrInvalidQuality
+}
+
+func (s *Session) registerCallbacks(client *sfu.Client) {
+	client.OnRenegotiation(s.renegotiate)
+
+	client.OnAllowedRemoteRenegotiation(func() {
+		s.event(TypeAllowRenegotiation, nil)
+	})
+
+	client.OnIceCandidate(func(ctx context.Context, candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+
+		s.event(TypeCandidate, candidate.ToJSON())
+	})
+
+	client.OnTracksAdded(func(tracks []sfu.ITrack) {
+		s.event(TypeTracksAdded, newTracks(tracks))
+	})
+
+	client.OnTracksAvailable(func(tracks []sfu.ITrack) {
+		s.event(TypeTracksAvailable, newTracks(tracks))
+	})
+
+	client.OnTrackRemoved(func(sourceType string, track *webrtc.TrackLocalStaticRTP) {
+		s.event(TypeTrackRemoved, TrackRemoved{TrackID: track.ID(), StreamID: track.StreamID(), Source: sourceType})
+	})
+}
+
+// renegotiate sends the offer of the SFU and waits for the answer with the same ID
+func (s *Session) renegotiate(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	id := fmt.Sprintf("sfu-%d", s.offerSeq.Add(1))
+	answerChan := make(chan webrtc.SessionDescription, 1)
+
+	s.mu.Lock()
+	s.answers[id] = answerChan
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.answers, id)
+		s.mu.Unlock()
+	}()
+
+	s.reply(id, TypeOffer, offer)
+
+	timeout, cancel := context.WithTimeout(ctx, s.opts.RenegotiationTimeout)
+	defer cancel()
+
+	select {
+	case <-timeout.Done():
+		return webrtc.SessionDescription{}, ErrRenegotiationTimeout
+	case <-s.context.Done():
+		return webrtc.SessionDescription{}, s.context.Err()
+	case answer := <-answerChan:
+		return answer, nil
+	}
+}
+
+func newTracks(tracks []sfu.ITrack) []Track {
+	result := make([]Track, 0, len(tracks))
+	for _, track := range tracks {
+		result = append(result, newTrack(track))
+	}
+
+	return result
+}
+
+func (s *Session) event(messageType Type, data interface{}) {
+	s.reply("", messageType, data)
+}
+
+func (s *Session) reply(id string, messageType Type, data interface{}) {
+	msg, err := NewMessage(id, messageType, data)
+	if err != nil {
+		s.opts.Log.Errorf("signaling: error marshal %s message: %s", messageType, err)
+		return
+	}
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	if err := s.send(msg); err != nil {
+		s.opts.Log.Debugf("signaling: error send %s message: %s", messageType, err)
+	}
+}
+
+func (s *Session) sendError(id, code, message string) {
+	s.reply(id, TypeError, Error{Code: code, Message: message})
+}
@@ -0,0 +1,205 @@
+// Package signaling defines a versioned JSON signaling protocol between the clients and the SFU, and serves it over a
+// WebSocket with NewWebSocketHandler. Use it instead of inventing a wire format for every integration, the protocol
+// is transport agnostic so the Session can also be driven from another transport.
+//
+// Every message is a JSON object with the protocol version, the message type, an optional request ID, and the data:
+//
+//	{"v":1,"id":"1","type":"join","data":{"room_id":"room-1","name":"alice"}}
+//
+// A request with an ID is answered with a message of the same ID: joined, answer, or pong for the requests with a
+// result, ack for the commands, or error. The messages from the SFU without an ID are events.
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/inlivedev/sfu"
+	"github.com/pion/webrtc/v4"
+)
+
+// Version is the version of the protocol, the messages with another version are rejected with ErrCodeUnsupportedVersion
+const Version = 1
+
+type Type string
+
+const (
+	// TypeJoin joins a room, sent by the client. The response is joined
+	TypeJoin Type = "join"
+	// TypeJoined is the response of join with the client ID, sent by the SFU
+	TypeJoined Type = "joined"
+	// TypeLeave leaves the room, sent by the client
+	TypeLeave Type = "leave"
+	// TypeOffer is the SDP offer, sent by the client to publish or by the SFU to renegotiate the subscribed tracks.
+	// The offer of the client is answered with answer, and the SFU offer must be answered with the same ID
+	TypeOffer Type = "offer"
+	// TypeAnswer is the SDP answer of an offer
+	TypeAnswer Type = "answer"
+	// TypeCandidate is a trickle ICE candidate, sent by both sides
+	TypeCandidate Type = "candidate"
+	// TypeAllowRenegotiation tells the client it can send a new offer, sent by the SFU
+	TypeAllowRenegotiation Type = "allow_renegotiation"
+	// TypeTracksAdded lists the tracks that published by the client, sent by the SFU. The client should answer with
+	// set_track_sources so the tracks are available to the other clients
+	TypeTracksAdded Type = "tracks_added"
+	// TypeTracksAvailable lists the tracks of the other clients that can be subscribed, sent by the SFU
+	TypeTracksAvailable Type = "tracks_available"
+	// TypeTrackRemoved is a subscribed track that ended, sent by the SFU
+	TypeTrackRemoved Type = "track_removed"
+	// TypeSetTrackSources sets the source type of the published tracks, sent by the client
+	TypeSetTrackSources Type = "set_track_sources"
+	// TypeSubscribe subscribes to the available tracks, sent by the client
+	TypeSubscribe Type = "subscribe"
+	// TypeSetQuality sets the max quality of all subscribed videos, sent by the client
+	TypeSetQuality Type = "set_quality"
+	// TypeTrackQuality pins the quality of a subscribed video, an empty quality unpins it, sent by the client
+	TypeTrackQuality Type = "track_quality"
+	// TypeTrackPause pauses or resumes a subscribed video, sent by the client
+	TypeTrackPause Type = "track_pause"
+	// TypePing checks the connection, sent by the client. The response is pong
+	TypePing Type = "ping"
+	TypePong Type = "pong"
+	// TypeAck is the response of a command that has no result
+	TypeAck Type = "ack"
+	// TypeError is the response of a failed request, or an event if the SFU fails without a request
+	TypeError Type = "error"
+)
+
+// the codes of the error messages
+const (
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeInvalidMessage     = "invalid_message"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeNotJoined          = "not_joined"
+	ErrCodeAlreadyJoined      = "already_joined"
+	ErrCodeJoinFailed         = "join_failed"
+	ErrCodeNegotiationFailed  = "negotiation_failed"
+	ErrCodeRequestFailed      = "request_failed"
+)
+
+var (
+	ErrNotJoined      = errors.New("signaling: error the session has not joined a room")
+	ErrAlreadyJoined  = errors.New("signaling: error the session already joined a room")
+	ErrInvalidQuality = errors.New("signaling: error invalid quality")
+)
+
+// Message is the envelope of all messages of the protocol
+type Message struct {
+	Version int `json:"v"`
+	// ID correlates a response with its request, it's chosen by the sender of the request
+	ID   string          `json:"id,omitempty"`
+	Type Type            `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// NewMessage returns a message of the current version with the data encoded as JSON
+func NewMessage(id string, messageType Type, data interface{}) (Message, error) {
+	msg := Message{Version: Version, ID: id, Type: messageType}
+
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return msg, err
+		}
+
+		msg.Data = raw
+	}
+
+	return msg, nil
+}
+
+// Join is the data of the join message. ClientID and Name are optional, and ignored when the Token is verified
+type Join struct {
+	RoomID   string `json:"room_id"`
+	ClientID string `json:"client_id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	// Token is the join token that signed with pkg/token, it's required when Options.Verifier is set
+	Token string `json:"token,omitempty"`
+}
+
+// Joined is the data of the joined message
+type Joined struct {
+	RoomID   string `json:"room_id"`
+	ClientID string `json:"client_id"`
+}
+
+// SessionDescription is the data of the offer and answer messages
+type SessionDescription = webrtc.SessionDescription
+
+// Candidate is the data of the candidate message
+type Candidate = webrtc.ICECandidateInit
+
+// Track is a published track in the tracks_added and tracks_available messages
+type Track struct {
+	ID        string `json:"id"`
+	ClientID  string `json:"client_id"`
+	StreamID  string `json:"stream_id"`
+	Kind      string `json:"kind"`
+	Source    string `json:"source"`
+	Label     string `json:"label,omitempty"`
+	Simulcast bool   `json:"simulcast"`
+}
+
+// TrackRemoved is the data of the track_removed message
+type TrackRemoved struct {
+	TrackID  string `json:"track_id"`
+	StreamID string `json:"stream_id"`
+	Source   string `json:"source"`
+}
+
+// TrackSources is the data of the set_track_sources message, the source type and the label of each published track by
+// the track ID, the type is media, camera, or screen
+type TrackSources map[string]sfu.TrackSource
+
+// Subscribe is the data of the subscribe message
+type Subscribe []sfu.SubscribeTrackRequest
+
+// Quality is the data of the set_quality message: high, mid, low, or none, including the temporal levels like highmid
+type Quality struct {
+	Quality string `json:"quality"`
+}
+
+// TrackQuality is the data of the track_quality message, the quality is high, mid, or low, empty unpins the quality
+type TrackQuality struct {
+	TrackID string `json:"track_id"`
+	Quality string `json:"quality"`
+}
+
+// TrackPause is the data of the track_pause message
+type TrackPause struct {
+	TrackID string `json:"track_id"`
+	Paused  bool   `json:"paused"`
+}
+
+// Error is the data of the error message
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var qualityLevels = map[string]sfu.QualityLevel{
+	"high":    sfu.QualityHigh,
+	"highmid": sfu.QualityHighMid,
+	"highlow": sfu.QualityHighLow,
+	"mid":     sfu.QualityMid,
+	"midmid":  sfu.QualityMidMid,
+	"midlow":  sfu.QualityMidLow,
+	"low":     sfu.QualityLow,
+	"lowmid":  sfu.QualityLowMid,
+	"lowlow":  sfu.QualityLowLow,
+	"none":    sfu.QualityNone,
+}
+
+func newTrack(track sfu.ITrack) Track {
+	source := track.Source()
+
+	return Track{
+		ID:        track.ID(),
+		ClientID:  track.ClientID(),
+		StreamID:  track.StreamID(),
+		Kind:      track.Kind().String(),
+		Source:    string(source.Type),
+		Label:     source.Label,
+		Simulcast: track.IsSimulcast(),
+	}
+}
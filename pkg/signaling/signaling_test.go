@@ -0,0 +1,136 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inlivedev/sfu"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func TestWebSocketHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := sfu.NewManager(ctx, "test", sfu.DefaultOptions())
+	defer roomManager.Close()
+
+	room, err := roomManager.NewRoom("room", "test-room", sfu.RoomTypeLocal, sfu.DefaultRoomOptions())
+	require.NoError(t, err)
+
+	server := httptest.NewServer(NewWebSocketHandler(roomManager, DefaultOptions()))
+	defer server.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	send := func(id string, messageType Type, data interface{}) {
+		msg, err := NewMessage(id, messageType, data)
+		require.NoError(t, err)
+		require.NoError(t, websocket.JSON.Send(conn, msg))
+	}
+
+	// the events like the ICE candidates are skipped until the response of the request
+	receive := func(id string) Message {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Second)))
+
+		for {
+			msg := Message{}
+			require.NoError(t, websocket.JSON.Receive(conn, &msg))
+			require.Equal(t, Version, msg.Version)
+
+			if msg.ID == id {
+				return msg
+			}
+		}
+	}
+
+	requireError := func(msg Message, code string) {
+		require.Equal(t, TypeError, msg.Type)
+
+		data := Error{}
+		require.NoError(t, json.Unmarshal(msg.Data, &data))
+		require.Equal(t, code, data.Code)
+	}
+
+	send("1", TypePing, nil)
+	require.Equal(t, TypePong, receive("1").Type)
+
+	msg, err := NewMessage("2", TypePing, nil)
+	require.NoError(t, err)
+	msg.Version = Version + 1
+	require.NoError(t, websocket.JSON.Send(conn, msg))
+	requireError(receive("2"), ErrCodeUnsupportedVersion)
+
+	send("3", TypeSubscribe, Subscribe{})
+	requireError(receive("3"), ErrCodeNotJoined)
+
+	send("4", Type("unknown"), nil)
+	requireError(receive("4"), ErrCodeUnknownType)
+
+	send("5", TypeJoin, Join{RoomID: "unknown"})
+	requireError(receive("5"), ErrCodeJoinFailed)
+
+	send("6", TypeJoin, Join{RoomID: room.ID(), ClientID: "alice"})
+	msg = receive("6")
+	require.Equal(t, TypeJoined, msg.Type)
+
+	joined := Joined{}
+	require.NoError(t, json.Unmarshal(msg.Data, &joined))
+	require.Equal(t, Joined{RoomID: room.ID(), ClientID: "alice"}, joined)
+
+	client, err := room.SFU().GetClient("alice")
+	require.NoError(t, err)
+
+	send("7", TypeJoin, Join{RoomID: room.ID()})
+	requireError(receive("7"), ErrCodeAlreadyJoined)
+
+	// publish the offer and get the answer of the SFU
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	defer pc.Close()
+
+	_, err = pc.CreateDataChannel("test", nil)
+	require.NoError(t, err)
+
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+	require.NoError(t, pc.SetLocalDescription(offer))
+
+	send("8", TypeOffer, offer)
+	msg = receive("8")
+	require.Equal(t, TypeAnswer, msg.Type)
+
+	answer := SessionDescription{}
+	require.NoError(t, json.Unmarshal(msg.Data, &answer))
+	require.Equal(t, webrtc.SDPTypeAnswer, answer.Type)
+	require.NoError(t, pc.SetRemoteDescription(answer))
+
+	send("9", TypeSetQuality, Quality{Quality: "mid"})
+	require.Equal(t, TypeAck, receive("9").Type)
+
+	send("10", TypeSetQuality, Quality{Quality: "ultra"})
+	requireError(receive("10"), ErrCodeRequestFailed)
+
+	send("11", TypeTrackPause, json.RawMessage(`{"track_id":1}`))
+	requireError(receive("11"), ErrCodeInvalidMessage)
+
+	// leaving stops the client and closes the connection
+	send("12", TypeLeave, nil)
+	require.Equal(t, TypeAck, receive("12").Type)
+
+	select {
+	case <-client.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the client to stop")
+	}
+}
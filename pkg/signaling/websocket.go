@@ -0,0 +1,45 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/inlivedev/sfu"
+	"golang.org/x/net/websocket"
+)
+
+// NewWebSocketHandler returns the reference WebSocket handler of the protocol, each connection is a Session that
+// joins one client. The connection is closed when the client leaves or it's stopped by the room, and the client is
+// stopped when the connection is closed. The handshake requires the Origin header like the browsers send.
+func NewWebSocketHandler(manager *sfu.Manager, opts Options) websocket.Handler {
+	return func(conn *websocket.Conn) {
+		session := NewSession(manager, opts, func(msg Message) error {
+			return websocket.JSON.Send(conn, msg)
+		})
+
+		defer session.Close()
+
+		go func() {
+			<-session.Done()
+			_ = conn.Close()
+		}()
+
+		for {
+			msg := Message{}
+			if err := websocket.JSON.Receive(conn, &msg); err != nil {
+				var syntaxErr *json.SyntaxError
+				var typeErr *json.UnmarshalTypeError
+
+				// the frame is read, the connection is still usable
+				if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+					session.sendError("", ErrCodeInvalidMessage, err.Error())
+					continue
+				}
+
+				return
+			}
+
+			session.Handle(msg)
+		}
+	}
+}
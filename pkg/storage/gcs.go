@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// the chunk of the resumable upload must be a multiple of 256 KiB except the last chunk
+	gcsChunkUnit = 256 << 10
+	// the status of an incomplete chunk upload, "Resume Incomplete"
+	gcsStatusResumeIncomplete = 308
+)
+
+type GCSOptions struct {
+	// Endpoint is the URL of the Cloud Storage JSON API, default is https://storage.googleapis.com
+	Endpoint string
+	Bucket   string
+	// TokenSource returns the OAuth2 access token of the requests, for example from the golang.org/x/oauth2/google
+	// default credentials: func(ctx context.Context) (string, error) { t, err := ts.Token(); return t.AccessToken, err }
+	TokenSource func(ctx context.Context) (string, error)
+	// ChunkSize is the size of every chunk of the resumable upload, it's rounded down to a multiple of 256 KiB. Default is 8 MiB
+	ChunkSize int
+	Retry     RetryOptions
+	// Client is the HTTP client to send the requests, the default client has a 5 minutes timeout per request
+	Client *http.Client
+}
+
+func DefaultGCSOptions() GCSOptions {
+	return GCSOptions{
+		Endpoint:  "https://storage.googleapis.com",
+		ChunkSize: 8 << 20,
+		Retry:     DefaultRetryOptions(),
+		Client:    &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// GCS uploads the objects to a Google Cloud Storage bucket with the resumable upload, every chunk is retried on its own
+// so a network error doesn't restart the whole upload
+type GCS struct {
+	opts GCSOptions
+}
+
+func NewGCS(opts GCSOptions) *GCS {
+	defaults := DefaultGCSOptions()
+
+	if opts.Endpoint == "" {
+		opts.Endpoint = defaults.Endpoint
+	}
+
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaults.ChunkSize
+	}
+
+	opts.ChunkSize = max(opts.ChunkSize/gcsChunkUnit, 1) * gcsChunkUnit
+
+	if opts.Retry.RetryInterval <= 0 {
+		opts.Retry.RetryInterval = defaults.Retry.RetryInterval
+	}
+
+	if opts.Client == nil {
+		opts.Client = defaults.Client
+	}
+
+	return &GCS{opts: opts}
+}
+
+type gcsObject struct {
+	Name      string `json:"name"`
+	Size      string `json:"size"`
+	ETag      string `json:"etag"`
+	MediaLink string `json:"mediaLink"`
+}
+
+// Put starts a resumable upload session and uploads the body chunk by chunk, the object is created with the last chunk
+func (g *GCS) Put(ctx context.Context, key string, body io.Reader, _ int64) (Object, error) {
+	if key == "" {
+		return Object{}, ErrInvalidKey
+	}
+
+	session, err := g.startSession(ctx, key)
+	if err != nil {
+		return Object{}, err
+	}
+
+	buf := make([]byte, g.opts.ChunkSize)
+	offset := int64(0)
+
+	for {
+		chunk, last, err := readPart(body, buf)
+		if err != nil {
+			g.cancelSession(session)
+			return Object{}, err
+		}
+
+		result, err := g.uploadChunk(ctx, session, chunk, offset, last)
+		if err != nil {
+			g.cancelSession(session)
+			return Object{}, err
+		}
+
+		offset += int64(len(chunk))
+
+		if last {
+			size, _ := strconv.ParseInt(result.Size, 10, 64)
+
+			return Object{
+				Key:  key,
+				Size: size,
+				URL:  result.MediaLink,
+				ETag: result.ETag,
+			}, nil
+		}
+	}
+}
+
+func (g *GCS) authorize(ctx context.Context, req *http.Request) error {
+	if g.opts.TokenSource == nil {
+		return nil
+	}
+
+	token, err := g.opts.TokenSource(ctx)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return nil
+}
+
+func (g *GCS) startSession(ctx context.Context, key string) (string, error) {
+	var session string
+
+	u := strings.TrimSuffix(g.opts.Endpoint, "/") + "/upload/storage/v1/b/" + url.PathEscape(g.opts.Bucket) + "/o?" + url.Values{
+		"uploadType": {"resumable"},
+		"name":       {key},
+	}.Encode()
+
+	err := retry(ctx, g.opts.Retry, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+		if err != nil {
+			return err
+		}
+
+		if err := g.authorize(ctx, req); err != nil {
+			return err
+		}
+
+		res, err := g.opts.Client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return newStatusError(res)
+		}
+
+		session = res.Header.Get("Location")
+		if session == "" {
+			return fmt.Errorf("%w: empty upload session", ErrUploadFailed)
+		}
+
+		return nil
+	})
+
+	return session, err
+}
+
+// uploadChunk uploads the chunk at the offset of the object, the total size is only known with the last chunk
+func (g *GCS) uploadChunk(ctx context.Context, session string, chunk []byte, offset int64, last bool) (gcsObject, error) {
+	var result gcsObject
+
+	total := "*"
+	if last {
+		total = strconv.FormatInt(offset+int64(len(chunk)), 10)
+	}
+
+	contentRange := fmt.Sprintf("bytes %d-%d/%s", offset, offset+int64(len(chunk))-1, total)
+	if len(chunk) == 0 {
+		// the object size is a multiple of the chunk size, the last request only finalizes the object
+		contentRange = "bytes */" + total
+	}
+
+	err := retry(ctx, g.opts.Retry, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, session, bytes.NewReader(chunk))
+		if err != nil {
+			return err
+		}
+
+		req.ContentLength = int64(len(chunk))
+		req.Header.Set("Content-Range", contentRange)
+
+		if err := g.authorize(ctx, req); err != nil {
+			return err
+		}
+
+		res, err := g.opts.Client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		defer res.Body.Close()
+
+		switch {
+		case !last && res.StatusCode == gcsStatusResumeIncomplete:
+			return nil
+		case last && (res.StatusCode == http.StatusOK || res.StatusCode == http.StatusCreated):
+			return json.NewDecoder(res.Body).Decode(&result)
+		default:
+			return newStatusError(res)
+		}
+	})
+
+	return result, err
+}
+
+// cancelSession deletes the incomplete upload, the session is expired after a week anyway
+func (g *GCS) cancelSession(session string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, session, nil)
+	if err != nil {
+		return
+	}
+
+	if err := g.authorize(ctx, req); err != nil {
+		return
+	}
+
+	res, err := g.opts.Client.Do(req)
+	if err != nil {
+		return
+	}
+
+	res.Body.Close()
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores the objects as the files in a directory, the key can contain slashes for the subdirectories
+type Local struct {
+	directory string
+}
+
+func NewLocal(directory string) *Local {
+	return &Local{directory: directory}
+}
+
+// Put writes the body to a temporary file and renames it to the key once it's complete, so a partial file is never
+// seen with the object name
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, _ int64) (Object, error) {
+	if key == "" || strings.Contains(key, "..") {
+		return Object{}, ErrInvalidKey
+	}
+
+	path := filepath.Join(l.directory, filepath.FromSlash(key))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return Object{}, err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return Object{}, err
+	}
+
+	defer os.Remove(file.Name())
+
+	size, err := io.Copy(file, &contextReader{ctx: ctx, reader: body})
+	if err != nil {
+		file.Close()
+		return Object{}, err
+	}
+
+	if err := file.Close(); err != nil {
+		return Object{}, err
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return Object{}, err
+	}
+
+	return Object{
+		Key:  key,
+		Size: size,
+		URL:  path,
+	}, nil
+}
+
+// contextReader stops the copy when the context is canceled
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.reader.Read(p)
+}
@@ -0,0 +1,476 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// S3 rejects the parts smaller than 5 MiB except the last part
+	s3MinPartSize = 5 << 20
+	s3MaxParts    = 10000
+)
+
+type S3Options struct {
+	// Endpoint is the URL of the S3 API, for example https://s3.us-east-1.amazonaws.com or the URL of an S3 compatible storage
+	// like MinIO or Cloudflare R2
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is only required for the temporary credentials
+	SessionToken string
+	// PathStyle requests {endpoint}/{bucket}/{key} instead of {bucket}.{endpoint}/{key}, most of the S3 compatible storages need it
+	PathStyle bool
+	// PartSize is the size of every part of the multipart upload, the object smaller than it is uploaded with a single request.
+	// The minimum is 5 MiB, default is 8 MiB
+	PartSize int
+	Retry    RetryOptions
+	// Client is the HTTP client to send the requests, the default client has a 5 minutes timeout per request
+	Client *http.Client
+}
+
+func DefaultS3Options() S3Options {
+	return S3Options{
+		Region:   "us-east-1",
+		PartSize: 8 << 20,
+		Retry:    DefaultRetryOptions(),
+		Client:   &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// S3 uploads the objects to an S3 bucket, the requests are signed with the AWS signature version 4
+type S3 struct {
+	opts S3Options
+	now  func() time.Time
+}
+
+func NewS3(opts S3Options) *S3 {
+	defaults := DefaultS3Options()
+
+	if opts.Region == "" {
+		opts.Region = defaults.Region
+	}
+
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://s3." + opts.Region + ".amazonaws.com"
+	}
+
+	if opts.PartSize <= 0 {
+		opts.PartSize = defaults.PartSize
+	}
+
+	opts.PartSize = max(opts.PartSize, s3MinPartSize)
+
+	if opts.Retry.RetryInterval <= 0 {
+		opts.Retry.RetryInterval = defaults.Retry.RetryInterval
+	}
+
+	if opts.Client == nil {
+		opts.Client = defaults.Client
+	}
+
+	return &S3{
+		opts: opts,
+		now:  time.Now,
+	}
+}
+
+type s3InitiateResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteRequest struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+type s3CompleteResult struct {
+	XMLName xml.Name
+	ETag    string `xml:"ETag"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// Put uploads the object with a single request if it's smaller than the part size, otherwise with a multipart upload.
+// The multipart upload is aborted if a part is failed after all retries, so the uploaded parts are not billed.
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, _ int64) (Object, error) {
+	if key == "" {
+		return Object{}, ErrInvalidKey
+	}
+
+	buf := make([]byte, s.opts.PartSize)
+
+	part, last, err := readPart(body, buf)
+	if err != nil {
+		return Object{}, err
+	}
+
+	if last {
+		etag, err := s.putObject(ctx, key, part)
+		if err != nil {
+			return Object{}, err
+		}
+
+		return Object{Key: key, Size: int64(len(part)), URL: s.objectURL(key, nil).String(), ETag: etag}, nil
+	}
+
+	uploadID, err := s.initiate(ctx, key)
+	if err != nil {
+		return Object{}, err
+	}
+
+	object, err := s.uploadParts(ctx, key, uploadID, body, buf, part)
+	if err != nil {
+		// use a new context, the upload context may be the one that canceled
+		abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if abortErr := s.abort(abortCtx, key, uploadID); abortErr != nil {
+			return Object{}, fmt.Errorf("%w, abort: %s", err, abortErr.Error())
+		}
+
+		return Object{}, err
+	}
+
+	return object, nil
+}
+
+func (s *S3) uploadParts(ctx context.Context, key, uploadID string, body io.Reader, buf, part []byte) (Object, error) {
+	parts := make([]s3CompletedPart, 0)
+	size := int64(0)
+	last := false
+
+	for number := 1; ; number++ {
+		if number > s3MaxParts {
+			return Object{}, fmt.Errorf("%w: more than %d parts", ErrUploadFailed, s3MaxParts)
+		}
+
+		etag, err := s.uploadPart(ctx, key, uploadID, number, part)
+		if err != nil {
+			return Object{}, err
+		}
+
+		parts = append(parts, s3CompletedPart{PartNumber: number, ETag: etag})
+		size += int64(len(part))
+
+		if last {
+			break
+		}
+
+		// the buffer is reused after the part is uploaded
+		part, last, err = readPart(body, buf)
+		if err != nil {
+			return Object{}, err
+		}
+
+		if len(part) == 0 {
+			break
+		}
+	}
+
+	etag, err := s.complete(ctx, key, uploadID, parts)
+	if err != nil {
+		return Object{}, err
+	}
+
+	return Object{Key: key, Size: size, URL: s.objectURL(key, nil).String(), ETag: etag}, nil
+}
+
+func (s *S3) putObject(ctx context.Context, key string, payload []byte) (string, error) {
+	var etag string
+
+	err := retry(ctx, s.opts.Retry, func() error {
+		res, err := s.do(ctx, http.MethodPut, key, nil, payload)
+		if err != nil {
+			return err
+		}
+
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return newStatusError(res)
+		}
+
+		etag = res.Header.Get("ETag")
+
+		return nil
+	})
+
+	return etag, err
+}
+
+func (s *S3) initiate(ctx context.Context, key string) (string, error) {
+	var result s3InitiateResult
+
+	err := retry(ctx, s.opts.Retry, func() error {
+		res, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+		if err != nil {
+			return err
+		}
+
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return newStatusError(res)
+		}
+
+		return xml.NewDecoder(res.Body).Decode(&result)
+	})
+
+	if err == nil && result.UploadID == "" {
+		err = fmt.Errorf("%w: empty upload ID", ErrUploadFailed)
+	}
+
+	return result.UploadID, err
+}
+
+func (s *S3) uploadPart(ctx context.Context, key, uploadID string, number int, payload []byte) (string, error) {
+	var etag string
+
+	query := url.Values{
+		"partNumber": {strconv.Itoa(number)},
+		"uploadId":   {uploadID},
+	}
+
+	err := retry(ctx, s.opts.Retry, func() error {
+		res, err := s.do(ctx, http.MethodPut, key, query, payload)
+		if err != nil {
+			return err
+		}
+
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return newStatusError(res)
+		}
+
+		etag = res.Header.Get("ETag")
+
+		return nil
+	})
+
+	return etag, err
+}
+
+func (s *S3) complete(ctx context.Context, key, uploadID string, parts []s3CompletedPart) (string, error) {
+	payload, err := xml.Marshal(s3CompleteRequest{Parts: parts})
+	if err != nil {
+		return "", err
+	}
+
+	var result s3CompleteResult
+
+	err = retry(ctx, s.opts.Retry, func() error {
+		res, err := s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, payload)
+		if err != nil {
+			return err
+		}
+
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return newStatusError(res)
+		}
+
+		if err := xml.NewDecoder(res.Body).Decode(&result); err != nil {
+			return err
+		}
+
+		// the complete request can fail after the 200 status is sent
+		if result.XMLName.Local == "Error" {
+			status := &statusError{status: http.StatusOK, body: result.Code + " " + result.Message}
+			if result.Code == "InternalError" || result.Code == "SlowDown" {
+				status.status = http.StatusServiceUnavailable
+			}
+
+			return status
+		}
+
+		return nil
+	})
+
+	return result.ETag, err
+}
+
+func (s *S3) abort(ctx context.Context, key, uploadID string) error {
+	return retry(ctx, s.opts.Retry, func() error {
+		res, err := s.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+		if err != nil {
+			return err
+		}
+
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+			return newStatusError(res)
+		}
+
+		return nil
+	})
+}
+
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u, err := url.Parse(s.opts.Endpoint)
+	if err != nil {
+		u = &url.URL{Scheme: "https", Host: s.opts.Endpoint}
+	}
+
+	path := "/" + key
+	if s.opts.PathStyle {
+		path = "/" + s.opts.Bucket + path
+	} else {
+		u.Host = s.opts.Bucket + "." + u.Host
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	return u
+}
+
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, payload []byte) (*http.Response, error) {
+	u := s.objectURL(key, query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	// the URL is parsed again by NewRequest, keep the canonical encoding that signed
+	req.URL = u
+	req.ContentLength = int64(len(payload))
+
+	s.sign(req, payload)
+
+	return s.opts.Client.Do(req)
+}
+
+// sign adds the AWS signature version 4 to the request
+func (s *S3) sign(req *http.Request, payload []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := sha256.Sum256(payload)
+	payloadHex := hex.EncodeToString(payloadHash[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHex)
+
+	if s.opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.opts.SessionToken)
+	}
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHex,
+		"x-amz-date":           amzDate,
+	}
+
+	if s.opts.SessionToken != "" {
+		headers["x-amz-security-token"] = s.opts.SessionToken
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHex,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + s.opts.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.opts.SecretAccessKey), date)
+	key = hmacSHA256(key, s.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.opts.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes the query sorted by the key, as required by the signature
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range query[key] {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode encodes every byte except the unreserved characters of RFC 3986, the slash is only encoded if encodeSlash
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,116 @@
+// Package storage uploads the files that produced by the SFU, like the recordings, to a local directory or an object
+// storage. The S3 and GCS backends only use the standard library, the large files are uploaded in parts and every part
+// is retried on the network error, 429 and 5xx responses.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrUploadFailed = errors.New("storage: upload failed")
+	ErrInvalidKey   = errors.New("storage: invalid object key")
+)
+
+// Storage stores the uploaded objects, it must be safe to call from multiple goroutines
+type Storage interface {
+	// Put uploads the body as the object key, size is the length of the body or -1 if it's unknown. The object is only
+	// visible in the storage once Put returns without error.
+	Put(ctx context.Context, key string, body io.Reader, size int64) (Object, error)
+}
+
+// Object is an uploaded object
+type Object struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	// URL is the location of the object, a file path for the local storage
+	URL  string `json:"url"`
+	ETag string `json:"etag,omitempty"`
+}
+
+// RetryOptions configures the retries of the failed requests
+type RetryOptions struct {
+	// MaxRetries is the number of the retries after the first attempt is failed, with the exponential backoff from RetryInterval
+	MaxRetries int
+	// RetryInterval is the wait before the first retry, it's doubled on every retry
+	RetryInterval time.Duration
+}
+
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		MaxRetries:    3,
+		RetryInterval: time.Second,
+	}
+}
+
+// statusError is the unexpected response status, the request is retried if the status is 429 or 5xx
+type statusError struct {
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: status %d %s", ErrUploadFailed.Error(), e.status, e.body)
+}
+
+func (e *statusError) Unwrap() error {
+	return ErrUploadFailed
+}
+
+func (e *statusError) retryable() bool {
+	return e.status == http.StatusTooManyRequests || e.status >= 500
+}
+
+func newStatusError(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+
+	return &statusError{status: res.StatusCode, body: string(body)}
+}
+
+// retry calls the request until it's succeeded, the response status error that is not retryable is returned immediately
+func retry(ctx context.Context, opts RetryOptions, request func() error) error {
+	interval := opts.RetryInterval
+
+	for attempt := 0; ; attempt++ {
+		err := request()
+		if err == nil {
+			return nil
+		}
+
+		var status *statusError
+		if errors.As(err, &status) && !status.retryable() {
+			return err
+		}
+
+		if attempt >= opts.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+
+		interval *= 2
+	}
+}
+
+// readPart reads the next part of the body, the part is shorter than the buffer only if it's the last part
+func readPart(body io.Reader, buf []byte) ([]byte, bool, error) {
+	n, err := io.ReadFull(body, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return buf[:n], true, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return buf[:n], false, nil
+}
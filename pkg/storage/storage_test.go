@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testRetry = RetryOptions{MaxRetries: 2, RetryInterval: time.Millisecond}
+
+func TestLocal(t *testing.T) {
+	dir := t.TempDir()
+	local := NewLocal(dir)
+
+	object, err := local.Put(context.Background(), "room/track.webm", strings.NewReader("recording"), 9)
+	require.NoError(t, err)
+	require.Equal(t, int64(9), object.Size)
+
+	data, err := os.ReadFile(filepath.Join(dir, "room", "track.webm"))
+	require.NoError(t, err)
+	require.Equal(t, "recording", string(data))
+
+	entries, err := os.ReadDir(filepath.Join(dir, "room"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "temporary file is removed")
+
+	_, err = local.Put(context.Background(), "../escape", strings.NewReader("x"), 1)
+	require.ErrorIs(t, err, ErrInvalidKey)
+}
+
+// fakeS3 is a minimal S3 server that supports the single and multipart uploads
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	parts    map[int][]byte
+	aborted  bool
+	failures int
+	failPart int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodPut && query.Get("partNumber") != "":
+		var number int
+		fmt.Sscanf(query.Get("partNumber"), "%d", &number)
+
+		if number == f.failPart {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if f.failures > 0 {
+			f.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		f.parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, number))
+	case r.Method == http.MethodPut:
+		if f.failures > 0 {
+			f.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		f.objects[key] = body
+		w.Header().Set("ETag", `"single"`)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.parts = make(map[int][]byte)
+		fmt.Fprint(w, `<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPost && query.Get("uploadId") == "upload-1":
+		object := make([]byte, 0)
+		for i := 1; i <= len(f.parts); i++ {
+			object = append(object, f.parts[i]...)
+		}
+
+		f.objects[key] = object
+		fmt.Fprint(w, `<CompleteMultipartUploadResult><ETag>"multipart"</ETag></CompleteMultipartUploadResult>`)
+	case r.Method == http.MethodDelete:
+		f.aborted = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestS3(t *testing.T, server *httptest.Server) *S3 {
+	t.Helper()
+
+	s3 := NewS3(S3Options{
+		Endpoint:        server.URL,
+		Bucket:          "bucket",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		PathStyle:       true,
+		Retry:           testRetry,
+	})
+
+	// use small parts to test the multipart upload without allocating the minimum part size
+	s3.opts.PartSize = 4
+
+	return s3
+}
+
+func TestS3SingleUpload(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte), failures: 1}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	object, err := newTestS3(t, server).Put(context.Background(), "room/a b.webm", strings.NewReader("abc"), 3)
+	require.NoError(t, err)
+	require.Equal(t, `"single"`, object.ETag)
+	require.Equal(t, server.URL+"/bucket/room/a%20b.webm", object.URL)
+	require.Equal(t, "abc", string(fake.objects["room/a b.webm"]))
+}
+
+func TestS3MultipartUpload(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte), failures: 2}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	object, err := newTestS3(t, server).Put(context.Background(), "track.webm", strings.NewReader("0123456789"), 10)
+	require.NoError(t, err)
+	require.Equal(t, `"multipart"`, object.ETag)
+	require.Equal(t, int64(10), object.Size)
+	require.Equal(t, "0123456789", string(fake.objects["track.webm"]))
+	require.Len(t, fake.parts, 3)
+	require.False(t, fake.aborted)
+}
+
+func TestS3AbortUpload(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte), failPart: 2}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	_, err := newTestS3(t, server).Put(context.Background(), "track.webm", strings.NewReader("0123456789"), 10)
+	require.ErrorIs(t, err, ErrUploadFailed)
+	require.True(t, fake.aborted)
+	require.Empty(t, fake.objects)
+}
+
+func TestGCSResumableUpload(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		object   bytes.Buffer
+		ranges   []string
+		failures = 1
+	)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/upload/storage/v1/b/bucket/o", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		require.Equal(t, "resumable", r.URL.Query().Get("uploadType"))
+		require.Equal(t, "room/track.webm", r.URL.Query().Get("name"))
+
+		w.Header().Set("Location", server.URL+"/session")
+	})
+
+	mux.HandleFunc("/session", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		contentRange := r.Header.Get("Content-Range")
+		ranges = append(ranges, contentRange)
+		_, _ = io.Copy(&object, r.Body)
+
+		if strings.HasSuffix(contentRange, "/*") {
+			w.WriteHeader(gcsStatusResumeIncomplete)
+			return
+		}
+
+		fmt.Fprintf(w, `{"name":"room/track.webm","size":"%d","etag":"tag","mediaLink":"link"}`, object.Len())
+	})
+
+	gcs := NewGCS(GCSOptions{
+		Endpoint: server.URL,
+		Bucket:   "bucket",
+		TokenSource: func(context.Context) (string, error) {
+			return "token", nil
+		},
+		ChunkSize: gcsChunkUnit,
+		Retry:     testRetry,
+	})
+
+	body := bytes.Repeat([]byte{1}, gcsChunkUnit*2+10)
+
+	result, err := gcs.Put(context.Background(), "room/track.webm", bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(body)), result.Size)
+	require.Equal(t, "link", result.URL)
+	require.Equal(t, body, object.Bytes())
+	require.Equal(t, []string{
+		fmt.Sprintf("bytes 0-%d/*", gcsChunkUnit-1),
+		fmt.Sprintf("bytes %d-%d/*", gcsChunkUnit, gcsChunkUnit*2-1),
+		fmt.Sprintf("bytes %d-%d/%d", gcsChunkUnit*2, len(body)-1, len(body)),
+	}, ranges)
+}
@@ -0,0 +1,148 @@
+// Package token signs and validates the HS256 JWT join tokens of the SFU rooms.
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken         = errors.New("token: invalid token")
+	ErrInvalidSignature     = errors.New("token: invalid signature")
+	ErrUnsupportedAlgorithm = errors.New("token: unsupported algorithm")
+	ErrTokenExpired         = errors.New("token: token is expired")
+	ErrTokenNotValidYet     = errors.New("token: token is not valid yet")
+	ErrMissingClaims        = errors.New("token: room_id and client_id are required")
+)
+
+const algorithm = "HS256"
+
+var encoding = base64.RawURLEncoding
+
+// Claims is the payload of the join token
+type Claims struct {
+	RoomID   string `json:"room_id"`
+	ClientID string `json:"client_id"`
+	// Name is the client display name, the client ID is used if it's empty
+	Name string `json:"name,omitempty"`
+	// Role is the client role in the room: publisher, subscriber or moderator
+	Role string `json:"role,omitempty"`
+	// Sources is the track sources that the client can publish: media or screen, empty means all sources
+	Sources []string `json:"sources,omitempty"`
+	// the registered claims of RFC 7519 in the Unix time seconds
+	ExpiresAt int64  `json:"exp,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+}
+
+// Sign returns the signed token of the claims, use it on the API server that authorizes the client to join a room
+func Sign(secret []byte, claims Claims) (string, error) {
+	h, err := json.Marshal(header{Algorithm: algorithm, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := encoding.EncodeToString(h) + "." + encoding.EncodeToString(payload)
+
+	return unsigned + "." + encoding.EncodeToString(sign(secret, unsigned)), nil
+}
+
+// Verifier validates the join tokens that signed with the secret
+type Verifier struct {
+	secret []byte
+	// leeway is the allowed clock difference with the token issuer
+	leeway time.Duration
+	now    func() time.Time
+}
+
+func NewVerifier(secret []byte, leeway time.Duration) *Verifier {
+	return &Verifier{
+		secret: secret,
+		leeway: leeway,
+		now:    time.Now,
+	}
+}
+
+// Verify checks the signature and the time claims of the token, and returns the claims
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	h := header{}
+	if err := decode(parts[0], &h); err != nil {
+		return nil, err
+	}
+
+	// only the algorithm that the verifier expects, never the "none" algorithm from the token
+	if h.Algorithm != algorithm {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !hmac.Equal(signature, sign(v.secret, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidSignature
+	}
+
+	claims := &Claims{}
+	if err := decode(parts[1], claims); err != nil {
+		return nil, err
+	}
+
+	now := v.now()
+
+	if claims.ExpiresAt != 0 && now.After(time.Unix(claims.ExpiresAt, 0).Add(v.leeway)) {
+		return nil, ErrTokenExpired
+	}
+
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-v.leeway)) {
+		return nil, ErrTokenNotValidYet
+	}
+
+	if claims.RoomID == "" || claims.ClientID == "" {
+		return nil, ErrMissingClaims
+	}
+
+	return claims, nil
+}
+
+func sign(secret []byte, unsigned string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+
+	return mac.Sum(nil)
+}
+
+func decode(part string, v interface{}) error {
+	data, err := encoding.DecodeString(part)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
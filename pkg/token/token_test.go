@@ -0,0 +1,77 @@
+package token
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+
+	verifier := NewVerifier(secret, 5*time.Second)
+	verifier.now = func() time.Time { return now }
+
+	claims := Claims{
+		RoomID:    "room",
+		ClientID:  "alice",
+		Role:      "subscriber",
+		Sources:   []string{"media"},
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		IssuedAt:  now.Unix(),
+	}
+
+	token, err := Sign(secret, claims)
+	require.NoError(t, err)
+
+	verified, err := verifier.Verify(token)
+	require.NoError(t, err)
+	require.Equal(t, claims, *verified)
+
+	// signed with another secret
+	otherToken, err := Sign([]byte("other"), claims)
+	require.NoError(t, err)
+
+	_, err = verifier.Verify(otherToken)
+	require.ErrorIs(t, err, ErrInvalidSignature)
+
+	// the payload is changed
+	parts := strings.Split(token, ".")
+	tampered, err := Sign(secret, Claims{RoomID: "room", ClientID: "mallory"})
+	require.NoError(t, err)
+
+	_, err = verifier.Verify(parts[0] + "." + strings.Split(tampered, ".")[1] + "." + parts[2])
+	require.ErrorIs(t, err, ErrInvalidSignature)
+
+	// the none algorithm is never accepted
+	_, err = verifier.Verify(encoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + ".")
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+
+	_, err = verifier.Verify("invalid")
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// the expiry with the leeway
+	verifier.now = func() time.Time { return now.Add(time.Minute + 3*time.Second) }
+	_, err = verifier.Verify(token)
+	require.NoError(t, err)
+
+	verifier.now = func() time.Time { return now.Add(time.Minute + 6*time.Second) }
+	_, err = verifier.Verify(token)
+	require.ErrorIs(t, err, ErrTokenExpired)
+
+	notYet, err := Sign(secret, Claims{RoomID: "room", ClientID: "alice", NotBefore: now.Add(time.Minute).Unix()})
+	require.NoError(t, err)
+
+	verifier.now = func() time.Time { return now }
+	_, err = verifier.Verify(notYet)
+	require.ErrorIs(t, err, ErrTokenNotValidYet)
+
+	missing, err := Sign(secret, Claims{RoomID: "room"})
+	require.NoError(t, err)
+
+	_, err = verifier.Verify(missing)
+	require.ErrorIs(t, err, ErrMissingClaims)
+}
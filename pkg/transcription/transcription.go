@@ -0,0 +1,264 @@
+// Package transcription is the reference speech-to-text integration of sfu.AudioSink. Every audio track of a room is
+// streamed to a live transcription service over WebSocket, and the transcripts are published as captions with
+// sfu.Room.PublishCaption. The default endpoint is the Deepgram live streaming API, the audio is sent as Ogg Opus so the SFU
+// doesn't need to decode it. Implement sfu.AudioSink directly for the services with a different protocol.
+package transcription
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/inlivedev/sfu"
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+	"golang.org/x/net/websocket"
+)
+
+var ErrClosed = errors.New("transcription: transcriber is closed")
+
+const DefaultURL = "wss://api.deepgram.com/v1/listen"
+
+// the time to wait for the last results after the stream is closed
+const closeTimeout = 5 * time.Second
+
+type Options struct {
+	// URL of the live transcription WebSocket endpoint, the language and the interim results are added as the query parameters
+	URL string `json:"url"`
+	// APIKey is sent with the Authorization header as "Token {APIKey}", empty to not send the header
+	APIKey string `json:"-"`
+	// Language of the speech, the BCP-47 tag like "en" or "id"
+	Language string `json:"language"`
+	// InterimResults publishes the captions while the sentence is still spoken, they're replaced by the final caption
+	InterimResults bool `json:"interim_results"`
+}
+
+func DefaultOptions() Options {
+	return Options{
+		URL:            DefaultURL,
+		Language:       "en",
+		InterimResults: true,
+	}
+}
+
+// Transcriber streams the audio tracks of a room to the transcription service
+type Transcriber struct {
+	mu        sync.Mutex
+	room      *sfu.Room
+	options   Options
+	hook      *sfu.AudioSinkHook
+	onCaption []func(sfu.Caption)
+	log       logging.LeveledLogger
+}
+
+func New(room *sfu.Room, opts Options) (*Transcriber, error) {
+	t := &Transcriber{
+		room:    room,
+		options: opts,
+		log:     logging.NewDefaultLoggerFactory().NewLogger("transcription"),
+	}
+
+	hook, err := room.AddAudioSink(t.newSink, sfu.DefaultAudioSinkOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	t.hook = hook
+
+	return t, nil
+}
+
+// OnCaption is called for every caption before it's published to the room
+func (t *Transcriber) OnCaption(callback func(sfu.Caption)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.onCaption = append(t.onCaption, callback)
+}
+
+// Close stops streaming all tracks
+func (t *Transcriber) Close() error {
+	if err := t.hook.Close(); err != nil {
+		return ErrClosed
+	}
+
+	return nil
+}
+
+func (t *Transcriber) publish(clientID, name, text string, timing sfu.CaptionTiming) {
+	t.mu.Lock()
+	callbacks := make([]func(sfu.Caption), len(t.onCaption))
+	copy(callbacks, t.onCaption)
+	t.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(sfu.Caption{
+			ClientID: clientID,
+			Name:     name,
+			Text:     text,
+			Lang:     t.options.Language,
+			Final:    timing.Final,
+			Start:    timing.Start.UnixMilli(),
+			Duration: timing.Duration.Milliseconds(),
+		})
+	}
+
+	if err := t.room.PublishCaption(clientID, text, t.options.Language, timing); err != nil {
+		t.log.Warnf("transcription: failed to publish caption: %s", err.Error())
+	}
+}
+
+func (t *Transcriber) dial() (*websocket.Conn, error) {
+	endpoint, err := url.Parse(t.options.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	query := endpoint.Query()
+	query.Set("language", t.options.Language)
+	query.Set("interim_results", "false")
+	if t.options.InterimResults {
+		query.Set("interim_results", "true")
+	}
+	query.Set("punctuate", "true")
+	endpoint.RawQuery = query.Encode()
+
+	config, err := websocket.NewConfig(endpoint.String(), "http://localhost")
+	if err != nil {
+		return nil, err
+	}
+
+	if t.options.APIKey != "" {
+		config.Header.Set("Authorization", "Token "+t.options.APIKey)
+	}
+
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.PayloadType = websocket.BinaryFrame
+
+	return conn, nil
+}
+
+// newSink is the sfu.AudioSinkFactory, each track has its own transcription stream
+func (t *Transcriber) newSink(_ sfu.ITrack, publisher *sfu.Client) (sfu.AudioSink, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	// the Ogg pages are written as the binary messages, the headers are written once it's created
+	ogg, err := oggwriter.NewWith(conn, 48000, 2)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	s := &sink{
+		transcriber: t,
+		conn:        conn,
+		ogg:         ogg,
+		clientID:    publisher.ID(),
+		name:        publisher.Name(),
+		done:        make(chan struct{}),
+	}
+
+	go s.readLoop()
+
+	return s, nil
+}
+
+// result is the transcript message of the Deepgram live streaming API
+type result struct {
+	Type     string  `json:"type"`
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+	IsFinal  bool    `json:"is_final"`
+	Channel  struct {
+		Alternatives []struct {
+			Transcript string `json:"transcript"`
+		} `json:"alternatives"`
+	} `json:"channel"`
+}
+
+type sink struct {
+	transcriber *Transcriber
+	conn        *websocket.Conn
+	ogg         *oggwriter.OggWriter
+	clientID    string
+	name        string
+	// the time of the first frame, the result timing is from the start of the stream
+	mu    sync.Mutex
+	start time.Time
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (s *sink) WriteAudio(frame sfu.AudioFrame) error {
+	s.mu.Lock()
+	if s.start.IsZero() {
+		s.start = frame.Time
+	}
+	s.mu.Unlock()
+
+	return s.ogg.WriteRTP(&rtp.Packet{
+		Header:  rtp.Header{Timestamp: frame.Timestamp},
+		Payload: frame.Opus,
+	})
+}
+
+// Close asks the service to send the last results, the connection is closed in the background once they're received
+func (s *sink) Close() error {
+	s.once.Do(func() {
+		_ = websocket.Message.Send(s.conn, `{"type":"CloseStream"}`)
+
+		go func() {
+			select {
+			case <-s.done:
+			case <-time.After(closeTimeout):
+			}
+
+			_ = s.conn.Close()
+		}()
+	})
+
+	return nil
+}
+
+func (s *sink) readLoop() {
+	defer close(s.done)
+
+	for {
+		res := result{}
+		if err := websocket.JSON.Receive(s.conn, &res); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+
+			// the message is read, the connection is still usable
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				continue
+			}
+
+			return
+		}
+
+		if res.Type != "Results" || len(res.Channel.Alternatives) == 0 || res.Channel.Alternatives[0].Transcript == "" {
+			continue
+		}
+
+		s.mu.Lock()
+		start := s.start
+		s.mu.Unlock()
+
+		s.transcriber.publish(s.clientID, s.name, res.Channel.Alternatives[0].Transcript, sfu.CaptionTiming{
+			Start:    start.Add(time.Duration(res.Start * float64(time.Second))),
+			Duration: time.Duration(res.Duration * float64(time.Second)),
+			Final:    res.IsFinal,
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package transcription
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inlivedev/sfu"
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func TestTranscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type request struct {
+		auth     string
+		language string
+	}
+
+	requests := make(chan request, 10)
+
+	// the fake service sends a transcript once the Ogg stream is received
+	server := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		requests <- request{
+			auth:     conn.Request().Header.Get("Authorization"),
+			language: conn.Request().URL.Query().Get("language"),
+		}
+
+		pages := 0
+
+		for {
+			var data []byte
+			if err := websocket.Message.Receive(conn, &data); err != nil {
+				return
+			}
+
+			if bytes.HasPrefix(data, []byte("OggS")) {
+				pages++
+			}
+
+			if pages == 50 {
+				_ = websocket.Message.Send(conn, `{"type":"Results","start":0.5,"duration":1.25,"is_final":true,"channel":{"alternatives":[{"transcript":"hello world"}]}}`)
+			}
+		}
+	}))
+	defer server.Close()
+
+	// the peers are connected through the loopback host candidates
+	sfuOpts := sfu.DefaultOptions()
+	sfuOpts.IceServers = []webrtc.ICEServer{}
+	sfuOpts.SettingEngine.SetIncludeLoopbackCandidate(true)
+
+	roomManager := sfu.NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	room, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-transcription-room", sfu.RoomTypeLocal, sfu.DefaultRoomOptions())
+	require.NoError(t, err)
+
+	opts := DefaultOptions()
+	opts.URL = "ws" + strings.TrimPrefix(server.URL, "http")
+	opts.APIKey = "secret"
+	opts.Language = "id"
+
+	transcriber, err := New(room, opts)
+	require.NoError(t, err)
+
+	captions := make(chan sfu.Caption, 10)
+	transcriber.OnCaption(func(caption sfu.Caption) {
+		captions <- caption
+	})
+
+	started := time.Now()
+
+	_, publisher, _, _ := sfu.CreatePeerPair(ctx, logging.NewDefaultLoggerFactory().NewLogger("test"), room, sfuOpts.IceServers, "publisher", true, false, true)
+
+	select {
+	case req := <-requests:
+		require.Equal(t, "Token secret", req.auth)
+		require.Equal(t, "id", req.language)
+	case <-time.After(20 * time.Second):
+		t.Fatal("timeout waiting for the transcription stream")
+	}
+
+	select {
+	case caption := <-captions:
+		require.Equal(t, publisher.ID(), caption.ClientID)
+		require.Equal(t, "hello world", caption.Text)
+		require.Equal(t, "id", caption.Lang)
+		require.True(t, caption.Final)
+		require.Equal(t, int64(1250), caption.Duration)
+		require.GreaterOrEqual(t, caption.Start, started.UnixMilli())
+	case <-time.After(20 * time.Second):
+		t.Fatal("timeout waiting for the caption")
+	}
+
+	require.NoError(t, transcriber.Close())
+	require.ErrorIs(t, transcriber.Close(), ErrClosed)
+
+	_ = room.StopClient(publisher.ID())
+}
@@ -0,0 +1,398 @@
+// Package webmwriter implements a minimal Matroska/WebM muxer that writes encoded frames to a stream.
+// The segment is written with unknown size, so the file can be played while it's still being recorded.
+package webmwriter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	CodecVP8  = "V_VP8"
+	CodecVP9  = "V_VP9"
+	CodecAV1  = "V_AV1"
+	CodecH264 = "V_MPEG4/ISO/AVC"
+	CodecOpus = "A_OPUS"
+
+	TrackTypeVideo = 1
+	TrackTypeAudio = 2
+
+	DocTypeWebM     = "webm"
+	DocTypeMatroska = "matroska"
+
+	// cluster can't be longer than the block relative timecode (int16) allows
+	maxClusterDuration = 5 * time.Second
+)
+
+// EBML element IDs
+const (
+	idEBML               = 0x1A45DFA3
+	idEBMLVersion        = 0x4286
+	idEBMLReadVersion    = 0x42F7
+	idEBMLMaxIDLength    = 0x42F2
+	idEBMLMaxSizeLength  = 0x42F3
+	idDocType            = 0x4282
+	idDocTypeVersion     = 0x4287
+	idDocTypeReadVersion = 0x4285
+	idSegment            = 0x18538067
+	idInfo               = 0x1549A966
+	idTimecodeScale      = 0x2AD7B1
+	idMuxingApp          = 0x4D80
+	idWritingApp         = 0x5741
+	idTracks             = 0x1654AE6B
+	idTrackEntry         = 0xAE
+	idTrackNumber        = 0xD7
+	idTrackUID           = 0x73C5
+	idTrackType          = 0x83
+	idCodecID            = 0x86
+	idCodecPrivate       = 0x63A2
+	idCodecDelay         = 0x56AA
+	idSeekPreRoll        = 0x56BB
+	idVideo              = 0xE0
+	idPixelWidth         = 0xB0
+	idPixelHeight        = 0xBA
+	idAudio              = 0xE1
+	idSamplingFrequency  = 0xB5
+	idChannels           = 0x9F
+	idCluster            = 0x1F43B675
+	idTimecode           = 0xE7
+	idSimpleBlock        = 0xA3
+)
+
+var (
+	ErrClosed         = errors.New("webmwriter: writer is closed")
+	ErrUnknownTrack   = errors.New("webmwriter: unknown track number")
+	ErrHeaderWritten  = errors.New("webmwriter: header is already written")
+	ErrNegativeTime   = errors.New("webmwriter: timestamp is before the cluster start")
+	unknownSizeMarker = []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+)
+
+type TrackEntry struct {
+	// TrackNumber must be unique and start from 1
+	TrackNumber  uint64
+	TrackType    uint8
+	CodecID      string
+	CodecPrivate []byte
+	// Video settings
+	Width  uint32
+	Height uint32
+	// Audio settings
+	SamplingFrequency float64
+	Channels          uint8
+}
+
+type Writer struct {
+	mu             sync.Mutex
+	w              io.WriteCloser
+	docType        string
+	tracks         []*TrackEntry
+	headerWritten  bool
+	closed         bool
+	cluster        *bytes.Buffer
+	clusterStart   time.Duration
+	clusterStarted bool
+}
+
+// New creates a writer, the header is written on the first frame so the track entries still can be updated
+// through SetCodecPrivate or SetVideoSize until then.
+func New(w io.WriteCloser, docType string, tracks []TrackEntry) *Writer {
+	entries := make([]*TrackEntry, 0, len(tracks))
+	for i := range tracks {
+		t := tracks[i]
+		entries = append(entries, &t)
+	}
+
+	return &Writer{
+		w:       w,
+		docType: docType,
+		tracks:  entries,
+		cluster: &bytes.Buffer{},
+	}
+}
+
+func (w *Writer) track(number uint64) *TrackEntry {
+	for _, t := range w.tracks {
+		if t.TrackNumber == number {
+			return t
+		}
+	}
+
+	return nil
+}
+
+func (w *Writer) SetCodecPrivate(trackNumber uint64, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.headerWritten {
+		return ErrHeaderWritten
+	}
+
+	t := w.track(trackNumber)
+	if t == nil {
+		return ErrUnknownTrack
+	}
+
+	t.CodecPrivate = data
+
+	return nil
+}
+
+func (w *Writer) SetVideoSize(trackNumber uint64, width, height uint32) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.headerWritten {
+		return ErrHeaderWritten
+	}
+
+	t := w.track(trackNumber)
+	if t == nil {
+		return ErrUnknownTrack
+	}
+
+	t.Width, t.Height = width, height
+
+	return nil
+}
+
+// WriteFrame writes a single encoded frame. The timestamp is the presentation time from the beginning of the file.
+func (w *Writer) WriteFrame(trackNumber uint64, keyframe bool, timestamp time.Duration, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	t := w.track(trackNumber)
+	if t == nil {
+		return ErrUnknownTrack
+	}
+
+	if !w.headerWritten {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+	}
+
+	relative := timestamp - w.clusterStart
+
+	newCluster := !w.clusterStarted ||
+		relative > maxClusterDuration ||
+		relative < 0 ||
+		(keyframe && t.TrackType == TrackTypeVideo && relative > 0)
+
+	if newCluster {
+		if err := w.flushCluster(); err != nil {
+			return err
+		}
+
+		if timestamp < 0 {
+			return ErrNegativeTime
+		}
+
+		w.clusterStart = timestamp
+		w.clusterStarted = true
+		relative = 0
+
+		w.cluster.Write(uintElement(idTimecode, uint64(timestamp.Milliseconds())))
+	}
+
+	block := make([]byte, 0, len(data)+4)
+	block = append(block, encodeSize(trackNumber)...)
+	block = binary.BigEndian.AppendUint16(block, uint16(int16(relative.Milliseconds())))
+
+	flags := byte(0)
+	if keyframe {
+		flags |= 0x80
+	}
+
+	block = append(block, flags)
+	block = append(block, data...)
+
+	w.cluster.Write(element(idSimpleBlock, block))
+
+	return nil
+}
+
+// Close flushes the last cluster and closes the underlying writer
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+
+	if err := w.flushCluster(); err != nil {
+		_ = w.w.Close()
+		return err
+	}
+
+	return w.w.Close()
+}
+
+func (w *Writer) flushCluster() error {
+	if w.cluster.Len() == 0 {
+		return nil
+	}
+
+	_, err := w.w.Write(element(idCluster, w.cluster.Bytes()))
+	w.cluster.Reset()
+
+	return err
+}
+
+func (w *Writer) writeHeader() error {
+	header := element(idEBML, concat(
+		uintElement(idEBMLVersion, 1),
+		uintElement(idEBMLReadVersion, 1),
+		uintElement(idEBMLMaxIDLength, 4),
+		uintElement(idEBMLMaxSizeLength, 8),
+		stringElement(idDocType, w.docType),
+		uintElement(idDocTypeVersion, 4),
+		uintElement(idDocTypeReadVersion, 2),
+	))
+
+	info := element(idInfo, concat(
+		uintElement(idTimecodeScale, uint64(time.Millisecond)),
+		stringElement(idMuxingApp, "inlivedev-sfu"),
+		stringElement(idWritingApp, "inlivedev-sfu"),
+	))
+
+	entries := make([][]byte, 0, len(w.tracks))
+	for _, t := range w.tracks {
+		entries = append(entries, trackEntryElement(t))
+	}
+
+	tracks := element(idTracks, concat(entries...))
+
+	segment := concat(encodeID(idSegment), unknownSizeMarker, info, tracks)
+
+	if _, err := w.w.Write(concat(header, segment)); err != nil {
+		return err
+	}
+
+	w.headerWritten = true
+
+	return nil
+}
+
+func trackEntryElement(t *TrackEntry) []byte {
+	fields := [][]byte{
+		uintElement(idTrackNumber, t.TrackNumber),
+		uintElement(idTrackUID, t.TrackNumber),
+		uintElement(idTrackType, uint64(t.TrackType)),
+		stringElement(idCodecID, t.CodecID),
+	}
+
+	if len(t.CodecPrivate) > 0 {
+		fields = append(fields, element(idCodecPrivate, t.CodecPrivate))
+	}
+
+	switch t.TrackType {
+	case TrackTypeVideo:
+		fields = append(fields, element(idVideo, concat(
+			uintElement(idPixelWidth, uint64(t.Width)),
+			uintElement(idPixelHeight, uint64(t.Height)),
+		)))
+	case TrackTypeAudio:
+		if t.CodecID == CodecOpus {
+			// recommended values from https://wiki.xiph.org/MatroskaOpus
+			fields = append(fields,
+				uintElement(idCodecDelay, 0),
+				uintElement(idSeekPreRoll, uint64(80*time.Millisecond)),
+			)
+		}
+
+		fields = append(fields, element(idAudio, concat(
+			floatElement(idSamplingFrequency, t.SamplingFrequency),
+			uintElement(idChannels, uint64(t.Channels)),
+		)))
+	}
+
+	return element(idTrackEntry, concat(fields...))
+}
+
+// OpusHead returns the Opus identification header that used as the CodecPrivate of the Opus track
+// https://datatracker.ietf.org/doc/html/rfc7845#section-5.1
+func OpusHead(channels uint8, sampleRate uint32) []byte {
+	head := []byte("OpusHead")
+	head = append(head, 1, channels)
+	head = binary.LittleEndian.AppendUint16(head, 0)
+	head = binary.LittleEndian.AppendUint32(head, sampleRate)
+	head = binary.LittleEndian.AppendUint16(head, 0)
+
+	return append(head, 0)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func element(id uint32, data []byte) []byte {
+	return concat(encodeID(id), encodeSize(uint64(len(data))), data)
+}
+
+func uintElement(id uint32, v uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, v)
+
+	i := 0
+	for i < 7 && data[i] == 0 {
+		i++
+	}
+
+	return element(id, data[i:])
+}
+
+func stringElement(id uint32, v string) []byte {
+	return element(id, []byte(v))
+}
+
+func floatElement(id uint32, v float64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, math.Float64bits(v))
+
+	return element(id, data)
+}
+
+// encodeID writes the element ID, the ID already contains the length marker
+func encodeID(id uint32) []byte {
+	switch {
+	case id >= 0x1000000:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 0x10000:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 0x100:
+		return []byte{byte(id >> 8), byte(id)}
+	default:
+		return []byte{byte(id)}
+	}
+}
+
+// encodeSize writes a variable size integer with the shortest length
+func encodeSize(size uint64) []byte {
+	length := 1
+	for length < 8 && size >= (uint64(1)<<(7*length))-1 {
+		length++
+	}
+
+	data := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		data[i] = byte(size)
+		size >>= 8
+	}
+
+	data[0] |= 0x80 >> (length - 1)
+
+	return data
+}
@@ -0,0 +1,134 @@
+package webmwriter
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+// readElement reads a single element and returns the ID, data, and the remaining buffer
+func readElement(t *testing.T, buf []byte) (uint32, []byte, []byte) {
+	t.Helper()
+
+	idLength := 1
+	for buf[0]&(0x80>>(idLength-1)) == 0 {
+		idLength++
+	}
+
+	id := uint32(0)
+	for _, b := range buf[:idLength] {
+		id = id<<8 | uint32(b)
+	}
+
+	buf = buf[idLength:]
+
+	sizeLength := 1
+	for buf[0]&(0x80>>(sizeLength-1)) == 0 {
+		sizeLength++
+	}
+
+	if bytes.Equal(buf[:sizeLength], unknownSizeMarker) {
+		return id, buf[sizeLength:], nil
+	}
+
+	size := uint64(buf[0] & (0xff >> sizeLength))
+	for _, b := range buf[1:sizeLength] {
+		size = size<<8 | uint64(b)
+	}
+
+	buf = buf[sizeLength:]
+	require.GreaterOrEqual(t, uint64(len(buf)), size)
+
+	return id, buf[:size], buf[size:]
+}
+
+func TestEncodeSize(t *testing.T) {
+	require.Equal(t, []byte{0x81}, encodeSize(1))
+	require.Equal(t, []byte{0xfe}, encodeSize(126))
+	// 127 is reserved as unknown size in a single byte
+	require.Equal(t, []byte{0x40, 0x7f}, encodeSize(127))
+	require.Equal(t, []byte{0x41, 0x00}, encodeSize(256))
+}
+
+func TestWriteFrames(t *testing.T) {
+	out := &bufferCloser{}
+
+	w := New(out, DocTypeWebM, []TrackEntry{
+		{TrackNumber: 1, TrackType: TrackTypeVideo, CodecID: CodecVP8},
+		{TrackNumber: 2, TrackType: TrackTypeAudio, CodecID: CodecOpus, SamplingFrequency: 48000, Channels: 2},
+	})
+
+	require.NoError(t, w.SetVideoSize(1, 640, 480))
+
+	require.NoError(t, w.WriteFrame(1, true, 0, []byte{1}))
+	require.NoError(t, w.WriteFrame(2, true, 10*time.Millisecond, []byte{2}))
+	require.NoError(t, w.WriteFrame(1, false, 33*time.Millisecond, []byte{3}))
+	// a new cluster is started on the video keyframe
+	require.NoError(t, w.WriteFrame(1, true, 66*time.Millisecond, []byte{4}))
+
+	require.ErrorIs(t, w.SetCodecPrivate(1, []byte{0}), ErrHeaderWritten)
+	require.ErrorIs(t, w.WriteFrame(3, true, 0, []byte{5}), ErrUnknownTrack)
+
+	require.NoError(t, w.Close())
+	require.True(t, out.closed)
+	require.ErrorIs(t, w.WriteFrame(1, true, 100*time.Millisecond, []byte{6}), ErrClosed)
+
+	id, header, rest := readElement(t, out.Bytes())
+	require.Equal(t, uint32(idEBML), id)
+	require.Contains(t, string(header), DocTypeWebM)
+
+	id, segment, _ := readElement(t, rest)
+	require.Equal(t, uint32(idSegment), id)
+
+	clusters := make([][]byte, 0)
+	for len(segment) > 0 {
+		var data []byte
+		id, data, segment = readElement(t, segment)
+
+		if id == idCluster {
+			clusters = append(clusters, data)
+		}
+	}
+
+	require.Len(t, clusters, 2)
+
+	// timecode, video block, audio block, video block
+	id, timecode, blocks := readElement(t, clusters[0])
+	require.Equal(t, uint32(idTimecode), id)
+	require.Equal(t, []byte{0}, timecode)
+
+	expected := [][]byte{
+		{0x81, 0x00, 0x00, 0x80, 1},
+		{0x82, 0x00, 0x0a, 0x80, 2},
+		{0x81, 0x00, 0x21, 0x00, 3},
+	}
+
+	for _, block := range expected {
+		var data []byte
+		id, data, blocks = readElement(t, blocks)
+		require.Equal(t, uint32(idSimpleBlock), id)
+		require.Equal(t, block, data)
+	}
+
+	_, timecode, _ = readElement(t, clusters[1])
+	require.Equal(t, []byte{66}, timecode)
+}
+
+func TestOpusHead(t *testing.T) {
+	head := OpusHead(2, 48000)
+	require.Len(t, head, 19)
+	require.Equal(t, "OpusHead", string(head[:8]))
+	require.Equal(t, byte(2), head[9])
+}
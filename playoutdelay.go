@@ -0,0 +1,70 @@
+package sfu
+
+import (
+	"github.com/pion/webrtc/v4"
+)
+
+// PlayoutDelay is the range of the delay in milliseconds that the subscriber waits before it plays the received frames,
+// it's sent with the playout-delay RTP header extension to the subscribers that negotiate it. The min 0 asks the
+// subscriber to play the frames as soon as possible like in an auction room, and the bigger delay lets the subscriber
+// buffer more frames against the network glitches like in a webinar. The delay is rounded down to 10ms and it's
+// limited to 40950ms by the extension.
+type PlayoutDelay struct {
+	Min uint16 `json:"min"`
+	Max uint16 `json:"max"`
+}
+
+// setPlayoutDelay sets the delay of the track to the current and the next subscribers
+func (t *baseTrack) setPlayoutDelay(delay *PlayoutDelay) {
+	if delay != nil {
+		copied := *delay
+		delay = &copied
+	}
+
+	t.playoutDelay.Store(delay)
+
+	for _, clientTrack := range t.clientTracks.GetTracks() {
+		clientTrack.Client().setPlayoutDelay(clientTrack.LocalTrack(), delay)
+	}
+}
+
+func (t *baseTrack) getPlayoutDelay() *PlayoutDelay {
+	delay := t.playoutDelay.Load()
+	if delay == nil {
+		return nil
+	}
+
+	copied := *delay
+
+	return &copied
+}
+
+// setPlayoutDelay sets the delay of the sender of the local track, nil sets it back to the client options
+func (c *Client) setPlayoutDelay(localTrack *webrtc.TrackLocalStaticRTP, delay *PlayoutDelay) {
+	if c.playoutDelay == nil {
+		return
+	}
+
+	for _, sender := range c.peerConnection.PC().GetSenders() {
+		if sender.Track() == webrtc.TrackLocal(localTrack) {
+			c.setSenderPlayoutDelay(sender, delay)
+		}
+	}
+}
+
+func (c *Client) setSenderPlayoutDelay(sender *webrtc.RTPSender, delay *PlayoutDelay) {
+	if c.playoutDelay == nil {
+		return
+	}
+
+	for _, encoding := range sender.GetParameters().Encodings {
+		if delay == nil {
+			c.playoutDelay.ResetDelay(uint32(encoding.SSRC))
+			continue
+		}
+
+		if err := c.playoutDelay.SetDelay(uint32(encoding.SSRC), delay.Min, delay.Max); err != nil {
+			c.log.Errorf("client: error on set playout delay ", err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+)
+
+// the default window to coalesce the keyframe requests of a publisher track
+const defaultPLIWindow = 250 * time.Millisecond
+
+// PLIStats is the keyframe request counters of the SFU
+type PLIStats struct {
+	// Requested is the number of keyframe requests from the subscribers, the PLI interval and the layer switches
+	Requested uint64 `json:"requested"`
+	// Sent is the number of PLI packets sent to the publishers
+	Sent uint64 `json:"sent"`
+	// Coalesced is the number of requests that merged into the PLI that already sent or pending in the window
+	Coalesced uint64 `json:"coalesced"`
+}
+
+type pliState struct {
+	lastSent time.Time
+	pending  bool
+}
+
+// pliAggregator coalesces the keyframe requests to the same SSRC, so a burst of subscribers joining at once doesn't
+// flood the publisher with PLIs. The first request in the window is sent immediately, the following requests are
+// merged into a single PLI that sent at the end of the window, so the late subscribers still get a fresh keyframe.
+type pliAggregator struct {
+	mu     sync.Mutex
+	window time.Duration
+	ssrcs  map[uint32]*pliState
+	stats  PLIStats
+}
+
+func newPLIAggregator(window time.Duration) *pliAggregator {
+	return &pliAggregator{
+		window: window,
+		ssrcs:  make(map[uint32]*pliState),
+	}
+}
+
+func (a *pliAggregator) request(ssrc uint32, send func()) {
+	a.mu.Lock()
+
+	a.stats.Requested++
+	now := time.Now()
+
+	state, ok := a.ssrcs[ssrc]
+	if !ok {
+		state = &pliState{}
+		a.ssrcs[ssrc] = state
+	}
+
+	elapsed := now.Sub(state.lastSent)
+
+	if elapsed >= a.window && !state.pending {
+		state.lastSent = now
+		a.stats.Sent++
+		a.removeIdle(now)
+		a.mu.Unlock()
+
+		send()
+
+		return
+	}
+
+	a.stats.Coalesced++
+
+	if state.pending {
+		a.mu.Unlock()
+		return
+	}
+
+	state.pending = true
+	a.mu.Unlock()
+
+	time.AfterFunc(a.window-elapsed, func() {
+		a.mu.Lock()
+		state.pending = false
+		state.lastSent = time.Now()
+		a.stats.Sent++
+		a.mu.Unlock()
+
+		send()
+	})
+}
+
+// removeIdle removes the SSRCs that has no request in the window, the ended tracks are never requested again
+func (a *pliAggregator) removeIdle(now time.Time) {
+	for ssrc, state := range a.ssrcs {
+		if !state.pending && now.Sub(state.lastSent) > a.window {
+			delete(a.ssrcs, ssrc)
+		}
+	}
+}
+
+func (a *pliAggregator) Stats() PLIStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.stats
+}
+
+// PLIStats returns the keyframe request counters of all publishers in the SFU
+func (s *SFU) PLIStats() PLIStats {
+	return s.pliAggregator.Stats()
+}
+
+// requestPLI sends the PLI to the publisher of the SSRC through the SFU PLI aggregator
+func (s *SFU) requestPLI(ssrc uint32, send func()) {
+	s.pliAggregator.request(ssrc, send)
+}
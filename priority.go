@@ -0,0 +1,263 @@
+package sfu
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const defaultMaxPrioritizedSubscriptions = 2
+
+var (
+	ErrInvalidSubscriptionPriority    = errors.New("client: error invalid subscription priority")
+	ErrSubscriptionPriorityNotAllowed = errors.New("client: error subscription priority is not allowed")
+)
+
+// SubscriptionPriority is the tier of a subscribed video in the bitrate allocation. When the bandwidth is scarce the
+// tracks of the higher tier get their quality first, and the lower tiers share the rest on their lowest quality.
+type SubscriptionPriority uint8
+
+const (
+	// SubscriptionPriorityAuto uses the high priority for the screen share and the normal priority for the other tracks
+	SubscriptionPriorityAuto SubscriptionPriority = iota
+	SubscriptionPriorityLow
+	SubscriptionPriorityNormal
+	SubscriptionPriorityHigh
+	// SubscriptionPriorityPinned is for the video that the user focuses on, like the pinned speaker
+	SubscriptionPriorityPinned
+)
+
+var subscriptionPriorityNames = map[SubscriptionPriority]string{
+	SubscriptionPriorityAuto:   "auto",
+	SubscriptionPriorityLow:    "low",
+	SubscriptionPriorityNormal: "normal",
+	SubscriptionPriorityHigh:   "high",
+	SubscriptionPriorityPinned: "pinned",
+}
+
+func (p SubscriptionPriority) String() string {
+	if name, ok := subscriptionPriorityNames[p]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+func (p SubscriptionPriority) MarshalText() ([]byte, error) {
+	if _, ok := subscriptionPriorityNames[p]; !ok {
+		return nil, ErrInvalidSubscriptionPriority
+	}
+
+	return []byte(p.String()), nil
+}
+
+func (p *SubscriptionPriority) UnmarshalText(text []byte) error {
+	priority, err := ParseSubscriptionPriority(string(text))
+	if err != nil {
+		return err
+	}
+
+	*p = priority
+
+	return nil
+}
+
+// ParseSubscriptionPriority returns the priority of the name: auto, low, normal, high, or pinned. Empty is auto.
+func ParseSubscriptionPriority(name string) (SubscriptionPriority, error) {
+	if name == "" {
+		return SubscriptionPriorityAuto, nil
+	}
+
+	for priority, n := range subscriptionPriorityNames {
+		if n == name {
+			return priority, nil
+		}
+	}
+
+	return SubscriptionPriorityAuto, ErrInvalidSubscriptionPriority
+}
+
+// subscriptionPriority is the data of the subscription_priority message that sent by the client
+type subscriptionPriority struct {
+	TrackID  string               `json:"track_id"`
+	Priority SubscriptionPriority `json:"priority" enums:"auto,low,normal,high,pinned"`
+}
+
+type internalDataSubscriptionPriority struct {
+	Type string               `json:"type"`
+	Data subscriptionPriority `json:"data"`
+}
+
+// SetSubscriptionPriority sets the priority of a subscribed video track in the bitrate allocation, for example the
+// pinned priority for the speaker that the user focuses on. It's not limited like the priority that set by the client.
+func (c *Client) SetSubscriptionPriority(trackID string, priority SubscriptionPriority) error {
+	claim, err := c.subscriptionPriorityClaim(trackID, priority)
+	if err != nil {
+		return err
+	}
+
+	claim.setPriority(priority)
+
+	c.log.Infof("client: %s set track %s priority to %s", c.id, trackID, priority)
+
+	return nil
+}
+
+// SubscriptionPriority returns the priority that set to the subscribed track, auto if it's not set
+func (c *Client) SubscriptionPriority(trackID string) (SubscriptionPriority, error) {
+	claim := c.bitrateController.GetClaim(trackID)
+	if claim == nil {
+		return SubscriptionPriorityAuto, ErrTrackIsNotExists
+	}
+
+	return claim.Priority(), nil
+}
+
+func (c *Client) subscriptionPriorityClaim(trackID string, priority SubscriptionPriority) (*bitrateClaim, error) {
+	if _, ok := subscriptionPriorityNames[priority]; !ok {
+		return nil, ErrInvalidSubscriptionPriority
+	}
+
+	claim := c.bitrateController.GetClaim(trackID)
+	if claim == nil {
+		return nil, ErrTrackIsNotExists
+	}
+
+	if claim.track.Kind() != webrtc.RTPCodecTypeVideo {
+		return nil, ErrTrackQualityNotAdjustable
+	}
+
+	return claim, nil
+}
+
+// onSubscriptionPriorityMessage sets the priority that requested by the client, the client can't go above
+// ClientOptions.SubscriberPriorityLimit, raise more than ClientOptions.MaxPrioritizedSubscriptions tracks above the
+// normal priority, or change the priority that the app server set above the limit
+func (c *Client) onSubscriptionPriorityMessage(data subscriptionPriority) error {
+	claim, err := c.subscriptionPriorityClaim(data.TrackID, data.Priority)
+	if err != nil {
+		return err
+	}
+
+	limit := c.options.SubscriberPriorityLimit
+	if data.Priority > limit || claim.Priority() > limit {
+		return ErrSubscriptionPriorityNotAllowed
+	}
+
+	if data.Priority > SubscriptionPriorityNormal {
+		prioritized := 0
+
+		for id, other := range c.bitrateController.Claims() {
+			if id != data.TrackID && other.Priority() > SubscriptionPriorityNormal {
+				prioritized++
+			}
+		}
+
+		if prioritized >= c.options.MaxPrioritizedSubscriptions {
+			return ErrSubscriptionPriorityNotAllowed
+		}
+	}
+
+	claim.setPriority(data.Priority)
+
+	c.log.Infof("client: %s requested track %s priority %s", c.id, data.TrackID, data.Priority)
+
+	return nil
+}
+
+// tier returns the priority of the claim in the bitrate allocation, the auto priority is resolved from the track source
+func (c *bitrateClaim) tier() SubscriptionPriority {
+	if priority := c.Priority(); priority != SubscriptionPriorityAuto {
+		return priority
+	}
+
+	if c.track.IsScreen() {
+		return SubscriptionPriorityHigh
+	}
+
+	return SubscriptionPriorityNormal
+}
+
+// claimsByTier returns the claims ordered by their tier, the lowest tier first unless descending
+func (bc *bitrateController) claimsByTier(descending bool) []*bitrateClaim {
+	claims := make([]*bitrateClaim, 0)
+	for _, claim := range bc.Claims() {
+		claims = append(claims, claim)
+	}
+
+	sort.SliceStable(claims, func(i, j int) bool {
+		if descending {
+			return claims[i].tier() > claims[j].tier()
+		}
+
+		return claims[i].tier() < claims[j].tier()
+	})
+
+	return claims
+}
+
+// allocateTiers allocates the bandwidth to the tracks of the higher tier first, the lowest quality of the lower tiers
+// is kept so they're not starved. The tracks of the same tier share the bandwidth by their weight.
+func allocateTiers(bandwidth uint32, tracks []*trackAllocation, tiers []SubscriptionPriority, levels []QualityLevel) {
+	if len(levels) == 0 {
+		return
+	}
+
+	lowest := slices.Min(levels)
+	groups := make(map[SubscriptionPriority][]*trackAllocation)
+	floors := make(map[SubscriptionPriority]uint32)
+	order := make([]SubscriptionPriority, 0)
+
+	for i, track := range tracks {
+		tier := tiers[i]
+		if tier == SubscriptionPriorityAuto {
+			tier = SubscriptionPriorityNormal
+		}
+
+		if _, ok := groups[tier]; !ok {
+			order = append(order, tier)
+		}
+
+		groups[tier] = append(groups[tier], track)
+		floors[tier] += track.bitrateAt(lowest)
+	}
+
+	sort.Slice(order, func(i, j int) bool { return order[i] > order[j] })
+
+	sorted := slices.Clone(levels)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	// the lower tiers are only raised once all tracks of the higher tiers get their max quality
+	starved := false
+
+	for i, tier := range order {
+		if starved {
+			for _, track := range groups[tier] {
+				track.quality = qualityForBitrate(sorted, track, 0)
+			}
+
+			continue
+		}
+
+		lowerFloors := uint32(0)
+		for _, lower := range order[i+1:] {
+			lowerFloors += floors[lower]
+		}
+
+		allocateBitrates(bandwidth-min(lowerFloors, bandwidth), groups[tier], levels)
+
+		spent := uint32(0)
+		for _, track := range groups[tier] {
+			spent += track.bitrateAt(track.quality)
+
+			if track.quality < qualityForBitrate(sorted, track, math.MaxUint64) {
+				starved = true
+			}
+		}
+
+		bandwidth -= min(spent, bandwidth)
+	}
+}
@@ -0,0 +1,179 @@
+package sfu
+
+import (
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	defaultDownlinkProbeDuration = 2 * time.Second
+	// the probe gives up if no subscribed video is forwarded to carry the padding after this time
+	downlinkProbeCarrierTimeout = 10 * time.Second
+	downlinkProbeInterval       = 50 * time.Millisecond
+	// the largest padding that fits in the RTP padding length byte
+	probePaddingSize = 255
+	// the most padding packets that written to a track in one burst
+	maxProbePacketsPerBurst = 64
+)
+
+// startDownlinkProbe sends the padding bursts to the client after it's connected, reconnected or its ICE is restarted,
+// so the bandwidth estimator ramps up to the bitrate of the high simulcast layers before they're forwarded. While probing, the new
+// subscriptions start from the low layer and the bitrate controller doesn't increase the quality.
+func (c *Client) startDownlinkProbe() {
+	if !c.options.EnableDownlinkProbe {
+		return
+	}
+
+	if !c.probingDownlink.CompareAndSwap(false, true) {
+		return
+	}
+
+	duration := c.options.DownlinkProbeDuration
+	if duration <= 0 {
+		duration = defaultDownlinkProbeDuration
+	}
+
+	c.resources.goroutine(func() {
+		defer c.probingDownlink.Store(false)
+
+		c.probeDownlink(duration)
+	})
+}
+
+// isProbingDownlink returns true while the padding bursts are sent to the client, see ClientOptions.EnableDownlinkProbe
+func (c *Client) isProbingDownlink() bool {
+	return c.probingDownlink.Load()
+}
+
+func (c *Client) probeDownlink(duration time.Duration) {
+	ticker := time.NewTicker(downlinkProbeInterval)
+	defer ticker.Stop()
+
+	waitUntil := time.Now().Add(downlinkProbeCarrierTimeout)
+
+	var probeUntil time.Time
+
+	for {
+		select {
+		case <-c.Context().Done():
+			return
+		case now := <-ticker.C:
+			// the padding is only sent on the selected path, it's changed while the ICE is restarting
+			if c.peerConnection.PC().ICEConnectionState() != webrtc.ICEConnectionStateConnected {
+				if probeUntil.IsZero() && now.After(waitUntil) {
+					c.log.Infof("client: downlink probe stopped, the ICE is not connected")
+					return
+				}
+
+				continue
+			}
+
+			carriers := c.probeCarriers()
+
+			if probeUntil.IsZero() {
+				if len(carriers) == 0 {
+					if now.After(waitUntil) {
+						c.log.Infof("client: downlink probe stopped, no video is forwarded to carry the padding")
+						return
+					}
+
+					continue
+				}
+
+				probeUntil = now.Add(duration)
+				c.log.Infof("client: probing the downlink bandwidth for %s", duration)
+			}
+
+			// the estimate starts from the initial bandwidth, it's only trusted after the probe
+			target := c.probeTargetBitrate()
+			if now.After(probeUntil) {
+				c.log.Infof("client: downlink probe done, estimated bandwidth %s target %s", ThousandSeparator(int(c.GetEstimatedBandwidth())), ThousandSeparator(int(target)))
+				return
+			}
+
+			sent := c.bitrateController.totalSentBitrates()
+			if sent >= target || len(carriers) == 0 {
+				continue
+			}
+
+			// the padding fills the gap between the sent bitrate and the target for one interval
+			bytes := uint64(target-sent) * uint64(downlinkProbeInterval) / uint64(time.Second) / 8
+			packets := int(bytes/probePaddingSize) + 1
+			perCarrier := min(packets/len(carriers)+1, maxProbePacketsPerBurst)
+
+			for _, carrier := range carriers {
+				carrier.writePadding(perCarrier)
+			}
+		}
+	}
+}
+
+// probeTargetBitrate returns the bitrate that the client needs to receive the subscribed tracks on the max quality,
+// the configured video bitrate is used until the bitrate of the layer is measured
+func (c *Client) probeTargetBitrate() uint32 {
+	target := uint32(0)
+
+	for _, claim := range c.bitrateController.Claims() {
+		if claim.track.Kind() != webrtc.RTPCodecTypeVideo || !claim.IsAdjustable() {
+			target += claim.SendBitrate()
+			continue
+		}
+
+		bitrate := claim.QualityLevelToBitrate(claim.track.MaxQuality())
+		if bitrate == 0 && claim.track.MaxQuality() != QualityNone {
+			bitrate = c.SFU().bitrateConfigs.VideoHigh
+		}
+
+		target += bitrate
+	}
+
+	return target
+}
+
+// probeCarriers returns the subscribed simulcast tracks that already forward the video, the padding is inserted into
+// their stream so the client doesn't need a separate probe stream
+func (c *Client) probeCarriers() []*simulcastClientTrack {
+	carriers := make([]*simulcastClientTrack, 0)
+
+	for _, track := range c.ClientTracks() {
+		if t, ok := track.(*simulcastClientTrack); ok {
+			if _, started := t.layerSwitcher.Layer(); started && !t.isEnded.Load() {
+				carriers = append(carriers, t)
+			}
+		}
+	}
+
+	return carriers
+}
+
+// writePadding requests the padding-only packets after the next forwarded frame. The packets are written by the write
+// path of the track after the last packet of the frame, so the sequence numbers reserved in the munger don't interleave
+// with the forwarded packets. A request replaces the previous one that is not written yet.
+func (t *simulcastClientTrack) writePadding(count int) {
+	t.pendingPadding.Store(int32(count))
+}
+
+// writePendingPadding writes the requested padding packets, it's only called from the write path after the last
+// packet of a frame
+func (t *simulcastClientTrack) writePendingPadding() {
+	count := t.pendingPadding.Swap(0)
+
+	for i := int32(0); i < count; i++ {
+		seq, ts, ok := t.layerSwitcher.Munger().InsertPadding()
+		if !ok {
+			return
+		}
+
+		t.writeRTP(&rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Padding:        true,
+				SequenceNumber: seq,
+				Timestamp:      ts,
+			},
+			PaddingSize: probePaddingSize,
+		})
+	}
+}
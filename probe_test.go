@@ -0,0 +1,131 @@
+package sfu
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProbeClientTrack(t *testing.T) *simulcastClientTrack {
+	log := logging.NewDefaultLoggerFactory().NewLogger("test")
+
+	localTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "stream")
+	require.NoError(t, err)
+
+	return &simulcastClientTrack{
+		client:        &Client{log: log, hotPathLog: log},
+		localTrack:    localTrack,
+		layerSwitcher: NewLayerSwitcher(90000),
+	}
+}
+
+// forward rewrites the packet like the write path and writes the requested padding after the last packet of a frame
+func forwardProbePacket(ct *simulcastClientTrack, seq uint16, marker bool) uint16 {
+	p := &rtp.Packet{Header: rtp.Header{SequenceNumber: seq, Timestamp: uint32(seq) * 3000, Marker: marker}}
+	ct.layerSwitcher.Rewrite(QualityLow, p)
+
+	if p.Marker {
+		ct.writePendingPadding()
+	}
+
+	return p.SequenceNumber
+}
+
+func TestProbePaddingBetweenFrames(t *testing.T) {
+	ct := newTestProbeClientTrack(t)
+
+	// nothing is written before the first packet is forwarded
+	ct.writePadding(3)
+	ct.writePendingPadding()
+	require.Equal(t, uint16(100), forwardProbePacket(ct, 100, false))
+
+	ct.writePadding(3)
+
+	// the padding is not inserted in the middle of a frame
+	require.Equal(t, uint16(101), forwardProbePacket(ct, 101, false))
+	require.Equal(t, uint16(102), forwardProbePacket(ct, 102, true))
+
+	// the sequence numbers 103-105 are used by the padding
+	require.Equal(t, uint16(106), forwardProbePacket(ct, 103, true))
+
+	// a request replaces the previous one
+	ct.writePadding(5)
+	ct.writePadding(1)
+	require.Equal(t, uint16(107), forwardProbePacket(ct, 104, true))
+	require.Equal(t, uint16(109), forwardProbePacket(ct, 105, true))
+}
+
+func TestProbePaddingConcurrentRequests(t *testing.T) {
+	ct := newTestProbeClientTrack(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	// the probe requests the padding while the packets are forwarded
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			ct.writePadding(2)
+		}
+	}()
+
+	last := forwardProbePacket(ct, 0, true)
+
+	for seq := uint16(1); seq < 1000; seq++ {
+		next := forwardProbePacket(ct, seq, true)
+
+		// the padding only shifts the sequence numbers forward, no forwarded packet reuses a padding sequence number
+		require.Greater(t, int16(next-last), int16(0))
+		require.LessOrEqual(t, int16(next-last), int16(3))
+
+		last = next
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestClientRestartICEDownlinkProbe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	clientOpts := DefaultClientOptions()
+	clientOpts.EnableDownlinkProbe = true
+
+	client, err := testRoom.AddClient("peer", "peer", clientOpts)
+	require.NoError(t, err)
+
+	_ = connectResumablePeer(t, client)
+
+	require.Eventually(t, client.isProbingDownlink, 30*time.Second, 50*time.Millisecond)
+
+	// no video is forwarded to carry the padding, the probe gives up after the timeout
+	require.Eventually(t, func() bool {
+		return !client.isProbingDownlink()
+	}, downlinkProbeCarrierTimeout+5*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, client.RestartICE())
+
+	require.Eventually(t, client.isProbingDownlink, 30*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, client.End())
+}
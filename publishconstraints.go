@@ -0,0 +1,338 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+var (
+	ErrTooManyVideoTracks = errors.New("client: error the client publishes more video tracks than allowed")
+	ErrTooManyAudioTracks = errors.New("client: error the client publishes more audio tracks than allowed")
+	ErrCodecNotAllowed    = errors.New("client: error the track codec is not allowed to publish")
+	ErrResolutionTooHigh  = errors.New("client: error the video resolution is higher than allowed")
+)
+
+// PublishConstraints restricts what a client can publish, see RoomOptions.PublishConstraints. The zero value of each
+// field means no limit.
+type PublishConstraints struct {
+	// MaxVideoTracks is the number of the video tracks that the client can publish, the video sections that offered after
+	// the limit is reached are answered without accepting them
+	MaxVideoTracks int `json:"max_video_tracks" example:"2"`
+	// MaxAudioTracks is the number of the audio tracks that the client can publish
+	MaxAudioTracks int `json:"max_audio_tracks" example:"1"`
+	// AllowedCodecs is the mime types that the client can publish, the other codecs are removed from the offer and
+	// a media section without an allowed codec is not accepted
+	AllowedCodecs []string `json:"allowed_codecs" example:"video/VP8,audio/opus"`
+	// MaxWidth and MaxHeight limit the resolution of the published video, a VP8 or VP9 track or simulcast layer with
+	// a bigger keyframe is not forwarded until it sends a keyframe within the limit
+	MaxWidth  uint32 `json:"max_width" example:"1280"`
+	MaxHeight uint32 `json:"max_height" example:"720"`
+	// MaxBitrate caps the uplink of the client in bits per second with REMB, see Client.SetMaxUplinkBitrate
+	MaxBitrate uint32 `json:"max_bitrate" example:"1500000"`
+}
+
+// PublishConstraintError is passed to the OnPublishRejected callbacks when a published media section or track violates
+// the publish constraints of the client. It wraps one of ErrTooManyVideoTracks, ErrTooManyAudioTracks, ErrCodecNotAllowed
+// or ErrResolutionTooHigh.
+type PublishConstraintError struct {
+	ClientID string
+	// Mid is the media section that rejected in the negotiation, empty for the resolution limit
+	Mid string
+	// TrackID is the track that not forwarded because of the resolution limit, empty for the rejected media section
+	TrackID string
+	Err     error
+}
+
+func (e *PublishConstraintError) Error() string {
+	if e.TrackID != "" {
+		return fmt.Sprintf("%s: client %s, track %s", e.Err.Error(), e.ClientID, e.TrackID)
+	}
+
+	return fmt.Sprintf("%s: client %s, mid %s", e.Err.Error(), e.ClientID, e.Mid)
+}
+
+func (e *PublishConstraintError) Unwrap() error {
+	return e.Err
+}
+
+// Reason returns the reason of the publish_rejected message
+func (e *PublishConstraintError) Reason() string {
+	switch {
+	case errors.Is(e.Err, ErrTooManyVideoTracks):
+		return "max_video_tracks"
+	case errors.Is(e.Err, ErrTooManyAudioTracks):
+		return "max_audio_tracks"
+	case errors.Is(e.Err, ErrCodecNotAllowed):
+		return "codec_not_allowed"
+	case errors.Is(e.Err, ErrResolutionTooHigh):
+		return "max_resolution"
+	default:
+		return "unknown"
+	}
+}
+
+// publishRejected is the data of the publish_rejected message that sent to the client
+type publishRejected struct {
+	Mid     string `json:"mid,omitempty"`
+	TrackID string `json:"track_id,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+type internalDataPublishRejected struct {
+	Type string          `json:"type"`
+	Data publishRejected `json:"data"`
+}
+
+// the codecs that only carry or protect the media of another codec, they're never removed from the offer
+var auxiliaryCodecs = []string{"rtx", "red", "ulpfec", "flexfec-03"}
+
+// applyPublishConstraints rejects the media sections of the offer that violate the constraints and removes the codecs
+// that not allowed, it returns the munged SDP and the errors of the rejected sections without the client ID.
+// The sections keep their order in the renegotiation, so the tracks that already published are never rejected by
+// a track that offered later.
+func applyPublishConstraints(sdp string, constraints PublishConstraints) (string, []*PublishConstraintError) {
+	lines := strings.Split(sdp, "\r\n")
+	rejected := make([]*PublishConstraintError, 0)
+	videoTracks := 0
+	audioTracks := 0
+
+	start := -1
+	for i := 0; i <= len(lines); i++ {
+		if i < len(lines) && !strings.HasPrefix(lines[i], "m=") {
+			continue
+		}
+
+		if start >= 0 && (strings.HasPrefix(lines[start], "m=audio") || strings.HasPrefix(lines[start], "m=video")) {
+			section := lines[start:i]
+
+			if mid, sending := publishSection(section); sending {
+				var err error
+
+				isVideo := strings.HasPrefix(section[0], "m=video")
+
+				switch {
+				case !filterSectionCodecs(section, constraints.AllowedCodecs):
+					err = ErrCodecNotAllowed
+				case isVideo && constraints.MaxVideoTracks > 0 && videoTracks >= constraints.MaxVideoTracks:
+					err = ErrTooManyVideoTracks
+				case !isVideo && constraints.MaxAudioTracks > 0 && audioTracks >= constraints.MaxAudioTracks:
+					err = ErrTooManyAudioTracks
+				case isVideo:
+					videoTracks++
+				default:
+					audioTracks++
+				}
+
+				if err != nil {
+					rejectPublishSection(section)
+					rejected = append(rejected, &PublishConstraintError{Mid: mid, Err: err})
+				}
+			}
+		}
+
+		start = i
+	}
+
+	return strings.Join(lines, "\r\n"), rejected
+}
+
+// publishSection returns the mid of the media section and true if the remote peer sends a track in it
+func publishSection(lines []string) (string, bool) {
+	mid := ""
+	sending := false
+	hasTrack := false
+
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "a=mid:"):
+			mid = strings.TrimPrefix(line, "a=mid:")
+		case line == "a=sendrecv" || line == "a=sendonly":
+			sending = true
+		case strings.HasPrefix(line, "a=msid:"):
+			hasTrack = true
+		}
+	}
+
+	return mid, sending && hasTrack
+}
+
+// filterSectionCodecs removes the payload types of the codecs that not allowed from the m= line, it returns false
+// if no allowed codec is left in the section
+func filterSectionCodecs(lines []string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	fields := strings.Fields(lines[0])
+	if len(fields) < 4 {
+		return false
+	}
+
+	kind := strings.TrimPrefix(fields[0], "m=")
+
+	// the codec name and the associated payload type of the payload types
+	names := make(map[string]string)
+	apts := make(map[string]string)
+
+	for _, line := range lines {
+		if value, ok := strings.CutPrefix(line, "a=rtpmap:"); ok {
+			pt, codec, _ := strings.Cut(value, " ")
+			name, _, _ := strings.Cut(codec, "/")
+			names[pt] = strings.ToLower(name)
+		} else if value, ok := strings.CutPrefix(line, "a=fmtp:"); ok {
+			pt, params, _ := strings.Cut(value, " ")
+			for _, param := range strings.Split(params, ";") {
+				if apt, ok := strings.CutPrefix(strings.TrimSpace(param), "apt="); ok {
+					apts[pt] = apt
+				}
+			}
+		}
+	}
+
+	isAllowed := func(pt string) bool {
+		return slices.ContainsFunc(allowed, func(mimeType string) bool {
+			return strings.EqualFold(mimeType, kind+"/"+names[pt])
+		})
+	}
+
+	formats := make([]string, 0, len(fields)-3)
+	hasAllowed := false
+
+	for _, pt := range fields[3:] {
+		switch {
+		case slices.Contains(auxiliaryCodecs, names[pt]):
+			// the retransmission of a removed codec is removed too
+			if apt, ok := apts[pt]; ok && !isAllowed(apt) {
+				continue
+			}
+		case !isAllowed(pt):
+			continue
+		default:
+			hasAllowed = true
+		}
+
+		formats = append(formats, pt)
+	}
+
+	if hasAllowed {
+		lines[0] = strings.Join(append(fields[:3], formats...), " ")
+	}
+
+	return hasAllowed
+}
+
+// checkPublishedTracks returns the error of the first constraint that the published tracks violate, it's used when
+// the client is moved to a room with other constraints
+func checkPublishedTracks(tracks []ITrack, constraints PublishConstraints) error {
+	videoTracks := 0
+	audioTracks := 0
+
+	for _, track := range tracks {
+		_, codec, _ := strings.Cut(strings.ToLower(track.MimeType()), "/")
+
+		if len(constraints.AllowedCodecs) > 0 && !slices.Contains(auxiliaryCodecs, codec) &&
+			!slices.ContainsFunc(constraints.AllowedCodecs, func(mimeType string) bool {
+				return strings.EqualFold(mimeType, track.MimeType())
+			}) {
+			return ErrCodecNotAllowed
+		}
+
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			videoTracks++
+		} else {
+			audioTracks++
+		}
+	}
+
+	if constraints.MaxVideoTracks > 0 && videoTracks > constraints.MaxVideoTracks {
+		return ErrTooManyVideoTracks
+	}
+
+	if constraints.MaxAudioTracks > 0 && audioTracks > constraints.MaxAudioTracks {
+		return ErrTooManyAudioTracks
+	}
+
+	return nil
+}
+
+// publishConstraints returns the publish constraints of the client, the zero value if there is no constraint
+func (c *Client) publishConstraints() PublishConstraints {
+	constraints := c.constraints.Load()
+	if constraints == nil {
+		return PublishConstraints{}
+	}
+
+	return *constraints
+}
+
+// onPublishConstraintViolated notifies the OnPublishRejected callbacks and the client with the publish_rejected message
+func (c *Client) onPublishConstraintViolated(err *PublishConstraintError) {
+	err.ClientID = c.id
+
+	c.log.Warnf("client: %s publish rejected, %s", c.id, err.Error())
+
+	c.onPublishRejected(err)
+
+	data, marshalErr := json.Marshal(internalDataPublishRejected{
+		Type: messageTypePublishRejected,
+		Data: publishRejected{Mid: err.Mid, TrackID: err.TrackID, Reason: err.Reason()},
+	})
+	if marshalErr != nil {
+		c.log.Errorf("client: error marshal publish rejected ", marshalErr)
+		return
+	}
+
+	c.sendInternalMessage(data)
+}
+
+// maxResolutionInterceptor returns the ingress interceptor that drops the video packets of a track or a simulcast layer
+// after a keyframe that bigger than the max resolution, nil if there is no resolution limit. The layer is forwarded again
+// after a keyframe within the limit. The limit is read on every keyframe, so it follows the constraints of the room
+// that the client is moved to. The packets of the end-to-end encrypted tracks are passed through, their payload can't be
+// parsed.
+func (c *Client) maxResolutionInterceptor() PacketInterceptor {
+	if constraints := c.publishConstraints(); constraints.MaxWidth == 0 && constraints.MaxHeight == 0 {
+		return nil
+	}
+
+	var mu sync.Mutex
+
+	exceeded := make(map[QualityLevel]bool)
+	notified := false
+
+	return func(info PacketInfo, p *rtp.Packet) bool {
+		if c.IsE2EE() {
+			return true
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !IsKeyframe(info.MimeType, p.Payload) {
+			return !exceeded[info.Quality]
+		}
+
+		width, height := KeyframeDimensions(info.MimeType, p.Payload)
+		if width == 0 || height == 0 {
+			return !exceeded[info.Quality]
+		}
+
+		constraints := c.publishConstraints()
+		tooHigh := (constraints.MaxWidth > 0 && width > constraints.MaxWidth) || (constraints.MaxHeight > 0 && height > constraints.MaxHeight)
+		exceeded[info.Quality] = tooHigh
+
+		if tooHigh && !notified {
+			notified = true
+			go c.onPublishConstraintViolated(&PublishConstraintError{TrackID: info.TrackID, Err: ErrResolutionTooHigh})
+		}
+
+		return !tooHigh
+	}
+}
@@ -0,0 +1,24 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxResolutionInterceptorE2EE(t *testing.T) {
+	client := &Client{options: ClientOptions{E2EE: true}}
+	client.constraints.Store(&PublishConstraints{MaxWidth: 320, MaxHeight: 180})
+
+	interceptor := client.maxResolutionInterceptor()
+	require.NotNil(t, interceptor)
+
+	info := PacketInfo{TrackID: "video", MimeType: webrtc.MimeTypeVP8, Quality: QualityHigh}
+
+	// the ciphertext looks like a 640x360 VP8 keyframe, it's passed through without being parsed
+	keyframe := []byte{0x10, 0x50, 0x01, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0x68, 0x01, 0xaa, 0xbb}
+	require.True(t, interceptor(info, &rtp.Packet{Payload: keyframe}))
+	require.True(t, interceptor(info, &rtp.Packet{Payload: []byte{0x00, 0xcc}}))
+}
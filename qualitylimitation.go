@@ -0,0 +1,149 @@
+package sfu
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const qualityLimitationInterval = time.Second
+
+// QualityLimitationReason is why a subscribed video is forwarded below the high quality, similar to the
+// qualityLimitationReason of the browser outbound RTP stats
+type QualityLimitationReason string
+
+const (
+	// QualityLimitationNone means the video is forwarded on the high quality
+	QualityLimitationNone QualityLimitationReason = "none"
+	// QualityLimitationBandwidth means the downlink bandwidth of the subscriber doesn't fit the higher quality
+	QualityLimitationBandwidth QualityLimitationReason = "bandwidth"
+	// QualityLimitationCPU means the publisher stopped sending the higher layer because its encoder is CPU limited
+	QualityLimitationCPU QualityLimitationReason = "cpu"
+	// QualityLimitationLayerInactive means the publisher doesn't send the higher layer
+	QualityLimitationLayerInactive QualityLimitationReason = "layer_inactive"
+	// QualityLimitationPolicy means the quality is capped by the subscriber, the forwarding policy, or the quality override
+	QualityLimitationPolicy QualityLimitationReason = "policy"
+)
+
+// qualityLimitation is the data of the quality_limitation message that sent to the subscriber
+type qualityLimitation struct {
+	TrackID    string                  `json:"track_id"`
+	Reason     QualityLimitationReason `json:"reason"`
+	Quality    QualityLevel            `json:"quality"`
+	MaxQuality QualityLevel            `json:"max_quality"`
+}
+
+type internalDataQualityLimitation struct {
+	Type string            `json:"type"`
+	Data qualityLimitation `json:"data"`
+}
+
+// qualityLimitationReason returns why the subscribed track is forwarded below the high quality
+func (c *Client) qualityLimitationReason(track iClientTrack) QualityLimitationReason {
+	if track.Kind() != webrtc.RTPCodecTypeVideo {
+		return QualityLimitationNone
+	}
+
+	if c.isTrackPaused(track.ID()) {
+		return QualityLimitationPolicy
+	}
+
+	quality := track.Quality()
+	if quality >= QualityHigh {
+		return QualityLimitationNone
+	}
+
+	capped := min(track.MaxQuality(), Uint32ToQualityLevel(c.quality.Load()))
+
+	target := capped
+	if claim := c.bitrateController.GetClaim(track.ID()); claim != nil {
+		target = min(target, claim.Quality())
+
+		if quality >= target && claim.Quality() < capped {
+			return QualityLimitationBandwidth
+		}
+	}
+
+	// the forwarded layer is lower than the selected layer, the publisher doesn't send it
+	if quality < target {
+		if t, ok := track.(*simulcastClientTrack); ok {
+			if reason, _ := t.remoteTrack.base.publisher().ingressQualityLimitationReason.Load().(string); reason == "cpu" || reason == "both" {
+				return QualityLimitationCPU
+			}
+		}
+
+		return QualityLimitationLayerInactive
+	}
+
+	if capped < QualityHigh {
+		return QualityLimitationPolicy
+	}
+
+	return QualityLimitationNone
+}
+
+// QualityLimitationReason returns why the subscribed track is forwarded below the high quality
+func (c *Client) QualityLimitationReason(trackID string) (QualityLimitationReason, error) {
+	track, ok := c.ClientTracks()[trackID]
+	if !ok {
+		return QualityLimitationNone, ErrTrackIsNotExists
+	}
+
+	return c.qualityLimitationReason(track), nil
+}
+
+// loopQualityLimitation sends the quality_limitation message to the client when the reason of a subscribed video is changed
+func (c *Client) loopQualityLimitation() {
+	ticker := time.NewTicker(qualityLimitationInterval)
+	defer ticker.Stop()
+
+	reasons := make(map[string]QualityLimitationReason)
+
+	for {
+		select {
+		case <-c.Context().Done():
+			return
+		case <-ticker.C:
+			current := make(map[string]QualityLimitationReason)
+
+			for id, track := range c.ClientTracks() {
+				if track.Kind() != webrtc.RTPCodecTypeVideo {
+					continue
+				}
+
+				reason := c.qualityLimitationReason(track)
+				current[id] = reason
+
+				previous, ok := reasons[id]
+				if !ok {
+					previous = QualityLimitationNone
+				}
+
+				if reason != previous {
+					c.sendQualityLimitation(track, reason)
+				}
+			}
+
+			reasons = current
+		}
+	}
+}
+
+func (c *Client) sendQualityLimitation(track iClientTrack, reason QualityLimitationReason) {
+	data, err := json.Marshal(internalDataQualityLimitation{
+		Type: messageTypeQualityLimitation,
+		Data: qualityLimitation{
+			TrackID:    track.ID(),
+			Reason:     reason,
+			Quality:    track.Quality(),
+			MaxQuality: track.MaxQuality(),
+		},
+	})
+	if err != nil {
+		c.log.Errorf("client: error marshal quality limitation ", err)
+		return
+	}
+
+	c.sendInternalMessage(data)
+}
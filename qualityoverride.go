@@ -0,0 +1,108 @@
+package sfu
+
+import (
+	"errors"
+
+	"github.com/pion/webrtc/v4"
+)
+
+var (
+	ErrTrackQualityNotAdjustable = errors.New("client: error track quality is not adjustable")
+	ErrInvalidTrackQuality       = errors.New("client: error invalid track quality")
+)
+
+// trackQuality is the data of the track_quality message, empty quality will unpin the track quality
+type trackQuality struct {
+	TrackID string `json:"track_id"`
+	Quality string `json:"quality" enums:"high,mid,low"`
+}
+
+// PinTrackQuality pins the quality of a subscribed simulcast or SVC video track regardless of the estimated bandwidth
+// and the video size, for example to always receive the low quality for the thumbnails and the high quality for the
+// maximized video. The pinned track bitrate still counts in the bandwidth usage, so the other tracks will adjust
+// their quality to fit the bandwidth.
+func (c *Client) PinTrackQuality(trackID string, quality QualityLevel) error {
+	if quality < QualityLowLow || quality > QualityHigh {
+		return ErrInvalidTrackQuality
+	}
+
+	claim := c.bitrateController.GetClaim(trackID)
+	if claim == nil {
+		return ErrTrackIsNotExists
+	}
+
+	if claim.track.Kind() != webrtc.RTPCodecTypeVideo || (!claim.track.IsSimulcast() && !claim.track.IsScaleable()) {
+		return ErrTrackQualityNotAdjustable
+	}
+
+	claim.setPinned(true)
+	claim.track.SetMaxQuality(quality)
+	c.bitrateController.setQuality(trackID, quality)
+	claim.track.RequestPLI()
+
+	c.log.Infof("client: %s pinned track %s quality to %d", c.id, trackID, quality)
+
+	return nil
+}
+
+// UnpinTrackQuality lets the bitrate controller adjust the track quality again based on the estimated bandwidth
+func (c *Client) UnpinTrackQuality(trackID string) error {
+	claim := c.bitrateController.GetClaim(trackID)
+	if claim == nil {
+		return ErrTrackIsNotExists
+	}
+
+	if !claim.IsPinned() {
+		return nil
+	}
+
+	claim.setPinned(false)
+	claim.track.SetMaxQuality(QualityHigh)
+
+	c.log.Infof("client: %s unpinned track %s quality", c.id, trackID)
+
+	return nil
+}
+
+// SetTrackMaxTemporalLayer caps the temporal layer of a subscribed simulcast or SVC video track independently from
+// the spatial layer of the quality level, to reduce the frame rate without reducing the resolution.
+// Temporal layer 0 is the lowest frame rate, set it to 2 to remove the cap.
+func (c *Client) SetTrackMaxTemporalLayer(trackID string, tid uint8) error {
+	track, ok := c.ClientTracks()[trackID]
+	if !ok {
+		return ErrTrackIsNotExists
+	}
+
+	if track.Kind() != webrtc.RTPCodecTypeVideo || (!track.IsSimulcast() && !track.IsScaleable()) {
+		return ErrTrackQualityNotAdjustable
+	}
+
+	if tid >= maxTemporalID {
+		c.maxTemporalLayers.Delete(trackID)
+	} else {
+		c.maxTemporalLayers.Store(trackID, tid)
+	}
+
+	return nil
+}
+
+func (c *Client) maxTemporalLayer(trackID string) uint8 {
+	if tid, ok := c.maxTemporalLayers.Load(trackID); ok {
+		return tid.(uint8)
+	}
+
+	return maxTemporalID
+}
+
+func (c *Client) onTrackQualityMessage(data trackQuality) error {
+	if data.Quality == "" {
+		return c.UnpinTrackQuality(data.TrackID)
+	}
+
+	switch data.Quality {
+	case "high", "mid", "low":
+		return c.PinTrackQuality(data.TrackID, RIDToQuality(data.Quality))
+	}
+
+	return ErrInvalidTrackQuality
+}
@@ -0,0 +1,100 @@
+package sfu
+
+// QualityStrategy selects the quality of the subscribed video tracks of a client, it's called every second and when
+// the tracks are subscribed. Set it with RoomOptions.QualityStrategy to replace the default weighted bitrate share
+// without forking the SFU. It must not block, and it's called from the bitrate controller of each client.
+type QualityStrategy interface {
+	// SelectQualities returns the quality level of the tracks by the track ID, the track that not in the result keeps
+	// its quality. The quality is capped to the QualityTrack.MaxQuality.
+	SelectQualities(input QualityInput) map[string]QualityLevel
+}
+
+// QualityStrategyFunc is a function that implements the QualityStrategy
+type QualityStrategyFunc func(input QualityInput) map[string]QualityLevel
+
+func (f QualityStrategyFunc) SelectQualities(input QualityInput) map[string]QualityLevel {
+	return f(input)
+}
+
+// QualityInput is the state of a client that passed to the QualityStrategy
+type QualityInput struct {
+	ClientID string
+	// Bandwidth is the estimated downlink bandwidth of the client in bits per second, it's capped by the downlink limits
+	// and the room budget
+	Bandwidth uint32
+	// Reserved is the bitrate of the audio tracks and the video tracks with the pinned quality, it's part of the Bandwidth
+	Reserved uint32
+	// Levels is the enabled quality levels of the client, see the room quality presets
+	Levels []QualityLevel
+	// Tracks is the adjustable video tracks that receive the packets, the paused and the hidden tracks are not included
+	Tracks []QualityTrack
+}
+
+// QualityTrack is a subscribed video track in the QualityInput
+type QualityTrack struct {
+	ID          string
+	PublisherID string
+	IsScreen    bool
+	// Quality is the current quality of the track
+	Quality    QualityLevel
+	MaxQuality QualityLevel
+	// Priority is the share weight of the track, the screen share and the dominant speaker have the higher priority
+	Priority uint32
+	// Tier is the subscription priority of the track, the auto priority is already resolved from the track source
+	Tier SubscriptionPriority
+	// MinBitrate is the bitrate that the track needs even if it's more than its share, like the screen share
+	MinBitrate uint32
+	// ViewportWidth and ViewportHeight are the rendered size that reported by the client with the video_size message,
+	// zero if it's not reported
+	ViewportWidth  uint32
+	ViewportHeight uint32
+	// LayerBitrates is the measured bitrate of each enabled quality level, zero if the layer is not received
+	LayerBitrates map[QualityLevel]uint32
+}
+
+type weightedQualityStrategy struct{}
+
+// DefaultQualityStrategy returns the default strategy that allocates the bandwidth to the higher tier first, then
+// shares it by the priority of the tracks in the same tier. The tracks that need less than their share give the rest
+// back to the other tracks, and each track gets the highest quality that fits its share.
+func DefaultQualityStrategy() QualityStrategy {
+	return weightedQualityStrategy{}
+}
+
+func (weightedQualityStrategy) SelectQualities(input QualityInput) map[string]QualityLevel {
+	qualities := make(map[string]QualityLevel, len(input.Tracks))
+
+	if len(input.Tracks) == 0 || len(input.Levels) == 0 {
+		return qualities
+	}
+
+	bandwidth := input.Bandwidth - min(input.Reserved, input.Bandwidth)
+
+	tracks := make([]*trackAllocation, 0, len(input.Tracks))
+	tiers := make([]SubscriptionPriority, 0, len(input.Tracks))
+
+	for _, track := range input.Tracks {
+		layerBitrates := track.LayerBitrates
+
+		tracks = append(tracks, &trackAllocation{
+			id:         track.ID,
+			weight:     max(track.Priority, 1),
+			minBitrate: track.MinBitrate,
+			maxQuality: track.MaxQuality,
+			bitrateAt: func(quality QualityLevel) uint32 {
+				return layerBitrates[quality]
+			},
+			quality: track.Quality,
+		})
+
+		tiers = append(tiers, track.Tier)
+	}
+
+	allocateTiers(bandwidth, tracks, tiers, input.Levels)
+
+	for _, track := range tracks {
+		qualities[track.id] = track.quality
+	}
+
+	return qualities
+}
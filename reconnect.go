@@ -0,0 +1,513 @@
+package sfu
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/logger"
+	"github.com/inlivedev/sfu/pkg/networkmonitor"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/webrtc/v4"
+)
+
+var ErrInvalidReconnectToken = errors.New("room: error invalid or expired reconnect token")
+
+// the maximum time to wait for the previous client is removed when it's still connected on resume
+const resumeRemoveTimeout = 5 * time.Second
+
+// clientSession keeps the state of a client that can be resumed with the reconnect token, the session is suspended
+// when the client transport is lost and it's removed if the client is not resumed in the grace period.
+type clientSession struct {
+	token  string
+	client *Client
+	joined bool
+	// the client is resumed, the previous client is removed without the left event
+	resumed       bool
+	suspended     bool
+	subscriptions []SubscribeTrackRequest
+	timer         *time.Timer
+}
+
+type clientSessionList struct {
+	mu       sync.Mutex
+	sessions map[string]*clientSession
+}
+
+func newClientSessionList() *clientSessionList {
+	return &clientSessionList{
+		sessions: make(map[string]*clientSession),
+	}
+}
+
+func (l *clientSessionList) add(client *Client) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	token := GenerateID(32)
+	l.sessions[token] = &clientSession{token: token, client: client}
+
+	return token
+}
+
+func (l *clientSessionList) setJoined(token string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if session, ok := l.sessions[token]; ok {
+		session.joined = true
+	}
+}
+
+func (l *clientSessionList) remove(token string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if session, ok := l.sessions[token]; ok {
+		if session.timer != nil {
+			session.timer.Stop()
+		}
+
+		delete(l.sessions, token)
+	}
+}
+
+// suspendedCount returns the number of the suspended clients that can still be resumed
+func (l *clientSessionList) suspendedCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	count := 0
+
+	for _, session := range l.sessions {
+		if session.suspended && !session.resumed {
+			count++
+		}
+	}
+
+	return count
+}
+
+// clear removes all sessions and returns the suspended clients
+func (l *clientSessionList) clear() []*Client {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	clients := make([]*Client, 0)
+
+	for token, session := range l.sessions {
+		if session.suspended && !session.resumed {
+			session.timer.Stop()
+			clients = append(clients, session.client)
+		}
+
+		delete(l.sessions, token)
+	}
+
+	return clients
+}
+
+// capture keeps the subscribed tracks of the client, it must be called before the client context is canceled. The
+// published tracks are held by the client itself, see Client.holdTrack
+func (s *clientSession) capture() {
+	s.subscriptions = make([]SubscribeTrackRequest, 0)
+	for _, track := range s.client.publishedTracks.GetTracks() {
+		s.subscriptions = append(s.subscriptions, SubscribeTrackRequest{ClientID: track.ClientID(), TrackID: track.ID()})
+	}
+}
+
+// ReconnectToken returns the token to resume the client with Room.ResumeClient after the transport is lost,
+// it's empty if RoomOptions.ReconnectGracePeriod is not set. Pass it to the client with the join response.
+func (c *Client) ReconnectToken() string {
+	token, _ := c.reconnectToken.Load().(string)
+	return token
+}
+
+func (c *Client) setReconnectToken(token string) {
+	c.reconnectToken.Store(token)
+}
+
+func (r *Room) reconnectGracePeriod() time.Duration {
+	if r.options.ReconnectGracePeriod == nil {
+		return 0
+	}
+
+	return *r.options.ReconnectGracePeriod
+}
+
+// suspendClient returns true if the removed client is kept to be resumed, the left event is delayed until
+// the grace period is passed
+func (r *Room) suspendClient(client *Client) bool {
+	token := client.ReconnectToken()
+	if token == "" {
+		return false
+	}
+
+	// the client is stopped by the server or the client itself, it can't be resumed
+	if client.leaving.Load() || r.context.Err() != nil || r.sfu.IsDraining() {
+		r.sessions.remove(token)
+		return false
+	}
+
+	r.sessions.mu.Lock()
+	defer r.sessions.mu.Unlock()
+
+	session, ok := r.sessions.sessions[token]
+	if !ok || !session.joined {
+		delete(r.sessions.sessions, token)
+		return false
+	}
+
+	if session.resumed {
+		return true
+	}
+
+	session.capture()
+	session.suspended = true
+	session.timer = time.AfterFunc(r.reconnectGracePeriod(), func() {
+		r.sessions.mu.Lock()
+		if session.resumed {
+			r.sessions.mu.Unlock()
+			return
+		}
+
+		delete(r.sessions.sessions, session.token)
+		r.sessions.mu.Unlock()
+
+		r.sfu.log.Infof("room: client %s is not resumed in the grace period", client.ID())
+
+		r.onClientLeft(client)
+	})
+
+	r.sfu.log.Infof("room: client %s is suspended, waiting to resume", client.ID())
+
+	return true
+}
+
+// ResumeClient adds the client again with the same ID and name after the transport is lost, the client is subscribed
+// to the tracks that it subscribed before. The other clients don't get the client left and joined events, use
+// Room.OnClientResumed to get notified when the resumed client is connected. If the previous client is still connected,
+// it's stopped first. It returns ErrInvalidReconnectToken if the grace period is passed or the client is left.
+//
+// The published tracks of the previous client are held in the grace period, the subscribers keep them without a
+// renegotiation. The tracks that the resumed client publishes take over the held tracks: the track with the same ID,
+// or else the first held track with the same kind, codec, and simulcast in the order they're published. The held
+// tracks that are not taken over in the grace period after the resume are ended.
+//
+// The returned client has a new reconnect token, the previous token can't be used again.
+func (r *Room) ResumeClient(reconnectToken string, opts ClientOptions) (*Client, error) {
+	r.sessions.mu.Lock()
+	session, ok := r.sessions.sessions[reconnectToken]
+	if !ok || !session.joined || session.resumed {
+		r.sessions.mu.Unlock()
+		return nil, ErrInvalidReconnectToken
+	}
+
+	session.resumed = true
+
+	if session.timer != nil {
+		session.timer.Stop()
+	}
+
+	if !session.suspended {
+		session.capture()
+	}
+	r.sessions.mu.Unlock()
+
+	previous := session.client
+
+	if !session.suspended {
+		if err := previous.stop(); err != nil {
+			r.sfu.log.Errorf("room: error stop the previous client ", err)
+		}
+
+		// the client context is canceled once the client is removed from the SFU
+		select {
+		case <-previous.Context().Done():
+		case <-time.After(resumeRemoveTimeout):
+			r.sfu.log.Warnf("room: the previous client %s is not removed in %s", previous.ID(), resumeRemoveTimeout)
+		}
+	}
+
+	r.sessions.remove(reconnectToken)
+
+	client, err := r.addClient(previous.ID(), previous.Name(), opts, true)
+	if err != nil {
+		r.onClientLeft(previous)
+		return nil, err
+	}
+
+	// only the tracks that still published in the room
+	subscriptions := make([]SubscribeTrackRequest, 0, len(session.subscriptions))
+	for _, sub := range session.subscriptions {
+		publisher, err := r.sfu.GetClient(sub.ClientID)
+		if err != nil {
+			if r.sfu.hasRelayTrack(sub.TrackID) {
+				subscriptions = append(subscriptions, sub)
+			}
+
+			continue
+		}
+
+		if _, err := publisher.tracks.Get(sub.TrackID); err == nil {
+			subscriptions = append(subscriptions, sub)
+		}
+	}
+
+	if len(subscriptions) > 0 {
+		if err := client.SubscribeTracks(subscriptions); err != nil {
+			r.sfu.log.Errorf("room: error subscribe the previous tracks ", err)
+		}
+	}
+
+	client.resumedFrom.Store(previous)
+
+	// the held tracks that are not published again are ended
+	time.AfterFunc(r.reconnectGracePeriod(), previous.releaseHeldTracks)
+
+	return client, nil
+}
+
+// OnClientResumed event is called when a client that resumed with ResumeClient is connected again
+func (r *Room) OnClientResumed(callback func(client *Client)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onResumedCallbacks = append(r.onResumedCallbacks, callback)
+}
+
+func (r *Room) onClientResumed(client *Client) {
+	r.mu.RLock()
+	callbacks := append([]func(*Client){}, r.onResumedCallbacks...)
+	r.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		callback(client)
+	}
+}
+
+// heldTrackList keeps the published tracks of a client after the transport is lost, the tracks are not ended while
+// the client can be resumed, so the subscribers keep receiving them once the resumed client takes them over.
+type heldTrackList struct {
+	mu     sync.Mutex
+	tracks []ITrack
+	// the held tracks that taken over by the resumed client, keyed by the ID of the remote track that took it over
+	taken    map[string]ITrack
+	released bool
+}
+
+// add holds the track, it returns false if the held tracks are already released
+func (l *heldTrackList) add(track ITrack) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.released {
+		return false
+	}
+
+	if !slices.Contains(l.tracks, track) {
+		l.tracks = append(l.tracks, track)
+	}
+
+	return true
+}
+
+// take returns the held track that the remote track takes over, true if the track is taken for the first time. The
+// held track with the same ID is matched first, or else the first held track with the same kind, codec, and
+// simulcast in the order they're published. The other layers of a simulcast track get the track that taken by the
+// first layer.
+func (l *heldTrackList) take(remoteTrack IRemoteTrack) (ITrack, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if track, ok := l.taken[remoteTrack.ID()]; ok {
+		return track, false
+	}
+
+	if l.released {
+		return nil, false
+	}
+
+	index := slices.IndexFunc(l.tracks, func(track ITrack) bool {
+		return track.ID() == remoteTrack.ID()
+	})
+
+	if index < 0 {
+		index = slices.IndexFunc(l.tracks, func(track ITrack) bool {
+			return track.Kind() == remoteTrack.Kind() &&
+				strings.EqualFold(track.MimeType(), remoteTrack.Codec().MimeType) &&
+				track.IsSimulcast() == (remoteTrack.RID() != "")
+		})
+	}
+
+	if index < 0 {
+		return nil, false
+	}
+
+	track := l.tracks[index]
+	l.tracks = slices.Delete(l.tracks, index, index+1)
+
+	if l.taken == nil {
+		l.taken = make(map[string]ITrack)
+	}
+
+	l.taken[remoteTrack.ID()] = track
+
+	return track, true
+}
+
+// release ends the held tracks that are not taken over, the tracks that ended after this are not held
+func (l *heldTrackList) release() {
+	l.mu.Lock()
+	tracks := l.tracks
+	l.tracks = nil
+	l.released = true
+	l.mu.Unlock()
+
+	for _, track := range tracks {
+		if held, ok := track.(interface{ end() }); ok {
+			held.end()
+		}
+	}
+}
+
+// holdTrack keeps the published track after its remote track is ended because the transport is lost, so the track
+// can be taken over by the resumed client. It returns false if the track must be ended.
+func (c *Client) holdTrack(track ITrack) bool {
+	if c.ReconnectToken() == "" || c.leaving.Load() || !c.isClosing() {
+		return false
+	}
+
+	if !c.heldTracks.add(track) {
+		return false
+	}
+
+	c.log.Infof("client: track %s is held until the client is resumed", track.ID())
+
+	return true
+}
+
+// isClosing returns true once the peer connection is closed, the remote tracks are ended with it
+func (c *Client) isClosing() bool {
+	return c.context.Err() != nil || c.peerConnection.PC().SignalingState() == webrtc.SignalingStateClosed
+}
+
+// releaseHeldTracks ends the held tracks that are not taken over by the resumed client
+func (c *Client) releaseHeldTracks() {
+	c.heldTracks.release()
+}
+
+// resumeTrack continues the held track of the previous client with the remote track that the resumed client
+// publishes, the subscribers of the held track keep their subscription. It returns false if the remote track doesn't
+// match a held track, it's published as a new track then.
+func (c *Client) resumeTrack(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver, onPLI func()) bool {
+	previous := c.resumedFrom.Load()
+	if previous == nil {
+		return false
+	}
+
+	held, first := previous.heldTracks.take(remoteTrack)
+	if held == nil {
+		return false
+	}
+
+	extensions := receiver.GetParameters().HeaderExtensions
+	c.checkHeaderExtensions(remoteTrack, extensions)
+
+	// the stats are kept with the ID of the held track
+	onStatsUpdated := func(stats *stats.Stats) {
+		c.stats.SetReceiver(held.ID(), remoteTrack.RID(), *stats)
+	}
+
+	held.OnEnded(func() {
+		c.stats.removeReceiverStats(held.ID() + remoteTrack.RID())
+	})
+
+	if first {
+		c.log.Infof("client: remote track %s takes over the held track %s", remoteTrack.ID(), held.ID())
+
+		if err := c.tracks.Add(held); err != nil {
+			c.log.Errorf("client: error add track ", err)
+		}
+
+		held.OnEnded(func() {
+			c.tracks.remove([]string{held.ID()})
+			c.trackDimensions.Delete(held.ID())
+
+			c.SFU().onTrackCatalogChanged()
+		})
+
+		c.countResumedTrack()
+	}
+
+	switch track := held.(type) {
+	case *SimulcastTrack:
+		track.base.resumedClient.Store(c)
+		track.SetHeaderExtensions(extensions)
+		track.addRemoteTrack(c, remoteTrack, c.options.JitterBufferMinWait, c.options.JitterBufferMaxWait, c.statsGetter, onStatsUpdated, onPLI)
+	case *AudioTrack:
+		track.base.resumedClient.Store(c)
+		track.SetHeaderExtensions(extensions)
+		track.takeOver(c, held, remoteTrack, onPLI, onStatsUpdated)
+
+		if c.options.EnableVoiceDetection {
+			// the voice activity of the previous client is not sent anymore
+			track.mu.Lock()
+			track.vadCallbacks = nil
+			track.mu.Unlock()
+
+			c.setTrackVAD(track)
+		}
+	case *Track:
+		track.base.resumedClient.Store(c)
+		track.SetHeaderExtensions(extensions)
+		track.takeOver(c, held, remoteTrack, onPLI, onStatsUpdated)
+	}
+
+	return true
+}
+
+// countResumedTrack excludes the track that taken over from the initial tracks of the offer, the new tracks of the
+// same offer are published once the other tracks are received
+func (c *Client) countResumedTrack() {
+	for {
+		count := c.initialReceiverCount.Load()
+		if count == 0 {
+			return
+		}
+
+		if !c.initialReceiverCount.CompareAndSwap(count, count-1) {
+			continue
+		}
+
+		// the new tracks were waiting for this track
+		pending := c.pendingPublishedTracks.GetTracks()
+		if int(count) > len(pending) && int(count-1) <= len(pending) && len(pending) > 0 && c.onTracksAdded != nil {
+			c.onTracksAdded(pending)
+		}
+
+		return
+	}
+}
+
+// takeOver continues the held track with the remote track of the resumed client, the packets are rewritten to
+// continue after the last packet of the held track. The held track is the AudioTrack for the audio.
+func (t *Track) takeOver(client *Client, held ITrack, trackRemote IRemoteTrack, onPLI func(), onStatsUpdated func(*stats.Stats)) {
+	captureClock := client.captureClock(trackRemote)
+
+	onNetworkConditionChanged := func(condition networkmonitor.NetworkConditionType) {
+		client.onNetworkConditionChanged(condition)
+	}
+
+	source := newRemoteTrack(client.Context(), logger.With(client.log, "track_id", t.ID()), client.options.ReorderPackets, trackRemote, client.options.JitterBufferMinWait, client.options.JitterBufferMaxWait, client.SFU().pliInterval, onPLI, client.statsGetter, onStatsUpdated, t.forwarder(client, captureClock), t.base.pool, onNetworkConditionChanged, client.fanOutScheduler(), t.remoteTrack.current())
+	source.captureClock = captureClock
+
+	source.OnEnded(func() {
+		t.sourceEnded(client, source, held)
+	})
+
+	// the keyframe for the subscribers to decode the new source
+	source.SendPLI()
+}
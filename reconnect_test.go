@@ -0,0 +1,379 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/rtppool"
+	"github.com/pion/ice/v4"
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newHeldTestTrack(id string, kind webrtc.RTPCodecType, mimeType string) *Track {
+	return &Track{base: &baseTrack{id: id, kind: kind, codec: getRTPParameters(mimeType)}}
+}
+
+func TestHeldTrackListTake(t *testing.T) {
+	audio := newHeldTestTrack("audio", webrtc.RTPCodecTypeAudio, webrtc.MimeTypeOpus)
+	camera := newHeldTestTrack("camera", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8)
+	screen := newHeldTestTrack("screen", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8)
+	simulcast := &SimulcastTrack{base: &baseTrack{id: "simulcast", kind: webrtc.RTPCodecTypeVideo, codec: getRTPParameters(webrtc.MimeTypeVP8)}}
+
+	list := &heldTrackList{}
+	for _, track := range []ITrack{audio, camera, screen, simulcast} {
+		require.True(t, list.add(track))
+	}
+
+	// the track is held once
+	require.True(t, list.add(camera))
+	require.Len(t, list.tracks, 4)
+
+	remote := func(id, rid string, kind webrtc.RTPCodecType, mimeType string) IRemoteTrack {
+		return NewTrackRelay(id, "stream", rid, kind, 1, mimeType, nil)
+	}
+
+	// the same ID is matched first
+	track, first := list.take(remote("screen", "", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8))
+	require.Equal(t, ITrack(screen), track)
+	require.True(t, first)
+
+	// the other tracks are matched by the kind, the codec, and the simulcast in the order they're published
+	track, _ = list.take(remote("new-video", "", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8))
+	require.Equal(t, ITrack(camera), track)
+
+	track, _ = list.take(remote("new-layer", "h", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8))
+	require.Equal(t, ITrack(simulcast), track)
+
+	// the other layers of the simulcast track get the same track
+	track, first = list.take(remote("new-layer", "q", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8))
+	require.Equal(t, ITrack(simulcast), track)
+	require.False(t, first)
+
+	track, _ = list.take(remote("new-video-2", "", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeH264))
+	require.Nil(t, track)
+
+	track, _ = list.take(remote("new-audio", "", webrtc.RTPCodecTypeAudio, webrtc.MimeTypeOpus))
+	require.Equal(t, ITrack(audio), track)
+
+	require.Empty(t, list.tracks)
+}
+
+func TestHeldTrackListRelease(t *testing.T) {
+	ended := make(chan string, 2)
+
+	newTrack := func(id string) *Track {
+		track := newHeldTestTrack(id, webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8)
+		_, track.cancel = context.WithCancel(context.Background())
+		track.OnEnded(func() {
+			ended <- id
+		})
+
+		return track
+	}
+
+	taken := newTrack("taken")
+	held := newTrack("held")
+
+	list := &heldTrackList{}
+	require.True(t, list.add(taken))
+	require.True(t, list.add(held))
+
+	track, _ := list.take(NewTrackRelay("taken", "stream", "", webrtc.RTPCodecTypeVideo, 1, webrtc.MimeTypeVP8, nil))
+	require.Equal(t, ITrack(taken), track)
+
+	// only the track that not taken over is ended
+	list.release()
+	require.Equal(t, "held", <-ended)
+	require.Empty(t, ended)
+
+	// the tracks that ended after the release are not held
+	require.False(t, list.add(newTrack("late")))
+}
+
+func TestRemoteTrackContinuation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := logging.NewDefaultLoggerFactory().NewLogger("test")
+
+	received := make(chan rtp.Header, 10)
+	onRead := func(_ interceptor.Attributes, p *rtp.Packet) {
+		received <- p.Header
+	}
+
+	newTestRemoteTrack := func(packets chan *rtp.Packet, plis *atomic.Int32, previous *remoteTrack) *remoteTrack {
+		track := NewTrackRelay("video", "stream", "", webrtc.RTPCodecTypeVideo, 1234, webrtc.MimeTypeVP8, packets)
+		return newRemoteTrack(ctx, log, false, track, 0, 0, 0, func() { plis.Add(1) }, nil, nil, onRead, rtppool.New(), nil, nil, previous)
+	}
+
+	receive := func() rtp.Header {
+		select {
+		case header := <-received:
+			return header
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the packet")
+			return rtp.Header{}
+		}
+	}
+
+	send := func(packets chan *rtp.Packet, seq uint16, ts uint32) {
+		packets <- &rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: seq, Timestamp: ts}, Payload: []byte{1}}
+	}
+
+	var firstPLIs, secondPLIs atomic.Int32
+
+	firstPackets := make(chan *rtp.Packet, 10)
+	first := newTestRemoteTrack(firstPackets, &firstPLIs, nil)
+
+	ended := make(chan struct{})
+	first.OnEnded(func() {
+		close(ended)
+	})
+
+	for seq := uint16(1000); seq < 1003; seq++ {
+		send(firstPackets, seq, uint32(seq)*3000)
+		require.Equal(t, seq, receive().SequenceNumber)
+	}
+
+	close(firstPackets)
+	<-ended
+
+	secondPackets := make(chan *rtp.Packet, 10)
+	second := newTestRemoteTrack(secondPackets, &secondPLIs, first)
+
+	require.True(t, first.takenOver())
+	require.Equal(t, second, first.current())
+
+	// the keyframe request to the previous remote track is sent to the remote track that continues it
+	first.SendPLI()
+	require.Eventually(t, func() bool {
+		return secondPLIs.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Zero(t, firstPLIs.Load())
+
+	// the packets of the new source continue after the last packet
+	lastTS := uint32(1002) * 3000
+	for seq := uint16(7); seq < 10; seq++ {
+		send(secondPackets, seq, 500000+uint32(seq)*3000)
+
+		header := receive()
+		require.Equal(t, uint16(1003+seq-7), header.SequenceNumber)
+		require.Greater(t, header.Timestamp, lastTS)
+
+		lastTS = header.Timestamp
+	}
+
+	close(secondPackets)
+}
+
+// connectResumablePublisher connects a peer that publishes an audio and a video track to the client, the client is
+// not stopped when the peer is closed
+func connectResumablePublisher(t *testing.T, ctx context.Context, client *Client) *webrtc.PeerConnection {
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	settingEngine.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	settingEngine.SetIncludeLoopbackCandidate(true)
+
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(GetMediaEngine()), webrtc.WithSettingEngine(settingEngine))
+
+	pc, err := api.NewPeerConnection(webrtc.Configuration{ICEServers: DefaultTestIceServers()})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { pc.Close() })
+
+	iceConnectedCtx, iceConnected := context.WithCancel(ctx)
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		if state == webrtc.ICEConnectionStateConnected {
+			iceConnected()
+		}
+	})
+
+	tracks, _ := GetStaticTracks(ctx, iceConnectedCtx, client.ID(), true)
+	SetPeerConnectionTracks(ctx, pc, tracks)
+
+	client.OnTracksAdded(func(addedTracks []ITrack) {
+		sources := make(map[string]TrackType)
+		for _, track := range addedTracks {
+			sources[track.ID()] = TrackTypeMedia
+		}
+
+		client.SetTracksSourceType(sources)
+	})
+
+	client.OnIceCandidate(func(ctx context.Context, candidate *webrtc.ICECandidate) {
+		if candidate != nil {
+			_ = pc.AddICECandidate(candidate.ToJSON())
+		}
+	})
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate != nil {
+			_ = client.PeerConnection().PC().AddICECandidate(candidate.ToJSON())
+		}
+	})
+
+	client.OnRenegotiation(func(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+		if err := pc.SetRemoteDescription(offer); err != nil {
+			return webrtc.SessionDescription{}, err
+		}
+
+		answer, err := pc.CreateAnswer(nil)
+		if err != nil {
+			return webrtc.SessionDescription{}, err
+		}
+
+		if err := pc.SetLocalDescription(answer); err != nil {
+			return webrtc.SessionDescription{}, err
+		}
+
+		return *pc.LocalDescription(), nil
+	})
+
+	client.OnAllowedRemoteRenegotiation(func() {
+		negotiate(pc, client, TestLogger, true)
+	})
+
+	negotiate(pc, client, TestLogger, true)
+
+	return pc
+}
+
+func trackIDs(tracks []ITrack) []string {
+	ids := make([]string, 0, len(tracks))
+	for _, track := range tracks {
+		ids = append(ids, track.ID())
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+func TestRoomResumeClientHeldTracks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	gracePeriod := 10 * time.Second
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	roomOpts.ReconnectGracePeriod = &gracePeriod
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	publisher, err := testRoom.AddClient("publisher", "publisher", DefaultClientOptions())
+	require.NoError(t, err)
+
+	peerCtx, cancelPeer := context.WithCancel(ctx)
+	defer cancelPeer()
+
+	publisherPC := connectResumablePublisher(t, peerCtx, publisher)
+
+	require.Eventually(t, func() bool {
+		return len(publisher.Tracks()) == 2
+	}, 30*time.Second, 100*time.Millisecond)
+
+	heldIDs := trackIDs(publisher.Tracks())
+
+	subscriberPC, subscriber, statsGetter, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "subscriber", true, false, true)
+
+	received := func() map[webrtc.SSRC]uint64 {
+		packets := make(map[webrtc.SSRC]uint64)
+		for ssrc, stat := range GetReceiverStats(subscriberPC.PeerConnection, statsGetter) {
+			packets[ssrc] = stat.InboundRTPStreamStats.PacketsReceived
+		}
+
+		return packets
+	}
+
+	require.Eventually(t, func() bool {
+		packets := received()
+		if len(packets) != 2 {
+			return false
+		}
+
+		for _, count := range packets {
+			if count == 0 {
+				return false
+			}
+		}
+
+		return true
+	}, 30*time.Second, 100*time.Millisecond)
+
+	clientTracks := subscriber.ClientTracks()
+	require.Len(t, clientTracks, 2)
+
+	// the transport of the publisher is lost
+	cancelPeer()
+	require.NoError(t, publisherPC.Close())
+
+	require.Eventually(t, func() bool {
+		_, err := testRoom.SFU().GetClient(publisher.ID())
+		return errors.Is(err, ErrClientNotFound)
+	}, 10*time.Second, 50*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		publisher.heldTracks.mu.Lock()
+		defer publisher.heldTracks.mu.Unlock()
+
+		return len(publisher.heldTracks.tracks) == 2
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// the subscriber keeps the held tracks
+	require.Equal(t, clientTracks, subscriber.ClientTracks())
+
+	resumed, err := testRoom.ResumeClient(publisher.ReconnectToken(), DefaultClientOptions())
+	require.NoError(t, err)
+
+	// the new tracks of the resumed client have different IDs, they take over the held tracks
+	_ = connectResumablePublisher(t, ctx, resumed)
+
+	require.Eventually(t, func() bool {
+		ids := trackIDs(resumed.Tracks())
+		return len(ids) == 2 && ids[0] == heldIDs[0] && ids[1] == heldIDs[1]
+	}, 30*time.Second, 100*time.Millisecond)
+
+	for _, track := range resumed.Tracks() {
+		require.Equal(t, resumed.ID(), track.ClientID())
+
+		if track.Kind() == webrtc.RTPCodecTypeAudio {
+			require.IsType(t, &AudioTrack{}, track)
+		}
+	}
+
+	// the subscriber receives the resumed tracks on the same senders
+	before := received()
+	require.Eventually(t, func() bool {
+		after := received()
+		if len(after) != 2 {
+			return false
+		}
+
+		for ssrc, count := range after {
+			if count < before[ssrc]+50 {
+				return false
+			}
+		}
+
+		return true
+	}, 30*time.Second, 100*time.Millisecond)
+
+	require.Equal(t, clientTracks, subscriber.ClientTracks())
+
+	require.NoError(t, testRoom.StopClient(resumed.ID()))
+	require.NoError(t, testRoom.StopClient(subscriber.ID()))
+}
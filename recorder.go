@@ -0,0 +1,819 @@
+package sfu
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/interceptors/voiceactivedetector"
+	"github.com/inlivedev/sfu/pkg/storage"
+	"github.com/inlivedev/sfu/pkg/webmwriter"
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	videoClockRate = 90000
+	audioClockRate = 48000
+
+	// number of packets can be buffered before the packets dropped when the disk is slow
+	recorderPacketBufferSize = 512
+
+	// the longest DTX silent period that filled with the empty frames, the longer gap is a timestamp jump
+	recorderMaxDTXGap = 10 * audioClockRate
+)
+
+var (
+	ErrRecordingAlreadyStarted   = errors.New("recorder: recording is already started")
+	ErrRecordingNotStarted       = errors.New("recorder: recording is not started")
+	ErrRecordingUnsupportedCodec = errors.New("recorder: codec is not supported")
+)
+
+type RecordingOptions struct {
+	// Directory where the recording files are written, it will be created if not exists
+	Directory string `json:"directory"`
+	// Storage uploads every file once it's closed, nil to keep the files only in the directory
+	Storage storage.Storage `json:"-"`
+	// KeyPrefix is prepended to the file name as the object key, for example "recordings/2024/"
+	KeyPrefix string `json:"key_prefix"`
+	// DeleteLocalFiles removes the file from the directory after it's uploaded. The compositor can't be used with the
+	// deleted files, so keep it disabled if the recording will be composed on the server.
+	DeleteLocalFiles bool `json:"delete_local_files"`
+}
+
+func DefaultRecordingOptions() RecordingOptions {
+	return RecordingOptions{
+		Directory: "recordings",
+	}
+}
+
+// Recorder writes every track in a room into a separate file in the recording directory.
+// Opus and VP8/VP9 tracks are written as WebM, and H264 tracks are written as MKV.
+// All files are aligned to the recording start time, so they can be muxed together later by the file timestamps.
+// The file name format is {roomID}_{clientID}_{trackID}_{startTimeMs}.{webm|mkv}. When a track ended, the file is closed,
+// and a new file is created when the client publish a new track.
+type Recorder struct {
+	mu             sync.Mutex
+	context        context.Context
+	cancel         context.CancelFunc
+	room           *Room
+	options        RecordingOptions
+	startTime      time.Time
+	duration       time.Duration
+	tracks         map[string]*trackRecorder
+	recordedTracks []*RecordedTrack
+	voiceEvents    []voiceEvent
+	uploads        sync.WaitGroup
+	onUploaded     func(track RecordedTrack, err error)
+	log            logging.LeveledLogger
+}
+
+// RecordedTrack is a track file that written by the recorder, the start and end are the durations from the recording start
+type RecordedTrack struct {
+	ClientID string              `json:"client_id"`
+	TrackID  string              `json:"track_id"`
+	Kind     webrtc.RTPCodecType `json:"kind"`
+	Path     string              `json:"path"`
+	Start    time.Duration       `json:"start"`
+	// End is zero while the track is still recorded
+	End time.Duration `json:"end"`
+	// Object is the uploaded file when the recording storage is set, nil until the upload is succeeded
+	Object *storage.Object `json:"object,omitempty"`
+}
+
+// voiceEvent is recorded when a client start or stop speaking, it's used to find the active speaker in the composite recording
+type voiceEvent struct {
+	clientID string
+	at       time.Duration
+	speaking bool
+}
+
+func newRecorder(room *Room, opts RecordingOptions) (*Recorder, error) {
+	if err := os.MkdirAll(opts.Directory, 0o755); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(room.context)
+
+	return &Recorder{
+		context:        ctx,
+		cancel:         cancel,
+		room:           room,
+		options:        opts,
+		startTime:      time.Now(),
+		tracks:         make(map[string]*trackRecorder),
+		recordedTracks: make([]*RecordedTrack, 0),
+		voiceEvents:    make([]voiceEvent, 0),
+		log:            room.sfu.log,
+	}, nil
+}
+
+// StartTime returns the time when the recording started, all track files are aligned to this time
+func (r *Recorder) StartTime() time.Time {
+	return r.startTime
+}
+
+// Files returns the path of all files that created by the recorder
+func (r *Recorder) Files() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files := make([]string, 0, len(r.recordedTracks))
+	for _, track := range r.recordedTracks {
+		files = append(files, track.Path)
+	}
+
+	return files
+}
+
+// RecordedTracks returns all tracks that recorded, including the tracks that already ended
+func (r *Recorder) RecordedTracks() []RecordedTrack {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tracks := make([]RecordedTrack, 0, len(r.recordedTracks))
+	for _, track := range r.recordedTracks {
+		tracks = append(tracks, *track)
+	}
+
+	return tracks
+}
+
+// Duration returns the duration of the recording, it's zero while the recording is still running
+func (r *Recorder) Duration() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.duration
+}
+
+// OnUploaded is called when a file is uploaded to the recording storage, err is not nil if the upload is failed after
+// all retries and the file is kept in the directory
+func (r *Recorder) OnUploaded(callback func(track RecordedTrack, err error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onUploaded = callback
+}
+
+// WaitUploads blocks until all closed files are uploaded to the recording storage, or the context is done
+func (r *Recorder) WaitUploads(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		r.uploads.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// upload uploads the closed file of the recorded track in the background, the recorder context is not used because
+// the files are closed after the recording is stopped
+func (r *Recorder) upload(recordedTrack *RecordedTrack) {
+	if r.options.Storage == nil {
+		return
+	}
+
+	r.uploads.Add(1)
+
+	go func() {
+		defer r.uploads.Done()
+
+		object, err := r.uploadFile(recordedTrack.Path)
+
+		r.mu.Lock()
+		if err == nil {
+			recordedTrack.Object = &object
+		}
+		track := *recordedTrack
+		callback := r.onUploaded
+		r.mu.Unlock()
+
+		if err != nil {
+			r.log.Errorf("recorder: failed to upload file %s: %s", track.Path, err.Error())
+		} else if r.options.DeleteLocalFiles {
+			if err := os.Remove(track.Path); err != nil {
+				r.log.Warnf("recorder: failed to delete uploaded file %s: %s", track.Path, err.Error())
+			}
+		}
+
+		if callback != nil {
+			callback(track, err)
+		}
+	}()
+}
+
+func (r *Recorder) uploadFile(path string) (storage.Object, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return storage.Object{}, err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return storage.Object{}, err
+	}
+
+	return r.options.Storage.Put(context.Background(), r.options.KeyPrefix+filepath.Base(path), file, info.Size())
+}
+
+func (r *Recorder) isStopped() bool {
+	return r.context.Err() != nil
+}
+
+func (r *Recorder) onVoiceDetected(clientID string, speaking bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.isStopped() {
+		return
+	}
+
+	r.voiceEvents = append(r.voiceEvents, voiceEvent{
+		clientID: clientID,
+		at:       time.Since(r.startTime),
+		speaking: speaking,
+	})
+}
+
+func (r *Recorder) addTracks(tracks []ITrack) {
+	for _, track := range tracks {
+		if err := r.addTrack(track); err != nil {
+			r.log.Warnf("recorder: failed to record track %s: %s", track.ID(), err.Error())
+		}
+	}
+}
+
+func (r *Recorder) addTrack(track ITrack) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.isStopped() {
+		return ErrRecordingNotStarted
+	}
+
+	key := track.ClientID() + "/" + track.ID()
+	if _, ok := r.tracks[key]; ok {
+		return nil
+	}
+
+	if track.IsE2EE() {
+		return ErrE2EENotSupported
+	}
+
+	tr, err := newTrackRecorder(r, track)
+	if err != nil {
+		return err
+	}
+
+	recordedTrack := &RecordedTrack{
+		ClientID: track.ClientID(),
+		TrackID:  track.ID(),
+		Kind:     track.Kind(),
+		Path:     tr.filePath,
+		Start:    time.Since(r.startTime),
+	}
+
+	tr.recorded = recordedTrack
+	r.tracks[key] = tr
+	r.recordedTracks = append(r.recordedTracks, recordedTrack)
+
+	if buffer := r.room.recordingBuffer(track); buffer != nil {
+		// the buffer passes the packets to the recorder, so no packet is lost or duplicated between them
+		buffer.attach(tr)
+
+		if len(tr.buffered) > 0 {
+			recordedTrack.Start = tr.buffered[0].at.Sub(r.startTime)
+		}
+	} else {
+		tr.unregister = track.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, quality QualityLevel) {
+			// only record the highest simulcast layer
+			if track.IsSimulcast() && quality != QualityHigh {
+				return
+			}
+
+			tr.push(p)
+		})
+	}
+
+	if audioTrack, ok := track.(*AudioTrack); ok {
+		// the callback is called with nil packets when the voice is stopped
+		audioTrack.OnVoiceDetected(func(pkts []voiceactivedetector.VoicePacketData) {
+			if pkts == nil {
+				r.onVoiceDetected(track.ClientID(), false)
+			} else if len(pkts) > 0 {
+				r.onVoiceDetected(track.ClientID(), true)
+			}
+		})
+	}
+
+	// rotate the file when the track ended
+	track.OnEnded(func() {
+		r.mu.Lock()
+		if r.tracks[key] == tr {
+			delete(r.tracks, key)
+		}
+
+		if recordedTrack.End == 0 {
+			recordedTrack.End = time.Since(r.startTime)
+		}
+		r.mu.Unlock()
+
+		tr.stop()
+	})
+
+	go tr.run()
+
+	return nil
+}
+
+func (r *Recorder) stop() {
+	r.mu.Lock()
+	r.cancel()
+
+	r.duration = time.Since(r.startTime)
+	for _, track := range r.recordedTracks {
+		if track.End == 0 {
+			track.End = r.duration
+		}
+	}
+
+	tracks := make([]*trackRecorder, 0, len(r.tracks))
+	for key, tr := range r.tracks {
+		tracks = append(tracks, tr)
+		delete(r.tracks, key)
+	}
+	r.mu.Unlock()
+
+	for _, tr := range tracks {
+		tr.stop()
+	}
+}
+
+type trackRecorder struct {
+	context      context.Context
+	cancel       context.CancelFunc
+	done         chan bool
+	recorder     *Recorder
+	track        ITrack
+	mimeType     string
+	clockRate    uint32
+	filePath     string
+	recorded     *RecordedTrack
+	writer       *webmwriter.Writer
+	packets      chan *rtp.Packet
+	stopOnce     sync.Once
+	log          logging.LeveledLogger
+	started      bool
+	offset       time.Duration
+	lastTS       uint32
+	elapsedTS    int64
+	lastSeq      uint16
+	hasSeq       bool
+	frame        []*rtp.Packet
+	waitKeyframe bool
+	lastAudioTS  uint32
+	lastTOC      byte
+	lastDTX      bool
+	// the packets from the recording buffer that written before the packets from the channel
+	buffered []bufferedPacket
+	arrival  time.Time
+	// unregisters the read callback of the track, nil when the packets are passed by the recording buffer
+	unregister func()
+}
+
+func newTrackRecorder(r *Recorder, track ITrack) (*trackRecorder, error) {
+	mimeType := strings.ToLower(track.MimeType())
+
+	entry := webmwriter.TrackEntry{
+		TrackNumber: 1,
+	}
+
+	docType := webmwriter.DocTypeWebM
+	ext := ".webm"
+	clockRate := uint32(videoClockRate)
+
+	switch mimeType {
+	case strings.ToLower(webrtc.MimeTypeOpus), "audio/red":
+		entry.TrackType = webmwriter.TrackTypeAudio
+		entry.CodecID = webmwriter.CodecOpus
+		entry.CodecPrivate = webmwriter.OpusHead(2, audioClockRate)
+		entry.SamplingFrequency = audioClockRate
+		entry.Channels = 2
+		clockRate = audioClockRate
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		entry.TrackType = webmwriter.TrackTypeVideo
+		entry.CodecID = webmwriter.CodecVP8
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		entry.TrackType = webmwriter.TrackTypeVideo
+		entry.CodecID = webmwriter.CodecVP9
+	case strings.ToLower(webrtc.MimeTypeH264):
+		entry.TrackType = webmwriter.TrackTypeVideo
+		entry.CodecID = webmwriter.CodecH264
+		docType = webmwriter.DocTypeMatroska
+		ext = ".mkv"
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrRecordingUnsupportedCodec, track.MimeType())
+	}
+
+	name := fmt.Sprintf("%s_%s_%s_%d%s", r.room.ID(), track.ClientID(), track.ID(), time.Now().UnixMilli(), ext)
+	filePath := filepath.Join(r.options.Directory, sanitizeFileName(name))
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(r.context)
+
+	return &trackRecorder{
+		context:      ctx,
+		cancel:       cancel,
+		done:         make(chan bool),
+		recorder:     r,
+		track:        track,
+		mimeType:     mimeType,
+		clockRate:    clockRate,
+		filePath:     filePath,
+		writer:       webmwriter.New(file, docType, []webmwriter.TrackEntry{entry}),
+		packets:      make(chan *rtp.Packet, recorderPacketBufferSize),
+		log:          r.log,
+		waitKeyframe: track.Kind() == webrtc.RTPCodecTypeVideo,
+	}, nil
+}
+
+// push is called from the track read loop, the packet is copied because it will be returned to the pool
+func (t *trackRecorder) push(p *rtp.Packet) {
+	if t.context.Err() != nil {
+		return
+	}
+
+	select {
+	case t.packets <- p.Clone():
+	default:
+		t.log.Warnf("recorder: packet buffer is full, dropping packet of track %s", t.track.ID())
+	}
+}
+
+func (t *trackRecorder) run() {
+	defer close(t.done)
+
+	for _, bp := range t.buffered {
+		if t.context.Err() != nil {
+			return
+		}
+
+		t.arrival = bp.at
+		if err := t.writePacket(bp.packet); err != nil {
+			t.log.Errorf("recorder: failed to write track %s: %s", t.track.ID(), err.Error())
+			return
+		}
+	}
+
+	t.buffered = nil
+	t.arrival = time.Time{}
+
+	// the buffer starts from a keyframe, it's only requested when the buffer is empty or has no keyframe
+	if t.waitKeyframe {
+		requestKeyframe(t.track)
+	}
+
+	for {
+		select {
+		case <-t.context.Done():
+			return
+		case p := <-t.packets:
+			if err := t.writePacket(p); err != nil {
+				t.log.Errorf("recorder: failed to write track %s: %s", t.track.ID(), err.Error())
+				return
+			}
+		}
+	}
+}
+
+func (t *trackRecorder) stop() {
+	t.stopOnce.Do(func() {
+		// the stopped recorder doesn't keep the track from being paused
+		if t.unregister != nil {
+			t.unregister()
+		}
+
+		t.cancel()
+		<-t.done
+
+		if err := t.writer.Close(); err != nil {
+			t.log.Errorf("recorder: failed to close file %s: %s", t.filePath, err.Error())
+			return
+		}
+
+		if t.recorded != nil {
+			t.recorder.upload(t.recorded)
+		}
+	})
+}
+
+func (t *trackRecorder) writePacket(p *rtp.Packet) error {
+	if t.hasSeq && p.SequenceNumber != t.lastSeq+1 {
+		// the frame is broken when a packet is lost, wait for the next keyframe to continue
+		t.frame = t.frame[:0]
+
+		if t.track.Kind() == webrtc.RTPCodecTypeVideo && !t.waitKeyframe {
+			t.waitKeyframe = true
+			requestKeyframe(t.track)
+		}
+	}
+
+	t.hasSeq = true
+	t.lastSeq = p.SequenceNumber
+
+	if t.track.Kind() == webrtc.RTPCodecTypeAudio {
+		payload := p.Payload
+		if p.PayloadType == 63 || t.mimeType == "audio/red" {
+			primary, err := extractPrimaryEncodingForRED(payload)
+			if err != nil {
+				return nil
+			}
+
+			payload = primary
+		}
+
+		if len(payload) == 0 {
+			return nil
+		}
+
+		return t.writeAudioFrame(p.Timestamp, payload)
+	}
+
+	if len(t.frame) > 0 && t.frame[0].Timestamp != p.Timestamp {
+		// the marker of the previous frame is lost
+		if err := t.writeFrame(); err != nil {
+			return err
+		}
+	}
+
+	t.frame = append(t.frame, p)
+
+	if p.Marker {
+		return t.writeFrame()
+	}
+
+	return nil
+}
+
+func (t *trackRecorder) writeAudioFrame(ts uint32, payload []byte) error {
+	dtx := voiceactivedetector.IsOpusDTX(payload)
+
+	// the sender only sends a DTX packet every 400ms in the silent period, the gap is filled with the empty frames
+	// because some players ignore the block timestamps and play the audio frames back to back
+	if t.started && (dtx || t.lastDTX) {
+		for _, fillTS := range opusDTXFill(t.lastAudioTS, ts, t.lastTOC) {
+			if err := t.writer.WriteFrame(1, true, t.timestamp(fillTS), []byte{t.lastTOC &^ 0x03}); err != nil {
+				return err
+			}
+		}
+	}
+
+	t.lastAudioTS = ts
+	t.lastTOC = payload[0]
+	t.lastDTX = dtx
+
+	return t.writer.WriteFrame(1, true, t.timestamp(ts), payload)
+}
+
+// opusDTXFill returns the timestamps of the empty frames between two Opus packets, the frame duration is from the TOC byte
+func opusDTXFill(lastTS, ts uint32, toc byte) []uint32 {
+	gap := ts - lastTS
+	if int32(gap) <= 0 || gap > recorderMaxDTXGap {
+		return nil
+	}
+
+	samples := opusFrameSamples(toc)
+
+	timestamps := make([]uint32, 0, gap/samples)
+	for fillTS := lastTS + samples; int32(ts-fillTS) > 0; fillTS += samples {
+		timestamps = append(timestamps, fillTS)
+	}
+
+	return timestamps
+}
+
+// opusFrameSamples returns the number of the 48kHz samples in a frame of the TOC configuration, RFC 6716 section 3.1
+func opusFrameSamples(toc byte) uint32 {
+	config := toc >> 3
+
+	switch {
+	case config < 12:
+		// SILK 10, 20, 40 and 60ms
+		return []uint32{480, 960, 1920, 2880}[config%4]
+	case config < 16:
+		// hybrid 10 and 20ms
+		return []uint32{480, 960}[config%2]
+	default:
+		// CELT 2.5, 5, 10 and 20ms
+		return []uint32{120, 240, 480, 960}[config%4]
+	}
+}
+
+func (t *trackRecorder) writeFrame() error {
+	packets := t.frame
+	t.frame = t.frame[:0]
+
+	if len(packets) == 0 {
+		return nil
+	}
+
+	keyframe := IsKeyframe(t.mimeType, packets[0].Payload)
+	if t.waitKeyframe && !keyframe {
+		return nil
+	}
+
+	data, err := depacketizeFrame(t.mimeType, packets)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+
+	if t.waitKeyframe {
+		t.waitKeyframe = false
+
+		// update the header while it's not written yet
+		if width, height := KeyframeDimensions(t.mimeType, packets[0].Payload); width > 0 && height > 0 {
+			_ = t.writer.SetVideoSize(1, width, height)
+		}
+
+		if t.mimeType == strings.ToLower(webrtc.MimeTypeH264) {
+			if avcc := h264DecoderConfig(data); avcc != nil {
+				_ = t.writer.SetCodecPrivate(1, avcc)
+			}
+		}
+	}
+
+	return t.writer.WriteFrame(1, keyframe, t.timestamp(packets[0].Timestamp), data)
+}
+
+// depacketizeFrame returns the frame of the RTP packets, H264 is returned as the AVC format with the length prefixes
+func depacketizeFrame(mimeType string, packets []*rtp.Packet) ([]byte, error) {
+	data := make([]byte, 0)
+
+	switch mimeType {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		for _, p := range packets {
+			vp8 := &codecs.VP8Packet{}
+			payload, err := vp8.Unmarshal(p.Payload)
+			if err != nil {
+				return nil, err
+			}
+
+			data = append(data, payload...)
+		}
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		// each spatial layer is a separate frame, they're combined into a superframe
+		frames := make([][]byte, 0)
+		for _, p := range packets {
+			vp9 := &codecs.VP9Packet{}
+			payload, err := vp9.Unmarshal(p.Payload)
+			if err != nil {
+				return nil, err
+			}
+
+			if vp9.B || len(frames) == 0 {
+				frames = append(frames, make([]byte, 0))
+			}
+
+			frames[len(frames)-1] = append(frames[len(frames)-1], payload...)
+		}
+
+		data = vp9Superframe(frames)
+	case strings.ToLower(webrtc.MimeTypeH264):
+		h264 := &codecs.H264Packet{IsAVC: true}
+		for _, p := range packets {
+			payload, err := h264.Unmarshal(p.Payload)
+			if err != nil {
+				return nil, err
+			}
+
+			data = append(data, payload...)
+		}
+	}
+
+	return data, nil
+}
+
+// timestamp converts the RTP timestamp to the duration from the recording start
+func (t *trackRecorder) timestamp(ts uint32) time.Duration {
+	if !t.started {
+		t.started = true
+		t.lastTS = ts
+		t.offset = time.Since(t.recorder.startTime)
+
+		// the buffered packet is written at the time it's received
+		if !t.arrival.IsZero() {
+			t.offset = t.arrival.Sub(t.recorder.startTime)
+		}
+	}
+
+	// the signed difference handles the timestamp wraparound
+	t.elapsedTS += int64(int32(ts - t.lastTS))
+	t.lastTS = ts
+
+	return t.offset + time.Duration(t.elapsedTS)*time.Second/time.Duration(t.clockRate)
+}
+
+func requestKeyframe(track ITrack) {
+	switch t := track.(type) {
+	case *Track:
+		t.remoteTrack.SendPLI()
+	case *SimulcastTrack:
+		t.sendPLI()
+	}
+}
+
+// vp9Superframe combines the frames of the spatial layers with the superframe index
+// https://storage.googleapis.com/downloads.webmproject.org/docs/vp9/vp9-bitstream-specification-v0.6-20160331-draft.pdf Annex B
+func vp9Superframe(frames [][]byte) []byte {
+	if len(frames) == 1 {
+		return frames[0]
+	}
+
+	if len(frames) > 8 {
+		frames = frames[:8]
+	}
+
+	data := make([]byte, 0)
+	for _, frame := range frames {
+		data = append(data, frame...)
+	}
+
+	// 4 bytes frame size is always enough
+	marker := byte(0xc0) | byte(3<<3) | byte(len(frames)-1)
+
+	data = append(data, marker)
+	for _, frame := range frames {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(frame)))
+	}
+
+	return append(data, marker)
+}
+
+// h264DecoderConfig builds the AVCDecoderConfigurationRecord from the SPS and PPS in the length prefixed frame
+func h264DecoderConfig(frame []byte) []byte {
+	var sps, pps []byte
+
+	for len(frame) > 4 {
+		size := int(binary.BigEndian.Uint32(frame))
+		frame = frame[4:]
+
+		if size > len(frame) || size == 0 {
+			break
+		}
+
+		nalu := frame[:size]
+		frame = frame[size:]
+
+		switch nalu[0] & 0x1F {
+		case 7:
+			sps = nalu
+		case 8:
+			pps = nalu
+		}
+	}
+
+	if len(sps) < 4 || len(pps) == 0 {
+		return nil
+	}
+
+	config := []byte{1, sps[1], sps[2], sps[3], 0xFF, 0xE1}
+	config = binary.BigEndian.AppendUint16(config, uint16(len(sps)))
+	config = append(config, sps...)
+	config = append(config, 1)
+	config = binary.BigEndian.AppendUint16(config, uint16(len(pps)))
+
+	return append(config, pps...)
+}
+
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '_' || r == '-' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+
+		return '-'
+	}, name)
+}
@@ -0,0 +1,230 @@
+package sfu
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/interceptors/voiceactivedetector"
+	"github.com/inlivedev/sfu/pkg/storage"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoomRecording(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomID := roomManager.CreateRoomID()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	testRoom, err := roomManager.NewRoom(roomID, "test-recording-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	uploadDir := t.TempDir()
+
+	recorder, err := testRoom.StartRecording(RecordingOptions{
+		Directory: t.TempDir(),
+		Storage:   storage.NewLocal(uploadDir),
+		KeyPrefix: "uploads/",
+	})
+	require.NoError(t, err)
+
+	_, err = testRoom.StartRecording(DefaultRecordingOptions())
+	require.ErrorIs(t, err, ErrRecordingAlreadyStarted)
+
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 20*time.Second)
+	defer cancelTimeout()
+
+	// wait until both tracks are recorded for a while
+	for len(recorder.Files()) < 2 {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for recorded tracks")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	time.Sleep(3 * time.Second)
+
+	require.NoError(t, testRoom.StopRecording())
+	require.ErrorIs(t, testRoom.StopRecording(), ErrRecordingNotStarted)
+
+	// the stopped recorder doesn't read the video track anymore
+	for _, track := range publisher.Tracks() {
+		if video, ok := track.(*Track); ok {
+			video.mu.Lock()
+			require.Empty(t, video.onReadCallbacks)
+			video.mu.Unlock()
+		}
+	}
+
+	extensions := make(map[string]bool)
+
+	for _, file := range recorder.Files() {
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		require.Greater(t, info.Size(), int64(1000), file)
+
+		extensions[filepath.Ext(file)] = true
+	}
+
+	require.True(t, extensions[".webm"])
+	require.True(t, extensions[".mkv"])
+
+	uploadCtx, cancelUpload := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelUpload()
+
+	require.NoError(t, recorder.WaitUploads(uploadCtx))
+
+	for _, track := range recorder.RecordedTracks() {
+		require.NotNil(t, track.Object, track.Path)
+		require.Equal(t, "uploads/"+filepath.Base(track.Path), track.Object.Key)
+
+		_, err := os.Stat(filepath.Join(uploadDir, "uploads", filepath.Base(track.Path)))
+		require.NoError(t, err)
+	}
+
+	_ = testRoom.StopClient(publisher.ID())
+}
+
+func TestRecorderDTXFill(t *testing.T) {
+	// TOC byte of 20ms SILK wideband, and 10ms CELT fullband
+	const (
+		silk20ms = byte(9 << 3)
+		celt10ms = byte(30 << 3)
+	)
+
+	require.True(t, voiceactivedetector.IsOpusDTX([]byte{silk20ms}))
+	require.False(t, voiceactivedetector.IsOpusDTX([]byte{}))
+	require.False(t, voiceactivedetector.IsOpusDTX([]byte{silk20ms, 0x01, 0x02}))
+
+	require.Equal(t, uint32(960), opusFrameSamples(silk20ms))
+	require.Equal(t, uint32(480), opusFrameSamples(celt10ms))
+
+	// the DTX packet 400ms after the last frame
+	fill := opusDTXFill(1000, 1000+20*960, silk20ms)
+	require.Len(t, fill, 19)
+	require.Equal(t, uint32(1000+960), fill[0])
+	require.Equal(t, uint32(1000+19*960), fill[18])
+
+	// the wraparound
+	require.Len(t, opusDTXFill(math.MaxUint32-959, 960*2, silk20ms), 2)
+
+	// the consecutive frames, the reordered packet, and the timestamp jump are not filled
+	require.Empty(t, opusDTXFill(1000, 1960, silk20ms))
+	require.Empty(t, opusDTXFill(1960, 1000, silk20ms))
+	require.Empty(t, opusDTXFill(1000, 1000+recorderMaxDTXGap+1, silk20ms))
+}
+
+func TestRoomRecordingBuffer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	bufferDuration := 2 * time.Second
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.RecordingBuffer = &bufferDuration
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-recording-buffer", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	// wait until the buffers are filled
+	require.Eventually(t, func() bool {
+		start := testRoom.recordingBufferStart()
+		return len(publisher.Tracks()) == 2 && !start.IsZero() && time.Since(start) > bufferDuration
+	}, 20*time.Second, 100*time.Millisecond)
+
+	started := time.Now()
+
+	recorder, err := testRoom.StartRecording(RecordingOptions{Directory: t.TempDir()})
+	require.NoError(t, err)
+
+	// the recording includes the buffered packets from before it's started
+	require.True(t, recorder.StartTime().Before(started.Add(-bufferDuration)))
+
+	time.Sleep(time.Second)
+
+	require.NoError(t, testRoom.StopRecording())
+
+	tracks := recorder.RecordedTracks()
+	require.Len(t, tracks, 2)
+
+	for _, track := range tracks {
+		require.Less(t, track.Start, started.Sub(recorder.StartTime()), track.Path)
+
+		info, err := os.Stat(track.Path)
+		require.NoError(t, err)
+		require.Greater(t, info.Size(), int64(1000), track.Path)
+	}
+
+	_ = testRoom.StopClient(publisher.ID())
+}
+
+func TestRecordingBufferTrim(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	track := newTestForwardedTrack(ctx)
+	track.base.kind = webrtc.RTPCodecTypeVideo
+	track.base.codec.MimeType = webrtc.MimeTypeVP8
+
+	buffer := newRecordingBuffer(track, time.Second)
+
+	keyframe := []byte{0x10, 0x00}
+	delta := []byte{0x10, 0x01}
+
+	start := time.Now()
+	push := func(seq uint16, payload []byte, at time.Duration) {
+		buffer.push(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}, Payload: payload}, start.Add(at))
+	}
+
+	sequences := func() []uint16 {
+		seqs := make([]uint16, 0)
+		for _, bp := range buffer.packets {
+			seqs = append(seqs, bp.packet.SequenceNumber)
+		}
+
+		return seqs
+	}
+
+	push(1, keyframe, 0)
+	push(2, delta, 500*time.Millisecond)
+	push(3, keyframe, time.Second)
+	push(4, delta, 1500*time.Millisecond)
+	require.Equal(t, []uint16{3, 4}, sequences())
+
+	// starts from the keyframe before the buffer duration
+	push(5, delta, 2200*time.Millisecond)
+	require.Equal(t, []uint16{3, 4, 5}, sequences())
+	require.Equal(t, start.Add(time.Second), buffer.start())
+
+	// the frames after the keyframe can't be decoded without it
+	push(6, delta, 5*time.Second)
+	require.Equal(t, []uint16{3, 4, 5, 6}, sequences())
+
+	push(7, keyframe, 5500*time.Millisecond)
+	push(8, delta, 7*time.Second)
+	require.Equal(t, []uint16{7, 8}, sequences())
+
+	buffer.stop()
+	push(9, keyframe, 7500*time.Millisecond)
+	require.True(t, buffer.start().IsZero())
+}
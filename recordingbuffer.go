@@ -0,0 +1,213 @@
+package sfu
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// the buffer is also limited by the number of packets, for the publisher that rarely sends a keyframe
+const recordingBufferMaxPackets = 20_000
+
+type bufferedPacket struct {
+	packet *rtp.Packet
+	at     time.Time
+	// the packet is the start of a keyframe, or an audio packet, the recording can start from it
+	keyframe bool
+}
+
+// recordingBuffer keeps the packets of a track from the last RoomOptions.RecordingBuffer, so a recording that started
+// later includes what happened before it's started. The buffer starts from a keyframe that is not newer than the buffer
+// duration, so the first recorded frame can be decoded.
+type recordingBuffer struct {
+	mu        sync.Mutex
+	track     ITrack
+	mimeType  string
+	duration  time.Duration
+	packets   []bufferedPacket
+	recorders []*trackRecorder
+	ended     bool
+}
+
+func newRecordingBuffer(track ITrack, duration time.Duration) *recordingBuffer {
+	b := &recordingBuffer{
+		track:    track,
+		mimeType: strings.ToLower(track.MimeType()),
+		duration: duration,
+		packets:  make([]bufferedPacket, 0),
+	}
+
+	track.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, quality QualityLevel) {
+		// only the highest simulcast layer is recorded
+		if track.IsSimulcast() && quality != QualityHigh {
+			return
+		}
+
+		b.push(p, time.Now())
+	})
+
+	return b
+}
+
+// push is called from the track read loop, the packet is copied because it will be returned to the pool
+func (b *recordingBuffer) push(p *rtp.Packet, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ended {
+		return
+	}
+
+	recorders := b.recorders[:0]
+	for _, tr := range b.recorders {
+		if tr.context.Err() == nil {
+			tr.push(p)
+			recorders = append(recorders, tr)
+		}
+	}
+
+	b.recorders = recorders
+
+	b.packets = append(b.packets, bufferedPacket{
+		packet:   p.Clone(),
+		at:       now,
+		keyframe: b.track.Kind() == webrtc.RTPCodecTypeAudio || IsKeyframe(b.mimeType, p.Payload),
+	})
+
+	b.trim(now)
+}
+
+// trim drops the packets before the last keyframe that is older than the buffer duration
+func (b *recordingBuffer) trim(now time.Time) {
+	cutoff := now.Add(-b.duration)
+
+	if len(b.packets) <= recordingBufferMaxPackets && b.packets[0].at.After(cutoff) {
+		return
+	}
+
+	keyframe := -1
+	first := len(b.packets)
+
+	for i, bp := range b.packets {
+		if bp.at.After(cutoff) {
+			first = i
+			break
+		}
+
+		if bp.keyframe {
+			keyframe = i
+		}
+	}
+
+	start := first
+	if keyframe >= 0 && (first == len(b.packets) || !b.packets[first].keyframe) {
+		start = keyframe
+	}
+
+	if len(b.packets)-start > recordingBufferMaxPackets {
+		start = len(b.packets) - recordingBufferMaxPackets
+	}
+
+	// the dropped packets are released when the slice is grown to a new array
+	b.packets = b.packets[start:]
+}
+
+// attach passes the buffered packets to the track recorder before the packets that read after it
+func (b *recordingBuffer) attach(tr *trackRecorder) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tr.buffered = append(tr.buffered, b.packets...)
+	b.recorders = append(b.recorders, tr)
+}
+
+// start returns the time of the oldest buffered packet, zero if the buffer is empty
+func (b *recordingBuffer) start() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.packets) == 0 {
+		return time.Time{}
+	}
+
+	return b.packets[0].at
+}
+
+func (b *recordingBuffer) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ended = true
+	b.packets = nil
+	b.recorders = nil
+}
+
+// bufferTracks starts buffering the published tracks when RoomOptions.RecordingBuffer is set
+func (r *Room) bufferTracks(tracks []ITrack) {
+	if r.options.RecordingBuffer == nil || *r.options.RecordingBuffer <= 0 || r.options.E2EE {
+		return
+	}
+
+	for _, track := range tracks {
+		if track.IsE2EE() {
+			continue
+		}
+
+		key := track.ClientID() + "/" + track.ID()
+
+		r.mu.Lock()
+		if r.recordingBuffers == nil {
+			r.recordingBuffers = make(map[string]*recordingBuffer)
+		}
+
+		if _, ok := r.recordingBuffers[key]; ok {
+			r.mu.Unlock()
+			continue
+		}
+
+		buffer := newRecordingBuffer(track, *r.options.RecordingBuffer)
+		r.recordingBuffers[key] = buffer
+		r.mu.Unlock()
+
+		track.OnEnded(func() {
+			r.mu.Lock()
+			if r.recordingBuffers[key] == buffer {
+				delete(r.recordingBuffers, key)
+			}
+			r.mu.Unlock()
+
+			buffer.stop()
+		})
+	}
+}
+
+func (r *Room) recordingBuffer(track ITrack) *recordingBuffer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.recordingBuffers[track.ClientID()+"/"+track.ID()]
+}
+
+// recordingBufferStart returns the time of the oldest buffered packet of all tracks, zero if nothing is buffered
+func (r *Room) recordingBufferStart() time.Time {
+	r.mu.RLock()
+	buffers := make([]*recordingBuffer, 0, len(r.recordingBuffers))
+	for _, buffer := range r.recordingBuffers {
+		buffers = append(buffers, buffer)
+	}
+	r.mu.RUnlock()
+
+	oldest := time.Time{}
+
+	for _, buffer := range buffers {
+		if start := buffer.start(); !start.IsZero() && (oldest.IsZero() || start.Before(oldest)) {
+			oldest = start
+		}
+	}
+
+	return oldest
+}
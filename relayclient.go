@@ -0,0 +1,1063 @@
+package sfu
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	relayMessageHello  = byte(1)
+	relayMessageTracks = byte(2)
+	relayMessageRTP    = byte(3)
+	relayMessagePLI    = byte(4)
+	relayMessagePing   = byte(5)
+
+	relayMessageFeedback = byte(6)
+
+	// number of messages can be queued before the RTP packets dropped when the relay connection is slow
+	relaySendQueueSize = 1024
+
+	relayMaxMessageSize    = 1 << 20
+	relayMaxRetryInterval  = 5 * time.Second
+	relayStreamHeaderBytes = 4
+)
+
+var (
+	ErrRelayClosed           = errors.New("relay: relay is closed")
+	ErrRelayTimeout          = errors.New("relay: no message received from the remote node")
+	ErrRelayReconnectTimeout = errors.New("relay: failed to reconnect to the remote node")
+	ErrRelayInvalidHello     = errors.New("relay: invalid hello from the remote node")
+	ErrRelayMessageTooLarge  = errors.New("relay: message is too large")
+)
+
+// RelayConn is the transport of a relay link between two SFUs. A message is a whole relay message, the transport
+// must keep the message boundary and the order. WriteMessage is never called concurrently, and the slice returned by
+// ReadMessage is owned by the caller.
+type RelayConn interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(data []byte) error
+	Close() error
+}
+
+// RelayDialFunc opens a new connection to the remote SFU, it's called again to reconnect when the connection is lost
+type RelayDialFunc func(ctx context.Context) (RelayConn, error)
+
+type RelayOptions struct {
+	// MaxHops is the maximum number of SFUs a track can pass through, zero means unlimited
+	MaxHops int `json:"max_hops"`
+	// KeepAliveInterval is the interval of the ping that sent to the remote node
+	KeepAliveInterval time.Duration `json:"keep_alive_interval"`
+	// Timeout is the duration without any message from the remote node before the connection is considered lost
+	Timeout time.Duration `json:"timeout"`
+	// ReconnectTimeout is how long the relayed tracks are kept while the connection is lost, the relay is closed
+	// with ErrRelayReconnectTimeout if it's not reconnected in this duration
+	ReconnectTimeout time.Duration `json:"reconnect_timeout"`
+	// RetryInterval is the first delay between the reconnection attempts, it's doubled on every failed attempt
+	RetryInterval time.Duration `json:"retry_interval"`
+	// FeedbackInterval is the interval of the congestion feedback that sent to the remote node
+	FeedbackInterval time.Duration `json:"feedback_interval"`
+	// LimitPublishers caps the uplink bitrate of the publishers with REMB when the relay is congested,
+	// the estimated bitrate of the relay is shared to the publishers by the bitrate of their forwarded tracks
+	LimitPublishers bool `json:"limit_publishers"`
+	// ClientOptions used to create the bridge client that owns the relayed tracks
+	ClientOptions ClientOptions `json:"client_options"`
+	// Authorize is called on the accepting side before a new relay is created, return an error to reject the relay
+	Authorize func(roomID, nodeID string) error `json:"-"`
+}
+
+func DefaultRelayOptions() RelayOptions {
+	return RelayOptions{
+		MaxHops:           4,
+		KeepAliveInterval: time.Second,
+		Timeout:           5 * time.Second,
+		ReconnectTimeout:  30 * time.Second,
+		RetryInterval:     500 * time.Millisecond,
+		FeedbackInterval:  time.Second,
+		LimitPublishers:   true,
+		ClientOptions:     DefaultClientOptions(),
+	}
+}
+
+type relayHello struct {
+	Node string `json:"node"`
+	Room string `json:"room"`
+}
+
+// RelayClient is a bidirectional relay link between the same room on two SFUs. Both sides forward the tracks of
+// their room to the other side, and publish the tracks from the other side as relay tracks owned by a bridge client.
+// The keyframe requests from the local subscribers are forwarded upstream to the publisher.
+//
+// When the connection is lost the relayed tracks are kept, the dialing side reconnects, and the track list is
+// synced again once reconnected. The tracks that ended while disconnected are ended, and the subscribers of the tracks
+// that still exist keep receiving them without renegotiation.
+type RelayClient struct {
+	mu         sync.Mutex
+	sendMu     sync.Mutex
+	context    context.Context
+	cancel     context.CancelFunc
+	room       *Room
+	client     *Client
+	localNode  string
+	remoteNode string
+	dial       RelayDialFunc
+	options    RelayOptions
+	closed     bool
+	// the current connection, nil while disconnected
+	conn         RelayConn
+	connContext  context.Context
+	connCancel   context.CancelFunc
+	queue        chan []byte
+	lastReceived atomic.Int64
+	// the timer to close the relay if it's not reconnected
+	reconnectTimer *time.Timer
+	// the local tracks that forwarded to the remote node by the track ID
+	sources map[string]*rtpCascadeSource
+	// the tracks from the remote node by the track ID, and by the SSRC of the remote node
+	relays         map[string]*rtpCascadeRelay
+	relaysBySSRC   map[uint32]*rtpCascadeRelay
+	onConnected    func()
+	onDisconnected func(error)
+	onClosed       func(error)
+	log            logging.LeveledLogger
+
+	// the congestion state of the current connection, see relaycongestion.go
+	congestion       relayCongestionController
+	estimatedBitrate atomic.Uint32
+	offeredBytes     map[string]uint64
+	lastFeedback     time.Time
+	limitedClients   map[string]bool
+	writtenPackets   atomic.Uint64
+	droppedPackets   atomic.Uint64
+	receivedBytes    atomic.Uint64
+	receivedPackets  atomic.Uint64
+}
+
+// ConnectRelay connects the local room to the same room on the remote SFU that accepts the relay with AcceptRelay
+// or ServeRelay. The dial is called again to reconnect when the connection is lost.
+func (m *Manager) ConnectRelay(ctx context.Context, roomID string, dial RelayDialFunc, opts RelayOptions) (*RelayClient, error) {
+	room, err := m.GetRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.name == "" {
+		return nil, ErrCascadeNoNodeID
+	}
+
+	conn, hello, err := dialRelay(ctx, dial, relayHello{Node: m.name, Room: roomID}, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if hello.Node == m.name {
+		_ = conn.Close()
+		return nil, ErrCascadeSameNode
+	}
+
+	c := newRelayClient(room, m.name, hello.Node, ClientTypeUpBridge, opts)
+	c.dial = dial
+
+	c.attach(conn)
+	c.start()
+
+	return c, nil
+}
+
+// AcceptRelay accepts a relay connection from a remote SFU that connected with ConnectRelay. If the remote node
+// is reconnecting, the connection is attached to its existing relay and the same relay is returned.
+func (m *Manager) AcceptRelay(conn RelayConn, opts RelayOptions) (*RelayClient, error) {
+	if m.name == "" {
+		_ = conn.Close()
+		return nil, ErrCascadeNoNodeID
+	}
+
+	hello, err := readRelayHello(conn, opts.Timeout)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if hello.Node == m.name {
+		_ = conn.Close()
+		return nil, ErrCascadeSameNode
+	}
+
+	room, err := m.GetRoom(hello.Room)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if opts.Authorize != nil {
+		if err := opts.Authorize(hello.Room, hello.Node); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	if err := writeRelayHello(conn, relayHello{Node: m.name, Room: hello.Room}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	for _, c := range room.Relays() {
+		if c.RemoteNode() == hello.Node {
+			m.log.Infof("relay: node %s is reconnected to room %s", hello.Node, room.ID())
+			c.attach(conn)
+
+			return c, nil
+		}
+	}
+
+	c := newRelayClient(room, m.name, hello.Node, ClientTypeDownBridge, opts)
+
+	c.attach(conn)
+	c.start()
+
+	m.log.Infof("relay: node %s is connected to room %s", hello.Node, room.ID())
+
+	return c, nil
+}
+
+// ServeRelay accepts the relay connections on the listener until the listener is closed,
+// the connections are framed with NewRelayStreamConn
+func (m *Manager) ServeRelay(listener net.Listener, opts RelayOptions) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			if _, err := m.AcceptRelay(NewRelayStreamConn(conn), opts); err != nil {
+				m.log.Errorf("relay: failed to accept relay from %s: %s", conn.RemoteAddr().String(), err.Error())
+			}
+		}()
+	}
+}
+
+// RelayDialTCP returns a RelayDialFunc that connects to the SFU that serves the relay on the TCP address with ServeRelay
+func RelayDialTCP(addr string) RelayDialFunc {
+	return func(ctx context.Context) (RelayConn, error) {
+		dialer := &net.Dialer{}
+
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewRelayStreamConn(conn), nil
+	}
+}
+
+func newRelayClient(room *Room, localNode, remoteNode, clientType string, opts RelayOptions) *RelayClient {
+	defaults := DefaultRelayOptions()
+
+	if opts.KeepAliveInterval <= 0 {
+		opts.KeepAliveInterval = defaults.KeepAliveInterval
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaults.Timeout
+	}
+
+	if opts.ReconnectTimeout <= 0 {
+		opts.ReconnectTimeout = defaults.ReconnectTimeout
+	}
+
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = defaults.RetryInterval
+	}
+
+	if opts.FeedbackInterval <= 0 {
+		opts.FeedbackInterval = defaults.FeedbackInterval
+	}
+
+	ctx, cancel := context.WithCancel(room.context)
+
+	clientOpts := opts.ClientOptions
+	clientOpts.Type = clientType
+
+	c := &RelayClient{
+		context:      ctx,
+		cancel:       cancel,
+		room:         room,
+		localNode:    localNode,
+		remoteNode:   remoteNode,
+		options:      opts,
+		sources:      make(map[string]*rtpCascadeSource),
+		relays:       make(map[string]*rtpCascadeRelay),
+		relaysBySSRC: make(map[uint32]*rtpCascadeRelay),
+		log:          room.sfu.log,
+	}
+
+	// the bridge client never connects, it's only the owner of the relay tracks in the room
+	c.client = room.sfu.NewClient(room.CreateClientID(), remoteNode, clientOpts)
+
+	room.mu.Lock()
+	room.relays[c.client.ID()] = c
+	room.mu.Unlock()
+
+	return c
+}
+
+// start forwards the current and the future tracks of the room to the remote node
+func (c *RelayClient) start() {
+	go func() {
+		// the room is closed
+		<-c.context.Done()
+		_ = c.close(nil)
+	}()
+
+	c.room.sfu.OnTracksAvailable(c.addSources)
+
+	tracks := c.room.sfu.relayTrackList()
+	for _, client := range c.room.sfu.GetClients() {
+		tracks = append(tracks, client.Tracks()...)
+	}
+
+	c.addSources(tracks)
+}
+
+// Client returns the bridge client that owns the tracks from the remote node
+func (c *RelayClient) Client() *Client {
+	return c.client
+}
+
+// RemoteNode returns the node ID of the SFU on the other side of the relay
+func (c *RelayClient) RemoteNode() string {
+	return c.remoteNode
+}
+
+// IsConnected returns true if the relay is currently connected to the remote node
+func (c *RelayClient) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn != nil
+}
+
+// OnConnected event is called every time the relay is connected or reconnected and the track list is sent
+func (c *RelayClient) OnConnected(callback func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onConnected = callback
+}
+
+// OnDisconnected event is called when the connection is lost, the relay is reconnecting until the ReconnectTimeout
+func (c *RelayClient) OnDisconnected(callback func(error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onDisconnected = callback
+}
+
+// OnClosed event is called once the relay is closed, the error is ErrRelayReconnectTimeout if it's failed to reconnect
+func (c *RelayClient) OnClosed(callback func(error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onClosed = callback
+}
+
+// Close stops the relay and closes the connection, the relayed tracks are ended on both sides
+func (c *RelayClient) Close() error {
+	return c.close(nil)
+}
+
+func (c *RelayClient) close(reason error) error {
+	c.mu.Lock()
+
+	if c.closed {
+		c.mu.Unlock()
+		return ErrRelayClosed
+	}
+
+	c.closed = true
+	c.cancel()
+
+	if c.reconnectTimer != nil {
+		c.reconnectTimer.Stop()
+	}
+
+	conn := c.conn
+	c.conn = nil
+
+	if c.connCancel != nil {
+		c.connCancel()
+	}
+
+	for id, relay := range c.relays {
+		close(relay.rtpChan)
+		delete(c.relays, id)
+	}
+
+	c.relaysBySSRC = make(map[uint32]*rtpCascadeRelay)
+
+	onClosed := c.onClosed
+	c.mu.Unlock()
+
+	c.room.mu.Lock()
+	delete(c.room.relays, c.client.ID())
+	c.room.mu.Unlock()
+
+	_ = c.client.stop()
+
+	c.limitPublishers(0, nil, 0)
+
+	var err error
+	if conn != nil {
+		err = conn.Close()
+	}
+
+	c.log.Infof("relay: relay to node %s in room %s is closed", c.remoteNode, c.room.ID())
+
+	if onClosed != nil {
+		onClosed(reason)
+	}
+
+	return err
+}
+
+// attach starts using the connection and syncs the track list with the remote node, the previous connection is closed
+func (c *RelayClient) attach(conn RelayConn) {
+	c.mu.Lock()
+
+	if c.closed {
+		c.mu.Unlock()
+		_ = conn.Close()
+
+		return
+	}
+
+	previous := c.conn
+
+	if c.connCancel != nil {
+		c.connCancel()
+	}
+
+	if c.reconnectTimer != nil {
+		c.reconnectTimer.Stop()
+		c.reconnectTimer = nil
+	}
+
+	ctx, cancel := context.WithCancel(c.context)
+
+	c.conn = conn
+	c.connContext = ctx
+	c.connCancel = cancel
+	c.queue = make(chan []byte, relaySendQueueSize)
+	c.offeredBytes = make(map[string]uint64)
+	c.lastReceived.Store(time.Now().UnixNano())
+
+	queue := c.queue
+	onConnected := c.onConnected
+	c.mu.Unlock()
+
+	if previous != nil {
+		_ = previous.Close()
+	}
+
+	c.resetCongestion()
+
+	go c.writeLoop(ctx, conn, queue)
+	go c.readLoop(conn)
+	go c.keepAlive(ctx, conn)
+	go c.feedbackLoop(ctx)
+
+	c.sendTracks()
+
+	// the relayed tracks missed the packets while disconnected, the decoders need a keyframe to recover
+	c.mu.Lock()
+	for _, relay := range c.relays {
+		c.enqueuePLI(relay.info.SSRC)
+	}
+	c.mu.Unlock()
+
+	if onConnected != nil {
+		onConnected()
+	}
+}
+
+// detach is called when the connection is lost, the relay is closed if it's not reconnected in the ReconnectTimeout
+func (c *RelayClient) detach(conn RelayConn, reason error) {
+	c.mu.Lock()
+
+	if c.closed || c.conn != conn {
+		c.mu.Unlock()
+		return
+	}
+
+	c.conn = nil
+	c.connCancel()
+
+	c.reconnectTimer = time.AfterFunc(c.options.ReconnectTimeout, func() {
+		c.log.Warnf("relay: failed to reconnect to node %s in room %s", c.remoteNode, c.room.ID())
+		_ = c.close(ErrRelayReconnectTimeout)
+	})
+
+	onDisconnected := c.onDisconnected
+	c.mu.Unlock()
+
+	_ = conn.Close()
+
+	c.limitPublishers(0, nil, 0)
+
+	c.log.Warnf("relay: connection to node %s in room %s is lost: %s", c.remoteNode, c.room.ID(), reason.Error())
+
+	if onDisconnected != nil {
+		onDisconnected(reason)
+	}
+
+	if c.dial != nil {
+		go c.reconnect()
+	}
+}
+
+// reconnect dials the remote node until it's reconnected or the relay is closed
+func (c *RelayClient) reconnect() {
+	interval := c.options.RetryInterval
+
+	for {
+		select {
+		case <-c.context.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		conn, hello, err := dialRelay(c.context, c.dial, relayHello{Node: c.localNode, Room: c.room.ID()}, c.options.Timeout)
+		if err == nil && hello.Node != c.remoteNode {
+			_ = conn.Close()
+			err = ErrRelayInvalidHello
+		}
+
+		if err != nil {
+			c.log.Debugf("relay: failed to reconnect to node %s: %s", c.remoteNode, err.Error())
+
+			if interval *= 2; interval > relayMaxRetryInterval {
+				interval = relayMaxRetryInterval
+			}
+
+			continue
+		}
+
+		c.log.Infof("relay: reconnected to node %s in room %s", c.remoteNode, c.room.ID())
+		c.attach(conn)
+
+		return
+	}
+}
+
+func (c *RelayClient) readLoop(conn RelayConn) {
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			c.detach(conn, err)
+			return
+		}
+
+		c.lastReceived.Store(time.Now().UnixNano())
+
+		if len(data) == 0 {
+			continue
+		}
+
+		switch data[0] {
+		case relayMessageTracks:
+			var infos []rtpCascadeTrack
+			if err := json.Unmarshal(data[1:], &infos); err != nil {
+				c.log.Errorf("relay: failed to decode the tracks from node %s: %s", c.remoteNode, err.Error())
+				continue
+			}
+
+			c.onTracks(infos)
+		case relayMessageRTP:
+			c.handleRTP(data[1:])
+		case relayMessagePLI:
+			if len(data) >= 5 {
+				c.onPLI(binary.BigEndian.Uint32(data[1:]))
+			}
+		case relayMessageFeedback:
+			var feedback relayFeedback
+			if err := json.Unmarshal(data[1:], &feedback); err == nil {
+				c.onFeedback(feedback)
+			}
+		}
+	}
+}
+
+func (c *RelayClient) writeLoop(ctx context.Context, conn RelayConn, queue chan []byte) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-queue:
+			if err := conn.WriteMessage(data); err != nil {
+				c.detach(conn, err)
+				return
+			}
+
+			if data[0] == relayMessageRTP {
+				c.writtenPackets.Add(1)
+			}
+		}
+	}
+}
+
+// keepAlive sends the ping to the remote node and detects the lost connection
+func (c *RelayClient) keepAlive(ctx context.Context, conn RelayConn) {
+	ticker := time.NewTicker(c.options.KeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, c.lastReceived.Load())) > c.options.Timeout {
+				c.detach(conn, ErrRelayTimeout)
+				return
+			}
+
+			c.enqueue([]byte{relayMessagePing})
+		}
+	}
+}
+
+// enqueue sends the message to the current connection, it waits until the message is queued or the connection is lost
+func (c *RelayClient) enqueue(data []byte) {
+	c.mu.Lock()
+	ctx, queue := c.connContext, c.queue
+	connected := c.conn != nil
+	c.mu.Unlock()
+
+	if !connected {
+		return
+	}
+
+	select {
+	case queue <- data:
+	case <-ctx.Done():
+	}
+}
+
+// enqueueRTP sends the packet of the publisher to the current connection, the packet is dropped when the queue is full.
+// The dropped packets are counted as lost by the congestion controller.
+func (c *RelayClient) enqueueRTP(clientID string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return
+	}
+
+	c.offeredBytes[clientID] += uint64(len(data))
+
+	select {
+	case c.queue <- data:
+	default:
+		c.droppedPackets.Add(1)
+		c.log.Warnf("relay: send queue to node %s is full, packet is dropped", c.remoteNode)
+	}
+}
+
+// enqueuePLI requests a keyframe of the track that published on the remote node, it's called with the lock held
+func (c *RelayClient) enqueuePLI(ssrc uint32) {
+	if c.conn == nil {
+		return
+	}
+
+	data := binary.BigEndian.AppendUint32([]byte{relayMessagePLI}, ssrc)
+
+	select {
+	case c.queue <- data:
+	default:
+	}
+}
+
+// sendTracks sends the list of all forwarded tracks, the remote node ends the relayed tracks that are not in the list
+func (c *RelayClient) sendTracks() {
+	// keep the order of the lists when the sources are changed concurrently
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.mu.Lock()
+	infos := make([]rtpCascadeTrack, 0, len(c.sources))
+	for _, source := range c.sources {
+		infos = append(infos, source.info)
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(infos)
+	if err != nil {
+		c.log.Errorf("relay: failed to encode the tracks: %s", err.Error())
+		return
+	}
+
+	c.enqueue(append([]byte{relayMessageTracks}, data...))
+}
+
+// trackPath returns the nodes that the relayed track passed through, including the remote node
+func (c *RelayClient) trackPath(trackID string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if relay, ok := c.relays[trackID]; ok {
+		return append(slices.Clone(relay.info.Path), c.remoteNode)
+	}
+
+	return []string{c.remoteNode}
+}
+
+// addSources forwards the tracks that never passed through the remote node
+func (c *RelayClient) addSources(tracks []ITrack) {
+	added := false
+
+	for _, track := range tracks {
+		if c.addSource(track) {
+			added = true
+		}
+	}
+
+	if added {
+		c.sendTracks()
+	}
+}
+
+func (c *RelayClient) addSource(track ITrack) bool {
+	if c.context.Err() != nil {
+		return false
+	}
+
+	path := c.room.trackPath(track)
+
+	c.mu.Lock()
+
+	if slices.Contains(path, c.remoteNode) || (c.options.MaxHops > 0 && len(path) >= c.options.MaxHops) {
+		c.mu.Unlock()
+		return false
+	}
+
+	if _, ok := c.sources[track.ID()]; ok {
+		c.mu.Unlock()
+		return false
+	}
+
+	source := &rtpCascadeSource{
+		track: track,
+		info: rtpCascadeTrack{
+			cascadeTrack: cascadeTrack{
+				ID:     track.ID(),
+				Source: track.SourceType(),
+				Label:  track.Source().Label,
+				Path:   path,
+			},
+			SSRC:     rand.Uint32(),
+			StreamID: track.StreamID(),
+			Kind:     track.Kind(),
+			MimeType: track.MimeType(),
+		},
+	}
+
+	c.sources[track.ID()] = source
+	c.mu.Unlock()
+
+	track.OnRead(func(attrs interceptor.Attributes, p *rtp.Packet, quality QualityLevel) {
+		// only the high layer of a simulcast track is forwarded
+		if track.IsSimulcast() && quality != QualityHigh {
+			return
+		}
+
+		c.writeRTP(track.ClientID(), source.info.SSRC, p)
+	})
+
+	track.OnEnded(func() {
+		c.mu.Lock()
+		delete(c.sources, track.ID())
+		c.mu.Unlock()
+
+		c.sendTracks()
+	})
+
+	requestKeyframe(track)
+
+	return true
+}
+
+func (c *RelayClient) writeRTP(clientID string, ssrc uint32, p *rtp.Packet) {
+	if c.context.Err() != nil {
+		return
+	}
+
+	// the packet is shared with the other readers, copy the header before rewriting the SSRC
+	packet := rtp.Packet{Header: p.Header, Payload: p.Payload}
+	packet.Header.SSRC = ssrc
+
+	buf := make([]byte, 1+packet.MarshalSize())
+	buf[0] = relayMessageRTP
+
+	if _, err := packet.MarshalTo(buf[1:]); err != nil {
+		c.log.Errorf("relay: failed to marshal rtp: %s", err.Error())
+		return
+	}
+
+	c.enqueueRTP(clientID, buf)
+}
+
+func (c *RelayClient) onPLI(ssrc uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, source := range c.sources {
+		if source.info.SSRC == ssrc {
+			requestKeyframe(source.track)
+			return
+		}
+	}
+}
+
+// onTracks syncs the relayed tracks with the track list of the remote node. The tracks that are not in the list are
+// ended, and the SSRC of the existing tracks is updated in case the remote node is restarted.
+func (c *RelayClient) onTracks(infos []rtpCascadeTrack) {
+	listed := make(map[string]bool, len(infos))
+	added := make([]rtpCascadeTrack, 0)
+
+	c.mu.Lock()
+
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+
+	for _, info := range infos {
+		listed[info.ID] = true
+
+		relay, ok := c.relays[info.ID]
+		if !ok {
+			added = append(added, info)
+			continue
+		}
+
+		if relay.info.SSRC != info.SSRC {
+			delete(c.relaysBySSRC, relay.info.SSRC)
+			relay.info.SSRC = info.SSRC
+			c.relaysBySSRC[info.SSRC] = relay
+		}
+	}
+
+	for id, relay := range c.relays {
+		if !listed[id] {
+			close(relay.rtpChan)
+			delete(c.relays, id)
+			delete(c.relaysBySSRC, relay.info.SSRC)
+		}
+	}
+
+	c.mu.Unlock()
+
+	for _, info := range added {
+		c.addRelay(info)
+	}
+}
+
+// addRelay publishes the track from the remote node to the local room as a relay track
+func (c *RelayClient) addRelay(info rtpCascadeTrack) {
+	if c.room.hasTrack(info.ID, c.client.ID()) || c.room.sfu.hasRelayTrack(info.ID) {
+		c.log.Warnf("relay: track %s from node %s is already in the room", info.ID, c.remoteNode)
+		return
+	}
+
+	relay := &rtpCascadeRelay{
+		info:    info,
+		rtpChan: make(chan *rtp.Packet, rtpCascadeChannelSize),
+	}
+
+	c.mu.Lock()
+
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+
+	c.relays[info.ID] = relay
+	c.relaysBySSRC[info.SSRC] = relay
+	c.mu.Unlock()
+
+	// the keyframe request from the local subscribers is forwarded to the remote node
+	onPLI := func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.enqueuePLI(relay.info.SSRC)
+	}
+
+	remoteTrack := NewTrackRelay(info.ID, info.StreamID, "", info.Kind, webrtc.SSRC(info.SSRC), info.MimeType, relay.rtpChan)
+
+	c.room.sfu.addRelayTrack(c.context, remoteTrack, c.client, TrackSource{Type: info.Source, Label: info.Label}, onPLI)
+}
+
+func (c *RelayClient) handleRTP(data []byte) {
+	c.receivedBytes.Add(uint64(len(data)))
+	c.receivedPackets.Add(1)
+
+	p := &rtp.Packet{}
+	if err := p.Unmarshal(data); err != nil {
+		c.log.Errorf("relay: failed to unmarshal rtp: %s", err.Error())
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	relay, ok := c.relaysBySSRC[p.SSRC]
+	if !ok {
+		return
+	}
+
+	select {
+	case relay.rtpChan <- p:
+	default:
+		c.log.Warnf("relay: relay track %s buffer is full, packet is dropped", relay.info.ID)
+	}
+}
+
+// dialRelay opens a connection and exchanges the hello with the remote node
+func dialRelay(ctx context.Context, dial RelayDialFunc, hello relayHello, timeout time.Duration) (RelayConn, relayHello, error) {
+	conn, err := dial(ctx)
+	if err != nil {
+		return nil, relayHello{}, err
+	}
+
+	if err := writeRelayHello(conn, hello); err != nil {
+		_ = conn.Close()
+		return nil, relayHello{}, err
+	}
+
+	remote, err := readRelayHello(conn, timeout)
+	if err != nil {
+		_ = conn.Close()
+		return nil, relayHello{}, err
+	}
+
+	return conn, remote, nil
+}
+
+func writeRelayHello(conn RelayConn, hello relayHello) error {
+	data, err := json.Marshal(hello)
+	if err != nil {
+		return err
+	}
+
+	return conn.WriteMessage(append([]byte{relayMessageHello}, data...))
+}
+
+// readRelayHello waits the hello from the remote node, the conn is closed if it's not received before the timeout
+func readRelayHello(conn RelayConn, timeout time.Duration) (relayHello, error) {
+	if timeout <= 0 {
+		timeout = DefaultRelayOptions().Timeout
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		_ = conn.Close()
+	})
+
+	data, err := conn.ReadMessage()
+	if !timer.Stop() {
+		return relayHello{}, ErrRelayTimeout
+	}
+
+	if err != nil {
+		return relayHello{}, err
+	}
+
+	var hello relayHello
+	if len(data) == 0 || data[0] != relayMessageHello || json.Unmarshal(data[1:], &hello) != nil || hello.Node == "" {
+		return relayHello{}, ErrRelayInvalidHello
+	}
+
+	return hello, nil
+}
+
+// Relays returns the relay links of the room
+func (r *Room) Relays() []*RelayClient {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	relays := make([]*RelayClient, 0, len(r.relays))
+	for _, c := range r.relays {
+		relays = append(relays, c)
+	}
+
+	return relays
+}
+
+type relayStreamConn struct {
+	mu     sync.Mutex
+	conn   io.ReadWriteCloser
+	reader *bufio.Reader
+}
+
+// NewRelayStreamConn frames the relay messages on a stream connection like TCP or a QUIC stream,
+// every message is prefixed with its length as a 4 bytes big endian integer
+func NewRelayStreamConn(conn io.ReadWriteCloser) RelayConn {
+	return &relayStreamConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+}
+
+func (c *relayStreamConn) ReadMessage() ([]byte, error) {
+	header := make([]byte, relayStreamHeaderBytes)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size > relayMaxMessageSize {
+		return nil, ErrRelayMessageTooLarge
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (c *relayStreamConn) WriteMessage(data []byte) error {
+	if len(data) > relayMaxMessageSize {
+		return ErrRelayMessageTooLarge
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	buf := make([]byte, relayStreamHeaderBytes+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[relayStreamHeaderBytes:], data)
+
+	_, err := c.conn.Write(buf)
+
+	return err
+}
+
+func (c *relayStreamConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,254 @@
+package sfu
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	managerA := NewManager(ctx, "node-a", sfuOpts)
+	defer managerA.Close()
+
+	managerB := NewManager(ctx, "node-b", sfuOpts)
+	defer managerB.Close()
+
+	roomID := managerA.CreateRoomID()
+
+	roomA, err := managerA.NewRoom(roomID, "room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	roomB, err := managerB.NewRoom(roomID, "room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	acceptOpts := DefaultRelayOptions()
+	acceptOpts.ReconnectTimeout = time.Second
+
+	// the current connection, closed to simulate a network failure
+	var connMu sync.Mutex
+	var current net.Conn
+
+	dial := func(ctx context.Context) (RelayConn, error) {
+		local, remote := net.Pipe()
+
+		connMu.Lock()
+		current = local
+		connMu.Unlock()
+
+		go func() {
+			_, _ = managerA.AcceptRelay(NewRelayStreamConn(remote), acceptOpts)
+		}()
+
+		return NewRelayStreamConn(local), nil
+	}
+
+	_, publisherA, _, _ := CreatePeerPair(ctx, TestLogger, roomA, DefaultTestIceServers(), "publisher-a", true, false, true)
+	_, publisherB, _, _ := CreatePeerPair(ctx, TestLogger, roomB, DefaultTestIceServers(), "publisher-b", true, false, true)
+
+	connectOpts := DefaultRelayOptions()
+	connectOpts.RetryInterval = 50 * time.Millisecond
+
+	relay, err := managerB.ConnectRelay(ctx, roomID, dial, connectOpts)
+	require.NoError(t, err)
+	require.Equal(t, "node-a", relay.RemoteNode())
+
+	var connected, disconnected atomic.Int32
+
+	relay.OnConnected(func() {
+		connected.Add(1)
+	})
+
+	relay.OnDisconnected(func(error) {
+		disconnected.Add(1)
+	})
+
+	relayedTracks := func(room *Room, publisher *Client) []ITrack {
+		tracks := make([]ITrack, 0)
+
+		for _, track := range publisher.Tracks() {
+			room.sfu.mu.Lock()
+			relayTrack, ok := room.sfu.relayTracks[track.ID()]
+			room.sfu.mu.Unlock()
+
+			if ok {
+				tracks = append(tracks, relayTrack)
+			}
+		}
+
+		return tracks
+	}
+
+	// the tracks are relayed in both directions, but never back to the node they're published on
+	require.Eventually(t, func() bool {
+		return len(publisherA.Tracks()) == 2 && len(publisherB.Tracks()) == 2 &&
+			len(relayedTracks(roomB, publisherA)) == 2 && len(relayedTracks(roomA, publisherB)) == 2
+	}, 30*time.Second, 100*time.Millisecond)
+
+	require.Empty(t, relayedTracks(roomA, publisherA))
+	require.Empty(t, relayedTracks(roomB, publisherB))
+	require.Len(t, roomA.Relays(), 1)
+	require.Equal(t, "node-b", roomA.Relays()[0].RemoteNode())
+
+	tracksB := relayedTracks(roomB, publisherA)
+	for _, track := range tracksB {
+		require.Equal(t, []string{"node-a"}, roomB.trackPath(track))
+	}
+
+	var packets atomic.Int32
+
+	for _, track := range tracksB {
+		track.OnRead(func(_ interceptor.Attributes, _ *rtp.Packet, _ QualityLevel) {
+			packets.Add(1)
+		})
+	}
+
+	require.Eventually(t, func() bool {
+		return packets.Load() > 10
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// the relayed tracks are kept while reconnecting
+	connMu.Lock()
+	require.NoError(t, current.Close())
+	connMu.Unlock()
+
+	require.Eventually(t, func() bool {
+		return disconnected.Load() == 1 && connected.Load() == 1 && relay.IsConnected()
+	}, 10*time.Second, 50*time.Millisecond)
+
+	for _, track := range tracksB {
+		roomB.sfu.mu.Lock()
+		relayTrack := roomB.sfu.relayTracks[track.ID()]
+		roomB.sfu.mu.Unlock()
+
+		require.True(t, relayTrack == track, "relayed track %s is republished", track.ID())
+	}
+
+	require.Len(t, roomA.Relays(), 1)
+
+	packets.Store(0)
+	require.Eventually(t, func() bool {
+		return packets.Load() > 10
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// the relayed tracks are ended when the publisher left
+	require.NoError(t, roomA.StopClient(publisherA.ID()))
+	require.Eventually(t, func() bool {
+		for _, track := range tracksB {
+			if roomB.sfu.hasRelayTrack(track.ID()) {
+				return false
+			}
+		}
+
+		return true
+	}, 10*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, relay.Close())
+	require.ErrorIs(t, relay.Close(), ErrRelayClosed)
+	require.Empty(t, roomB.Relays())
+
+	// the accepting side closes the relay once the reconnect timeout is passed
+	require.Eventually(t, func() bool {
+		return len(roomA.Relays()) == 0 && len(relayedTracks(roomA, publisherB)) == 0
+	}, 10*time.Second, 50*time.Millisecond)
+
+	_ = roomB.StopClient(publisherB.ID())
+}
+
+func TestRelayCongestionController(t *testing.T) {
+	cc := &relayCongestionController{}
+
+	// no loss, not congested
+	require.Equal(t, uint32(0), cc.update(2_000_000, 2_000_000, 0))
+
+	// the estimation is decreased from the received bitrate on the high loss
+	require.Equal(t, uint32(1_800_000), cc.update(2_000_000, 2_000_000, 0.2))
+	require.Equal(t, uint32(1_620_000), cc.update(2_000_000, 1_900_000, 0.2))
+
+	// hold on the moderate loss
+	require.Equal(t, uint32(1_620_000), cc.update(1_620_000, 1_620_000, 0.05))
+
+	// increased on the low loss
+	require.Equal(t, uint32(1_749_600), cc.update(1_620_000, 1_620_000, 0))
+
+	// never lower than the minimum bitrate
+	require.Equal(t, uint32(relayMinBitrate), cc.update(100_000, 50_000, 0.5))
+
+	// released once the publishers send less than the estimation
+	require.Equal(t, uint32(0), cc.update(50_000, 50_000, 0))
+}
+
+func TestRelayClientLimitPublishers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := NewManager(ctx, "node-a", sfuOpts)
+	defer manager.Close()
+
+	room, err := manager.NewRoom(manager.CreateRoomID(), "room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, room, DefaultTestIceServers(), "publisher", true, false, true)
+
+	relay := newRelayClient(room, "node-a", "node-b", ClientTypeDownBridge, DefaultRelayOptions())
+	defer relay.Close()
+
+	feedback := func(offered uint64, written, dropped uint64, received relayFeedback) {
+		relay.mu.Lock()
+		relay.offeredBytes = map[string]uint64{publisher.ID(): offered}
+		relay.lastFeedback = time.Now().Add(-time.Second)
+		relay.mu.Unlock()
+
+		relay.writtenPackets.Store(written)
+		relay.droppedPackets.Store(dropped)
+
+		relay.onFeedback(received)
+	}
+
+	// a quarter of the packets are dropped in the send queue, the publisher is capped
+	feedback(250_000, 300, 100, relayFeedback{Bitrate: 1_500_000, Packets: 300})
+	require.Greater(t, relay.EstimatedBitrate(), uint32(0))
+	require.Less(t, relay.EstimatedBitrate(), uint32(1_500_000))
+	require.Equal(t, relay.EstimatedBitrate(), publisher.relayUplinkLimit())
+
+	// the cap is removed once the publisher sends less than the estimation
+	feedback(10_000, 100, 0, relayFeedback{Bitrate: 80_000, Packets: 100})
+	require.Equal(t, uint32(0), relay.EstimatedBitrate())
+	require.Equal(t, uint32(0), publisher.relayUplinkLimit())
+
+	_ = room.StopClient(publisher.ID())
+}
+
+func TestRelayStreamConn(t *testing.T) {
+	local, remote := net.Pipe()
+
+	localConn := NewRelayStreamConn(local)
+	remoteConn := NewRelayStreamConn(remote)
+
+	defer localConn.Close()
+	defer remoteConn.Close()
+
+	go func() {
+		_ = localConn.WriteMessage([]byte{relayMessagePing})
+		_ = localConn.WriteMessage([]byte{relayMessageRTP, 1, 2, 3})
+	}()
+
+	data, err := remoteConn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, []byte{relayMessagePing}, data)
+
+	data, err = remoteConn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, []byte{relayMessageRTP, 1, 2, 3}, data)
+
+	require.ErrorIs(t, localConn.WriteMessage(make([]byte, relayMaxMessageSize+1)), ErrRelayMessageTooLarge)
+}
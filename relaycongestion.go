@@ -0,0 +1,191 @@
+package sfu
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const (
+	// the loss thresholds and the rates of the loss based controller of GCC
+	// https://datatracker.ietf.org/doc/html/draft-ietf-rmcat-gcc-02#section-6
+	relayLossHigh       = 0.1
+	relayLossLow        = 0.02
+	relayIncreaseFactor = 1.08
+
+	// the estimation is never lower than this, so the audio and the low layers can still pass through
+	relayMinBitrate = 100_000
+)
+
+// relayFeedback is sent periodically by the receiving side with what it received since the previous feedback
+type relayFeedback struct {
+	Bitrate uint32 `json:"bitrate"`
+	Packets uint32 `json:"packets"`
+}
+
+// relayCongestionController estimates the available bitrate of a relay link from the lost packets,
+// the packets that dropped because the send queue is full are counted as lost.
+type relayCongestionController struct {
+	estimate uint32
+}
+
+// update returns the estimated bitrate in bits per second, 0 means the link is not congested
+func (cc *relayCongestionController) update(sentBps, receivedBps uint32, loss float64) uint32 {
+	switch {
+	case loss > relayLossHigh:
+		base := receivedBps
+		if cc.estimate > 0 && cc.estimate < base {
+			base = cc.estimate
+		}
+
+		cc.estimate = uint32(float64(base) * (1 - 0.5*loss))
+		if cc.estimate < relayMinBitrate {
+			cc.estimate = relayMinBitrate
+		}
+	case loss < relayLossLow && cc.estimate > 0:
+		cc.estimate = uint32(float64(cc.estimate) * relayIncreaseFactor)
+
+		// the publishers send less than the estimation, the link is not limiting them anymore
+		if uint64(cc.estimate) > uint64(sentBps)*3/2 {
+			cc.estimate = 0
+		}
+	}
+
+	return cc.estimate
+}
+
+// EstimatedBitrate returns the estimated available bitrate to the remote node in bits per second,
+// 0 if the relay is not congested
+func (c *RelayClient) EstimatedBitrate() uint32 {
+	return c.estimatedBitrate.Load()
+}
+
+// feedbackLoop sends what received from the remote node, so the remote node can detect the congestion
+func (c *RelayClient) feedbackLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.options.FeedbackInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			elapsed := now.Sub(last).Seconds()
+			last = now
+
+			feedback := relayFeedback{
+				Bitrate: uint32(float64(c.receivedBytes.Swap(0)*8) / elapsed),
+				Packets: uint32(c.receivedPackets.Swap(0)),
+			}
+
+			data, err := json.Marshal(feedback)
+			if err != nil {
+				continue
+			}
+
+			c.enqueue(append([]byte{relayMessageFeedback}, data...))
+		}
+	}
+}
+
+// onFeedback updates the estimated bitrate of the link and caps the publishers of the forwarded tracks if it's congested
+func (c *RelayClient) onFeedback(feedback relayFeedback) {
+	now := time.Now()
+
+	c.mu.Lock()
+	offered := c.offeredBytes
+	c.offeredBytes = make(map[string]uint64)
+	elapsed := now.Sub(c.lastFeedback).Seconds()
+	c.lastFeedback = now
+	c.mu.Unlock()
+
+	written := c.writtenPackets.Swap(0)
+	dropped := c.droppedPackets.Swap(0)
+
+	if elapsed <= 0 {
+		return
+	}
+
+	// the packets in flight are counted on the next feedback, the small difference is below the low loss threshold
+	lost := dropped
+	if written > uint64(feedback.Packets) {
+		lost += written - uint64(feedback.Packets)
+	}
+
+	loss := 0.0
+	if total := written + dropped; total > 0 {
+		loss = float64(lost) / float64(total)
+	}
+
+	total := uint64(0)
+	for _, bytes := range offered {
+		total += bytes
+	}
+
+	c.mu.Lock()
+	estimate := c.congestion.update(uint32(float64(total*8)/elapsed), feedback.Bitrate, loss)
+	c.mu.Unlock()
+
+	if previous := c.estimatedBitrate.Swap(estimate); previous != estimate {
+		c.log.Debugf("relay: estimated bitrate to node %s is %s, loss %.2f", c.remoteNode, ThousandSeparator(int(estimate)), loss)
+	}
+
+	if c.options.LimitPublishers {
+		c.limitPublishers(estimate, offered, total)
+	}
+}
+
+// limitPublishers shares the estimated bitrate to the publishers by what they sent through the relay,
+// the caps are removed once the relay is not congested
+func (c *RelayClient) limitPublishers(estimate uint32, offered map[string]uint64, total uint64) {
+	c.mu.Lock()
+	limited := c.limitedClients
+	c.limitedClients = make(map[string]bool)
+	c.mu.Unlock()
+
+	if estimate > 0 && total > 0 {
+		for clientID, bytes := range offered {
+			client, err := c.room.sfu.GetClient(clientID)
+			if err != nil || client.IsBridge() {
+				continue
+			}
+
+			limit := uint32(uint64(estimate) * bytes / total)
+			if limit < relayMinBitrate {
+				limit = relayMinBitrate
+			}
+
+			client.setRelayUplinkLimit(c.client.ID(), limit)
+
+			delete(limited, clientID)
+
+			c.mu.Lock()
+			c.limitedClients[clientID] = true
+			c.mu.Unlock()
+		}
+	}
+
+	for clientID := range limited {
+		if client, err := c.room.sfu.GetClient(clientID); err == nil {
+			client.setRelayUplinkLimit(c.client.ID(), 0)
+		}
+	}
+}
+
+// resetCongestion removes the caps of the publishers and starts a new estimation, it's called when the connection is changed
+func (c *RelayClient) resetCongestion() {
+	c.mu.Lock()
+	c.congestion = relayCongestionController{}
+	c.lastFeedback = time.Now()
+	c.mu.Unlock()
+
+	c.writtenPackets.Store(0)
+	c.droppedPackets.Store(0)
+	c.receivedBytes.Store(0)
+	c.receivedPackets.Store(0)
+	c.estimatedBitrate.Store(0)
+
+	c.limitPublishers(0, nil, 0)
+}
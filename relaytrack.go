@@ -1,7 +1,8 @@
 package sfu
 
 import (
-	"errors"
+	"io"
+	"os"
 	"sync"
 	"time"
 
@@ -37,6 +38,7 @@ type RelayTrack struct {
 	mimeType    string
 	rid         string
 	rtpChan     chan *rtp.Packet
+	deadline    time.Time
 }
 
 func NewTrackRelay(id, streamid, rid string, kind webrtc.RTPCodecType, ssrc webrtc.SSRC, mimeType string, rtpChan chan *rtp.Packet) IRemoteTrack {
@@ -110,20 +112,53 @@ func (t *RelayTrack) Codec() webrtc.RTPCodecParameters {
 	return getRTPParameters(t.mimeType)
 }
 
-// Read reads data from the track.
+// Read reads the next packet from the channel and marshals it to b, io.EOF is returned once the channel is closed.
 func (t *RelayTrack) Read(b []byte) (n int, attributes interceptor.Attributes, err error) {
-	return 0, nil, errors.New("relaytrack: not implemented, use ReadRTP instead")
+	p, _, err := t.ReadRTP()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	n, err = p.MarshalTo(b)
+
+	return n, nil, err
 }
 
 // ReadRTP is a convenience method that wraps Read and unmarshals for you.
 func (t *RelayTrack) ReadRTP() (*rtp.Packet, interceptor.Attributes, error) {
-	p := <-t.rtpChan
-	return p, nil, nil
+	t.mu.RLock()
+	deadline := t.deadline
+	t.mu.RUnlock()
+
+	var timeout <-chan time.Time
+
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	select {
+	case p, ok := <-t.rtpChan:
+		if !ok {
+			return nil, nil, io.EOF
+		}
+
+		return p, nil, nil
+	case <-timeout:
+		return nil, nil, os.ErrDeadlineExceeded
+	}
 }
 
 // SetReadDeadline sets the max amount of time the RTP stream will block before returning. 0 is forever.
 func (t *RelayTrack) SetReadDeadline(deadline time.Time) error {
-	return errors.New("relaytrack: not implemented")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.deadline = deadline
+
+	return nil
 }
 
 // IsRelay returns true if this track is a relay track
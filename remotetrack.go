@@ -9,6 +9,7 @@ import (
 
 	"sync/atomic"
 
+	"github.com/inlivedev/sfu/pkg/interceptors/avsync"
 	"github.com/inlivedev/sfu/pkg/networkmonitor"
 	"github.com/inlivedev/sfu/pkg/rtppool"
 	"github.com/pion/interceptor"
@@ -28,15 +29,31 @@ type remoteTrack struct {
 	previousBytesReceived *atomic.Uint64
 	currentBytesReceived  *atomic.Uint64
 	latestUpdatedTS       *atomic.Uint64
-	lastPLIRequestTime    time.Time
+	heartbeat             *atomic.Int64
 	onEndedCallbacks      []func()
 	statsGetter           stats.Getter
 	onStatsUpdated        func(*stats.Stats)
 	log                   logging.LeveledLogger
+	hotPathLog            logging.LeveledLogger
 	rtppool               *rtppool.RTPPool
+	// nil if the packets are forwarded as they're received
+	jitterBuffer *JitterBuffer
+	// estimates the capture time of the received packets for the A/V sync of the subscribers
+	captureClock *avsync.CaptureClock
+	// the start of the current bitrate window, only used by the read loop
+	bitrateWindowStart time.Time
+	// the read packets are forwarded by the fan-out worker if it's set, see Options.FanOutWorkers
+	readQueue *remoteReadQueue
+	// the remote track of the resumed client that continues this track, see Room.ResumeClient
+	next atomic.Pointer[remoteTrack]
+	// the sequence number and the timestamp of the last forwarded packet, the next remote track continues after it
+	lastPacket     atomic.Uint64
+	lastPacketTime atomic.Int64
+	// rewrites the packets to continue after the last packet of the previous remote track, nil if there is none
+	munger *RTPMunger
 }
 
-func newRemoteTrack(ctx context.Context, log logging.LeveledLogger, useBuffer bool, track IRemoteTrack, minWait, maxWait, pliInterval time.Duration, onPLI func(), statsGetter stats.Getter, onStatsUpdated func(*stats.Stats), onRead func(interceptor.Attributes, *rtp.Packet), pool *rtppool.RTPPool, onNetworkConditionChanged func(networkmonitor.NetworkConditionType)) *remoteTrack {
+func newRemoteTrack(ctx context.Context, log logging.LeveledLogger, useBuffer bool, track IRemoteTrack, minWait, maxWait, pliInterval time.Duration, onPLI func(), statsGetter stats.Getter, onStatsUpdated func(*stats.Stats), onRead func(interceptor.Attributes, *rtp.Packet), pool *rtppool.RTPPool, onNetworkConditionChanged func(networkmonitor.NetworkConditionType), scheduler *fanOutScheduler, previous *remoteTrack) *remoteTrack {
 	localctx, cancel := context.WithCancel(ctx)
 
 	rt := &remoteTrack{
@@ -48,19 +65,34 @@ func newRemoteTrack(ctx context.Context, log logging.LeveledLogger, useBuffer bo
 		previousBytesReceived: &atomic.Uint64{},
 		currentBytesReceived:  &atomic.Uint64{},
 		latestUpdatedTS:       &atomic.Uint64{},
+		heartbeat:             &atomic.Int64{},
 		onEndedCallbacks:      make([]func(), 0),
 		statsGetter:           statsGetter,
 		onStatsUpdated:        onStatsUpdated,
 		onPLI:                 onPLI,
 		onRead:                onRead,
 		log:                   log,
+		hotPathLog:            newHotPathLogger(log),
 		rtppool:               pool,
 	}
 
+	if useBuffer && !rt.IsRelay() {
+		rt.jitterBuffer = NewJitterBuffer(track.Codec().ClockRate, minWait, maxWait)
+	}
+
 	if pliInterval > 0 {
 		rt.enableIntervalPLI(pliInterval)
 	}
 
+	if scheduler != nil {
+		rt.readQueue = newRemoteReadQueue(scheduler, rt)
+	}
+
+	if previous != nil {
+		rt.munger = previous.continuation(track.Codec().ClockRate)
+		previous.next.Store(rt)
+	}
+
 	go rt.readRTP()
 
 	return rt
@@ -84,13 +116,30 @@ func (t *remoteTrack) readRTP() {
 		case <-readCtx.Done():
 			return
 		default:
-			if err := t.track.SetReadDeadline(time.Now().Add(1 * time.Second)); err != nil {
+			// the loop wakes up at least every second, see isStuck. The heartbeat is updated by the worker that forwards
+			// the packets if the read queue is used
+			if t.readQueue == nil {
+				t.heartbeat.Store(time.Now().UnixNano())
+			}
+
+			deadline := time.Now().Add(1 * time.Second)
+			if t.jitterBuffer != nil {
+				// wake up to forward the held packets once their missing packets are waited long enough
+				if expired, ok := t.jitterBuffer.Deadline(); ok && expired.Before(deadline) {
+					deadline = expired
+				}
+			}
+
+			if err := t.track.SetReadDeadline(deadline); err != nil {
 				t.log.Errorf("remotetrack: set read deadline error - %s", err.Error())
 				return
 			}
 			buffer := t.rtppool.GetPayload()
 
 			n, attrs, readErr := t.track.Read(*buffer)
+
+			t.measureBitrate(n, time.Now())
+
 			if readErr != nil {
 				if readErr == io.EOF {
 					t.log.Infof("remotetrack: track ended %s ", t.track.ID())
@@ -100,34 +149,128 @@ func (t *remoteTrack) readRTP() {
 
 				t.log.Tracef("remotetrack: read error: %s", readErr.Error())
 				t.rtppool.PutPayload(buffer)
+				t.idle()
 				continue
 			}
 
 			// could be read deadline reached
 			if n == 0 {
 				t.rtppool.PutPayload(buffer)
+				t.idle()
 				continue
 			}
 
-			p := t.rtppool.GetPacket()
-
-			if err := t.unmarshal((*buffer)[:n], p); err != nil {
-				t.log.Errorf("remotetrack: unmarshal error: %s", err.Error())
-				t.rtppool.PutPayload(buffer)
-				t.rtppool.PutPacket(p)
+			if t.readQueue != nil {
+				t.readQueue.push(buffer, n, attrs)
 				continue
 			}
 
-			if !t.IsRelay() {
-				go t.updateStats()
-			}
+			t.process(buffer, n, attrs)
+		}
+	}
+}
 
-			t.onRead(attrs, p)
+// process forwards the packet that read into the buffer, the buffer is returned to the pool
+func (t *remoteTrack) process(buffer *[]byte, n int, attrs interceptor.Attributes) {
+	p := t.rtppool.GetPacket()
 
-			t.rtppool.PutPayload(buffer)
-			t.rtppool.PutPacket(p)
-		}
+	if err := t.unmarshal((*buffer)[:n], p); err != nil {
+		t.log.Errorf("remotetrack: unmarshal error: %s", err.Error())
+		t.rtppool.PutPayload(buffer)
+		t.rtppool.PutPacket(p)
+		return
+	}
+
+	if !t.IsRelay() {
+		go t.updateStats()
+	}
+
+	if t.jitterBuffer != nil {
+		t.jitterBuffer.Push(attrs, p, t.forward)
+	} else {
+		t.forward(attrs, p)
+	}
+
+	t.rtppool.PutPayload(buffer)
+	t.rtppool.PutPacket(p)
+}
+
+// forward passes the packet to the track, the packet is rewritten first if this track continues a previous track
+func (t *remoteTrack) forward(attrs interceptor.Attributes, p *rtp.Packet) {
+	if t.munger != nil && !t.munger.Rewrite(p) {
+		return
+	}
+
+	t.lastPacket.Store(uint64(p.SequenceNumber)<<32 | uint64(p.Timestamp))
+	t.lastPacketTime.Store(time.Now().UnixNano())
+
+	t.onRead(attrs, p)
+}
+
+// continuation returns the munger of the next remote track that continues after the last forwarded packet, so the
+// subscribers see one RTP stream
+func (t *remoteTrack) continuation(clockRate uint32) *RTPMunger {
+	munger := NewRTPMunger(clockRate)
+
+	if lastTime := t.lastPacketTime.Load(); lastTime != 0 {
+		last := t.lastPacket.Load()
+		munger.Continue(uint16(last>>32), uint32(last), time.Unix(0, lastTime))
+	}
+
+	return munger
+}
+
+// current returns the remote track that receives the packets now, it's the remote track of the resumed client once
+// the track is taken over, see Room.ResumeClient
+func (t *remoteTrack) current() *remoteTrack {
+	for next := t.next.Load(); next != nil; next = t.next.Load() {
+		t = next
+	}
+
+	return t
+}
+
+// takenOver returns true if the track is continued by the remote track of the resumed client
+func (t *remoteTrack) takenOver() bool {
+	return t.next.Load() != nil
+}
+
+// idle is called when the read loop wakes up without a packet, the buffered packets that waited long enough are
+// forwarded by the same goroutine that forwards the read packets
+func (t *remoteTrack) idle() {
+	if t.readQueue != nil {
+		t.readQueue.push(nil, 0, nil)
+		return
+	}
+
+	t.expireBuffered()
+}
+
+// isStuck returns true if the read loop is blocked longer than the timeout, for example by a callback that never returns
+func (t *remoteTrack) isStuck(now time.Time, timeout time.Duration) (time.Time, bool) {
+	heartbeat := t.heartbeat.Load()
+	if heartbeat == 0 || t.context.Err() != nil {
+		return time.Time{}, false
+	}
+
+	since := time.Unix(0, heartbeat)
+
+	return since, now.Sub(since) > timeout
+}
+
+func (t *remoteTrack) expireBuffered() {
+	if t.jitterBuffer != nil {
+		t.jitterBuffer.Expire(t.forward)
+	}
+}
+
+// JitterBufferStats returns the stats of the jitter buffer, false if the jitter buffer is disabled
+func (t *remoteTrack) JitterBufferStats() (JitterBufferStats, bool) {
+	if t.jitterBuffer == nil {
+		return JitterBufferStats{}, false
 	}
+
+	return t.jitterBuffer.Stats(), true
 }
 
 func (t *remoteTrack) unmarshal(buf []byte, p *rtp.Packet) error {
@@ -153,7 +296,7 @@ func (t *remoteTrack) unmarshal(buf []byte, p *rtp.Packet) error {
 func (t *remoteTrack) updateStats() {
 	s := t.statsGetter.Get(uint32(t.track.SSRC()))
 	if s == nil {
-		t.log.Warnf("remotetrack: stats not found for track: ", t.track.SSRC())
+		t.hotPathLog.Warnf("remotetrack: stats not found for track: ", t.track.SSRC())
 		return
 	}
 
@@ -175,25 +318,38 @@ func (t *remoteTrack) updateStats() {
 	}
 }
 
-func (t *remoteTrack) Track() IRemoteTrack {
-	return t.track
-}
-
-func (t *remoteTrack) SendPLI() {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+// measureBitrate counts the received bytes, the bitrate is updated every second. It's also called when the read
+// deadline is reached, so the bitrate drops to zero once the publisher stops sending.
+func (t *remoteTrack) measureBitrate(n int, now time.Time) {
+	t.currentBytesReceived.Add(uint64(n))
 
-	// return if there is a pending PLI request
-	maxGapSeconds := 250 * time.Millisecond
-	requestGap := time.Since(t.lastPLIRequestTime)
+	if t.bitrateWindowStart.IsZero() {
+		t.bitrateWindowStart = now
+		return
+	}
 
-	if requestGap < maxGapSeconds {
-		return // ignore PLI request
+	elapsed := now.Sub(t.bitrateWindowStart)
+	if elapsed < bitrateWindow {
+		return
 	}
 
-	t.lastPLIRequestTime = time.Now()
+	bytes := t.currentBytesReceived.Swap(0)
+	t.bitrate.Store(uint32(float64(bytes*8) / elapsed.Seconds()))
+	t.bitrateWindowStart = now
+}
+
+// Bitrate returns the received bitrate in bits per second, measured over the last second
+func (t *remoteTrack) Bitrate() uint32 {
+	return t.current().bitrate.Load()
+}
 
-	go t.onPLI()
+func (t *remoteTrack) Track() IRemoteTrack {
+	return t.current().track
+}
+
+// SendPLI requests a keyframe from the publisher, the requests are coalesced by the SFU PLI aggregator
+func (t *remoteTrack) SendPLI() {
+	go t.current().onPLI()
 }
 
 func (t *remoteTrack) enableIntervalPLI(interval time.Duration) {
@@ -0,0 +1,221 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// ClientRole is the permission of the client in the room
+type ClientRole string
+
+const (
+	// ClientRolePublisher can publish and subscribe the tracks, it's the default role
+	ClientRolePublisher ClientRole = "publisher"
+	// ClientRoleSubscriber can only subscribe the tracks, the published tracks are rejected
+	ClientRoleSubscriber ClientRole = "subscriber"
+	// ClientRoleModerator can publish, subscribe and moderate the other clients with Room.ForceMute and Room.ModeratorKickClient
+	ClientRoleModerator ClientRole = "moderator"
+)
+
+var (
+	ErrPublishNotAllowed = errors.New("client: error the client role is not allowed to publish")
+	ErrNotModerator      = errors.New("room: error the client is not a moderator")
+	ErrSourceNotAllowed  = errors.New("client: error the track source is not allowed to publish")
+)
+
+var msidRegex = regexp.MustCompile(`(?m)^a=msid:`)
+
+// PublishRejectedError is passed to the OnPublishRejected callbacks when a client that not allowed to publish offers
+// the media to send, the media sections are answered as inactive or recvonly
+type PublishRejectedError struct {
+	ClientID string
+	Role     ClientRole
+	// the mids of the rejected media sections
+	Mids []string
+}
+
+func (e *PublishRejectedError) Error() string {
+	return fmt.Sprintf("%s: client %s with role %s, mids %v", ErrPublishNotAllowed.Error(), e.ClientID, e.Role, e.Mids)
+}
+
+func (e *PublishRejectedError) Unwrap() error {
+	return ErrPublishNotAllowed
+}
+
+type forceMuted struct {
+	Kind  string `json:"kind"`
+	Muted bool   `json:"muted"`
+}
+
+type internalDataForceMuted struct {
+	Type string     `json:"type"`
+	Data forceMuted `json:"data"`
+}
+
+// rejectPublishSDP removes the send direction of the remote peer from the audio and video media sections,
+// it returns the munged SDP and the mids of the sections that have a track to send.
+func rejectPublishSDP(sdp string) (string, []string) {
+	lines := strings.Split(sdp, "\r\n")
+	mids := make([]string, 0)
+
+	// the media section is from the m= line until the next one
+	start := -1
+	for i := 0; i <= len(lines); i++ {
+		if i < len(lines) && !strings.HasPrefix(lines[i], "m=") {
+			continue
+		}
+
+		if start >= 0 && (strings.HasPrefix(lines[start], "m=audio") || strings.HasPrefix(lines[start], "m=video")) {
+			if mid, rejected := rejectPublishSection(lines[start:i]); rejected {
+				mids = append(mids, mid)
+			}
+		}
+
+		start = i
+	}
+
+	return strings.Join(lines, "\r\n"), mids
+}
+
+func rejectPublishSection(lines []string) (string, bool) {
+	mid := ""
+	sending := false
+
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "a=mid:"):
+			mid = strings.TrimPrefix(line, "a=mid:")
+		case line == "a=sendrecv":
+			lines[i] = "a=recvonly"
+			sending = true
+		case line == "a=sendonly":
+			lines[i] = "a=inactive"
+			sending = true
+		}
+	}
+
+	// a transceiver without a track is also offered as sendrecv, only count the section that has a track
+	hasTrack := msidRegex.MatchString(strings.Join(lines, "\n"))
+
+	return mid, sending && hasTrack
+}
+
+// Role returns the client role, ClientRolePublisher if it's not set
+func (c *Client) Role() ClientRole {
+	if c.options.Role == "" {
+		return ClientRolePublisher
+	}
+
+	return c.options.Role
+}
+
+func (c *Client) canPublish() bool {
+	return c.Role() != ClientRoleSubscriber
+}
+
+func (c *Client) canPublishSource(source TrackType) bool {
+	if len(c.options.AllowedSources) == 0 || slices.Contains(c.options.AllowedSources, source) {
+		return true
+	}
+
+	// a camera is a media source
+	return source == TrackTypeCamera && slices.Contains(c.options.AllowedSources, TrackTypeMedia)
+}
+
+// OnPublishRejected event is called when the client offers the media to send but the client role is not allowed to publish.
+// The error is a *PublishRejectedError that wraps ErrPublishNotAllowed.
+func (c *Client) OnPublishRejected(callback func(err error)) {
+	c.muCallback.Lock()
+	defer c.muCallback.Unlock()
+
+	c.onPublishRejectedCallbacks = append(c.onPublishRejectedCallbacks, callback)
+}
+
+func (c *Client) onPublishRejected(err error) {
+	c.muCallback.Lock()
+	callbacks := append([]func(error){}, c.onPublishRejectedCallbacks...)
+	c.muCallback.Unlock()
+
+	for _, callback := range callbacks {
+		callback(err)
+	}
+}
+
+// ForceMute stops or resumes forwarding the published tracks of the kind to the subscribers, including the tracks
+// that published later. The client is notified with the force_muted internal message so it can update the UI.
+func (c *Client) ForceMute(kind webrtc.RTPCodecType, muted bool) {
+	if muted {
+		if _, loaded := c.forceMuted.LoadOrStore(kind, true); loaded {
+			return
+		}
+	} else {
+		if _, loaded := c.forceMuted.LoadAndDelete(kind); !loaded {
+			return
+		}
+
+		// the subscribers need a keyframe to decode the video again
+		for _, track := range c.Tracks() {
+			if kind == webrtc.RTPCodecTypeVideo && track.Kind() == kind {
+				requestKeyframe(track)
+			}
+		}
+	}
+
+	data, err := json.Marshal(internalDataForceMuted{
+		Type: messageTypeForceMuted,
+		Data: forceMuted{Kind: kind.String(), Muted: muted},
+	})
+	if err != nil {
+		c.log.Errorf("client: error marshal force muted ", err)
+		return
+	}
+
+	c.sendInternalMessage(data)
+}
+
+// IsForceMuted returns true if the published tracks of the kind is muted with ForceMute
+func (c *Client) IsForceMuted(kind webrtc.RTPCodecType) bool {
+	_, ok := c.forceMuted.Load(kind)
+	return ok
+}
+
+// ForceMute mutes or unmutes the published tracks of the kind of a client, the moderatorID must be a client in the room
+// with ClientRoleModerator.
+func (r *Room) ForceMute(moderatorID, clientID string, kind webrtc.RTPCodecType, muted bool) error {
+	client, err := r.moderatedClient(moderatorID, clientID)
+	if err != nil {
+		return err
+	}
+
+	client.ForceMute(kind, muted)
+
+	return nil
+}
+
+// ModeratorKickClient is the same as KickClient, the moderatorID must be a client in the room with ClientRoleModerator.
+func (r *Room) ModeratorKickClient(moderatorID, clientID, reason string) error {
+	if _, err := r.moderatedClient(moderatorID, clientID); err != nil {
+		return err
+	}
+
+	return r.KickClient(clientID, reason)
+}
+
+func (r *Room) moderatedClient(moderatorID, clientID string) (*Client, error) {
+	moderator, err := r.sfu.GetClient(moderatorID)
+	if err != nil {
+		return nil, err
+	}
+
+	if moderator.Role() != ClientRoleModerator {
+		return nil, ErrNotModerator
+	}
+
+	return r.sfu.GetClient(clientID)
+}
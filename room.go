@@ -2,10 +2,14 @@ package sfu
 
 import (
 	"context"
+	"encoding/json"
+	"slices"
 	"sync"
 	"time"
 
+	"github.com/pion/logging"
 	"github.com/pion/webrtc/v4"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -24,6 +28,24 @@ type Options struct {
 	// SettingEngine is used to configure the WebRTC engine
 	// Use this to configure use of enable/disable mDNS, network types, use single port mux, etc.
 	SettingEngine *webrtc.SettingEngine
+	// TracerProvider is used to create the OpenTelemetry spans of the client signaling,
+	// the global tracer provider is used if not set
+	TracerProvider trace.TracerProvider
+	// EventSink receives the lifecycle events of all rooms, like the room created, the client joined or the track published.
+	// Use NewWebhookSink to send the events to an HTTP endpoint
+	EventSink EventSink
+	// ICEServersProvider returns the ICE servers of each client instead of IceServers, it's called when the client is added
+	// and when the ICE is restarted. Use NewTURNCredentialsProvider to generate the time-limited TURN credentials
+	ICEServersProvider ICEServersProvider
+	// LoggerFactory creates the loggers of the manager and the rooms, the pion default logger factory is used if not set.
+	// Use logger.NewFactory for the structured logs with the room_id, client_id and track_id fields and the levels
+	// that can be changed at runtime
+	LoggerFactory logging.LoggerFactory
+	// FanOutWorkers is the number of the workers that forward the read packets of the published tracks and write the packets
+	// to the subscribers of all rooms, the tracks are sharded to the workers by their SSRC. Default is 0 means every track is
+	// processed by its own goroutine, which is thousands of goroutines in a large room. Set it to runtime.GOMAXPROCS(0) to
+	// process them with a worker per CPU instead
+	FanOutWorkers int
 }
 
 func DefaultOptions() Options {
@@ -69,6 +91,31 @@ type Room struct {
 	extensions              []IExtension
 	OnEvent                 func(event Event)
 	options                 RoomOptions
+	recorder                *Recorder
+	forwardingPolicy        *ForwardingPolicy
+	bitrateAllocator        *bitrateAllocator
+	speakers                *speakerDetector
+	cascades                map[string]*Cascade
+	rtpCascades             map[string]*RTPCascade
+	relays                  map[string]*RelayClient
+	banList                 BanList
+	eventSink               EventSink
+	sessions                *clientSessionList
+	onResumedCallbacks      []func(*Client)
+	analytics               *analytics
+	onStateChangedCallbacks []func(RoomStateDiff)
+	onWaitingCallbacks      []func(*Client)
+	onClosingCallbacks      []func(CloseReason, time.Duration)
+	onRoomFullCallbacks     []func(clientID string)
+	freezes                 *freezeDetector
+	onFreezeCallbacks       []func(FreezeEvent)
+	onRecoverCallbacks      []func(FreezeEvent)
+	recordingBuffers        map[string]*recordingBuffer
+	audioSinks              []*AudioSinkHook
+	// guards the admission check and the admitting count, see Room.admitClient
+	admitMu sync.Mutex
+	// the number of the clients that admitted but not added to the room yet, they are counted as the room clients
+	admitting int
 }
 
 type RoomOptions struct {
@@ -76,54 +123,232 @@ type RoomOptions struct {
 	// Make sure to use the same bitrate config when publishing video because this is used to manage the usage bandwidth in this room
 	Bitrates BitrateConfigs `json:"bitrates,omitempty"`
 	// Configures the codecs that will be used by the room
-	Codecs *[]string `json:"codecs,omitempty" enums:"video/VP9,video/H264,video/VP8,audio/red,audio/opus" example:"video/VP9,video/H264,video/VP8,audio/red,audio/opus"`
+	Codecs *[]string `json:"codecs,omitempty" enums:"video/VP9,video/H264,video/VP8,audio/red,audio/opus,audio/PCMU,audio/PCMA" example:"video/VP9,video/H264,video/VP8,audio/red,audio/opus"`
 	// Configures the interval in nanoseconds of sending PLIs to clients that will generate keyframe, default is 0 means it will use auto PLI request only when needed.
 	// More often means more bandwidth usage but more stability on video quality when packet loss, but client libs supposed to request PLI automatically when needed.
 	PLIInterval *time.Duration `json:"pli_interval_ns,omitempty" example:"0"`
+	// Configures the window in nanoseconds to coalesce the keyframe requests to the same publisher track, the requests in the window
+	// are merged into a single PLI so a burst of joining clients doesn't flood the publisher. Default is 250 milliseconds, set to 0 to disable it
+	PLIWindow *time.Duration `json:"pli_window_ns,omitempty" example:"250000000" default:"250000000"`
 	// Configure the mapping of spatsial and temporal layers to quality level
 	// Use this to use scalable video coding (SVC) to control the bitrate level of the video
 	QualityLevels []QualityLevel `json:"quality_levels,omitempty"`
 	// Configure the timeout in nanonseconds when the room is empty it will close after the timeout exceeded. Default is 5 minutes
 	EmptyRoomTimeout *time.Duration `json:"empty_room_timeout_ns,ompitempty" example:"300000000000" default:"300000000000"`
+	// Mark the room as end-to-end encrypted, all clients are E2EE and the features that require the media payload
+	// like recording are disabled with ErrE2EENotSupported
+	E2EE bool `json:"e2ee,omitempty"`
+	// Configure the interval in nanoseconds of broadcasting the audio levels of the speaking clients to all clients through the internal data channel.
+	// Default is 200 milliseconds, set to 0 to disable it
+	AudioLevelInterval *time.Duration `json:"audio_level_interval_ns,omitempty" example:"200000000" default:"200000000"`
+	// Configure the interval in nanoseconds of the active speaker ranking, the audio levels are from the clients that enable the voice detection. Default is 500 milliseconds
+	ActiveSpeakerInterval *time.Duration `json:"active_speaker_interval_ns,omitempty" example:"500000000" default:"500000000"`
+	// Configure the total downlink bitrate in bits per second of each client that shared by the subscribed video tracks,
+	// the screen share and the active speaker tracks get the bigger share. Default is nil means each track quality is adjusted
+	// independently by the estimated bandwidth, set to 0 to share the estimated bandwidth without a fixed budget
+	DownlinkBitrateBudget *uint32 `json:"downlink_bitrate_budget,omitempty" example:"2500000"`
+	// Configure the maximum size in bytes of the room and client metadata that set with SetMetadata. Default is 64KB
+	MaxMetadataSize *int `json:"max_metadata_size,omitempty" example:"65536" default:"65536"`
+	// Configure the ban list that checked when a client is added to the room, implement it to keep the bans in a database.
+	// Default is nil means the bans are kept in the memory until the room is closed
+	BanList BanList `json:"-"`
+	// Configure the time in nanoseconds that a client can resume the session with Room.ResumeClient after the transport is lost,
+	// the other clients don't get the client left event in the grace period. Default is nil means the client can't be resumed
+	ReconnectGracePeriod *time.Duration `json:"reconnect_grace_period_ns,omitempty" example:"30000000000"`
+	// Disable the jitter buffer of all clients in the room even if the client enables ReorderPackets, the packets are forwarded
+	// as they're received. Use it for the low-latency room, the subscribers handle the reordered packets themselves
+	DisableJitterBuffer bool `json:"disable_jitter_buffer,omitempty"`
+	// Configure the playout delay in milliseconds that sent to all subscribers in the room, it overrides the playout delay of the client options.
+	// Use 0 for the low-latency room like an auction, or the bigger delay for a webinar. Default is nil means the client options are used
+	PlayoutDelay *PlayoutDelay `json:"playout_delay,omitempty"`
+	// Configure the interval in nanoseconds of the bitrate samples in the analytics timeline, see Room.Analytics.
+	// Default is 5 seconds, set to 0 to disable the analytics
+	AnalyticsInterval *time.Duration `json:"analytics_interval_ns,omitempty" example:"5000000000" default:"5000000000"`
+	// CapUnusedLayers caps the uplink of the simulcast publishers with REMB to the layers that needed by the subscribers,
+	// the high layer is not sent while no subscriber receives it. The layers are needed again once a subscriber selects them
+	CapUnusedLayers bool `json:"cap_unused_layers,omitempty"`
+	// PauseUnusedLayers tells the simulcast publishers with the simulcast_layers_paused data channel message to stop sending
+	// the layers that no subscriber needs for a few seconds, and to send them again as soon as a subscriber selects them
+	PauseUnusedLayers bool `json:"pause_unused_layers,omitempty"`
+	// PauseUnsubscribedVideo stops forwarding a published video track after it has no subscriber for 2 seconds, the publisher
+	// is told with the publish_paused data channel message that it can stop sending the track. The track is resumed with
+	// a keyframe request as soon as it's subscribed.
+	PauseUnsubscribedVideo bool `json:"pause_unsubscribed_video,omitempty"`
+	// ScreenProfile configures the quality presets, the minimum bitrate, and the encoding hints of the screen share tracks.
+	// Default is nil means DefaultScreenProfile is used
+	ScreenProfile *ScreenProfile `json:"screen_profile,omitempty"`
+	// PublishConstraints restricts what the clients of each role can publish: the number of the video and audio tracks,
+	// the codecs, the video resolution, and the uplink bitrate. The violations are reported with OnPublishRejected and
+	// the publish_rejected data channel message. Default is nil means the publishers are not restricted
+	PublishConstraints map[ClientRole]PublishConstraints `json:"publish_constraints,omitempty"`
+	// PreferCodecsInOrder registers the Codecs in the order of the list to the media engine of each client, the first video
+	// and audio codec is preferred when the publishers are answered and the tracks are offered to the subscribers. For example
+	// VP9 then VP8 without H264 for the SVC rooms. Default is false means the SFU order: VP8, H264, VP9, then red, opus, PCMU, PCMA
+	PreferCodecsInOrder bool `json:"prefer_codecs_in_order,omitempty"`
+	// HeaderExtensions selects the RTP header extensions that negotiated with the clients, a published track that negotiated
+	// without an expected extension is reported with Client.OnHeaderExtensionMissing. Default is nil means DefaultHeaderExtensions
+	HeaderExtensions *HeaderExtensions `json:"header_extensions,omitempty"`
+	// TrackCatalog sends the track_catalog data channel message with all published tracks to the clients, it's sent when
+	// the client connects and again when a track is published or ended, the video dimensions change, or a publisher metadata
+	// is changed. Default is false means the signaling announces the tracks from the OnTracksAvailable event
+	TrackCatalog bool `json:"track_catalog,omitempty"`
+	// StateDiffInterval is the interval in nanoseconds that the room state is compared with the previous state, the changes
+	// are passed to the Room.OnStateChanged callbacks. Default is nil means the state changes are not streamed
+	StateDiffInterval *time.Duration `json:"state_diff_interval_ns,omitempty" example:"1000000000"`
+	// AuthorizeExternalSubscriber is called when a client of another room subscribes to the tracks of this room with
+	// Client.SubscribeRoomTracks, return an error to reject it. Default is nil means the external subscriptions are not allowed
+	AuthorizeExternalSubscriber func(client *Client, req []SubscribeTrackRequest) error `json:"-"`
+	// Lobby makes the clients wait after connected until a moderator admits them with Room.Admit, the waiting clients
+	// don't receive the tracks of the room and their tracks are not announced. The moderators and the resumed clients
+	// join without waiting. Use Room.OnClientWaiting to get notified when a client is waiting
+	Lobby bool `json:"lobby,omitempty"`
+	// MaxDuration is the time in nanoseconds since the room is created that the room is closed, the clients are warned
+	// CloseWarning before with Room.OnClosing and the room_closing data channel message. Default is nil means no limit
+	MaxDuration *time.Duration `json:"max_duration_ns,omitempty" example:"3600000000000"`
+	// CloseWarning is the time in nanoseconds before the MaxDuration is reached that the room closing is announced. Default is 1 minute
+	CloseWarning *time.Duration `json:"close_warning_ns,omitempty" example:"60000000000" default:"60000000000"`
+	// MaxClients is the number of the clients that can be in the room, AddClient, MoveClient, IngestRTP and BridgeSIP
+	// return ErrRoomIsFull and call the Room.OnRoomFull callbacks when it's reached. Default is 0 means no limit
+	MaxClients int `json:"max_clients,omitempty" example:"50"`
+	// FreezeThreshold is the time in nanoseconds without a packet that a published video layer or a subscribed video
+	// track is frozen, the freezes are passed to Room.OnFreeze and Room.OnRecover. Default is nil means the freezes are not detected
+	FreezeThreshold *time.Duration `json:"freeze_threshold_ns,omitempty" example:"500000000"`
+	// QualityStrategy selects the quality of the subscribed video tracks of each client from the estimated bandwidth, the
+	// layer bitrates, the track priority, and the rendered size that reported by the client. Setting it enables the room
+	// bitrate allocation like DownlinkBitrateBudget. Default is nil means DefaultQualityStrategy when the budget is set
+	QualityStrategy QualityStrategy `json:"-"`
+	// RecordingBuffer keeps the packets of every published track from the last duration in nanoseconds in the memory,
+	// so Room.StartRecording includes what happened before the recording is started, like for an incident capture or
+	// a highlight clip. The video buffer starts from a keyframe, so it can be longer. Default is nil means no buffer
+	RecordingBuffer *time.Duration `json:"recording_buffer_ns,omitempty" example:"10000000000"`
+	// StatsHistory keeps the stats samples of every published track, queryable with Track.StatsHistory to graph them
+	// without collecting the stats from Room.OnStatsUpdated, the zero fields use the defaults. Default is nil means no history
+	StatsHistory *StatsHistoryOptions `json:"stats_history,omitempty"`
 }
 
 func DefaultRoomOptions() RoomOptions {
 	pli := time.Duration(0)
+	pliWindow := defaultPLIWindow
 	emptyDuration := time.Duration(3) * time.Minute
+	speakerInterval := 500 * time.Millisecond
+	audioLevelInterval := 200 * time.Millisecond
+	analyticsInterval := defaultAnalyticsInterval
 	return RoomOptions{
-		Bitrates:         DefaultBitrates(),
-		QualityLevels:    DefaultQualityLevels(),
-		Codecs:           &[]string{webrtc.MimeTypeVP9, webrtc.MimeTypeH264, webrtc.MimeTypeVP8, "audio/red", webrtc.MimeTypeOpus},
-		PLIInterval:      &pli,
-		EmptyRoomTimeout: &emptyDuration,
+		Bitrates:              DefaultBitrates(),
+		QualityLevels:         DefaultQualityLevels(),
+		Codecs:                &[]string{webrtc.MimeTypeVP9, webrtc.MimeTypeH264, webrtc.MimeTypeVP8, "audio/red", webrtc.MimeTypeOpus},
+		PLIInterval:           &pli,
+		PLIWindow:             &pliWindow,
+		EmptyRoomTimeout:      &emptyDuration,
+		ActiveSpeakerInterval: &speakerInterval,
+		AudioLevelInterval:    &audioLevelInterval,
+		AnalyticsInterval:     &analyticsInterval,
 	}
 }
 
 func newRoom(id, name string, sfu *SFU, kind string, opts RoomOptions) *Room {
 	localContext, cancel := context.WithCancel(sfu.context)
 
+	speakerInterval := 500 * time.Millisecond
+	if opts.ActiveSpeakerInterval != nil && *opts.ActiveSpeakerInterval > 0 {
+		speakerInterval = *opts.ActiveSpeakerInterval
+	}
+
 	room := &Room{
-		id:         id,
-		context:    localContext,
-		cancel:     cancel,
-		sfu:        sfu,
-		token:      GenerateID(21),
-		stats:      make(map[string]*TrackStats),
-		state:      StateRoomOpen,
-		name:       name,
-		mu:         &sync.RWMutex{},
-		meta:       NewMetadata(),
-		extensions: make([]IExtension, 0),
-		kind:       kind,
-		options:    opts,
+		id:          id,
+		context:     localContext,
+		cancel:      cancel,
+		sfu:         sfu,
+		token:       GenerateID(21),
+		stats:       make(map[string]*TrackStats),
+		state:       StateRoomOpen,
+		name:        name,
+		mu:          &sync.RWMutex{},
+		meta:        NewMetadata(),
+		extensions:  make([]IExtension, 0),
+		kind:        kind,
+		options:     opts,
+		cascades:    make(map[string]*Cascade),
+		rtpCascades: make(map[string]*RTPCascade),
+		relays:      make(map[string]*RelayClient),
+		speakers:    newSpeakerDetector(speakerInterval),
+		sessions:    newClientSessionList(),
+	}
+
+	room.bitrateAllocator = newBitrateAllocator(room, opts.DownlinkBitrateBudget, opts.QualityStrategy)
+
+	if opts.AnalyticsInterval == nil {
+		room.analytics = newAnalytics(defaultAnalyticsInterval)
+	} else if *opts.AnalyticsInterval > 0 {
+		room.analytics = newAnalytics(*opts.AnalyticsInterval)
+	}
+
+	room.banList = opts.BanList
+	if room.banList == nil {
+		room.banList = NewMemoryBanList()
+	}
+
+	if opts.MaxMetadataSize != nil && *opts.MaxMetadataSize > 0 {
+		sfu.maxMetadataSize = *opts.MaxMetadataSize
 	}
 
 	sfu.OnClientRemoved(func(client *Client) {
+		room.speakers.removeClient(client.ID())
+
+		if room.suspendClient(client) {
+			return
+		}
+
 		room.onClientLeft(client)
 	})
 
+	sfu.OnTracksAvailable(func(tracks []ITrack) {
+		room.speakers.addTracks(tracks)
+		room.emitTracksPublished(tracks)
+		room.bufferTracks(tracks)
+
+		room.mu.RLock()
+		recorder := room.recorder
+		room.mu.RUnlock()
+
+		if recorder != nil {
+			recorder.addTracks(tracks)
+		}
+
+		room.mu.RLock()
+		audioSinks := slices.Clone(room.audioSinks)
+		room.mu.RUnlock()
+
+		for _, hook := range audioSinks {
+			hook.addTracks(tracks)
+		}
+	})
+
 	go room.loopRecordStats()
 
+	go room.loopSpeakerRanking()
+
+	if opts.AudioLevelInterval != nil && *opts.AudioLevelInterval > 0 {
+		go room.loopAudioLevels(*opts.AudioLevelInterval)
+	}
+
+	if opts.StateDiffInterval != nil && *opts.StateDiffInterval > 0 {
+		// the clients that added right after the room is created are in the first diff
+		go room.loopStateDiff(*opts.StateDiffInterval, room.State())
+	}
+
+	if opts.FreezeThreshold != nil && *opts.FreezeThreshold > 0 {
+		room.freezes = newFreezeDetector(*opts.FreezeThreshold)
+		room.AddPacketInterceptor(PacketIngress, room.freezes.intercept)
+		room.AddPacketInterceptor(PacketEgress, room.freezes.intercept)
+
+		go room.loopFreezeDetection()
+	}
+
+	if opts.StatsHistory != nil {
+		go room.loopStatsHistory(*opts.StatsHistory)
+	}
+
 	return room
 }
 
@@ -151,17 +376,31 @@ func (r *Room) Close() error {
 		return ErrRoomIsClosed
 	}
 
+	_ = r.StopRecording()
+
 	r.cancel()
 
 	r.sfu.Stop()
 
+	// the suspended clients are not resumed anymore
+	for _, client := range r.sessions.clear() {
+		r.onClientLeft(client)
+	}
+
 	r.mu.RLock()
-	defer r.mu.RUnlock()
-	for _, callback := range r.onRoomClosedCallbacks {
+	callbacks := r.onRoomClosedCallbacks
+	r.mu.RUnlock()
+
+	for _, callback := range callbacks {
 		callback(r.id)
 	}
 
+	r.mu.Lock()
 	r.state = StateRoomClosed
+	r.mu.Unlock()
+
+	// the event handlers can call the room, so it's sent without holding the lock
+	r.emit(EventRoomClosed, nil)
 
 	return nil
 }
@@ -180,15 +419,38 @@ func (r *Room) StopClient(id string) error {
 		return err
 	}
 
+	client.leaving.Store(true)
+
 	return client.stop()
 }
 
 func (r *Room) AddClient(id, name string, opts ClientOptions) (*Client, error) {
+	return r.addClient(id, name, opts, false)
+}
+
+// admitClient checks the room policies before a client is added to the room or moved to the room. The admitted client
+// takes a place in the room until the returned release is called, call it once the client is added or the add failed,
+// so the concurrent admissions can't exceed the max clients.
+func (r *Room) admitClient(id string, resumed bool) (func(), error) {
 	if r.state == StateRoomClosed {
 		return nil, ErrRoomIsClosed
 	}
 
-	opts.qualityLevels = r.options.QualityLevels
+	if r.sfu.IsDraining() {
+		return nil, ErrSFUDraining
+	}
+
+	if banned, err := r.banList.IsBanned(r.id, id); err != nil {
+		return nil, err
+	} else if banned {
+		return nil, ErrClientBanned
+	}
+
+	// the resumed client takes the place that kept while suspended
+	if !resumed && r.isFull() {
+		r.onRoomFull(id)
+		return nil, ErrRoomIsFull
+	}
 
 	for _, ext := range r.extensions {
 		if err := ext.OnBeforeClientAdded(r, id); err != nil {
@@ -196,12 +458,90 @@ func (r *Room) AddClient(id, name string, opts ClientOptions) (*Client, error) {
 		}
 	}
 
-	client, _ := r.sfu.GetClient(id)
-	if client != nil {
+	if _, err := r.sfu.GetClient(id); err == nil {
 		return nil, ErrClientExists
 	}
 
-	client = r.sfu.NewClient(id, name, opts)
+	if resumed {
+		return func() {}, nil
+	}
+
+	// checked again with the place taken in the same lock, the other clients could be admitted after the first check
+	r.admitMu.Lock()
+	full := r.isFullLocked()
+	if !full {
+		r.admitting++
+	}
+	r.admitMu.Unlock()
+
+	if full {
+		r.onRoomFull(id)
+		return nil, ErrRoomIsFull
+	}
+
+	return sync.OnceFunc(func() {
+		r.admitMu.Lock()
+		defer r.admitMu.Unlock()
+
+		r.admitting--
+	}), nil
+}
+
+func (r *Room) addClient(id, name string, opts ClientOptions, resumed bool) (*Client, error) {
+	release, err := r.admitClient(id, resumed)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.qualityLevels = r.options.QualityLevels
+
+	if r.options.E2EE {
+		opts.E2EE = true
+	}
+
+	if r.options.DisableJitterBuffer {
+		opts.ReorderPackets = false
+	}
+
+	if r.options.CapUnusedLayers {
+		opts.CapUnusedLayers = true
+	}
+
+	if r.options.PauseUnusedLayers {
+		opts.PauseUnusedLayers = true
+	}
+
+	if r.options.PauseUnsubscribedVideo {
+		opts.PauseUnsubscribedVideo = true
+	}
+
+	role := opts.Role
+	if role == "" {
+		role = ClientRolePublisher
+	}
+
+	if constraints, ok := r.options.PublishConstraints[role]; ok {
+		opts.PublishConstraints = &constraints
+	}
+
+	if r.options.PlayoutDelay != nil {
+		opts.EnablePlayoutDelay = true
+		opts.MinPlayoutDelay = r.options.PlayoutDelay.Min
+		opts.MaxPlayoutDelay = r.options.PlayoutDelay.Max
+	}
+
+	client := r.sfu.NewClient(id, name, opts)
+	release()
+
+	client.bitrateController.allocator.Store(r.bitrateAllocator)
+
+	if r.options.Lobby && !resumed && role != ClientRoleModerator {
+		client.inLobby.Store(true)
+	}
+
+	r.analytics.addClient(client)
+
+	client.joinSpan.SetAttributes(attrRoomID.String(r.id))
 
 	// stop client if not connecting for a specific time
 	initConnection := true
@@ -238,13 +578,134 @@ func (r *Room) AddClient(id, name string, opts ClientOptions) (*Client, error) {
 		}
 	}()
 
+	if r.reconnectGracePeriod() > 0 {
+		client.setReconnectToken(r.sessions.add(client))
+	}
+
 	client.OnJoined(func() {
+		r.sessions.setJoined(client.ReconnectToken())
+
+		if resumed {
+			r.onClientResumed(client)
+			return
+		}
+
+		if client.IsInLobby() {
+			r.onClientWaiting(client)
+			return
+		}
+
 		r.onClientJoined(client)
 	})
 
 	return client, nil
 }
 
+// StartRecording starts recording all tracks in the room to the recording directory, including the tracks that published later.
+// Each track is written to a separate file, see Recorder for the file format. The recording starts from the buffered
+// packets when RoomOptions.RecordingBuffer is set, the recorder StartTime is the time of the oldest buffered packet.
+func (r *Room) StartRecording(opts RecordingOptions) (*Recorder, error) {
+	if r.options.E2EE {
+		return nil, ErrE2EENotSupported
+	}
+
+	bufferStart := r.recordingBufferStart()
+
+	r.mu.Lock()
+
+	if r.recorder != nil {
+		r.mu.Unlock()
+		return nil, ErrRecordingAlreadyStarted
+	}
+
+	recorder, err := newRecorder(r, opts)
+	if err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+
+	// the files are aligned to the oldest buffered packet, see RoomOptions.RecordingBuffer
+	if !bufferStart.IsZero() && bufferStart.Before(recorder.startTime) {
+		recorder.startTime = bufferStart
+	}
+
+	r.recorder = recorder
+	r.mu.Unlock()
+
+	for _, client := range r.sfu.GetClients() {
+		recorder.addTracks(client.Tracks())
+	}
+
+	r.emit(EventRecordingStarted, map[string]interface{}{"directory": opts.Directory})
+
+	return recorder, nil
+}
+
+// StopRecording stops the recording and closes all recording files
+func (r *Room) StopRecording() error {
+	r.mu.Lock()
+	recorder := r.recorder
+	r.recorder = nil
+	r.mu.Unlock()
+
+	if recorder == nil {
+		return ErrRecordingNotStarted
+	}
+
+	recorder.stop()
+
+	r.emit(EventRecordingStopped, nil)
+
+	return nil
+}
+
+// Recorder returns the recorder of the running recording, nil if the room is not recorded
+func (r *Room) Recorder() *Recorder {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.recorder
+}
+
+// SetForwardingPolicy limits the video tracks that forwarded to each subscriber, see ForwardingPolicy.
+// The previous policy is replaced.
+func (r *Room) SetForwardingPolicy(opts ForwardingPolicyOptions) *ForwardingPolicy {
+	policy := newForwardingPolicy(r, opts)
+
+	r.mu.Lock()
+	previous := r.forwardingPolicy
+	r.forwardingPolicy = policy
+	r.mu.Unlock()
+
+	if previous != nil {
+		previous.cancel()
+	}
+
+	policy.start()
+
+	return policy
+}
+
+// ForwardingPolicy returns the current forwarding policy, nil if it's not set
+func (r *Room) ForwardingPolicy() *ForwardingPolicy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.forwardingPolicy
+}
+
+// RemoveForwardingPolicy removes the forwarding policy and resumes all the paused video tracks
+func (r *Room) RemoveForwardingPolicy() {
+	r.mu.Lock()
+	policy := r.forwardingPolicy
+	r.forwardingPolicy = nil
+	r.mu.Unlock()
+
+	if policy != nil {
+		policy.stop()
+	}
+}
+
 // Generate a unique client ID for this room
 func (r *Room) CreateClientID() string {
 	return GenerateID(21)
@@ -261,6 +722,9 @@ func (r *Room) OnClientLeft(callback func(client *Client)) {
 }
 
 func (r *Room) onClientLeft(client *Client) {
+	// the published tracks that held to resume the client are ended
+	client.releaseHeldTracks()
+
 	r.mu.RLock()
 	callbacks := r.onClientLeftCallbacks
 	exts := r.extensions
@@ -275,9 +739,12 @@ func (r *Room) onClientLeft(client *Client) {
 
 	// update the latest stats from client before they left
 	r.mu.Lock()
-	defer r.mu.Unlock()
-
 	r.stats[client.ID()] = client.stats.TrackStats
+	r.mu.Unlock()
+
+	r.analytics.removeClient(client.ID())
+
+	r.emit(EventRoomClientLeft, map[string]interface{}{"client_id": client.ID(), "name": client.Name()})
 }
 
 func (r *Room) onClientJoined(client *Client) {
@@ -288,6 +755,8 @@ func (r *Room) onClientJoined(client *Client) {
 	for _, ext := range r.extensions {
 		ext.OnClientAdded(r, client)
 	}
+
+	r.emit(EventRoomClientJoined, map[string]interface{}{"client_id": client.ID(), "name": client.Name()})
 }
 
 func (r *Room) OnClientJoined(callback func(client *Client)) {
@@ -388,6 +857,36 @@ func (r *Room) CreateDataChannel(label string, opts DataChannelOptions) error {
 	return r.sfu.CreateDataChannel(label, opts)
 }
 
+// SetDataChannelPermissions replaces the clients that allowed to send and receive messages on the data channel,
+// empty means all clients in the channel. See DataChannelOptions.SenderIDs and DataChannelOptions.ReceiverIDs.
+func (r *Room) SetDataChannelPermissions(label string, senderIDs, receiverIDs []string) error {
+	dc := r.sfu.dataChannels.Get(label)
+	if dc == nil {
+		return ErrDataChannelNotFound
+	}
+
+	dc.setPermissions(senderIDs, receiverIDs)
+
+	return nil
+}
+
+// BroadcastMessage sends the payload to all receivers of the data channel, except the clients in excludeClientIDs
+func (r *Room) BroadcastMessage(label string, payload []byte, excludeClientIDs []string) error {
+	if r.sfu.dataChannels.Get(label) == nil {
+		return ErrDataChannelNotFound
+	}
+
+	r.sfu.broadcastDataChannelMessage(label, payload, excludeClientIDs)
+
+	return nil
+}
+
+// OnDataChannelMessage event is called when a client sends a message on a data channel that created with
+// CreateDataChannel, the msg.ClientID is the sender.
+func (r *Room) OnDataChannelMessage(callback func(msg DataChannelMessage)) {
+	r.sfu.OnDataChannelMessage(callback)
+}
+
 // BitrateConfigs return the current bitrate configuration that used in bitrate controller
 // Client should use this to configure the bitrate when publishing media tracks
 // Inconsistent bitrate configuration between client and server will result missed bitrate calculation and
@@ -411,6 +910,34 @@ func (r *Room) Meta() *Metadata {
 	return r.meta
 }
 
+// SetMetadata sets the room metadata, a JSON blob that sent to all clients in the room with the metadata_changed
+// internal message. It returns the new version or ErrMetadataTooLarge if it's bigger than RoomOptions.MaxMetadataSize.
+func (r *Room) SetMetadata(metadata []byte) (uint64, error) {
+	data, version, err := r.sfu.metadata.set(metadata, r.sfu.maxMetadataSize)
+	if err != nil {
+		return 0, err
+	}
+
+	r.sfu.onMetadataChanged(MetadataChanged{Version: version, Metadata: data})
+
+	return version, nil
+}
+
+// Metadata returns the room metadata that set with SetMetadata and the version, the version is 0 if it's never set
+func (r *Room) Metadata() (json.RawMessage, uint64) {
+	return r.sfu.metadata.get()
+}
+
+// OnMetadataChanged event is called when the room metadata or a client metadata is set
+func (r *Room) OnMetadataChanged(callback func(event MetadataChanged)) {
+	r.sfu.OnMetadataChanged(callback)
+}
+
+// IsE2EE returns true if the room is marked as end-to-end encrypted
+func (r *Room) IsE2EE() bool {
+	return r.options.E2EE
+}
+
 func (r *Room) Options() RoomOptions {
 	return r.options
 }
@@ -428,6 +955,7 @@ func (r *Room) loopRecordStats() {
 			return
 		case <-ticker.C:
 			r.updateStats()
+			r.analytics.sample(r.sfu.clients.GetClients())
 		}
 	}
 }
@@ -24,6 +24,10 @@ type TrackSentStats struct {
 	Source         string              `json:"source"`
 	Quality        QualityLevel        `json:"quality"`
 	MaxQuality     QualityLevel        `json:"max_quality"`
+	// the packets that dropped because the write queue of the track is full, the subscriber is slower than the track
+	DroppedPackets uint64 `json:"dropped_packets"`
+	// the reason that the video is forwarded below the high quality, see QualityLimitationReason
+	QualityLimitationReason QualityLimitationReason `json:"quality_limitation_reason"`
 }
 
 type TrackReceivedStats struct {
@@ -36,6 +40,10 @@ type TrackReceivedStats struct {
 	PacketsLost     int64               `json:"packets_lost"`
 	PacketsReceived uint64              `json:"packets_received"`
 	BytesReceived   int64               `json:"bytes_received"`
+	// the number of the Opus DTX packets received in the silent period, always 0 for the video and the end-to-end encrypted tracks
+	DTXPackets uint64 `json:"dtx_packets"`
+	// the stats of the jitter buffer that reorders the received packets, nil if ReorderPackets is disabled
+	JitterBuffer *JitterBufferStats `json:"jitter_buffer,omitempty"`
 }
 
 type ClientTrackStats struct {
@@ -49,6 +57,10 @@ type ClientTrackStats struct {
 	Receives                 []TrackReceivedStats `json:"received_track_stats"`
 	// in milliseconds
 	VoiceActivityDurationMS uint32 `json:"voice_activity_duration_ms"`
+	// the audio/video sync of the streams that sent to the client, empty if EnableAVSync is disabled
+	AVSync []AVSyncStats `json:"av_sync,omitempty"`
+	// the goroutines, the buffered packets, and the estimated memory of the client
+	Resources ClientResources `json:"resources"`
 }
 
 type RoomStats struct {
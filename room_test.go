@@ -2,9 +2,19 @@ package sfu
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/inlivedev/sfu/pkg/interceptors/voiceactivedetector"
+	"github.com/inlivedev/sfu/pkg/token"
+	"github.com/pion/ice/v4"
+	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
 	"github.com/stretchr/testify/require"
 )
@@ -353,3 +363,1459 @@ func TestRoomAddClientTimeout(t *testing.T) {
 		require.Equal(t, c.ID(), client.ID())
 	}
 }
+
+func TestRoomForwardingPolicy(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	_, subscriber, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "subscriber", true, false, true)
+	_, peer1, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer1", true, false, true)
+	_, peer2, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer2", true, false, true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	wait := func(msg string, cond func() bool) {
+		for !cond() {
+			select {
+			case <-timeout.Done():
+				t.Fatal(msg)
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+
+	wait("timeout waiting for the subscribed tracks", func() bool {
+		return len(subscriber.ClientTracks()) == 4
+	})
+
+	videoTrackID := func(client *Client) string {
+		for _, track := range client.Tracks() {
+			if track.Kind() == webrtc.RTPCodecTypeVideo {
+				return track.ID()
+			}
+		}
+
+		return ""
+	}
+
+	video1, video2 := videoTrackID(peer1), videoTrackID(peer2)
+
+	opts := DefaultForwardingPolicyOptions()
+	opts.MaxVideoTracks = 1
+
+	policy := testRoom.SetForwardingPolicy(opts)
+	require.Equal(t, policy, testRoom.ForwardingPolicy())
+
+	require.Len(t, policy.ForwardedTracks(subscriber.ID()), 1)
+	require.NotEqual(t, subscriber.isTrackPaused(video1), subscriber.isTrackPaused(video2))
+
+	// the pinned publisher is forwarded first
+	policy.Pin(subscriber.ID(), peer2.ID())
+
+	require.Equal(t, []string{video2}, policy.ForwardedTracks(subscriber.ID()))
+	require.True(t, subscriber.isTrackPaused(video1))
+	require.False(t, subscriber.isTrackPaused(video2))
+
+	changed := make(chan []string, 10)
+	policy.OnChanged(func(subscriberID string, forwarded, paused []string) {
+		if subscriberID == subscriber.ID() {
+			changed <- forwarded
+		}
+	})
+
+	policy.Pin(subscriber.ID(), peer1.ID())
+
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the forwarding changed")
+	case forwarded := <-changed:
+		require.Equal(t, []string{video1}, forwarded)
+	}
+
+	require.True(t, subscriber.isTrackPaused(video2))
+
+	// the audio tracks are never paused
+	for id, track := range subscriber.ClientTracks() {
+		if track.Kind() == webrtc.RTPCodecTypeAudio {
+			require.False(t, subscriber.isTrackPaused(id))
+		}
+	}
+
+	// the screen track is forwarded without counting in the max video tracks
+	screen, err := peer2.tracks.Get(video2)
+	require.NoError(t, err)
+
+	screen.SetSourceType(TrackTypeScreen)
+	policy.evaluate()
+
+	require.ElementsMatch(t, []string{video1, video2}, policy.ForwardedTracks(subscriber.ID()))
+	require.False(t, subscriber.isTrackPaused(video2))
+
+	testRoom.RemoveForwardingPolicy()
+	require.Nil(t, testRoom.ForwardingPolicy())
+	require.False(t, subscriber.isTrackPaused(video1))
+	require.False(t, subscriber.isTrackPaused(video2))
+
+	_ = testRoom.StopClient(subscriber.ID())
+	_ = testRoom.StopClient(peer1.ID())
+	_ = testRoom.StopClient(peer2.ID())
+}
+
+func TestRoomActiveSpeaker(t *testing.T) {
+	// the ranking is updated manually instead of by the room ticker
+	testRoom := &Room{speakers: newSpeakerDetector(500 * time.Millisecond)}
+
+	dominantChanged := make([]string, 0)
+	testRoom.OnDominantSpeakerChanged(func(clientID string) {
+		dominantChanged = append(dominantChanged, clientID)
+	})
+
+	voice := func(level uint8, count int) []voiceactivedetector.VoicePacketData {
+		pkts := make([]voiceactivedetector.VoicePacketData, count)
+		for i := range pkts {
+			pkts[i] = voiceactivedetector.VoicePacketData{AudioLevel: level, IsVoice: true}
+		}
+
+		return pkts
+	}
+
+	speakers := testRoom.speakers
+	now := time.Now()
+
+	speakers.addLevels("a", voice(10, 25), now)
+	speakers.update()
+
+	require.Equal(t, "a", testRoom.DominantSpeaker())
+	require.Equal(t, []string{"a"}, dominantChanged)
+
+	// a slightly louder speaker doesn't take over the dominant speaker
+	speakers.addLevels("a", voice(10, 25), now)
+	speakers.addLevels("b", voice(9, 25), now)
+	speakers.update()
+
+	require.Equal(t, "a", testRoom.DominantSpeaker())
+
+	// the dominant speaker is changed once the other speaker keeps talking louder
+	for i := 0; i < 5; i++ {
+		speakers.addLevels("b", voice(0, 25), now)
+		speakers.update()
+	}
+
+	require.Equal(t, "b", testRoom.DominantSpeaker())
+	require.Equal(t, []string{"a", "b"}, dominantChanged)
+
+	ranking := testRoom.SpeakerRanking()
+	require.Len(t, ranking, 2)
+	require.Equal(t, "b", ranking[0].ClientID)
+	require.True(t, ranking[0].Speaking)
+	require.False(t, ranking[1].Speaking)
+	require.Greater(t, ranking[0].AudioLevel, ranking[1].AudioLevel)
+
+	speakers.removeClient("b")
+	require.Empty(t, testRoom.DominantSpeaker())
+}
+
+func TestRoomAudioLevels(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	messages := make(chan internalDataAudioLevels, 10)
+
+	pc, client, _, connChan := CreateDataPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer1", func(dc *webrtc.DataChannel) {
+		if dc.Label() != "internal" {
+			return
+		}
+
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			data := internalDataAudioLevels{}
+			if err := json.Unmarshal(msg.Data, &data); err == nil && data.Type == messageTypeAudioLevels {
+				messages <- data
+			}
+		})
+	})
+
+	defer pc.Close()
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for connected")
+	case <-connChan:
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-connChan:
+			}
+		}
+	}()
+
+	// the levels are added until the client receives it, the data channel might not be opened yet
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the audio levels")
+		case <-ticker.C:
+			testRoom.speakers.addLevels("speaker", []voiceactivedetector.VoicePacketData{{AudioLevel: 0}, {AudioLevel: 20}}, time.Now())
+			continue
+		case msg := <-messages:
+			require.Len(t, msg.Data, 1)
+			require.Equal(t, "speaker", msg.Data[0].ClientID)
+			require.InDelta(t, 0.55, msg.Data[0].AudioLevel, 0.01)
+		}
+
+		break
+	}
+
+	_ = testRoom.StopClient(client.ID())
+}
+
+func TestRoomBitrateAllocation(t *testing.T) {
+	bitrates := map[QualityLevel]uint32{
+		QualityHigh:   1_200_000,
+		QualityMid:    500_000,
+		QualityLow:    150_000,
+		QualityLowMid: 100_000,
+		QualityLowLow: 50_000,
+	}
+
+	bitrateAt := func(quality QualityLevel) uint32 {
+		return bitrates[quality]
+	}
+
+	newTracks := func(screenMaxQuality QualityLevel) []*trackAllocation {
+		return []*trackAllocation{
+			{id: "screen", weight: allocationWeightScreen, maxQuality: screenMaxQuality, bitrateAt: bitrateAt},
+			{id: "speaker", weight: allocationWeightSpeaker, maxQuality: QualityHigh, bitrateAt: bitrateAt},
+			{id: "a", weight: allocationWeightDefault, maxQuality: QualityHigh, bitrateAt: bitrateAt},
+			{id: "b", weight: allocationWeightDefault, maxQuality: QualityHigh, bitrateAt: bitrateAt},
+		}
+	}
+
+	qualities := func(tracks []*trackAllocation) map[string]QualityLevel {
+		result := make(map[string]QualityLevel)
+		for _, track := range tracks {
+			result[track.id] = track.quality
+		}
+
+		return result
+	}
+
+	// shared by weight: 900k, 600k, 300k, 300k
+	tracks := newTracks(QualityHigh)
+	allocateBitrates(2_100_000, tracks, DefaultQualityLevels())
+	require.Equal(t, map[string]QualityLevel{"screen": QualityMid, "speaker": QualityMid, "a": QualityLow, "b": QualityLow}, qualities(tracks))
+
+	// the saturated screen and speaker give the rest of their share to the other tracks
+	tracks = newTracks(QualityHigh)
+	allocateBitrates(5_000_000, tracks, DefaultQualityLevels())
+	require.Equal(t, map[string]QualityLevel{"screen": QualityHigh, "speaker": QualityHigh, "a": QualityHigh, "b": QualityHigh}, qualities(tracks))
+
+	// the screen is capped to low quality, the rest is shared by the other tracks
+	tracks = newTracks(QualityLow)
+	allocateBitrates(2_100_000, tracks, DefaultQualityLevels())
+	require.Equal(t, map[string]QualityLevel{"screen": QualityLow, "speaker": QualityMid, "a": QualityLow, "b": QualityLow}, qualities(tracks))
+
+	// not enough budget, all tracks use the lowest quality
+	tracks = newTracks(QualityHigh)
+	allocateBitrates(0, tracks, DefaultQualityLevels())
+	require.Equal(t, map[string]QualityLevel{"screen": QualityLowLow, "speaker": QualityLowLow, "a": QualityLowLow, "b": QualityLowLow}, qualities(tracks))
+
+	// the screen keeps its minimum bitrate even without enough budget
+	tracks = newTracks(QualityHigh)
+	tracks[0].minBitrate = 500_000
+	allocateBitrates(0, tracks, DefaultQualityLevels())
+	require.Equal(t, map[string]QualityLevel{"screen": QualityMid, "speaker": QualityLowLow, "a": QualityLowLow, "b": QualityLowLow}, qualities(tracks))
+
+	// the quality with unknown bitrate is skipped
+	bitrates[QualityHigh] = 0
+	tracks = newTracks(QualityHigh)
+	allocateBitrates(5_000_000, tracks, DefaultQualityLevels())
+	require.Equal(t, map[string]QualityLevel{"screen": QualityMid, "speaker": QualityMid, "a": QualityMid, "b": QualityMid}, qualities(tracks))
+
+	// the allocation is enabled by the room options or at runtime
+	room := &Room{}
+	room.bitrateAllocator = newBitrateAllocator(room, nil, nil)
+	require.False(t, room.bitrateAllocator.isEnabled())
+
+	room.SetDownlinkBitrateBudget(2_000_000)
+	require.True(t, room.bitrateAllocator.isEnabled())
+	require.Equal(t, uint32(2_000_000), room.DownlinkBitrateBudget())
+}
+
+func TestRoomQualityStrategy(t *testing.T) {
+	bitrates := map[QualityLevel]uint32{
+		QualityHigh:   1_200_000,
+		QualityMid:    500_000,
+		QualityLow:    150_000,
+		QualityLowMid: 100_000,
+		QualityLowLow: 50_000,
+	}
+
+	input := QualityInput{
+		ClientID:  "client",
+		Bandwidth: 2_400_000,
+		Reserved:  300_000,
+		Levels:    DefaultQualityLevels(),
+		Tracks: []QualityTrack{
+			{ID: "screen", IsScreen: true, MaxQuality: QualityHigh, Priority: allocationWeightScreen, LayerBitrates: bitrates},
+			{ID: "speaker", MaxQuality: QualityHigh, Priority: allocationWeightSpeaker, LayerBitrates: bitrates},
+			{ID: "a", MaxQuality: QualityHigh, Priority: allocationWeightDefault, LayerBitrates: bitrates},
+			{ID: "b", MaxQuality: QualityHigh, Priority: allocationWeightDefault, LayerBitrates: bitrates},
+		},
+	}
+
+	// the default strategy shares the bandwidth without the reserved bitrate by the track priority
+	qualities := DefaultQualityStrategy().SelectQualities(input)
+	require.Equal(t, map[string]QualityLevel{"screen": QualityMid, "speaker": QualityMid, "a": QualityLow, "b": QualityLow}, qualities)
+
+	require.Empty(t, DefaultQualityStrategy().SelectQualities(QualityInput{Bandwidth: 2_400_000, Levels: DefaultQualityLevels()}))
+
+	// the pinned tier gets its quality first, the lower tiers share the rest
+	input.Tracks[2].Tier = SubscriptionPriorityPinned
+	input.Tracks[3].Tier = SubscriptionPriorityLow
+	qualities = DefaultQualityStrategy().SelectQualities(input)
+	require.Equal(t, map[string]QualityLevel{"screen": QualityMid, "speaker": QualityLow, "a": QualityHigh, "b": QualityLowLow}, qualities)
+
+	input.Bandwidth = 4_000_000
+	qualities = DefaultQualityStrategy().SelectQualities(input)
+	require.Equal(t, map[string]QualityLevel{"screen": QualityHigh, "speaker": QualityHigh, "a": QualityHigh, "b": QualityLowMid}, qualities)
+
+	// the lowest quality of the lower tiers is kept when the bandwidth is scarce
+	input.Bandwidth = 1_000_000
+	qualities = DefaultQualityStrategy().SelectQualities(input)
+	require.Equal(t, map[string]QualityLevel{"screen": QualityLowLow, "speaker": QualityLowLow, "a": QualityMid, "b": QualityLowLow}, qualities)
+	input.Bandwidth = 2_400_000
+	input.Tracks[2].Tier, input.Tracks[3].Tier = SubscriptionPriorityAuto, SubscriptionPriorityAuto
+
+	// a custom strategy enables the allocation without the budget
+	strategy := QualityStrategyFunc(func(input QualityInput) map[string]QualityLevel {
+		qualities := make(map[string]QualityLevel)
+		for _, track := range input.Tracks {
+			if track.ViewportWidth*track.ViewportHeight < 320*240 {
+				qualities[track.ID] = QualityLow
+			} else {
+				qualities[track.ID] = QualityHigh
+			}
+		}
+
+		return qualities
+	})
+
+	room := &Room{}
+	room.bitrateAllocator = newBitrateAllocator(room, nil, strategy)
+	require.True(t, room.bitrateAllocator.isEnabled())
+	require.Equal(t, uint32(0), room.DownlinkBitrateBudget())
+
+	input.Tracks[0].ViewportWidth, input.Tracks[0].ViewportHeight = 1280, 720
+	input.Tracks[1].ViewportWidth, input.Tracks[1].ViewportHeight = 160, 90
+
+	qualities = room.bitrateAllocator.strategy.SelectQualities(input)
+	require.Equal(t, map[string]QualityLevel{"screen": QualityHigh, "speaker": QualityLow, "a": QualityLow, "b": QualityLow}, qualities)
+}
+
+func TestRoomMuteClientTrack(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	_, subscriber, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "subscriber", true, false, true)
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	for len(subscriber.ClientTracks()) != 2 {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the subscribed tracks")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	var audioTrack ITrack
+
+	for _, track := range publisher.Tracks() {
+		if track.Kind() == webrtc.RTPCodecTypeAudio {
+			audioTrack = track
+		}
+	}
+
+	require.NotNil(t, audioTrack)
+
+	type mutedEvent struct {
+		trackID string
+		muted   bool
+	}
+
+	events := make(chan mutedEvent, 10)
+	publisher.OnTrackMutedRemotely(func(trackID string, muted bool) {
+		events <- mutedEvent{trackID, muted}
+	})
+
+	require.ErrorIs(t, testRoom.MuteClientTrack(publisher.ID(), "unknown"), ErrTrackIsNotExists)
+	require.ErrorIs(t, testRoom.MuteClientTrack("unknown", audioTrack.ID()), ErrClientNotFound)
+
+	require.NoError(t, testRoom.MuteClientTrack(publisher.ID(), audioTrack.ID()))
+	require.Equal(t, mutedEvent{audioTrack.ID(), true}, <-events)
+	require.True(t, publisher.IsTrackMutedRemotely(audioTrack.ID()))
+	require.True(t, publisher.isPublishMuted(audioTrack.ID(), webrtc.RTPCodecTypeAudio))
+
+	// muting twice doesn't call the callback again
+	require.NoError(t, testRoom.MuteClientTrack(publisher.ID(), audioTrack.ID()))
+
+	// the track is still subscribed, only the packets are not forwarded
+	require.Len(t, subscriber.ClientTracks(), 2)
+
+	require.NoError(t, testRoom.UnmuteClientTrack(publisher.ID(), audioTrack.ID()))
+	require.Equal(t, mutedEvent{audioTrack.ID(), false}, <-events)
+	require.False(t, publisher.IsTrackMutedRemotely(audioTrack.ID()))
+	require.Empty(t, events)
+
+	_ = testRoom.StopClient(subscriber.ID())
+	_ = testRoom.StopClient(publisher.ID())
+}
+
+func TestRoomKickAndBan(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	opened := make(chan bool, 1)
+	messages := make(chan internalDataKicked, 1)
+
+	pc, client, _, connChan := CreateDataPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer1", func(dc *webrtc.DataChannel) {
+		if dc.Label() != "internal" {
+			return
+		}
+
+		dc.OnOpen(func() {
+			opened <- true
+		})
+
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			data := internalDataKicked{}
+			if err := json.Unmarshal(msg.Data, &data); err == nil && data.Type == messageTypeKicked {
+				messages <- data
+			}
+		})
+	})
+
+	defer pc.Close()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-connChan:
+			}
+		}
+	}()
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the internal data channel")
+	case <-opened:
+	}
+
+	require.ErrorIs(t, testRoom.KickClient("unknown", "spam"), ErrClientNotFound)
+
+	// the reason is sent before the peer connection is closed
+	require.NoError(t, testRoom.BanClient(client.ID(), "spam"))
+
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the kicked message")
+	case msg := <-messages:
+		require.Equal(t, kicked{Reason: "spam", Banned: true}, msg.Data)
+	}
+
+	require.Eventually(t, func() bool {
+		_, err := testRoom.SFU().GetClient(client.ID())
+		return errors.Is(err, ErrClientNotFound)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	banned, err := testRoom.BanList().IsBanned(testRoom.ID(), client.ID())
+	require.NoError(t, err)
+	require.True(t, banned)
+
+	_, err = testRoom.AddClient(client.ID(), client.ID(), DefaultClientOptions())
+	require.ErrorIs(t, err, ErrClientBanned)
+
+	// banning a client that is not in the room only adds it to the ban list
+	require.NoError(t, testRoom.BanClient("peer2", "spam"))
+
+	require.NoError(t, testRoom.UnbanClient(client.ID()))
+
+	rejoined, err := testRoom.AddClient(client.ID(), client.ID(), DefaultClientOptions())
+	require.NoError(t, err)
+
+	_ = testRoom.StopClient(rejoined.ID())
+}
+
+func TestRoomAddClientWithToken(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom("token-room", "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	secret := []byte("secret")
+	verifier := token.NewVerifier(secret, 0)
+
+	sign := func(claims token.Claims) string {
+		signed, err := token.Sign(secret, claims)
+		require.NoError(t, err)
+
+		return signed
+	}
+
+	expiresAt := time.Now().Add(time.Minute).Unix()
+
+	_, err = testRoom.AddClientWithToken(verifier, sign(token.Claims{RoomID: "other-room", ClientID: "alice", ExpiresAt: expiresAt}), DefaultClientOptions())
+	require.ErrorIs(t, err, ErrTokenRoomMismatch)
+
+	_, err = testRoom.AddClientWithToken(verifier, sign(token.Claims{RoomID: "token-room", ClientID: "alice", Role: "admin", ExpiresAt: expiresAt}), DefaultClientOptions())
+	require.ErrorIs(t, err, ErrTokenInvalidRole)
+
+	_, err = testRoom.AddClientWithToken(verifier, sign(token.Claims{RoomID: "token-room", ClientID: "alice", ExpiresAt: time.Now().Add(-time.Minute).Unix()}), DefaultClientOptions())
+	require.ErrorIs(t, err, token.ErrTokenExpired)
+
+	client, err := testRoom.AddClientWithToken(verifier, sign(token.Claims{
+		RoomID:    "token-room",
+		ClientID:  "alice",
+		Name:      "Alice",
+		Role:      "subscriber",
+		Sources:   []string{"media"},
+		ExpiresAt: expiresAt,
+	}), DefaultClientOptions())
+	require.NoError(t, err)
+
+	require.Equal(t, "alice", client.ID())
+	require.Equal(t, "Alice", client.Name())
+	require.Equal(t, ClientRoleSubscriber, client.Role())
+	require.True(t, client.canPublishSource(TrackTypeMedia))
+	require.False(t, client.canPublishSource(TrackTypeScreen))
+
+	_ = testRoom.StopClient(client.ID())
+}
+
+// connectResumablePeer connects a peer to the client without stopping the client when the peer is closed
+func connectResumablePeer(t *testing.T, client *Client) *webrtc.PeerConnection {
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	settingEngine.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	settingEngine.SetIncludeLoopbackCandidate(true)
+
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(GetMediaEngine()), webrtc.WithSettingEngine(settingEngine))
+
+	pc, err := api.NewPeerConnection(webrtc.Configuration{ICEServers: DefaultTestIceServers()})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { pc.Close() })
+
+	_, err = pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+	require.NoError(t, err)
+
+	client.OnIceCandidate(func(ctx context.Context, candidate *webrtc.ICECandidate) {
+		if candidate != nil {
+			_ = pc.AddICECandidate(candidate.ToJSON())
+		}
+	})
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate != nil {
+			_ = client.PeerConnection().PC().AddICECandidate(candidate.ToJSON())
+		}
+	})
+
+	client.OnRenegotiation(func(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+		if err := pc.SetRemoteDescription(offer); err != nil {
+			return webrtc.SessionDescription{}, err
+		}
+
+		answer, err := pc.CreateAnswer(nil)
+		if err != nil {
+			return webrtc.SessionDescription{}, err
+		}
+
+		if err := pc.SetLocalDescription(answer); err != nil {
+			return webrtc.SessionDescription{}, err
+		}
+
+		return *pc.LocalDescription(), nil
+	})
+
+	negotiate(pc, client, TestLogger, true)
+
+	return pc
+}
+
+func TestRoomResumeClient(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	gracePeriod := 10 * time.Second
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	roomOpts.ReconnectGracePeriod = &gracePeriod
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	joined := make(chan string, 10)
+	left := make(chan string, 10)
+	resumed := make(chan string, 10)
+
+	testRoom.OnClientJoined(func(client *Client) {
+		joined <- client.ID()
+	})
+
+	testRoom.OnClientLeft(func(client *Client) {
+		left <- client.ID()
+	})
+
+	testRoom.OnClientResumed(func(client *Client) {
+		resumed <- client.ID()
+	})
+
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	for len(publisher.Tracks()) != 2 {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the published tracks")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	client, err := testRoom.AddClient("peer", "peer", DefaultClientOptions())
+	require.NoError(t, err)
+	require.NotEmpty(t, client.ReconnectToken())
+
+	subscriptions := make([]SubscribeTrackRequest, 0)
+	for _, track := range publisher.Tracks() {
+		subscriptions = append(subscriptions, SubscribeTrackRequest{ClientID: publisher.ID(), TrackID: track.ID()})
+	}
+
+	require.NoError(t, client.SubscribeTracks(subscriptions))
+
+	connectResumablePeer(t, client)
+
+	for len(client.ClientTracks()) != 2 {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the subscribed tracks")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	require.Len(t, joined, 2)
+	<-joined
+	<-joined
+
+	// the transport is lost, the client is removed from the SFU but it's not left from the room
+	require.NoError(t, client.PeerConnection().PC().Close())
+
+	require.Eventually(t, func() bool {
+		_, err := testRoom.SFU().GetClient(client.ID())
+		return errors.Is(err, ErrClientNotFound)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	_, err = testRoom.ResumeClient("invalid", DefaultClientOptions())
+	require.ErrorIs(t, err, ErrInvalidReconnectToken)
+
+	resumedClient, err := testRoom.ResumeClient(client.ReconnectToken(), DefaultClientOptions())
+	require.NoError(t, err)
+	require.Equal(t, client.ID(), resumedClient.ID())
+	require.NotEqual(t, client.ReconnectToken(), resumedClient.ReconnectToken())
+
+	// the token can only be used once
+	_, err = testRoom.ResumeClient(client.ReconnectToken(), DefaultClientOptions())
+	require.ErrorIs(t, err, ErrInvalidReconnectToken)
+
+	connectResumablePeer(t, resumedClient)
+
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the resumed client")
+	case id := <-resumed:
+		require.Equal(t, client.ID(), id)
+	}
+
+	// the previous subscriptions are restored without the client subscribing again
+	for len(resumedClient.ClientTracks()) != 2 {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the resubscribed tracks")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	require.Empty(t, joined)
+	require.Empty(t, left)
+
+	// the client that left by itself can't be resumed
+	require.NoError(t, testRoom.StopClient(resumedClient.ID()))
+
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the left event")
+	case id := <-left:
+		require.Equal(t, client.ID(), id)
+	}
+
+	_, err = testRoom.ResumeClient(resumedClient.ReconnectToken(), DefaultClientOptions())
+	require.ErrorIs(t, err, ErrInvalidReconnectToken)
+
+	_ = testRoom.StopClient(publisher.ID())
+}
+
+func TestRoomPreferCodecsInOrder(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeVP9, webrtc.MimeTypeVP8, webrtc.MimeTypeOpus}
+	roomOpts.PreferCodecsInOrder = true
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	client, err := testRoom.AddClient("publisher", "publisher", DefaultClientOptions())
+	require.NoError(t, err)
+
+	defer func() {
+		_ = testRoom.StopClient(client.ID())
+	}()
+
+	// the default pion media engine prefers VP8 and offers H264
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	defer pc.Close()
+
+	_, err = pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo)
+	require.NoError(t, err)
+
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+	require.NoError(t, pc.SetLocalDescription(offer))
+
+	answer, err := client.Negotiate(offer)
+	require.NoError(t, err)
+
+	mediaLine, _, _ := strings.Cut(answer.SDP[strings.Index(answer.SDP, "m=video"):], "\r\n")
+	formats := strings.Fields(mediaLine)[3:]
+	require.Equal(t, "98", formats[0], "VP9 is the first codec of the answer")
+	require.NotContains(t, answer.SDP, "H264")
+	require.Contains(t, formats, "96")
+}
+
+func TestRoomState(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	diffInterval := 50 * time.Millisecond
+	roomOpts.StateDiffInterval = &diffInterval
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	diffChan := make(chan RoomStateDiff, 10)
+	testRoom.OnStateChanged(func(diff RoomStateDiff) {
+		diffChan <- diff
+	})
+
+	client, err := testRoom.AddClient("client", "client", DefaultClientOptions())
+	require.NoError(t, err)
+
+	state := testRoom.State()
+	require.Equal(t, testRoom.ID(), state.ID)
+	require.Equal(t, StateRoomOpen, state.State)
+	require.Len(t, state.Clients, 1)
+	require.Equal(t, client.ID(), state.Clients[0].ID)
+	require.Equal(t, webrtc.PeerConnectionStateNew.String(), state.Clients[0].ConnectionState)
+	require.Empty(t, state.Clients[0].Published)
+	require.Empty(t, state.Clients[0].Subscriptions)
+
+	select {
+	case diff := <-diffChan:
+		require.Len(t, diff.ClientsAdded, 1)
+		require.Equal(t, client.ID(), diff.ClientsAdded[0].ID)
+	case <-time.After(time.Second):
+		require.Fail(t, "timeout waiting for the added client diff")
+	}
+
+	require.NoError(t, testRoom.StopClient(client.ID()))
+
+	select {
+	case diff := <-diffChan:
+		require.Equal(t, []string{client.ID()}, diff.ClientsRemoved)
+	case <-time.After(time.Second):
+		require.Fail(t, "timeout waiting for the removed client diff")
+	}
+}
+
+func TestRoomExternalSubscriber(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	authorized := false
+
+	mainOpts := DefaultRoomOptions()
+	mainOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	mainOpts.AuthorizeExternalSubscriber = func(client *Client, req []SubscribeTrackRequest) error {
+		if !authorized {
+			return errors.New("not authorized")
+		}
+
+		return nil
+	}
+	mainRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "main-room", RoomTypeLocal, mainOpts)
+	require.NoError(t, err)
+
+	defer mainRoom.Close()
+
+	breakoutOpts := DefaultRoomOptions()
+	breakoutOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	breakoutRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "breakout-room", RoomTypeLocal, breakoutOpts)
+	require.NoError(t, err)
+
+	defer breakoutRoom.Close()
+
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, mainRoom, DefaultTestIceServers(), "publisher", true, false, true)
+	_, listener, _, _ := CreatePeerPair(ctx, TestLogger, breakoutRoom, DefaultTestIceServers(), "listener", true, false, true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	for len(publisher.Tracks()) != 2 || listener.PeerConnection().PC().ConnectionState() != webrtc.PeerConnectionStateConnected {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the publisher tracks")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	req := make([]SubscribeTrackRequest, 0)
+	for _, track := range publisher.Tracks() {
+		req = append(req, SubscribeTrackRequest{ClientID: publisher.ID(), TrackID: track.ID()})
+	}
+
+	require.ErrorIs(t, listener.SubscribeRoomTracks(mainRoom, req), ErrExternalSubscribeNotAllowed)
+	require.Empty(t, listener.ClientTracks())
+
+	authorized = true
+	require.NoError(t, listener.SubscribeRoomTracks(mainRoom, req))
+	require.Len(t, listener.ClientTracks(), 2)
+
+	for _, subscription := range breakoutRoom.State().Clients[0].Subscriptions {
+		require.Equal(t, publisher.ID(), subscription.PublisherID)
+	}
+
+	_ = breakoutRoom.StopClient(listener.ID())
+	_ = mainRoom.StopClient(publisher.ID())
+}
+
+func TestRoomMoveClient(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	mainRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "main-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer mainRoom.Close()
+
+	breakoutRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "breakout-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer breakoutRoom.Close()
+
+	_, host, _, _ := CreatePeerPair(ctx, TestLogger, mainRoom, DefaultTestIceServers(), "host", true, false, true)
+	_, mover, _, _ := CreatePeerPair(ctx, TestLogger, mainRoom, DefaultTestIceServers(), "mover", true, false, true)
+	_, member, _, _ := CreatePeerPair(ctx, TestLogger, breakoutRoom, DefaultTestIceServers(), "member", true, false, true)
+
+	waitFor := func(msg string, condition func() bool) {
+		timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+		defer cancelTimeout()
+
+		for !condition() {
+			select {
+			case <-timeout.Done():
+				t.Fatal(msg)
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+
+	waitFor("timeout waiting for the main room tracks", func() bool {
+		return len(host.ClientTracks()) == 2 && len(mover.ClientTracks()) == 2 && len(member.Tracks()) == 2
+	})
+
+	joined := make(chan string, 1)
+	breakoutRoom.OnClientJoined(func(client *Client) {
+		joined <- client.ID()
+	})
+
+	require.ErrorIs(t, mainRoom.MoveClient(mover.ID(), mainRoom), ErrMoveToSameRoom)
+	require.ErrorIs(t, mainRoom.MoveClient(member.ID(), breakoutRoom), ErrClientNotFound)
+
+	require.NoError(t, mainRoom.MoveClient(mover.ID(), breakoutRoom))
+	require.Equal(t, mover.ID(), <-joined)
+	require.Equal(t, breakoutRoom.SFU(), mover.SFU())
+
+	_, err = mainRoom.SFU().GetClient(mover.ID())
+	require.ErrorIs(t, err, ErrClientNotFound)
+
+	// the host stops receiving the mover tracks, the mover and the member receive each other tracks
+	waitFor("timeout waiting for the breakout room tracks", func() bool {
+		return len(host.ClientTracks()) == 0 && len(mover.ClientTracks()) == 2 && len(member.ClientTracks()) == 2
+	})
+
+	for id := range mover.ClientTracks() {
+		track, err := mover.publishedTracks.Get(id)
+		require.NoError(t, err)
+		require.Equal(t, member.ID(), track.ClientID())
+	}
+
+	// the mover is ended with the breakout room, not the main room
+	require.NoError(t, breakoutRoom.Close())
+
+	waitFor("timeout waiting for the mover to end", func() bool {
+		return mover.Context().Err() != nil
+	})
+
+	require.NoError(t, host.Context().Err())
+
+	_ = mainRoom.StopClient(host.ID())
+}
+
+func TestRoomMoveClientPolicies(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	mainRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "main-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer mainRoom.Close()
+
+	e2eeOpts := roomOpts
+	e2eeOpts.E2EE = true
+	e2eeRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "e2ee-room", RoomTypeLocal, e2eeOpts)
+	require.NoError(t, err)
+
+	defer e2eeRoom.Close()
+
+	constrainedOpts := roomOpts
+	constrainedOpts.PublishConstraints = map[ClientRole]PublishConstraints{
+		ClientRolePublisher: {AllowedCodecs: []string{webrtc.MimeTypeVP8, webrtc.MimeTypeOpus}},
+	}
+	constrainedRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "constrained-room", RoomTypeLocal, constrainedOpts)
+	require.NoError(t, err)
+
+	defer constrainedRoom.Close()
+
+	lobbyOpts := roomOpts
+	lobbyOpts.Lobby = true
+	lobbyOpts.PublishConstraints = map[ClientRole]PublishConstraints{
+		ClientRolePublisher: {MaxBitrate: 500_000},
+	}
+	lobbyRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "lobby-room", RoomTypeLocal, lobbyOpts)
+	require.NoError(t, err)
+
+	defer lobbyRoom.Close()
+
+	_, mover, _, _ := CreatePeerPair(ctx, TestLogger, mainRoom, DefaultTestIceServers(), "mover", true, false, true)
+
+	require.Eventually(t, func() bool {
+		return len(mover.Tracks()) == 2
+	}, 30*time.Second, 100*time.Millisecond)
+
+	require.ErrorIs(t, mainRoom.MoveClient(mover.ID(), e2eeRoom), ErrMoveE2EERequired)
+	require.ErrorIs(t, mainRoom.MoveClient(mover.ID(), constrainedRoom), ErrCodecNotAllowed)
+
+	// the rejected moves keep the client in the room
+	_, err = mainRoom.SFU().GetClient(mover.ID())
+	require.NoError(t, err)
+
+	waiting := make(chan string, 1)
+	lobbyRoom.OnClientWaiting(func(client *Client) {
+		waiting <- client.ID()
+	})
+
+	joined := make(chan string, 1)
+	lobbyRoom.OnClientJoined(func(client *Client) {
+		joined <- client.ID()
+	})
+
+	// the moved client waits in the lobby with the publish constraints of the target room
+	require.NoError(t, mainRoom.MoveClient(mover.ID(), lobbyRoom))
+	require.Equal(t, mover.ID(), <-waiting)
+	require.True(t, mover.IsInLobby())
+	require.Equal(t, uint32(500_000), mover.publishConstraints().MaxBitrate)
+
+	require.NoError(t, lobbyRoom.Admit(mover.ID()))
+	require.Equal(t, mover.ID(), <-joined)
+
+	_ = lobbyRoom.StopClient(mover.ID())
+}
+
+func TestRoomLobby(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	roomOpts.Lobby = true
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	waiting := make(chan string, 2)
+	testRoom.OnClientWaiting(func(client *Client) {
+		waiting <- client.ID()
+	})
+
+	joined := make(chan string, 2)
+	testRoom.OnClientJoined(func(client *Client) {
+		joined <- client.ID()
+	})
+
+	_, host, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "host", true, false, true)
+	require.Equal(t, host.ID(), <-waiting)
+	require.NoError(t, testRoom.Admit(host.ID()))
+	require.Equal(t, host.ID(), <-joined)
+
+	_, guest, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "guest", true, false, true)
+	require.Equal(t, guest.ID(), <-waiting)
+
+	waitFor := func(msg string, condition func() bool) {
+		timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+		defer cancelTimeout()
+
+		for !condition() {
+			select {
+			case <-timeout.Done():
+				t.Fatal(msg)
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+
+	waitFor("timeout waiting for the published tracks", func() bool {
+		return len(host.Tracks()) == 2 && len(guest.Tracks()) == 2
+	})
+
+	// the guest in the lobby doesn't receive and send the tracks
+	time.Sleep(500 * time.Millisecond)
+	require.Empty(t, guest.ClientTracks())
+	require.Empty(t, host.ClientTracks())
+	require.Equal(t, []*Client{guest}, testRoom.WaitingClients())
+	require.ErrorIs(t, guest.SubscribeTracks([]SubscribeTrackRequest{{ClientID: host.ID(), TrackID: host.Tracks()[0].ID()}}), ErrClientInLobby)
+
+	require.NoError(t, testRoom.Admit(guest.ID()))
+	require.Equal(t, guest.ID(), <-joined)
+	require.ErrorIs(t, testRoom.Admit(guest.ID()), ErrClientNotInLobby)
+	require.Empty(t, testRoom.WaitingClients())
+
+	waitFor("timeout waiting for the subscribed tracks", func() bool {
+		return len(guest.ClientTracks()) == 2 && len(host.ClientTracks()) == 2
+	})
+
+	_ = testRoom.StopClient(guest.ID())
+	_ = testRoom.StopClient(host.ID())
+}
+
+func TestRoomPolicies(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	maxDuration := 500 * time.Millisecond
+	closeWarning := 200 * time.Millisecond
+	roomOpts.MaxDuration = &maxDuration
+	roomOpts.CloseWarning = &closeWarning
+	roomOpts.MaxClients = 1
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	full := make(chan string, 1)
+	testRoom.OnRoomFull(func(clientID string) {
+		full <- clientID
+	})
+
+	type closingEvent struct {
+		reason    CloseReason
+		remaining time.Duration
+	}
+
+	closing := make(chan closingEvent, 1)
+	testRoom.OnClosing(func(reason CloseReason, remaining time.Duration) {
+		closing <- closingEvent{reason, remaining}
+	})
+
+	closed := make(chan string, 1)
+	testRoom.OnRoomClosed(func(id string) {
+		closed <- id
+	})
+
+	_, err = testRoom.AddClient("first", "first", DefaultClientOptions())
+	require.NoError(t, err)
+
+	_, err = testRoom.AddClient("second", "second", DefaultClientOptions())
+	require.ErrorIs(t, err, ErrRoomIsFull)
+	require.Equal(t, "second", <-full)
+
+	select {
+	case event := <-closing:
+		require.Equal(t, closingEvent{CloseReasonMaxDuration, closeWarning}, event)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the closing event")
+	}
+
+	select {
+	case id := <-closed:
+		require.Equal(t, testRoom.ID(), id)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the room to close")
+	}
+
+	// the room is removed from the manager after it's closed
+	require.Eventually(t, func() bool {
+		roomManager.mutex.Lock()
+		defer roomManager.mutex.Unlock()
+
+		_, err := roomManager.getRoom(testRoom.ID())
+
+		return errors.Is(err, ErrRoomNotFound)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRoomMaxClientsPaths(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	mainRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "main-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer mainRoom.Close()
+
+	fullOpts := roomOpts
+	fullOpts.MaxClients = 1
+	fullRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "full-room", RoomTypeLocal, fullOpts)
+	require.NoError(t, err)
+
+	defer fullRoom.Close()
+
+	full := make(chan string, 3)
+	fullRoom.OnRoomFull(func(clientID string) {
+		full <- clientID
+	})
+
+	_, err = fullRoom.AddClient("first", "first", DefaultClientOptions())
+	require.NoError(t, err)
+
+	_, mover, _, _ := CreatePeerPair(ctx, TestLogger, mainRoom, DefaultTestIceServers(), "mover", true, false, true)
+
+	require.Eventually(t, func() bool {
+		return mover.state.Load() == ClientStateActive
+	}, 30*time.Second, 100*time.Millisecond)
+
+	// the moved client is rejected and stays in the room
+	require.ErrorIs(t, mainRoom.MoveClient(mover.ID(), fullRoom), ErrRoomIsFull)
+	require.Equal(t, mover.ID(), <-full)
+
+	_, err = mainRoom.SFU().GetClient(mover.ID())
+	require.NoError(t, err)
+
+	_, err = fullRoom.IngestRTP("ingest", DefaultRTPIngestOptions())
+	require.ErrorIs(t, err, ErrRoomIsFull)
+	<-full
+
+	_, err = fullRoom.BridgeSIP("caller", nil, nil, DefaultSIPBridgeOptions())
+	require.ErrorIs(t, err, ErrRoomIsFull)
+	<-full
+
+	require.Equal(t, 1, fullRoom.SFU().clients.Length())
+
+	_ = mainRoom.StopClient(mover.ID())
+}
+
+func TestRoomMaxClientsConcurrent(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.MaxClients = 3
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	// the admitted client holds its place until it's released
+	release, err := testRoom.admitClient("reserved", false)
+	require.NoError(t, err)
+
+	var added, rejected atomic.Int32
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			_, err := testRoom.AddClient(fmt.Sprintf("client-%d", i), "client", DefaultClientOptions())
+			if err == nil {
+				added.Add(1)
+			} else if errors.Is(err, ErrRoomIsFull) {
+				rejected.Add(1)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	require.Equal(t, int32(2), added.Load())
+	require.Equal(t, int32(8), rejected.Load())
+	require.Equal(t, 2, testRoom.SFU().clients.Length())
+
+	release()
+
+	_, err = testRoom.AddClient("last", "last", DefaultClientOptions())
+	require.NoError(t, err)
+
+	_, err = testRoom.AddClient("rejected", "rejected", DefaultClientOptions())
+	require.ErrorIs(t, err, ErrRoomIsFull)
+}
+
+func TestRoomFreezeDetection(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	threshold := 300 * time.Millisecond
+	roomOpts.FreezeThreshold = &threshold
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	frozen := make(chan FreezeEvent, 1)
+	testRoom.OnFreeze(func(event FreezeEvent) {
+		select {
+		case frozen <- event:
+		default:
+		}
+	})
+
+	recovered := make(chan FreezeEvent, 1)
+	testRoom.OnRecover(func(event FreezeEvent) {
+		select {
+		case recovered <- event:
+		default:
+		}
+	})
+
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer1", true, false, true)
+	_, subscriber, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer2", true, false, true)
+
+	// drop the video of the publisher after the freeze detector recorded it, the subscriber stops receiving it
+	var dropVideo atomic.Bool
+	testRoom.AddPacketInterceptor(PacketIngress, func(info PacketInfo, p *rtp.Packet) bool {
+		return !(dropVideo.Load() && info.PublisherID == publisher.ID() && info.Kind == webrtc.RTPCodecTypeVideo)
+	})
+
+	require.Eventually(t, func() bool {
+		return len(subscriber.ClientTracks()) == 2 && len(publisher.ClientTracks()) == 2
+	}, 30*time.Second, 100*time.Millisecond)
+
+	// the streams are flowing, there is no freeze
+	time.Sleep(2 * threshold)
+	select {
+	case event := <-frozen:
+		t.Fatalf("unexpected freeze %+v", event)
+	default:
+	}
+
+	dropVideo.Store(true)
+
+	select {
+	case event := <-frozen:
+		require.Equal(t, PacketEgress, event.Direction)
+		require.Equal(t, publisher.ID(), event.PublisherID)
+		require.Equal(t, subscriber.ID(), event.SubscriberID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the freeze")
+	}
+
+	dropVideo.Store(false)
+
+	select {
+	case event := <-recovered:
+		require.Equal(t, PacketEgress, event.Direction)
+		require.Equal(t, subscriber.ID(), event.SubscriberID)
+		require.GreaterOrEqual(t, event.Duration, threshold)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the recovery")
+	}
+
+	for _, stat := range testRoom.FreezeStats() {
+		if stat.Direction == PacketEgress && stat.SubscriberID == subscriber.ID() && stat.PublisherID == publisher.ID() {
+			require.Equal(t, uint64(1), stat.Freezes)
+			require.False(t, stat.Frozen)
+		}
+	}
+
+	_ = testRoom.StopClient(subscriber.ID())
+	_ = testRoom.StopClient(publisher.ID())
+}
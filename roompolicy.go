@@ -0,0 +1,149 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var ErrRoomIsFull = errors.New("room: error the room reached the max clients")
+
+// the time before the max duration that the clients are told the room is closing, see RoomOptions.CloseWarning
+const defaultCloseWarning = time.Minute
+
+// CloseReason is the policy that closes the room, see Room.OnClosing
+type CloseReason string
+
+const (
+	// CloseReasonEmpty closes the room after it's empty for RoomOptions.EmptyRoomTimeout
+	CloseReasonEmpty CloseReason = "empty"
+	// CloseReasonMaxDuration closes the room after RoomOptions.MaxDuration since it's created
+	CloseReasonMaxDuration CloseReason = "max_duration"
+)
+
+// roomClosing is the data of the room_closing message that sent to the clients before the room is closed by a policy
+type roomClosing struct {
+	Reason      CloseReason `json:"reason"`
+	RemainingMs int64       `json:"remaining_ms"`
+}
+
+type internalDataRoomClosing struct {
+	Type string      `json:"type"`
+	Data roomClosing `json:"data"`
+}
+
+// closeWarning returns the time before the max duration that the OnClosing callbacks are called
+func (r *Room) closeWarning() time.Duration {
+	if r.options.CloseWarning == nil {
+		return defaultCloseWarning
+	}
+
+	return *r.options.CloseWarning
+}
+
+// OnClosing event is called before the room is closed by a policy. For the max duration it's called RoomOptions.CloseWarning
+// before the room is closed with the remaining time, and the clients get the room_closing data channel message.
+// For the empty room timeout it's called right before the room is closed with zero remaining time.
+// The callback must not block, the room is closed after it returns.
+func (r *Room) OnClosing(callback func(reason CloseReason, remaining time.Duration)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onClosingCallbacks = append(r.onClosingCallbacks, callback)
+}
+
+func (r *Room) onClosing(reason CloseReason, remaining time.Duration) {
+	r.mu.RLock()
+	callbacks := r.onClosingCallbacks
+	r.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		callback(reason, remaining)
+	}
+
+	if remaining <= 0 {
+		return
+	}
+
+	data, err := json.Marshal(internalDataRoomClosing{
+		Type: messageTypeRoomClosing,
+		Data: roomClosing{Reason: reason, RemainingMs: remaining.Milliseconds()},
+	})
+	if err != nil {
+		r.sfu.log.Errorf("room: error marshal room closing ", err)
+		return
+	}
+
+	for _, client := range r.sfu.GetClients() {
+		client.sendInternalMessage(data)
+	}
+}
+
+// OnRoomFull event is called when a client is not added because the room reached RoomOptions.MaxClients,
+// the AddClient returns ErrRoomIsFull after the callbacks are called
+func (r *Room) OnRoomFull(callback func(clientID string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onRoomFullCallbacks = append(r.onRoomFullCallbacks, callback)
+}
+
+func (r *Room) onRoomFull(clientID string) {
+	r.mu.RLock()
+	callbacks := r.onRoomFullCallbacks
+	r.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		callback(clientID)
+	}
+}
+
+// isFull returns true if the room reached the max clients, the suspended clients that can be resumed are counted.
+// It's checked when a client is added, moved to the room, or bridged with an ingest or a SIP call. The relay and the
+// transcoder clients that created by the SFU itself are never rejected.
+func (r *Room) isFull() bool {
+	r.admitMu.Lock()
+	defer r.admitMu.Unlock()
+
+	return r.isFullLocked()
+}
+
+// isFullLocked is isFull with the admitMu held, the admitted clients that not added yet are counted
+func (r *Room) isFullLocked() bool {
+	if r.options.MaxClients <= 0 {
+		return false
+	}
+
+	return r.sfu.clients.Length()+r.sessions.suspendedCount()+r.admitting >= r.options.MaxClients
+}
+
+// startRoomMaxDuration closes the room after the max duration, the OnClosing callbacks are called before with the warning
+func startRoomMaxDuration(m *Manager, room *Room, duration time.Duration) {
+	warning := min(room.closeWarning(), duration)
+
+	warningTimer := time.NewTimer(duration - warning)
+	defer warningTimer.Stop()
+
+	select {
+	case <-room.context.Done():
+		return
+	case <-warningTimer.C:
+		room.onClosing(CloseReasonMaxDuration, warning)
+	}
+
+	closeTimer := time.NewTimer(warning)
+	defer closeTimer.Stop()
+
+	select {
+	case <-room.context.Done():
+		return
+	case <-closeTimer.C:
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		_ = room.Close()
+		delete(m.rooms, room.id)
+
+		m.log.Infof("room %s is closed because it reached the max duration %s", room.id, duration)
+	}
+}
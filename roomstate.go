@@ -0,0 +1,209 @@
+package sfu
+
+import (
+	"reflect"
+	"sort"
+	"time"
+)
+
+// RoomState is the serializable snapshot of a room that returned by Room.State, it's meant for the admin dashboards
+type RoomState struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	State   string            `json:"state" enums:"open,closed"`
+	Time    time.Time         `json:"time"`
+	Clients []RoomClientState `json:"clients"`
+}
+
+type RoomClientState struct {
+	ID              string                   `json:"id"`
+	Name            string                   `json:"name"`
+	Role            ClientRole               `json:"role"`
+	ConnectionState string                   `json:"connection_state"`
+	InLobby         bool                     `json:"in_lobby,omitempty"`
+	Published       []PublishedTrackState    `json:"published_tracks"`
+	Subscriptions   []SubscriptionTrackState `json:"subscriptions"`
+}
+
+type PublishedTrackState struct {
+	ID        string    `json:"id"`
+	StreamID  string    `json:"stream_id"`
+	Kind      string    `json:"kind" enums:"audio,video"`
+	MimeType  string    `json:"mime_type"`
+	Source    TrackType `json:"source" enums:"media,camera,screen"`
+	Label     string    `json:"label,omitempty"`
+	Simulcast bool      `json:"simulcast"`
+}
+
+// SubscriptionTrackState is a track of another client that the client subscribes to
+type SubscriptionTrackState struct {
+	TrackID     string `json:"track_id"`
+	PublisherID string `json:"publisher_id"`
+	Kind        string `json:"kind" enums:"audio,video"`
+	// Quality is the quality level that currently forwarded to the client
+	Quality    QualityLevel `json:"quality"`
+	MaxQuality QualityLevel `json:"max_quality"`
+	Paused     bool         `json:"paused"`
+}
+
+// RoomStateDiff is the change of the room state since the previous diff, a client that changed anything like the connection
+// state, a track, or the quality of a subscription is sent with its whole state
+type RoomStateDiff struct {
+	Time           time.Time         `json:"time"`
+	State          string            `json:"state,omitempty"`
+	ClientsAdded   []RoomClientState `json:"clients_added,omitempty"`
+	ClientsUpdated []RoomClientState `json:"clients_updated,omitempty"`
+	ClientsRemoved []string          `json:"clients_removed,omitempty"`
+}
+
+// IsEmpty returns true if nothing is changed
+func (d RoomStateDiff) IsEmpty() bool {
+	return d.State == "" && len(d.ClientsAdded) == 0 && len(d.ClientsUpdated) == 0 && len(d.ClientsRemoved) == 0
+}
+
+// State returns the snapshot of the clients in the room with their published tracks, subscriptions, and connection states.
+// The clients and the tracks are sorted by ID, so the snapshots are comparable.
+func (r *Room) State() RoomState {
+	r.mu.RLock()
+	state := r.state
+	r.mu.RUnlock()
+
+	room := RoomState{
+		ID:      r.id,
+		Name:    r.name,
+		State:   state,
+		Time:    time.Now(),
+		Clients: make([]RoomClientState, 0),
+	}
+
+	for _, client := range r.sfu.GetClients() {
+		room.Clients = append(room.Clients, client.roomState())
+	}
+
+	sort.Slice(room.Clients, func(i, j int) bool {
+		return room.Clients[i].ID < room.Clients[j].ID
+	})
+
+	return room
+}
+
+func (c *Client) roomState() RoomClientState {
+	client := RoomClientState{
+		ID:              c.id,
+		Name:            c.name,
+		Role:            c.Role(),
+		ConnectionState: c.peerConnection.PC().ConnectionState().String(),
+		InLobby:         c.IsInLobby(),
+		Published:       make([]PublishedTrackState, 0),
+		Subscriptions:   make([]SubscriptionTrackState, 0),
+	}
+
+	for _, track := range c.Tracks() {
+		client.Published = append(client.Published, PublishedTrackState{
+			ID:        track.ID(),
+			StreamID:  track.StreamID(),
+			Kind:      track.Kind().String(),
+			MimeType:  track.MimeType(),
+			Source:    track.SourceType(),
+			Label:     track.Source().Label,
+			Simulcast: track.IsSimulcast(),
+		})
+	}
+
+	for id, track := range c.ClientTracks() {
+		subscription := SubscriptionTrackState{
+			TrackID:    id,
+			Kind:       track.Kind().String(),
+			Quality:    track.Quality(),
+			MaxQuality: track.MaxQuality(),
+			Paused:     c.isTrackPaused(id),
+		}
+
+		if publisherTrack, err := c.publishedTracks.Get(id); err == nil {
+			subscription.PublisherID = publisherTrack.ClientID()
+		}
+
+		client.Subscriptions = append(client.Subscriptions, subscription)
+	}
+
+	sort.Slice(client.Published, func(i, j int) bool {
+		return client.Published[i].ID < client.Published[j].ID
+	})
+
+	sort.Slice(client.Subscriptions, func(i, j int) bool {
+		return client.Subscriptions[i].TrackID < client.Subscriptions[j].TrackID
+	})
+
+	return client
+}
+
+// diffRoomState returns the changes from the previous to the current state
+func diffRoomState(previous, current RoomState) RoomStateDiff {
+	diff := RoomStateDiff{Time: current.Time}
+
+	if previous.State != current.State {
+		diff.State = current.State
+	}
+
+	previousClients := make(map[string]RoomClientState, len(previous.Clients))
+	for _, client := range previous.Clients {
+		previousClients[client.ID] = client
+	}
+
+	for _, client := range current.Clients {
+		previousClient, ok := previousClients[client.ID]
+		delete(previousClients, client.ID)
+
+		if !ok {
+			diff.ClientsAdded = append(diff.ClientsAdded, client)
+		} else if !reflect.DeepEqual(previousClient, client) {
+			diff.ClientsUpdated = append(diff.ClientsUpdated, client)
+		}
+	}
+
+	for id := range previousClients {
+		diff.ClientsRemoved = append(diff.ClientsRemoved, id)
+	}
+
+	sort.Strings(diff.ClientsRemoved)
+
+	return diff
+}
+
+// OnStateChanged event is called with the changes of the room state every RoomOptions.StateDiffInterval,
+// it's not called if nothing is changed. Use Room.State to get the initial state.
+func (r *Room) OnStateChanged(callback func(diff RoomStateDiff)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onStateChangedCallbacks = append(r.onStateChangedCallbacks, callback)
+}
+
+func (r *Room) loopStateDiff(interval time.Duration, previous RoomState) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.context.Done():
+			return
+		case <-ticker.C:
+			current := r.State()
+
+			diff := diffRoomState(previous, current)
+			previous = current
+
+			if diff.IsEmpty() {
+				continue
+			}
+
+			r.mu.RLock()
+			callbacks := r.onStateChangedCallbacks
+			r.mu.RUnlock()
+
+			for _, callback := range callbacks {
+				callback(diff)
+			}
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/interceptors/avsync"
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/srtp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// number of packets can be buffered before the packets dropped when the network is slow
+	rtpForwarderPacketBufferSize = 512
+
+	rtpForwarderMaxPacketSize = 1500
+)
+
+var ErrRTPForwarderClosed = errors.New("rtpforwarder: forwarder is closed")
+
+type RTPForwarderOptions struct {
+	// SSRC of the forwarded packets, default is 0 means a random SSRC
+	SSRC uint32 `json:"ssrc"`
+	// PayloadType of the forwarded packets, default is 0 means the payload type of the track
+	PayloadType uint8 `json:"payload_type"`
+	// Quality is the simulcast layer that forwarded, default is high. It's ignored if the track is not simulcast
+	Quality QualityLevel `json:"quality"`
+	// RTCPAddr is the destination of the RTCP sender reports, default is empty means the next port of the RTP
+	// destination like RFC 3550. Set it to the RTP destination to multiplex the RTP and RTCP on the same port
+	RTCPAddr string `json:"rtcp_addr"`
+	// SenderReportInterval is the interval of the RTCP sender reports. Default is 1 second
+	SenderReportInterval time.Duration `json:"sender_report_interval_ns"`
+	// SRTPKey is the base64 of the concatenated SRTP master key and salt, like the FFmpeg srtp_in_params or the
+	// SDES inline key. Default is empty means the packets are sent as plain RTP
+	SRTPKey string `json:"srtp_key"`
+	// SRTPProfile of the SRTPKey, default is AES_CM_128_HMAC_SHA1_80
+	SRTPProfile srtp.ProtectionProfile `json:"srtp_profile"`
+}
+
+func DefaultRTPForwarderOptions() RTPForwarderOptions {
+	return RTPForwarderOptions{
+		Quality:              QualityHigh,
+		SenderReportInterval: time.Second,
+	}
+}
+
+// RTPForwarder sends the packets of a track to a UDP destination, so the external tools like the analyzers or the
+// transcoders can tap a track without WebRTC. The SSRC is rewritten to the forwarder SSRC, and the RTCP sender
+// reports are sent so the receiver can sync the audio and video of the same client. On the plain RTP the PLI and FIR
+// from the receiver request a keyframe from the publisher. The forwarder is closed when the track is ended.
+type RTPForwarder struct {
+	mu          sync.Mutex
+	context     context.Context
+	cancel      context.CancelFunc
+	track       ITrack
+	options     RTPForwarderOptions
+	clockRate   uint32
+	rtpConn     net.Conn
+	rtcpConn    net.Conn
+	srtp        *srtp.Context
+	packets     chan *rtp.Packet
+	started     bool
+	lastTS      uint32
+	lastTime    time.Time
+	packetsSent uint32
+	octetsSent  uint32
+	onClosed    func()
+	log         logging.LeveledLogger
+	hotPathLog  logging.LeveledLogger
+	// unregisters the read callback of the track once the forwarder is closed
+	unregister func()
+}
+
+// ForwardTo forwards the packets of the track to the UDP address as RTP, see RTPForwarder
+func (t *Track) ForwardTo(addr string, opts RTPForwarderOptions) (*RTPForwarder, error) {
+	return newRTPForwarder(t, t.base, addr, opts)
+}
+
+// ForwardTo forwards the packets of the layer in the options to the UDP address as RTP, see RTPForwarder
+func (t *SimulcastTrack) ForwardTo(addr string, opts RTPForwarderOptions) (*RTPForwarder, error) {
+	return newRTPForwarder(t, t.base, addr, opts)
+}
+
+func newRTPForwarder(track ITrack, base *baseTrack, addr string, opts RTPForwarderOptions) (*RTPForwarder, error) {
+	defaults := DefaultRTPForwarderOptions()
+
+	if opts.Quality == 0 {
+		opts.Quality = defaults.Quality
+	}
+
+	if opts.SenderReportInterval <= 0 {
+		opts.SenderReportInterval = defaults.SenderReportInterval
+	}
+
+	if opts.SSRC == 0 {
+		opts.SSRC = rand.Uint32()
+	}
+
+	if opts.PayloadType == 0 {
+		opts.PayloadType = uint8(base.codec.PayloadType)
+	}
+
+	if opts.RTCPAddr == "" {
+		rtcpAddr, err := nextPortAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.RTCPAddr = rtcpAddr
+	}
+
+	var srtpContext *srtp.Context
+
+	if opts.SRTPKey != "" {
+		var err error
+		if srtpContext, err = newRTPIngestSRTPContext(opts.SRTPKey, opts.SRTPProfile); err != nil {
+			return nil, err
+		}
+	}
+
+	rtpConn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	rtcpConn := rtpConn
+
+	if opts.RTCPAddr != addr {
+		if rtcpConn, err = net.Dial("udp", opts.RTCPAddr); err != nil {
+			_ = rtpConn.Close()
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(track.Context())
+
+	f := &RTPForwarder{
+		context:    ctx,
+		cancel:     cancel,
+		track:      track,
+		options:    opts,
+		clockRate:  base.codec.ClockRate,
+		rtpConn:    rtpConn,
+		rtcpConn:   rtcpConn,
+		srtp:       srtpContext,
+		packets:    make(chan *rtp.Packet, rtpForwarderPacketBufferSize),
+		log:        base.client.log,
+		hotPathLog: base.client.hotPathLog,
+	}
+
+	f.unregister = track.OnRead(f.onRead)
+
+	go f.run()
+
+	// the RTCP of the receiver is encrypted with the receiver key that is unknown to the forwarder
+	if srtpContext == nil {
+		go f.readRTCP()
+	}
+
+	// the receiver can't decode until the next keyframe
+	f.requestKeyframe()
+
+	return f, nil
+}
+
+// nextPortAddr returns the address with the next port, the RTCP port of the RTP address
+func nextPortAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(portNumber+1)), nil
+}
+
+// SSRC returns the SSRC of the forwarded packets
+func (f *RTPForwarder) SSRC() uint32 {
+	return f.options.SSRC
+}
+
+// Close stops forwarding the packets
+func (f *RTPForwarder) Close() error {
+	if f.context.Err() != nil {
+		return ErrRTPForwarderClosed
+	}
+
+	// the closed forwarder doesn't keep the track from being paused
+	f.unregister()
+	f.cancel()
+
+	return nil
+}
+
+// OnClosed event is called when the forwarder is closed or the track is ended
+func (f *RTPForwarder) OnClosed(callback func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.onClosed = callback
+}
+
+func (f *RTPForwarder) onRead(_ interceptor.Attributes, p *rtp.Packet, quality QualityLevel) {
+	if f.context.Err() != nil {
+		return
+	}
+
+	if f.track.IsSimulcast() && quality != f.options.Quality {
+		return
+	}
+
+	// the payload is shared with the subscribers, the packet is rewritten on another goroutine
+	packet := p.Clone()
+
+	select {
+	case f.packets <- packet:
+	default:
+		f.hotPathLog.Warnf("rtpforwarder: packet buffer of track %s is full, drop packet", f.track.ID())
+	}
+}
+
+func (f *RTPForwarder) run() {
+	ticker := time.NewTicker(f.options.SenderReportInterval)
+
+	defer func() {
+		ticker.Stop()
+
+		_ = f.rtpConn.Close()
+		if f.rtcpConn != f.rtpConn {
+			_ = f.rtcpConn.Close()
+		}
+
+		f.mu.Lock()
+		onClosed := f.onClosed
+		f.mu.Unlock()
+
+		if onClosed != nil {
+			onClosed()
+		}
+	}()
+
+	for {
+		select {
+		case <-f.context.Done():
+			return
+		case p := <-f.packets:
+			if err := f.writeRTP(p); err != nil {
+				f.log.Debugf("rtpforwarder: error write packet of track %s: %s", f.track.ID(), err.Error())
+			}
+		case <-ticker.C:
+			if err := f.writeSenderReport(time.Now()); err != nil {
+				f.log.Debugf("rtpforwarder: error write sender report of track %s: %s", f.track.ID(), err.Error())
+			}
+		}
+	}
+}
+
+func (f *RTPForwarder) writeRTP(p *rtp.Packet) error {
+	p.SSRC = f.options.SSRC
+	p.PayloadType = f.options.PayloadType
+
+	buf, err := p.Marshal()
+	if err != nil {
+		return err
+	}
+
+	if f.srtp != nil {
+		if buf, err = f.srtp.EncryptRTP(nil, buf, &p.Header); err != nil {
+			return err
+		}
+	}
+
+	if _, err := f.rtpConn.Write(buf); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.started = true
+	f.lastTS = p.Timestamp
+	f.lastTime = time.Now()
+	f.packetsSent++
+	f.octetsSent += uint32(len(p.Payload))
+	f.mu.Unlock()
+
+	return nil
+}
+
+// writeSenderReport sends the sender report that maps the current time to the RTP timestamp of the last packet
+// advanced by the elapsed time, it's not sent until the first packet is forwarded
+func (f *RTPForwarder) writeSenderReport(now time.Time) error {
+	f.mu.Lock()
+
+	if !f.started {
+		f.mu.Unlock()
+		return nil
+	}
+
+	report := &rtcp.SenderReport{
+		SSRC:        f.options.SSRC,
+		NTPTime:     avsync.ToNTP(now),
+		RTPTime:     f.lastTS + uint32(now.Sub(f.lastTime).Seconds()*float64(f.clockRate)),
+		PacketCount: f.packetsSent,
+		OctetCount:  f.octetsSent,
+	}
+
+	f.mu.Unlock()
+
+	buf, err := report.Marshal()
+	if err != nil {
+		return err
+	}
+
+	if f.srtp != nil {
+		header := &rtcp.Header{}
+		if err := header.Unmarshal(buf); err != nil {
+			return err
+		}
+
+		if buf, err = f.srtp.EncryptRTCP(nil, buf, header); err != nil {
+			return err
+		}
+	}
+
+	_, err = f.rtcpConn.Write(buf)
+
+	return err
+}
+
+// readRTCP requests a keyframe when the receiver sends a PLI or FIR, the RTP packets on the multiplexed port are ignored
+func (f *RTPForwarder) readRTCP() {
+	buf := make([]byte, rtpForwarderMaxPacketSize)
+
+	for {
+		n, err := f.rtcpConn.Read(buf)
+		if err != nil {
+			if f.context.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			// the ICMP port unreachable error is returned until the receiver is started
+			continue
+		}
+
+		packets, err := rtcp.Unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		for _, packet := range packets {
+			switch packet.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				f.requestKeyframe()
+			}
+		}
+	}
+}
+
+func (f *RTPForwarder) requestKeyframe() {
+	switch t := f.track.(type) {
+	case *Track:
+		if t.Kind() == webrtc.RTPCodecTypeVideo {
+			t.remoteTrack.SendPLI()
+		}
+	case *SimulcastTrack:
+		t.sendPLIAt(f.options.Quality)
+	}
+}
@@ -0,0 +1,155 @@
+package sfu
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/srtp/v3"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestForwardedTrack(ctx context.Context) *Track {
+	log := logging.NewDefaultLoggerFactory().NewLogger("test")
+
+	return &Track{
+		context: ctx,
+		base: &baseTrack{
+			id:     "audio",
+			kind:   webrtc.RTPCodecTypeAudio,
+			codec:  webrtc.RTPCodecParameters{PayloadType: 111, RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000}},
+			client: &Client{log: log, hotPathLog: log},
+		},
+	}
+}
+
+func TestRTPForwarder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer rtpConn.Close()
+
+	rtcpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer rtcpConn.Close()
+
+	track := newTestForwardedTrack(ctx)
+
+	opts := DefaultRTPForwarderOptions()
+	opts.SSRC = 1234
+	opts.PayloadType = 96
+	opts.RTCPAddr = rtcpConn.LocalAddr().String()
+	opts.SenderReportInterval = 20 * time.Millisecond
+
+	forwarder, err := track.ForwardTo(rtpConn.LocalAddr().String(), opts)
+	require.NoError(t, err)
+
+	closed := make(chan struct{})
+	forwarder.OnClosed(func() {
+		close(closed)
+	})
+
+	payload := []byte{0x01, 0x02, 0x03}
+	track.onRead(nil, &rtp.Packet{Header: rtp.Header{Version: 2, SSRC: 5678, PayloadType: 111, SequenceNumber: 10, Timestamp: 960}, Payload: payload}, QualityHigh)
+
+	buf := make([]byte, 1500)
+
+	require.NoError(t, rtpConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := rtpConn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	packet := &rtp.Packet{}
+	require.NoError(t, packet.Unmarshal(buf[:n]))
+	require.Equal(t, uint32(1234), packet.SSRC)
+	require.Equal(t, uint8(96), packet.PayloadType)
+	require.Equal(t, uint16(10), packet.SequenceNumber)
+	require.Equal(t, payload, packet.Payload)
+
+	// the sender report counts the forwarded packet with the forwarder SSRC
+	require.NoError(t, rtcpConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err = rtcpConn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	packets, err := rtcp.Unmarshal(buf[:n])
+	require.NoError(t, err)
+
+	report, ok := packets[0].(*rtcp.SenderReport)
+	require.True(t, ok)
+	require.Equal(t, uint32(1234), report.SSRC)
+	require.Equal(t, uint32(1), report.PacketCount)
+	require.Equal(t, uint32(len(payload)), report.OctetCount)
+	require.GreaterOrEqual(t, report.RTPTime, uint32(960))
+
+	require.NoError(t, forwarder.Close())
+	require.ErrorIs(t, forwarder.Close(), ErrRTPForwarderClosed)
+
+	// the closed forwarder doesn't read the track anymore
+	require.Empty(t, track.onReadCallbacks)
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the forwarder to close")
+	}
+}
+
+func TestRTPForwarderSRTP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer rtpConn.Close()
+
+	keying := make([]byte, 30)
+	for i := range keying {
+		keying[i] = byte(i)
+	}
+
+	track := newTestForwardedTrack(ctx)
+
+	opts := DefaultRTPForwarderOptions()
+	opts.RTCPAddr = rtpConn.LocalAddr().String()
+	opts.SRTPKey = base64.StdEncoding.EncodeToString(keying)
+
+	_, err = track.ForwardTo(rtpConn.LocalAddr().String(), RTPForwarderOptions{SRTPKey: "invalid"})
+	require.ErrorIs(t, err, ErrRTPIngestInvalidKey)
+
+	forwarder, err := track.ForwardTo(rtpConn.LocalAddr().String(), opts)
+	require.NoError(t, err)
+
+	defer forwarder.Close()
+
+	payload := []byte{0x01, 0x02, 0x03}
+	track.onRead(nil, &rtp.Packet{Header: rtp.Header{Version: 2, SSRC: 5678, SequenceNumber: 10, Timestamp: 960}, Payload: payload}, QualityHigh)
+
+	buf := make([]byte, 1500)
+
+	require.NoError(t, rtpConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := rtpConn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	srtpContext, err := srtp.CreateContext(keying[:16], keying[16:], srtp.ProtectionProfileAes128CmHmacSha1_80)
+	require.NoError(t, err)
+
+	header := &rtp.Header{}
+	decrypted, err := srtpContext.DecryptRTP(nil, buf[:n], header)
+	require.NoError(t, err)
+
+	packet := &rtp.Packet{}
+	require.NoError(t, packet.Unmarshal(decrypted))
+	require.Equal(t, forwarder.SSRC(), packet.SSRC)
+	require.Equal(t, uint8(111), packet.PayloadType)
+	require.Equal(t, payload, packet.Payload)
+}
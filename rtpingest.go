@@ -0,0 +1,365 @@
+package sfu
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
+	"github.com/pion/srtp/v3"
+	"github.com/pion/webrtc/v4"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	rtpIngestChannelSize = 512
+	// the RTP payload size of the packetized samples
+	rtpIngestMTU = 1200
+)
+
+var (
+	ErrRTPIngestClosed       = errors.New("rtpingest: ingest is closed")
+	ErrRTPIngestCodec        = errors.New("rtpingest: codec is not supported")
+	ErrRTPIngestInvalidKey   = errors.New("rtpingest: invalid srtp key, it must be the base64 of the master key and salt")
+	ErrRTPIngestStreamExists = errors.New("rtpingest: stream with the same kind is already added")
+)
+
+// RTPStreamOptions is the media that sent by the publisher to a port, like the udpsink of a GStreamer pipeline or
+// the rtp output of FFmpeg
+type RTPStreamOptions struct {
+	// MimeType of the stream, the room codecs must include it so the WebRTC clients can receive it without transcoding
+	MimeType string `json:"mime_type"`
+	// PayloadType of the stream, the packets with the other payload types are dropped
+	PayloadType uint8 `json:"payload_type"`
+	// SRTPKey is the base64 of the concatenated SRTP master key and salt, like the FFmpeg srtp_out_params or the
+	// SDES inline key. Default is empty means the stream is plain RTP
+	SRTPKey string `json:"srtp_key"`
+	// SRTPProfile of the SRTPKey, default is AES_CM_128_HMAC_SHA1_80
+	SRTPProfile srtp.ProtectionProfile `json:"srtp_profile"`
+}
+
+type RTPIngestOptions struct {
+	// StreamID of the published tracks, default is the ingest client ID
+	StreamID      string        `json:"stream_id"`
+	ClientOptions ClientOptions `json:"client_options"`
+}
+
+func DefaultRTPIngestOptions() RTPIngestOptions {
+	return RTPIngestOptions{
+		ClientOptions: DefaultClientOptions(),
+	}
+}
+
+// RTPIngest publishes the RTP streams from a non WebRTC publisher, like a GStreamer or FFmpeg pipeline, to a room.
+// Each stream is received on its own port and published as a relay track owned by the ingest client.
+type RTPIngest struct {
+	mu       sync.Mutex
+	context  context.Context
+	cancel   context.CancelFunc
+	done     chan bool
+	room     *Room
+	streamID string
+	// the ingest client that owns the relay tracks
+	client  *Client
+	streams []*rtpIngestStream
+	log     logging.LeveledLogger
+}
+
+type rtpIngestStream struct {
+	conn    net.PacketConn
+	options RTPStreamOptions
+	srtp    *srtp.Context
+	track   ITrack
+	rtpChan chan *rtp.Packet
+}
+
+// IngestRTP creates the ingest client in the room, add the streams with RTPIngest.AddStream. The ingest is closed
+// when it's closed or the room is closed.
+func (r *Room) IngestRTP(name string, opts RTPIngestOptions) (*RTPIngest, error) {
+	if r.context.Err() != nil {
+		return nil, ErrRoomIsClosed
+	}
+
+	// the ingest is a participant of the room
+	clientID := r.CreateClientID()
+	if r.isFull() {
+		r.onRoomFull(clientID)
+		return nil, ErrRoomIsFull
+	}
+
+	ctx, cancel := context.WithCancel(r.context)
+
+	clientOpts := opts.ClientOptions
+	clientOpts.Type = ClientTypeUpBridge
+
+	ingest := &RTPIngest{
+		context: ctx,
+		cancel:  cancel,
+		done:    make(chan bool),
+		room:    r,
+		// the ingest client never connects, it's only the owner of the relay tracks in the room
+		client:  r.sfu.NewClient(clientID, name, clientOpts),
+		streams: make([]*rtpIngestStream, 0),
+		log:     r.sfu.log,
+	}
+
+	ingest.streamID = opts.StreamID
+	if ingest.streamID == "" {
+		ingest.streamID = ingest.client.ID()
+	}
+
+	go ingest.closeOnDone()
+
+	return ingest, nil
+}
+
+// ClientID returns the ID of the ingest client that publishes the tracks
+func (i *RTPIngest) ClientID() string {
+	return i.client.ID()
+}
+
+// AddStream publishes the RTP packets that received on the conn as a track, one audio and one video stream can be
+// added to an ingest. The conn is closed when the ingest is closed.
+//
+// The SFU can't request a keyframe from the publisher, so set a short keyframe interval on the video encoder.
+func (i *RTPIngest) AddStream(conn net.PacketConn, opts RTPStreamOptions) (ITrack, error) {
+	var srtpContext *srtp.Context
+
+	if opts.SRTPKey != "" {
+		var err error
+
+		srtpContext, err = newRTPIngestSRTPContext(opts.SRTPKey, opts.SRTPProfile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stream, err := i.addStream(conn, opts.MimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	stream.options = opts
+	stream.srtp = srtpContext
+
+	go i.readLoop(stream)
+
+	return stream.track, nil
+}
+
+// addStream publishes the relay track of the stream, the conn is nil if the packets are written by the caller
+func (i *RTPIngest) addStream(conn net.PacketConn, mimeType string) (*rtpIngestStream, error) {
+	codec := getCodecCapability(mimeType)
+	if codec.MimeType == "" {
+		return nil, ErrRTPIngestCodec
+	}
+
+	kind := webrtc.RTPCodecTypeAudio
+	if strings.HasPrefix(strings.ToLower(codec.MimeType), "video/") {
+		kind = webrtc.RTPCodecTypeVideo
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.context.Err() != nil {
+		return nil, ErrRTPIngestClosed
+	}
+
+	for _, s := range i.streams {
+		if s.track.Kind() == kind {
+			return nil, ErrRTPIngestStreamExists
+		}
+	}
+
+	stream := &rtpIngestStream{
+		conn:    conn,
+		rtpChan: make(chan *rtp.Packet, rtpIngestChannelSize),
+	}
+
+	remoteTrack := NewTrackRelay(i.client.ID()+"-"+kind.String(), i.streamID, "", kind, webrtc.SSRC(rand.Uint32()), codec.MimeType, stream.rtpChan)
+	stream.track = i.room.sfu.addRelayTrack(i.context, remoteTrack, i.client, TrackSource{Type: TrackTypeMedia}, func() {})
+
+	i.streams = append(i.streams, stream)
+
+	return stream, nil
+}
+
+func newRTPIngestSRTPContext(key string, profile srtp.ProtectionProfile) (*srtp.Context, error) {
+	if profile == 0 {
+		profile = srtp.ProtectionProfileAes128CmHmacSha1_80
+	}
+
+	keyLen, err := profile.KeyLen()
+	if err != nil {
+		return nil, err
+	}
+
+	saltLen, err := profile.SaltLen()
+	if err != nil {
+		return nil, err
+	}
+
+	keying, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(keying) != keyLen+saltLen {
+		return nil, ErrRTPIngestInvalidKey
+	}
+
+	return srtp.CreateContext(keying[:keyLen], keying[keyLen:], profile)
+}
+
+// Tracks returns the relay tracks of the added streams
+func (i *RTPIngest) Tracks() []ITrack {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	tracks := make([]ITrack, 0, len(i.streams))
+	for _, stream := range i.streams {
+		tracks = append(tracks, stream.track)
+	}
+
+	return tracks
+}
+
+// Close ends the relay tracks and closes the conns of the streams
+func (i *RTPIngest) Close() error {
+	if i.context.Err() != nil {
+		<-i.done
+		return ErrRTPIngestClosed
+	}
+
+	i.cancel()
+	<-i.done
+
+	return nil
+}
+
+func (i *RTPIngest) closeOnDone() {
+	<-i.context.Done()
+
+	i.mu.Lock()
+	for _, stream := range i.streams {
+		if stream.conn != nil {
+			_ = stream.conn.Close()
+		}
+
+		close(stream.rtpChan)
+	}
+	i.mu.Unlock()
+
+	_ = i.client.stop()
+
+	close(i.done)
+}
+
+func (i *RTPIngest) readLoop(stream *rtpIngestStream) {
+	buf := make([]byte, 1500)
+
+	for {
+		n, _, err := stream.conn.ReadFrom(buf)
+		if err != nil {
+			if i.context.Err() == nil {
+				i.log.Errorf("rtpingest: read error: %s", err.Error())
+				i.cancel()
+			}
+
+			return
+		}
+
+		// the RTCP from the publisher is ignored
+		if n < 2 || (buf[1] >= 192 && buf[1] <= 223) {
+			continue
+		}
+
+		// the buffer is reused for the next packet
+		data := slices.Clone(buf[:n])
+
+		if stream.srtp != nil {
+			header := &rtp.Header{}
+			data, err = stream.srtp.DecryptRTP(data, data, header)
+			if err != nil {
+				i.log.Warnf("rtpingest: failed to decrypt srtp: %s", err.Error())
+				continue
+			}
+		}
+
+		p := &rtp.Packet{}
+		if err := p.Unmarshal(data); err != nil {
+			i.log.Errorf("rtpingest: failed to unmarshal rtp: %s", err.Error())
+			continue
+		}
+
+		if p.PayloadType != stream.options.PayloadType {
+			continue
+		}
+
+		i.writeRTP(stream, p)
+	}
+}
+
+func (i *RTPIngest) writeRTP(stream *rtpIngestStream, p *rtp.Packet) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.context.Err() != nil {
+		return
+	}
+
+	select {
+	case stream.rtpChan <- p:
+	default:
+		i.log.Warnf("rtpingest: relay track %s buffer is full, packet is dropped", stream.track.ID())
+	}
+}
+
+// rtpIngestPacketizer packetizes the encoded samples of the ingest that not received as RTP
+type rtpIngestPacketizer struct {
+	payloader rtp.Payloader
+	clockRate uint32
+	ssrc      uint32
+	sequence  uint16
+}
+
+func newRTPIngestPacketizer(mimeType string) (*rtpIngestPacketizer, error) {
+	codec := getCodecCapability(mimeType)
+
+	payloader, err := PayloaderForCodec(codec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rtpIngestPacketizer{
+		payloader: payloader,
+		clockRate: codec.ClockRate,
+		ssrc:      rand.Uint32(),
+		sequence:  uint16(rand.Uint32()),
+	}, nil
+}
+
+// packetize returns the RTP packets of the sample, the marker is set on the last packet
+func (p *rtpIngestPacketizer) packetize(timestamp uint32, sample []byte) []*rtp.Packet {
+	payloads := p.payloader.Payload(rtpIngestMTU, sample)
+	packets := make([]*rtp.Packet, 0, len(payloads))
+
+	for i, payload := range payloads {
+		packets = append(packets, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         i == len(payloads)-1,
+				SequenceNumber: p.sequence,
+				Timestamp:      timestamp,
+				SSRC:           p.ssrc,
+			},
+			Payload: payload,
+		})
+
+		p.sequence++
+	}
+
+	return packets
+}
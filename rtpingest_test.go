@@ -0,0 +1,136 @@
+package sfu
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/srtp/v3"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRTPIngest(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-rtp-ingest-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	_, subscriber, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "subscriber", true, false, true)
+
+	ingest, err := testRoom.IngestRTP("pipeline", DefaultRTPIngestOptions())
+	require.NoError(t, err)
+
+	// the master key and salt of AES_CM_128_HMAC_SHA1_80
+	keying := make([]byte, 30)
+	for i := range keying {
+		keying[i] = byte(i)
+	}
+
+	streamOpts := RTPStreamOptions{
+		MimeType:    webrtc.MimeTypeOpus,
+		PayloadType: 111,
+		SRTPKey:     "invalid",
+	}
+
+	ingestConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	_, err = ingest.AddStream(ingestConn, streamOpts)
+	require.ErrorIs(t, err, ErrRTPIngestInvalidKey)
+
+	streamOpts.SRTPKey = base64.StdEncoding.EncodeToString(keying)
+
+	track, err := ingest.AddStream(ingestConn, streamOpts)
+	require.NoError(t, err)
+	require.Equal(t, webrtc.RTPCodecTypeAudio, track.Kind())
+
+	_, err = ingest.AddStream(ingestConn, streamOpts)
+	require.ErrorIs(t, err, ErrRTPIngestStreamExists)
+
+	// the packet is released to the pool after the callback
+	received := make(chan rtp.Packet, 1)
+	track.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, _ QualityLevel) {
+		select {
+		case received <- *p.Clone():
+		default:
+		}
+	})
+
+	// the publisher side
+	publisherConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer publisherConn.Close()
+
+	encrypter, err := srtp.CreateContext(keying[:16], keying[16:], srtp.ProtectionProfileAes128CmHmacSha1_80)
+	require.NoError(t, err)
+
+	sendCtx, cancelSend := context.WithCancel(ctx)
+	defer cancelSend()
+
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+
+		for seq := uint16(0); ; seq++ {
+			packet := rtp.Packet{
+				Header:  rtp.Header{Version: 2, PayloadType: 111, SequenceNumber: seq, Timestamp: uint32(seq) * 960, SSRC: 5678},
+				Payload: make([]byte, 80),
+			}
+
+			buf, _ := packet.Marshal()
+			encrypted, err := encrypter.EncryptRTP(nil, buf, nil)
+			if err == nil {
+				_, _ = publisherConn.WriteTo(encrypted, ingestConn.LocalAddr())
+			}
+
+			select {
+			case <-sendCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	for {
+		if _, ok := subscriber.ClientTracks()[track.ID()]; ok {
+			break
+		}
+
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the ingest track")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	select {
+	case p := <-received:
+		require.Equal(t, uint8(111), p.PayloadType)
+		require.Len(t, p.Payload, 80)
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the decrypted packet")
+	}
+
+	cancelSend()
+
+	require.NoError(t, ingest.Close())
+	require.ErrorIs(t, ingest.Close(), ErrRTPIngestClosed)
+
+	_ = testRoom.StopClient(subscriber.ID())
+}
@@ -0,0 +1,153 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/packetmap"
+	"github.com/pion/rtp"
+)
+
+// RTPMunger rewrites the sequence numbers and timestamps of the forwarded packets, so the receiver sees one
+// continuous RTP stream while the SFU drops packets, inserts padding packets, or switches the source stream.
+//
+//   - Drop removes a packet from the stream, the next packets are shifted so the receiver doesn't see a gap and NACK it.
+//   - Switch continues the next packet of a new source right after the last packet, the timestamp is advanced by the
+//     elapsed time since the last packet.
+//   - Continue is Switch from a source that's not rewritten by the munger, the last packet is given instead.
+//   - InsertPadding reserves a sequence number after the last packet for a padding packet that sent by the SFU.
+//
+// The sequence numbers wrap around at 65535 like the RTP header. It's safe to use from multiple goroutines.
+type RTPMunger struct {
+	mu        sync.Mutex
+	clockRate uint32
+	seqmap    *packetmap.Map
+	started   bool
+	switching bool
+	switched  bool
+	// the first source sequence number after the switch, the older packets of the source are not forwarded
+	switchSeq uint16
+	seqOffset uint16
+	tsOffset  uint32
+	lastSeq   uint16
+	lastTS    uint32
+	lastTime  time.Time
+}
+
+// NewRTPMunger returns the munger for the codec clock rate, the clock rate is used to advance the timestamp when
+// the source is switched
+func NewRTPMunger(clockRate uint32) *RTPMunger {
+	return &RTPMunger{
+		clockRate: clockRate,
+		seqmap:    &packetmap.Map{},
+	}
+}
+
+// Switch makes the next packet that rewritten as the first packet of a new source stream. Switch the source only on
+// a keyframe, the munger doesn't parse the payload.
+func (m *RTPMunger) Switch() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.started {
+		m.switching = true
+	}
+}
+
+// Continue makes the next packet that rewritten as the first packet of a new source stream that continues after the
+// last packet of a stream that's not rewritten by this munger, for example when the publisher sends the track again.
+func (m *RTPMunger) Continue(lastSeq uint16, lastTS uint32, lastTime time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.started = true
+	m.switching = true
+	m.lastSeq = lastSeq
+	m.lastTS = lastTS
+	m.lastTime = lastTime
+}
+
+// Rewrite rewrites the sequence number and timestamp of the packet in place, it returns false if the packet can't
+// be forwarded: a duplicate, too late to be mapped, or older than the last switch.
+func (m *RTPMunger) Rewrite(p *rtp.Packet) bool {
+	return m.rewrite(p, time.Now())
+}
+
+func (m *RTPMunger) rewrite(p *rtp.Packet, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.started {
+		// the first source is forwarded with its own sequence numbers and timestamps
+		m.started = true
+		m.lastSeq = p.SequenceNumber - 1
+		m.lastTS = p.Timestamp
+		m.lastTime = now
+	} else if m.switching {
+		m.switching = false
+		m.switched = true
+		m.switchSeq = p.SequenceNumber
+		m.seqmap = &packetmap.Map{}
+
+		elapsed := uint32(0)
+		if now.After(m.lastTime) {
+			elapsed = uint32(uint64(now.Sub(m.lastTime).Microseconds()) * uint64(m.clockRate) / uint64(time.Second/time.Microsecond))
+		}
+
+		// the new frame must have a different timestamp with the last frame of the previous source
+		elapsed = max(elapsed, 1)
+
+		m.seqOffset = m.lastSeq + 1 - p.SequenceNumber
+		m.tsOffset = m.lastTS + elapsed - p.Timestamp
+	} else if m.switched && int16(p.SequenceNumber-m.switchSeq) < 0 {
+		// the sequence numbers before the switch are used by the previous source
+		return false
+	}
+
+	ok, seq, _ := m.seqmap.Map(p.SequenceNumber, 0)
+	if !ok {
+		return false
+	}
+
+	p.SequenceNumber = seq + m.seqOffset
+	p.Timestamp += m.tsOffset
+
+	// the reordered packets don't move the last position back
+	if int16(p.SequenceNumber-m.lastSeq) > 0 {
+		m.lastSeq = p.SequenceNumber
+		m.lastTS = p.Timestamp
+		m.lastTime = now
+	}
+
+	return true
+}
+
+// Drop removes the packet from the stream, the packet must be the next packet after the last rewritten or dropped
+// packet of the source. It returns false if the packet is out of order, the receiver sees a gap in that case.
+func (m *RTPMunger) Drop(p *rtp.Packet) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.started || m.switching {
+		return true
+	}
+
+	return m.seqmap.Drop(p.SequenceNumber, 0)
+}
+
+// InsertPadding reserves the sequence number after the last packet for a padding packet, it returns the sequence
+// number and the timestamp of the last packet. It returns false if no packet is rewritten yet. Insert the padding
+// after the last packet of a frame, the reordered packets of the frame are shifted by the padding.
+func (m *RTPMunger) InsertPadding() (uint16, uint32, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.started {
+		return 0, 0, false
+	}
+
+	m.lastSeq++
+	m.seqOffset++
+
+	return m.lastSeq, m.lastTS, true
+}
@@ -0,0 +1,168 @@
+package sfu
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// the requests are authorized with the Digest
+func rtspTestServer(listener net.Listener, packets chan []byte) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	reader := textproto.NewReader(bufio.NewReader(conn))
+
+	sps := base64.StdEncoding.EncodeToString([]byte{0x67, 0x42, 0xc0, 0x1f})
+	pps := base64.StdEncoding.EncodeToString([]byte{0x68, 0xce, 0x3c, 0x80})
+	description := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=camera\r\nt=0 0\r\n" +
+		"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=fmtp:96 packetization-mode=1;sprop-parameter-sets=" + sps + "," + pps + "\r\na=control:trackID=1\r\n" +
+		"m=audio 0 RTP/AVP 0\r\na=control:trackID=2\r\n"
+
+	for {
+		line, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+
+		method, _, _ := strings.Cut(line, " ")
+		response := "RTSP/1.0 200 OK\r\nCSeq: " + header.Get("CSeq") + "\r\n"
+
+		switch {
+		case !strings.HasPrefix(header.Get("Authorization"), `Digest username="admin"`):
+			response = "RTSP/1.0 401 Unauthorized\r\nCSeq: " + header.Get("CSeq") + "\r\nWWW-Authenticate: Digest realm=\"camera\", nonce=\"abc\"\r\n\r\n"
+		case method == "DESCRIBE":
+			response += fmt.Sprintf("Content-Type: application/sdp\r\nContent-Length: %d\r\n\r\n%s", len(description), description)
+		case method == "SETUP":
+			response += "Session: 12345;timeout=60\r\nTransport: " + header.Get("Transport") + "\r\n\r\n"
+		default:
+			response += "\r\n"
+		}
+
+		if _, err := conn.Write([]byte(response)); err != nil {
+			return
+		}
+
+		if method == "PLAY" {
+			break
+		}
+	}
+
+	for packet := range packets {
+		if _, err := conn.Write(packet); err != nil {
+			return
+		}
+	}
+}
+
+func TestRTSPIngest(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-rtsp-ingest-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	_, err = testRoom.IngestRTSP("camera", "http://127.0.0.1/stream", DefaultRTSPIngestOptions())
+	require.ErrorIs(t, err, ErrRTSPInvalidURL)
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer listener.Close()
+
+	packets := make(chan []byte, 10)
+	go rtspTestServer(listener, packets)
+
+	ingest, err := testRoom.IngestRTSP("camera", "rtsp://admin:secret@"+listener.Addr().String()+"/stream", DefaultRTSPIngestOptions())
+	require.NoError(t, err)
+	require.Len(t, ingest.Tracks(), 2)
+
+	received := make(map[webrtc.RTPCodecType]chan rtp.Packet)
+
+	for _, track := range ingest.Tracks() {
+		trackPackets := make(chan rtp.Packet, 10)
+		received[track.Kind()] = trackPackets
+
+		track.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, _ QualityLevel) {
+			select {
+			case trackPackets <- *p.Clone():
+			default:
+			}
+		})
+	}
+
+	interleaved := func(channel byte, p rtp.Packet) []byte {
+		buf, _ := p.Marshal()
+		return append([]byte{rtspInterleavedMagic, channel, byte(len(buf) >> 8), byte(len(buf))}, buf...)
+	}
+
+	// the FU-A start of an IDR and the PCMU audio
+	packets <- interleaved(0, rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 100, Timestamp: 9000, SSRC: 1}, Payload: []byte{0x7c, 0x85, 0x88, 0x84}})
+	packets <- interleaved(0, rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 101, Timestamp: 9000, SSRC: 1, Marker: true}, Payload: []byte{0x7c, 0x45, 0x00}})
+	packets <- interleaved(2, rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: 10, Timestamp: 160, SSRC: 2}, Payload: make([]byte, 160)})
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelTimeout()
+
+	video := make([]rtp.Packet, 0, 3)
+	for len(video) < 3 {
+		select {
+		case p := <-received[webrtc.RTPCodecTypeVideo]:
+			video = append(video, p)
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the video packets")
+		}
+	}
+
+	// the SPS and PPS are sent in a STAP-A before the keyframe
+	require.Equal(t, []byte{0x78, 0x00, 0x04, 0x67, 0x42, 0xc0, 0x1f, 0x00, 0x04, 0x68, 0xce, 0x3c, 0x80}, video[0].Payload)
+	require.Equal(t, uint16(100), video[0].SequenceNumber)
+	require.Equal(t, uint16(101), video[1].SequenceNumber)
+	require.Equal(t, uint16(102), video[2].SequenceNumber)
+	require.True(t, video[2].Marker)
+
+	select {
+	case p := <-received[webrtc.RTPCodecTypeAudio]:
+		require.Len(t, p.Payload, 160)
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the audio packet")
+	}
+
+	// the camera is disconnected
+	close(packets)
+
+	select {
+	case <-ingest.Done():
+		require.Error(t, ingest.Err())
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the ingest is ended")
+	}
+
+	require.ErrorIs(t, ingest.Close(), ErrRTPIngestClosed)
+}
@@ -0,0 +1,89 @@
+package sfu
+
+import (
+	"encoding/json"
+)
+
+// ScreenProfile configures how the screen share tracks are forwarded. A screen share needs the readable text more than
+// the smooth motion, so the screen tracks keep the resolution and drop the frame rate first when the bandwidth is low.
+type ScreenProfile struct {
+	// Presets maps the quality levels of the screen tracks to the spatial and temporal layers, the simulcast screen
+	// tracks only use the temporal layers. By default the spatial layer is kept and the temporal layer is reduced first.
+	Presets QualityPresets `json:"presets"`
+	// MinBitrate is the bitrate in bits per second that a screen track always gets from the room bitrate allocation
+	// before the other tracks, see RoomOptions.DownlinkBitrateBudget. Default is 300 kbps
+	MinBitrate uint32 `json:"min_bitrate" example:"300000" default:"300000"`
+	// MaxFramerate is the frame rate that the publisher is asked to send the screen with. Default is 15 fps
+	MaxFramerate uint32 `json:"max_framerate" example:"15" default:"15"`
+	// DegradationPreference is the RTCDegradationPreference that the publisher is asked to encode the screen with.
+	// Default is maintain-resolution
+	DegradationPreference string `json:"degradation_preference" enums:"maintain-resolution,maintain-framerate,balanced" example:"maintain-resolution"`
+}
+
+func DefaultScreenProfile() ScreenProfile {
+	return ScreenProfile{
+		Presets: QualityPresets{
+			High:    QualityPreset{SID: 2, TID: 2},
+			HighMid: QualityPreset{SID: 2, TID: 1},
+			HighLow: QualityPreset{SID: 2, TID: 0},
+			Mid:     QualityPreset{SID: 2, TID: 1},
+			MidMid:  QualityPreset{SID: 2, TID: 0},
+			MidLow:  QualityPreset{SID: 1, TID: 0},
+			Low:     QualityPreset{SID: 1, TID: 1},
+			LowMid:  QualityPreset{SID: 1, TID: 0},
+			LowLow:  QualityPreset{SID: 0, TID: 0},
+		},
+		MinBitrate:            300_000,
+		MaxFramerate:          15,
+		DegradationPreference: "maintain-resolution",
+	}
+}
+
+// screenProfileMessage is the data of the screen_profile message that sent to the publisher of a screen track
+type screenProfileMessage struct {
+	TrackID               string `json:"track_id"`
+	MinBitrate            uint32 `json:"min_bitrate"`
+	MaxFramerate          uint32 `json:"max_framerate"`
+	DegradationPreference string `json:"degradation_preference"`
+}
+
+type internalDataScreenProfile struct {
+	Type string               `json:"type"`
+	Data screenProfileMessage `json:"data"`
+}
+
+// ScreenProfile returns the profile of the screen share tracks in the room
+func (s *SFU) ScreenProfile() ScreenProfile {
+	return s.screenProfile
+}
+
+// trackQualityPreset returns the spatial and temporal layers of the quality level, the screen tracks use the presets
+// of the screen profile
+func (s *SFU) trackQualityPreset(lvl QualityLevel, isScreen bool) QualityPreset {
+	if isScreen && lvl != QualityNone {
+		return s.screenProfile.Presets.get(lvl)
+	}
+
+	return s.qualityLevelToPreset(lvl)
+}
+
+// sendScreenProfile asks the client to encode the published screen track with the screen profile
+func (c *Client) sendScreenProfile(trackID string) {
+	profile := c.SFU().screenProfile
+
+	data, err := json.Marshal(internalDataScreenProfile{
+		Type: messageTypeScreenProfile,
+		Data: screenProfileMessage{
+			TrackID:               trackID,
+			MinBitrate:            profile.MinBitrate,
+			MaxFramerate:          profile.MaxFramerate,
+			DegradationPreference: profile.DegradationPreference,
+		},
+	})
+	if err != nil {
+		c.log.Errorf("client: error marshal screen profile ", err)
+		return
+	}
+
+	c.sendInternalMessage(data)
+}
@@ -2,12 +2,15 @@ package sfu
 
 import (
 	"context"
+	"encoding/json"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/logging"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/slices"
 )
 
@@ -115,13 +118,34 @@ type SFU struct {
 	mu                        sync.Mutex
 	onStop                    func()
 	pliInterval               time.Duration
+	pliAggregator             *pliAggregator
 	onTrackAvailableCallbacks []func(tracks []ITrack)
 	onClientRemovedCallbacks  []func(*Client)
 	onClientAddedCallbacks    []func(*Client)
+	onDataMessageCallbacks    []func(DataChannelMessage)
 	relayTracks               map[string]ITrack
 	clientStats               map[string]*ClientStats
 	log                       logging.LeveledLogger
 	defaultSettingEngine      *webrtc.SettingEngine
+	tracer                    trace.Tracer
+	qualityPresets            atomic.Pointer[QualityPresets]
+	onQualityPresetChanged    []func(QualityPresets)
+	onMetadataCallbacks       []func(MetadataChanged)
+	metadata                  *jsonMetadata
+	maxMetadataSize           int
+	draining                  atomic.Bool
+	iceServersProvider        func(clientID string) ([]webrtc.ICEServer, error)
+	packetInterceptors        *packetInterceptors
+	transcoding               *transcoding
+	fanOut                    *fanOutScheduler
+	screenProfile             ScreenProfile
+	// register the codecs in the order of the codecs list, see RoomOptions.PreferCodecsInOrder
+	preferCodecsInOrder bool
+	headerExtensions    HeaderExtensions
+	// send the track catalog to the clients, see RoomOptions.TrackCatalog
+	trackCatalog   bool
+	catalogVersion atomic.Uint64
+	catalogMu      sync.Mutex
 }
 
 type PublishedTrack struct {
@@ -130,13 +154,27 @@ type PublishedTrack struct {
 }
 
 type sfuOptions struct {
-	IceServers    []webrtc.ICEServer
-	Bitrates      BitrateConfigs
-	QualityLevels []QualityLevel
-	Codecs        []string
-	PLIInterval   time.Duration
-	Log           logging.LeveledLogger
-	SettingEngine *webrtc.SettingEngine
+	IceServers     []webrtc.ICEServer
+	Bitrates       BitrateConfigs
+	QualityLevels  []QualityLevel
+	Codecs         []string
+	PLIInterval    time.Duration
+	PLIWindow      time.Duration
+	Log            logging.LeveledLogger
+	SettingEngine  *webrtc.SettingEngine
+	TracerProvider trace.TracerProvider
+	// the ICE servers of the client by the client ID, IceServers is used if it's nil
+	ICEServersProvider func(clientID string) ([]webrtc.ICEServer, error)
+	// the scheduler that shared by all rooms of the manager, nil if the subscribed tracks are written by their own goroutines
+	FanOut *fanOutScheduler
+	// the profile of the screen tracks, the default profile is used if it's nil
+	ScreenProfile *ScreenProfile
+	// register the codecs in the order of Codecs instead of the SFU order
+	PreferCodecsInOrder bool
+	// the header extensions that negotiated with the clients, DefaultHeaderExtensions is used if it's nil
+	HeaderExtensions *HeaderExtensions
+	// send the track catalog to the clients through the internal data channel
+	TrackCatalog bool
 }
 
 // @Param muxPort: port for udp mux
@@ -153,14 +191,35 @@ func New(ctx context.Context, opts sfuOptions) *SFU {
 		iceServers:                opts.IceServers,
 		bitrateConfigs:            opts.Bitrates,
 		pliInterval:               opts.PLIInterval,
+		pliAggregator:             newPLIAggregator(opts.PLIWindow),
 		relayTracks:               make(map[string]ITrack),
+		packetInterceptors:        newPacketInterceptors(),
 		onTrackAvailableCallbacks: make([]func(tracks []ITrack), 0),
 		onClientRemovedCallbacks:  make([]func(*Client), 0),
 		onClientAddedCallbacks:    make([]func(*Client), 0),
 		log:                       opts.Log,
 		defaultSettingEngine:      opts.SettingEngine,
+		tracer:                    newTracer(opts.TracerProvider),
+		metadata:                  &jsonMetadata{},
+		maxMetadataSize:           defaultMaxMetadataSize,
+		iceServersProvider:        opts.ICEServersProvider,
+		fanOut:                    opts.FanOut,
+		preferCodecsInOrder:       opts.PreferCodecsInOrder,
+		trackCatalog:              opts.TrackCatalog,
 	}
 
+	sfu.headerExtensions = DefaultHeaderExtensions()
+	if opts.HeaderExtensions != nil {
+		sfu.headerExtensions = *opts.HeaderExtensions
+	}
+
+	sfu.screenProfile = DefaultScreenProfile()
+	if opts.ScreenProfile != nil {
+		sfu.screenProfile = *opts.ScreenProfile
+	}
+
+	sfu.transcoding = newTranscoding(sfu)
+
 	return sfu
 }
 
@@ -185,13 +244,14 @@ func (s *SFU) createClient(id string, name string, peerConnectionConfig webrtc.C
 func (s *SFU) NewClient(id, name string, opts ClientOptions) *Client {
 	peerConnectionConfig := webrtc.Configuration{}
 
-	if len(s.iceServers) > 0 {
-		peerConnectionConfig.ICEServers = s.iceServers
+	if iceServers := s.iceServersFor(id); len(iceServers) > 0 {
+		peerConnectionConfig.ICEServers = iceServers
 	}
 
 	opts.Log = s.log
 
 	client := s.createClient(id, name, peerConnectionConfig, opts)
+	client.iceServers = peerConnectionConfig.ICEServers
 
 	s.addClient(client)
 
@@ -208,6 +268,33 @@ func (s *SFU) AvailableTracks() []ITrack {
 	return tracks
 }
 
+// availableTracksFor returns the tracks of the other clients and the relay tracks that the client doesn't receive yet
+func (s *SFU) availableTracksFor(client *Client) []ITrack {
+	availableTracks := make([]ITrack, 0)
+
+	for _, c := range s.clients.GetClients() {
+		if c.IsInLobby() {
+			continue
+		}
+
+		for _, track := range c.tracks.GetTracks() {
+			_, err := client.publishedTracks.Get(track.ID())
+			if track.ClientID() != client.ID() {
+				if err == ErrTrackIsNotExists {
+					availableTracks = append(availableTracks, track)
+				} else {
+					c.log.Errorf("client: track already exists")
+				}
+			}
+		}
+	}
+
+	// add relay tracks
+	availableTracks = append(availableTracks, s.relayTrackList()...)
+
+	return availableTracks
+}
+
 // Syncs track from connected client to other clients
 func (s *SFU) syncTrack(client *Client) {
 	publishedTrackIDs := make([]string, 0)
@@ -240,6 +327,7 @@ func (s *SFU) syncTrack(client *Client) {
 
 func (s *SFU) Stop() {
 	for _, client := range s.clients.GetClients() {
+		client.leaving.Store(true)
 		client.PeerConnection().Close()
 	}
 
@@ -291,8 +379,13 @@ func (s *SFU) onClientRemoved(client *Client) {
 }
 
 func (s *SFU) onTracksAvailable(clientId string, tracks []ITrack) {
+	// the tracks of the client in the lobby are announced when the client is admitted
+	if publisher, err := s.clients.GetClient(clientId); err == nil && publisher.IsInLobby() {
+		return
+	}
+
 	for _, client := range s.clients.GetClients() {
-		if client.ID() != clientId {
+		if client.ID() != clientId && !client.IsInLobby() {
 			client.onTracksAvailable(tracks)
 			s.log.Infof("sfu: client %s have %d tracks available ", client.ID(), len(tracks))
 		}
@@ -303,6 +396,8 @@ func (s *SFU) onTracksAvailable(clientId string, tracks []ITrack) {
 			callback(tracks)
 		}
 	}
+
+	s.onTrackCatalogChanged()
 }
 
 func (s *SFU) GetClient(id string) (*Client, error) {
@@ -325,17 +420,18 @@ func (s *SFU) removeClient(client *Client) error {
 }
 
 func (s *SFU) CreateDataChannel(label string, opts DataChannelOptions) error {
+	if label == "internal" || label == CaptionsDataChannelLabel {
+		return ErrDataChannelReserved
+	}
+
 	dc := s.dataChannels.Get(label)
 	if dc != nil {
 		return ErrDataChannelExists
 	}
 
-	s.dataChannels.Add(label, opts)
+	initOpts := s.dataChannels.Add(label, opts).initOptions()
 
 	errors := []error{}
-	initOpts := &webrtc.DataChannelInit{
-		Ordered: &opts.Ordered,
-	}
 
 	for _, client := range s.clients.GetClients() {
 		if len(opts.ClientIDs) > 0 {
@@ -354,38 +450,147 @@ func (s *SFU) CreateDataChannel(label string, opts DataChannelOptions) error {
 }
 
 func (s *SFU) setupMessageForwarder(clientID string, d *webrtc.DataChannel) {
+	sfuDC := s.dataChannels.Get(d.Label())
+	if sfuDC == nil {
+		return
+	}
+
+	limiter := newMessageRateLimiter(sfuDC.messageRate)
+
 	d.OnMessage(func(msg webrtc.DataChannelMessage) {
-		// broadcast to all clients
-		s.mu.Lock()
-		defer s.mu.Unlock()
+		if !sfuDC.canSend(clientID) {
+			s.log.Debugf("datachannel: drop message from client %s on %s, not allowed to send", clientID, d.Label())
+			return
+		}
 
-		for _, client := range s.clients.GetClients() {
-			// skip the sender
-			if client.id == clientID {
-				continue
-			}
+		if !limiter.allow(time.Now()) {
+			s.log.Debugf("datachannel: drop message from client %s on %s, rate limited", clientID, d.Label())
+			return
+		}
 
-			dc := client.dataChannels.Get(d.Label())
-			if dc == nil {
-				continue
-			}
+		s.onDataChannelMessage(DataChannelMessage{
+			Label:    d.Label(),
+			ClientID: clientID,
+			IsString: msg.IsString,
+			Data:     msg.Data,
+		})
 
-			if dc.ReadyState() != webrtc.DataChannelStateOpen {
-				dc.OnOpen(func() {
-					dc.Send(msg.Data)
-				})
-			} else {
-				dc.Send(msg.Data)
-			}
+		// broadcast to all clients, skip the sender
+		s.broadcastDataChannelMessage(d.Label(), msg.Data, []string{clientID})
+	})
+}
+
+func (s *SFU) broadcastDataChannelMessage(label string, data []byte, excludeClientIDs []string) {
+	sfuDC := s.dataChannels.Get(label)
+	if sfuDC == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, client := range s.clients.GetClients() {
+		if slices.Contains(excludeClientIDs, client.id) || !sfuDC.canReceive(client.id) {
+			continue
+		}
+
+		dc := client.dataChannels.Get(label)
+		if dc == nil {
+			continue
 		}
+
+		sendDataChannelMessage(dc, data)
+	}
+}
+
+// OnDataChannelMessage event is called when a message is received from a client on the data channels
+// that created with CreateDataChannel, the message is also forwarded to the other clients.
+func (s *SFU) OnDataChannelMessage(callback func(msg DataChannelMessage)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onDataMessageCallbacks = append(s.onDataMessageCallbacks, callback)
+}
+
+func (s *SFU) onDataChannelMessage(msg DataChannelMessage) {
+	s.mu.Lock()
+	callbacks := slices.Clone(s.onDataMessageCallbacks)
+	s.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(msg)
+	}
+}
+
+// OnMetadataChanged event is called when the room metadata or a client metadata is set
+func (s *SFU) OnMetadataChanged(callback func(event MetadataChanged)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onMetadataCallbacks = append(s.onMetadataCallbacks, callback)
+}
+
+// onMetadataChanged calls the callbacks and broadcasts the change to all clients through the internal data channel
+func (s *SFU) onMetadataChanged(event MetadataChanged) {
+	s.mu.Lock()
+	callbacks := slices.Clone(s.onMetadataCallbacks)
+	s.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(event)
+	}
+
+	data, err := json.Marshal(internalDataMetadata{
+		Type: messageTypeMetadataChanged,
+		Data: event,
 	})
+	if err != nil {
+		s.log.Errorf("sfu: error marshal metadata ", err)
+		return
+	}
+
+	for _, client := range s.clients.GetClients() {
+		client.sendInternalMessage(data)
+	}
+
+	// the catalog has the metadata of the publishers
+	if event.ClientID != "" {
+		s.onTrackCatalogChanged()
+	}
+}
+
+// sendMetadataSnapshot sends the current room and clients metadata to a client that just opened the internal data channel,
+// so it doesn't miss the changes before it joined
+func (s *SFU) sendMetadataSnapshot(c *Client) {
+	events := make([]MetadataChanged, 0)
+
+	if data, version := s.metadata.get(); version > 0 {
+		events = append(events, MetadataChanged{Version: version, Metadata: data})
+	}
+
+	for _, client := range s.clients.GetClients() {
+		if data, version := client.metadata.get(); version > 0 {
+			events = append(events, MetadataChanged{ClientID: client.ID(), Version: version, Metadata: data})
+		}
+	}
+
+	for _, event := range events {
+		data, err := json.Marshal(internalDataMetadata{
+			Type: messageTypeMetadataChanged,
+			Data: event,
+		})
+		if err != nil {
+			s.log.Errorf("sfu: error marshal metadata ", err)
+			continue
+		}
+
+		c.sendInternalMessage(data)
+	}
 }
 
 func (s *SFU) createExistingDataChannels(c *Client) {
 	for _, dc := range s.dataChannels.dataChannels {
-		initOpts := &webrtc.DataChannelInit{
-			Ordered: &dc.isOrdered,
-		}
+		initOpts := dc.initOptions()
 		if len(dc.clientIDs) > 0 {
 			if !slices.Contains(dc.clientIDs, c.id) {
 				continue
@@ -462,3 +667,98 @@ func (s *SFU) AddRelayTrack(ctx context.Context, id, streamid, rid string, clien
 
 	return nil
 }
+
+// addRelayTrack publishes a non simulcast relay track that owned by the bridge client, the track is removed
+// from the relay tracks once it's ended
+func (s *SFU) addRelayTrack(ctx context.Context, relayTrack IRemoteTrack, client *Client, source TrackSource, onPLI func()) ITrack {
+	requestPLI := func() {
+		s.requestPLI(uint32(relayTrack.SSRC()), onPLI)
+	}
+
+	track := newTrack(ctx, client, relayTrack, 0, 0, s.pliInterval, requestPLI, nil, nil)
+
+	track.SetSource(source)
+
+	s.mu.Lock()
+	s.relayTracks[track.ID()] = track
+	s.mu.Unlock()
+
+	track.OnEnded(func() {
+		s.mu.Lock()
+		delete(s.relayTracks, track.ID())
+		s.mu.Unlock()
+	})
+
+	s.onTracksAvailable(client.ID(), []ITrack{track})
+
+	return track
+}
+
+func (s *SFU) hasRelayTrack(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.relayTracks[id]
+
+	return ok
+}
+
+func (s *SFU) relayTrackList() []ITrack {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tracks := make([]ITrack, 0, len(s.relayTracks))
+	for _, track := range s.relayTracks {
+		tracks = append(tracks, track)
+	}
+
+	return tracks
+}
+
+// QualityPresets returns the spatial and temporal layers of the SVC and simulcast quality levels
+func (s *SFU) QualityPresets() QualityPresets {
+	if presets := s.qualityPresets.Load(); presets != nil {
+		return *presets
+	}
+
+	return newQualityPresets(DefaultQualityPresets)
+}
+
+// UpdateQualityPresets changes the spatial and temporal layers of the quality levels at runtime.
+// The new presets are used on the next packet of all SVC and simulcast client tracks, a keyframe is requested
+// from the publishers so the subscribers can switch to the new spatial layers.
+func (s *SFU) UpdateQualityPresets(presets QualityPresets) {
+	s.qualityPresets.Store(&presets)
+
+	for _, client := range s.clients.GetClients() {
+		for _, track := range client.ClientTracks() {
+			if track.IsScaleable() || track.IsSimulcast() {
+				track.RequestPLI()
+			}
+		}
+	}
+
+	s.mu.Lock()
+	callbacks := s.onQualityPresetChanged
+	s.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(presets)
+	}
+}
+
+// OnQualityPresetChanged is called after the quality presets are updated with UpdateQualityPresets
+func (s *SFU) OnQualityPresetChanged(callback func(QualityPresets)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onQualityPresetChanged = append(s.onQualityPresetChanged, callback)
+}
+
+func (s *SFU) qualityLevelToPreset(lvl QualityLevel) QualityPreset {
+	if presets := s.qualityPresets.Load(); presets != nil {
+		return presets.get(lvl)
+	}
+
+	return DefaultQualityPresets[lvl]
+}
@@ -2,7 +2,9 @@ package sfu
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net"
 	"testing"
 	"time"
 
@@ -208,3 +210,370 @@ Loop:
 
 	require.Equal(t, expectedTracksAfterAdded, trackReceived)
 }
+
+func TestUpdateQualityPresets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := New(ctx, sfuOptions{Log: TestLogger})
+
+	require.Equal(t, DefaultQualityPresets[QualityMid], s.QualityPresets().Mid)
+	require.Equal(t, DefaultQualityPresets[QualityMid], s.qualityLevelToPreset(QualityMid))
+
+	changed := make(chan QualityPresets, 1)
+	s.OnQualityPresetChanged(func(presets QualityPresets) {
+		changed <- presets
+	})
+
+	// use the high spatial layer with lower frame rates for the mid quality
+	presets := s.QualityPresets()
+	presets.Mid = QualityPreset{SID: 2, TID: 1}
+
+	s.UpdateQualityPresets(presets)
+
+	require.Equal(t, presets, <-changed)
+	require.Equal(t, presets, s.QualityPresets())
+	require.Equal(t, QualityPreset{SID: 2, TID: 1}, s.qualityLevelToPreset(QualityMid))
+	require.Equal(t, DefaultQualityPresets[QualityHigh], s.qualityLevelToPreset(QualityHigh))
+	require.Equal(t, DefaultQualityPresets[QualityNone], s.qualityLevelToPreset(QualityNone))
+}
+
+func TestScreenProfile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := New(ctx, sfuOptions{Log: TestLogger})
+
+	// the screen keeps the high spatial layer with lower frame rates
+	require.Equal(t, DefaultScreenProfile(), s.ScreenProfile())
+	require.Equal(t, QualityPreset{SID: 2, TID: 1}, s.trackQualityPreset(QualityMid, true))
+	require.Equal(t, DefaultQualityPresets[QualityMid], s.trackQualityPreset(QualityMid, false))
+	require.Equal(t, DefaultQualityPresets[QualityNone], s.trackQualityPreset(QualityNone, true))
+
+	profile := DefaultScreenProfile()
+	profile.Presets.Mid = QualityPreset{SID: 1, TID: 2}
+
+	s = New(ctx, sfuOptions{Log: TestLogger, ScreenProfile: &profile})
+	require.Equal(t, QualityPreset{SID: 1, TID: 2}, s.trackQualityPreset(QualityMid, true))
+}
+
+func TestPLIAggregator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	window := 100 * time.Millisecond
+	s := New(ctx, sfuOptions{Log: TestLogger, PLIWindow: window})
+
+	sent := make(chan uint32, 10)
+	send := func(ssrc uint32) func() {
+		return func() {
+			sent <- ssrc
+		}
+	}
+
+	// a burst of requests to the same SSRC is sent once and the rest is merged into a single PLI at the end of the window
+	for i := 0; i < 5; i++ {
+		s.requestPLI(1234, send(1234))
+	}
+
+	// the other SSRC is not affected by the burst
+	s.requestPLI(5678, send(5678))
+
+	require.Equal(t, uint32(1234), <-sent)
+	require.Equal(t, uint32(5678), <-sent)
+
+	select {
+	case <-sent:
+		t.Fatal("the coalesced PLI must wait until the end of the window")
+	case <-time.After(window / 2):
+	}
+
+	select {
+	case ssrc := <-sent:
+		require.Equal(t, uint32(1234), ssrc)
+	case <-time.After(window * 2):
+		t.Fatal("the coalesced PLI is not sent")
+	}
+
+	require.Equal(t, PLIStats{Requested: 6, Sent: 3, Coalesced: 4}, s.PLIStats())
+
+	// the window is passed, the next request is sent immediately
+	time.Sleep(window)
+	s.requestPLI(1234, send(1234))
+	require.Equal(t, uint32(1234), <-sent)
+	require.Equal(t, uint64(4), s.PLIStats().Sent)
+}
+
+func TestManagerDrain(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	migrateURL := "wss://sfu-2.example.com"
+
+	joinRoom := func(roomID string, leaveOnMigrate bool) *Room {
+		testRoom, err := roomManager.NewRoom(roomID, roomID, RoomTypeLocal, DefaultRoomOptions())
+		require.NoError(t, err)
+
+		opened := make(chan bool, 1)
+
+		var pc *webrtc.PeerConnection
+
+		pc, _, _, connChan := CreateDataPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer-"+roomID, func(dc *webrtc.DataChannel) {
+			if dc.Label() != "internal" {
+				return
+			}
+
+			dc.OnOpen(func() {
+				opened <- true
+			})
+
+			dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+				data := internalDataMigrate{}
+				if err := json.Unmarshal(msg.Data, &data); err == nil && data.Type == messageTypeMigrate {
+					require.Equal(t, migrateURL, data.Data.URL)
+
+					// reconnect to the other server
+					if leaveOnMigrate {
+						go pc.Close()
+					}
+				}
+			})
+		})
+
+		t.Cleanup(func() { pc.Close() })
+
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-connChan:
+				}
+			}
+		}()
+
+		select {
+		case <-time.After(30 * time.Second):
+			t.Fatal("timeout waiting for the internal data channel")
+		case <-opened:
+		}
+
+		return testRoom
+	}
+
+	// the client leaves after the migrate message
+	migratedRoom := joinRoom("migrated", true)
+
+	drainCtx, cancelDrain := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelDrain()
+
+	require.NoError(t, migratedRoom.Drain(drainCtx, migrateURL))
+	require.Equal(t, StateRoomClosed, migratedRoom.state)
+	require.ErrorIs(t, migratedRoom.Drain(drainCtx, migrateURL), ErrSFUDraining)
+
+	// the client never leaves, the room is closed on the deadline
+	stayedRoom := joinRoom("stayed", false)
+
+	deadlineCtx, cancelDeadline := context.WithTimeout(ctx, time.Second)
+	defer cancelDeadline()
+
+	require.ErrorIs(t, roomManager.Drain(deadlineCtx, migrateURL), context.DeadlineExceeded)
+	require.True(t, roomManager.IsDraining())
+	require.True(t, stayedRoom.SFU().IsDraining())
+	require.Equal(t, StateRoomClosed, stayedRoom.state)
+
+	_, err := roomManager.NewRoom("new", "new", RoomTypeLocal, DefaultRoomOptions())
+	require.ErrorIs(t, err, ErrManagerDraining)
+}
+
+func TestICEMux(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := NewICEMux(ICEMuxOptions{})
+	require.ErrorIs(t, err, ErrICEMuxNoPort)
+
+	require.ErrorIs(t, NetworkOptions{IPs: []string{"invalid"}}.Configure(&webrtc.SettingEngine{}), ErrInvalidIP)
+
+	freePort := func(network string) int {
+		if network == "udp" {
+			conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer conn.Close()
+
+			return conn.LocalAddr().(*net.UDPAddr).Port
+		}
+
+		listener, err := net.Listen("tcp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		return listener.Addr().(*net.TCPAddr).Port
+	}
+
+	udpPort := freePort("udp")
+
+	mux, err := NewICEMux(ICEMuxOptions{
+		UDPPort:         udpPort,
+		TCPPort:         freePort("tcp"),
+		IncludeLoopback: true,
+		// only gather the loopback candidates on a multi-homed host
+		Network:    NetworkOptions{IPs: []string{"127.0.0.1"}},
+		WriteBatch: WriteBatchOptions{Enabled: true},
+	})
+	require.NoError(t, err)
+
+	defer mux.Close()
+
+	opts := sfuOpts
+	opts.SettingEngine = &webrtc.SettingEngine{}
+	mux.Configure(opts.SettingEngine)
+
+	roomManager := NewManager(ctx, "test", opts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	pc1, client1, _, _ := CreateDataPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer1", func(dc *webrtc.DataChannel) {})
+	defer pc1.Close()
+
+	pc2, client2, _, _ := CreateDataPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer2", func(dc *webrtc.DataChannel) {})
+	defer pc2.Close()
+
+	// both clients are connected through the same UDP port
+	for _, client := range []*Client{client1, client2} {
+		require.Eventually(t, func() bool {
+			return client.PeerConnection().PC().ConnectionState() == webrtc.PeerConnectionStateConnected
+		}, 30*time.Second, 50*time.Millisecond)
+
+		pair, err := client.PeerConnection().PC().SCTP().Transport().ICETransport().GetSelectedCandidatePair()
+		require.NoError(t, err)
+		require.Equal(t, uint16(udpPort), pair.Local.Port)
+
+		selected := 0
+		for _, stats := range client.CandidatePairStats() {
+			require.Equal(t, "127.0.0.1", stats.LocalIP)
+
+			if stats.Selected {
+				selected++
+				require.Equal(t, int32(udpPort), stats.LocalPort)
+				require.Equal(t, "udp", stats.Protocol)
+			}
+		}
+
+		require.Equal(t, 1, selected)
+	}
+
+	stats := mux.WriteBatchStats()
+	require.Len(t, stats, 1)
+	require.Equal(t, fmt.Sprintf("127.0.0.1:%d", udpPort), stats[0].LocalAddr)
+	require.NotZero(t, stats[0].Packets)
+}
+
+func TestTrackCatalog(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.TrackCatalog = true
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	catalogs := make(chan TrackCatalog, 100)
+
+	pc, _, _, connChan := CreateDataPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "viewer", func(dc *webrtc.DataChannel) {
+		if dc.Label() != "internal" {
+			return
+		}
+
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			data := internalDataTrackCatalog{}
+			if err := json.Unmarshal(msg.Data, &data); err == nil && data.Type == messageTypeTrackCatalog {
+				catalogs <- data.Data
+			}
+		})
+	})
+
+	defer pc.Close()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-connChan:
+			}
+		}
+	}()
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	// the empty catalog is sent when the internal data channel is open
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the track catalog")
+	case catalog := <-catalogs:
+		require.Empty(t, catalog.Tracks)
+	}
+
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, false, true)
+	_, err = publisher.SetMetadata([]byte(`{"role":"speaker"}`))
+	require.NoError(t, err)
+
+	var latest TrackCatalog
+
+	for len(latest.Tracks) != 2 || latest.Tracks[0].PublisherMetadata == nil {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the published tracks in the catalog")
+		case catalog := <-catalogs:
+			require.Greater(t, catalog.Version, latest.Version)
+			latest = catalog
+		}
+	}
+
+	kinds := make([]string, 0)
+	for _, track := range latest.Tracks {
+		require.Equal(t, publisher.ID(), track.ClientID)
+		require.Equal(t, publisher.Name(), track.PublisherName)
+		require.JSONEq(t, `{"role":"speaker"}`, string(track.PublisherMetadata))
+		require.Equal(t, TrackType(TrackTypeMedia), track.Source)
+
+		kinds = append(kinds, track.Kind)
+	}
+
+	require.ElementsMatch(t, []string{"audio", "video"}, kinds)
+	require.Equal(t, latest.Tracks, testRoom.SFU().TrackCatalog().Tracks)
+}
+
+func TestTrackCatalogDimensionsE2EE(t *testing.T) {
+	client := &Client{}
+	client.sfu.Store(&SFU{trackCatalog: true})
+
+	require.NotNil(t, client.dimensionsInterceptor(webrtc.RTPCodecTypeVideo))
+
+	// the dimensions are not parsed from the encrypted payload
+	client.options.E2EE = true
+	require.Nil(t, client.dimensionsInterceptor(webrtc.RTPCodecTypeVideo))
+}
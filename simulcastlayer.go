@@ -0,0 +1,172 @@
+package sfu
+
+import "time"
+
+const (
+	// the simulcast layer is considered inactive when there is no packet read for this duration
+	simulcastLayerInactiveThreshold = 500 * time.Millisecond
+	// the interval to check the simulcast layers activity
+	simulcastLayerCheckInterval = 250 * time.Millisecond
+	// the minimum interval of the keyframe requests while a subscriber is waiting to switch the layer
+	simulcastKeyframeRequestInterval = 500 * time.Millisecond
+)
+
+// the layers to try when the selected layer is inactive, sorted from the nearest one.
+// The lower layer is preferred so the subscriber bandwidth is not exceeded by the fallback.
+var simulcastFallbackLayers = map[QualityLevel][]QualityLevel{
+	QualityHigh: {QualityMid, QualityLow},
+	QualityMid:  {QualityLow, QualityHigh},
+	QualityLow:  {QualityMid, QualityHigh},
+}
+
+// nearestActiveLayer returns the nearest active layer to replace the inactive layer, false if no layer is active
+func nearestActiveLayer(layer QualityLevel, isActive func(QualityLevel) bool) (QualityLevel, bool) {
+	for _, fallback := range simulcastFallbackLayers[layer] {
+		if isActive(fallback) {
+			return fallback, true
+		}
+	}
+
+	return QualityNone, false
+}
+
+// simulcastLayerStates keeps the last known active state of the simulcast layers to detect when the publisher
+// stops sending a layer, for example when its uplink bandwidth drops, and when the layer is sent again.
+type simulcastLayerStates struct {
+	active map[QualityLevel]bool
+}
+
+// update returns the layers that became inactive and the layers that recovered since the last update.
+// The layer that never received a packet is not reported.
+func (s *simulcastLayerStates) update(lastReads map[QualityLevel]time.Time, now time.Time) (inactive, recovered []QualityLevel) {
+	if s.active == nil {
+		s.active = make(map[QualityLevel]bool)
+	}
+
+	for _, layer := range []QualityLevel{QualityHigh, QualityMid, QualityLow} {
+		lastRead := lastReads[layer]
+		if lastRead.IsZero() {
+			continue
+		}
+
+		isActive := now.Sub(lastRead) <= simulcastLayerInactiveThreshold
+
+		wasActive, known := s.active[layer]
+		s.active[layer] = isActive
+
+		switch {
+		case known && wasActive && !isActive:
+			inactive = append(inactive, layer)
+		case known && !wasActive && isActive:
+			recovered = append(recovered, layer)
+		}
+	}
+
+	return inactive, recovered
+}
+
+// OnLayerInactive is called when the publisher stops sending a simulcast layer. The subscribers of the layer are
+// switched to the nearest active layer until the layer is recovered.
+func (t *SimulcastTrack) OnLayerInactive(f func(quality QualityLevel)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.onLayerInactiveCallbacks = append(t.onLayerInactiveCallbacks, f)
+}
+
+// OnLayerRecovered is called when the publisher sends an inactive simulcast layer again
+func (t *SimulcastTrack) OnLayerRecovered(f func(quality QualityLevel)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.onLayerRecoveredCallbacks = append(t.onLayerRecoveredCallbacks, f)
+}
+
+// lastRead returns the time of the last packet read of the layer, zero if the layer never received a packet
+func (t *SimulcastTrack) lastRead(quality QualityLevel) time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var ts int64
+
+	switch quality {
+	case QualityHigh:
+		if t.remoteTrackHigh != nil {
+			ts = t.lastReadHighTS.Load()
+		}
+	case QualityMid:
+		if t.remoteTrackMid != nil {
+			ts = t.lastReadMidTS.Load()
+		}
+	case QualityLow:
+		if t.remoteTrackLow != nil {
+			ts = t.lastReadLowTS.Load()
+		}
+	}
+
+	if ts == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, ts)
+}
+
+func (t *SimulcastTrack) loopLayerMonitor() {
+	ticker := time.NewTicker(simulcastLayerCheckInterval)
+	defer ticker.Stop()
+
+	states := &simulcastLayerStates{}
+	pauser := &simulcastLayerPauser{}
+
+	for {
+		select {
+		case <-t.context.Done():
+			return
+		case <-ticker.C:
+			lastReads := map[QualityLevel]time.Time{
+				QualityHigh: t.lastRead(QualityHigh),
+				QualityMid:  t.lastRead(QualityMid),
+				QualityLow:  t.lastRead(QualityLow),
+			}
+
+			now := time.Now()
+
+			if t.base.client.options.PauseUnusedLayers {
+				pauser.update(t, now)
+			}
+
+			inactive, recovered := states.update(lastReads, now)
+			if len(inactive) == 0 && len(recovered) == 0 {
+				continue
+			}
+
+			// the subscribers are switched to the other layer, they need a keyframe to decode it
+			t.sendPLI()
+
+			t.mu.RLock()
+			onInactive := t.onLayerInactiveCallbacks
+			onRecovered := t.onLayerRecoveredCallbacks
+			t.mu.RUnlock()
+
+			for _, layer := range inactive {
+				if layer > t.maxSentLayer() || t.base.autoPause.isPaused() {
+					t.base.client.log.Infof("track: remote track %s layer %d is paused", t.base.id, layer)
+				} else {
+					t.base.client.log.Warnf("track: remote track %s layer %d is not active, last read was %d ms ago", t.base.id, layer, time.Since(lastReads[layer]).Milliseconds())
+				}
+
+				for _, f := range onInactive {
+					f(layer)
+				}
+			}
+
+			for _, layer := range recovered {
+				t.base.client.log.Infof("track: remote track %s layer %d is active again", t.base.id, layer)
+
+				for _, f := range onRecovered {
+					f(layer)
+				}
+			}
+		}
+	}
+}
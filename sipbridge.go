@@ -0,0 +1,534 @@
+package sfu
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// the default payload type of the telephone-event in the SIP SDP
+	sipDefaultDTMFPayloadType = 101
+	// RFC 4733 section 2.5.1.2, the event packets are sent every 50ms until the end
+	sipDTMFPacketInterval = 50 * time.Millisecond
+	// the end packet is sent 3 times in case of the packet loss
+	sipDTMFEndPackets = 3
+
+	sipBridgeChannelSize = 512
+)
+
+var (
+	ErrSIPBridgeClosed      = errors.New("sipbridge: bridge is closed")
+	ErrSIPUnsupportedCodec  = errors.New("sipbridge: codec is not supported, only audio/PCMU, audio/PCMA and audio/opus")
+	ErrSIPInvalidDTMF       = errors.New("sipbridge: invalid dtmf digit")
+	ErrSIPDTMFNotNegotiated = errors.New("sipbridge: telephone-event is not negotiated")
+)
+
+// the DTMF event codes from RFC 4733 section 3.2, the index is the event code
+var sipDTMFDigits = []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "*", "#", "A", "B", "C", "D"}
+
+// SIPMixer mixes the room audio into a single stream for the SIP leg. The SFU never decodes the media, so the mixer is
+// provided by the application, for example with an Opus and G.711 codec library.
+type SIPMixer interface {
+	// AddTrack is called for every audio track in the room, except the audio from the SIP leg itself
+	AddTrack(track ITrack)
+	// RemoveTrack is called when the track is ended
+	RemoveTrack(track ITrack)
+	// ReadRTP blocks until the next mixed packet that encoded with the SIP leg codec, the sequence number and SSRC are
+	// rewritten by the bridge
+	ReadRTP() (*rtp.Packet, error)
+	// Close is called when the bridge is closed, the blocked ReadRTP must return an error
+	Close() error
+}
+
+type SIPBridgeOptions struct {
+	// MimeType of the SIP leg audio that negotiated in the SIP SDP, audio/PCMU, audio/PCMA or audio/opus.
+	// The room codecs must include it so the WebRTC clients can receive the audio.
+	MimeType string `json:"mime_type"`
+	// PayloadType of the audio that negotiated in the SIP SDP
+	PayloadType uint8 `json:"payload_type"`
+	// DTMFPayloadType of the telephone-event that negotiated in the SIP SDP, 0 if it's not negotiated
+	DTMFPayloadType uint8 `json:"dtmf_payload_type"`
+	// Mixer mixes the room audio for the SIP leg. Without mixer, the audio of the room dominant speaker is forwarded
+	// when it's the same codec as the SIP leg, which only works with an Opus SIP leg.
+	Mixer         SIPMixer      `json:"-"`
+	ClientOptions ClientOptions `json:"client_options"`
+}
+
+func DefaultSIPBridgeOptions() SIPBridgeOptions {
+	return SIPBridgeOptions{
+		MimeType:        webrtc.MimeTypePCMU,
+		PayloadType:     0,
+		DTMFPayloadType: sipDefaultDTMFPayloadType,
+		ClientOptions:   DefaultClientOptions(),
+	}
+}
+
+// DTMFEvent is a telephone-event that received from or sent to the SIP leg
+type DTMFEvent struct {
+	// Digit is one of 0-9, *, #, and A-D
+	Digit    string        `json:"digit"`
+	Duration time.Duration `json:"duration"`
+}
+
+type internalDataDTMF struct {
+	Type string          `json:"type"`
+	Data sipDTMFReceived `json:"data"`
+}
+
+type sipDTMFReceived struct {
+	ClientID   string `json:"client_id"`
+	Digit      string `json:"digit"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// SIPBridge connects a room to a SIP endpoint over plain RTP. The SIP signaling is handled by the application, the bridge
+// only takes the RTP socket and the parameters negotiated in the SIP SDP. The audio from the SIP leg is published to
+// the room as a relay track, and the room audio is sent back to the SIP leg.
+type SIPBridge struct {
+	mu         sync.Mutex
+	context    context.Context
+	cancel     context.CancelFunc
+	done       chan bool
+	room       *Room
+	conn       net.PacketConn
+	remoteAddr net.Addr
+	options    SIPBridgeOptions
+	clockRate  uint32
+	// the bridge client that owns the relay track of the SIP audio
+	client  *Client
+	track   ITrack
+	rtpChan chan *rtp.Packet
+	// the outgoing stream to the SIP leg
+	ssrc      uint32
+	sequence  uint16
+	timestamp uint32
+	// the dominant speaker track that forwarded without mixer
+	forwardTrackID  string
+	forwardClientID string
+	tsOffset        uint32
+	mixerTracks     map[string]ITrack
+	// the timestamp of the last received DTMF event, the end packet is repeated
+	lastDTMF   uint32
+	hasDTMF    bool
+	onDTMF     []func(DTMFEvent)
+	log        logging.LeveledLogger
+	closeError error
+}
+
+// BridgeSIP publishes the audio that received on the conn from the remoteAddr to the room, and sends the room audio
+// back to the remoteAddr. The conn is closed when the bridge is closed or the room is closed.
+func (r *Room) BridgeSIP(name string, conn net.PacketConn, remoteAddr net.Addr, opts SIPBridgeOptions) (*SIPBridge, error) {
+	codec := getCodecCapability(opts.MimeType)
+
+	switch strings.ToLower(opts.MimeType) {
+	case strings.ToLower(webrtc.MimeTypePCMU), strings.ToLower(webrtc.MimeTypePCMA), strings.ToLower(webrtc.MimeTypeOpus):
+	default:
+		return nil, ErrSIPUnsupportedCodec
+	}
+
+	if r.context.Err() != nil {
+		return nil, ErrRoomIsClosed
+	}
+
+	// the caller is a participant of the room
+	clientID := r.CreateClientID()
+	if r.isFull() {
+		r.onRoomFull(clientID)
+		return nil, ErrRoomIsFull
+	}
+
+	ctx, cancel := context.WithCancel(r.context)
+
+	b := &SIPBridge{
+		context:     ctx,
+		cancel:      cancel,
+		done:        make(chan bool),
+		room:        r,
+		conn:        conn,
+		remoteAddr:  remoteAddr,
+		options:     opts,
+		clockRate:   codec.ClockRate,
+		rtpChan:     make(chan *rtp.Packet, sipBridgeChannelSize),
+		ssrc:        rand.Uint32(),
+		sequence:    uint16(rand.Uint32()),
+		timestamp:   rand.Uint32(),
+		mixerTracks: make(map[string]ITrack),
+		log:         r.sfu.log,
+	}
+
+	clientOpts := opts.ClientOptions
+	clientOpts.Type = ClientTypeUpBridge
+
+	// the bridge client never connects, it's only the owner of the relay track in the room
+	b.client = r.sfu.NewClient(clientID, name, clientOpts)
+
+	remoteTrack := NewTrackRelay(b.client.ID()+"-audio", b.client.ID(), "", webrtc.RTPCodecTypeAudio, webrtc.SSRC(rand.Uint32()), codec.MimeType, b.rtpChan)
+	b.track = r.sfu.addRelayTrack(ctx, remoteTrack, b.client, TrackSource{Type: TrackTypeMedia}, func() {})
+
+	go b.readLoop()
+	go b.closeOnDone()
+
+	r.sfu.OnTracksAvailable(func(tracks []ITrack) {
+		for _, track := range tracks {
+			b.addRoomTrack(track)
+		}
+	})
+
+	for _, client := range r.sfu.GetClients() {
+		for _, track := range client.Tracks() {
+			b.addRoomTrack(track)
+		}
+	}
+
+	if opts.Mixer != nil {
+		go b.mixerLoop()
+	}
+
+	return b, nil
+}
+
+// ClientID returns the ID of the bridge client that publishes the SIP audio
+func (b *SIPBridge) ClientID() string {
+	return b.client.ID()
+}
+
+// Track returns the relay track of the audio from the SIP leg
+func (b *SIPBridge) Track() ITrack {
+	return b.track
+}
+
+// OnDTMF event is called when a DTMF event is received from the SIP leg. The event is also sent to the room clients
+// through the internal data channel with the `dtmf` message type.
+func (b *SIPBridge) OnDTMF(callback func(event DTMFEvent)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.onDTMF = append(b.onDTMF, callback)
+}
+
+// Close stops the bridge, ends the relay track, and closes the conn
+func (b *SIPBridge) Close() error {
+	if b.context.Err() != nil {
+		<-b.done
+		return ErrSIPBridgeClosed
+	}
+
+	b.cancel()
+	<-b.done
+
+	return b.closeError
+}
+
+func (b *SIPBridge) closeOnDone() {
+	<-b.context.Done()
+
+	b.mu.Lock()
+	close(b.rtpChan)
+	b.mu.Unlock()
+
+	if b.options.Mixer != nil {
+		_ = b.options.Mixer.Close()
+	}
+
+	_ = b.client.stop()
+
+	b.closeError = b.conn.Close()
+
+	close(b.done)
+}
+
+func (b *SIPBridge) readLoop() {
+	buf := make([]byte, 1500)
+
+	for {
+		n, addr, err := b.conn.ReadFrom(buf)
+		if err != nil {
+			if b.context.Err() == nil {
+				b.log.Errorf("sipbridge: read error: %s", err.Error())
+				b.cancel()
+			}
+
+			return
+		}
+
+		// the RTCP is ignored, the SIP leg may also send it on the same port with rtcp-mux
+		if addr.String() != b.remoteAddr.String() || (n >= 2 && buf[1] >= 192 && buf[1] <= 223) {
+			continue
+		}
+
+		// the buffer is reused for the next packet
+		p := &rtp.Packet{}
+		if err := p.Unmarshal(slices.Clone(buf[:n])); err != nil {
+			b.log.Errorf("sipbridge: failed to unmarshal rtp: %s", err.Error())
+			continue
+		}
+
+		switch p.PayloadType {
+		case b.options.PayloadType:
+			b.mu.Lock()
+			if b.context.Err() == nil {
+				select {
+				case b.rtpChan <- p:
+				default:
+					b.log.Warnf("sipbridge: relay track %s buffer is full, packet is dropped", b.track.ID())
+				}
+			}
+			b.mu.Unlock()
+		case b.options.DTMFPayloadType:
+			if b.options.DTMFPayloadType != 0 {
+				b.handleDTMF(p)
+			}
+		}
+	}
+}
+
+// handleDTMF notifies the DTMF event once the end packet is received, RFC 4733 section 2.5.1.4
+func (b *SIPBridge) handleDTMF(p *rtp.Packet) {
+	if len(p.Payload) < 4 || p.Payload[1]&0x80 == 0 || int(p.Payload[0]) >= len(sipDTMFDigits) {
+		return
+	}
+
+	b.mu.Lock()
+
+	if b.hasDTMF && b.lastDTMF == p.Timestamp {
+		b.mu.Unlock()
+		return
+	}
+
+	b.hasDTMF = true
+	b.lastDTMF = p.Timestamp
+	callbacks := slices.Clone(b.onDTMF)
+	b.mu.Unlock()
+
+	event := DTMFEvent{
+		Digit:    sipDTMFDigits[p.Payload[0]],
+		Duration: time.Duration(binary.BigEndian.Uint16(p.Payload[2:])) * time.Second / time.Duration(b.clockRate),
+	}
+
+	for _, callback := range callbacks {
+		callback(event)
+	}
+
+	data, err := json.Marshal(internalDataDTMF{
+		Type: messageTypeDTMF,
+		Data: sipDTMFReceived{
+			ClientID:   b.client.ID(),
+			Digit:      event.Digit,
+			DurationMS: event.Duration.Milliseconds(),
+		},
+	})
+	if err != nil {
+		b.log.Errorf("sipbridge: error marshal dtmf ", err)
+		return
+	}
+
+	for _, client := range b.room.sfu.GetClients() {
+		if client.ID() != b.client.ID() {
+			client.sendInternalMessage(data)
+		}
+	}
+}
+
+// SendDTMF sends the DTMF event to the SIP leg, it blocks until the event is sent for the duration
+func (b *SIPBridge) SendDTMF(digit string, duration time.Duration) error {
+	event := slices.Index(sipDTMFDigits, strings.ToUpper(digit))
+	if event < 0 {
+		return ErrSIPInvalidDTMF
+	}
+
+	if b.options.DTMFPayloadType == 0 {
+		return ErrSIPDTMFNotNegotiated
+	}
+
+	b.mu.Lock()
+	timestamp := b.timestamp
+	b.mu.Unlock()
+
+	ticker := time.NewTicker(sipDTMFPacketInterval)
+	defer ticker.Stop()
+
+	total := uint16(min(int64(duration)*int64(b.clockRate)/int64(time.Second), 0xffff))
+	elapsed := time.Duration(0)
+
+	for i := 0; ; i++ {
+		samples := uint16(min(int64(elapsed)*int64(b.clockRate)/int64(time.Second), int64(total)))
+		end := samples >= total
+
+		packets := 1
+		if end {
+			packets = sipDTMFEndPackets
+		}
+
+		for j := 0; j < packets; j++ {
+			payload := []byte{byte(event), 10, 0, 0}
+			if end {
+				payload[1] |= 0x80
+			}
+
+			binary.BigEndian.PutUint16(payload[2:], samples)
+
+			// the marker is set on the first packet of the event, all packets use the start timestamp
+			if err := b.writeRTP(b.options.DTMFPayloadType, timestamp, i == 0, payload); err != nil {
+				return err
+			}
+		}
+
+		if end {
+			return nil
+		}
+
+		select {
+		case <-b.context.Done():
+			return ErrSIPBridgeClosed
+		case <-ticker.C:
+			elapsed += sipDTMFPacketInterval
+		}
+	}
+}
+
+// addRoomTrack sends the room audio track to the mixer, or forwards it when it's the dominant speaker
+func (b *SIPBridge) addRoomTrack(track ITrack) {
+	if b.context.Err() != nil || track.Kind() != webrtc.RTPCodecTypeAudio || track.ClientID() == b.client.ID() {
+		return
+	}
+
+	if b.options.Mixer != nil {
+		b.mu.Lock()
+		if _, ok := b.mixerTracks[track.ID()]; ok {
+			b.mu.Unlock()
+			return
+		}
+
+		b.mixerTracks[track.ID()] = track
+		b.mu.Unlock()
+
+		b.options.Mixer.AddTrack(track)
+
+		track.OnEnded(func() {
+			b.mu.Lock()
+			delete(b.mixerTracks, track.ID())
+			b.mu.Unlock()
+
+			if b.context.Err() == nil {
+				b.options.Mixer.RemoveTrack(track)
+			}
+		})
+
+		return
+	}
+
+	// the SFU doesn't transcode, only the same codec can be forwarded
+	audioTrack, ok := track.(*AudioTrack)
+	if !ok || !audioTrack.isOpus() || !strings.EqualFold(b.options.MimeType, webrtc.MimeTypeOpus) {
+		return
+	}
+
+	track.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, _ QualityLevel) {
+		b.forward(track, p)
+	})
+}
+
+func (b *SIPBridge) forward(track ITrack, p *rtp.Packet) {
+	if b.context.Err() != nil {
+		return
+	}
+
+	dominant := b.room.DominantSpeaker()
+	if track.ClientID() != dominant {
+		return
+	}
+
+	payload := p.Payload
+	if p.PayloadType == 63 {
+		primary, err := extractPrimaryEncodingForRED(payload)
+		if err != nil {
+			return
+		}
+
+		payload = primary
+	}
+
+	b.mu.Lock()
+	if b.forwardTrackID != track.ID() {
+		// only one audio track of the dominant speaker is forwarded
+		if b.forwardClientID == dominant {
+			b.mu.Unlock()
+			return
+		}
+
+		// continue the timestamp from the previous speaker, one 20ms frame after the last packet
+		b.forwardTrackID = track.ID()
+		b.forwardClientID = dominant
+		b.tsOffset = b.timestamp + b.clockRate/50 - p.Timestamp
+	}
+
+	timestamp := p.Timestamp + b.tsOffset
+	b.mu.Unlock()
+
+	if err := b.writeRTP(b.options.PayloadType, timestamp, p.Marker, payload); err != nil && b.context.Err() == nil {
+		b.log.Errorf("sipbridge: failed to write rtp: %s", err.Error())
+	}
+}
+
+func (b *SIPBridge) mixerLoop() {
+	for {
+		p, err := b.options.Mixer.ReadRTP()
+		if err != nil {
+			return
+		}
+
+		if err := b.writeRTP(b.options.PayloadType, p.Timestamp, p.Marker, p.Payload); err != nil && b.context.Err() == nil {
+			b.log.Errorf("sipbridge: failed to write rtp: %s", err.Error())
+		}
+	}
+}
+
+// writeRTP sends the packet on the outgoing stream, the audio and the DTMF events share the sequence number
+func (b *SIPBridge) writeRTP(payloadType uint8, timestamp uint32, marker bool, payload []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.context.Err() != nil {
+		return ErrSIPBridgeClosed
+	}
+
+	packet := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         marker,
+			PayloadType:    payloadType,
+			SequenceNumber: b.sequence,
+			Timestamp:      timestamp,
+			SSRC:           b.ssrc,
+		},
+		Payload: payload,
+	}
+
+	b.sequence++
+
+	if payloadType == b.options.PayloadType {
+		b.timestamp = timestamp
+	}
+
+	buf, err := packet.Marshal()
+	if err != nil {
+		return err
+	}
+
+	_, err = b.conn.WriteTo(buf, b.remoteAddr)
+
+	return err
+}
@@ -0,0 +1,141 @@
+package sfu
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSIPBridge(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus, webrtc.MimeTypePCMU}
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-sip-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	_, subscriber, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "subscriber", true, false, true)
+
+	// the SIP endpoint side
+	sipConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer sipConn.Close()
+
+	bridgeConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	bridge, err := testRoom.BridgeSIP("phone", bridgeConn, sipConn.LocalAddr(), DefaultSIPBridgeOptions())
+	require.NoError(t, err)
+
+	dtmf := make(chan DTMFEvent, 10)
+	bridge.OnDTMF(func(event DTMFEvent) {
+		dtmf <- event
+	})
+
+	sendCtx, cancelSend := context.WithCancel(ctx)
+	defer cancelSend()
+
+	// the PCMU audio from the SIP leg
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+
+		for seq := uint16(0); ; seq++ {
+			packet := rtp.Packet{
+				Header:  rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: seq, Timestamp: uint32(seq) * 160, SSRC: 1234},
+				Payload: make([]byte, 160),
+			}
+
+			buf, _ := packet.Marshal()
+			_, _ = sipConn.WriteTo(buf, bridgeConn.LocalAddr())
+
+			select {
+			case <-sendCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	for {
+		if _, ok := subscriber.ClientTracks()[bridge.Track().ID()]; ok {
+			break
+		}
+
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the SIP audio track")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// the end packet of the digit 5 with 100ms duration is repeated 3 times
+	for i := 0; i < 3; i++ {
+		packet := rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: sipDefaultDTMFPayloadType, SequenceNumber: uint16(1000 + i), Timestamp: 8000, SSRC: 1234},
+			Payload: []byte{5, 0x80 | 10, 0x03, 0x20},
+		}
+
+		buf, _ := packet.Marshal()
+		_, _ = sipConn.WriteTo(buf, bridgeConn.LocalAddr())
+	}
+
+	select {
+	case event := <-dtmf:
+		require.Equal(t, DTMFEvent{Digit: "5", Duration: 100 * time.Millisecond}, event)
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the DTMF event")
+	}
+
+	require.ErrorIs(t, bridge.SendDTMF("x", 100*time.Millisecond), ErrSIPInvalidDTMF)
+	require.NoError(t, bridge.SendDTMF("#", 100*time.Millisecond))
+
+	// the event updates and the 3 end packets
+	buf := make([]byte, 1500)
+	packets := make([]rtp.Packet, 0)
+
+	for len(packets) < 5 {
+		require.NoError(t, sipConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := sipConn.ReadFrom(buf)
+		require.NoError(t, err)
+
+		packet := rtp.Packet{}
+		require.NoError(t, packet.Unmarshal(append([]byte{}, buf[:n]...)))
+
+		packets = append(packets, packet)
+	}
+
+	require.True(t, packets[0].Marker)
+	require.Equal(t, byte(11), packets[0].Payload[0])
+	require.Zero(t, packets[0].Payload[1]&0x80)
+	require.Equal(t, packets[0].SequenceNumber+4, packets[4].SequenceNumber)
+
+	for _, packet := range packets[2:] {
+		require.Equal(t, packets[0].Timestamp, packet.Timestamp)
+		require.NotZero(t, packet.Payload[1]&0x80)
+		require.Equal(t, []byte{0x03, 0x20}, packet.Payload[2:])
+	}
+
+	cancelSend()
+
+	require.NoError(t, bridge.Close())
+	require.ErrorIs(t, bridge.Close(), ErrSIPBridgeClosed)
+
+	_ = testRoom.StopClient(subscriber.ID())
+}
@@ -0,0 +1,442 @@
+package sfu
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/webmwriter"
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// the keyframe is requested again if the publisher doesn't send it after the interval
+const snapshotKeyframeRequestInterval = time.Second
+
+var (
+	ErrSnapshotVideoOnly  = errors.New("snapshot: only video track can be snapshotted")
+	ErrSnapshotNotTaken   = errors.New("snapshot: no snapshot is taken yet")
+	ErrSnapshotFrameEmpty = errors.New("snapshot: keyframe is empty")
+)
+
+// SnapshotDecoder decodes the keyframe to a JPEG image
+type SnapshotDecoder func(ctx context.Context, frame SnapshotFrame) ([]byte, error)
+
+type SnapshotOptions struct {
+	// Interval between the snapshots, the snapshot is taken from the first keyframe after the interval
+	Interval time.Duration `json:"interval"`
+	// Quality is the simulcast layer that snapshotted, the low layer is cheaper to decode and enough for the preview tiles
+	Quality QualityLevel `json:"quality"`
+	// Width of the JPEG image, the height follows the aspect ratio. Zero keeps the video size.
+	Width uint32 `json:"width"`
+	// JPEGQuality is the ffmpeg -q:v value from 2 to 31, the lower is the better quality
+	JPEGQuality int `json:"jpeg_quality"`
+	// FFmpegPath is the path of the ffmpeg binary that used to decode the keyframe
+	FFmpegPath string `json:"ffmpeg_path"`
+	// Decoder replaces ffmpeg to decode the keyframe, for example to decode it in a separate worker
+	Decoder SnapshotDecoder `json:"-"`
+}
+
+func DefaultSnapshotOptions() SnapshotOptions {
+	return SnapshotOptions{
+		Interval:    5 * time.Second,
+		Quality:     QualityLow,
+		Width:       320,
+		JPEGQuality: 5,
+		FFmpegPath:  "ffmpeg",
+	}
+}
+
+// SnapshotFrame is a keyframe of the track that will be decoded
+type SnapshotFrame struct {
+	MimeType string
+	Width    uint32
+	Height   uint32
+	// Container is the keyframe in a single frame WebM file, or Matroska for H264, so it can be decoded by any decoder
+	// that reads a file without knowing the codec
+	Container []byte
+}
+
+// Snapshot is a JPEG image of a video track
+type Snapshot struct {
+	ClientID string    `json:"client_id"`
+	TrackID  string    `json:"track_id"`
+	Time     time.Time `json:"time"`
+	Width    uint32    `json:"width"`
+	Height   uint32    `json:"height"`
+	JPEG     []byte    `json:"-"`
+}
+
+// Snapshotter takes a JPEG snapshot of a video track periodically, for the room preview tiles and the moderation.
+// The SFU never decode the media, so the keyframe is decoded by ffmpeg or the SnapshotDecoder. A keyframe is requested
+// from the publisher when a snapshot is due, so the snapshot is taken even if the publisher sends the keyframes rarely.
+//
+// Snapshotter implements http.Handler that serves the latest snapshot.
+type Snapshotter struct {
+	mu         sync.RWMutex
+	context    context.Context
+	cancel     context.CancelFunc
+	track      ITrack
+	options    SnapshotOptions
+	mimeType   string
+	frames     chan []*rtp.Packet
+	latest     *Snapshot
+	onSnapshot func(Snapshot)
+	log        logging.LeveledLogger
+	// the state of the read loop
+	frame   []*rtp.Packet
+	lastSeq uint16
+	hasSeq  bool
+	next    time.Time
+	lastPLI time.Time
+	// the track is only read while a snapshot is due, so the reader doesn't keep the track from being paused
+	readMu     sync.Mutex
+	unregister func()
+}
+
+// StartSnapshots starts taking the snapshots of a video track in the room until the track is ended or the snapshotter is stopped
+func (r *Room) StartSnapshots(trackID string, opts SnapshotOptions) (*Snapshotter, error) {
+	if r.options.E2EE {
+		return nil, ErrE2EENotSupported
+	}
+
+	for _, track := range r.sfu.AvailableTracks() {
+		if track.ID() == trackID {
+			return newSnapshotter(r.context, track, opts, r.sfu.log)
+		}
+	}
+
+	return nil, ErrTrackIsNotExists
+}
+
+func newSnapshotter(ctx context.Context, track ITrack, opts SnapshotOptions, log logging.LeveledLogger) (*Snapshotter, error) {
+	if track.Kind() != webrtc.RTPCodecTypeVideo {
+		return nil, ErrSnapshotVideoOnly
+	}
+
+	if track.IsE2EE() {
+		return nil, ErrE2EENotSupported
+	}
+
+	mimeType := strings.ToLower(track.MimeType())
+
+	switch mimeType {
+	case strings.ToLower(webrtc.MimeTypeVP8), strings.ToLower(webrtc.MimeTypeVP9), strings.ToLower(webrtc.MimeTypeH264):
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrRecordingUnsupportedCodec, track.MimeType())
+	}
+
+	defaults := DefaultSnapshotOptions()
+
+	if opts.Interval <= 0 {
+		opts.Interval = defaults.Interval
+	}
+
+	if opts.Quality == QualityNone {
+		opts.Quality = defaults.Quality
+	}
+
+	if opts.JPEGQuality <= 0 {
+		opts.JPEGQuality = defaults.JPEGQuality
+	}
+
+	if opts.FFmpegPath == "" {
+		opts.FFmpegPath = defaults.FFmpegPath
+	}
+
+	snapshotCtx, cancel := context.WithCancel(ctx)
+
+	s := &Snapshotter{
+		context:  snapshotCtx,
+		cancel:   cancel,
+		track:    track,
+		options:  opts,
+		mimeType: mimeType,
+		frames:   make(chan []*rtp.Packet, 1),
+		log:      log,
+	}
+
+	if s.options.Decoder == nil {
+		s.options.Decoder = s.decodeFFmpeg
+	}
+
+	context.AfterFunc(snapshotCtx, s.stopRead)
+
+	s.read()
+
+	track.OnEnded(s.Stop)
+
+	go s.run()
+
+	return s, nil
+}
+
+// OnSnapshot is called every time a new snapshot is taken
+func (s *Snapshotter) OnSnapshot(callback func(Snapshot)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onSnapshot = callback
+}
+
+// Latest returns the latest snapshot, ErrSnapshotNotTaken if the first snapshot is not taken yet
+func (s *Snapshotter) Latest() (Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.latest == nil {
+		return Snapshot{}, ErrSnapshotNotTaken
+	}
+
+	return *s.latest, nil
+}
+
+// Stop stops taking the snapshots, the latest snapshot is still served
+func (s *Snapshotter) Stop() {
+	s.cancel()
+}
+
+// ServeHTTP responds with the latest JPEG snapshot, or 404 if no snapshot is taken yet
+func (s *Snapshotter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := s.Latest()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "image/jpeg")
+
+	http.ServeContent(w, r, "", snapshot.Time, bytes.NewReader(snapshot.JPEG))
+}
+
+// read registers the read callback of the track until the keyframe of the next snapshot is collected
+func (s *Snapshotter) read() {
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+
+	if s.context.Err() != nil || s.unregister != nil {
+		return
+	}
+
+	s.unregister = s.track.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, quality QualityLevel) {
+		if s.track.IsSimulcast() && quality != s.options.Quality {
+			return
+		}
+
+		s.onRead(p)
+	})
+}
+
+// stopRead unregisters the read callback once the keyframe is collected or the snapshotter is stopped
+func (s *Snapshotter) stopRead() {
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+
+	if s.unregister != nil {
+		s.unregister()
+		s.unregister = nil
+	}
+}
+
+// onRead collects the packets of the next keyframe when a snapshot is due, it's called from the track read loop
+func (s *Snapshotter) onRead(p *rtp.Packet) {
+	if s.context.Err() != nil {
+		return
+	}
+
+	now := time.Now()
+	if now.Before(s.next) {
+		s.hasSeq = false
+		return
+	}
+
+	if s.hasSeq && p.SequenceNumber != s.lastSeq+1 {
+		// the keyframe is broken, wait for the next one
+		s.frame = nil
+	}
+
+	s.hasSeq = true
+	s.lastSeq = p.SequenceNumber
+
+	if len(s.frame) > 0 && s.frame[0].Timestamp != p.Timestamp {
+		// the marker of the keyframe is lost
+		s.frame = nil
+	}
+
+	if len(s.frame) == 0 && !IsKeyframe(s.mimeType, p.Payload) {
+		if now.Sub(s.lastPLI) >= snapshotKeyframeRequestInterval {
+			s.lastPLI = now
+			requestKeyframe(s.track)
+		}
+
+		return
+	}
+
+	// the packet is copied because it will be returned to the pool
+	s.frame = append(s.frame, p.Clone())
+
+	if !p.Marker {
+		return
+	}
+
+	select {
+	case s.frames <- s.frame:
+		s.next = now.Add(s.options.Interval)
+
+		// the track is read again when the next snapshot is due
+		s.stopRead()
+		time.AfterFunc(s.options.Interval, s.read)
+	default:
+		// the previous keyframe is still decoded, try again with the next keyframe
+	}
+
+	s.frame = nil
+}
+
+func (s *Snapshotter) run() {
+	for {
+		select {
+		case <-s.context.Done():
+			return
+		case packets := <-s.frames:
+			snapshot, err := s.decode(packets)
+			if err != nil {
+				s.log.Warnf("snapshot: failed to decode keyframe of track %s: %s", s.track.ID(), err.Error())
+				continue
+			}
+
+			s.mu.Lock()
+			s.latest = &snapshot
+			callback := s.onSnapshot
+			s.mu.Unlock()
+
+			if callback != nil {
+				callback(snapshot)
+			}
+		}
+	}
+}
+
+func (s *Snapshotter) decode(packets []*rtp.Packet) (Snapshot, error) {
+	frame, err := snapshotFrame(s.mimeType, packets)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	jpeg, err := s.options.Decoder(s.context, frame)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	width, height := frame.Width, frame.Height
+	if s.options.Width > 0 && width > 0 {
+		height = height * s.options.Width / width
+		width = s.options.Width
+	}
+
+	return Snapshot{
+		ClientID: s.track.ClientID(),
+		TrackID:  s.track.ID(),
+		Time:     time.Now(),
+		Width:    width,
+		Height:   height,
+		JPEG:     jpeg,
+	}, nil
+}
+
+// decodeFFmpeg decodes the keyframe with ffmpeg from the stdin and reads the JPEG image from the stdout
+func (s *Snapshotter) decodeFFmpeg(ctx context.Context, frame SnapshotFrame) ([]byte, error) {
+	args := []string{"-hide_banner", "-loglevel", "error", "-i", "pipe:0", "-frames:v", "1"}
+
+	if s.options.Width > 0 {
+		// the height must be even for the yuv420p output
+		args = append(args, "-vf", "scale="+strconv.FormatUint(uint64(s.options.Width), 10)+":-2")
+	}
+
+	args = append(args, "-q:v", strconv.Itoa(s.options.JPEGQuality), "-f", "image2", "-c:v", "mjpeg", "pipe:1")
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	cmd := exec.CommandContext(ctx, s.options.FFmpegPath, args...)
+	cmd.Stdin = bytes.NewReader(frame.Container)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("snapshot: ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// snapshotFrame writes the keyframe packets into a single frame WebM or Matroska file
+func snapshotFrame(mimeType string, packets []*rtp.Packet) (SnapshotFrame, error) {
+	data, err := depacketizeFrame(mimeType, packets)
+	if err != nil {
+		return SnapshotFrame{}, err
+	}
+
+	if len(data) == 0 {
+		return SnapshotFrame{}, ErrSnapshotFrameEmpty
+	}
+
+	width, height := KeyframeDimensions(mimeType, packets[0].Payload)
+
+	entry := webmwriter.TrackEntry{
+		TrackNumber: 1,
+		TrackType:   webmwriter.TrackTypeVideo,
+		Width:       width,
+		Height:      height,
+	}
+
+	docType := webmwriter.DocTypeWebM
+
+	switch mimeType {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		entry.CodecID = webmwriter.CodecVP8
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		entry.CodecID = webmwriter.CodecVP9
+	case strings.ToLower(webrtc.MimeTypeH264):
+		entry.CodecID = webmwriter.CodecH264
+		entry.CodecPrivate = h264DecoderConfig(data)
+		docType = webmwriter.DocTypeMatroska
+	}
+
+	buf := &snapshotBuffer{}
+
+	writer := webmwriter.New(buf, docType, []webmwriter.TrackEntry{entry})
+	if err := writer.WriteFrame(1, true, 0, data); err != nil {
+		return SnapshotFrame{}, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return SnapshotFrame{}, err
+	}
+
+	return SnapshotFrame{
+		MimeType:  mimeType,
+		Width:     width,
+		Height:    height,
+		Container: buf.Bytes(),
+	}, nil
+}
+
+// snapshotBuffer is the in-memory file of the webm writer
+type snapshotBuffer struct {
+	bytes.Buffer
+}
+
+func (b *snapshotBuffer) Close() error {
+	return nil
+}
@@ -0,0 +1,112 @@
+package sfu
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotFrame(t *testing.T) {
+	// VP8 payload descriptor with the start of partition bit, then a 640x360 keyframe header
+	keyframe := []byte{0x10, 0x50, 0x01, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0x68, 0x01, 0xaa, 0xbb}
+	packets := []*rtp.Packet{
+		{Header: rtp.Header{SequenceNumber: 1, Timestamp: 3000}, Payload: keyframe},
+		{Header: rtp.Header{SequenceNumber: 2, Timestamp: 3000, Marker: true}, Payload: []byte{0x00, 0xcc, 0xdd}},
+	}
+
+	frame, err := snapshotFrame("video/vp8", packets)
+	require.NoError(t, err)
+	require.Equal(t, uint32(640), frame.Width)
+	require.Equal(t, uint32(360), frame.Height)
+
+	// the file starts with the EBML header and contains the whole frame
+	require.True(t, bytes.HasPrefix(frame.Container, []byte{0x1a, 0x45, 0xdf, 0xa3}))
+	require.True(t, bytes.Contains(frame.Container, append(keyframe[1:], 0xcc, 0xdd)))
+
+	_, err = snapshotFrame("video/vp8", []*rtp.Packet{{Payload: []byte{0x10}}})
+	require.ErrorIs(t, err, ErrSnapshotFrameEmpty)
+}
+
+func TestSnapshotterServeHTTP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	snapshotter := &Snapshotter{context: ctx, cancel: cancel}
+
+	res := httptest.NewRecorder()
+	snapshotter.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/snapshot.jpg", nil))
+	require.Equal(t, http.StatusNotFound, res.Code)
+
+	snapshotter.latest = &Snapshot{
+		TrackID: "video",
+		Time:    time.Now(),
+		JPEG:    []byte{0xff, 0xd8, 0xff, 0xd9},
+	}
+
+	res = httptest.NewRecorder()
+	snapshotter.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/snapshot.jpg", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "image/jpeg", res.Header().Get("Content-Type"))
+	require.Equal(t, []byte{0xff, 0xd8, 0xff, 0xd9}, res.Body.Bytes())
+}
+
+func TestSnapshotterVideoOnly(t *testing.T) {
+	_, err := newSnapshotter(context.Background(), &Track{base: &baseTrack{kind: webrtc.RTPCodecTypeAudio}}, DefaultSnapshotOptions(), nil)
+	require.ErrorIs(t, err, ErrSnapshotVideoOnly)
+}
+
+func TestSnapshotterReadCallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	track := newTestForwardedTrack(ctx)
+	track.base.kind = webrtc.RTPCodecTypeVideo
+	track.base.codec.MimeType = webrtc.MimeTypeVP8
+
+	opts := DefaultSnapshotOptions()
+	opts.Interval = 100 * time.Millisecond
+	opts.Decoder = func(_ context.Context, _ SnapshotFrame) ([]byte, error) {
+		return []byte{0xff, 0xd8, 0xff, 0xd9}, nil
+	}
+
+	snapshotter, err := newSnapshotter(ctx, track, opts, TestLogger)
+	require.NoError(t, err)
+
+	readCallbacks := func() int {
+		track.mu.Lock()
+		defer track.mu.Unlock()
+
+		return len(track.onReadCallbacks)
+	}
+
+	require.Equal(t, 1, readCallbacks())
+
+	keyframe := []byte{0x10, 0x50, 0x01, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0x68, 0x01, 0xaa, 0xbb}
+	track.onRead(nil, &rtp.Packet{Header: rtp.Header{SequenceNumber: 1, Timestamp: 3000, Marker: true}, Payload: keyframe}, QualityHigh)
+
+	// the track is not read until the next snapshot is due
+	require.Equal(t, 0, readCallbacks())
+
+	require.Eventually(t, func() bool {
+		_, err := snapshotter.Latest()
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		return readCallbacks() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// the stopped snapshotter doesn't read the track anymore
+	snapshotter.Stop()
+
+	require.Eventually(t, func() bool {
+		return readCallbacks() == 0
+	}, time.Second, 10*time.Millisecond)
+}
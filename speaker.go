@@ -0,0 +1,309 @@
+package sfu
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/inlivedev/sfu/pkg/interceptors/voiceactivedetector"
+)
+
+const (
+	// the audio packet duration that used to normalize the speaking duration in the interval
+	speakerPacketDuration = 20 * time.Millisecond
+	// the weight of the previous score on every interval
+	speakerSmoothing = 0.6
+	// the minimum score to be a dominant speaker
+	speakerMinScore = 0.01
+	// the new dominant speaker must be louder than the current one by this ratio
+	speakerSwitchRatio = 1.2
+)
+
+// SpeakerRank is the speaking score of a client in the room, sorted from the loudest
+type SpeakerRank struct {
+	ClientID string `json:"client_id"`
+	// AudioLevel is the smoothed linear audio level from 0 to 1, weighted by the speaking duration
+	AudioLevel float64   `json:"audio_level"`
+	Speaking   bool      `json:"speaking"`
+	LastSpoke  time.Time `json:"last_spoke"`
+}
+
+// AudioLevel is the average linear audio level of a client since the last broadcast, from 0 to 1
+type AudioLevel struct {
+	ClientID   string  `json:"client_id"`
+	AudioLevel float64 `json:"audio_level"`
+}
+
+type speakerState struct {
+	score     float64
+	sum       float64
+	packets   int
+	lastSpoke time.Time
+	// the audio levels since the last broadcast to the clients
+	broadcastSum     float64
+	broadcastPackets int
+}
+
+// speakerDetector ranks the speakers in the room from the audio levels that detected by the voice activity detector
+// of the publisher clients. The voice detection must be enabled on the client options to be ranked.
+type speakerDetector struct {
+	mu                         sync.Mutex
+	interval                   time.Duration
+	speakers                   map[string]*speakerState
+	ranking                    []SpeakerRank
+	dominant                   string
+	onDominantChangedCallbacks []func(clientID string)
+	onSpeakerRankingCallbacks  []func(ranking []SpeakerRank)
+}
+
+func newSpeakerDetector(interval time.Duration) *speakerDetector {
+	return &speakerDetector{
+		interval: interval,
+		speakers: make(map[string]*speakerState),
+		ranking:  make([]SpeakerRank, 0),
+	}
+}
+
+// audioLevelToLinear converts the audio level in -dBov from the audio level header extension to the linear level
+func audioLevelToLinear(level uint8) float64 {
+	return math.Pow(10, -float64(level)/20)
+}
+
+func (d *speakerDetector) addTracks(tracks []ITrack) {
+	for _, track := range tracks {
+		audioTrack, ok := track.(*AudioTrack)
+		if !ok {
+			continue
+		}
+
+		clientID := track.ClientID()
+
+		// the callback is called with nil packets when the voice is stopped
+		audioTrack.OnVoiceDetected(func(pkts []voiceactivedetector.VoicePacketData) {
+			if len(pkts) > 0 {
+				d.addLevels(clientID, pkts, time.Now())
+			}
+		})
+	}
+}
+
+func (d *speakerDetector) addLevels(clientID string, pkts []voiceactivedetector.VoicePacketData, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.speakers[clientID]
+	if !ok {
+		state = &speakerState{}
+		d.speakers[clientID] = state
+	}
+
+	for _, pkt := range pkts {
+		level := audioLevelToLinear(pkt.AudioLevel)
+		state.sum += level
+		state.packets++
+		state.broadcastSum += level
+		state.broadcastPackets++
+	}
+
+	state.lastSpoke = now
+}
+
+func (d *speakerDetector) removeClient(clientID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.speakers, clientID)
+
+	if d.dominant == clientID {
+		d.dominant = ""
+	}
+}
+
+func (d *speakerDetector) lastSpoke(clientID string) time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if state, ok := d.speakers[clientID]; ok {
+		return state.lastSpoke
+	}
+
+	return time.Time{}
+}
+
+// takeAudioLevels returns the average audio levels of the clients that spoke since the last call
+func (d *speakerDetector) takeAudioLevels() []AudioLevel {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	levels := make([]AudioLevel, 0)
+
+	for clientID, state := range d.speakers {
+		if state.broadcastPackets == 0 {
+			continue
+		}
+
+		levels = append(levels, AudioLevel{
+			ClientID:   clientID,
+			AudioLevel: state.broadcastSum / float64(state.broadcastPackets),
+		})
+
+		state.broadcastSum = 0
+		state.broadcastPackets = 0
+	}
+
+	sort.Slice(levels, func(i, j int) bool {
+		return levels[i].ClientID < levels[j].ClientID
+	})
+
+	return levels
+}
+
+// update calculates the speaker scores of the last interval and updates the ranking and the dominant speaker
+func (d *speakerDetector) update() {
+	d.mu.Lock()
+
+	expectedPackets := float64(d.interval / speakerPacketDuration)
+	if expectedPackets < 1 {
+		expectedPackets = 1
+	}
+
+	ranking := make([]SpeakerRank, 0, len(d.speakers))
+
+	for clientID, state := range d.speakers {
+		level := math.Min(state.sum/expectedPackets, 1)
+		state.score = speakerSmoothing*state.score + (1-speakerSmoothing)*level
+
+		ranking = append(ranking, SpeakerRank{
+			ClientID:   clientID,
+			AudioLevel: state.score,
+			Speaking:   state.packets > 0,
+			LastSpoke:  state.lastSpoke,
+		})
+
+		state.sum = 0
+		state.packets = 0
+	}
+
+	sort.Slice(ranking, func(i, j int) bool {
+		if ranking[i].AudioLevel != ranking[j].AudioLevel {
+			return ranking[i].AudioLevel > ranking[j].AudioLevel
+		}
+
+		return ranking[i].ClientID < ranking[j].ClientID
+	})
+
+	d.ranking = ranking
+
+	dominantChanged := false
+
+	if len(ranking) > 0 && ranking[0].ClientID != d.dominant && ranking[0].AudioLevel >= speakerMinScore {
+		current, ok := d.speakers[d.dominant]
+		if !ok || ranking[0].AudioLevel > current.score*speakerSwitchRatio {
+			d.dominant = ranking[0].ClientID
+			dominantChanged = true
+		}
+	}
+
+	dominant := d.dominant
+	onDominantChanged := d.onDominantChangedCallbacks
+	onSpeakerRanking := d.onSpeakerRankingCallbacks
+
+	d.mu.Unlock()
+
+	if dominantChanged {
+		for _, callback := range onDominantChanged {
+			callback(dominant)
+		}
+	}
+
+	if len(ranking) > 0 {
+		for _, callback := range onSpeakerRanking {
+			callback(ranking)
+		}
+	}
+}
+
+// SpeakerRanking returns the speakers ranking of the last interval, sorted from the loudest
+func (r *Room) SpeakerRanking() []SpeakerRank {
+	r.speakers.mu.Lock()
+	defer r.speakers.mu.Unlock()
+
+	ranking := make([]SpeakerRank, len(r.speakers.ranking))
+	copy(ranking, r.speakers.ranking)
+
+	return ranking
+}
+
+// DominantSpeaker returns the client ID of the current dominant speaker, empty if there is no one spoke yet
+func (r *Room) DominantSpeaker() string {
+	r.speakers.mu.Lock()
+	defer r.speakers.mu.Unlock()
+
+	return r.speakers.dominant
+}
+
+// OnDominantSpeakerChanged is called when the loudest speaker in the room is changed.
+// The dominant speaker is only changed when the new speaker is louder than the current one for a while,
+// so the layout is not flickering when people are talking at the same time.
+func (r *Room) OnDominantSpeakerChanged(callback func(clientID string)) {
+	r.speakers.mu.Lock()
+	defer r.speakers.mu.Unlock()
+
+	r.speakers.onDominantChangedCallbacks = append(r.speakers.onDominantChangedCallbacks, callback)
+}
+
+// OnSpeakerRanking is called on every RoomOptions.ActiveSpeakerInterval with the speakers ranking
+func (r *Room) OnSpeakerRanking(callback func(ranking []SpeakerRank)) {
+	r.speakers.mu.Lock()
+	defer r.speakers.mu.Unlock()
+
+	r.speakers.onSpeakerRankingCallbacks = append(r.speakers.onSpeakerRankingCallbacks, callback)
+}
+
+func (r *Room) loopSpeakerRanking() {
+	ticker := time.NewTicker(r.speakers.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.context.Done():
+			return
+		case <-ticker.C:
+			r.speakers.update()
+		}
+	}
+}
+
+// loopAudioLevels broadcasts the audio levels of the speaking clients to all clients through the internal data channel,
+// so the client can render the speaking indicators without decoding all the audio tracks
+func (r *Room) loopAudioLevels(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.context.Done():
+			return
+		case <-ticker.C:
+			levels := r.speakers.takeAudioLevels()
+			if len(levels) == 0 {
+				continue
+			}
+
+			data, err := json.Marshal(internalDataAudioLevels{
+				Type: messageTypeAudioLevels,
+				Data: levels,
+			})
+			if err != nil {
+				r.sfu.log.Errorf("room: error marshal audio levels ", err)
+				continue
+			}
+
+			for _, client := range r.sfu.GetClients() {
+				client.sendInternalMessage(data)
+			}
+		}
+	}
+}
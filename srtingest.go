@@ -0,0 +1,185 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// ErrSRTIngestUnsupportedStream is the Err of the ingest that ended because the MPEG-TS has a stream that can't be
+// published without transcoding, see SRTIngestOptions.IgnoreUnsupportedStreams
+var ErrSRTIngestUnsupportedStream = errors.New("srtingest: error the stream is not supported, only H264 and Opus are published")
+
+type SRTIngestOptions struct {
+	// StreamID of the published tracks, default is the ingest client ID
+	StreamID      string        `json:"stream_id"`
+	ClientOptions ClientOptions `json:"client_options"`
+	// IgnoreUnsupportedStreams publishes the H264 and Opus streams of the MPEG-TS and skips the others, like AAC audio.
+	// Default is false, the ingest is ended with ErrSRTIngestUnsupportedStream because the media is not transcoded
+	IgnoreUnsupportedStreams bool `json:"ignore_unsupported_streams"`
+}
+
+func DefaultSRTIngestOptions() SRTIngestOptions {
+	return SRTIngestOptions{
+		ClientOptions: DefaultClientOptions(),
+	}
+}
+
+// SRTIngest publishes the H264 video and Opus audio from an MPEG-TS stream, like the SRT output of a broadcast encoder,
+// to a room. The SFU doesn't listen for SRT: the application accepts the connection with an SRT library and the ingest
+// only demuxes the MPEG-TS that read from it into the relay tracks that owned by the ingest client.
+type SRTIngest struct {
+	mu     sync.Mutex
+	ingest *RTPIngest
+	conn   io.ReadCloser
+	tracks map[uint16]*srtIngestTrack
+	done   chan bool
+	err    error
+	// skip the unsupported streams instead of ending the ingest
+	ignoreUnsupported bool
+}
+
+type srtIngestTrack struct {
+	stream     *rtpIngestStream
+	packetizer *rtpIngestPacketizer
+}
+
+// IngestSRT starts reading the MPEG-TS from the conn, a track is published when its elementary stream is found in
+// the program map table. There is no SRT listener in the SFU, the conn is the connection that accepted by the SRT
+// library of the application, or any other reader of an MPEG-TS stream.
+//
+// The media is not transcoded, a stream that is not supported by WebRTC, like AAC audio, ends the ingest with
+// ErrSRTIngestUnsupportedStream unless SRTIngestOptions.IgnoreUnsupportedStreams is set. The ingest is also closed
+// when the conn returns an error, the ingest is closed, or the room is closed.
+func (r *Room) IngestSRT(name string, conn io.ReadCloser, opts SRTIngestOptions) (*SRTIngest, error) {
+	ingest, err := r.IngestRTP(name, RTPIngestOptions{StreamID: opts.StreamID, ClientOptions: opts.ClientOptions})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SRTIngest{
+		ingest: ingest,
+		conn:   conn,
+		tracks: make(map[uint16]*srtIngestTrack),
+		done:   make(chan bool),
+
+		ignoreUnsupported: opts.IgnoreUnsupportedStreams,
+	}
+
+	go s.readLoop()
+
+	go func() {
+		<-ingest.context.Done()
+		_ = conn.Close()
+	}()
+
+	return s, nil
+}
+
+// ClientID returns the ID of the ingest client that publishes the tracks
+func (s *SRTIngest) ClientID() string {
+	return s.ingest.ClientID()
+}
+
+// Tracks returns the relay tracks of the supported elementary streams that found in the MPEG-TS
+func (s *SRTIngest) Tracks() []ITrack {
+	return s.ingest.Tracks()
+}
+
+// Done is closed when the ingest is ended, use Err to get the reason
+func (s *SRTIngest) Done() <-chan bool {
+	return s.done
+}
+
+// Err returns the read error of the conn or ErrSRTIngestUnsupportedStream after the ingest is ended, it's nil if
+// the conn is ended with io.EOF or the ingest is closed
+func (s *SRTIngest) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Close ends the relay tracks and closes the conn
+func (s *SRTIngest) Close() error {
+	err := s.ingest.Close()
+	<-s.done
+
+	return err
+}
+
+func (s *SRTIngest) readLoop() {
+	demuxer := newTSDemuxer(s.conn, s.onStream, s.onPES)
+
+	err := demuxer.run()
+
+	if s.ingest.context.Err() == nil && !errors.Is(err, io.EOF) {
+		s.ingest.log.Errorf("srtingest: read error: %s", err.Error())
+
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+	}
+
+	s.ingest.cancel()
+	<-s.ingest.done
+
+	close(s.done)
+}
+
+func (s *SRTIngest) onStream(es *tsElementaryStream) error {
+	if es.mimeType == "" {
+		if !s.ignoreUnsupported {
+			return fmt.Errorf("%w: stream type 0x%02x of pid %d", ErrSRTIngestUnsupportedStream, es.streamType, es.pid)
+		}
+
+		s.ingest.log.Warnf("srtingest: stream type 0x%02x of pid %d is not supported, only H264 and Opus", es.streamType, es.pid)
+
+		return nil
+	}
+
+	stream, err := s.ingest.addStream(nil, es.mimeType)
+	if err != nil {
+		s.ingest.log.Warnf("srtingest: error add stream of pid %d: %s", es.pid, err.Error())
+		return nil
+	}
+
+	// the payloader is always found for H264 and Opus
+	packetizer, _ := newRTPIngestPacketizer(es.mimeType)
+
+	s.tracks[es.pid] = &srtIngestTrack{
+		stream:     stream,
+		packetizer: packetizer,
+	}
+
+	return nil
+}
+
+func (s *SRTIngest) onPES(es *tsElementaryStream, pts uint64, data []byte) {
+	track, ok := s.tracks[es.pid]
+	if !ok {
+		return
+	}
+
+	// the PTS is in 90kHz, the same clock rate as the video
+	timestamp := uint32(pts * uint64(track.packetizer.clockRate) / 90000)
+
+	if es.mimeType != webrtc.MimeTypeOpus {
+		s.writeSample(track, timestamp, data)
+		return
+	}
+
+	for _, unit := range tsOpusAccessUnits(data) {
+		s.writeSample(track, timestamp, unit)
+		timestamp += opusSamples(unit)
+	}
+}
+
+func (s *SRTIngest) writeSample(track *srtIngestTrack, timestamp uint32, sample []byte) {
+	for _, p := range track.packetizer.packetize(timestamp, sample) {
+		s.ingest.writeRTP(track.stream, p)
+	}
+}
@@ -0,0 +1,213 @@
+package sfu
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// tsTestPacket returns a TS packet with the payload, the payload is padded with the adaptation field stuffing
+func tsTestPacket(pid uint16, unitStart bool, payload []byte) []byte {
+	packet := []byte{tsSyncByte, byte(pid>>8) & 0x1f, byte(pid), 0x10}
+	if unitStart {
+		packet[1] |= 0x40
+	}
+
+	if stuffing := tsPacketSize - 4 - len(payload); stuffing > 0 {
+		packet[3] = 0x30
+		packet = append(packet, byte(stuffing-1))
+
+		if stuffing > 1 {
+			packet = append(packet, 0x00)
+			packet = append(packet, bytes.Repeat([]byte{0xff}, stuffing-2)...)
+		}
+	}
+
+	return append(packet, payload...)
+}
+
+func tsTestPES(streamID byte, pts uint64, payload []byte, withLength bool) []byte {
+	length := 0
+	if withLength {
+		length = 8 + len(payload)
+	}
+
+	pes := []byte{0x00, 0x00, 0x01, streamID, byte(length >> 8), byte(length), 0x80, 0x80, 0x05,
+		0x21 | byte(pts>>29)&0x0e, byte(pts >> 22), 0x01 | byte(pts>>14)&0xfe, byte(pts >> 7), 0x01 | byte(pts<<1)&0xfe}
+
+	return append(pes, payload...)
+}
+
+func TestSRTIngest(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-srt-ingest-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	reader, writer := io.Pipe()
+
+	opts := DefaultSRTIngestOptions()
+	opts.IgnoreUnsupportedStreams = true
+
+	ingest, err := testRoom.IngestSRT("encoder", reader, opts)
+	require.NoError(t, err)
+
+	pat := []byte{0x00, 0x00, 0xb0, 13, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xe1, 0x00, 0, 0, 0, 0}
+	// H264, Opus, and AAC that is ignored
+	pmt := []byte{0x00, 0x02, 0xb0, 34, 0x00, 0x01, 0xc1, 0x00, 0x00, 0xe1, 0x01, 0xf0, 0x00,
+		tsStreamTypeH264, 0xe1, 0x01, 0xf0, 0x00,
+		tsStreamTypePrivate, 0xe1, 0x02, 0xf0, 0x06, tsDescriptorRegistration, 0x04, 'O', 'p', 'u', 's',
+		0x0f, 0xe1, 0x03, 0xf0, 0x00,
+		0, 0, 0, 0}
+
+	h264 := []byte{0, 0, 0, 1, 0x67, 0x42, 0xc0, 0x1f, 0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80, 0, 0, 0, 1, 0x65, 0x88, 0x84, 0x00}
+	// 20ms CELT frame
+	opus := []byte{0xfc, 0x01, 0x02, 0x03, 0x04}
+	opusAU := append([]byte{0x7f, 0xe0, byte(len(opus))}, opus...)
+
+	sendCtx, cancelSend := context.WithCancel(ctx)
+	defer cancelSend()
+
+	go func() {
+		defer writer.Close()
+
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+
+		for i := uint64(0); ; i++ {
+			packets := [][]byte{
+				tsTestPacket(tsPIDPAT, true, pat),
+				tsTestPacket(0x100, true, pmt),
+				tsTestPacket(0x101, true, tsTestPES(0xe0, i*1800, h264, false)),
+				tsTestPacket(0x102, true, tsTestPES(0xc0, i*1800, opusAU, true)),
+			}
+
+			for _, packet := range packets {
+				if _, err := writer.Write(packet); err != nil {
+					return
+				}
+			}
+
+			select {
+			case <-sendCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelTimeout()
+
+	for len(ingest.Tracks()) < 2 {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the ingest tracks")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	require.Len(t, ingest.Tracks(), 2)
+
+	received := make(map[webrtc.RTPCodecType]chan rtp.Packet)
+
+	for _, track := range ingest.Tracks() {
+		packets := make(chan rtp.Packet, 1)
+		received[track.Kind()] = packets
+
+		// the packet is released to the pool after the callback
+		track.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, _ QualityLevel) {
+			select {
+			case packets <- *p.Clone():
+			default:
+			}
+		})
+	}
+
+	select {
+	case p := <-received[webrtc.RTPCodecTypeAudio]:
+		require.Equal(t, opus, p.Payload)
+		require.True(t, p.Marker)
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the audio packet")
+	}
+
+	select {
+	case p := <-received[webrtc.RTPCodecTypeVideo]:
+		require.NotEmpty(t, p.Payload)
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the video packet")
+	}
+
+	// the ingest is ended when the encoder is disconnected
+	cancelSend()
+
+	select {
+	case <-ingest.Done():
+		require.NoError(t, ingest.Err())
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the ingest is ended")
+	}
+
+	require.ErrorIs(t, ingest.Close(), ErrRTPIngestClosed)
+}
+
+func TestSRTIngestUnsupportedStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-srt-ingest-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	reader, writer := io.Pipe()
+	defer writer.Close()
+
+	ingest, err := testRoom.IngestSRT("encoder", reader, DefaultSRTIngestOptions())
+	require.NoError(t, err)
+
+	pat := []byte{0x00, 0x00, 0xb0, 13, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xe1, 0x00, 0, 0, 0, 0}
+	// H264 and AAC
+	pmt := []byte{0x00, 0x02, 0xb0, 23, 0x00, 0x01, 0xc1, 0x00, 0x00, 0xe1, 0x01, 0xf0, 0x00,
+		tsStreamTypeH264, 0xe1, 0x01, 0xf0, 0x00,
+		0x0f, 0xe1, 0x03, 0xf0, 0x00,
+		0, 0, 0, 0}
+
+	go func() {
+		for _, packet := range [][]byte{tsTestPacket(tsPIDPAT, true, pat), tsTestPacket(0x100, true, pmt)} {
+			if _, err := writer.Write(packet); err != nil {
+				return
+			}
+		}
+	}()
+
+	// the AAC audio can't be published without transcoding
+	select {
+	case <-ingest.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the ingest to end")
+	}
+
+	require.ErrorIs(t, ingest.Err(), ErrSRTIngestUnsupportedStream)
+
+	// the ingest client is removed with its tracks
+	require.Eventually(t, func() bool {
+		return len(testRoom.SFU().GetClients()) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
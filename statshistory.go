@@ -0,0 +1,209 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+)
+
+// StatsHistoryOptions configures the stats history of the published tracks, see Track.StatsHistory
+type StatsHistoryOptions struct {
+	// Retention is how long the samples are kept, the older samples are replaced. Default is 5 minutes
+	Retention time.Duration `json:"retention_ns" example:"300000000000" default:"300000000000"`
+	// Resolution is the interval of the samples, the stats are updated every second so it's at least 1 second. Default is 1 second
+	Resolution time.Duration `json:"resolution_ns" example:"1000000000" default:"1000000000"`
+}
+
+func DefaultStatsHistoryOptions() StatsHistoryOptions {
+	return StatsHistoryOptions{
+		Retention:  5 * time.Minute,
+		Resolution: time.Second,
+	}
+}
+
+// TrackStatsSample is the stats of a published track at the sample time, the simulcast layers are summed.
+// The packet counters are the totals since the track is published.
+type TrackStatsSample struct {
+	Time            time.Time `json:"time"`
+	Bitrate         uint32    `json:"bitrate"`
+	PacketsReceived uint64    `json:"packets_received"`
+	PacketsLost     int64     `json:"packets_lost"`
+	// FractionLost is the lost packets since the previous sample
+	FractionLost float64 `json:"fraction_lost"`
+	// Jitter is the interarrival jitter in seconds, the highest of the simulcast layers
+	Jitter float64 `json:"jitter"`
+}
+
+// statsHistory is a ring buffer of the samples of a track
+type statsHistory struct {
+	mu      sync.Mutex
+	samples []TrackStatsSample
+	next    int
+	full    bool
+}
+
+func newStatsHistory(size int) *statsHistory {
+	return &statsHistory{
+		samples: make([]TrackStatsSample, size),
+	}
+}
+
+func (h *statsHistory) add(sample TrackStatsSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if last, ok := h.lastLocked(); ok {
+		lost := sample.PacketsLost - last.PacketsLost
+		received := int64(sample.PacketsReceived) - int64(last.PacketsReceived)
+
+		if lost > 0 && lost+received > 0 {
+			sample.FractionLost = float64(lost) / float64(lost+received)
+		}
+	}
+
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % len(h.samples)
+
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+func (h *statsHistory) lastLocked() (TrackStatsSample, bool) {
+	if !h.full && h.next == 0 {
+		return TrackStatsSample{}, false
+	}
+
+	return h.samples[(h.next+len(h.samples)-1)%len(h.samples)], true
+}
+
+// since returns the samples from the time in the chronological order
+func (h *statsHistory) since(from time.Time) []TrackStatsSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	start, count := 0, h.next
+	if h.full {
+		start, count = h.next, len(h.samples)
+	}
+
+	samples := make([]TrackStatsSample, 0, count)
+
+	for i := 0; i < count; i++ {
+		sample := h.samples[(start+i)%len(h.samples)]
+		if !sample.Time.Before(from) {
+			samples = append(samples, sample)
+		}
+	}
+
+	return samples
+}
+
+// StatsHistory returns the samples of the track in the last window, the whole history if the window is zero.
+// It's nil if RoomOptions.StatsHistory is not set.
+func (t *Track) StatsHistory(window time.Duration) []TrackStatsSample {
+	return t.base.statsHistorySince(window)
+}
+
+// StatsHistory returns the samples of the track in the last window, the whole history if the window is zero.
+// The simulcast layers are summed in a sample. It's nil if RoomOptions.StatsHistory is not set.
+func (t *SimulcastTrack) StatsHistory(window time.Duration) []TrackStatsSample {
+	return t.base.statsHistorySince(window)
+}
+
+func (t *baseTrack) statsHistorySince(window time.Duration) []TrackStatsSample {
+	history := t.statsHistory.Load()
+	if history == nil {
+		return nil
+	}
+
+	from := time.Time{}
+	if window > 0 {
+		from = time.Now().Add(-window)
+	}
+
+	return history.since(from)
+}
+
+// sampleTrack adds the current stats of the remote tracks of a published track to its history
+func sampleTrack(client *Client, base *baseTrack, remoteTracks []*remoteTrack, size int, now time.Time) {
+	history := base.statsHistory.Load()
+	if history == nil {
+		base.statsHistory.CompareAndSwap(nil, newStatsHistory(size))
+		history = base.statsHistory.Load()
+	}
+
+	sample := TrackStatsSample{Time: now}
+
+	for _, rt := range remoteTracks {
+		if rt == nil {
+			continue
+		}
+
+		sample.Bitrate += rt.Bitrate()
+
+		stat, err := client.stats.GetReceiver(rt.Track().ID(), rt.Track().RID())
+		if err != nil {
+			continue
+		}
+
+		sample.PacketsReceived += stat.InboundRTPStreamStats.PacketsReceived
+		sample.PacketsLost += stat.InboundRTPStreamStats.PacketsLost
+
+		// the jitter is in the RTP timestamp unit
+		if clockRate := base.codec.ClockRate; clockRate > 0 {
+			if jitter := stat.InboundRTPStreamStats.Jitter / float64(clockRate); jitter > sample.Jitter {
+				sample.Jitter = jitter
+			}
+		}
+	}
+
+	history.add(sample)
+}
+
+// withDefaults returns the options with the default values of the unset fields, the resolution is at least 1 second
+func (o StatsHistoryOptions) withDefaults() StatsHistoryOptions {
+	defaults := DefaultStatsHistoryOptions()
+
+	if o.Retention <= 0 {
+		o.Retention = defaults.Retention
+	}
+
+	if o.Resolution < time.Second {
+		o.Resolution = defaults.Resolution
+	}
+
+	return o
+}
+
+// loopStatsHistory samples the published tracks of all clients in the room
+func (r *Room) loopStatsHistory(opts StatsHistoryOptions) {
+	opts = opts.withDefaults()
+
+	size := int(opts.Retention / opts.Resolution)
+	if size < 1 {
+		size = 1
+	}
+
+	ticker := time.NewTicker(opts.Resolution)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.context.Done():
+			return
+		case now := <-ticker.C:
+			for _, client := range r.sfu.clients.GetClients() {
+				for _, track := range client.Tracks() {
+					switch t := track.(type) {
+					case *Track:
+						sampleTrack(client, t.base, []*remoteTrack{t.remoteTrack}, size, now)
+					case *AudioTrack:
+						sampleTrack(client, t.base, []*remoteTrack{t.remoteTrack}, size, now)
+					case *SimulcastTrack:
+						sampleTrack(client, t.base, []*remoteTrack{t.GetRemoteTrack(QualityHigh), t.GetRemoteTrack(QualityMid), t.GetRemoteTrack(QualityLow)}, size, now)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package sfu
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoomStatsHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.StatsHistory = &StatsHistoryOptions{
+		Retention:  3 * time.Second,
+		Resolution: time.Second,
+	}
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-stats-history", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	// the history is full after the retention
+	require.Eventually(t, func() bool {
+		tracks := publisher.Tracks()
+		if len(tracks) != 2 {
+			return false
+		}
+
+		for _, track := range tracks {
+			if len(track.StatsHistory(0)) != 3 {
+				return false
+			}
+		}
+
+		return true
+	}, 20*time.Second, 100*time.Millisecond)
+
+	for _, track := range publisher.Tracks() {
+		samples := track.StatsHistory(0)
+
+		for i := 1; i < len(samples); i++ {
+			require.True(t, samples[i].Time.After(samples[i-1].Time), track.ID())
+			require.GreaterOrEqual(t, samples[i].PacketsReceived, samples[i-1].PacketsReceived, track.ID())
+		}
+
+		require.NotZero(t, samples[len(samples)-1].Bitrate, track.ID())
+
+		// the window excludes the samples before the newest one
+		recent := track.StatsHistory(time.Since(samples[len(samples)-1].Time) + 100*time.Millisecond)
+		require.NotEmpty(t, recent, track.ID())
+		require.True(t, recent[0].Time.After(samples[len(samples)-2].Time), track.ID())
+	}
+
+	_ = testRoom.StopClient(publisher.ID())
+}
+
+func TestStatsHistoryRingBuffer(t *testing.T) {
+	history := newStatsHistory(3)
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		history.add(TrackStatsSample{
+			Time:            now.Add(time.Duration(i) * time.Second),
+			PacketsReceived: uint64(i * 90),
+			PacketsLost:     int64(i * 10),
+		})
+	}
+
+	// the oldest samples are replaced
+	samples := history.since(time.Time{})
+	require.Len(t, samples, 3)
+
+	for i, sample := range samples {
+		require.Equal(t, now.Add(time.Duration(i+2)*time.Second), sample.Time)
+		require.InDelta(t, 0.1, sample.FractionLost, 0.0001)
+	}
+
+	samples = history.since(now.Add(3 * time.Second))
+	require.Len(t, samples, 2)
+	require.Equal(t, uint64(360), samples[1].PacketsReceived)
+}
+
+func TestStatsHistoryOptionsDefaults(t *testing.T) {
+	require.Equal(t, DefaultStatsHistoryOptions(), StatsHistoryOptions{}.withDefaults())
+
+	// the stats are updated every second
+	opts := StatsHistoryOptions{Retention: time.Minute, Resolution: 100 * time.Millisecond}.withDefaults()
+	require.Equal(t, time.Minute, opts.Retention)
+	require.Equal(t, time.Second, opts.Resolution)
+
+	opts = StatsHistoryOptions{Resolution: 10 * time.Second}.withDefaults()
+	require.Equal(t, 5*time.Minute, opts.Retention)
+	require.Equal(t, 10*time.Second, opts.Resolution)
+}
+
+func TestRoomStatsHistoryDefaults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	// the history is kept with the default retention when only the options are set
+	roomOpts := DefaultRoomOptions()
+	roomOpts.StatsHistory = &StatsHistoryOptions{}
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-stats-history-defaults", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	require.Eventually(t, func() bool {
+		tracks := publisher.Tracks()
+		if len(tracks) != 2 {
+			return false
+		}
+
+		for _, track := range tracks {
+			if len(track.StatsHistory(0)) < 2 {
+				return false
+			}
+		}
+
+		return true
+	}, 20*time.Second, 100*time.Millisecond)
+
+	_ = testRoom.StopClient(publisher.ID())
+}
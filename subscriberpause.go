@@ -0,0 +1,118 @@
+package sfu
+
+import (
+	"errors"
+
+	"github.com/pion/webrtc/v4"
+)
+
+var ErrTrackNotPausable = errors.New("client: error only video tracks can be paused")
+
+// trackPause is the data of the track_pause message that sent by the client
+type trackPause struct {
+	TrackID string `json:"track_id"`
+	Paused  bool   `json:"paused"`
+}
+
+type internalDataTrackPause struct {
+	Type string     `json:"type"`
+	Data trackPause `json:"data"`
+}
+
+// PauseTrack stops forwarding a subscribed video track to the client without unsubscribing it, for example while the
+// video is offscreen. The track is kept paused by the subscriber even when the forwarding policy would forward it.
+func (c *Client) PauseTrack(trackID string) error {
+	return c.setTrackPausedBySubscriber(trackID, true)
+}
+
+// ResumeTrack forwards the subscribed video track that paused with PauseTrack again, a keyframe is requested from the
+// publisher so the client can render the video right away
+func (c *Client) ResumeTrack(trackID string) error {
+	return c.setTrackPausedBySubscriber(trackID, false)
+}
+
+func (c *Client) setTrackPausedBySubscriber(trackID string, paused bool) error {
+	track, ok := c.ClientTracks()[trackID]
+	if !ok {
+		return ErrTrackIsNotExists
+	}
+
+	if track.Kind() != webrtc.RTPCodecTypeVideo {
+		return ErrTrackNotPausable
+	}
+
+	if paused {
+		track.Pause()
+	} else {
+		track.Resume()
+	}
+
+	return nil
+}
+
+// pauseBySubscriber pauses the subscribed track, the pause is independent from the forwarding policy
+func (c *Client) pauseBySubscriber(trackID string) {
+	if _, loaded := c.subscriberPausedTracks.LoadOrStore(trackID, true); !loaded {
+		c.log.Infof("client: %s paused track %s", c.id, trackID)
+	}
+}
+
+// resumeBySubscriber resumes the subscribed track and requests a keyframe if it's not paused by the forwarding policy
+func (c *Client) resumeBySubscriber(trackID string) {
+	if _, loaded := c.subscriberPausedTracks.LoadAndDelete(trackID); !loaded {
+		return
+	}
+
+	c.log.Infof("client: %s resumed track %s", c.id, trackID)
+
+	if c.isTrackPaused(trackID) {
+		return
+	}
+
+	if track, ok := c.ClientTracks()[trackID]; ok {
+		track.RequestPLI()
+	}
+}
+
+func (c *Client) isTrackPausedBySubscriber(trackID string) bool {
+	_, ok := c.subscriberPausedTracks.Load(trackID)
+	return ok
+}
+
+func (c *Client) onTrackPauseMessage(data trackPause) error {
+	if data.Paused {
+		return c.PauseTrack(data.TrackID)
+	}
+
+	return c.ResumeTrack(data.TrackID)
+}
+
+// Pause stops forwarding the video track to the subscriber, see Client.PauseTrack
+func (t *clientTrack) Pause() {
+	t.client.pauseBySubscriber(t.ID())
+}
+
+// Resume forwards the video track that paused with Pause again, see Client.ResumeTrack
+func (t *clientTrack) Resume() {
+	t.client.resumeBySubscriber(t.ID())
+}
+
+// IsPaused returns true if the track is paused by the subscriber or by the forwarding policy
+func (t *clientTrack) IsPaused() bool {
+	return t.client.isTrackPaused(t.ID())
+}
+
+// Pause stops forwarding the video track to the subscriber, see Client.PauseTrack
+func (t *simulcastClientTrack) Pause() {
+	t.client.pauseBySubscriber(t.ID())
+}
+
+// Resume forwards the video track that paused with Pause again, see Client.ResumeTrack
+func (t *simulcastClientTrack) Resume() {
+	t.client.resumeBySubscriber(t.ID())
+}
+
+// IsPaused returns true if the track is paused by the subscriber or by the forwarding policy
+func (t *simulcastClientTrack) IsPaused() bool {
+	return t.client.isTrackPaused(t.ID())
+}
@@ -0,0 +1,128 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var ErrNoTrackFilter = errors.New("client: error at least one track filter is required")
+
+// TrackFilter selects the tracks from other clients to subscribe. A track matches the filter
+// when it matches all the fields that are set, the empty fields are ignored.
+type TrackFilter struct {
+	// Kind is the track kind, "audio" or "video"
+	Kind string `json:"kind,omitempty"`
+	// SourceTypes is the list of the source types, media, camera or screen
+	SourceTypes []TrackType `json:"source_types,omitempty"`
+	// Labels is the list of the track labels that set by the publisher with the track source
+	Labels []string `json:"labels,omitempty"`
+	// ClientIDs is the list of the publisher client IDs, use it to subscribe the pinned speakers only
+	ClientIDs []string `json:"client_ids,omitempty"`
+	// ClientMeta matches the publisher client metadata, all the keys must be exists with the same value.
+	// The values are compared in their string format, so the numbers that sent through JSON are matched.
+	ClientMeta map[string]interface{} `json:"client_meta,omitempty"`
+	// MaxWidth and MaxHeight limit the quality of the subscribed video tracks
+	MaxWidth  uint32 `json:"max_width,omitempty"`
+	MaxHeight uint32 `json:"max_height,omitempty"`
+	// Match is a custom predicate for the filter, it's not available through the data channel
+	Match func(track ITrack) bool `json:"-"`
+}
+
+func (f TrackFilter) match(track ITrack, publisher *Client) bool {
+	if f.Kind != "" && f.Kind != track.Kind().String() {
+		return false
+	}
+
+	if len(f.SourceTypes) > 0 && !slices.Contains(f.SourceTypes, track.SourceType()) {
+		return false
+	}
+
+	if len(f.Labels) > 0 && !slices.Contains(f.Labels, track.Source().Label) {
+		return false
+	}
+
+	if len(f.ClientIDs) > 0 && !slices.Contains(f.ClientIDs, track.ClientID()) {
+		return false
+	}
+
+	if len(f.ClientMeta) > 0 {
+		if publisher == nil {
+			return false
+		}
+
+		for key, value := range f.ClientMeta {
+			v, err := publisher.Meta().Get(key)
+			if err != nil || fmt.Sprint(v) != fmt.Sprint(value) {
+				return false
+			}
+		}
+	}
+
+	if f.Match != nil && !f.Match(track) {
+		return false
+	}
+
+	return true
+}
+
+// SubscribeTracksWithFilter subscribes the available tracks from other clients that match any of the filters.
+// The tracks that already subscribed are skipped. It returns the subscription requests that sent to SubscribeTracks,
+// so it's also works before the client is connected.
+func (c *Client) SubscribeTracksWithFilter(filters ...TrackFilter) ([]SubscribeTrackRequest, error) {
+	if len(filters) == 0 {
+		return nil, ErrNoTrackFilter
+	}
+
+	subscribed := c.ClientTracks()
+
+	c.mu.Lock()
+	for _, r := range c.pendingReceivedTracks {
+		subscribed[r.TrackID] = nil
+	}
+	c.mu.Unlock()
+
+	req := make([]SubscribeTrackRequest, 0)
+
+	add := func(track ITrack, publisher *Client) {
+		if _, ok := subscribed[track.ID()]; ok {
+			return
+		}
+
+		for _, filter := range filters {
+			if filter.match(track, publisher) {
+				req = append(req, SubscribeTrackRequest{
+					ClientID:  track.ClientID(),
+					TrackID:   track.ID(),
+					MaxWidth:  filter.MaxWidth,
+					MaxHeight: filter.MaxHeight,
+				})
+
+				subscribed[track.ID()] = nil
+
+				return
+			}
+		}
+	}
+
+	for _, client := range c.SFU().clients.GetClients() {
+		if client.ID() == c.ID() {
+			continue
+		}
+
+		for _, track := range client.tracks.GetTracks() {
+			add(track, client)
+		}
+	}
+
+	for _, track := range c.SFU().relayTrackList() {
+		publisher, _ := c.SFU().clients.GetClient(track.ClientID())
+		add(track, publisher)
+	}
+
+	if len(req) == 0 {
+		return req, nil
+	}
+
+	return req, c.SubscribeTracks(req)
+}
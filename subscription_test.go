@@ -0,0 +1,152 @@
+package sfu
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeTracksWithFilter(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	_, pinned, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "pinned", true, false, true)
+	_, other, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "other", true, false, true)
+
+	pinned.Meta().Set("pinned", true)
+
+	dcChan := make(chan *webrtc.DataChannel, 1)
+
+	pc, subscriber, _, connChan := CreateDataPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "subscriber", func(dc *webrtc.DataChannel) {
+		if dc.Label() == "internal" {
+			dcChan <- dc
+		}
+	})
+
+	defer pc.Close()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-connChan:
+			}
+		}
+	}()
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	wait := func(msg string, cond func() bool) {
+		for !cond() {
+			select {
+			case <-timeout.Done():
+				t.Fatal(msg)
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+
+	wait("timeout waiting for the published tracks", func() bool {
+		return len(pinned.Tracks()) == 2 && len(other.Tracks()) == 2
+	})
+
+	_, err = subscriber.SubscribeTracksWithFilter()
+	require.ErrorIs(t, err, ErrNoTrackFilter)
+
+	// audio only mode
+	req, err := subscriber.SubscribeTracksWithFilter(TrackFilter{Kind: "audio"})
+	require.NoError(t, err)
+	require.Len(t, req, 2)
+
+	for _, r := range req {
+		require.NotEqual(t, subscriber.ID(), r.ClientID)
+	}
+
+	// the subscribed tracks are skipped
+	req, err = subscriber.SubscribeTracksWithFilter(TrackFilter{Kind: "audio"})
+	require.NoError(t, err)
+	require.Empty(t, req)
+
+	wait("timeout waiting for the audio tracks", func() bool {
+		return len(subscriber.ClientTracks()) == 2
+	})
+
+	for _, track := range subscriber.ClientTracks() {
+		require.Equal(t, webrtc.RTPCodecTypeAudio, track.Kind())
+	}
+
+	// subscribe the video of the pinned speaker through the data channel
+	var dc *webrtc.DataChannel
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the internal data channel")
+	case dc = <-dcChan:
+	}
+
+	msg, err := json.Marshal(internalDataSubscribeTracks{
+		Type: messageTypeSubscribeTracks,
+		Data: []TrackFilter{{Kind: "video", ClientMeta: map[string]interface{}{"pinned": true}}},
+	})
+	require.NoError(t, err)
+
+	wait("timeout waiting for the internal data channel opened", func() bool {
+		return dc.ReadyState() == webrtc.DataChannelStateOpen
+	})
+
+	require.NoError(t, dc.SendText(string(msg)))
+
+	wait("timeout waiting for the pinned video track", func() bool {
+		return len(subscriber.ClientTracks()) == 3
+	})
+
+	for _, track := range pinned.Tracks() {
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			require.Contains(t, subscriber.ClientTracks(), track.ID())
+		}
+	}
+
+	_ = testRoom.StopClient(pinned.ID())
+	_ = testRoom.StopClient(other.ID())
+	_ = testRoom.StopClient(subscriber.ID())
+}
+
+func TestTrackSource(t *testing.T) {
+	track := &Track{base: &baseTrack{isScreen: &atomic.Bool{}}}
+	require.Equal(t, TrackSource{Type: TrackTypeMedia}, track.Source())
+
+	track.SetSource(TrackSource{Type: TrackTypeScreen, Label: "slides"})
+	require.True(t, track.IsScreen())
+	require.Equal(t, TrackType(TrackTypeScreen), track.SourceType())
+
+	// the label is kept when only the source type is changed
+	track.SetSourceType(TrackTypeCamera)
+	require.False(t, track.IsScreen())
+	require.Equal(t, TrackSource{Type: TrackTypeCamera, Label: "slides"}, track.Source())
+
+	require.True(t, TrackFilter{Labels: []string{"slides"}}.match(track, nil))
+	require.False(t, TrackFilter{Labels: []string{"terminal"}}.match(track, nil))
+	require.True(t, TrackFilter{SourceTypes: []TrackType{TrackTypeCamera}}.match(track, nil))
+
+	// a camera is allowed by the roles that allow the media
+	client := &Client{options: ClientOptions{AllowedSources: []TrackType{TrackTypeMedia}}}
+	require.True(t, client.canPublishSource(TrackTypeCamera))
+	require.False(t, client.canPublishSource(TrackTypeScreen))
+}
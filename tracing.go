@@ -0,0 +1,67 @@
+package sfu
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/inlivedev/sfu"
+
+// span attribute keys
+const (
+	attrRoomID        = attribute.Key("sfu.room.id")
+	attrClientID      = attribute.Key("sfu.client.id")
+	attrClientName    = attribute.Key("sfu.client.name")
+	attrClientType    = attribute.Key("sfu.client.type")
+	attrICERestart    = attribute.Key("sfu.ice_restart")
+	attrTrackID       = attribute.Key("sfu.track.id")
+	attrTrackClientID = attribute.Key("sfu.track.client_id")
+	attrTracksCount   = attribute.Key("sfu.tracks.count")
+)
+
+// newTracer returns the tracer of the SFU, the global tracer provider is used if the provider is not set
+func newTracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+
+	return provider.Tracer(tracerName)
+}
+
+// startSpan starts a client span. When the context doesn't carry a span from the caller,
+// the span is parented to the client join span so all signaling of a client is in the same trace.
+func (c *Client) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = trace.ContextWithSpanContext(ctx, c.joinSpan.SpanContext())
+	}
+
+	attrs = append(attrs, attrClientID.String(c.id))
+
+	return c.SFU().tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span and records the error if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// iceUfrag returns the first ice-ufrag value of the SDP, the value is changed when the remote peer restarts ICE
+func iceUfrag(sdp string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		if ufrag, ok := strings.CutPrefix(strings.TrimSpace(line), "a=ice-ufrag:"); ok {
+			return ufrag
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,94 @@
+package sfu
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestICEUfrag(t *testing.T) {
+	require.Equal(t, "abcd", iceUfrag("v=0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=ice-ufrag:abcd\r\na=ice-pwd:secret\r\n"))
+	require.Equal(t, "", iceUfrag("v=0\r\n"))
+}
+
+func TestClientTracing(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	defer func() {
+		_ = provider.Shutdown(context.Background())
+	}()
+
+	opts := sfuOpts
+	opts.TracerProvider = provider
+
+	roomManager := NewManager(ctx, "test", opts)
+	defer roomManager.Close()
+
+	roomID := roomManager.CreateRoomID()
+
+	testRoom, err := roomManager.NewRoom(roomID, "test-tracing-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	_, client1, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer1", true, false, true)
+	_, client2, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "peer2", true, false, true)
+
+	spans := func(name string) []sdktrace.ReadOnlySpan {
+		found := make([]sdktrace.ReadOnlySpan, 0)
+		for _, span := range recorder.Ended() {
+			if span.Name() == name {
+				found = append(found, span)
+			}
+		}
+
+		return found
+	}
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	// both clients are joined and subscribed to the tracks of each other through renegotiation
+	for len(spans("sfu.client.join")) < 2 || len(spans("sfu.client.renegotiate")) < 2 || len(spans("sfu.client.subscribe_tracks")) < 2 {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the client spans")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	for _, span := range spans("sfu.client.join") {
+		attrs := make(map[string]string)
+		for _, attr := range span.Attributes() {
+			attrs[string(attr.Key)] = attr.Value.Emit()
+		}
+
+		require.Equal(t, roomID, attrs[string(attrRoomID)])
+		require.Contains(t, []string{client1.ID(), client2.ID()}, attrs[string(attrClientID)])
+	}
+
+	// the negotiation spans are in the same trace as the client join span
+	negotiations := spans("sfu.client.negotiate")
+	require.GreaterOrEqual(t, len(negotiations), 2)
+
+	joinTraces := make(map[string]bool)
+	for _, span := range spans("sfu.client.join") {
+		joinTraces[span.SpanContext().TraceID().String()] = true
+	}
+
+	for _, span := range negotiations {
+		require.True(t, joinTraces[span.SpanContext().TraceID().String()])
+	}
+
+	_ = testRoom.StopClient(client1.ID())
+	_ = testRoom.StopClient(client2.ID())
+}
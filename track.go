@@ -3,11 +3,17 @@ package sfu
 import (
 	"context"
 	"errors"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/inlivedev/sfu/pkg/dependencydescriptor"
+	"github.com/inlivedev/sfu/pkg/framemarking"
+	"github.com/inlivedev/sfu/pkg/interceptors/avsync"
 	"github.com/inlivedev/sfu/pkg/interceptors/voiceactivedetector"
+	"github.com/inlivedev/sfu/pkg/logger"
 	"github.com/inlivedev/sfu/pkg/networkmonitor"
 	"github.com/inlivedev/sfu/pkg/rtppool"
 	"github.com/pion/interceptor"
@@ -20,6 +26,8 @@ import (
 const (
 	TrackTypeMedia  = "media"
 	TrackTypeScreen = "screen"
+	// TrackTypeCamera is a media track that explicitly published from a camera, it's allowed by the roles that allow media
+	TrackTypeCamera = "camera"
 )
 
 var (
@@ -44,6 +52,43 @@ type baseTrack struct {
 	isScreen     *atomic.Bool // source of the track, can be media or screen
 	clientTracks *clientTrackList
 	pool         *rtppool.RTPPool
+	// negotiated header extension IDs, 0 if not negotiated
+	dependencyDescriptorExtID *atomic.Uint32
+	frameMarkingExtID         *atomic.Uint32
+	absCaptureTimeExtID       *atomic.Uint32
+	interceptors              *packetInterceptors
+	// the playout delay of the subscribers, nil uses the subscriber client options
+	playoutDelay atomic.Pointer[PlayoutDelay]
+	// the source type and label of the track, isScreen is kept for the hot path
+	source atomic.Pointer[TrackSource]
+	// pauses the video track while it has no subscriber, nil if it's disabled
+	autoPause *trackAutoPause
+	// the stats samples of the track, nil until it's sampled when RoomOptions.StatsHistory is set
+	statsHistory atomic.Pointer[statsHistory]
+	// the resumed client that took over the track, nil while the track is sent by the client that published it
+	resumedClient atomic.Pointer[Client]
+}
+
+// publisher returns the client that sends the track now, see Room.ResumeClient
+func (t *baseTrack) publisher() *Client {
+	if client := t.resumedClient.Load(); client != nil {
+		return client
+	}
+
+	return t.client
+}
+
+func (t *baseTrack) setHeaderExtensions(extensions []webrtc.RTPHeaderExtensionParameter) {
+	for _, ext := range extensions {
+		switch ext.URI {
+		case dependencydescriptor.URI:
+			t.dependencyDescriptorExtID.Store(uint32(ext.ID))
+		case framemarking.URI:
+			t.frameMarkingExtID.Store(uint32(ext.ID))
+		case avsync.AbsCaptureTimeURI:
+			t.absCaptureTimeExtID.Store(uint32(ext.ID))
+		}
+	}
 }
 
 type ITrack interface {
@@ -55,8 +100,13 @@ type ITrack interface {
 	IsProcessed() bool
 	SetSourceType(TrackType)
 	SourceType() TrackType
+	// SetSource sets the source type and the label of the track
+	SetSource(TrackSource)
+	// Source returns the source type and the label of the track
+	Source() TrackSource
 	SetAsProcessed()
-	OnRead(func(interceptor.Attributes, *rtp.Packet, QualityLevel))
+	// OnRead registers the callback that called on every packet of the track, it returns the func to unregister it
+	OnRead(func(interceptor.Attributes, *rtp.Packet, QualityLevel)) func()
 	IsScreen() bool
 	IsRelay() bool
 	Kind() webrtc.RTPCodecType
@@ -66,6 +116,19 @@ type ITrack interface {
 	Relay(func(webrtc.SSRC, interceptor.Attributes, *rtp.Packet))
 	PayloadType() webrtc.PayloadType
 	OnEnded(func())
+	// IsE2EE returns true if the payload is end-to-end encrypted by the publisher
+	IsE2EE() bool
+	// AddPacketInterceptor adds the interceptor to the end of the chain of the direction of the track
+	AddPacketInterceptor(PacketDirection, PacketInterceptor)
+	// SetPlayoutDelay sets the playout delay of the track to all subscribers, it overrides the room and the subscriber
+	// client options. Set nil to use the subscriber client options again.
+	SetPlayoutDelay(*PlayoutDelay)
+	// PlayoutDelay returns the playout delay that set with SetPlayoutDelay, nil if it's not set
+	PlayoutDelay() *PlayoutDelay
+	// ForwardTo forwards the packets of the track to a UDP address as RTP, see RTPForwarder
+	ForwardTo(addr string, opts RTPForwarderOptions) (*RTPForwarder, error)
+	// StatsHistory returns the stats samples of the track in the last window, see RoomOptions.StatsHistory
+	StatsHistory(window time.Duration) []TrackStatsSample
 }
 
 type Track struct {
@@ -74,13 +137,16 @@ type Track struct {
 	base             *baseTrack
 	remoteTrack      *remoteTrack
 	onEndedCallbacks []func()
-	onReadCallbacks  []func(interceptor.Attributes, *rtp.Packet, QualityLevel)
+	onReadCallbacks  []*readCallback
+	// the track outlives its remote track while it's held for the resumed client, see Room.ResumeClient
+	cancel context.CancelFunc
 }
 
 type AudioTrack struct {
 	*Track
 	vad          *voiceactivedetector.VoiceDetector
 	vadCallbacks []func([]voiceactivedetector.VoicePacketData)
+	dtxPackets   atomic.Uint64
 }
 
 func newTrack(ctx context.Context, client *Client, trackRemote IRemoteTrack, minWait, maxWait, pliInterval time.Duration, onPLI func(), stats stats.Getter, onStatsUpdated func(*stats.Stats)) ITrack {
@@ -96,35 +162,101 @@ func newTrack(ctx context.Context, client *Client, trackRemote IRemoteTrack, min
 		codec:        trackRemote.Codec(),
 		clientTracks: ctList,
 		pool:         pool,
+
+		dependencyDescriptorExtID: &atomic.Uint32{},
+		frameMarkingExtID:         &atomic.Uint32{},
+		absCaptureTimeExtID:       &atomic.Uint32{},
+		interceptors:              newPacketInterceptors(),
 	}
 
 	t := &Track{
 		mu:               sync.Mutex{},
 		base:             baseTrack,
-		onReadCallbacks:  make([]func(interceptor.Attributes, *rtp.Packet, QualityLevel), 0),
+		onReadCallbacks:  make([]*readCallback, 0),
 		onEndedCallbacks: make([]func(), 0),
 	}
 
-	onRead := func(attrs interceptor.Attributes, p *rtp.Packet) {
-		tracks := t.base.clientTracks.GetTracks()
+	captureClock := client.captureClock(trackRemote)
 
-		for _, track := range tracks {
-			//nolint:ineffassign,staticcheck // packet is from the pool
-			packet := pool.NewPacket(&p.Header, p.Payload, attrs)
+	onNetworkConditionChanged := func(condition networkmonitor.NetworkConditionType) {
+		client.onNetworkConditionChanged(condition)
+	}
+
+	t.remoteTrack = newRemoteTrack(ctx, logger.With(client.log, "track_id", trackRemote.ID()), client.options.ReorderPackets, trackRemote, minWait, maxWait, pliInterval, onPLI, stats, onStatsUpdated, t.forwarder(client, captureClock), pool, onNetworkConditionChanged, client.fanOutScheduler(), nil)
+	t.remoteTrack.captureClock = captureClock
+
+	// the track is not ended with the client context, it's held if the client can be resumed
+	t.context, t.cancel = context.WithCancel(context.WithoutCancel(client.Context()))
+
+	if client.options.PauseUnsubscribedVideo && trackRemote.Kind() == webrtc.RTPCodecTypeVideo {
+		t.enableAutoPause()
+	}
+
+	if trackRemote.Kind() == webrtc.RTPCodecTypeVideo {
+		if interceptor := client.maxResolutionInterceptor(); interceptor != nil {
+			t.AddPacketInterceptor(PacketIngress, interceptor)
+		}
+	}
+
+	if interceptor := client.dimensionsInterceptor(trackRemote.Kind()); interceptor != nil {
+		t.AddPacketInterceptor(PacketIngress, interceptor)
+	}
+
+	var track ITrack = t
+
+	if trackRemote.Kind() == webrtc.RTPCodecTypeAudio {
+		ta := &AudioTrack{
+			Track: t,
+		}
 
-			copyPacket := pool.GetPacket()
-			copyPacket.Header = *packet.Header()
-			copyPacket.Payload = packet.Payload()
+		// the encrypted payload of an end-to-end encrypted track can't be checked for DTX
+		if ta.isOpus() && !t.base.isE2EE() {
+			ta.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, _ QualityLevel) {
+				ta.countDTX(p)
+			})
+		}
+
+		track = ta
+	}
+
+	source := t.remoteTrack
+	source.OnEnded(func() {
+		t.sourceEnded(client, source, track)
+	})
+
+	return track
+}
+
+// forwarder returns the read callback of the remote track that the client sends, the packet is forwarded to the
+// subscribers and the OnRead callbacks
+func (t *Track) forwarder(client *Client, captureClock *avsync.CaptureClock) func(interceptor.Attributes, *rtp.Packet) {
+	pool := t.base.pool
 
-			track.push(copyPacket, QualityHigh)
+	return func(attrs interceptor.Attributes, p *rtp.Packet) {
+		captureClock.Update(&p.Header, uint8(t.base.absCaptureTimeExtID.Load()), time.Now())
 
-			pool.PutPacket(copyPacket)
+		if !t.base.intercept(PacketIngress, nil, QualityHigh, p) {
+			return
+		}
 
-			packet.Release()
+		if t.base.autoPause.isPaused() {
+			return
 		}
 
-		//nolint:ineffassign // this is required
+		tracks := t.base.clientTracks.GetTracks()
+		if client.isPublishMuted(t.base.id, t.base.kind) {
+			tracks = nil
+		}
+
+		// the packet is copied once and shared by all subscribers, it's released after the last subscriber wrote it
 		packet := pool.NewPacket(&p.Header, p.Payload, attrs)
+		if packet == nil {
+			return
+		}
+
+		for _, track := range tracks {
+			pushClientTrack(track, packet, QualityHigh)
+		}
 
 		copyPacket := pool.GetPacket()
 		copyPacket.Header = *packet.Header()
@@ -132,35 +264,28 @@ func newTrack(ctx context.Context, client *Client, trackRemote IRemoteTrack, min
 
 		t.onRead(attrs, copyPacket, QualityHigh)
 
+		// the payload is still used by the subscribers, it must not be cleared by the pool
+		copyPacket.Payload = nil
 		pool.PutPacket(copyPacket)
 
 		packet.Release()
 	}
+}
 
-	onNetworkConditionChanged := func(condition networkmonitor.NetworkConditionType) {
-		client.onNetworkConditionChanged(condition)
+// sourceEnded ends the track once its remote track is ended. The track is held instead if the transport of a client
+// that can be resumed is lost, and the remote track that's continued by the resumed client is ignored. The track is
+// the AudioTrack for the audio.
+func (t *Track) sourceEnded(client *Client, source *remoteTrack, track ITrack) {
+	if source.takenOver() || client.holdTrack(track) {
+		return
 	}
 
-	t.remoteTrack = newRemoteTrack(ctx, client.log, client.options.ReorderPackets, trackRemote, minWait, maxWait, pliInterval, onPLI, stats, onStatsUpdated, onRead, pool, onNetworkConditionChanged)
-
-	var cancel context.CancelFunc
-
-	t.context, cancel = context.WithCancel(client.Context())
-
-	t.remoteTrack.OnEnded(func() {
-		cancel()
-		t.onEnded()
-	})
-
-	if trackRemote.Kind() == webrtc.RTPCodecTypeAudio {
-		ta := &AudioTrack{
-			Track: t,
-		}
-
-		return ta
-	}
+	t.end()
+}
 
-	return t
+func (t *Track) end() {
+	t.cancel()
+	t.onEnded()
 }
 
 func (t *Track) ClientID() string {
@@ -180,10 +305,23 @@ func (t *Track) createLocalTrack() *webrtc.TrackLocalStaticRTP {
 	return track
 }
 
-func (t *Track) createOpusLocalTrack() *webrtc.TrackLocalStaticRTP {
+func (t *Track) createOpusLocalTrack(opts OpusOptions) *webrtc.TrackLocalStaticRTP {
 	c := t.remoteTrack.track.Codec().RTPCodecCapability
 	c.MimeType = webrtc.MimeTypeOpus
-	c.SDPFmtpLine = "minptime=10;useinbandfec=1"
+	c.SDPFmtpLine = opts.fmtp("minptime=10;useinbandfec=1")
+	track, newTrackErr := webrtc.NewTrackLocalStaticRTP(c, t.base.id, t.base.streamid)
+	if newTrackErr != nil {
+		panic(newTrackErr)
+	}
+
+	return track
+}
+
+// createRedLocalTrack creates the local track that sends the plain Opus from the publisher as RED to the subscriber
+func (t *Track) createRedLocalTrack() *webrtc.TrackLocalStaticRTP {
+	c := t.remoteTrack.track.Codec().RTPCodecCapability
+	c.MimeType = "audio/red"
+	c.SDPFmtpLine = "111/111"
 	track, newTrackErr := webrtc.NewTrackLocalStaticRTP(c, t.base.id, t.base.streamid)
 	if newTrackErr != nil {
 		panic(newTrackErr)
@@ -201,7 +339,7 @@ func (t *Track) StreamID() string {
 }
 
 func (t *Track) SSRC() webrtc.SSRC {
-	return t.remoteTrack.track.SSRC()
+	return t.remoteTrack.Track().SSRC()
 }
 
 func (t *AudioTrack) SetVAD(vad *voiceactivedetector.VoiceDetector) {
@@ -231,6 +369,34 @@ func (t *AudioTrack) OnVoiceDetected(callback func(pkts []voiceactivedetector.Vo
 	t.vadCallbacks = append(t.vadCallbacks, callback)
 }
 
+// isOpus returns true if the track is Opus or Opus RED
+func (t *AudioTrack) isOpus() bool {
+	return strings.EqualFold(t.MimeType(), webrtc.MimeTypeOpus) || strings.EqualFold(t.MimeType(), "audio/red")
+}
+
+// countDTX counts the Opus DTX packets, the primary encoding is checked if the track is RED
+func (t *AudioTrack) countDTX(p *rtp.Packet) {
+	payload := p.Payload
+	if p.PayloadType == 63 {
+		primary, err := extractPrimaryEncodingForRED(payload)
+		if err != nil {
+			return
+		}
+
+		payload = primary
+	}
+
+	if voiceactivedetector.IsOpusDTX(payload) {
+		t.dtxPackets.Add(1)
+	}
+}
+
+// DTXPackets returns the number of the received Opus DTX packets, the publisher sends them in the silent period. It's
+// always zero if the track is end-to-end encrypted.
+func (t *AudioTrack) DTXPackets() uint64 {
+	return t.dtxPackets.Load()
+}
+
 func (t *AudioTrack) subscribe(c *Client) iClientTrack {
 	var ct iClientTrack
 
@@ -239,8 +405,9 @@ func (t *AudioTrack) subscribe(c *Client) iClientTrack {
 	if t.PayloadType() == 63 {
 		t.base.client.log.Tracef("track: red enabled %v", c.receiveRED)
 
-		// TODO: detect if client supports RED and it's audio then send RED encoded packets
 		ct = newClientTrackRed(cta)
+	} else if c.receiveRED && c.options.AudioREDDistance > 0 && t.isOpus() {
+		ct = newClientTrackRedEncoder(cta, c.options.AudioREDDistance)
 	} else {
 		ct = cta
 	}
@@ -266,7 +433,12 @@ func (t *Track) IsSimulcast() bool {
 }
 
 func (t *Track) IsScaleable() bool {
-	return t.MimeType() == webrtc.MimeTypeVP9
+	return t.MimeType() == webrtc.MimeTypeVP9 || t.MimeType() == webrtc.MimeTypeAV1
+}
+
+// SetHeaderExtensions store the negotiated header extension IDs that needed to forward the track
+func (t *Track) SetHeaderExtensions(extensions []webrtc.RTPHeaderExtensionParameter) {
+	t.base.setHeaderExtensions(extensions)
 }
 
 func (t *Track) IsProcessed() bool {
@@ -303,8 +475,11 @@ func (t *Track) TotalTracks() int {
 func (t *Track) subscribe(c *Client) iClientTrack {
 	var ct iClientTrack
 
-	if t.MimeType() == webrtc.MimeTypeVP9 {
+	if t.MimeType() == webrtc.MimeTypeVP9 && !t.IsE2EE() {
 		ct = newScaleableClientTrack(c, t)
+	} else if t.MimeType() == webrtc.MimeTypeAV1 || t.MimeType() == webrtc.MimeTypeVP9 {
+		// the VP9 payload is encrypted on end-to-end encrypted track, the layers are selected from the dependency descriptor
+		ct = newScaleableAV1ClientTrack(c, t)
 	} else {
 		ct = newClientTrack(c, t, t.IsScreen(), nil)
 	}
@@ -319,15 +494,19 @@ func (t *Track) subscribe(c *Client) iClientTrack {
 }
 
 func (t *Track) SetSourceType(sourceType TrackType) {
-	t.base.isScreen.Store(sourceType == TrackTypeScreen)
+	t.base.setSourceType(sourceType)
 }
 
 func (t *Track) SourceType() TrackType {
-	if t.base.isScreen.Load() {
-		return TrackTypeScreen
-	}
+	return t.base.getSource().Type
+}
+
+func (t *Track) SetSource(source TrackSource) {
+	t.base.setSource(source)
+}
 
-	return TrackTypeMedia
+func (t *Track) Source() TrackSource {
+	return t.base.getSource()
 }
 
 func (t *Track) SetAsProcessed() {
@@ -337,29 +516,53 @@ func (t *Track) SetAsProcessed() {
 	t.base.isProcessed = true
 }
 
-func (t *Track) OnRead(callback func(interceptor.Attributes, *rtp.Packet, QualityLevel)) {
+// OnRead registers the callback that called on every packet of the track. The payload is shared with the
+// subscribers, copy it before modifying it. It returns the func to unregister the callback, the track is not auto
+// paused while it has a callback, so unregister it once the packets are not needed anymore.
+func (t *Track) OnRead(callback func(interceptor.Attributes, *rtp.Packet, QualityLevel)) func() {
+	entry := &readCallback{callback: callback}
+
 	t.mu.Lock()
-	defer t.mu.Unlock()
+	t.onReadCallbacks = append(t.onReadCallbacks, entry)
+	t.mu.Unlock()
 
-	t.onReadCallbacks = append(t.onReadCallbacks, callback)
+	// a paused track is resumed for the reader
+	t.base.autoPause.update()
+
+	return sync.OnceFunc(func() {
+		t.mu.Lock()
+		t.onReadCallbacks = removeReadCallback(t.onReadCallbacks, entry)
+		t.mu.Unlock()
+
+		t.base.autoPause.update()
+	})
 }
 
 func (t *Track) onRead(attrs interceptor.Attributes, p *rtp.Packet, quality QualityLevel) {
-	callbacks := make([]func(interceptor.Attributes, *rtp.Packet, QualityLevel), 0)
+	callbacks := make([]*readCallback, 0)
 
 	t.mu.Lock()
 	callbacks = append(callbacks, t.onReadCallbacks...)
 	t.mu.Unlock()
 
-	for _, callback := range callbacks {
-		copyPacket := t.base.pool.GetPacket()
-		copyPacket.Header = p.Header
-		copyPacket.Payload = p.Payload
-		callback(attrs, p, quality)
-		t.base.pool.PutPacket(copyPacket)
+	// the packet payload is shared, releasing a copy to the pool would blank it for the next callbacks
+	for _, entry := range callbacks {
+		entry.callback(attrs, p, quality)
 	}
 }
 
+// readCallback is a callback that registered with OnRead, the pointer identifies the callback to unregister it
+type readCallback struct {
+	callback func(interceptor.Attributes, *rtp.Packet, QualityLevel)
+}
+
+// removeReadCallback returns a new slice without the callback, the slice that copied by onRead is not modified
+func removeReadCallback(callbacks []*readCallback, entry *readCallback) []*readCallback {
+	return slices.DeleteFunc(slices.Clone(callbacks), func(c *readCallback) bool {
+		return c == entry
+	})
+}
+
 func (t *Track) Relay(f func(webrtc.SSRC, interceptor.Attributes, *rtp.Packet)) {
 	t.OnRead(func(attrs interceptor.Attributes, p *rtp.Packet, quality QualityLevel) {
 		f(t.SSRC(), attrs, p)
@@ -370,6 +573,22 @@ func (t *Track) PayloadType() webrtc.PayloadType {
 	return t.base.codec.PayloadType
 }
 
+func (t *Track) IsE2EE() bool {
+	return t.base.isE2EE()
+}
+
+func (t *Track) AddPacketInterceptor(direction PacketDirection, interceptor PacketInterceptor) {
+	t.base.interceptors.add(direction, interceptor)
+}
+
+func (t *Track) SetPlayoutDelay(delay *PlayoutDelay) {
+	t.base.setPlayoutDelay(delay)
+}
+
+func (t *Track) PlayoutDelay() *PlayoutDelay {
+	return t.base.getPlayoutDelay()
+}
+
 func (t *Track) IsRelay() bool {
 	return t.remoteTrack.IsRelay()
 }
@@ -395,20 +614,10 @@ type SimulcastTrack struct {
 	cancel                      context.CancelFunc
 	mu                          sync.RWMutex
 	base                        *baseTrack
-	baseTS                      uint32
 	onTrackCompleteCallbacks    []func()
 	remoteTrackHigh             *remoteTrack
-	remoteTrackHighBaseTS       uint32
-	highSequence                uint16
-	lastHighSequence            uint16
 	remoteTrackMid              *remoteTrack
-	remoteTrackMidBaseTS        uint32
-	midSequence                 uint16
-	lastMidSequence             uint16
 	remoteTrackLow              *remoteTrack
-	remoteTrackLowBaseTS        uint32
-	lowSequence                 uint16
-	lastLowSequence             uint16
 	lastReadHighTS              *atomic.Int64
 	lastReadMidTS               *atomic.Int64
 	lastReadLowTS               *atomic.Int64
@@ -416,11 +625,16 @@ type SimulcastTrack struct {
 	lastMidKeyframeTS           *atomic.Int64
 	lastLowKeyframeTS           *atomic.Int64
 	onAddedRemoteTrackCallbacks []func(*remoteTrack)
-	onReadCallbacks             []func(interceptor.Attributes, *rtp.Packet, QualityLevel)
+	onReadCallbacks             []*readCallback
 	pliInterval                 time.Duration
 	onNetworkConditionChanged   func(networkmonitor.NetworkConditionType)
 	reordered                   bool
 	onEndedCallbacks            []func()
+	onLayerInactiveCallbacks    []func(QualityLevel)
+	onLayerRecoveredCallbacks   []func(QualityLevel)
+	maxLayer                    atomic.Uint32
+	// the keyframe dimensions of each layer, used to select the layer that fits the rendered size of the subscriber
+	layerDimensions sync.Map
 }
 
 func newSimulcastTrack(client *Client, track IRemoteTrack, minWait, maxWait, pliInterval time.Duration, onPLI func(), stats stats.Getter, onStatsUpdated func(*stats.Stats)) ITrack {
@@ -437,6 +651,11 @@ func newSimulcastTrack(client *Client, track IRemoteTrack, minWait, maxWait, pli
 			codec:        track.Codec(),
 			clientTracks: newClientTrackList(),
 			pool:         rtppool.New(),
+
+			dependencyDescriptorExtID: &atomic.Uint32{},
+			frameMarkingExtID:         &atomic.Uint32{},
+			absCaptureTimeExtID:       &atomic.Uint32{},
+			interceptors:              newPacketInterceptors(),
 		},
 		lastReadHighTS:              &atomic.Int64{},
 		lastReadMidTS:               &atomic.Int64{},
@@ -446,22 +665,38 @@ func newSimulcastTrack(client *Client, track IRemoteTrack, minWait, maxWait, pli
 		lastLowKeyframeTS:           &atomic.Int64{},
 		onTrackCompleteCallbacks:    make([]func(), 0),
 		onAddedRemoteTrackCallbacks: make([]func(*remoteTrack), 0),
-		onReadCallbacks:             make([]func(interceptor.Attributes, *rtp.Packet, QualityLevel), 0),
+		onReadCallbacks:             make([]*readCallback, 0),
 		pliInterval:                 pliInterval,
+		reordered:                   client.options.ReorderPackets,
 		onNetworkConditionChanged: func(condition networkmonitor.NetworkConditionType) {
 			client.onNetworkConditionChanged(condition)
 		},
 		onEndedCallbacks: make([]func(), 0),
 	}
 
-	t.context, t.cancel = context.WithCancel(client.Context())
+	// the track is not ended with the client context, it's held if the client can be resumed
+	t.context, t.cancel = context.WithCancel(context.WithoutCancel(client.Context()))
 
-	rt := t.AddRemoteTrack(track, minWait, maxWait, stats, onStatsUpdated, onPLI)
+	client.resources.goroutine(t.loopLayerMonitor)
 
-	rt.OnEnded(func() {
-		t.cancel()
-		t.onEnded()
-	})
+	t.AddRemoteTrack(track, minWait, maxWait, stats, onStatsUpdated, onPLI)
+
+	if client.options.PauseUnsubscribedVideo {
+		t.enableAutoPause()
+	}
+
+	if interceptor := client.maxResolutionInterceptor(); interceptor != nil {
+		t.AddPacketInterceptor(PacketIngress, interceptor)
+	}
+
+	if interceptor := client.dimensionsInterceptor(webrtc.RTPCodecTypeVideo); interceptor != nil {
+		t.AddPacketInterceptor(PacketIngress, interceptor)
+	}
+
+	// the payload of the end-to-end encrypted layers can't be parsed, the viewport uses the pixels thresholds instead
+	if !t.base.isE2EE() {
+		t.AddPacketInterceptor(PacketIngress, t.layerDimensionsInterceptor)
+	}
 
 	return t
 }
@@ -483,9 +718,12 @@ func (t *SimulcastTrack) onRemoteTrackAdded(f func(*remoteTrack)) {
 
 func (t *SimulcastTrack) onRemoteTrackAddedCallbacks(track *remoteTrack) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
+	callbacks := make([]func(*remoteTrack), len(t.onAddedRemoteTrackCallbacks))
+	copy(callbacks, t.onAddedRemoteTrackCallbacks)
+	t.mu.Unlock()
 
-	for _, f := range t.onAddedRemoteTrackCallbacks {
+	// the callbacks are called without the lock, they may use the track
+	for _, f := range callbacks {
 		f(track)
 	}
 }
@@ -523,6 +761,11 @@ func (t *SimulcastTrack) IsScaleable() bool {
 	return false
 }
 
+// SetHeaderExtensions store the negotiated header extension IDs that needed to forward the temporal layers
+func (t *SimulcastTrack) SetHeaderExtensions(extensions []webrtc.RTPHeaderExtensionParameter) {
+	t.base.setHeaderExtensions(extensions)
+}
+
 func (t *SimulcastTrack) IsProcessed() bool {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -535,60 +778,55 @@ func (t *SimulcastTrack) Kind() webrtc.RTPCodecType {
 }
 
 func (t *SimulcastTrack) AddRemoteTrack(track IRemoteTrack, minWait, maxWait time.Duration, stats stats.Getter, onStatsUpdated func(*stats.Stats), onPLI func()) *remoteTrack {
+	return t.addRemoteTrack(t.base.client, track, minWait, maxWait, stats, onStatsUpdated, onPLI)
+}
+
+// addRemoteTrack adds the layer that the client sends, the layer of a held track is continued by the layer of the
+// resumed client, see Room.ResumeClient
+func (t *SimulcastTrack) addRemoteTrack(client *Client, track IRemoteTrack, minWait, maxWait time.Duration, stats stats.Getter, onStatsUpdated func(*stats.Stats), onPLI func()) *remoteTrack {
 	var remoteTrack *remoteTrack
 
 	quality := RIDToQuality(track.RID())
 
-	onRead := func(attrs interceptor.Attributes, p *rtp.Packet) {
-
-		// set the base timestamp for the track if it is not set yet
-		if t.baseTS == 0 {
-			t.baseTS = p.Timestamp
-		}
+	captureClock := client.captureClock(track)
 
-		if quality == QualityHigh && t.remoteTrackHighBaseTS == 0 {
-			t.remoteTrackHighBaseTS = p.Timestamp
-		} else if quality == QualityMid && t.remoteTrackMidBaseTS == 0 {
-			t.remoteTrackMidBaseTS = p.Timestamp
-		} else if quality == QualityLow && t.remoteTrackLowBaseTS == 0 {
-			t.remoteTrackLowBaseTS = p.Timestamp
-		}
+	onRead := func(attrs interceptor.Attributes, p *rtp.Packet) {
+		now := time.Now()
+		readTime := now.UnixNano()
 
-		readTime := time.Now().UnixNano()
+		captureClock.Update(&p.Header, uint8(t.base.absCaptureTimeExtID.Load()), now)
 
 		switch quality {
 		case QualityHigh:
 			t.lastReadHighTS.Store(readTime)
-			t.lastHighSequence = t.highSequence
-			t.highSequence = p.SequenceNumber
 		case QualityMid:
 			t.lastReadMidTS.Store(readTime)
-			t.lastMidSequence = t.midSequence
-			t.midSequence = p.SequenceNumber
 		case QualityLow:
 			t.lastReadLowTS.Store(readTime)
-			t.lastLowSequence = t.lowSequence
-			t.lowSequence = p.SequenceNumber
 		}
 
-		tracks := t.base.clientTracks.GetTracks()
-		for _, track := range tracks {
-			//nolint:ineffassign,staticcheck // packet is from the pool
-			packet := t.base.pool.NewPacket(&p.Header, p.Payload, attrs)
-
-			copyPacket := t.base.pool.GetPacket()
-			copyPacket.Header = *packet.Header()
-			copyPacket.Payload = packet.Payload()
-
-			track.push(copyPacket, quality)
+		if !t.base.intercept(PacketIngress, nil, quality, p) {
+			return
+		}
 
-			t.base.pool.PutPacket(copyPacket)
+		if t.base.autoPause.isPaused() {
+			return
+		}
 
-			packet.Release()
+		tracks := t.base.clientTracks.GetTracks()
+		if client.isPublishMuted(t.base.id, t.base.kind) {
+			tracks = nil
 		}
 
-		//nolint:ineffassign // this is required
+		// the packet is copied once and shared by all subscribers, it's released after the last subscriber wrote it
 		packet := t.base.pool.NewPacket(&p.Header, p.Payload, attrs)
+		if packet == nil {
+			return
+		}
+
+		for _, track := range tracks {
+			pushClientTrack(track, packet, quality)
+		}
 
 		copyPacket := t.base.pool.GetPacket()
 		copyPacket.Header = *packet.Header()
@@ -596,51 +834,43 @@ func (t *SimulcastTrack) AddRemoteTrack(track IRemoteTrack, minWait, maxWait tim
 
 		t.onRead(attrs, copyPacket, quality)
 
+		// the payload is still used by the subscribers, it must not be cleared by the pool
+		copyPacket.Payload = nil
 		t.base.pool.PutPacket(copyPacket)
 
 		packet.Release()
 
 	}
 
-	remoteTrack = newRemoteTrack(t.Context(), t.base.client.log, t.reordered, track, minWait, maxWait, t.pliInterval, onPLI, stats, onStatsUpdated, onRead, t.base.pool, t.onNetworkConditionChanged)
+	if quality != QualityHigh && quality != QualityMid && quality != QualityLow {
+		client.log.Warnf("client: unknown track quality ", track.RID())
+		return nil
+	}
+
+	// the layer that's added again replaces the layer of the held track, the packets continue after its last packet
+	previous := t.GetRemoteTrack(quality)
+
+	// the layer ends with the client that sends it, the track is held if the client can be resumed
+	ctx, cancel := context.WithCancel(t.Context())
+	context.AfterFunc(client.Context(), cancel)
 
+	remoteTrack = newRemoteTrack(ctx, logger.With(client.log, "track_id", track.ID(), "rid", track.RID()), t.reordered, track, minWait, maxWait, t.pliInterval, onPLI, stats, onStatsUpdated, onRead, t.base.pool, t.onNetworkConditionChanged, client.fanOutScheduler(), previous)
+	remoteTrack.captureClock = captureClock
+
+	t.mu.Lock()
 	switch quality {
 	case QualityHigh:
-		t.mu.Lock()
 		t.remoteTrackHigh = remoteTrack
-		t.mu.Unlock()
-
-		remoteTrack.OnEnded(func() {
-			t.remoteTrackHigh = nil
-			t.cancel()
-			t.onEnded()
-		})
-
 	case QualityMid:
-		t.mu.Lock()
 		t.remoteTrackMid = remoteTrack
-		t.mu.Unlock()
-
-		remoteTrack.OnEnded(func() {
-			t.remoteTrackMid = nil
-			t.cancel()
-			t.onEnded()
-		})
-
 	case QualityLow:
-		t.mu.Lock()
 		t.remoteTrackLow = remoteTrack
-		t.mu.Unlock()
-
-		remoteTrack.OnEnded(func() {
-			t.remoteTrackLow = nil
-			t.cancel()
-			t.onEnded()
-		})
-	default:
-		t.base.client.log.Warnf("client: unknown track quality ", track.RID())
-		return nil
 	}
+	t.mu.Unlock()
+
+	remoteTrack.OnEnded(func() {
+		t.layerEnded(client, remoteTrack, quality)
+	})
 
 	// check if all simulcast tracks are available
 	if t.remoteTrackHigh != nil && t.remoteTrackMid != nil && t.remoteTrackLow != nil {
@@ -649,9 +879,39 @@ func (t *SimulcastTrack) AddRemoteTrack(track IRemoteTrack, minWait, maxWait tim
 
 	t.onRemoteTrackAddedCallbacks(remoteTrack)
 
+	// the new layer may be the first layer that the subscribers start from, one request for all subscribers
+	if t.base.clientTracks.Length() > 0 {
+		remoteTrack.SendPLI()
+	}
+
 	return remoteTrack
 }
 
+// layerEnded ends the track once a layer is ended, see Track.sourceEnded
+func (t *SimulcastTrack) layerEnded(client *Client, layer *remoteTrack, quality QualityLevel) {
+	if layer.takenOver() || client.holdTrack(t) {
+		return
+	}
+
+	t.mu.Lock()
+	switch quality {
+	case QualityHigh:
+		t.remoteTrackHigh = nil
+	case QualityMid:
+		t.remoteTrackMid = nil
+	case QualityLow:
+		t.remoteTrackLow = nil
+	}
+	t.mu.Unlock()
+
+	t.end()
+}
+
+func (t *SimulcastTrack) end() {
+	t.cancel()
+	t.onEnded()
+}
+
 func (t *SimulcastTrack) GetRemoteTrack(q QualityLevel) *remoteTrack {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -679,15 +939,19 @@ func (t *SimulcastTrack) subscribe(client *Client) iClientTrack {
 }
 
 func (t *SimulcastTrack) SetSourceType(sourceType TrackType) {
-	t.base.isScreen.Store(sourceType == TrackTypeScreen)
+	t.base.setSourceType(sourceType)
 }
 
 func (t *SimulcastTrack) SourceType() TrackType {
-	if t.base.isScreen.Load() {
-		return TrackTypeScreen
-	}
+	return t.base.getSource().Type
+}
+
+func (t *SimulcastTrack) SetSource(source TrackSource) {
+	t.base.setSource(source)
+}
 
-	return TrackTypeMedia
+func (t *SimulcastTrack) Source() TrackSource {
+	return t.base.getSource()
 }
 
 func (t *SimulcastTrack) SetAsProcessed() {
@@ -725,58 +989,22 @@ func (t *SimulcastTrack) TotalTracks() int {
 	return total
 }
 
-// track is considered active if the track is not nil and the latest read operation was 500ms ago
+// track is considered active if the track is not nil and the latest read operation was 500ms ago.
+// The inactive layer is logged by the layer monitor, this is called on every packet so it must not log.
 func (t *SimulcastTrack) isTrackActive(quality QualityLevel) bool {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-
-	// set max active track threshold to 500ms
-	threshold := time.Duration(500) * time.Millisecond
-
-	switch quality {
-	case QualityHigh:
-		if t.remoteTrackHigh == nil {
-			t.base.client.log.Warnf("track: remote track high is nil")
-			return false
-		}
-
-		delta := time.Since(time.Unix(0, t.lastReadHighTS.Load()))
-
-		if delta > threshold {
-			t.base.client.log.Warnf("track: remote track %s high is not active, last read was %d ms ago", t.base.id, delta.Milliseconds())
-			return false
-		}
-
-		return true
-	case QualityMid:
-		if t.remoteTrackMid == nil {
-			t.base.client.log.Warnf("track: remote track medium is nil")
-			return false
-		}
-
-		delta := time.Since(time.Unix(0, t.lastReadMidTS.Load()))
-		if delta > threshold {
-			t.base.client.log.Warnf("track: remote track %s mid is not active, last read was %d ms ago", delta.Milliseconds())
-			return false
-		}
-
-		return true
-	case QualityLow:
-		if t.remoteTrackLow == nil {
-			t.base.client.log.Warnf("track: remote track low is nil")
-			return false
-		}
+	lastRead := t.lastRead(quality)
 
-		delta := time.Since(time.Unix(0, t.lastReadLowTS.Load()))
-		if delta > threshold {
-			t.base.client.log.Warnf("track: remote track %s low is not active, last read was %d ms ago", delta.Milliseconds())
-			return false
-		}
+	return !lastRead.IsZero() && time.Since(lastRead) <= simulcastLayerInactiveThreshold
+}
 
-		return true
+// sendPLIAt requests a keyframe on the layer, the publisher may only send the keyframe on the requested layer
+func (t *SimulcastTrack) sendPLIAt(quality QualityLevel) {
+	if remoteTrack := t.GetRemoteTrack(quality); remoteTrack != nil {
+		remoteTrack.SendPLI()
+		return
 	}
 
-	return false
+	t.sendPLI()
 }
 
 func (t *SimulcastTrack) sendPLI() {
@@ -786,19 +1014,19 @@ func (t *SimulcastTrack) sendPLI() {
 	if t.remoteTrackHigh != nil {
 		t.remoteTrackHigh.SendPLI()
 	} else {
-		t.base.client.log.Warnf("track: remote track high is nil")
+		t.base.client.hotPathLog.Warnf("track: remote track high is nil")
 	}
 
 	if t.remoteTrackMid != nil {
 		t.remoteTrackMid.SendPLI()
 	} else {
-		t.base.client.log.Warnf("track: remote track mid is nil")
+		t.base.client.hotPathLog.Warnf("track: remote track mid is nil")
 	}
 
 	if t.remoteTrackLow != nil {
 		t.remoteTrackLow.SendPLI()
 	} else {
-		t.base.client.log.Warnf("track: remote track low is nil")
+		t.base.client.hotPathLog.Warnf("track: remote track low is nil")
 	}
 }
 
@@ -806,16 +1034,35 @@ func (t *SimulcastTrack) MimeType() string {
 	return t.base.codec.MimeType
 }
 
-func (t *SimulcastTrack) OnRead(callback func(interceptor.Attributes, *rtp.Packet, QualityLevel)) {
+// OnRead registers the callback that called on every packet of the track. The payload is shared with the
+// subscribers, copy it before modifying it. It returns the func to unregister the callback, see Track.OnRead.
+func (t *SimulcastTrack) OnRead(callback func(interceptor.Attributes, *rtp.Packet, QualityLevel)) func() {
+	entry := &readCallback{callback: callback}
+
 	t.mu.Lock()
-	defer t.mu.Unlock()
+	t.onReadCallbacks = append(t.onReadCallbacks, entry)
+	t.mu.Unlock()
+
+	// a paused track is resumed for the reader
+	t.base.autoPause.update()
 
-	t.onReadCallbacks = append(t.onReadCallbacks, callback)
+	return sync.OnceFunc(func() {
+		t.mu.Lock()
+		t.onReadCallbacks = removeReadCallback(t.onReadCallbacks, entry)
+		t.mu.Unlock()
+
+		t.base.autoPause.update()
+	})
 }
 
 func (t *SimulcastTrack) onRead(attr interceptor.Attributes, p *rtp.Packet, quality QualityLevel) {
-	for _, callback := range t.onReadCallbacks {
-		callback(attr, p, quality)
+	// the callbacks are only appended or replaced by a copy, so the snapshot is not modified after the lock
+	t.mu.RLock()
+	callbacks := t.onReadCallbacks
+	t.mu.RUnlock()
+
+	for _, entry := range callbacks {
+		entry.callback(attr, p, quality)
 	}
 }
 
@@ -902,6 +1149,22 @@ func (t *SimulcastTrack) PayloadType() webrtc.PayloadType {
 	return t.base.codec.PayloadType
 }
 
+func (t *SimulcastTrack) IsE2EE() bool {
+	return t.base.isE2EE()
+}
+
+func (t *SimulcastTrack) AddPacketInterceptor(direction PacketDirection, interceptor PacketInterceptor) {
+	t.base.interceptors.add(direction, interceptor)
+}
+
+func (t *SimulcastTrack) SetPlayoutDelay(delay *PlayoutDelay) {
+	t.base.setPlayoutDelay(delay)
+}
+
+func (t *SimulcastTrack) PlayoutDelay() *PlayoutDelay {
+	return t.base.getPlayoutDelay()
+}
+
 func (t *SimulcastTrack) IsRelay() bool {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
@@ -933,6 +1196,12 @@ func (t *SimulcastTrack) onEnded() {
 type SubscribeTrackRequest struct {
 	ClientID string `json:"client_id"`
 	TrackID  string `json:"track_id"`
+	// MaxWidth and MaxHeight limit the video quality that sent to the client, both must be set to apply the limit
+	MaxWidth  uint32 `json:"max_width,omitempty"`
+	MaxHeight uint32 `json:"max_height,omitempty"`
+	// MimeType is the codec that the track is transcoded to if it's different with the published codec,
+	// the room transcoder must be set and the transcoded video is scaled to MaxWidth and MaxHeight if they're set
+	MimeType string `json:"mime_type,omitempty"`
 }
 
 type trackList struct {
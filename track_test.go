@@ -2,12 +2,20 @@ package sfu
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/inlivedev/sfu/pkg/rtppool"
 	"github.com/pion/interceptor"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
 )
 
 func TestVoiceActivityDetection(t *testing.T) {
@@ -149,3 +157,573 @@ func sendAudioPackets(ctx context.Context, track *webrtc.TrackLocalStaticRTP) {
 
 	}
 }
+
+func TestIsKeyframeExtension(t *testing.T) {
+	const (
+		ddExtID = 1
+		fmExtID = 2
+	)
+
+	packet := func(id uint8, ext []byte) *rtp.Packet {
+		p := &rtp.Packet{Header: rtp.Header{Version: 2}, Payload: []byte{0xff, 0xff}}
+		require.NoError(t, p.Header.SetExtension(id, ext))
+
+		return p
+	}
+
+	// start of frame and independent frame
+	require.True(t, isKeyframeExtension(packet(fmExtID, []byte{0xa0}), ddExtID, fmExtID))
+	// not the first packet of the frame
+	require.False(t, isKeyframeExtension(packet(fmExtID, []byte{0x20}), ddExtID, fmExtID))
+	// frame marking is not negotiated
+	require.False(t, isKeyframeExtension(packet(fmExtID, []byte{0xa0}), ddExtID, 0))
+	// the dependency descriptor without the attached structure is not a keyframe
+	require.False(t, isKeyframeExtension(packet(ddExtID, []byte{0xc0, 0x00, 0x01}), ddExtID, fmExtID))
+	// the encrypted payload is never parsed
+	require.False(t, isKeyframeExtension(&rtp.Packet{Payload: []byte{0x10, 0x00, 0x00, 0x9d, 0x01, 0x2a}}, ddExtID, fmExtID))
+}
+
+func TestSimulcastLayerFallback(t *testing.T) {
+	now := time.Now()
+	states := &simulcastLayerStates{}
+
+	reads := func(high, mid, low time.Duration) map[QualityLevel]time.Time {
+		return map[QualityLevel]time.Time{
+			QualityHigh: now.Add(-high),
+			QualityMid:  now.Add(-mid),
+			QualityLow:  now.Add(-low),
+		}
+	}
+
+	// the first update only learns the layer states
+	inactive, recovered := states.update(reads(0, 0, 0), now)
+	require.Empty(t, inactive)
+	require.Empty(t, recovered)
+
+	// the high layer is stopped
+	inactive, recovered = states.update(reads(time.Second, 0, 0), now)
+	require.Equal(t, []QualityLevel{QualityHigh}, inactive)
+	require.Empty(t, recovered)
+
+	// still stopped, nothing reported again
+	inactive, _ = states.update(reads(2*time.Second, 0, 0), now)
+	require.Empty(t, inactive)
+
+	inactive, recovered = states.update(reads(0, 0, 0), now)
+	require.Empty(t, inactive)
+	require.Equal(t, []QualityLevel{QualityHigh}, recovered)
+
+	// the layer that never received a packet is not reported
+	inactive, recovered = (&simulcastLayerStates{}).update(map[QualityLevel]time.Time{QualityHigh: now}, now)
+	require.Empty(t, inactive)
+	require.Empty(t, recovered)
+
+	active := func(layers ...QualityLevel) func(QualityLevel) bool {
+		return func(quality QualityLevel) bool {
+			return slices.Contains(layers, quality)
+		}
+	}
+
+	fallback, ok := nearestActiveLayer(QualityHigh, active(QualityMid, QualityLow))
+	require.True(t, ok)
+	require.Equal(t, QualityLevel(QualityMid), fallback)
+
+	fallback, _ = nearestActiveLayer(QualityHigh, active(QualityLow))
+	require.Equal(t, QualityLevel(QualityLow), fallback)
+
+	fallback, _ = nearestActiveLayer(QualityMid, active(QualityHigh, QualityLow))
+	require.Equal(t, QualityLevel(QualityLow), fallback)
+
+	fallback, _ = nearestActiveLayer(QualityLow, active(QualityHigh, QualityMid))
+	require.Equal(t, QualityLevel(QualityMid), fallback)
+
+	_, ok = nearestActiveLayer(QualityLow, active())
+	require.False(t, ok)
+}
+
+func TestRedEncoder(t *testing.T) {
+	encoder := newClientTrackRedEncoder(nil, 2)
+
+	// the first packet has no redundancy yet
+	payload := encoder.encode(960, []byte{0x01})
+	require.Equal(t, []byte{redPrimaryPayloadType, 0x01}, payload)
+
+	encoder.encode(1920, []byte{0x02, 0x02})
+	payload = encoder.encode(2880, []byte{0x03})
+
+	primary, err := extractPrimaryEncodingForRED(payload)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x03}, primary)
+
+	// two redundant blocks from the oldest one, with the timestamp offset and the block length
+	first := binary.BigEndian.Uint32(payload[0:])
+	require.Equal(t, uint32(1), first>>31)
+	require.Equal(t, uint32(redPrimaryPayloadType), first>>24&0x7f)
+	require.Equal(t, uint32(1920), first>>10&0x3fff)
+	require.Equal(t, uint32(1), first&0x3ff)
+
+	second := binary.BigEndian.Uint32(payload[4:])
+	require.Equal(t, uint32(960), second>>10&0x3fff)
+	require.Equal(t, uint32(2), second&0x3ff)
+
+	require.Equal(t, []byte{redPrimaryPayloadType, 0x01, 0x02, 0x02, 0x03}, payload[8:])
+
+	// the redundancy that is too old for the timestamp offset is skipped
+	payload = encoder.encode(2880+redMaxTimestampOffset+1, []byte{0x04})
+	require.Equal(t, []byte{redPrimaryPayloadType, 0x04}, payload)
+}
+
+func TestE2EERoom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.E2EE = true
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer testRoom.Close()
+
+	require.True(t, testRoom.IsE2EE())
+
+	client, err := testRoom.AddClient(testRoom.CreateClientID(), "client", DefaultClientOptions())
+	require.NoError(t, err)
+	require.True(t, client.IsE2EE())
+
+	_, err = testRoom.StartRecording(DefaultRecordingOptions())
+	require.ErrorIs(t, err, ErrE2EENotSupported)
+
+	require.NoError(t, testRoom.StopClient(client.ID()))
+}
+
+func TestPacketInterceptor(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-packet-interceptor-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	_, subscriber, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "subscriber", true, false, true)
+
+	ingest, err := testRoom.IngestRTP("pipeline", DefaultRTPIngestOptions())
+	require.NoError(t, err)
+
+	stream, err := ingest.addStream(nil, webrtc.MimeTypeOpus)
+	require.NoError(t, err)
+
+	// the room interceptor is called first and marks the packet
+	testRoom.AddPacketInterceptor(PacketIngress, func(info PacketInfo, p *rtp.Packet) bool {
+		if info.PublisherID == ingest.ClientID() && len(p.Payload) > 0 {
+			p.Payload[0] = 0xaa
+		}
+
+		return true
+	})
+
+	// the track interceptor drops the odd packets that marked by the room interceptor
+	stream.track.AddPacketInterceptor(PacketIngress, func(info PacketInfo, p *rtp.Packet) bool {
+		return p.Payload[0] == 0xaa && p.SequenceNumber%2 == 0
+	})
+
+	egress := make(chan PacketInfo, 1)
+	stream.track.AddPacketInterceptor(PacketEgress, func(info PacketInfo, p *rtp.Packet) bool {
+		select {
+		case egress <- info:
+		default:
+		}
+
+		return true
+	})
+
+	received := make(chan rtp.Packet, 10)
+	stream.track.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, _ QualityLevel) {
+		select {
+		case received <- *p.Clone():
+		default:
+		}
+	})
+
+	sendCtx, cancelSend := context.WithCancel(ctx)
+	defer cancelSend()
+
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+
+		for seq := uint16(0); ; seq++ {
+			ingest.writeRTP(stream, &rtp.Packet{
+				Header:  rtp.Header{Version: 2, PayloadType: 111, SequenceNumber: seq, Timestamp: uint32(seq) * 960, SSRC: 5678},
+				Payload: make([]byte, 80),
+			})
+
+			select {
+			case <-sendCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	for i := 0; i < 5; i++ {
+		select {
+		case p := <-received:
+			require.Equal(t, uint16(0), p.SequenceNumber%2)
+			require.Equal(t, byte(0xaa), p.Payload[0])
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for the intercepted packet")
+		}
+	}
+
+	select {
+	case info := <-egress:
+		require.Equal(t, PacketEgress, info.Direction)
+		require.Equal(t, stream.track.ID(), info.TrackID)
+		require.Equal(t, subscriber.ID(), info.Subscriber.ID())
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the egress packet")
+	}
+
+	cancelSend()
+
+	require.NoError(t, ingest.Close())
+
+	_ = testRoom.StopClient(subscriber.ID())
+}
+
+// testTranscoder forwards the source packets as the target codec without decoding
+type testTranscoder struct {
+	written   chan rtp.Packet
+	out       chan *rtp.Packet
+	keyframes atomic.Int32
+	closed    chan bool
+	closeOnce sync.Once
+}
+
+func (t *testTranscoder) WriteRTP(p *rtp.Packet) error {
+	select {
+	case t.written <- *p.Clone():
+	default:
+	}
+
+	select {
+	case t.out <- p.Clone():
+	default:
+	}
+
+	return nil
+}
+
+func (t *testTranscoder) ReadRTP() (*rtp.Packet, error) {
+	select {
+	case p := <-t.out:
+		return p, nil
+	case <-t.closed:
+		return nil, io.EOF
+	}
+}
+
+func (t *testTranscoder) ForceKeyframe() {
+	t.keyframes.Add(1)
+}
+
+func (t *testTranscoder) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+
+	return nil
+}
+
+func TestTranscoder(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-transcoder-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	ingest, err := testRoom.IngestRTP("vp8-camera", DefaultRTPIngestOptions())
+	require.NoError(t, err)
+
+	stream, err := ingest.addStream(nil, webrtc.MimeTypeVP8)
+	require.NoError(t, err)
+
+	source := stream.track
+	req := SubscribeTrackRequest{ClientID: source.ClientID(), TrackID: source.ID(), MimeType: webrtc.MimeTypeH264, MaxWidth: 640, MaxHeight: 360}
+
+	require.False(t, needTranscode(source, SubscribeTrackRequest{MimeType: "video/vp8"}))
+	require.True(t, needTranscode(source, req))
+
+	_, err = testRoom.sfu.transcoding.track(source, req)
+	require.ErrorIs(t, err, ErrTranscoderNotSet)
+
+	_, err = testRoom.sfu.transcoding.track(source, SubscribeTrackRequest{MimeType: webrtc.MimeTypeOpus})
+	require.ErrorIs(t, err, ErrTranscoderKind)
+
+	transcoder := &testTranscoder{
+		written: make(chan rtp.Packet, 10),
+		out:     make(chan *rtp.Packet, 10),
+		closed:  make(chan bool),
+	}
+
+	var transcoderOpts TranscoderOptions
+
+	testRoom.SetTranscoder(func(opts TranscoderOptions) (Transcoder, error) {
+		transcoderOpts = opts
+		return transcoder, nil
+	})
+
+	transcoded, err := testRoom.sfu.transcoding.track(source, req)
+	require.NoError(t, err)
+	require.Equal(t, source.ID(), transcoded.ID())
+	require.Equal(t, webrtc.MimeTypeH264, transcoded.MimeType())
+	require.Equal(t, webrtc.MimeTypeVP8, transcoderOpts.Source.MimeType)
+	require.Equal(t, uint32(640), transcoderOpts.Width)
+
+	// the subscribers that request the same codec and size share the transcoded track
+	shared, err := testRoom.sfu.transcoding.track(source, req)
+	require.NoError(t, err)
+	require.Equal(t, transcoded, shared)
+
+	received := make(chan rtp.Packet, 10)
+	transcoded.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, _ QualityLevel) {
+		select {
+		case received <- *p.Clone():
+		default:
+		}
+	})
+
+	// the delta frame before the first keyframe is not written to the transcoder
+	ingest.writeRTP(stream, &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 1, Timestamp: 3000, SSRC: 1234},
+		Payload: []byte{0x10, 0x01, 0x00, 0x00},
+	})
+
+	ingest.writeRTP(stream, &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 2, Timestamp: 6000, SSRC: 1234, Marker: true},
+		Payload: []byte{0x10, 0x00, 0x00, 0x00},
+	})
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelTimeout()
+
+	select {
+	case p := <-transcoder.written:
+		require.Equal(t, uint16(2), p.SequenceNumber)
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the source keyframe")
+	}
+
+	select {
+	case p := <-received:
+		require.Equal(t, uint32(6000), p.Timestamp)
+		require.Equal(t, uint8(transcoderOpts.Target.PayloadType), p.PayloadType)
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the transcoded packet")
+	}
+
+	// the keyframe request of the subscriber is sent to the encoder
+	transcoded.(*Track).remoteTrack.SendPLI()
+
+	require.Eventually(t, func() bool {
+		return transcoder.keyframes.Load() > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, ingest.Close())
+
+	select {
+	case <-transcoded.Context().Done():
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for the transcoded track ended")
+	}
+}
+
+func TestLayerSwitcher(t *testing.T) {
+	switcher := NewLayerSwitcher(90000)
+	now := time.Now()
+
+	_, started := switcher.Layer()
+	require.False(t, started)
+
+	packet := func(seq uint16, ts uint32) *rtp.Packet {
+		return &rtp.Packet{Header: rtp.Header{SequenceNumber: seq, Timestamp: ts}}
+	}
+
+	// the first layer is not rewritten
+	p := packet(65535, 3000)
+	switcher.rewrite(QualityHigh, p, now)
+	require.Equal(t, uint16(65535), p.SequenceNumber)
+	require.Equal(t, uint32(3000), p.Timestamp)
+
+	p = packet(0, 6000)
+	switcher.rewrite(QualityHigh, p, now.Add(33*time.Millisecond))
+	require.Equal(t, uint16(0), p.SequenceNumber)
+	require.Equal(t, uint32(6000), p.Timestamp)
+
+	// the reordered packet keeps its position
+	p = packet(65534, 0)
+	switcher.rewrite(QualityHigh, p, now.Add(34*time.Millisecond))
+	require.Equal(t, uint16(65534), p.SequenceNumber)
+
+	// the low layer continues after the last high layer packet, 3000 ticks for 33ms
+	p = packet(5000, 123456)
+	switcher.rewrite(QualityLow, p, now.Add(66*time.Millisecond))
+	require.Equal(t, uint16(1), p.SequenceNumber)
+	require.Equal(t, uint32(6000+2970), p.Timestamp)
+
+	layer, started := switcher.Layer()
+	require.True(t, started)
+	require.Equal(t, QualityLevel(QualityLow), layer)
+
+	p = packet(5001, 123456+3000)
+	switcher.rewrite(QualityLow, p, now.Add(99*time.Millisecond))
+	require.Equal(t, uint16(2), p.SequenceNumber)
+	require.Equal(t, uint32(6000+2970+3000), p.Timestamp)
+
+	// the switch right after the last packet still moves the timestamp forward
+	last := p.Timestamp
+	p = packet(10, 42)
+	switcher.rewrite(QualityMid, p, now.Add(99*time.Millisecond))
+	require.Equal(t, uint16(3), p.SequenceNumber)
+	require.Equal(t, last+1, p.Timestamp)
+}
+
+func TestRTPMunger(t *testing.T) {
+	munger := NewRTPMunger(90000)
+	now := time.Now()
+
+	_, _, ok := munger.InsertPadding()
+	require.False(t, ok)
+
+	rewrite := func(seq uint16, ts uint32, at time.Duration) (uint16, uint32, bool) {
+		p := &rtp.Packet{Header: rtp.Header{SequenceNumber: seq, Timestamp: ts}}
+		ok := munger.rewrite(p, now.Add(at))
+
+		return p.SequenceNumber, p.Timestamp, ok
+	}
+
+	seq, ts, ok := rewrite(65534, 4294967000, 0)
+	require.True(t, ok)
+	require.Equal(t, uint16(65534), seq)
+	require.Equal(t, uint32(4294967000), ts)
+
+	seq, _, _ = rewrite(65535, 4294967000, 0)
+	require.Equal(t, uint16(65535), seq)
+
+	// the dropped packet after the wraparound doesn't leave a gap
+	require.True(t, munger.Drop(&rtp.Packet{Header: rtp.Header{SequenceNumber: 0}}))
+
+	seq, ts, _ = rewrite(1, 200, 33*time.Millisecond)
+	require.Equal(t, uint16(0), seq)
+	require.Equal(t, uint32(200), ts)
+
+	seq, _, _ = rewrite(2, 200, 33*time.Millisecond)
+	require.Equal(t, uint16(1), seq)
+
+	// the padding takes the next sequence number, the next packets are shifted
+	seq, ts, ok = munger.InsertPadding()
+	require.True(t, ok)
+	require.Equal(t, uint16(2), seq)
+	require.Equal(t, uint32(200), ts)
+
+	seq, _, _ = rewrite(3, 3200, 66*time.Millisecond)
+	require.Equal(t, uint16(3), seq)
+
+	// the lost packets are not compacted, so the receiver can NACK them
+	seq, _, _ = rewrite(6, 6200, 99*time.Millisecond)
+	require.Equal(t, uint16(6), seq)
+
+	seq, _, ok = rewrite(4, 3200, 100*time.Millisecond)
+	require.True(t, ok)
+	require.Equal(t, uint16(4), seq)
+
+	// the out of order packet can't be dropped
+	require.False(t, munger.Drop(&rtp.Packet{Header: rtp.Header{SequenceNumber: 5}}))
+
+	// the new source continues after the last packet, 33ms is 2970 ticks
+	munger.Switch()
+
+	seq, ts, _ = rewrite(30000, 5, 132*time.Millisecond)
+	require.Equal(t, uint16(7), seq)
+	require.Equal(t, uint32(6200+2970), ts)
+
+	// the packets of the new source before the switch are not forwarded
+	_, _, ok = rewrite(29999, 1, 133*time.Millisecond)
+	require.False(t, ok)
+
+	seq, ts, _ = rewrite(30001, 3005, 165*time.Millisecond)
+	require.Equal(t, uint16(8), seq)
+	require.Equal(t, uint32(6200+2970+3000), ts)
+}
+
+func TestTrackOnReadCallbacksPayload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	track := newTestForwardedTrack(ctx)
+	track.base.pool = rtppool.New()
+
+	payloads := make([][]byte, 0)
+
+	for i := 0; i < 3; i++ {
+		track.OnRead(func(_ interceptor.Attributes, p *rtp.Packet, _ QualityLevel) {
+			payloads = append(payloads, append([]byte(nil), p.Payload...))
+		})
+	}
+
+	track.onRead(nil, &rtp.Packet{Header: rtp.Header{SequenceNumber: 1}, Payload: []byte{0x01, 0x02, 0x03}}, QualityHigh)
+
+	// every callback receives the same payload
+	require.Len(t, payloads, 3)
+
+	for _, payload := range payloads {
+		require.Equal(t, []byte{0x01, 0x02, 0x03}, payload)
+	}
+}
+
+func TestTrackOnReadUnregister(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	track := newTestForwardedTrack(ctx)
+
+	first, second := 0, 0
+
+	unregister := track.OnRead(func(_ interceptor.Attributes, _ *rtp.Packet, _ QualityLevel) {
+		first++
+	})
+
+	track.OnRead(func(_ interceptor.Attributes, _ *rtp.Packet, _ QualityLevel) {
+		second++
+	})
+
+	track.onRead(nil, &rtp.Packet{Header: rtp.Header{SequenceNumber: 1}}, QualityHigh)
+
+	// the unregistered callback is not called anymore, unregistering it again is a no-op
+	unregister()
+	unregister()
+
+	track.onRead(nil, &rtp.Packet{Header: rtp.Header{SequenceNumber: 2}}, QualityHigh)
+
+	require.Equal(t, 1, first)
+	require.Equal(t, 2, second)
+	require.Len(t, track.onReadCallbacks, 1)
+}
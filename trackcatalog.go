@@ -0,0 +1,192 @@
+package sfu
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// TrackDescriptor describes a published track in the track catalog
+type TrackDescriptor struct {
+	ID        string    `json:"id"`
+	StreamID  string    `json:"stream_id"`
+	ClientID  string    `json:"client_id"`
+	Kind      string    `json:"kind" enums:"audio,video"`
+	MimeType  string    `json:"mime_type"`
+	Source    TrackType `json:"source" enums:"media,camera,screen"`
+	Label     string    `json:"label,omitempty"`
+	Simulcast bool      `json:"simulcast"`
+	// SVC is true if the track is VP9 or AV1 that the SFU can forward with the spatial and temporal layers
+	SVC bool `json:"svc"`
+	// Width and Height are the dimensions of the latest keyframe of the highest layer, zero until a VP8 or VP9 keyframe is received
+	Width             uint32          `json:"width,omitempty"`
+	Height            uint32          `json:"height,omitempty"`
+	PublisherName     string          `json:"publisher_name"`
+	PublisherMetadata json.RawMessage `json:"publisher_metadata,omitempty"`
+}
+
+// TrackCatalog is the list of the tracks that published in the room, the version is increased on every change
+type TrackCatalog struct {
+	Version uint64            `json:"version"`
+	Tracks  []TrackDescriptor `json:"tracks"`
+}
+
+type internalDataTrackCatalog struct {
+	Type string       `json:"type"`
+	Data TrackCatalog `json:"data"`
+}
+
+// videoDimensions is the size of the latest keyframe of the highest layer that received from a published video track
+type videoDimensions struct {
+	Quality QualityLevel
+	Width   uint32
+	Height  uint32
+}
+
+// TrackCatalog returns the tracks that available to subscribe in the room, the tracks that still wait for the source
+// type are not included
+func (s *SFU) TrackCatalog() TrackCatalog {
+	catalog := TrackCatalog{
+		Version: s.catalogVersion.Load(),
+		Tracks:  make([]TrackDescriptor, 0),
+	}
+
+	for _, client := range s.clients.GetClients() {
+		if client.IsInLobby() {
+			continue
+		}
+
+		pending := make(map[string]bool)
+		for _, track := range client.pendingPublishedTracks.GetTracks() {
+			pending[track.ID()] = true
+		}
+
+		metadata, _ := client.Metadata()
+
+		for _, track := range client.tracks.GetTracks() {
+			if pending[track.ID()] {
+				continue
+			}
+
+			descriptor := TrackDescriptor{
+				ID:                track.ID(),
+				StreamID:          track.StreamID(),
+				ClientID:          client.ID(),
+				Kind:              track.Kind().String(),
+				MimeType:          track.MimeType(),
+				Source:            track.SourceType(),
+				Label:             track.Source().Label,
+				Simulcast:         track.IsSimulcast(),
+				SVC:               track.IsScaleable(),
+				PublisherName:     client.Name(),
+				PublisherMetadata: metadata,
+			}
+
+			if value, ok := client.trackDimensions.Load(track.ID()); ok {
+				dimensions := value.(videoDimensions)
+				descriptor.Width = dimensions.Width
+				descriptor.Height = dimensions.Height
+			}
+
+			catalog.Tracks = append(catalog.Tracks, descriptor)
+		}
+	}
+
+	sort.Slice(catalog.Tracks, func(i, j int) bool {
+		if catalog.Tracks[i].ClientID != catalog.Tracks[j].ClientID {
+			return catalog.Tracks[i].ClientID < catalog.Tracks[j].ClientID
+		}
+
+		return catalog.Tracks[i].ID < catalog.Tracks[j].ID
+	})
+
+	return catalog
+}
+
+// onTrackCatalogChanged increases the catalog version and sends the catalog to all clients, see RoomOptions.TrackCatalog
+func (s *SFU) onTrackCatalogChanged() {
+	if !s.trackCatalog {
+		return
+	}
+
+	// the catalogs are sent in the version order
+	s.catalogMu.Lock()
+	defer s.catalogMu.Unlock()
+
+	s.catalogVersion.Add(1)
+
+	data, err := s.marshalTrackCatalog()
+	if err != nil {
+		s.log.Errorf("sfu: error marshal track catalog ", err)
+		return
+	}
+
+	for _, client := range s.clients.GetClients() {
+		if !client.IsInLobby() {
+			client.sendInternalMessage(data)
+		}
+	}
+}
+
+// sendTrackCatalog sends the current catalog to a client that just opened the internal data channel or admitted from the lobby
+func (s *SFU) sendTrackCatalog(c *Client) {
+	if !s.trackCatalog || c.IsInLobby() {
+		return
+	}
+
+	s.catalogMu.Lock()
+	defer s.catalogMu.Unlock()
+
+	data, err := s.marshalTrackCatalog()
+	if err != nil {
+		s.log.Errorf("sfu: error marshal track catalog ", err)
+		return
+	}
+
+	c.sendInternalMessage(data)
+}
+
+func (s *SFU) marshalTrackCatalog() ([]byte, error) {
+	return json.Marshal(internalDataTrackCatalog{
+		Type: messageTypeTrackCatalog,
+		Data: s.TrackCatalog(),
+	})
+}
+
+// dimensionsInterceptor returns the ingress interceptor that keeps the keyframe dimensions of the highest layer of
+// the video track for the track catalog, nil if the catalog is disabled. The payload of the end-to-end encrypted tracks
+// can't be parsed, so their dimensions are not known.
+func (c *Client) dimensionsInterceptor(kind webrtc.RTPCodecType) PacketInterceptor {
+	if c.SFU() == nil || !c.SFU().trackCatalog || kind != webrtc.RTPCodecTypeVideo || c.IsE2EE() {
+		return nil
+	}
+
+	return func(info PacketInfo, p *rtp.Packet) bool {
+		if !IsKeyframe(info.MimeType, p.Payload) {
+			return true
+		}
+
+		width, height := KeyframeDimensions(info.MimeType, p.Payload)
+		if width == 0 || height == 0 {
+			return true
+		}
+
+		current := videoDimensions{}
+		if value, ok := c.trackDimensions.Load(info.TrackID); ok {
+			current = value.(videoDimensions)
+		}
+
+		// a lower layer doesn't override the dimensions of the higher layer
+		if info.Quality < current.Quality || (current.Width == width && current.Height == height) {
+			return true
+		}
+
+		c.trackDimensions.Store(info.TrackID, videoDimensions{Quality: info.Quality, Width: width, Height: height})
+
+		go c.SFU().onTrackCatalogChanged()
+
+		return true
+	}
+}
@@ -0,0 +1,54 @@
+package sfu
+
+// TrackSource describes where a published track comes from, a client can publish multiple screen tracks and tell them
+// apart with the label, for example "slides" and "terminal"
+type TrackSource struct {
+	Type  TrackType `json:"type" enums:"media,camera,screen" example:"screen"`
+	Label string    `json:"label,omitempty" example:"slides"`
+}
+
+// trackSource is a track source of the track_sources message that sent by the client after publishing the tracks
+type trackSource struct {
+	TrackID string    `json:"track_id"`
+	Type    TrackType `json:"type"`
+	Label   string    `json:"label,omitempty"`
+}
+
+type internalDataTrackSources struct {
+	Type string        `json:"type"`
+	Data []trackSource `json:"data"`
+}
+
+func (t *baseTrack) setSource(source TrackSource) {
+	if source.Type == "" {
+		source.Type = TrackTypeMedia
+	}
+
+	t.source.Store(&source)
+	t.isScreen.Store(source.Type == TrackTypeScreen)
+}
+
+// setSourceType changes the source type and keeps the label
+func (t *baseTrack) setSourceType(sourceType TrackType) {
+	source := t.getSource()
+	source.Type = sourceType
+
+	t.setSource(source)
+}
+
+func (t *baseTrack) getSource() TrackSource {
+	if source := t.source.Load(); source != nil {
+		return *source
+	}
+
+	return TrackSource{Type: TrackTypeMedia}
+}
+
+func (c *Client) onTrackSourcesMessage(sources []trackSource) {
+	trackSources := make(map[string]TrackSource, len(sources))
+	for _, source := range sources {
+		trackSources[source.TrackID] = TrackSource{Type: source.Type, Label: source.Label}
+	}
+
+	c.SetTracksSource(trackSources)
+}
@@ -0,0 +1,253 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+var (
+	ErrTranscoderNotSet = errors.New("transcoder: transcoder is not set on the room")
+	ErrTranscoderCodec  = errors.New("transcoder: codec is not supported by the room")
+	ErrTranscoderKind   = errors.New("transcoder: codec kind is not the same with the track kind")
+)
+
+const transcoderChannelSize = 512
+
+// TranscoderOptions describes the output of the transcoder
+type TranscoderOptions struct {
+	// Source is the codec of the published track
+	Source webrtc.RTPCodecParameters
+	// Target is the codec that the subscriber receives
+	Target webrtc.RTPCodecParameters
+	// Width and Height of the output video, zero keeps the source size.
+	// They're from SubscribeTrackRequest.MaxWidth and MaxHeight.
+	Width  uint32
+	Height uint32
+}
+
+// Transcoder decodes the RTP packets of the published track and encodes them to the target codec, like an FFmpeg
+// or GStreamer pipeline. The SFU only handles the RTP packets, so the implementation is provided by the application.
+//
+// The first source packet that written is always a keyframe, and the writing is restarted from the next keyframe of
+// the publisher if WriteRTP returns an error, so the decoder can recover from the packet loss.
+type Transcoder interface {
+	// WriteRTP writes the RTP packet of the source codec, the packet must not be used after it returns
+	WriteRTP(p *rtp.Packet) error
+	// ReadRTP returns the next RTP packet of the target codec, it returns an error after the transcoder is closed.
+	// The SSRC and the payload type are set by the SFU.
+	ReadRTP() (*rtp.Packet, error)
+	// ForceKeyframe asks the encoder to encode the next frame as a keyframe, it's called when a subscriber
+	// requests a keyframe. It must not block.
+	ForceKeyframe()
+	Close() error
+}
+
+// TranscoderFactory creates a transcoder for a published track that subscribed with a different codec or size
+type TranscoderFactory func(opts TranscoderOptions) (Transcoder, error)
+
+// transcoding keeps the transcoded tracks of the room, the transcoded tracks are shared between the subscribers
+// that request the same codec and size of a track
+type transcoding struct {
+	mu      sync.Mutex
+	sfu     *SFU
+	factory TranscoderFactory
+	// the transcoded tracks are owned by the bridge client, it's created on the first transcoded track
+	client    *Client
+	pipelines map[string]*transcodePipeline
+}
+
+type transcodePipeline struct {
+	context      context.Context
+	cancel       context.CancelFunc
+	source       ITrack
+	transcoder   Transcoder
+	track        ITrack
+	rtpChan      chan *rtp.Packet
+	ssrc         uint32
+	payloadType  uint8
+	waitKeyframe atomic.Bool
+}
+
+func newTranscoding(sfu *SFU) *transcoding {
+	return &transcoding{
+		sfu:       sfu,
+		pipelines: make(map[string]*transcodePipeline),
+	}
+}
+
+// SetTranscoder sets the transcoder factory that used when a client subscribes a track with a different codec,
+// see SubscribeTrackRequest.MimeType. The existing transcoded tracks are not changed.
+func (r *Room) SetTranscoder(factory TranscoderFactory) {
+	r.sfu.transcoding.mu.Lock()
+	defer r.sfu.transcoding.mu.Unlock()
+
+	r.sfu.transcoding.factory = factory
+}
+
+// needTranscode returns true if the track is requested with a different codec
+func needTranscode(track ITrack, req SubscribeTrackRequest) bool {
+	return req.MimeType != "" && !strings.EqualFold(req.MimeType, track.MimeType())
+}
+
+// track returns the transcoded track of the source, the track is created if there is no transcoded track with the
+// same codec and size yet
+func (t *transcoding) track(source ITrack, req SubscribeTrackRequest) (ITrack, error) {
+	target := getRTPParameters(req.MimeType)
+	if target.MimeType == "" {
+		return nil, fmt.Errorf("%w: %s", ErrTranscoderCodec, req.MimeType)
+	}
+
+	if !strings.HasPrefix(strings.ToLower(target.MimeType), strings.ToLower(source.Kind().String())+"/") {
+		return nil, ErrTranscoderKind
+	}
+
+	opts := TranscoderOptions{
+		Source: getRTPParameters(source.MimeType()),
+		Target: target,
+	}
+
+	if source.Kind() == webrtc.RTPCodecTypeVideo {
+		opts.Width = req.MaxWidth
+		opts.Height = req.MaxHeight
+	}
+
+	key := fmt.Sprintf("%s:%s:%dx%d", source.ID(), target.MimeType, opts.Width, opts.Height)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if pipeline, ok := t.pipelines[key]; ok && pipeline.context.Err() == nil {
+		return pipeline.track, nil
+	}
+
+	if t.factory == nil {
+		return nil, ErrTranscoderNotSet
+	}
+
+	transcoder, err := t.factory(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.client == nil {
+		clientOpts := DefaultClientOptions()
+		clientOpts.Type = ClientTypeUpBridge
+
+		// the bridge client never connects, it's only the owner of the transcoded tracks
+		t.client = t.sfu.NewClient(GenerateID(21), "transcoder", clientOpts)
+
+		go func(client *Client) {
+			<-t.sfu.context.Done()
+			_ = client.stop()
+		}(t.client)
+	}
+
+	pipeline := t.newPipeline(source, transcoder, opts)
+	t.pipelines[key] = pipeline
+
+	go func() {
+		<-pipeline.context.Done()
+
+		t.mu.Lock()
+		if t.pipelines[key] == pipeline {
+			delete(t.pipelines, key)
+		}
+		t.mu.Unlock()
+	}()
+
+	return pipeline.track, nil
+}
+
+func (t *transcoding) newPipeline(source ITrack, transcoder Transcoder, opts TranscoderOptions) *transcodePipeline {
+	// the pipeline is ended with the source track
+	ctx, cancel := context.WithCancel(source.Context())
+
+	pipeline := &transcodePipeline{
+		context:     ctx,
+		cancel:      cancel,
+		source:      source,
+		transcoder:  transcoder,
+		rtpChan:     make(chan *rtp.Packet, transcoderChannelSize),
+		ssrc:        rand.Uint32(),
+		payloadType: uint8(opts.Target.PayloadType),
+	}
+
+	pipeline.waitKeyframe.Store(source.Kind() == webrtc.RTPCodecTypeVideo)
+
+	// the transcoded track has the same IDs with the source, so the subscriber sees it as the requested track
+	relayTrack := NewTrackRelay(source.ID(), source.StreamID(), "", source.Kind(), webrtc.SSRC(pipeline.ssrc), opts.Target.MimeType, pipeline.rtpChan)
+
+	requestPLI := func() {
+		t.sfu.requestPLI(pipeline.ssrc, transcoder.ForceKeyframe)
+	}
+
+	pipeline.track = newTrack(ctx, t.client, relayTrack, 0, 0, t.sfu.pliInterval, requestPLI, nil, nil)
+	pipeline.track.SetSource(source.Source())
+
+	source.OnRead(pipeline.write)
+
+	go pipeline.readLoop()
+
+	if pipeline.waitKeyframe.Load() {
+		requestKeyframe(source)
+	}
+
+	return pipeline
+}
+
+// write writes the source packet to the transcoder, only the high layer of a simulcast track is transcoded
+func (p *transcodePipeline) write(_ interceptor.Attributes, packet *rtp.Packet, quality QualityLevel) {
+	if p.context.Err() != nil || quality != QualityHigh {
+		return
+	}
+
+	if p.waitKeyframe.Load() {
+		if !IsKeyframe(p.source.MimeType(), packet.Payload) {
+			return
+		}
+
+		p.waitKeyframe.Store(false)
+	}
+
+	if err := p.transcoder.WriteRTP(packet); err != nil && p.source.Kind() == webrtc.RTPCodecTypeVideo {
+		// restart the decoder on the next keyframe
+		p.waitKeyframe.Store(true)
+		requestKeyframe(p.source)
+	}
+}
+
+// readLoop forwards the transcoded packets to the transcoded track until the transcoder or the source is ended
+func (p *transcodePipeline) readLoop() {
+	go func() {
+		<-p.context.Done()
+		_ = p.transcoder.Close()
+	}()
+
+	for {
+		packet, err := p.transcoder.ReadRTP()
+		if err != nil {
+			break
+		}
+
+		packet.SSRC = p.ssrc
+		packet.PayloadType = p.payloadType
+
+		select {
+		case p.rtpChan <- packet:
+		default:
+			// drop the packet if the track is not reading fast enough
+		}
+	}
+
+	p.cancel()
+	close(p.rtpChan)
+}
@@ -0,0 +1,95 @@
+package sfu
+
+import (
+	"github.com/pion/rtp"
+)
+
+// layerDimensionsInterceptor keeps the keyframe dimensions of each simulcast layer, the packets are never dropped
+func (t *SimulcastTrack) layerDimensionsInterceptor(info PacketInfo, p *rtp.Packet) bool {
+	if !IsKeyframe(info.MimeType, p.Payload) {
+		return true
+	}
+
+	width, height := KeyframeDimensions(info.MimeType, p.Payload)
+	if width == 0 || height == 0 {
+		return true
+	}
+
+	t.layerDimensions.Store(info.Quality, videoDimensions{Quality: info.Quality, Width: width, Height: height})
+
+	return true
+}
+
+// LayerDimensions returns the width and height of the latest keyframe of the simulcast layer, zero until a VP8 or VP9
+// keyframe of the layer is received, or if the track is end-to-end encrypted
+func (t *SimulcastTrack) LayerDimensions(quality QualityLevel) (uint32, uint32) {
+	value, ok := t.layerDimensions.Load(quality)
+	if !ok {
+		return 0, 0
+	}
+
+	dimensions := value.(videoDimensions)
+
+	return dimensions.Width, dimensions.Height
+}
+
+// viewportMaxQuality returns the lowest quality that covers the rendered size of the video. The simulcast layers are
+// compared by their keyframe dimensions when they're known, otherwise the pixels thresholds of the bitrate configs are used.
+func (bc *bitrateController) viewportMaxQuality(track iClientTrack, size videoSize) QualityLevel {
+	ratio := size.PixelRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+
+	pixels := uint64(float64(size.Width)*ratio) * uint64(float64(size.Height)*ratio)
+
+	if t, ok := track.(*simulcastClientTrack); ok {
+		known := false
+
+		for _, quality := range []QualityLevel{QualityLow, QualityMid, QualityHigh} {
+			width, height := t.remoteTrack.LayerDimensions(quality)
+			if width == 0 || height == 0 {
+				continue
+			}
+
+			known = true
+
+			if uint64(width)*uint64(height) >= pixels {
+				return quality
+			}
+		}
+
+		// no layer is big enough, the highest layer is the closest
+		if known {
+			return QualityHigh
+		}
+	}
+
+	if pixels < uint64(bc.client.SFU().bitrateConfigs.VideoLowPixels) {
+		return QualityLow
+	} else if pixels < uint64(bc.client.SFU().bitrateConfigs.VideoMidPixels) {
+		return QualityMid
+	}
+
+	return QualityHigh
+}
+
+// refreshViewports applies the rendered size again to the max quality of the tracks, the keyframe dimensions of the
+// simulcast layers are usually received after the size is reported, and they change when the publisher scales the video.
+// It also restores the size limit after the track quality is unpinned.
+func (bc *bitrateController) refreshViewports() {
+	for _, claim := range bc.Claims() {
+		claim.mu.RLock()
+		size := claim.viewport
+		claim.mu.RUnlock()
+
+		if size.Width == 0 || size.Height == 0 || claim.IsPinned() {
+			continue
+		}
+
+		if maxQuality := bc.viewportMaxQuality(claim.track, size); maxQuality != claim.track.MaxQuality() {
+			bc.log.Debugf("bitrate: track %s layer dimensions changed, set max quality to %d", claim.track.ID(), maxQuality)
+			claim.track.SetMaxQuality(maxQuality)
+		}
+	}
+}
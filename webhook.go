@@ -0,0 +1,247 @@
+package sfu
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+)
+
+const (
+	// WebhookSignatureHeader is the hex HMAC-SHA256 of the request body with the webhook secret, prefixed with sha256=
+	WebhookSignatureHeader = "X-SFU-Signature"
+	// WebhookEventIDHeader is the ID of the event, it's the same on the retries so the receiver can ignore the duplicates
+	WebhookEventIDHeader = "X-SFU-Event-ID"
+)
+
+var (
+	ErrWebhookSinkClosed = errors.New("webhook: sink is closed")
+	ErrWebhookQueueFull  = errors.New("webhook: queue is full")
+)
+
+type WebhookOptions struct {
+	// Secret is the key of the HMAC-SHA256 signature in the X-SFU-Signature header, the request is not signed if it's empty
+	Secret string
+	// MaxRetries is the number of the retries after the first attempt is failed, with the exponential backoff from RetryInterval
+	MaxRetries int
+	// RetryInterval is the wait before the first retry, it's doubled on every retry
+	RetryInterval time.Duration
+	// QueueSize is the number of the events that waiting to be sent, the new events are dropped when the queue is full
+	QueueSize int
+	// Client is the HTTP client to send the requests, the default client has a 10 seconds timeout
+	Client *http.Client
+	Log    logging.LeveledLogger
+}
+
+func DefaultWebhookOptions() WebhookOptions {
+	return WebhookOptions{
+		MaxRetries:    3,
+		RetryInterval: time.Second,
+		QueueSize:     1024,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+		Log:           logging.NewDefaultLoggerFactory().NewLogger("sfu"),
+	}
+}
+
+// WebhookEvent is the JSON body of the webhook request
+type WebhookEvent struct {
+	ID   string                 `json:"id"`
+	Type string                 `json:"type"`
+	Time time.Time              `json:"time"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// WebhookSink is an EventSink that posts every event as JSON to an HTTP endpoint. The events are sent in order
+// by a single worker, a failed request is retried on the network error, 429 and 5xx responses.
+type WebhookSink struct {
+	url     string
+	opts    WebhookOptions
+	queue   chan WebhookEvent
+	context context.Context
+	cancel  context.CancelFunc
+	mu      sync.RWMutex
+	closed  bool
+	done    chan struct{}
+	onError func(event WebhookEvent, err error)
+}
+
+func NewWebhookSink(url string, opts WebhookOptions) *WebhookSink {
+	defaults := DefaultWebhookOptions()
+
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaults.QueueSize
+	}
+
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = defaults.RetryInterval
+	}
+
+	if opts.Client == nil {
+		opts.Client = defaults.Client
+	}
+
+	if opts.Log == nil {
+		opts.Log = defaults.Log
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sink := &WebhookSink{
+		url:     url,
+		opts:    opts,
+		queue:   make(chan WebhookEvent, opts.QueueSize),
+		context: ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	go sink.loop()
+
+	return sink
+}
+
+// Send queues the event to be sent, it never blocks
+func (w *WebhookSink) Send(event Event) {
+	if err := w.enqueue(event); err != nil {
+		w.opts.Log.Warnf("webhook: drop event %s, %s", event.Type, err.Error())
+	}
+}
+
+func (w *WebhookSink) enqueue(event Event) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrWebhookSinkClosed
+	}
+
+	select {
+	case w.queue <- WebhookEvent{ID: GenerateID(21), Type: event.Type, Time: event.Time, Data: event.Data}:
+		return nil
+	default:
+		return ErrWebhookQueueFull
+	}
+}
+
+// OnError event is called when an event is failed to send after all retries
+func (w *WebhookSink) OnError(callback func(event WebhookEvent, err error)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.onError = callback
+}
+
+// Close stops accepting the events and waits until the queued events are sent, the retries are stopped
+// if the context is done before that.
+func (w *WebhookSink) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrWebhookSinkClosed
+	}
+
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		w.cancel()
+		<-w.done
+	}
+
+	w.cancel()
+
+	return nil
+}
+
+func (w *WebhookSink) loop() {
+	defer close(w.done)
+
+	for event := range w.queue {
+		if err := w.deliver(event); err != nil {
+			w.opts.Log.Errorf("webhook: error send event %s, %s", event.Type, err.Error())
+
+			w.mu.RLock()
+			onError := w.onError
+			w.mu.RUnlock()
+
+			if onError != nil {
+				onError(event, err)
+			}
+		}
+	}
+}
+
+func (w *WebhookSink) deliver(event WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	interval := w.opts.RetryInterval
+
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(event.ID, body)
+		if err == nil || !retry || attempt >= w.opts.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-w.context.Done():
+			return err
+		case <-time.After(interval):
+		}
+
+		interval *= 2
+	}
+}
+
+// post returns true if the request can be retried
+func (w *WebhookSink) post(eventID string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(w.context, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventIDHeader, eventID)
+
+	if w.opts.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(w.opts.Secret, body))
+	}
+
+	resp, err := w.opts.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("webhook: unexpected status %d", resp.StatusCode)
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of the body, use it on the receiver to verify the X-SFU-Signature header
+// with hmac.Equal
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,93 @@
+package sfu
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookSink(t *testing.T) {
+	const secret = "webhook-secret"
+
+	type request struct {
+		eventID string
+		event   WebhookEvent
+	}
+
+	mu := sync.Mutex{}
+	attempts := 0
+	requests := make(chan request, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		if r.Header.Get(WebhookSignatureHeader) != "sha256="+SignWebhook(secret, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		attempts++
+		failed := attempts == 1
+		mu.Unlock()
+
+		// the first attempt is failed and retried
+		if failed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		event := WebhookEvent{}
+		_ = json.Unmarshal(body, &event)
+
+		requests <- request{eventID: r.Header.Get(WebhookEventIDHeader), event: event}
+	}))
+
+	defer server.Close()
+
+	opts := DefaultWebhookOptions()
+	opts.Secret = secret
+	opts.RetryInterval = 10 * time.Millisecond
+
+	sink := NewWebhookSink(server.URL, opts)
+
+	// the room lifecycle events are sent to the sink
+	managerOpts := sfuOpts
+	managerOpts.EventSink = sink
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", managerOpts)
+	defer roomManager.Close()
+
+	testRoom, err := roomManager.NewRoom("webhook-room", "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+	require.NoError(t, testRoom.Close())
+
+	for _, eventType := range []string{EventRoomCreated, EventRoomClosed} {
+		select {
+		case req := <-requests:
+			require.Equal(t, eventType, req.event.Type)
+			require.Equal(t, req.event.ID, req.eventID)
+			require.Equal(t, "webhook-room", req.event.Data["room_id"])
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for the %s webhook", eventType)
+		}
+	}
+
+	mu.Lock()
+	require.Equal(t, 3, attempts)
+	mu.Unlock()
+
+	require.NoError(t, sink.Close(ctx))
+	require.ErrorIs(t, sink.Close(ctx), ErrWebhookSinkClosed)
+	require.ErrorIs(t, sink.enqueue(Event{Type: EventRoomCreated}), ErrWebhookSinkClosed)
+}
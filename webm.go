@@ -0,0 +1,347 @@
+package sfu
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// the Matroska element IDs that read by the WebM source, https://www.matroska.org/technical/elements.html
+const (
+	webmIDSegment       = 0x18538067
+	webmIDInfo          = 0x1549a966
+	webmIDTimecodeScale = 0x2ad7b1
+	webmIDTracks        = 0x1654ae6b
+	webmIDTrackEntry    = 0xae
+	webmIDTrackNumber   = 0xd7
+	webmIDTrackType     = 0x83
+	webmIDCodecID       = 0x86
+	webmIDCluster       = 0x1f43b675
+	webmIDTimecode      = 0xe7
+	webmIDBlockGroup    = 0xa0
+	webmIDBlock         = 0xa1
+	webmIDSimpleBlock   = 0xa3
+
+	webmTrackTypeVideo = 1
+	webmTrackTypeAudio = 2
+
+	// the default TimecodeScale is 1ms
+	webmDefaultTimecodeScale = 1000000
+	// the maximum size of the element that read to the memory, like a block
+	webmMaxElementSize = 16 * 1024 * 1024
+	// the size of the element that has unknown size, like the live WebM from the MediaRecorder
+	webmUnknownSize = -1
+)
+
+var errWebMInvalidElement = errors.New("webm: invalid element")
+
+// the codecs that can be published without transcoding
+var webmCodecs = map[string]string{
+	"V_VP8":  webrtc.MimeTypeVP8,
+	"V_VP9":  webrtc.MimeTypeVP9,
+	"V_AV1":  webrtc.MimeTypeAV1,
+	"A_OPUS": webrtc.MimeTypeOpus,
+}
+
+type webmTrackEntry struct {
+	number    uint64
+	trackType uint64
+	codecID   string
+}
+
+// webmSource reads the frames of a track from the WebM file. The elements are read one by one without keeping the
+// element tree, the master elements that we need are entered and the other elements are skipped.
+type webmSource struct {
+	reader      io.Reader
+	buf         *bufio.Reader
+	kind        webrtc.RTPCodecType
+	track       *webmTrackEntry
+	entry       *webmTrackEntry
+	mimeType    string
+	scale       uint64
+	clusterTime uint64
+}
+
+// NewWebMSource returns the source of the first video or audio track in the WebM file by the kind, it returns
+// ErrMediaSourceNoTrack if the file doesn't have the track with the VP8, VP9, AV1, or Opus codec. The reader must be
+// an io.Seeker to loop the media. The frames with lacing are not supported, they're skipped.
+func NewWebMSource(r io.Reader, kind webrtc.RTPCodecType) (MediaSource, error) {
+	s := &webmSource{reader: r, kind: kind}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// open reads the header elements until the first cluster
+func (s *webmSource) open() error {
+	s.buf = bufio.NewReader(s.reader)
+	s.track = nil
+	s.entry = nil
+	s.scale = webmDefaultTimecodeScale
+	s.clusterTime = 0
+
+	for {
+		id, size, err := s.readElementHeader()
+		if errors.Is(err, io.EOF) {
+			return ErrMediaSourceNoTrack
+		} else if err != nil {
+			return err
+		}
+
+		switch id {
+		case webmIDSegment, webmIDInfo, webmIDTracks:
+		case webmIDTrackEntry:
+			s.selectTrack()
+			s.entry = &webmTrackEntry{}
+		case webmIDTrackNumber, webmIDTrackType, webmIDTimecodeScale:
+			value, err := s.readUint(size)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case id == webmIDTimecodeScale:
+				s.scale = value
+			case s.entry != nil && id == webmIDTrackNumber:
+				s.entry.number = value
+			case s.entry != nil:
+				s.entry.trackType = value
+			}
+		case webmIDCodecID:
+			data, err := s.readData(size)
+			if err != nil {
+				return err
+			}
+
+			if s.entry != nil {
+				s.entry.codecID = string(data)
+			}
+		case webmIDCluster:
+			s.selectTrack()
+
+			if s.track == nil {
+				return ErrMediaSourceNoTrack
+			}
+
+			return nil
+		default:
+			if err := s.skip(size); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// selectTrack selects the last read track entry if it's the first supported track of the kind
+func (s *webmSource) selectTrack() {
+	entry := s.entry
+	s.entry = nil
+
+	if entry == nil || s.track != nil {
+		return
+	}
+
+	trackType := uint64(webmTrackTypeAudio)
+	if s.kind == webrtc.RTPCodecTypeVideo {
+		trackType = webmTrackTypeVideo
+	}
+
+	if mimeType, ok := webmCodecs[entry.codecID]; ok && entry.trackType == trackType {
+		s.track = entry
+		s.mimeType = mimeType
+	}
+}
+
+func (s *webmSource) MimeType() string {
+	return s.mimeType
+}
+
+func (s *webmSource) ReadFrame() (MediaFrame, error) {
+	for {
+		id, size, err := s.readElementHeader()
+		if err != nil {
+			return MediaFrame{}, err
+		}
+
+		switch id {
+		case webmIDCluster, webmIDBlockGroup:
+		case webmIDTimecode:
+			if s.clusterTime, err = s.readUint(size); err != nil {
+				return MediaFrame{}, err
+			}
+		case webmIDSimpleBlock, webmIDBlock:
+			data, err := s.readData(size)
+			if err != nil {
+				return MediaFrame{}, err
+			}
+
+			if frame, ok := s.parseBlock(data); ok {
+				return frame, nil
+			}
+		default:
+			if err := s.skip(size); err != nil {
+				return MediaFrame{}, err
+			}
+		}
+	}
+}
+
+// parseBlock returns the frame if the block is of the selected track, RFC 9559 section 10
+func (s *webmSource) parseBlock(data []byte) (MediaFrame, bool) {
+	number, n := webmVint(data, false)
+	if n == 0 || len(data) < n+3 || number != s.track.number {
+		return MediaFrame{}, false
+	}
+
+	relative := int16(binary.BigEndian.Uint16(data[n : n+2]))
+	flags := data[n+2]
+
+	// the lacing is not supported
+	if flags&0x06 != 0 {
+		return MediaFrame{}, false
+	}
+
+	timecode := int64(s.clusterTime) + int64(relative)
+	if timecode < 0 {
+		timecode = 0
+	}
+
+	return MediaFrame{
+		Data:      data[n+3:],
+		Timestamp: time.Duration(uint64(timecode) * s.scale),
+	}, true
+}
+
+func (s *webmSource) Rewind() error {
+	if err := rewindReader(s.reader); err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+// webmVint returns the variable size integer and its length, the length is 0 if it's invalid
+func webmVint(data []byte, keepMarker bool) (uint64, int) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0
+	}
+
+	length := bits.LeadingZeros8(data[0]) + 1
+	if len(data) < length {
+		return 0, 0
+	}
+
+	value := uint64(data[0])
+	if !keepMarker {
+		value &= uint64(0xff >> length)
+	}
+
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+	}
+
+	return value, length
+}
+
+// readElementHeader returns the element ID and size, the size is webmUnknownSize if it's unknown
+func (s *webmSource) readElementHeader() (uint64, int64, error) {
+	id, err := s.readVint(true)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sizeLength, err := s.vintLength()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size, err := s.readVint(false)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// all value bits are 1
+	if size == uint64(1)<<(7*sizeLength)-1 {
+		return id, webmUnknownSize, nil
+	}
+
+	return id, int64(size), nil
+}
+
+func (s *webmSource) vintLength() (int, error) {
+	b, err := s.buf.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+
+	if b[0] == 0 {
+		return 0, errWebMInvalidElement
+	}
+
+	return bits.LeadingZeros8(b[0]) + 1, nil
+}
+
+func (s *webmSource) readVint(keepMarker bool) (uint64, error) {
+	length, err := s.vintLength()
+	if err != nil {
+		return 0, err
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(s.buf, data); err != nil {
+		return 0, err
+	}
+
+	value, _ := webmVint(data, keepMarker)
+
+	return value, nil
+}
+
+func (s *webmSource) readData(size int64) ([]byte, error) {
+	if size < 0 || size > webmMaxElementSize {
+		return nil, errWebMInvalidElement
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(s.buf, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *webmSource) readUint(size int64) (uint64, error) {
+	if size > 8 {
+		return 0, errWebMInvalidElement
+	}
+
+	data, err := s.readData(size)
+	if err != nil {
+		return 0, err
+	}
+
+	value := uint64(0)
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+
+	return value, nil
+}
+
+func (s *webmSource) skip(size int64) error {
+	if size < 0 {
+		return errWebMInvalidElement
+	}
+
+	_, err := s.buf.Discard(int(size))
+
+	return err
+}
@@ -0,0 +1,176 @@
+package sfu
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+)
+
+var (
+	ErrWHEPInvalidPath         = errors.New("whep: invalid endpoint path")
+	ErrWHEPParticipantNotFound = errors.New("whep: participant not found")
+	ErrWHEPNoTracks            = errors.New("whep: no tracks available to play")
+)
+
+type WHEPOptions struct {
+	// Path is the prefix of the endpoint, the offer is posted to {Path}/{roomID}
+	// and the session is deleted through {Path}/{roomID}/{clientID}
+	Path string `json:"path"`
+	// ClientOptions used to create the subscriber client, the ICE trickle is always disabled
+	// because WHEP only exchange a single offer and answer
+	ClientOptions ClientOptions `json:"client_options"`
+	// IceServers advertised to the player through the Link header, the room manager ICE servers are used if empty
+	IceServers []webrtc.ICEServer `json:"ice_servers"`
+	// Authorize is called before a new session is created, return an error to reject the request
+	Authorize func(r *http.Request, roomID string) error `json:"-"`
+}
+
+func DefaultWHEPOptions() WHEPOptions {
+	return WHEPOptions{
+		Path:          "/whep",
+		ClientOptions: DefaultClientOptions(),
+	}
+}
+
+// WHEPHandler is a http.Handler that implements WebRTC-HTTP egress protocol (WHEP)
+// https://datatracker.ietf.org/doc/draft-ietf-wish-whep/
+// The player posts the offer to {Path}/{roomID} to play the tracks in the room, or {Path}/{roomID}?client={clientID}
+// to only play the tracks from a specific participant. The tracks are added to the transceivers that offered by the player,
+// so the player needs to offer a recvonly transceiver for each track that it wants to play.
+type WHEPHandler struct {
+	manager *Manager
+	options WHEPOptions
+	log     logging.LeveledLogger
+}
+
+func NewWHEPHandler(manager *Manager, opts WHEPOptions) *WHEPHandler {
+	opts.Path = "/" + strings.Trim(opts.Path, "/")
+	opts.ClientOptions.IceTrickle = false
+	opts.ClientOptions.reuseTransceivers = true
+
+	if len(opts.IceServers) == 0 {
+		opts.IceServers = manager.iceServers
+	}
+
+	return &WHEPHandler{
+		manager: manager,
+		options: opts,
+		log:     manager.log,
+	}
+}
+
+func (h *WHEPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	roomID, clientID, err := parseSessionPath(h.options.Path, r.URL.Path)
+	if err != nil {
+		http.Error(w, ErrWHEPInvalidPath.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodOptions:
+		writeICEServersLink(w, h.options.IceServers)
+		w.Header().Set("Accept-Post", whipContentType)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && clientID == "":
+		h.play(w, r, roomID)
+	case r.Method == http.MethodDelete && clientID != "":
+		stopSession(w, h.manager, roomID, clientID)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *WHEPHandler) play(w http.ResponseWriter, r *http.Request, roomID string) {
+	if h.options.Authorize != nil {
+		if err := h.options.Authorize(r, roomID); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	room, err := h.manager.GetRoom(roomID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	participantID := r.URL.Query().Get("client")
+	if participantID != "" {
+		if _, err := room.SFU().GetClient(participantID); err != nil {
+			http.Error(w, ErrWHEPParticipantNotFound.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	offer, err := readSDPOffer(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clientID := room.CreateClientID()
+
+	client, err := room.AddClient(clientID, clientID, h.options.ClientOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// WHEP player is not able to receive a renegotiation, so the tracks must be added before the answer is created
+	answer, err := client.negotiate(r.Context(), *offer, func() error {
+		req := h.selectTracks(room, client, participantID)
+		if len(req) == 0 {
+			return ErrWHEPNoTracks
+		}
+
+		return client.subscribeTracks(req)
+	})
+	if err != nil {
+		h.log.Errorf("whep: error negotiate client %s: %s", clientID, err.Error())
+		_ = room.StopClient(clientID)
+
+		if errors.Is(err, ErrWHEPNoTracks) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+
+		return
+	}
+
+	writeSDPAnswer(w, h.options.IceServers, h.options.Path+"/"+roomID+"/"+clientID, answer)
+}
+
+// selectTracks returns the tracks to subscribe, limited by the number of the transceivers offered by the player
+func (h *WHEPHandler) selectTracks(room *Room, client *Client, participantID string) []SubscribeTrackRequest {
+	available := map[webrtc.RTPCodecType]int{
+		webrtc.RTPCodecTypeAudio: len(client.availableTransceivers(webrtc.RTPCodecTypeAudio)),
+		webrtc.RTPCodecTypeVideo: len(client.availableTransceivers(webrtc.RTPCodecTypeVideo)),
+	}
+
+	req := make([]SubscribeTrackRequest, 0)
+
+	for _, peer := range room.SFU().clients.GetClients() {
+		if peer.ID() == client.ID() || (participantID != "" && peer.ID() != participantID) {
+			continue
+		}
+
+		for _, track := range peer.tracks.GetTracks() {
+			if available[track.Kind()] == 0 {
+				continue
+			}
+
+			available[track.Kind()]--
+
+			req = append(req, SubscribeTrackRequest{
+				ClientID: peer.ID(),
+				TrackID:  track.ID(),
+			})
+		}
+	}
+
+	return req
+}
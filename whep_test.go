@@ -0,0 +1,116 @@
+package sfu
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWHEPPlay(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomID := roomManager.CreateRoomID()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	testRoom, err := roomManager.NewRoom(roomID, "test-whep-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	tracksReady := make(chan bool, 1)
+	testRoom.SFU().OnClientAdded(func(client *Client) {
+		client.OnTracksReady(func(tracks []ITrack) {
+			tracksReady <- true
+		})
+	})
+
+	_, publisher, _, _ := CreatePeerPair(ctx, TestLogger, testRoom, DefaultTestIceServers(), "publisher", true, false, true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 20*time.Second)
+	defer cancelTimeout()
+
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for published tracks")
+	case <-tracksReady:
+	}
+
+	server := httptest.NewServer(NewWHEPHandler(roomManager, DefaultWHEPOptions()))
+	defer server.Close()
+
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(GetMediaEngine()), webrtc.WithSettingEngine(*sfuOpts.SettingEngine)).NewPeerConnection(webrtc.Configuration{
+		ICEServers: DefaultTestIceServers(),
+	})
+	require.NoError(t, err)
+
+	defer pc.Close()
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		_, err = pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+		require.NoError(t, err)
+	}
+
+	trackChan := make(chan *webrtc.TrackRemote, 2)
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		trackChan <- track
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	require.NoError(t, pc.SetLocalDescription(offer))
+	<-gatherComplete
+
+	// unknown participant
+	resp, err := http.Post(server.URL+"/whep/"+roomID+"?client=unknown", whipContentType, strings.NewReader(pc.LocalDescription().SDP))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Post(server.URL+"/whep/"+roomID+"?client="+publisher.ID(), whipContentType, strings.NewReader(pc.LocalDescription().SDP))
+	require.NoError(t, err)
+
+	answer, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	location := resp.Header.Get("Location")
+	require.True(t, strings.HasPrefix(location, "/whep/"+roomID+"/"))
+
+	require.NoError(t, pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(answer)}))
+
+	received := 0
+	for received < 2 {
+		select {
+		case <-timeout.Done():
+			t.Fatalf("timeout waiting for tracks, received %d", received)
+		case <-trackChan:
+			received++
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, server.URL+location, nil)
+	require.NoError(t, err)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_ = testRoom.StopClient(publisher.ID())
+}
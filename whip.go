@@ -0,0 +1,229 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	whipContentType = "application/sdp"
+
+	// limit the size of the SDP offer that we read from the request body
+	maxSDPSize = 1 << 20
+)
+
+var (
+	ErrWHIPInvalidPath   = errors.New("whip: invalid endpoint path")
+	ErrWHIPInvalidOffer  = errors.New("whip: invalid sdp offer")
+	ErrWHIPNotPublishing = errors.New("whip: offer doesn't contain any media to publish")
+)
+
+type WHIPOptions struct {
+	// Path is the prefix of the endpoint, the offer is posted to {Path}/{roomID}
+	// and the session is deleted through {Path}/{roomID}/{clientID}
+	Path string `json:"path"`
+	// ClientOptions used to create the publisher client, the ICE trickle is always disabled
+	// because WHIP only exchange a single offer and answer
+	ClientOptions ClientOptions `json:"client_options"`
+	// Authorize is called before a new session is created, return an error to reject the request
+	Authorize func(r *http.Request, roomID string) error `json:"-"`
+}
+
+func DefaultWHIPOptions() WHIPOptions {
+	return WHIPOptions{
+		Path:          "/whip",
+		ClientOptions: DefaultClientOptions(),
+	}
+}
+
+// WHIPHandler is a http.Handler that implements WebRTC-HTTP ingestion protocol (WHIP)
+// https://datatracker.ietf.org/doc/html/rfc9725
+// It allows WHIP encoders like OBS to publish the media to a room without a custom signaling.
+type WHIPHandler struct {
+	manager *Manager
+	options WHIPOptions
+	log     logging.LeveledLogger
+}
+
+func NewWHIPHandler(manager *Manager, opts WHIPOptions) *WHIPHandler {
+	opts.Path = "/" + strings.Trim(opts.Path, "/")
+	opts.ClientOptions.IceTrickle = false
+
+	return &WHIPHandler{
+		manager: manager,
+		options: opts,
+		log:     manager.log,
+	}
+}
+
+func (h *WHIPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	roomID, clientID, err := parseSessionPath(h.options.Path, r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodOptions:
+		writeICEServersLink(w, h.manager.iceServers)
+		w.Header().Set("Accept-Post", whipContentType)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && clientID == "":
+		h.publish(w, r, roomID)
+	case r.Method == http.MethodDelete && clientID != "":
+		stopSession(w, h.manager, roomID, clientID)
+	case r.Method == http.MethodPatch && clientID != "":
+		// trickle ICE and ICE restart are not supported since the answer already contains all candidates
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *WHIPHandler) publish(w http.ResponseWriter, r *http.Request, roomID string) {
+	if h.options.Authorize != nil {
+		if err := h.options.Authorize(r, roomID); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	room, err := h.manager.GetRoom(roomID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	offer, err := readSDPOffer(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !strings.Contains(offer.SDP, "m=audio") && !strings.Contains(offer.SDP, "m=video") {
+		http.Error(w, ErrWHIPNotPublishing.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clientID := room.CreateClientID()
+
+	client, err := room.AddClient(clientID, clientID, h.options.ClientOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// WHIP publisher is not able to tell the source of the tracks, publish all of them as media
+	client.OnTracksAdded(func(addedTracks []ITrack) {
+		setTracks := make(map[string]TrackType, 0)
+		for _, track := range addedTracks {
+			setTracks[track.ID()] = TrackTypeMedia
+		}
+
+		client.SetTracksSourceType(setTracks)
+	})
+
+	answer, err := client.NegotiateContext(r.Context(), *offer)
+	if err != nil {
+		h.log.Errorf("whip: error negotiate client %s: %s", clientID, err.Error())
+		_ = room.StopClient(clientID)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	writeSDPAnswer(w, h.manager.iceServers, h.options.Path+"/"+roomID+"/"+clientID, answer)
+}
+
+// parseSessionPath returns the room ID and the optional client ID from {prefix}/{roomID}/{clientID}
+func parseSessionPath(prefix, path string) (roomID, clientID string, err error) {
+	if !strings.HasPrefix(path, prefix+"/") {
+		return "", "", ErrWHIPInvalidPath
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, prefix), "/"), "/")
+
+	switch len(parts) {
+	case 1:
+		roomID = parts[0]
+	case 2:
+		roomID, clientID = parts[0], parts[1]
+	default:
+		return "", "", ErrWHIPInvalidPath
+	}
+
+	if roomID == "" {
+		return "", "", ErrWHIPInvalidPath
+	}
+
+	return roomID, clientID, nil
+}
+
+func readSDPOffer(r *http.Request) (*webrtc.SessionDescription, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), whipContentType) {
+		return nil, ErrWHIPInvalidOffer
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSDPSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) == 0 {
+		return nil, ErrWHIPInvalidOffer
+	}
+
+	return &webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  string(body),
+	}, nil
+}
+
+func writeSDPAnswer(w http.ResponseWriter, iceServers []webrtc.ICEServer, location string, answer *webrtc.SessionDescription) {
+	writeICEServersLink(w, iceServers)
+	w.Header().Set("Content-Type", whipContentType)
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusCreated)
+
+	_, _ = w.Write([]byte(answer.SDP))
+}
+
+// writeICEServersLink advertise the ICE servers through the Link header
+func writeICEServersLink(w http.ResponseWriter, iceServers []webrtc.ICEServer) {
+	for _, server := range iceServers {
+		for _, url := range server.URLs {
+			link := fmt.Sprintf("<%s>; rel=\"ice-server\"", url)
+
+			if server.Username != "" {
+				link += fmt.Sprintf("; username=\"%s\"", server.Username)
+			}
+
+			if credential, ok := server.Credential.(string); ok && credential != "" {
+				link += fmt.Sprintf("; credential=\"%s\"; credential-type=\"password\"", credential)
+			}
+
+			w.Header().Add("Link", link)
+		}
+	}
+}
+
+func stopSession(w http.ResponseWriter, manager *Manager, roomID, clientID string) {
+	room, err := manager.GetRoom(roomID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := room.StopClient(clientID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
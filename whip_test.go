@@ -0,0 +1,116 @@
+package sfu
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWHIPPublish(t *testing.T) {
+	report := CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roomManager := NewManager(ctx, "test", sfuOpts)
+	defer roomManager.Close()
+
+	roomID := roomManager.CreateRoomID()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = &[]string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	testRoom, err := roomManager.NewRoom(roomID, "test-whip-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	tracksAvailable := make(chan int, 1)
+	testRoom.SFU().OnClientAdded(func(client *Client) {
+		client.OnTracksReady(func(tracks []ITrack) {
+			tracksAvailable <- len(tracks)
+		})
+	})
+
+	server := httptest.NewServer(NewWHIPHandler(roomManager, DefaultWHIPOptions()))
+	defer server.Close()
+
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(GetMediaEngine()), webrtc.WithSettingEngine(*sfuOpts.SettingEngine)).NewPeerConnection(webrtc.Configuration{
+		ICEServers: DefaultTestIceServers(),
+	})
+	require.NoError(t, err)
+
+	defer pc.Close()
+
+	iceConnectedCtx, iceConnectedCancel := context.WithCancel(ctx)
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		if state == webrtc.ICEConnectionStateConnected {
+			iceConnectedCancel()
+		}
+	})
+
+	tracks, _ := GetStaticTracks(ctx, iceConnectedCtx, "whip", true)
+	SetPeerConnectionTracks(ctx, pc, tracks)
+
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	require.NoError(t, pc.SetLocalDescription(offer))
+	<-gatherComplete
+
+	// invalid content type should be rejected
+	resp, err := http.Post(server.URL+"/whip/"+roomID, "text/plain", strings.NewReader(pc.LocalDescription().SDP))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// unknown room
+	resp, err = http.Post(server.URL+"/whip/unknown", whipContentType, strings.NewReader(pc.LocalDescription().SDP))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Post(server.URL+"/whip/"+roomID, whipContentType, strings.NewReader(pc.LocalDescription().SDP))
+	require.NoError(t, err)
+
+	answer, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, whipContentType, resp.Header.Get("Content-Type"))
+	require.NotEmpty(t, resp.Header.Values("Link"))
+
+	location := resp.Header.Get("Location")
+	require.True(t, strings.HasPrefix(location, "/whip/"+roomID+"/"))
+
+	require.NoError(t, pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(answer)}))
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 20*time.Second)
+	defer cancelTimeout()
+
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for published tracks")
+	case count := <-tracksAvailable:
+		require.Equal(t, 2, count)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, server.URL+location, nil)
+	require.NoError(t, err)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,265 @@
+package sfu
+
+import (
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/transport/v3"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	defaultWriteBatchSize     = 64
+	defaultWriteBatchInterval = time.Millisecond
+	writeBatchBufferSize      = 1500
+)
+
+// WriteBatchOptions batches the UDP writes of the ICE mux, the packets that written to the same socket are queued and
+// written with a single sendmmsg syscall. The batch is shared by all peer connections on the socket, so the number of
+// the syscalls doesn't grow with the number of the subscribers. The batch writes are only supported on Linux, the
+// packets are written one by one on the other platforms.
+//
+// Only the UDP mux sockets of ICEMux are batched. The peer connections without the UDP mux, the ICE-TCP, and the TURN
+// relay connections write their packets one by one. A packet waits up to Interval in a batch that's not full, so the
+// batching adds up to Interval of latency to every packet when the traffic is too low to fill the batches.
+type WriteBatchOptions struct {
+	Enabled bool
+	// Size is the max number of the packets in a batch, a full batch is written right away. Default is 64
+	Size int
+	// Interval is the max time that a packet waits in a batch that not full yet. Default is 1ms
+	Interval time.Duration
+}
+
+// WriteBatchStats is the batch write stats of a UDP socket
+type WriteBatchStats struct {
+	LocalAddr string `json:"local_addr"`
+	// Batching is false when the socket doesn't support the batch writes, the packets are written one by one
+	Batching bool `json:"batching"`
+	// Packets is the number of the packets that written to the socket
+	Packets uint64 `json:"packets"`
+	// Batches is the number of the sendmmsg syscalls, the average batch size is Packets / Batches
+	Batches uint64 `json:"batches"`
+	// FullBatches is the number of the batches that written because they're full, the other batches are written by
+	// the interval
+	FullBatches uint64 `json:"full_batches"`
+	// Errors is the number of the packets that failed to write, including the packets of the batches that written by the
+	// interval
+	Errors uint64 `json:"errors"`
+}
+
+// batchWriter is implemented by ipv4.PacketConn and ipv6.PacketConn
+type batchWriter interface {
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// batchNet wraps the UDP sockets that listened by the ICE mux with batchConn
+type batchNet struct {
+	transport.Net
+	opts  WriteBatchOptions
+	log   logging.LeveledLogger
+	mu    sync.Mutex
+	conns []*batchConn
+}
+
+func newBatchNet(n transport.Net, opts WriteBatchOptions, log logging.LeveledLogger) *batchNet {
+	return &batchNet{Net: n, opts: opts, log: log}
+}
+
+func (n *batchNet) ListenUDP(network string, addr *net.UDPAddr) (transport.UDPConn, error) {
+	conn, err := n.Net.ListenUDP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := newBatchConn(conn, n.opts, n.log)
+
+	n.mu.Lock()
+	n.conns = append(n.conns, batch)
+	n.mu.Unlock()
+
+	return batch, nil
+}
+
+func (n *batchNet) stats() []WriteBatchStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	stats := make([]WriteBatchStats, 0, len(n.conns))
+	for _, conn := range n.conns {
+		stats = append(stats, conn.stats())
+	}
+
+	return stats
+}
+
+// batchConn queues the packets of WriteTo and writes them in batches, a batch is written when it's full or when the
+// interval is passed. The other writes are passed to the socket.
+type batchConn struct {
+	transport.UDPConn
+	writer      batchWriter
+	mu          sync.Mutex
+	messages    []ipv4.Message
+	pending     int
+	done        chan struct{}
+	closeOnce   sync.Once
+	packets     atomic.Uint64
+	batches     atomic.Uint64
+	fullBatches atomic.Uint64
+	errors      atomic.Uint64
+	// the errors of the batches that written by the interval or on close have no caller to return to
+	log logging.LeveledLogger
+}
+
+func newBatchConn(conn transport.UDPConn, opts WriteBatchOptions, log logging.LeveledLogger) *batchConn {
+	if opts.Size <= 0 {
+		opts.Size = defaultWriteBatchSize
+	}
+
+	if opts.Interval <= 0 {
+		opts.Interval = defaultWriteBatchInterval
+	}
+
+	c := &batchConn{
+		UDPConn: conn,
+		done:    make(chan struct{}),
+		log:     newHotPathLogger(log),
+	}
+
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok || runtime.GOOS != "linux" {
+		return c
+	}
+
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		c.writer = ipv6.NewPacketConn(udpConn)
+	} else {
+		c.writer = ipv4.NewPacketConn(udpConn)
+	}
+
+	c.messages = make([]ipv4.Message, opts.Size)
+	for i := range c.messages {
+		c.messages[i].Buffers = [][]byte{make([]byte, 0, writeBatchBufferSize)}
+	}
+
+	go c.loop(opts.Interval)
+
+	return c
+}
+
+func (c *batchConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.writer == nil {
+		n, err := c.UDPConn.WriteTo(b, addr)
+		if err != nil {
+			c.errors.Add(1)
+		} else {
+			c.packets.Add(1)
+		}
+
+		return n, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the buffer of the caller is reused once it returns
+	msg := &c.messages[c.pending]
+	msg.Buffers[0] = append(msg.Buffers[0][:0], b...)
+	msg.Addr = addr
+	c.pending++
+
+	if c.pending < len(c.messages) {
+		return len(b), nil
+	}
+
+	c.fullBatches.Add(1)
+
+	return len(b), c.flush()
+}
+
+// flush writes the pending packets, the caller must hold the lock
+func (c *batchConn) flush() error {
+	var err error
+
+	sent := 0
+	for sent < c.pending {
+		n, writeErr := c.writer.WriteBatch(c.messages[sent:c.pending], 0)
+		if writeErr != nil || n == 0 {
+			err = writeErr
+			break
+		}
+
+		c.batches.Add(1)
+		sent += n
+	}
+
+	c.packets.Add(uint64(sent))
+	c.errors.Add(uint64(c.pending - sent))
+
+	for i := 0; i < c.pending; i++ {
+		c.messages[i].Addr = nil
+	}
+
+	c.pending = 0
+
+	return err
+}
+
+// flushPending writes the pending packets without a caller, the failed packets are counted in the stats and the error
+// is logged. The caller must hold the lock
+func (c *batchConn) flushPending() {
+	pending := c.pending
+
+	if err := c.flush(); err != nil {
+		c.log.Warnf("writebatch: error write %d packets on %s: %s", pending, c.LocalAddr(), err.Error())
+	}
+}
+
+func (c *batchConn) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			if c.pending > 0 {
+				c.flushPending()
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *batchConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+
+		if c.writer != nil {
+			c.mu.Lock()
+			if c.pending > 0 {
+				c.flushPending()
+			}
+			c.mu.Unlock()
+		}
+	})
+
+	return c.UDPConn.Close()
+}
+
+func (c *batchConn) stats() WriteBatchStats {
+	return WriteBatchStats{
+		LocalAddr:   c.LocalAddr().String(),
+		Batching:    c.writer != nil,
+		Packets:     c.packets.Load(),
+		Batches:     c.batches.Load(),
+		FullBatches: c.fullBatches.Load(),
+		Errors:      c.errors.Load(),
+	}
+}
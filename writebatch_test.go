@@ -0,0 +1,79 @@
+package sfu
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchConn(t *testing.T) {
+	receiver, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	defer receiver.Close()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	batch := newBatchConn(conn, WriteBatchOptions{Enabled: true, Size: 4, Interval: 5 * time.Millisecond}, TestLogger)
+	defer batch.Close()
+
+	buf := make([]byte, 1)
+	for i := 0; i < 10; i++ {
+		buf[0] = byte(i)
+		_, err := batch.WriteTo(buf, receiver.LocalAddr())
+		require.NoError(t, err)
+	}
+
+	// the packets are received in order, the last 2 packets are written by the interval
+	require.NoError(t, receiver.SetReadDeadline(time.Now().Add(time.Second)))
+
+	for i := 0; i < 10; i++ {
+		n, _, err := receiver.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+		require.Equal(t, byte(i), buf[0])
+	}
+
+	// the packets are counted after the syscall returns
+	require.Eventually(t, func() bool {
+		return batch.stats().Packets == 10
+	}, time.Second, time.Millisecond)
+
+	stats := batch.stats()
+	require.Equal(t, uint64(0), stats.Errors)
+
+	if runtime.GOOS == "linux" {
+		require.True(t, stats.Batching)
+		require.Equal(t, uint64(2), stats.FullBatches)
+		require.GreaterOrEqual(t, stats.Batches, uint64(3))
+		require.Less(t, stats.Batches, uint64(10))
+	}
+}
+
+func TestBatchConnIntervalError(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the batch writes are only supported on Linux")
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	batch := newBatchConn(conn, WriteBatchOptions{Enabled: true, Size: 4, Interval: 5 * time.Millisecond}, TestLogger)
+	defer batch.Close()
+
+	_, err = batch.WriteTo([]byte{1}, conn.LocalAddr())
+	require.NoError(t, err)
+
+	// the socket is closed before the interval, the packet of the batch fails to write
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool {
+		return batch.stats().Errors == 1
+	}, time.Second, time.Millisecond)
+
+	require.Equal(t, uint64(0), batch.stats().Packets)
+}